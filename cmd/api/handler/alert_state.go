@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"aquawatch/internal"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AlertHandler returns an alert with its imagery and timeline: creation,
+// state changes, comments and attached images, oldest first.
+// GET /alerts/{id} -> AlertTrackerItem plus {"images":[...],"timeline":[{"seq":...,"kind":"acknowledged","actor":"...","at_ms":...}]}
+func AlertHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	createdOn, err := internal.ParseAlertID(r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid alert id"})
+		return
+	}
+	item, err := internal.GetAlert(r.Context(), createdOn)
+	if errors.Is(err, internal.ErrAlertNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "alert not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("get alert failed: %v", err)
+		writeError(w, err, "failed to load alert")
+		return
+	}
+	timeline, err := internal.AlertTimeline(r.Context(), *item)
+	if err != nil {
+		log.Printf("load alert timeline failed: %v", err)
+		writeError(w, err, "failed to load alert timeline")
+		return
+	}
+	item.Images = internal.LinkAlertImages(r.Context(), item.Images, alertImageURLExpiry)
+	writeJSON(w, http.StatusOK, struct {
+		*internal.AlertTrackerItem
+		Timeline []internal.AlertTimelineEntry `json:"timeline"`
+	}{item, timeline})
+}
+
+// AlertCommentsHandler adds a comment to an alert's timeline so teams can
+// coordinate on it.
+// POST /alerts/{id}/comments {"text":"Crew dispatched to the gauge"} -> 201 {"seq":...,"kind":"commented","actor":"...","comment":"...","at_ms":...}
+func AlertCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	createdOn, err := internal.ParseAlertID(r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid alert id"})
+		return
+	}
+	var req struct {
+		Text string `json:"text"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var actor string
+	if p := PrincipalFrom(r.Context()); p != nil {
+		actor = p.Actor
+	}
+	entry, err := internal.AddAlertComment(r.Context(), createdOn, actor, req.Text)
+	resource := r.PathValue("id")
+	switch {
+	case errors.Is(err, internal.ErrInvalidAlertComment):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, internal.ErrAlertNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "alert not found"})
+	case err != nil:
+		recordAudit(r, internal.AuditActionAlertComment, resource, internal.AuditResultFailure, "")
+		log.Printf("add alert comment failed: %v", err)
+		writeError(w, err, "failed to add comment")
+	default:
+		recordAudit(r, internal.AuditActionAlertComment, resource, internal.AuditResultSuccess, "")
+		writeJSON(w, http.StatusCreated, entry)
+	}
+}
+
+// UpdateAlertStateHandler acknowledges or resolves an alert. The caller must
+// send the version it last read; a stale version yields 409 so concurrent
+// updates from the API and lambdas are never silently lost.
+// POST /alerts/{id}/state {"state":"acknowledged","version":0} -> updated alert
+func UpdateAlertStateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	createdOn, err := internal.ParseAlertID(r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid alert id"})
+		return
+	}
+	var req struct {
+		State   string `json:"state"`
+		Version *int64 `json:"version"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Version == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "state and version required"})
+		return
+	}
+	state := strings.ToLower(strings.TrimSpace(req.State))
+
+	var actor string
+	if p := PrincipalFrom(r.Context()); p != nil {
+		actor = p.Actor
+	}
+	item, err := internal.UpdateAlertState(r.Context(), createdOn, state, *req.Version, actor)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, item)
+	case errors.Is(err, internal.ErrAlertNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "alert not found"})
+	case errors.Is(err, internal.ErrVersionConflict):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "alert was modified; reload and retry"})
+	case errors.Is(err, internal.ErrInvalidAlertState):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		log.Printf("update alert state failed: %v", err)
+		writeError(w, err, "failed to update alert")
+	}
+}
+
+// AlertCAPHandler renders an alert as a CAP 1.2 message for
+// emergency-management systems; resolved alerts render as an update that
+// references the original.
+// GET /alerts/{id}/cap -> application/cap+xml
+func AlertCAPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	createdOn, err := internal.ParseAlertID(r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid alert id"})
+		return
+	}
+	item, err := internal.GetAlert(r.Context(), createdOn)
+	if errors.Is(err, internal.ErrAlertNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "alert not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("get alert failed: %v", err)
+		writeError(w, err, "failed to load alert")
+		return
+	}
+	body, err := internal.RenderCAP(*item)
+	if err != nil {
+		log.Printf("render CAP failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to render alert"})
+		return
+	}
+	w.Header().Set("Content-Type", internal.CAPContentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// alertImageURLExpiry is how long the presigned links of uploaded alert
+// images stay valid.
+const alertImageURLExpiry = time.Hour
+
+// AlertImagesHandler lists or attaches imagery for visual confirmation of
+// an alert: an image uploaded via /uploads/presign, or a USGS/NWS webcam
+// frame. Uploads are listed with presigned links valid for an hour.
+// GET /alerts/{id}/images -> {"alert_id":"alert-...","images":[{"image_id":"img_...","source":"upload","key":"uploads/images/...","url":"...","caption":"..."}]}
+// POST /alerts/{id}/images {"key":"uploads/images/..."} or {"url":"https://...","caption":"Gauge at 09:00","site":"03339000"} -> 201 AlertImage
+func AlertImagesHandler(w http.ResponseWriter, r *http.Request) {
+	createdOn, err := internal.ParseAlertID(r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid alert id"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		item, err := internal.GetAlert(r.Context(), createdOn)
+		if errors.Is(err, internal.ErrAlertNotFound) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "alert not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("get alert failed: %v", err)
+			writeError(w, err, "failed to load alert")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"alert_id": item.AlertID,
+			"images":   internal.LinkAlertImages(r.Context(), item.Images, alertImageURLExpiry),
+		})
+	case http.MethodPost:
+		var spec internal.AlertImageSpec
+		if !decodeJSON(w, r, &spec) {
+			return
+		}
+		var actor string
+		if p := PrincipalFrom(r.Context()); p != nil {
+			actor = p.Actor
+		}
+		images, err := internal.NewAlertImages(r.Context(), []internal.AlertImageSpec{spec}, actor)
+		if err == nil {
+			_, err = internal.AttachAlertImage(r.Context(), createdOn, images[0])
+		}
+		resource := r.PathValue("id")
+		switch {
+		case errors.Is(err, internal.ErrInvalidAlertImage):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		case errors.Is(err, internal.ErrAlertNotFound):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "alert not found"})
+		case err != nil:
+			recordAudit(r, internal.AuditActionAlertImage, resource, internal.AuditResultFailure, "")
+			log.Printf("attach alert image failed: %v", err)
+			writeError(w, err, "failed to attach image")
+		default:
+			recordAudit(r, internal.AuditActionAlertImage, resource, internal.AuditResultSuccess, "")
+			writeJSON(w, http.StatusCreated, internal.LinkAlertImages(r.Context(), images, alertImageURLExpiry)[0])
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"aquawatch/internal"
+)
+
+// AlertSubscriberStatusHandler reports whether an email's alert subscription
+// is confirmed, refreshed from SNS.
+// GET /alerts/subscribe/status?email=you@example.com ->
+// {"email":"you@example.com","status":"pending|confirmed|expired|unsubscribed","requested_on_ms":...,"sent_on_ms":...,"resends":0,"confirmed_on_ms":...}
+func AlertSubscriberStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	email := strings.TrimSpace(r.URL.Query().Get("email"))
+	if !emailPattern.MatchString(email) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid email"})
+		return
+	}
+	sub, err := internal.GetAlertSubscriber(r.Context(), email)
+	switch {
+	case errors.Is(err, internal.ErrSubscriberNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not subscribed"})
+	case err != nil:
+		log.Printf("alert subscriber status failed: %v", err)
+		writeError(w, err, "failed to load subscription")
+	default:
+		writeJSON(w, http.StatusOK, sub)
+	}
+}
+
+// ResendAlertConfirmationHandler mails the confirmation link of a pending or
+// expired alert subscription again: 409 once confirmed, 429 with Retry-After
+// when asked too soon or too often.
+// POST {"email":"you@example.com"} -> the subscriber, as from /alerts/subscribe/status
+func ResendAlertConfirmationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	var req struct {
+		Email string `json:"email"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if !emailPattern.MatchString(email) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid email"})
+		return
+	}
+	sub, err := internal.ResendAlertConfirmation(r.Context(), email)
+	var rl *internal.RateLimitError
+	switch {
+	case errors.Is(err, internal.ErrSubscriberNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not subscribed"})
+	case errors.Is(err, internal.ErrAlreadySubscribed):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "email already subscribed"})
+	case errors.As(err, &rl):
+		recordAudit(r, internal.AuditActionSubscribeResend, email, internal.AuditResultDenied, "")
+		w.Header().Set("Retry-After", strconv.Itoa(int(rl.RetryAfter.Round(time.Second).Seconds())))
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "too many requests", "scope": rl.Scope})
+	case err != nil:
+		recordAudit(r, internal.AuditActionSubscribeResend, email, internal.AuditResultFailure, "")
+		log.Printf("alert confirmation resend failed: %v", err)
+		writeError(w, err, "resend failed")
+	default:
+		recordAudit(r, internal.AuditActionSubscribeResend, email, internal.AuditResultSuccess, "")
+		writeJSON(w, http.StatusOK, sub)
+	}
+}
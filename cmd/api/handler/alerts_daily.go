@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"aquawatch/internal"
+)
+
+// DailyAlertsHandler lists one calendar day's alerts grouped per impacted
+// site, with counts and the highest severity, for the history calendar.
+// date defaults to today in tz (IANA name, default UTC); sites (or site)
+// limits the listed sites.
+// GET /alerts/daily?date=2025-06-01&tz=America/New_York&sites=03339000 ->
+// {"date":"2025-06-01","tz":"America/New_York","total":2,"max_severity":"high","sites":[{"site":"03339000","count":2,"max_severity":"high","alerts":[...]}]}
+func DailyAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	q := r.URL.Query()
+	tz := strings.TrimSpace(q.Get("tz"))
+	date := strings.TrimSpace(q.Get("date"))
+	if date == "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unknown time zone"})
+			return
+		}
+		date = time.Now().In(loc).Format(time.DateOnly)
+	}
+	var sites []string
+	for _, key := range []string{"sites", "site"} {
+		for _, s := range strings.Split(q.Get(key), ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				sites = append(sites, s)
+			}
+		}
+	}
+
+	daily, err := internal.ListDailyAlerts(r.Context(), date, tz, sites)
+	switch {
+	case errors.Is(err, internal.ErrInvalidAlertDay):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case err != nil:
+		log.Printf("failed to list alerts of %s: %v", date, err)
+		writeError(w, err, "failed to list alerts")
+	default:
+		writeJSONETag(w, r, daily, nil)
+	}
+}
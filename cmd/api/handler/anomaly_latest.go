@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+)
+
+const (
+	// maxLatestAnomalySites bounds one /anomaly/latest lookup.
+	maxLatestAnomalySites = 200
+	// latestAnomalyMaxAge is how long clients may cache the results.
+	latestAnomalyMaxAge = 30 * time.Second
+)
+
+// LatestAnomalyHandler returns the most recent persisted sweep results for
+// sites, without running inference, so map refreshes are served from what
+// the scheduled sweep (or an earlier /anomaly/check) already computed.
+// GET /anomaly/latest?sites=03339000,03339001&parameter=00060 ->
+// {"items":[AnomalyEvaluation...],"missing":["03339001"]}
+// Sites without any evaluation of the parameter are listed in missing.
+func LatestAnomalyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	q := r.URL.Query()
+	var sites []string
+	seen := map[string]bool{}
+	for _, s := range strings.Split(q.Get("sites"), ",") {
+		if s = strings.TrimSpace(s); s != "" && !seen[s] {
+			seen[s] = true
+			sites = append(sites, s)
+		}
+	}
+	parameter := strings.TrimSpace(q.Get("parameter"))
+	if parameter == "" {
+		parameter = "00060"
+	}
+	if len(sites) > maxLatestAnomalySites {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("too many sites (max %d)", maxLatestAnomalySites)})
+		return
+	}
+	if err := pipeline.ValidateSelection(sites, parameter); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	evals, err := internal.LatestAnomalyEvaluations(r.Context(), sites, parameter)
+	if err != nil {
+		log.Printf("latest anomaly evaluations failed: %v", err)
+		writeError(w, err, "failed to load anomaly evaluations")
+		return
+	}
+	missing := []string{}
+	have := map[string]bool{}
+	for _, ev := range evals {
+		have[ev.Site] = true
+	}
+	for _, s := range sites {
+		if !have[s] {
+			missing = append(missing, s)
+		}
+	}
+	// Results only change when a sweep runs; let clients reuse them briefly.
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(latestAnomalyMaxAge/time.Second)))
+	writeJSON(w, http.StatusOK, map[string]any{"items": evals, "missing": missing})
+}
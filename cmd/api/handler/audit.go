@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"aquawatch/internal"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// clientIP returns the caller IP for audit entries and per-IP send limits.
+// Clients can send any X-Forwarded-For, so only the hops appended by our own
+// proxies are believed: with TRUSTED_PROXY_HOPS (default 1, the API Gateway
+// or ALB in front of the server) proxies, the address the outermost one saw
+// is that many entries from the right. Without the header it is the socket
+// address.
+func clientIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		i := max(len(hops)-envInt("TRUSTED_PROXY_HOPS", 1), 0)
+		if ip := strings.TrimSpace(hops[i]); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sessionActor returns the identity of the request's authenticated
+// principal, if any.
+func sessionActor(r *http.Request) string {
+	if p := PrincipalFrom(r.Context()); p != nil {
+		return p.Actor
+	}
+	return ""
+}
+
+// recordAudit writes a best-effort audit entry for the request. If actor is
+// empty the session identity (when present) is used.
+func recordAudit(r *http.Request, action, resource, result, actor string) {
+	if actor == "" {
+		actor = sessionActor(r)
+	}
+	err := internal.RecordAudit(r.Context(), internal.AuditEntry{
+		Actor:    actor,
+		Action:   action,
+		Resource: resource,
+		Result:   result,
+		IP:       clientIP(r),
+	})
+	if err != nil {
+		log.Printf("audit %s failed: %v", action, err)
+	}
+}
+
+// ListAuditHandler returns audit entries from the last N minutes (default 60),
+// newest first, optionally filtered by action. Routed behind the admin policy.
+// GET /admin/audit?minutes=60&action=sms.send&limit=100&cursor=...
+func ListAuditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("minutes")
+	minutes := 60
+	if strings.TrimSpace(q) != "" {
+		var v int
+		if _, err := fmt.Sscanf(q, "%d", &v); err == nil && v > 0 && v <= 43200 { // up to 30 days
+			minutes = v
+		}
+	}
+	limit := parsePageLimit(r, 100, 1000)
+	cursor := strings.TrimSpace(r.URL.Query().Get("cursor"))
+	action := strings.TrimSpace(r.URL.Query().Get("action"))
+	since := time.Now().UTC().Add(-time.Duration(minutes) * time.Minute).UnixMilli()
+	items, next, err := internal.ListAuditEntriesPage(r.Context(), since, action, limit, cursor)
+	if err != nil {
+		if errors.Is(err, internal.ErrInvalidCursor) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+			return
+		}
+		log.Printf("failed to list audit entries: %v", err)
+		writeError(w, err, "failed to list audit entries")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "since_ms": since, "next_cursor": next})
+}
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"aquawatch/internal"
+)
+
+// sessionTokens is the response body of endpoints that mint a session.
+// token is the access JWT sent as X-Session-Token; refresh_token is exchanged
+// at /auth/refresh for a new pair before token expires.
+type sessionTokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// issueSessionTokens mints an access/refresh token pair for the user linked
+// to subject (a verified phone number or email address, or a user ID when
+// refreshing). The tokens carry the user ID, creating the user on first
+// sign-in.
+func issueSessionTokens(ctx context.Context, subject string) (*sessionTokens, error) {
+	user, err := internal.UserForSubject(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	ttl := internal.SessionTTL()
+	token, err := internal.MintSessionToken(user.UserID, ttl)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := internal.MintRefreshToken(ctx, user.UserID, internal.RefreshTTL())
+	if err != nil {
+		return nil, err
+	}
+	return &sessionTokens{Token: token, RefreshToken: refresh, ExpiresIn: int64(ttl.Seconds())}, nil
+}
+
+// RefreshSessionHandler exchanges a refresh token for a new access/refresh
+// pair. Refresh tokens are single-use: the presented token is retired.
+// POST {"refresh_token":"..."} -> {"token":"...","refresh_token":"...","expires_in":43200}
+func RefreshSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.RefreshToken) == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+	subject, err := internal.RedeemRefreshToken(r.Context(), strings.TrimSpace(req.RefreshToken))
+	if err != nil {
+		recordAudit(r, internal.AuditActionSessionRefresh, "session", internal.AuditResultDenied, "")
+		if errors.Is(err, internal.ErrInvalidToken) || errors.Is(err, internal.ErrRefreshTokenReused) {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid refresh token"})
+			return
+		}
+		log.Printf("refresh token redeem failed: %v", err)
+		writeError(w, err, "failed to refresh session")
+		return
+	}
+	tokens, err := issueSessionTokens(r.Context(), subject)
+	if err != nil {
+		recordAudit(r, internal.AuditActionSessionRefresh, subject, internal.AuditResultFailure, subject)
+		log.Printf("session refresh failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to mint token"})
+		return
+	}
+	recordAudit(r, internal.AuditActionSessionRefresh, subject, internal.AuditResultSuccess, subject)
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// SendMagicLinkHandler emails a one-time sign-in link. It answers 202 for any
+// well-formed address so the endpoint cannot be used to probe for accounts;
+// sends share the SMS cooldown and rate limits.
+// POST {"email":"user@example.com"} -> 202 {"message":"..."}
+func SendMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	var req struct {
+		Email string `json:"email"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	email, err := internal.NormalizeEmail(req.Email)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid email"})
+		return
+	}
+	if err := internal.ReserveEmailSend(r.Context(), email, clientIP(r)); err != nil {
+		var rl *internal.RateLimitError
+		if errors.As(err, &rl) {
+			recordAudit(r, internal.AuditActionEmailSend, email, internal.AuditResultDenied, email)
+			w.Header().Set("Retry-After", strconv.Itoa(int(rl.RetryAfter.Round(time.Second).Seconds())))
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "too many requests", "scope": rl.Scope})
+			return
+		}
+		log.Printf("email rate limit check failed: %v", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "rate limiter unavailable"})
+		return
+	}
+	if err := internal.SendMagicLink(r.Context(), email); err != nil {
+		recordAudit(r, internal.AuditActionEmailSend, email, internal.AuditResultFailure, email)
+		log.Printf("magic link send failed: %v", err)
+		writeError(w, err, "failed to send link")
+		return
+	}
+	recordAudit(r, internal.AuditActionEmailSend, email, internal.AuditResultSuccess, email)
+	writeJSON(w, http.StatusAccepted, map[string]string{"message": "if the address is valid, a sign-in link is on its way"})
+}
+
+// VerifyMagicLinkHandler exchanges a magic-link token for session tokens.
+// POST {"token":"..."} -> {"token":"...","refresh_token":"...","expires_in":43200}
+func VerifyMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	var req struct {
+		Token string `json:"token"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Token) == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+	email, err := internal.RedeemMagicLink(r.Context(), strings.TrimSpace(req.Token))
+	if err != nil {
+		recordAudit(r, internal.AuditActionEmailVerify, "magic_link", internal.AuditResultFailure, "")
+		if errors.Is(err, internal.ErrInvalidToken) || errors.Is(err, internal.ErrRefreshTokenReused) {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or expired link"})
+			return
+		}
+		log.Printf("magic link redeem failed: %v", err)
+		writeError(w, err, "failed to verify link")
+		return
+	}
+	recordAudit(r, internal.AuditActionEmailVerify, "magic_link", internal.AuditResultSuccess, email)
+	tokens, err := issueSessionTokens(r.Context(), email)
+	if err != nil {
+		recordAudit(r, internal.AuditActionSessionMint, email, internal.AuditResultFailure, email)
+		log.Printf("session mint failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to mint token"})
+		return
+	}
+	recordAudit(r, internal.AuditActionSessionMint, email, internal.AuditResultSuccess, email)
+	writeJSON(w, http.StatusOK, tokens)
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"aquawatch/internal"
+)
+
+// BackfillsHandler lists or starts historical backfills.
+// GET /backfills -> {"backfills":[Backfill...]}
+// POST /backfills {"sites":["03339000"],"parameter":"00060","from":"2024-01-01","to":"2025-12-31","chunk_days":30} -> 202 Backfill
+// The chunks run on the site worker; poll GET /backfills/{id} for progress.
+func BackfillsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		backfills, err := internal.ListBackfills(r.Context())
+		if err != nil {
+			log.Printf("list backfills failed: %v", err)
+			writeError(w, err, "failed to list backfills")
+			return
+		}
+		if backfills == nil {
+			backfills = []internal.Backfill{}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"backfills": backfills})
+	case http.MethodPost:
+		var spec internal.BackfillSpec
+		if !decodeJSON(w, r, &spec) {
+			return
+		}
+		var actor string
+		if p := PrincipalFrom(r.Context()); p != nil {
+			actor = p.Actor
+		}
+		b, err := internal.CreateBackfill(r.Context(), spec, actor)
+		switch {
+		case errors.Is(err, internal.ErrInvalidBackfill):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		case errors.Is(err, internal.ErrSiteQueueNotConfigured):
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "backfills need the site task queue (SITE_TASK_QUEUE_URL)"})
+		case err != nil && b != nil:
+			// Stored but not fully queued: resumable.
+			recordAudit(r, internal.AuditActionBackfillCreate, b.BackfillID, internal.AuditResultFailure, "")
+			log.Printf("queue backfill %s failed: %v", b.BackfillID, err)
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": "backfill created but not fully queued; resume it", "backfill_id": b.BackfillID})
+		case err != nil:
+			recordAudit(r, internal.AuditActionBackfillCreate, "", internal.AuditResultFailure, "")
+			log.Printf("create backfill failed: %v", err)
+			writeError(w, err, "failed to create backfill")
+		default:
+			recordAudit(r, internal.AuditActionBackfillCreate, b.BackfillID, internal.AuditResultSuccess, "")
+			writeJSON(w, http.StatusAccepted, b)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// BackfillHandler reports the progress of one backfill.
+// GET /backfills/{id} -> Backfill (status, chunks_total, chunks_done, rows_written, dataset)
+func BackfillHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	id := r.PathValue("id")
+	b, err := internal.GetBackfill(r.Context(), id)
+	switch {
+	case errors.Is(err, internal.ErrBackfillNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "backfill not found"})
+	case err != nil:
+		log.Printf("get backfill %s failed: %v", id, err)
+		writeError(w, err, "failed to load backfill")
+	default:
+		writeJSON(w, http.StatusOK, b)
+	}
+}
+
+// ResumeBackfillHandler re-queues a backfill's unfinished chunks, e.g. after
+// some were dead-lettered.
+// POST /backfills/{id}/resume -> {"backfill_id":"bf_...","queued":12}
+func ResumeBackfillHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	id := r.PathValue("id")
+	n, err := internal.ResumeBackfill(r.Context(), id)
+	switch {
+	case errors.Is(err, internal.ErrBackfillNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "backfill not found"})
+	case err != nil:
+		recordAudit(r, internal.AuditActionBackfillResume, id, internal.AuditResultFailure, "")
+		log.Printf("resume backfill %s failed: %v", id, err)
+		writeError(w, err, "failed to resume backfill")
+	default:
+		recordAudit(r, internal.AuditActionBackfillResume, id, internal.AuditResultSuccess, "")
+		writeJSON(w, http.StatusOK, map[string]any{"backfill_id": id, "queued": n})
+	}
+}
@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+)
+
+// BasinsHandler lists or creates basins.
+// GET /basins -> {"basins":[Basin...]}
+// POST /basins {"name":"Upper Wabash","description":"...","sites":["03339000","03339500"]} -> 201 Basin
+func BasinsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		basins, err := internal.ListBasins(r.Context())
+		if err != nil {
+			log.Printf("list basins failed: %v", err)
+			writeError(w, err, "failed to list basins")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"basins": basins})
+	case http.MethodPost:
+		var spec internal.BasinSpec
+		if !decodeJSON(w, r, &spec) {
+			return
+		}
+		var actor string
+		if p := PrincipalFrom(r.Context()); p != nil {
+			actor = p.Actor
+		}
+		b, err := internal.CreateBasin(r.Context(), spec, actor)
+		switch {
+		case errors.Is(err, internal.ErrInvalidBasin):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		case err != nil:
+			recordAudit(r, internal.AuditActionBasinCreate, spec.Name, internal.AuditResultFailure, "")
+			log.Printf("create basin failed: %v", err)
+			writeError(w, err, "failed to create basin")
+		default:
+			recordAudit(r, internal.AuditActionBasinCreate, b.BasinID, internal.AuditResultSuccess, "")
+			writeJSON(w, http.StatusCreated, b)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// BasinHandler reads, replaces or deletes one basin.
+// GET /basins/{id} -> Basin
+// PUT /basins/{id} {<same fields as POST /basins>,"version":1} -> Basin
+// DELETE /basins/{id} -> 204
+// PUT replaces every editable field; a stale version returns 409.
+func BasinHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		if b, ok := loadBasin(w, r); ok {
+			writeJSON(w, http.StatusOK, b)
+		}
+	case http.MethodPut:
+		var req struct {
+			internal.BasinSpec
+			Version *int64 `json:"version"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Version == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: version is required"})
+			return
+		}
+		b, err := internal.UpdateBasin(ctx, id, req.BasinSpec, *req.Version)
+		switch {
+		case errors.Is(err, internal.ErrInvalidBasin):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		case errors.Is(err, internal.ErrBasinNotFound):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "basin not found"})
+		case errors.Is(err, internal.ErrVersionConflict):
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "basin was modified; reload and retry"})
+		case err != nil:
+			recordAudit(r, internal.AuditActionBasinUpdate, id, internal.AuditResultFailure, "")
+			log.Printf("update basin %s failed: %v", id, err)
+			writeError(w, err, "failed to update basin")
+		default:
+			recordAudit(r, internal.AuditActionBasinUpdate, id, internal.AuditResultSuccess, "")
+			writeJSON(w, http.StatusOK, b)
+		}
+	case http.MethodDelete:
+		err := internal.DeleteBasin(ctx, id)
+		switch {
+		case errors.Is(err, internal.ErrBasinNotFound):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "basin not found"})
+		case err != nil:
+			recordAudit(r, internal.AuditActionBasinDelete, id, internal.AuditResultFailure, "")
+			log.Printf("delete basin %s failed: %v", id, err)
+			writeError(w, err, "failed to delete basin")
+		default:
+			recordAudit(r, internal.AuditActionBasinDelete, id, internal.AuditResultSuccess, "")
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// loadBasin loads the basin named by the {id} path value, writing 404 or 502
+// when it can't.
+func loadBasin(w http.ResponseWriter, r *http.Request) (*internal.Basin, bool) {
+	id := r.PathValue("id")
+	b, err := internal.GetBasin(r.Context(), id)
+	switch {
+	case errors.Is(err, internal.ErrBasinNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "basin not found"})
+		return nil, false
+	case err != nil:
+		log.Printf("get basin %s failed: %v", id, err)
+		writeError(w, err, "failed to load basin")
+		return nil, false
+	}
+	return b, true
+}
+
+// basinParameter reads the optional "parameter" query param (default 00060).
+func basinParameter(r *http.Request) string {
+	if p := strings.TrimSpace(r.URL.Query().Get("parameter")); p != "" {
+		return p
+	}
+	return "00060"
+}
+
+// BasinIngestHandler ingests every station of a basin, exactly as /ingest
+// would for the same site list (including train, wait and queueing).
+// POST /basins/{id}/ingest?parameter=00060&train=true
+func BasinIngestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	b, ok := loadBasin(w, r)
+	if !ok {
+		return
+	}
+	startIngest(w, r, b.Sites, basinParameter(r), isTruthy(r.URL.Query().Get("train")))
+}
+
+// BasinAnomalyHandler runs an anomaly sweep over a basin's stations, exactly
+// as /anomaly/check would for the same site list. Archived watchlist sites
+// are skipped.
+// POST /basins/{id}/anomaly?parameter=00060 -> {"items":[...]}, or 202 when queued
+func BasinAnomalyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	b, ok := loadBasin(w, r)
+	if !ok {
+		return
+	}
+	checkAnomalies(w, r, internal.ExcludeArchivedSites(r.Context(), b.Sites), basinParameter(r))
+}
+
+// BasinStatusHandler aggregates the latest sweep results of a basin's
+// stations, without running inference.
+// GET /basins/{id}/status?parameter=00060 -> BasinStatus
+func BasinStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	parameter := basinParameter(r)
+	if err := pipeline.ValidateParameter(parameter); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	b, ok := loadBasin(w, r)
+	if !ok {
+		return
+	}
+	status, err := internal.GetBasinStatus(r.Context(), b, parameter)
+	if err != nil {
+		log.Printf("basin %s status failed: %v", b.BasinID, err)
+		writeError(w, err, "failed to load basin status")
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// BasinsStatusHandler aggregates every basin for the dashboard.
+// GET /basins/status?parameter=00060 -> {"basins":[BasinStatus...]}
+func BasinsStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	parameter := basinParameter(r)
+	if err := pipeline.ValidateParameter(parameter); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	basins, err := internal.ListBasins(r.Context())
+	if err != nil {
+		log.Printf("list basins failed: %v", err)
+		writeError(w, err, "failed to list basins")
+		return
+	}
+	statuses := make([]internal.BasinStatus, 0, len(basins))
+	for _, b := range basins {
+		status, err := internal.GetBasinStatus(r.Context(), &b, parameter)
+		if err != nil {
+			log.Printf("basin %s status failed: %v", b.BasinID, err)
+			writeError(w, err, "failed to load basin status")
+			return
+		}
+		statuses = append(statuses, status)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"basins": statuses})
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"aquawatch/internal"
+)
+
+// Request bodies are capped before handlers read them: BodyLimit wraps every
+// route in an http.MaxBytesReader of MAX_BODY_BYTES (default 1 MiB), or of
+// its entry in bodyLimits for the endpoints taking larger payloads. A body
+// over the cap is answered with 413, up front when Content-Length gives it
+// away. JSON bodies are decoded strictly by decodeJSON: unknown fields, type
+// mismatches and anything after the JSON value are 400s naming the problem.
+
+// defaultMaxBodyBytes is the body cap of routes without a bodyLimits entry.
+const defaultMaxBodyBytes = 1 << 20
+
+// bodyLimits are the body caps of routes taking larger payloads.
+var bodyLimits = map[string]func() int64{
+	// Inline images travel base64-encoded (REPORT_MAX_BODY_BYTES, default
+	// 10 MiB); larger ones are uploaded via /uploads/presign and passed as
+	// image_key.
+	"/report/pdf": func() int64 { return int64(envInt("REPORT_MAX_BODY_BYTES", 10<<20)) },
+	"/datasets":   func() int64 { return internal.MaxDatasetUploadBytes },
+}
+
+// bodyLimit returns the body cap of the route pattern.
+func bodyLimit(pattern string) int64 {
+	if limit, ok := bodyLimits[pattern]; ok {
+		return limit()
+	}
+	return int64(envInt("MAX_BODY_BYTES", defaultMaxBodyBytes))
+}
+
+// BodyLimit caps the request bodies of the route pattern (see bodyLimits).
+func BodyLimit(pattern string, next http.Handler) http.Handler {
+	limit := bodyLimit(pattern)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			writeBodyTooLarge(w, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, limit int64) {
+	writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("request body exceeds %d bytes", limit)})
+}
+
+// writeBodyError answers a failed body read: 413 when the body was over its
+// cap, else 400 with msg.
+func writeBodyError(w http.ResponseWriter, err error, msg string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeBodyTooLarge(w, tooLarge.Limit)
+		return
+	}
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+}
+
+// decodeJSON decodes the request body into dst, rejecting unknown fields and
+// trailing data. On failure it writes the 400 or 413 and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			return true
+		}
+		if err == nil {
+			err = errTrailingJSON
+		}
+	}
+	writeBodyError(w, err, jsonErrorMessage(err))
+	return false
+}
+
+var errTrailingJSON = errors.New("unexpected data after the JSON value")
+
+// jsonErrorMessage describes a JSON decoding error for the client.
+func jsonErrorMessage(err error) string {
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.Is(err, io.EOF):
+		return "request body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "invalid JSON body: unexpected end of input"
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("invalid JSON body: syntax error at byte %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Sprintf("invalid JSON body: %s must be %s", typeErr.Field, jsonTypeName(typeErr.Type.String()))
+	case errors.As(err, &typeErr):
+		return "invalid JSON body: expected " + jsonTypeName(typeErr.Type.String())
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return "invalid JSON body: unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+	case errors.Is(err, errTrailingJSON):
+		return "invalid JSON body: " + err.Error()
+	}
+	return "invalid JSON body"
+}
+
+// jsonTypeName names a Go type the way a JSON client thinks of it.
+func jsonTypeName(goType string) string {
+	switch {
+	case goType == "string":
+		return "a string"
+	case goType == "bool":
+		return "a boolean"
+	case strings.HasPrefix(goType, "int"), strings.HasPrefix(goType, "uint"), strings.HasPrefix(goType, "float"):
+		return "a number"
+	case strings.HasPrefix(goType, "[]"):
+		return "an array"
+	}
+	return "an object"
+}
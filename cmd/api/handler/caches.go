@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"net/http"
+
+	"aquawatch/internal"
+	"aquawatch/internal/cache"
+	"aquawatch/internal/httpclient"
+)
+
+// The introspection endpoints below report the API process's own caches and
+// upstream circuit breakers; Lambdas keep theirs per warm container.
+
+// CachesHandler lists the in-process caches with their counters.
+// GET /admin/caches -> {"caches":[{"name":"station-stats","size":120,"capacity":1000,"ttl_seconds":300,"hits":..,"misses":..,"evictions":..,"hit_rate":0.93}]}
+func CachesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"caches": cache.Snapshot()})
+}
+
+// CacheHandler flushes one cache, so the next lookups reload from the
+// source.
+// DELETE /admin/caches/{name} -> 204
+func CacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	name := r.PathValue("name")
+	if !cache.Flush(name) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "cache not found"})
+		return
+	}
+	recordAudit(r, internal.AuditActionCacheFlush, name, internal.AuditResultSuccess, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BreakersHandler lists the upstream circuit breakers along with the
+// upstreams' request counters.
+// GET /admin/breakers -> {"breakers":[{"name":"usgs","state":"closed","failures":0,"threshold":5,"cooldown_seconds":30,"trips":0,"rejected":0}],"upstreams":[{"name":"usgs","requests":..,"retries":..,"errors":..,...}]}
+func BreakersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"breakers":  httpclient.BreakerSnapshot(),
+		"upstreams": httpclient.Snapshot(),
+	})
+}
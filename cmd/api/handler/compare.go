@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+)
+
+// CompareHandler lines up several stations' daily means for side-by-side
+// charts, from their precomputed statistics.
+// GET /compare?sites=03339000,03339500&parameter=00060&days=7 ->
+// {"parameter":"00060","days":["2026-01-25",...],"series":[{"site":"03339000","values":[812.5,null,...],"summary":{"days":6,"mean":..},"latest":..,"percent_change":..}],"missing":[]}
+func CompareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	q := r.URL.Query()
+	var sites []string
+	seen := map[string]bool{}
+	for _, s := range strings.Split(q.Get("sites"), ",") {
+		if s = strings.TrimSpace(s); s != "" && !seen[s] {
+			seen[s] = true
+			sites = append(sites, s)
+		}
+	}
+	parameter := strings.TrimSpace(q.Get("parameter"))
+	if parameter == "" {
+		parameter = "00060"
+	}
+	if len(sites) > internal.MaxCompareSites {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("too many sites (max %d)", internal.MaxCompareSites)})
+		return
+	}
+	if err := pipeline.ValidateSelection(sites, parameter); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	days := 7
+	if v := q.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > internal.MaxCompareDays {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("days must be between 1 and %d", internal.MaxCompareDays)})
+			return
+		}
+		days = n
+	}
+
+	comparison, err := internal.CompareStations(r.Context(), sites, parameter, days)
+	if err != nil {
+		log.Printf("compare stations failed: %v", err)
+		writeError(w, err, "failed to load station statistics")
+		return
+	}
+	writeJSON(w, http.StatusOK, comparison)
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+)
+
+// StationCompletenessHandler reports how much of a station's daily cadence
+// the processed history store holds over a period, to judge whether a model
+// for the station can be trusted.
+// GET /stations/{site}/completeness?parameter=00060&start=2025-01-01&end=2025-12-31 ->
+// {"site":"03339000","parameter":"00060","start":"2025-01-01","end":"2025-12-31","expected_days":365,"observed_days":351,"percent":96.2,"gaps":[{"start":"2025-07-02","end":"2025-07-15","days":14}],"longest_outage":{...},"unstored_months":[]}
+// start defaults to 90 days before end, end to yesterday.
+func StationCompletenessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	q := r.URL.Query()
+	site := r.PathValue("site")
+	parameter := strings.TrimSpace(q.Get("parameter"))
+	if parameter == "" {
+		parameter = "00060"
+	}
+	if err := pipeline.ValidateSelection([]string{site}, parameter); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	end := time.Now().UTC()
+	if v := q.Get("end"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "end must be a date (YYYY-MM-DD)"})
+			return
+		}
+		end = t
+	}
+	var start time.Time
+	if v := q.Get("start"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "start must be a date (YYYY-MM-DD)"})
+			return
+		}
+		start = t
+	}
+
+	c, err := internal.SiteCompleteness(r.Context(), site, parameter, start, end)
+	switch {
+	case errors.Is(err, internal.ErrInvalidHistoryRange):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case err != nil:
+		log.Printf("completeness %s/%s failed: %v", site, parameter, err)
+		writeError(w, err, "failed to compute completeness")
+	default:
+		writeJSON(w, http.StatusOK, c)
+	}
+}
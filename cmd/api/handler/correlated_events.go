@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"aquawatch/internal"
+)
+
+// ListCorrelatedEventsHandler returns correlated events started in the last
+// N minutes (default 1440), newest first, with the same limit/cursor
+// pagination as ListAlertsHandler.
+// GET /correlated-events?minutes=1440&limit=100&cursor=...
+func ListCorrelatedEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	q := r.URL.Query().Get("minutes")
+	minutes := 1440
+	if strings.TrimSpace(q) != "" {
+		var v int
+		if _, err := fmt.Sscanf(q, "%d", &v); err == nil && v > 0 && v <= 43200 { // up to 30 days
+			minutes = v
+		}
+	}
+	limit := parsePageLimit(r, 100, 1000)
+	cursor := strings.TrimSpace(r.URL.Query().Get("cursor"))
+	since := time.Now().UTC().Add(-time.Duration(minutes) * time.Minute).UnixMilli()
+	events, next, err := internal.ListCorrelatedEventsPage(r.Context(), since, limit, cursor)
+	if err != nil {
+		if errors.Is(err, internal.ErrInvalidCursor) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+			return
+		}
+		log.Printf("failed to list correlated events: %v", err)
+		writeError(w, err, "failed to list correlated events")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"events": events, "since_ms": since, "next_cursor": next})
+}
+
+// CorrelatedEventHandler returns one correlated event with every anomalous
+// evaluation it merged.
+// GET /correlated-events/{id} -> CorrelatedEvent
+func CorrelatedEventHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	id := r.PathValue("id")
+	e, err := internal.GetCorrelatedEvent(r.Context(), id)
+	switch {
+	case errors.Is(err, internal.ErrEventNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "event not found"})
+	case err != nil:
+		log.Printf("get correlated event %s failed: %v", id, err)
+		writeError(w, err, "failed to load event")
+	default:
+		writeJSON(w, http.StatusOK, e)
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"aquawatch/internal"
+)
+
+// DatasetsHandler ingests a client's preprocessed dataset: a CSV in the
+// processed feature schema (value,timestamp_unix,latitude,longitude,wx_temp;
+// an optional header row naming them), gzipped with Content-Encoding: gzip
+// or Content-Type: application/gzip, or plain text/csv. The rows are
+// validated, stored under the processed prefix and registered for inference.
+// POST /datasets?name=my-run&parameter=00060&sites=03339000,03339500 (body: CSV) -> 201 Dataset
+func DatasetsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	// BodyLimit caps the upload at internal.MaxDatasetUploadBytes.
+	var body io.Reader = r.Body
+	switch {
+	case strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip"),
+		mediaType == "application/gzip", mediaType == "application/x-gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "body is not valid gzip"})
+			return
+		}
+		defer zr.Close()
+		body = zr
+	case mediaType != "text/csv":
+		writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "send text/csv, gzipped or not"})
+		return
+	}
+	q := r.URL.Query()
+	spec := internal.DatasetSpec{Name: q.Get("name"), Parameter: q.Get("parameter")}
+	if v := q.Get("sites"); v != "" {
+		for _, site := range strings.Split(v, ",") {
+			spec.Sites = append(spec.Sites, strings.TrimSpace(site))
+		}
+	}
+	var actor string
+	if p := PrincipalFrom(r.Context()); p != nil {
+		actor = p.Actor
+	}
+	ds, err := internal.IngestClientDataset(r.Context(), spec, body, actor)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeBodyTooLarge(w, tooLarge.Limit)
+	case errors.Is(err, internal.ErrInvalidDataset):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, internal.ErrIngestNotConfigured):
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	case err != nil:
+		recordAudit(r, internal.AuditActionDatasetUpload, "", internal.AuditResultFailure, "")
+		log.Printf("dataset upload failed: %v", err)
+		writeError(w, err, "failed to store dataset")
+	default:
+		recordAudit(r, internal.AuditActionDatasetUpload, ds.DatasetID, internal.AuditResultSuccess, "")
+		writeJSON(w, http.StatusCreated, ds)
+	}
+}
+
+// DatasetHandler returns an uploaded dataset's registration.
+// GET /datasets/{id} -> Dataset
+func DatasetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	id := r.PathValue("id")
+	ds, err := internal.GetDataset(r.Context(), id)
+	switch {
+	case errors.Is(err, internal.ErrDatasetNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "dataset not found"})
+	case err != nil:
+		log.Printf("get dataset %s failed: %v", id, err)
+		writeError(w, err, "failed to load dataset")
+	default:
+		writeJSON(w, http.StatusOK, ds)
+	}
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"aquawatch/internal"
+)
+
+// throttledRetryAfterSeconds is the Retry-After sent with a 429 for a
+// throttled dependency, which doesn't say when it will take requests again.
+const throttledRetryAfterSeconds = 5
+
+// writeError answers a failed call with the status of err's category (see
+// internal.ErrorKind) rather than a blanket 502:
+//
+//	validation             400  {"error": err}
+//	not found              404  {"error": err}
+//	throttled              429  {"error": msg, "cause": "throttled"} + Retry-After
+//	upstream unavailable   502  {"error": msg, "cause": "upstream unavailable"}
+//	  (upstream timed out) 504
+//	anything else          500  {"error": msg}
+//
+// A 4xx is about the request, so the client sees err itself; a 5xx only
+// says which dependency failed, with the details left to the caller's log.
+func writeError(w http.ResponseWriter, err error, msg string) {
+	kind := internal.ErrorKind(err)
+	switch kind {
+	case internal.ErrValidation:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case internal.ErrNotFound:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case internal.ErrThrottled:
+		retryAfter := throttledRetryAfterSeconds
+		var rl *internal.RateLimitError
+		if errors.As(err, &rl) {
+			retryAfter = max(1, int(rl.RetryAfter.Round(time.Second).Seconds()))
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": msg, "cause": kind.Error()})
+	case internal.ErrUpstreamUnavailable:
+		code := http.StatusBadGateway
+		if errors.Is(err, context.DeadlineExceeded) {
+			code = http.StatusGatewayTimeout
+		}
+		writeJSON(w, code, map[string]string{"error": msg, "cause": kind.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": msg})
+	}
+}
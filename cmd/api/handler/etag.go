@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Read endpoints that dashboards poll (/alerts, /alerts/daily, the GeoJSON
+// layers, /stations/{site}/stats, /stations/{site}/parameters and the
+// /history time series) send an ETag hashed from their response and
+// answer 304 Not Modified without a body when If-None-Match lists it. The
+// tags are weak: fields that change on every request, such as the since_ms
+// of /alerts, are left out of the hash, so a matching tag means the same
+// content rather than the same bytes.
+
+// writeJSONETag writes payload like writeJSON, tagged with a weak ETag hashed
+// from version (payload when nil), or 304 when the request's If-None-Match
+// matches the tag. A Content-Type already set is kept.
+func writeJSONETag(w http.ResponseWriter, r *http.Request, payload, version any) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		writeJSON(w, http.StatusOK, payload)
+		return
+	}
+	tagged := body
+	if version != nil {
+		if tagged, err = json.Marshal(version); err != nil {
+			writeJSON(w, http.StatusOK, payload)
+			return
+		}
+	}
+	sum := sha256.Sum256(tagged)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly as RFC 9110 requires for If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"aquawatch/internal"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ExportHandler triggers an on-demand analytics export of alert and prediction
+// history to Parquet in S3. Routed behind the admin policy.
+// POST /admin/export?days=1 -> exports the last N whole UTC days (default 1, max 90)
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	bucket := os.Getenv("ANALYTICS_BUCKET")
+	if bucket == "" {
+		bucket = os.Getenv("S3_BUCKET")
+	}
+	if bucket == "" {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "ANALYTICS_BUCKET or S3_BUCKET not configured"})
+		return
+	}
+	days := 1
+	if q := strings.TrimSpace(r.URL.Query().Get("days")); q != "" {
+		var v int
+		if _, err := fmt.Sscanf(q, "%d", &v); err != nil || v <= 0 || v > 90 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "days must be between 1 and 90"})
+			return
+		}
+		days = v
+	}
+	until := time.Now().UTC().Truncate(24 * time.Hour)
+	since := until.AddDate(0, 0, -days)
+
+	results, err := internal.ExportTrackerHistory(r.Context(), bucket, since, until)
+	if err != nil {
+		log.Printf("tracker export failed: %v", err)
+		recordAudit(r, internal.AuditActionExport, bucket, internal.AuditResultFailure, "")
+		writeError(w, err, "export failed")
+		return
+	}
+	recordAudit(r, internal.AuditActionExport, bucket, internal.AuditResultSuccess, "")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"bucket":   bucket,
+		"since":    since.Format(time.RFC3339),
+		"until":    until.Format(time.RFC3339),
+		"datasets": results,
+	})
+}
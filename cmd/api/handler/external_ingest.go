@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"aquawatch/internal"
+)
+
+// ExternalIngestHandler appends signed readings from third-party and IoT
+// sensors to the processed datasets (see SignedPayload for signing).
+// POST /ingest/external {"readings":[{"site":"03339000","timestamp":"2026-01-31T12:00:00Z","value":812.5,"unit":"cfs"}]} ->
+// {"accepted":1,"parts":[{"parameter":"00060","dataset":"processed/external/00060/2026-01-31.csv","part":"...","rows":1}]}
+func ExternalIngestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err, "failed to read body")
+		return
+	}
+	var payload internal.ExternalPayload
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": jsonErrorMessage(err)})
+		return
+	}
+	source := strings.TrimPrefix(PrincipalFrom(r.Context()).Actor, "external:")
+
+	parts, err := internal.IngestExternalReadings(r.Context(), source, payload, body)
+	switch {
+	case errors.Is(err, internal.ErrInvalidReading):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, internal.ErrIngestNotConfigured):
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	case err != nil:
+		recordAudit(r, internal.AuditActionIngestExternal, source, internal.AuditResultFailure, "")
+		log.Printf("external ingest from %s failed: %v", source, err)
+		writeError(w, err, "failed to store readings")
+	default:
+		recordAudit(r, internal.AuditActionIngestExternal, source, internal.AuditResultSuccess, "")
+		writeJSON(w, http.StatusOK, map[string]any{"accepted": len(payload.Readings), "parts": parts})
+	}
+}
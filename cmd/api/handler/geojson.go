@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+)
+
+// StationsGeoJSONHandler serves monitored stations as a GeoJSON map layer.
+// GET /stations.geojson?parameter=00060&sites=03339000,03339500 ->
+// {"type":"FeatureCollection","features":[{"type":"Feature","id":"03339000","geometry":{"type":"Point","coordinates":[-88.2,40.1]},"properties":{"site":"03339000","status":"anomalous","severity":"high",...}}]}
+// Without sites, the layer covers the stations of enabled schedules, basins
+// and watchlists.
+func StationsGeoJSONHandler(w http.ResponseWriter, r *http.Request) {
+	serveMapLayer(w, r, internal.StationsGeoJSON)
+}
+
+// AnomaliesGeoJSONHandler serves the stations whose latest evaluation is
+// anomalous as a GeoJSON map layer, with the same query and properties as
+// /stations.geojson.
+// GET /anomalies.geojson?parameter=00060 -> FeatureCollection
+func AnomaliesGeoJSONHandler(w http.ResponseWriter, r *http.Request) {
+	serveMapLayer(w, r, internal.AnomaliesGeoJSON)
+}
+
+func serveMapLayer(w http.ResponseWriter, r *http.Request, layer func(context.Context, []string, string) (internal.FeatureCollection, error)) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	q := r.URL.Query()
+	var sites []string
+	seen := map[string]bool{}
+	for _, s := range strings.Split(q.Get("sites"), ",") {
+		if s = strings.TrimSpace(s); s != "" && !seen[s] {
+			seen[s] = true
+			sites = append(sites, s)
+		}
+	}
+	parameter := strings.TrimSpace(q.Get("parameter"))
+	if parameter == "" {
+		parameter = "00060"
+	}
+	if len(sites) == 0 {
+		monitored, err := internal.MonitoredStations(r.Context())
+		if err != nil {
+			log.Printf("monitored stations failed: %v", err)
+			writeError(w, err, "failed to load stations")
+			return
+		}
+		sites = monitored
+	} else if err := pipeline.ValidateSelection(sites, parameter); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := pipeline.ValidateParameter(parameter); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if len(sites) > internal.MaxMapLayerSites {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("too many sites (max %d)", internal.MaxMapLayerSites)})
+		return
+	}
+
+	fc, err := layer(r.Context(), sites, parameter)
+	if err != nil {
+		log.Printf("map layer failed: %v", err)
+		writeError(w, err, "failed to build map layer")
+		return
+	}
+	w.Header().Set("Content-Type", internal.GeoJSONContentType)
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	writeJSONETag(w, r, fc, nil)
+}
@@ -2,13 +2,16 @@ package handler
 
 import (
 	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -20,12 +23,37 @@ type ingestResponse struct {
 	Bytes        int    `json:"bytes,omitempty"`
 	Timestamp    string `json:"timestamp"`
 	ExecutionArn string `json:"execution_arn,omitempty"`
+	// ExecutionName and Deduplicated are set by /ingest; Deduplicated means
+	// the request matched an earlier execution instead of starting one.
+	ExecutionName string `json:"execution_name,omitempty"`
+	Deduplicated  bool   `json:"deduplicated,omitempty"`
+	// DryRun marks executions that only fetch and preprocess; their report
+	// is the execution's output and lands on the run's record.
+	DryRun bool `json:"dry_run,omitempty"`
+	// Start and End are the window of days fetched, when not the last 30.
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+// dryRunResponse is the result of an inline dry run (dry_run=true&wait=true).
+type dryRunResponse struct {
+	DryRun    bool                   `json:"dry_run"`
+	Parameter string                 `json:"parameter"`
+	Report    *pipeline.DryRunReport `json:"report"`
 }
 
 // reportPDFRequest represents the JSON body for generating the PDF report.
+// The image is either inline (image_base64) or a key returned by
+// /uploads/presign (image_key). With event_id the rows are the stations of
+// that correlated event instead of items.
 type reportPDFRequest struct {
-	ImageBase64 string                `json:"image_base64"`
+	ImageBase64 string                `json:"image_base64,omitempty"`
+	ImageKey    string                `json:"image_key,omitempty"`
 	Items       []internal.ReportItem `json:"items"`
+	EventID     string                `json:"event_id,omitempty"`
+	// Images are attached to the alert the report records and linked in
+	// the PDF.
+	Images []internal.AlertImageSpec `json:"images,omitempty"`
 }
 
 // anomalyRequest represents inputs from the frontend for the anomaly check.
@@ -36,6 +64,9 @@ type anomalyRequest struct {
 	MaxLat    float64  `json:"max_lat"`
 	MaxLng    float64  `json:"max_lng"`
 	Parameter string   `json:"parameter"`
+	// ThresholdPercent is accepted from older clients but unused; the
+	// threshold is set server-side.
+	ThresholdPercent float64 `json:"threshold_percent,omitempty"`
 }
 
 type anomalyItem struct {
@@ -46,6 +77,14 @@ type anomalyItem struct {
 	PercentChange   float64 `json:"percent_change"`
 	Anomalous       bool    `json:"anomalous"`
 	AnomalousReason string  `json:"anomalous_reason"`
+	// Severity is set for parameters with a threshold rule, Unit for
+	// parameters with a known unit.
+	Unit     string `json:"unit,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	// Percentile ranks the observed value in the site's history.
+	Percentile *internal.PercentileContext `json:"percentile,omitempty"`
+	// LeadTime estimates when the site reaches its next impact threshold.
+	LeadTime *internal.FloodLeadTime `json:"lead_time,omitempty"`
 }
 
 type anomalyResponse struct {
@@ -61,15 +100,8 @@ func writeJSON(w http.ResponseWriter, code int, payload any) {
 // IngestHandler starts the ingestion workflow by launching the Step Functions
 // pipeline. It supports optional `train` query param to skip training.
 func IngestHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
 	log.Println("AquaWatch Ingest API called")
 
-	stateMachineArn := os.Getenv("STATE_MACHINE_ARN")
-	if stateMachineArn == "" {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "STATE_MACHINE_ARN not configured"})
-		return
-	}
-
 	// Accept multiple stations: repeated station params and/or comma-separated 'stations'
 	var stationIDs []string
 	if vals, ok := r.URL.Query()["station"]; ok {
@@ -99,52 +131,235 @@ func IngestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Optional training flag (default false unless train=true)
-	trainParam := r.URL.Query().Get("train")
-	trainFlag := false
-	if trainParam != "" {
-		switch strings.ToLower(trainParam) {
-		case "true", "1", "yes":
-			trainFlag = true
+	trainFlag := isTruthy(r.URL.Query().Get("train"))
+	startIngest(w, r, stationIDs, parameter, trainFlag)
+}
+
+// startIngest starts the pipeline for sites and writes the response: large
+// requests are queued as site tasks, wait=true runs small ones inline, and
+// anything else starts one execution. Parameters a station doesn't report
+// are rejected up front. dry_run=true only fetches and preprocesses, storing
+// nothing: inline with wait=true, otherwise as an execution that ends after
+// Preprocess; dry runs are never queued. start and end (YYYY-MM-DD, see
+// internal.IngestWindow) fetch a window of past days instead of the last 30,
+// e.g. to backfill training data; windowed runs are never queued either.
+func startIngest(w http.ResponseWriter, r *http.Request, stationIDs []string, parameter string, trainFlag bool) {
+	ctx := r.Context()
+	// Reject parameters the stations don't report before starting anything;
+	// oversized requests fail queueIngest's limit without the lookups.
+	if len(stationIDs) <= maxQueuedSites {
+		if err := internal.CheckSiteParameter(ctx, stationIDs, parameter); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
 		}
 	}
 
-	bucket := os.Getenv("S3_BUCKET")
-	if bucket == "" {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "S3_BUCKET not configured"})
+	dryRun := isTruthy(r.URL.Query().Get("dry_run"))
+	if dryRun && len(stationIDs) > internal.IngestSitesPerRun() {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("a dry run covers at most %d sites", internal.IngestSitesPerRun()),
+		})
+		return
+	}
+	start, end, err := internal.IngestWindow(r.URL.Query().Get("start"), r.URL.Query().Get("end"), time.Now())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if start != "" && len(stationIDs) > internal.IngestSitesPerRun() {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("a windowed ingest covers at most %d sites; use /backfills for more", internal.IngestSitesPerRun()),
+		})
+		return
+	}
+	if len(stationIDs) > internal.IngestSitesPerRun() && internal.SiteTaskQueueEnabled() {
+		queueIngest(w, r, stationIDs, parameter, trainFlag)
 		return
 	}
 
-	processedKey := fmt.Sprintf("processed/%d.csv", time.Now().UTC().Unix())
-
-	input := map[string]any{
-		"station":      stationIDs,
-		"parameter":    parameter,
-		"bucket":       bucket,
-		"processedKey": processedKey,
-		"train":        trainFlag,
+	req := internal.IngestRequest{
+		Sites:            stationIDs,
+		Parameter:        parameter,
+		Train:            trainFlag,
+		DryRun:           dryRun,
+		Start:            start,
+		End:              end,
+		IdempotencyToken: idempotencyToken(r),
+	}
+	// wait=true runs small no-training requests on the Express workflow and
+	// returns the result inline; anything else starts the standard run.
+	if isTruthy(r.URL.Query().Get("wait")) {
+		if dryRun {
+			runDryRunInline(w, r, req)
+			return
+		}
+		if internal.ExpressEligible(req) {
+			runIngestSync(w, r, req)
+			return
+		}
 	}
 
-	execArn, err := internal.StartStateMachine(ctx, stateMachineArn, input)
-	if err != nil {
+	exec, err := internal.StartIngest(ctx, req)
+	switch {
+	case errors.Is(err, pipeline.ErrInvalidInput):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	case errors.Is(err, internal.ErrIngestNotConfigured):
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	case errors.Is(err, internal.ErrExecutionExists):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "too many runs of this request today"})
+		return
+	case err != nil:
+		recordAudit(r, internal.AuditActionIngestStart, strings.Join(stationIDs, ","), internal.AuditResultFailure, "")
 		log.Printf("start state machine failed: %v", err)
-		writeJSON(w, http.StatusBadGateway, map[string]string{"error": fmt.Sprintf("state machine start failed: %v", err)})
+		writeError(w, err, fmt.Sprintf("state machine start failed: %v", err))
 		return
 	}
 
+	message := "execution started"
+	if exec.Existing {
+		message = "duplicate request; existing execution returned"
+	} else {
+		recordAudit(r, internal.AuditActionIngestStart, exec.ExecutionArn, internal.AuditResultSuccess, "")
+	}
 	writeJSON(w, http.StatusOK, ingestResponse{
-		Message:      "execution started",
-		Timestamp:    time.Now().UTC().Format(time.RFC3339),
-		ExecutionArn: execArn,
+		Message:       message,
+		Timestamp:     time.Now().UTC().Format(time.RFC3339),
+		ExecutionArn:  exec.ExecutionArn,
+		ExecutionName: exec.Name,
+		Deduplicated:  exec.Existing,
+		DryRun:        dryRun,
+		Start:         start,
+		End:           end,
 	})
 }
 
+// runDryRunInline fetches and preprocesses req's sites in this process and
+// writes the report: 200, or the status of the fetch or preprocessing
+// failure (see writeError).
+func runDryRunInline(w http.ResponseWriter, r *http.Request, req internal.IngestRequest) {
+	report, err := internal.DryRunIngest(r.Context(), req.Sites, req.Parameter, req.Start, req.End)
+	if err != nil {
+		log.Printf("dry run of %s failed: %v", strings.Join(req.Sites, ","), err)
+		writeError(w, err, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, dryRunResponse{DryRun: true, Parameter: req.Parameter, Report: report})
+}
+
+// isTruthy reports whether a query flag is set ("true", "1" or "yes").
+func isTruthy(v string) bool {
+	switch strings.ToLower(v) {
+	case "true", "1", "yes":
+		return true
+	}
+	return false
+}
+
+// runIngestSync runs req on the Express workflow and writes its result:
+// 200 with the inference output when it succeeded, 502 with the error and
+// cause otherwise.
+func runIngestSync(w http.ResponseWriter, r *http.Request, req internal.IngestRequest) {
+	res, err := internal.RunIngestSync(r.Context(), req)
+	switch {
+	case errors.Is(err, pipeline.ErrInvalidInput):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	case errors.Is(err, internal.ErrIngestNotConfigured):
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		recordAudit(r, internal.AuditActionIngestStart, strings.Join(req.Sites, ","), internal.AuditResultFailure, "")
+		log.Printf("express execution failed: %v", err)
+		writeError(w, err, fmt.Sprintf("express execution failed: %v", err))
+		return
+	}
+	recordAudit(r, internal.AuditActionIngestStart, res.ExecutionArn, internal.AuditResultSuccess, "")
+	code := http.StatusOK
+	if res.Status != internal.PipelineRunSucceeded {
+		code = http.StatusBadGateway
+	}
+	writeJSON(w, code, res)
+}
+
+// maxIdempotencyTokenLen bounds client-provided idempotency tokens.
+const maxIdempotencyTokenLen = 128
+
+// idempotencyToken reads the Idempotency-Key header (or ?idempotency_key=).
+// Overlong tokens are truncated, which still maps retries consistently.
+func idempotencyToken(r *http.Request) string {
+	tok := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+	if tok == "" {
+		tok = strings.TrimSpace(r.URL.Query().Get("idempotency_key"))
+	}
+	if len(tok) > maxIdempotencyTokenLen {
+		tok = tok[:maxIdempotencyTokenLen]
+	}
+	return tok
+}
+
+// queuedResponse is returned (202) when work is split into site tasks.
+type queuedResponse struct {
+	Message string `json:"message"`
+	BatchID string `json:"batch_id"`
+	Tasks   int    `json:"tasks"`
+	Sites   int    `json:"sites"`
+}
+
+// maxQueuedSites bounds one queued anomaly sweep or ingest.
+const maxQueuedSites = 1000
+
+// enqueueTasks queues tasks for the site worker and writes the 202 response.
+func enqueueTasks(w http.ResponseWriter, r *http.Request, batchID string, tasks []internal.SiteTask, sites int) bool {
+	if err := internal.EnqueueSiteTasks(r.Context(), tasks); err != nil {
+		log.Printf("enqueue batch %s failed: %v", batchID, err)
+		writeError(w, err, "failed to queue site tasks")
+		return false
+	}
+	writeJSON(w, http.StatusAccepted, queuedResponse{Message: "queued", BatchID: batchID, Tasks: len(tasks), Sites: sites})
+	return true
+}
+
+// queueIngest splits a large ingest into executions of at most
+// IngestSitesPerRun sites, started by the site worker.
+func queueIngest(w http.ResponseWriter, r *http.Request, sites []string, parameter string, train bool) {
+	if len(sites) > maxQueuedSites {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("too many sites (max %d)", maxQueuedSites)})
+		return
+	}
+	if err := pipeline.ValidateSelection(sites, parameter); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	batchID, err := internal.NewSiteTaskBatchID()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create batch"})
+		return
+	}
+	result := internal.AuditResultFailure
+	if enqueueTasks(w, r, batchID, internal.IngestTasks(batchID, sites, parameter, train), len(sites)) {
+		result = internal.AuditResultSuccess
+	}
+	recordAudit(r, internal.AuditActionIngestStart, batchID, result, "")
+}
+
 // HealthHandler returns a basic OK response.
 func HealthHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-// PredictionStatusHandler queries the prediction-tracker table by site and status
-// and returns whether the prediction is considered in-progress (created within 5 minutes).
+// reportURLExpiry is how long the links of a generated report stay valid.
+const reportURLExpiry = 120 * time.Hour
+
+// inlineRunTimeout bounds a prediction run made inside an API request.
+const inlineRunTimeout = 15 * time.Minute
+
+// PredictionStatusHandler queries the prediction-tracker table by site and returns
+// the latest status. When a status is given, that exact record is returned instead.
+// For a "started" run, in_progress and the execution's step progress come
+// from Step Functions (via the pipeline-runs table). Runs without an
+// execution (inline /anomaly runs) are in progress for inlineRunTimeout.
 func PredictionStatusHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	site := r.URL.Query().Get("site")
@@ -153,35 +368,72 @@ func PredictionStatusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	statusParam := r.URL.Query().Get("status")
+
+	var item *internal.PredictionTrackerItem
+	var err error
 	if statusParam == "" {
-		statusParam = "started"
+		item, err = internal.GetLatestPredictionTrackerItem(ctx, site)
+	} else {
+		item, err = internal.GetPredictionTrackerItem(ctx, site, statusParam)
 	}
-
-	item, err := internal.GetPredictionTrackerItem(ctx, site, statusParam)
 	if err != nil {
 		log.Printf("ddb: get item failed: %v", err)
-		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "failed to query status"})
+		writeError(w, err, "failed to query status")
 		return
 	}
 
 	inProgress := false
+	status := statusParam
 	var createdOn, updatedOn int64
+	var errorMessage string
 	if item != nil {
+		status = item.Status
 		createdOn = item.CreatedOn
 		updatedOn = item.UpdatedOn
-		ageMs := time.Now().UTC().UnixMilli() - item.CreatedOn
-		inProgress = ageMs < 15*60*1000
+		errorMessage = item.ErrorMessage
+		if item.ExecutionArn == "" {
+			ageMs := time.Now().UTC().UnixMilli() - item.CreatedOn
+			inProgress = item.Status == internal.PredictionStatusStarted && ageMs < inlineRunTimeout.Milliseconds()
+		}
+	}
+
+	var run *internal.PipelineRun
+	if item != nil && item.ExecutionArn != "" {
+		if item.Status == internal.PredictionStatusStarted {
+			run, err = internal.GetExecutionStatus(ctx, item.ExecutionArn)
+		} else {
+			run, err = internal.GetPipelineRun(ctx, item.ExecutionArn)
+		}
+		if err != nil {
+			log.Printf("pipeline run lookup failed for %s: %v", item.ExecutionArn, err)
+		}
+	}
+	if run != nil {
+		inProgress = item.Status == internal.PredictionStatusStarted && !run.Done()
+		if item.Status == internal.PredictionStatusStarted && run.Done() && run.Status != internal.PipelineRunSucceeded {
+			// The execution died before the infer lambda could record an outcome.
+			status = internal.PredictionStatusFailed
+			errorMessage = run.Error
+			if run.Cause != "" {
+				errorMessage += ": " + run.Cause
+			}
+		}
 	}
 
 	writeJSON(w, http.StatusOK, map[string]any{
-		"site":         site,
-		"status":       statusParam,
-		"in_progress":  inProgress,
-		"createdon_ms": createdOn,
-		"updatedon_ms": updatedOn,
+		"site":          site,
+		"status":        status,
+		"in_progress":   inProgress,
+		"createdon_ms":  createdOn,
+		"updatedon_ms":  updatedOn,
+		"error_message": errorMessage,
+		"execution":     run,
 	})
 }
 
+// emailPattern is a basic email address check.
+var emailPattern = regexp.MustCompile(`^[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}$`)
+
 // SubscribeAlertsHandler subscribes an email to the alerts SNS topic.
 // Accepts POST with JSON body: {"email": "user@example.com"}
 func SubscribeAlertsHandler(w http.ResponseWriter, r *http.Request) {
@@ -194,29 +446,29 @@ func SubscribeAlertsHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Email string `json:"email"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	// Basic email validation regex
-	pattern := regexp.MustCompile(`^[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}$`)
-	if !pattern.MatchString(strings.TrimSpace(req.Email)) {
+	if !emailPattern.MatchString(strings.TrimSpace(req.Email)) {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid email"})
 		return
 	}
 
 	ctx := r.Context()
-	arn, err := internal.SubscribeAlertsEmail(ctx, strings.TrimSpace(req.Email))
+	email := strings.TrimSpace(req.Email)
+	arn, err := internal.SubscribeAlertsEmail(ctx, email)
 	if err != nil {
+		recordAudit(r, internal.AuditActionSubscribe, email, internal.AuditResultFailure, "")
 		if err == internal.ErrAlreadySubscribed {
 			writeJSON(w, http.StatusConflict, map[string]string{"error": "email already subscribed"})
 			return
 		}
 		log.Printf("sns subscribe failed: %v", err)
-		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "subscription failed"})
+		writeError(w, err, "subscription failed")
 		return
 	}
 
+	recordAudit(r, internal.AuditActionSubscribe, email, internal.AuditResultSuccess, "")
 	writeJSON(w, http.StatusOK, map[string]any{
 		"message":          "subscription requested; check email to confirm",
 		"subscription_arn": arn,
@@ -224,7 +476,9 @@ func SubscribeAlertsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // SendSMSCodeHandler starts a Vonage Verify request (SMS) for a phone number.
-// POST {"phone_e164":"+15551234567","brand":"AquaWatch"} -> {"session_id":"<request_id>"}
+// Sends are rate limited per phone and per IP (429 with Retry-After) and, when
+// configured, require a signed challenge or CAPTCHA token (403 otherwise).
+// POST {"phone_e164":"+15551234567","brand":"AquaWatch","timestamp":"...","signature":"...","captcha_token":"..."} -> {"session_id":"<request_id>"}
 func SendSMSCodeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", http.MethodPost)
@@ -232,24 +486,148 @@ func SendSMSCodeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req struct {
-		PhoneE164 string `json:"phone_e164"`
-		Brand     string `json:"brand"`
+		PhoneE164    string `json:"phone_e164"`
+		Brand        string `json:"brand"`
+		Timestamp    string `json:"timestamp"`
+		Signature    string `json:"signature"`
+		CaptchaToken string `json:"captcha_token"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.PhoneE164) == "" {
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.PhoneE164) == "" {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
 		return
 	}
-	requestID, err := internal.VerifyStart(r.Context(), strings.TrimSpace(req.PhoneE164), strings.TrimSpace(req.Brand))
+	phone, ok := internal.NormalizePhoneE164(req.PhoneE164)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "phone_e164 must be an international number, e.g. +15551234567"})
+		return
+	}
+	ip := clientIP(r)
+	err := internal.VerifySMSChallenge(r.Context(), internal.SMSChallenge{
+		Phone:        phone,
+		Timestamp:    strings.TrimSpace(req.Timestamp),
+		Signature:    strings.TrimSpace(req.Signature),
+		CaptchaToken: strings.TrimSpace(req.CaptchaToken),
+		RemoteIP:     ip,
+	})
+	if err != nil {
+		recordAudit(r, internal.AuditActionSMSSend, phone, internal.AuditResultDenied, phone)
+		if errors.Is(err, internal.ErrChallengeFailed) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "challenge failed"})
+			return
+		}
+		log.Printf("sms challenge check failed: %v", err)
+		writeError(w, err, "failed to verify challenge")
+		return
+	}
+	if err := internal.ReserveSMSSend(r.Context(), phone, ip); err != nil {
+		var rl *internal.RateLimitError
+		if errors.As(err, &rl) {
+			recordAudit(r, internal.AuditActionSMSSend, phone, internal.AuditResultDenied, phone)
+			w.Header().Set("Retry-After", strconv.Itoa(int(rl.RetryAfter.Round(time.Second).Seconds())))
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "too many requests", "scope": rl.Scope})
+			return
+		}
+		// Fail closed: without the limiter every send is an unbounded cost
+		log.Printf("sms rate limit check failed: %v", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "rate limiter unavailable"})
+		return
+	}
+	requestID, err := internal.VerifyStart(r.Context(), phone, strings.TrimSpace(req.Brand))
 	if err != nil {
+		recordAudit(r, internal.AuditActionSMSSend, phone, internal.AuditResultFailure, phone)
 		log.Printf("verify start failed: %v", err)
-		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "failed to send code"})
+		writeError(w, err, "failed to send code")
 		return
 	}
+	recordAudit(r, internal.AuditActionSMSSend, phone, internal.AuditResultSuccess, phone)
 	writeJSON(w, http.StatusOK, map[string]string{"session_id": requestID})
 }
 
-// VerifySMSCodeHandler checks the Vonage code and mints a session token on success.
-// POST {"session_id":"<request_id>","code":"123456"} -> {"token":"..."}
+// CancelSMSCodeHandler cancels a pending verification, e.g. after the user
+// mistyped their number. Providers only allow this in some states (Vonage:
+// from 30s after the send until the second attempt); otherwise 409.
+// POST {"session_id":"<request_id>"} -> {"status":"cancelled"}
+func CancelSMSCodeHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := decodeSMSSession(w, r)
+	if !ok {
+		return
+	}
+	if err := internal.VerifyCancel(r.Context(), sessionID); err != nil {
+		recordAudit(r, internal.AuditActionSMSCancel, sessionID, internal.AuditResultFailure, "")
+		writeVerifyControlError(w, "cancel", err)
+		return
+	}
+	recordAudit(r, internal.AuditActionSMSCancel, sessionID, internal.AuditResultSuccess, "")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "cancelled"})
+}
+
+// ResendSMSCodeHandler re-sends the code of a pending verification using the
+// provider's next channel (Vonage: next workflow event, such as a voice call;
+// Twilio: a voice call). Resends share the SMS cooldown and rate limits.
+// POST {"session_id":"<request_id>"} -> {"status":"resent"}
+func ResendSMSCodeHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := decodeSMSSession(w, r)
+	if !ok {
+		return
+	}
+	if err := internal.ReserveSMSResend(r.Context(), sessionID, clientIP(r)); err != nil {
+		var rl *internal.RateLimitError
+		if errors.As(err, &rl) {
+			recordAudit(r, internal.AuditActionSMSResend, sessionID, internal.AuditResultDenied, "")
+			w.Header().Set("Retry-After", strconv.Itoa(int(rl.RetryAfter.Round(time.Second).Seconds())))
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "too many requests", "scope": rl.Scope})
+			return
+		}
+		log.Printf("sms rate limit check failed: %v", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "rate limiter unavailable"})
+		return
+	}
+	if err := internal.VerifyResend(r.Context(), sessionID); err != nil {
+		recordAudit(r, internal.AuditActionSMSResend, sessionID, internal.AuditResultFailure, "")
+		writeVerifyControlError(w, "resend", err)
+		return
+	}
+	recordAudit(r, internal.AuditActionSMSResend, sessionID, internal.AuditResultSuccess, "")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "resent"})
+}
+
+// decodeSMSSession reads {"session_id"} from a POST body, writing the error
+// response itself when the request is invalid.
+func decodeSMSSession(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return "", false
+	}
+	var req struct {
+		SessionID string `json:"session_id"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return "", false
+	}
+	if strings.TrimSpace(req.SessionID) == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return "", false
+	}
+	return strings.TrimSpace(req.SessionID), true
+}
+
+// writeVerifyControlError maps a cancel/resend failure to 409 when the
+// provider refused it in the request's current state, 502 otherwise.
+func writeVerifyControlError(w http.ResponseWriter, op string, err error) {
+	if errors.Is(err, internal.ErrVerifyControlRejected) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("verify %s failed: %v", op, err)
+	writeError(w, err, "failed to "+op+" verification")
+}
+
+// VerifySMSCodeHandler checks the Vonage code and mints session tokens on success.
+// POST {"session_id":"<request_id>","code":"123456"} -> {"token":"...","refresh_token":"...","expires_in":43200}
 func VerifySMSCodeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", http.MethodPost)
@@ -261,34 +639,39 @@ func VerifySMSCodeHandler(w http.ResponseWriter, r *http.Request) {
 		Code      string `json:"code"`
 		PhoneE164 string `json:"phone_e164"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SessionID == "" || strings.TrimSpace(req.Code) == "" {
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.SessionID == "" || strings.TrimSpace(req.Code) == "" {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
 		return
 	}
+	// Use provided phone if available; otherwise bind to empty string. It is
+	// normalized as on send, so one number maps to one user.
+	phone := strings.TrimSpace(req.PhoneE164)
+	if p, ok := internal.NormalizePhoneE164(phone); ok {
+		phone = p
+	}
 	ok, err := internal.VerifyCheck(r.Context(), req.SessionID, strings.TrimSpace(req.Code))
 	if err != nil || !ok {
+		recordAudit(r, internal.AuditActionSMSVerify, req.SessionID, internal.AuditResultFailure, phone)
 		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid code"})
 		return
 	}
-	// Mint a short-lived session token (default 12h)
-	ttl := 12 * time.Hour
-	if v := os.Getenv("SESSION_TTL_HOURS"); v != "" {
-		if d, err := time.ParseDuration(v + "h"); err == nil {
-			ttl = d
-		}
-	}
-	// Use provided phone if available; otherwise bind to empty string
-	phone := strings.TrimSpace(req.PhoneE164)
-	token, err := internal.MintSessionToken(phone, ttl)
+	recordAudit(r, internal.AuditActionSMSVerify, req.SessionID, internal.AuditResultSuccess, phone)
+	tokens, err := issueSessionTokens(r.Context(), phone)
 	if err != nil {
+		recordAudit(r, internal.AuditActionSessionMint, phone, internal.AuditResultFailure, phone)
+		log.Printf("session mint failed: %v", err)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to mint token"})
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"token": token})
+	recordAudit(r, internal.AuditActionSessionMint, phone, internal.AuditResultSuccess, phone)
+	writeJSON(w, http.StatusOK, tokens)
 }
 
-// GenerateReportPDFHandler accepts an image (base64) and table items, generates a PDF, uploads to S3, and returns the S3 key.
-// POST {"image_base64":"...","items":[{"site":"...","reason":"...","predicted_value":1.2,"anomaly_date":"2025-01-01"}]}
+// GenerateReportPDFHandler accepts an image (base64, or an uploaded image key) and table items, generates a PDF, uploads to S3, and returns the S3 key.
+// POST {"image_base64":"..." | "image_key":"uploads/images/...","items":[{"site":"...","reason":"...","predicted_value":1.2,"anomaly_date":"2025-01-01"}],"images":[{"url":"https://...","caption":"..."}]}
 func GenerateReportPDFHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", http.MethodPost)
@@ -296,53 +679,114 @@ func GenerateReportPDFHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req reportPDFRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	if strings.TrimSpace(req.ImageBase64) == "" || len(req.Items) == 0 {
+	alertName := "Anomaly Report"
+	if eventID := strings.TrimSpace(req.EventID); eventID != "" {
+		event, err := internal.GetCorrelatedEvent(r.Context(), eventID)
+		if errors.Is(err, internal.ErrEventNotFound) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "event not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("get correlated event %s failed: %v", eventID, err)
+			writeError(w, err, "failed to load event")
+			return
+		}
+		req.Items = event.ReportItems()
+		alertName = "Correlated Event Report: " + event.Name
+	}
+	imageKey := strings.TrimSpace(req.ImageKey)
+	if (strings.TrimSpace(req.ImageBase64) == "" && imageKey == "") || len(req.Items) == 0 {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "image and items required"})
 		return
 	}
-	imgBytes, err := decodeBase64Image(req.ImageBase64)
-	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid image"})
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "S3_BUCKET not configured"})
 		return
 	}
+	var imgBytes []byte
+	var err error
+	if imageKey != "" {
+		if !strings.HasPrefix(imageKey, internal.Layout().UploadImagePrefix()) || strings.Contains(imageKey, "..") {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid image_key"})
+			return
+		}
+		imgBytes, err = internal.LoadFromS3(r.Context(), bucket, imageKey)
+		if errors.Is(err, internal.ErrBlobNotFound) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "image_key not found; upload the image first"})
+			return
+		}
+		if err != nil {
+			log.Printf("load uploaded image failed: %v", err)
+			writeError(w, err, "failed to load image")
+			return
+		}
+	} else {
+		imgBytes, err = decodeBase64Image(req.ImageBase64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid image"})
+			return
+		}
+	}
 
-	pdfBytes, err := internal.GenerateReportPDF(r.Context(), imgBytes, req.Items)
+	var actor string
+	if p := PrincipalFrom(r.Context()); p != nil {
+		actor = p.Actor
+	}
+	images, err := internal.NewAlertImages(r.Context(), req.Images, actor)
+	if errors.Is(err, internal.ErrInvalidAlertImage) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		log.Printf("pdf generation failed: %v", err)
-		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "pdf generation failed"})
+		log.Printf("report images failed: %v", err)
+		writeError(w, err, "failed to load images")
 		return
 	}
-	bucket := os.Getenv("S3_BUCKET")
-	if bucket == "" {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "S3_BUCKET not configured"})
+
+	internal.AddReportImpacts(r.Context(), req.Items)
+	// Image links live as long as the report's own link.
+	pdfBytes, err := internal.GenerateReportPDF(r.Context(), imgBytes, req.Items, internal.LinkAlertImages(r.Context(), images, reportURLExpiry))
+	if err != nil {
+		log.Printf("pdf generation failed: %v", err)
+		writeError(w, err, "pdf generation failed")
 		return
 	}
-	key := fmt.Sprintf("reports/%d.pdf", time.Now().UTC().UnixNano())
-	if err := internal.SaveToS3WithKey(r.Context(), pdfBytes, bucket, key); err != nil {
-		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "failed to upload pdf"})
+	key := internal.Layout().ReportKey(time.Now().UTC())
+	opts := internal.ObjectOptions(r.Context(), key, internal.PurposeReport, map[string]string{internal.MetaSites: strings.Join(collectSitesFromItems(req.Items), ",")})
+	if err := internal.SaveObject(r.Context(), pdfBytes, bucket, key, opts); err != nil {
+		recordAudit(r, internal.AuditActionReportGenerate, key, internal.AuditResultFailure, "")
+		writeError(w, err, "failed to upload pdf")
 		return
 	}
-	url, err := internal.GeneratePresignedGetURL(r.Context(), bucket, key, 120*time.Hour)
+	recordAudit(r, internal.AuditActionReportGenerate, key, internal.AuditResultSuccess, "")
+	url, err := internal.GeneratePresignedGetURL(r.Context(), bucket, key, reportURLExpiry)
 	if err != nil {
 		writeJSON(w, http.StatusOK, map[string]string{"s3_key": key})
 		return
 	}
 
 	// Best-effort: write alert tracker record
-	_ = internal.SaveAlertTrackerRecord(r.Context(), map[string]any{
+	record := map[string]any{
 		"gsi_pk":         "recent",
 		"createdon":      time.Now().UTC().UnixMilli(),
 		"alert_id":       fmt.Sprintf("alert-%d", time.Now().UnixMilli()),
-		"alert_name":     "Anomaly Report",
+		"alert_name":     alertName,
 		"s3_signed_url":  url,
 		"severity":       "high",
 		"sites_impacted": collectSitesFromItems(req.Items),
 		"anomaly_date":   guessAnomalyDate(req.Items),
-	})
+	}
+	if len(images) > 0 {
+		record["images"] = images
+	}
+	err = internal.SaveAlertTrackerRecord(r.Context(), record)
+	if err != nil {
+		log.Printf("failed to record alert: %v", err)
+	}
 
 	writeJSON(w, http.StatusOK, map[string]string{"s3_key": key, "url": url})
 }
@@ -380,9 +824,76 @@ func guessAnomalyDate(items []internal.ReportItem) string {
 	return time.Now().UTC().Format(time.RFC3339)
 }
 
-// AnomalyCheckHandler accepts a site and bounding box and performs
+// maxInlineAnomalySites is the largest sweep run inside the request; bigger
+// sweeps are queued for the site worker when SITE_TASK_QUEUE_URL is set.
+const maxInlineAnomalySites = 30
+
+// queueAnomalySweep queues one anomaly task per site, or with SWEEP_SHARDS
+// set one task per shard of sites tracked as a sweep; results are saved to
+// anomaly-evaluations and alerted by the site worker.
+func queueAnomalySweep(w http.ResponseWriter, r *http.Request, sites []string, parameter string) {
+	shards := internal.SweepShards()
+	limit := maxQueuedSites
+	if shards > 0 {
+		limit = internal.MaxShardedSweepSites
+	}
+	if len(sites) > limit {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("too many sites (max %d)", limit)})
+		return
+	}
+	if err := pipeline.ValidateSelection(sites, parameter); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	batchID, err := internal.NewSiteTaskBatchID()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create batch"})
+		return
+	}
+	if shards == 0 {
+		enqueueTasks(w, r, batchID, internal.AnomalyTasks(batchID, sites, parameter), len(sites))
+		return
+	}
+	var actor string
+	if p := PrincipalFrom(r.Context()); p != nil {
+		actor = p.Actor
+	}
+	_, tasks, err := internal.CreateSweep(r.Context(), batchID, sites, parameter, actor)
+	if err != nil {
+		log.Printf("create sweep %s failed: %v", batchID, err)
+		writeError(w, err, "failed to create sweep")
+		return
+	}
+	enqueueTasks(w, r, batchID, tasks, len(sites))
+}
+
+// SweepHandler reports the progress and aggregate results of a sharded
+// anomaly sweep.
+// GET /anomaly/sweeps/{id} -> {"sweep_id":"batch_...","parameter":"00060","sites":2400,"shards_total":16,"shards_done":16,"evaluated":2391,"anomalies":12,"anomalous_sites":[...],"failed_sites":[...],"status":"completed",...}
+func SweepHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	id := r.PathValue("id")
+	s, err := internal.GetSweep(r.Context(), id)
+	switch {
+	case errors.Is(err, internal.ErrSweepNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "sweep not found"})
+	case err != nil:
+		log.Printf("get sweep %s failed: %v", id, err)
+		writeError(w, err, "failed to load sweep")
+	default:
+		writeJSON(w, http.StatusOK, s)
+	}
+}
+
+// AnomalyCheckHandler accepts sites, or a bounding box whose stations are
+// checked instead (see internal.BBoxSites), and performs
 // fetch->preprocess->infer->anomaly detection using a configured threshold.
-// POST JSON body: {"site":"03339000","min_lat":..,"min_lng":..,"max_lat":..,"max_lng":..,"threshold_percent":10}
+// Sweeps over more than maxInlineAnomalySites sites are queued (202).
+// POST JSON body: {"sites":["03339000"],"min_lat":..,"min_lng":..,"max_lat":..,"max_lng":..,"parameter":"00060"}
 func AnomalyCheckHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", http.MethodPost)
@@ -391,29 +902,67 @@ func AnomalyCheckHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req anomalyRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+	if !decodeJSON(w, r, &req) {
 		return
 	}
+	parameter := req.Parameter
+	if parameter == "" {
+		parameter = "00060"
+	}
 	sites := req.Sites
+	box := internal.BBox{MinLng: req.MinLng, MinLat: req.MinLat, MaxLng: req.MaxLng, MaxLat: req.MaxLat}
+	if len(sites) == 0 && !box.IsZero() {
+		found, err := internal.BBoxSites(r.Context(), box, parameter)
+		if err != nil {
+			log.Printf("anomaly check: bbox lookup failed: %v", err)
+			writeError(w, err, "failed to find stations in bbox")
+			return
+		}
+		for _, s := range found {
+			sites = append(sites, s.Site)
+		}
+		if len(sites) == 0 {
+			writeJSON(w, http.StatusOK, anomalyResponse{Items: []anomalyItem{}})
+			return
+		}
+	}
 	if len(sites) == 0 {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing sites"})
 		return
 	}
-	if len(sites) > 30 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "too many sites (max 30)"})
-		return
+	checkAnomalies(w, r, sites, parameter)
+}
+
+// checkAnomalies runs the anomaly check for sites inside the request, saves
+// and alerts the results, and writes them. Each site is checked once however
+// often it is listed. Sweeps over more than maxInlineAnomalySites sites are
+// queued instead.
+func checkAnomalies(w http.ResponseWriter, r *http.Request, sites []string, parameter string) {
+	unique := make([]string, 0, len(sites))
+	seen := map[string]bool{}
+	for _, s := range sites {
+		if s = strings.TrimSpace(s); s != "" && !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
 	}
-	parameter := req.Parameter
-	if parameter == "" {
-		parameter = "00060"
+	sites = unique
+	if len(sites) > maxInlineAnomalySites {
+		if !internal.SiteTaskQueueEnabled() {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("too many sites (max %d)", maxInlineAnomalySites)})
+			return
+		}
+		queueAnomalySweep(w, r, sites, parameter)
+		return
 	}
 
 	items := make([]anomalyItem, 0, len(sites))
+	evals := make([]internal.AnomalyEvaluation, 0, len(sites))
 	for _, site := range sites {
-		site = strings.TrimSpace(site)
-		if site == "" {
-			continue
+		if r.Context().Err() != nil {
+			// The client went away; don't keep calling upstreams for it.
+			log.Printf("anomaly check cancelled after %d of %d sites: %v", len(items), len(sites), r.Context().Err())
+			return
 		}
 		res, err := internal.ProcessInferAndDetect(r.Context(), site, parameter)
 		if err != nil {
@@ -421,8 +970,11 @@ func AnomalyCheckHandler(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		var anomalousReason string
-		if res.Anomalous {
-			anomalousReason = "high discharge"
+		switch {
+		case res.Reason != "":
+			anomalousReason = res.Reason
+		case res.Anomalous:
+			anomalousReason = internal.ProfileFor(parameter).PercentChangeReason(res.ObservedValue, res.PredictedValue)
 		}
 		items = append(items, anomalyItem{
 			Site:            site,
@@ -432,51 +984,95 @@ func AnomalyCheckHandler(w http.ResponseWriter, r *http.Request) {
 			PercentChange:   res.PercentChange,
 			Anomalous:       res.Anomalous,
 			AnomalousReason: anomalousReason,
+			Unit:            res.Unit,
+			Severity:        res.Severity,
+			Percentile:      res.Percentile,
+			LeadTime:        res.LeadTime,
 		})
+		evals = append(evals, internal.AnomalyEvaluation{
+			Site:           site,
+			Parameter:      parameter,
+			S3Key:          res.S3Key,
+			ObservedValue:  res.ObservedValue,
+			PredictedValue: res.PredictedValue,
+			PercentChange:  res.PercentChange,
+			Anomalous:      res.Anomalous,
+			Severity:       res.Severity,
+			Unit:           res.Unit,
+			Reason:         res.Reason,
+			Model:          res.Model,
+			Percentile:     res.Percentile,
+			LeadTime:       res.LeadTime,
+		})
+	}
+
+	// Best-effort: persist all evaluations in one batched write
+	if err := internal.SaveAnomalyEvaluations(r.Context(), evals); err != nil {
+		log.Printf("failed to persist anomaly evaluations: %v", err)
 	}
 
 	// Best-effort: publish one SNS alert covering all anomalous sites
-	{
-		var count int
-		var b strings.Builder
-		for _, it := range items {
-			if it.Anomalous {
-				count++
-				// it.ObservedValue and PredictedValue are strings with 2 decimals
-				fmt.Fprintf(&b, "Site %s anomalous: observed=%s predicted=%s (%.1f%%)\n", it.Site, it.ObservedValue, it.PredictedValue, it.PercentChange)
-			}
-		}
-		if count > 0 {
-			subject := fmt.Sprintf("AquaWatch Anomalies Detected (%d)", count)
-			_ = internal.PublishAlert(r.Context(), subject, b.String())
-		}
+	if err := internal.PublishAnomalies(r.Context(), evals); err != nil {
+		log.Printf("publish anomaly alert failed: %v", err)
 	}
 	writeJSON(w, http.StatusOK, anomalyResponse{Items: items})
 }
 
+// parsePageLimit reads the optional "limit" query param, clamped to (0, max].
+func parsePageLimit(r *http.Request, def, max int) int {
+	limit := def
+	if q := strings.TrimSpace(r.URL.Query().Get("limit")); q != "" {
+		var v int
+		if _, err := fmt.Sscanf(q, "%d", &v); err == nil && v > 0 && v <= max {
+			limit = v
+		}
+	}
+	return limit
+}
+
 // ListAlertsHandler returns alerts from the last N minutes (default 10).
-// GET /alerts?minutes=10
+// With ?site= it returns that gauge's alert history instead, using the
+// site index. Results are paginated: pass next_cursor from the response as
+// ?cursor= to fetch the following page.
+// GET /alerts?minutes=10&limit=200&cursor=...
+// GET /alerts?site=03339000&minutes=10080
 func ListAlertsHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query().Get("minutes")
 	minutes := 10
 	if strings.TrimSpace(q) != "" {
 		var v int
-		if _, err := fmt.Sscanf(q, "%d", &v); err == nil && v > 0 && v <= 1440 {
+		if _, err := fmt.Sscanf(q, "%d", &v); err == nil && v > 0 && v <= 43200 { // up to 30 days
 			minutes = v
 		}
 	}
+	limit := parsePageLimit(r, 200, 1000)
+	cursor := strings.TrimSpace(r.URL.Query().Get("cursor"))
 	since := time.Now().UTC().Add(-time.Duration(minutes) * time.Minute).UnixMilli()
-	items, err := internal.ListRecentAlerts(r.Context(), since, 200)
+	var items []internal.AlertTrackerItem
+	var next string
+	var err error
+	if site := strings.TrimSpace(r.URL.Query().Get("site")); site != "" {
+		items, next, err = internal.ListAlertsBySitePage(r.Context(), site, since, limit, cursor)
+	} else {
+		items, next, err = internal.ListRecentAlertsPage(r.Context(), since, limit, cursor)
+	}
 	if err != nil {
+		if errors.Is(err, internal.ErrInvalidCursor) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+			return
+		}
 		log.Printf("failed to list alerts: %v", err)
-		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "failed to list alerts"})
+		writeError(w, err, "failed to list alerts")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"alerts": items, "since_ms": since})
+	// since_ms moves with every request; the tag covers the page only.
+	writeJSONETag(w, r, map[string]any{"alerts": items, "since_ms": since, "next_cursor": next},
+		map[string]any{"alerts": items, "next_cursor": next})
 }
 
 // ListTrainModelsHandler returns training records from the last N minutes (default 60) in descending order.
-// GET /train/models?minutes=60
+// Supports the same limit/cursor pagination as ListAlertsHandler.
+// GET /train/models?minutes=60&limit=200&cursor=...
 func ListTrainModelsHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query().Get("minutes")
 	minutes := 60
@@ -486,12 +1082,40 @@ func ListTrainModelsHandler(w http.ResponseWriter, r *http.Request) {
 			minutes = v
 		}
 	}
+	limit := parsePageLimit(r, 200, 1000)
+	cursor := strings.TrimSpace(r.URL.Query().Get("cursor"))
 	since := time.Now().UTC().Add(-time.Duration(minutes) * time.Minute).UnixMilli()
-	items, err := internal.ListRecentTrainModels(r.Context(), since, 200)
+	items, next, err := internal.ListRecentTrainModelsPage(r.Context(), since, limit, cursor)
 	if err != nil {
+		if errors.Is(err, internal.ErrInvalidCursor) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+			return
+		}
 		log.Printf("failed to list train models: %v", err)
-		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "failed to list train models"})
+		writeError(w, err, "failed to list train models")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items, "since_ms": since})
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "since_ms": since, "next_cursor": next})
+}
+
+// TrainModelHandler returns one model registry entry, including the dataset
+// snapshot it was trained on.
+// GET /train/models/{uuid} -> TrainModelTrackerItem
+func TrainModelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	uuid := r.PathValue("uuid")
+	model, err := internal.GetTrainModel(r.Context(), uuid)
+	switch {
+	case errors.Is(err, internal.ErrTrainModelNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "model not found"})
+	case err != nil:
+		log.Printf("get train model %s failed: %v", uuid, err)
+		writeError(w, err, "failed to load model")
+	default:
+		writeJSON(w, http.StatusOK, model)
+	}
 }
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+)
+
+// HistoryHandler serves a station's daily values over a range of days,
+// read through the processed history store and backfilled from USGS.
+// GET /history?site=03339000&parameter=00060&start=2025-01-01&end=2025-12-31 ->
+// {"site":"03339000","parameter":"00060","start":"2025-01-01","end":"2025-12-31","points":[{"day":"2025-01-01","value":812.5},...],"stored_months":12,"fetched_months":0}
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	q := r.URL.Query()
+	site := strings.TrimSpace(q.Get("site"))
+	parameter := strings.TrimSpace(q.Get("parameter"))
+	if parameter == "" {
+		parameter = "00060"
+	}
+	if site == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "site is required"})
+		return
+	}
+	if err := pipeline.ValidateSelection([]string{site}, parameter); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	end := time.Now().UTC()
+	if v := q.Get("end"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "end must be a date (YYYY-MM-DD)"})
+			return
+		}
+		end = t
+	}
+	start := end.AddDate(0, 0, -29)
+	if v := q.Get("start"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "start must be a date (YYYY-MM-DD)"})
+			return
+		}
+		start = t
+	}
+
+	history, err := internal.GetHistory(r.Context(), site, parameter, start, end)
+	switch {
+	case errors.Is(err, internal.ErrInvalidHistoryRange):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case err != nil:
+		log.Printf("history %s/%s failed: %v", site, parameter, err)
+		writeError(w, err, "failed to load history")
+	default:
+		// Whether months came from the store or USGS doesn't change the
+		// series.
+		writeJSONETag(w, r, history, history.Points)
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"aquawatch/internal"
+)
+
+// SiteImpactsHandler lists or adds a site's impact statements.
+// GET /stations/{site}/impacts -> {"impacts":[SiteImpact...]}
+// POST /stations/{site}/impacts {"parameter":"00065","threshold":18,"unit":"ft","statement":"Route 9 floods near the bridge"} -> 201 SiteImpact
+func SiteImpactsHandler(w http.ResponseWriter, r *http.Request) {
+	site := r.PathValue("site")
+	switch r.Method {
+	case http.MethodGet:
+		impacts, err := internal.ListSiteImpacts(r.Context(), site)
+		if err != nil {
+			log.Printf("list impacts for %s failed: %v", site, err)
+			writeError(w, err, "failed to list impacts")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"impacts": impacts})
+	case http.MethodPost:
+		var spec internal.ImpactSpec
+		if !decodeJSON(w, r, &spec) {
+			return
+		}
+		var actor string
+		if p := PrincipalFrom(r.Context()); p != nil {
+			actor = p.Actor
+		}
+		im, err := internal.CreateSiteImpact(r.Context(), site, spec, actor)
+		switch {
+		case errors.Is(err, internal.ErrInvalidImpact):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		case err != nil:
+			recordAudit(r, internal.AuditActionImpactCreate, site, internal.AuditResultFailure, "")
+			log.Printf("create impact for %s failed: %v", site, err)
+			writeError(w, err, "failed to create impact")
+		default:
+			recordAudit(r, internal.AuditActionImpactCreate, site+"/"+im.ImpactID, internal.AuditResultSuccess, "")
+			writeJSON(w, http.StatusCreated, im)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// SiteImpactHandler deletes one impact statement.
+// DELETE /stations/{site}/impacts/{id} -> 204
+func SiteImpactHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	site, id := r.PathValue("site"), r.PathValue("id")
+	err := internal.DeleteSiteImpact(r.Context(), site, id)
+	switch {
+	case errors.Is(err, internal.ErrImpactNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "impact not found"})
+	case err != nil:
+		recordAudit(r, internal.AuditActionImpactDelete, site+"/"+id, internal.AuditResultFailure, "")
+		log.Printf("delete impact %s/%s failed: %v", site, id, err)
+		writeError(w, err, "failed to delete impact")
+	default:
+		recordAudit(r, internal.AuditActionImpactDelete, site+"/"+id, internal.AuditResultSuccess, "")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
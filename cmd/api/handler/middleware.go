@@ -0,0 +1,338 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"aquawatch/internal"
+)
+
+// Every route is registered with an AuthPolicy that decides who may call it.
+// Protect runs the policy before the handler and stores the resulting
+// Principal in the request context, where handlers and the audit log read it.
+
+// Authentication methods recorded on a Principal.
+const (
+	AuthMethodNone    = "none"
+	AuthMethodSession = "session"
+	AuthMethodOIDC    = "oidc"
+	AuthMethodVonage  = "vonage"
+	AuthMethodAPIKey  = "api_key"
+	AuthMethodSecret  = "shared_secret"
+	AuthMethodSigned  = "signature"
+	AuthMethodScoped  = "scoped_token"
+)
+
+// RoleAdmin is granted to admin API key callers and to OIDC users in the
+// "admin" group.
+const RoleAdmin = "admin"
+
+// RoleOperator is granted to OIDC users in the "operator" group.
+const RoleOperator = "operator"
+
+var (
+	errUnauthenticated = errors.New("unauthenticated")
+	errForbidden       = errors.New("forbidden")
+	errTooLarge        = errors.New("payload too large")
+)
+
+// authError carries the client-facing message of a failed policy check; it
+// unwraps to errUnauthenticated, errForbidden or, for policies that read the
+// body, errTooLarge.
+type authError struct {
+	kind error
+	msg  string
+}
+
+func (e *authError) Error() string { return e.msg }
+func (e *authError) Unwrap() error { return e.kind }
+
+func unauthenticated(msg string) error { return &authError{kind: errUnauthenticated, msg: msg} }
+func forbidden(msg string) error       { return &authError{kind: errForbidden, msg: msg} }
+
+// payloadTooLarge reports a body over limit, with the message of
+// writeBodyTooLarge.
+func payloadTooLarge(limit int64) error {
+	return &authError{kind: errTooLarge, msg: fmt.Sprintf("request body exceeds %d bytes", limit)}
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Method  string
+	Subject string // session subject (user ID or legacy phone) or "oidc:<sub>"; empty if unknown
+	Actor   string // identity recorded in the audit log
+	Roles   []string
+}
+
+// HasRole reports whether the principal holds role.
+func (p *Principal) HasRole(role string) bool {
+	return p != nil && slices.Contains(p.Roles, role)
+}
+
+type principalKey struct{}
+
+// PrincipalFrom returns the principal stored by Protect, or nil.
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// AuthPolicy authorizes a request, returning the caller on success. It
+// returns errUnauthenticated when credentials are missing or invalid and
+// errForbidden when the caller lacks permission.
+type AuthPolicy func(r *http.Request) (*Principal, error)
+
+// Protect wraps next with policy: 401, 403 or 413 on failure, otherwise next
+// runs with the principal in its context.
+func Protect(policy AuthPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := policy(r)
+		switch {
+		case errors.Is(err, errForbidden):
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+			return
+		case errors.Is(err, errTooLarge):
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+			return
+		case err != nil:
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// Public lets anyone call the route.
+func Public() AuthPolicy {
+	return func(r *http.Request) (*Principal, error) {
+		return &Principal{Method: AuthMethodNone}, nil
+	}
+}
+
+// Session requires a signed-in caller: an X-Session-Token, an OIDC bearer
+// token (when OIDC_ISSUER is set), or Vonage verify headers. With
+// useVonage false, callers without a token are let through anonymously, as
+// before per-route policies existed.
+func Session(useVonage bool) AuthPolicy {
+	return func(r *http.Request) (*Principal, error) {
+		if tok := r.Header.Get("X-Session-Token"); tok != "" {
+			if subject, err := internal.ValidateSessionToken(tok); err == nil {
+				return &Principal{Method: AuthMethodSession, Subject: subject, Actor: subject}, nil
+			}
+		}
+		if tok := BearerToken(r); tok != "" && internal.OIDCEnabled() {
+			claims, err := internal.ValidateOIDCToken(r.Context(), tok)
+			if err != nil {
+				return nil, unauthenticated("invalid bearer token")
+			}
+			return &Principal{
+				Method:  AuthMethodOIDC,
+				Subject: "oidc:" + claims.Subject,
+				Actor:   claims.Identity(),
+				Roles:   claims.Groups,
+			}, nil
+		}
+		if !useVonage {
+			return &Principal{Method: AuthMethodNone}, nil
+		}
+		reqID := r.Header.Get("X-Verify-Request-Id")
+		code := r.Header.Get("X-Verify-Code")
+		if reqID == "" || code == "" {
+			return nil, unauthenticated("missing verification headers")
+		}
+		ok, err := internal.VerifyCheck(r.Context(), reqID, code)
+		if err != nil || !ok {
+			return nil, unauthenticated("verification failed")
+		}
+		return &Principal{Method: AuthMethodVonage}, nil
+	}
+}
+
+// APIKey requires X-Admin-Key to match ADMIN_API_KEY. The caller gets the
+// admin role.
+func APIKey() AuthPolicy {
+	return func(r *http.Request) (*Principal, error) {
+		key := os.Getenv("ADMIN_API_KEY")
+		got := r.Header.Get("X-Admin-Key")
+		if key == "" || got == "" {
+			return nil, unauthenticated("admin key required")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(key)) != 1 {
+			return nil, forbidden("invalid admin key")
+		}
+		return &Principal{Method: AuthMethodAPIKey, Actor: "admin-key", Roles: []string{RoleAdmin}}, nil
+	}
+}
+
+// CallbackSecret requires X-Callback-Secret to match
+// PIPELINE_CALLBACK_SECRET; it guards webhooks called by AWS rather than
+// users (EventBridge API destinations send it as an API key header).
+func CallbackSecret() AuthPolicy {
+	return func(r *http.Request) (*Principal, error) {
+		secret := os.Getenv("PIPELINE_CALLBACK_SECRET")
+		got := r.Header.Get("X-Callback-Secret")
+		if secret == "" || got == "" {
+			return nil, unauthenticated("callback secret required")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+			return nil, forbidden("invalid callback secret")
+		}
+		return &Principal{Method: AuthMethodSecret, Actor: "pipeline-callback"}, nil
+	}
+}
+
+// SignedPayload requires the body to be signed by a source listed in
+// EXTERNAL_INGEST_SECRETS: X-Sensor-Source names it, X-Signature-Timestamp
+// is the signing time (Unix seconds) and X-Signature is
+// internal.ExternalSignature of the body. The body is read here and
+// replaced, so the handler can decode it again; one over
+// internal.MaxExternalPayloadBytes (or the route's body cap) is a 413.
+func SignedPayload() AuthPolicy {
+	return func(r *http.Request) (*Principal, error) {
+		source := r.Header.Get("X-Sensor-Source")
+		signature := r.Header.Get("X-Signature")
+		if source == "" || signature == "" {
+			return nil, unauthenticated("signed payload required")
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, internal.MaxExternalPayloadBytes+1))
+		var capped *http.MaxBytesError
+		if errors.As(err, &capped) {
+			return nil, payloadTooLarge(capped.Limit)
+		}
+		if err != nil {
+			return nil, unauthenticated("failed to read payload")
+		}
+		if len(body) > internal.MaxExternalPayloadBytes {
+			return nil, payloadTooLarge(internal.MaxExternalPayloadBytes)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err := internal.VerifyExternalSignature(source, r.Header.Get("X-Signature-Timestamp"), signature, body, time.Now()); err != nil {
+			return nil, forbidden(err.Error())
+		}
+		return &Principal{Method: AuthMethodSigned, Actor: "external:" + source}, nil
+	}
+}
+
+// scopedEndpoints are the routes a scoped token may be granted, mapped to
+// whether the route reads a "sites" list. Such routes default to the
+// token's sites; the others must name a site.
+var scopedEndpoints = map[string]bool{
+	"/anomaly/latest":        true,
+	"/compare":               true,
+	"/history":               false,
+	"/stations.geojson":      true,
+	"/anomalies.geojson":     true,
+	"/stations/{site}/stats": false,
+	"/alerts":                false,
+	"/alerts/daily":          true,
+	"/prediction/status":     false,
+}
+
+// validateScopedToken checks a scoped token and its revocation record;
+// tests swap it out.
+var validateScopedToken = internal.ValidateScopedToken
+
+// ScopedToken accepts a read-only scoped token in X-Scoped-Token (see
+// internal.MintScopedToken): GET requests to the token's endpoints, for its
+// sites only. Register it only on routes listed in scopedEndpoints.
+func ScopedToken() AuthPolicy {
+	return func(r *http.Request) (*Principal, error) {
+		tok := r.Header.Get("X-Scoped-Token")
+		if tok == "" {
+			return nil, unauthenticated("scoped token required")
+		}
+		claims, err := validateScopedToken(r.Context(), tok)
+		if err != nil {
+			return nil, unauthenticated("invalid scoped token")
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return nil, forbidden("scoped tokens are read-only")
+		}
+		takesSites, ok := scopedEndpoints[r.Pattern]
+		if !ok || !slices.Contains(claims.Endpoints, r.Pattern) {
+			return nil, forbidden("endpoint not allowed for this token")
+		}
+
+		q := r.URL.Query()
+		var sites []string
+		if site := r.PathValue("site"); site != "" {
+			sites = append(sites, site)
+		}
+		for _, key := range []string{"site", "station", "sites"} {
+			for _, v := range q[key] {
+				for _, site := range strings.Split(v, ",") {
+					if site = strings.TrimSpace(site); site != "" {
+						sites = append(sites, site)
+					}
+				}
+			}
+		}
+		switch {
+		case len(sites) == 0 && takesSites:
+			q.Set("sites", strings.Join(claims.Sites, ","))
+			r.URL.RawQuery = q.Encode()
+		case len(sites) == 0:
+			return nil, forbidden("site required for this token")
+		}
+		for _, site := range sites {
+			if !slices.Contains(claims.Sites, site) {
+				return nil, forbidden("site " + site + " not allowed for this token")
+			}
+		}
+		return &Principal{Method: AuthMethodScoped, Subject: claims.Subject, Actor: claims.Subject}, nil
+	}
+}
+
+// Role requires base to succeed and the caller to hold role.
+func Role(base AuthPolicy, role string) AuthPolicy {
+	return func(r *http.Request) (*Principal, error) {
+		p, err := base(r)
+		if err != nil {
+			return nil, err
+		}
+		if !p.HasRole(role) {
+			return nil, forbidden("requires role " + role)
+		}
+		return p, nil
+	}
+}
+
+// AnyOf tries each policy in order and returns the first success. If all
+// fail, the first forbidden error wins over unauthenticated ones, so a
+// recognized caller without permission gets 403 rather than 401.
+func AnyOf(policies ...AuthPolicy) AuthPolicy {
+	return func(r *http.Request) (*Principal, error) {
+		var firstErr error
+		for _, policy := range policies {
+			p, err := policy(r)
+			if err == nil {
+				return p, nil
+			}
+			if firstErr == nil || (errors.Is(err, errForbidden) && !errors.Is(firstErr, errForbidden)) {
+				firstErr = err
+			}
+		}
+		if firstErr == nil {
+			firstErr = errUnauthenticated
+		}
+		return nil, firstErr
+	}
+}
+
+// BearerToken returns the token from an "Authorization: Bearer" header.
+func BearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"aquawatch/internal"
+)
+
+func allow(roles ...string) AuthPolicy {
+	return func(r *http.Request) (*Principal, error) {
+		return &Principal{Method: AuthMethodSession, Actor: "tester", Roles: roles}, nil
+	}
+}
+
+func deny(err error) AuthPolicy {
+	return func(r *http.Request) (*Principal, error) { return nil, err }
+}
+
+// serve runs policy through Protect and returns the response status and the
+// request the handler saw (nil when it didn't run).
+func serve(t *testing.T, policy AuthPolicy, req *http.Request) (int, *http.Request) {
+	t.Helper()
+	var seen *http.Request
+	h := Protect(policy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+		w.WriteHeader(http.StatusOK)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code, seen
+}
+
+func TestAnyOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []AuthPolicy
+		want     int
+	}{
+		{"no policies", nil, http.StatusUnauthorized},
+		{"all unauthenticated", []AuthPolicy{deny(unauthenticated("a")), deny(unauthenticated("b"))}, http.StatusUnauthorized},
+		{"forbidden after unauthenticated", []AuthPolicy{deny(unauthenticated("a")), deny(forbidden("b"))}, http.StatusForbidden},
+		{"forbidden before unauthenticated", []AuthPolicy{deny(forbidden("a")), deny(unauthenticated("b"))}, http.StatusForbidden},
+		{"later success", []AuthPolicy{deny(forbidden("a")), allow()}, http.StatusOK},
+		{"first success", []AuthPolicy{allow(), deny(forbidden("a"))}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := serve(t, AnyOf(tt.policies...), httptest.NewRequest(http.MethodGet, "/", nil))
+			if got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnyOfKeepsFirstForbiddenMessage(t *testing.T) {
+	_, err := AnyOf(deny(unauthenticated("u")), deny(forbidden("first")), deny(forbidden("second")))(httptest.NewRequest(http.MethodGet, "/", nil))
+	if !errors.Is(err, errForbidden) || err.Error() != "first" {
+		t.Errorf("err = %v, want the first forbidden error", err)
+	}
+}
+
+func TestRole(t *testing.T) {
+	tests := []struct {
+		name string
+		base AuthPolicy
+		want int
+	}{
+		{"has role", allow(RoleOperator, RoleAdmin), http.StatusOK},
+		{"lacks role", allow(RoleOperator), http.StatusForbidden},
+		{"no roles", allow(), http.StatusForbidden},
+		{"base unauthenticated", deny(unauthenticated("no token")), http.StatusUnauthorized},
+		{"base forbidden", deny(forbidden("bad key")), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := serve(t, Role(tt.base, RoleAdmin), httptest.NewRequest(http.MethodGet, "/", nil))
+			if got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScopedToken(t *testing.T) {
+	claims := &internal.SessionClaims{
+		Subject:   "scoped:dashboard",
+		Type:      internal.TokenTypeScoped,
+		Sites:     []string{"03339000", "03339500"},
+		Endpoints: []string{"/anomaly/latest", "/history", "/stations/{site}/stats"},
+	}
+	orig := validateScopedToken
+	validateScopedToken = func(ctx context.Context, tok string) (*internal.SessionClaims, error) {
+		if tok != "good" {
+			return nil, internal.ErrInvalidToken
+		}
+		return claims, nil
+	}
+	t.Cleanup(func() { validateScopedToken = orig })
+
+	tests := []struct {
+		name      string
+		method    string
+		pattern   string
+		target    string
+		pathSite  string
+		token     string
+		want      int
+		wantSites string // sites query the handler sees, when checked
+	}{
+		{name: "no token", pattern: "/history", target: "/history?site=03339000", want: http.StatusUnauthorized},
+		{name: "invalid token", pattern: "/history", target: "/history?site=03339000", token: "bad", want: http.StatusUnauthorized},
+		{name: "write method", method: http.MethodPost, pattern: "/history", target: "/history?site=03339000", token: "good", want: http.StatusForbidden},
+		{name: "endpoint not granted", pattern: "/compare", target: "/compare?sites=03339000", token: "good", want: http.StatusForbidden},
+		{name: "endpoint not scopeable", pattern: "/admin/audit", target: "/admin/audit", token: "good", want: http.StatusForbidden},
+		{name: "allowed site", pattern: "/history", target: "/history?site=03339000", token: "good", want: http.StatusOK},
+		{name: "head allowed", method: http.MethodHead, pattern: "/history", target: "/history?station=03339500", token: "good", want: http.StatusOK},
+		{name: "site outside token", pattern: "/history", target: "/history?site=01234567", token: "good", want: http.StatusForbidden},
+		{name: "site required", pattern: "/history", target: "/history", token: "good", want: http.StatusForbidden},
+		{name: "path site allowed", pattern: "/stations/{site}/stats", target: "/stations/03339000/stats", pathSite: "03339000", token: "good", want: http.StatusOK},
+		{name: "path site outside token", pattern: "/stations/{site}/stats", target: "/stations/01234567/stats", pathSite: "01234567", token: "good", want: http.StatusForbidden},
+		{name: "sites list within token", pattern: "/anomaly/latest", target: "/anomaly/latest?sites=03339500", token: "good", want: http.StatusOK, wantSites: "03339500"},
+		{name: "sites list partly outside", pattern: "/anomaly/latest", target: "/anomaly/latest?sites=03339000,01234567", token: "good", want: http.StatusForbidden},
+		{name: "sites default to token", pattern: "/anomaly/latest", target: "/anomaly/latest?limit=5", token: "good", want: http.StatusOK, wantSites: "03339000,03339500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.target, nil)
+			req.Pattern = tt.pattern
+			if tt.pathSite != "" {
+				req.SetPathValue("site", tt.pathSite)
+			}
+			if tt.token != "" {
+				req.Header.Set("X-Scoped-Token", tt.token)
+			}
+			got, seen := serve(t, ScopedToken(), req)
+			if got != tt.want {
+				t.Fatalf("status = %d, want %d", got, tt.want)
+			}
+			if tt.wantSites != "" {
+				if s := seen.URL.Query().Get("sites"); s != tt.wantSites {
+					t.Errorf("sites = %q, want %q", s, tt.wantSites)
+				}
+				if p := PrincipalFrom(seen.Context()); p == nil || p.Method != AuthMethodScoped || p.Actor != claims.Subject {
+					t.Errorf("principal = %+v, want the scoped token's", p)
+				}
+			}
+		})
+	}
+}
+
+func TestScopedTokenDefaultKeepsOtherParams(t *testing.T) {
+	orig := validateScopedToken
+	validateScopedToken = func(ctx context.Context, tok string) (*internal.SessionClaims, error) {
+		return &internal.SessionClaims{Subject: "scoped:x", Sites: []string{"03339000"}, Endpoints: []string{"/alerts/daily"}}, nil
+	}
+	t.Cleanup(func() { validateScopedToken = orig })
+	req := httptest.NewRequest(http.MethodGet, "/alerts/daily?date=2026-01-02", nil)
+	req.Pattern = "/alerts/daily"
+	req.Header.Set("X-Scoped-Token", "t")
+	got, seen := serve(t, ScopedToken(), req)
+	if got != http.StatusOK {
+		t.Fatalf("status = %d, want 200", got)
+	}
+	if q := seen.URL.Query(); q.Get("date") != "2026-01-02" || q.Get("sites") != "03339000" {
+		t.Errorf("query = %q, want date kept and sites set", seen.URL.RawQuery)
+	}
+}
+
+func TestSignedPayload(t *testing.T) {
+	t.Setenv("EXTERNAL_INGEST_SECRETS", "gauge-co=s3cret, city-iot=other")
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	body := []byte(`{"readings":[{"site":"03339000","value":1.5}]}`)
+	big := bytes.Repeat([]byte("x"), internal.MaxExternalPayloadBytes+1)
+
+	tests := []struct {
+		name      string
+		source    string
+		timestamp string
+		signature string
+		body      []byte
+		want      int
+	}{
+		{name: "valid", source: "gauge-co", timestamp: now, signature: internal.ExternalSignature("s3cret", now, body), body: body, want: http.StatusOK},
+		{name: "missing source", timestamp: now, signature: internal.ExternalSignature("s3cret", now, body), body: body, want: http.StatusUnauthorized},
+		{name: "missing signature", source: "gauge-co", timestamp: now, body: body, want: http.StatusUnauthorized},
+		{name: "unknown source", source: "nobody", timestamp: now, signature: internal.ExternalSignature("s3cret", now, body), body: body, want: http.StatusForbidden},
+		{name: "other source's secret", source: "city-iot", timestamp: now, signature: internal.ExternalSignature("s3cret", now, body), body: body, want: http.StatusForbidden},
+		{name: "tampered body", source: "gauge-co", timestamp: now, signature: internal.ExternalSignature("s3cret", now, body), body: []byte(`{"readings":[]}`), want: http.StatusForbidden},
+		{name: "stale timestamp", source: "gauge-co", timestamp: stale, signature: internal.ExternalSignature("s3cret", stale, body), body: body, want: http.StatusForbidden},
+		{name: "too large", source: "gauge-co", timestamp: now, signature: internal.ExternalSignature("s3cret", now, big), body: big, want: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/ingest/external", bytes.NewReader(tt.body))
+			for k, v := range map[string]string{"X-Sensor-Source": tt.source, "X-Signature-Timestamp": tt.timestamp, "X-Signature": tt.signature} {
+				if v != "" {
+					req.Header.Set(k, v)
+				}
+			}
+			got, seen := serve(t, SignedPayload(), req)
+			if got != tt.want {
+				t.Fatalf("status = %d, want %d", got, tt.want)
+			}
+			if got != http.StatusOK {
+				return
+			}
+			replayed, err := io.ReadAll(seen.Body)
+			if err != nil || !bytes.Equal(replayed, tt.body) {
+				t.Errorf("handler read %q (err %v), want the signed body", replayed, err)
+			}
+			if p := PrincipalFrom(seen.Context()); p == nil || p.Actor != "external:"+tt.source {
+				t.Errorf("principal = %+v, want external:%s", p, tt.source)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"aquawatch/internal"
+)
+
+// artifactURLExpiry is how long a presigned artifact download URL stays
+// valid.
+const artifactURLExpiry = 5 * time.Minute
+
+// ModelArtifactHandler returns a short-lived presigned URL to a trained
+// model's model.tar.gz, so data scientists can pull it for offline analysis
+// without AWS access.
+// GET /train/models/{uuid}/artifact -> {"uuid","key","url","expires_in"}
+func ModelArtifactHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "S3_BUCKET not configured"})
+		return
+	}
+	uuid := r.PathValue("uuid")
+	url, key, err := internal.ModelArtifactURL(r.Context(), bucket, uuid, artifactURLExpiry)
+	switch {
+	case errors.Is(err, internal.ErrTrainModelNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "model not found"})
+	case errors.Is(err, internal.ErrModelArtifactUnavailable):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, internal.ErrPresignUnsupported):
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": "downloads not supported by the configured blob store"})
+	case err != nil:
+		log.Printf("presign artifact of %s failed: %v", uuid, err)
+		writeError(w, err, "failed to presign artifact")
+	default:
+		recordAudit(r, internal.AuditActionModelDownload, uuid, internal.AuditResultSuccess, "")
+		writeJSON(w, http.StatusOK, map[string]any{
+			"uuid":       uuid,
+			"key":        key,
+			"url":        url,
+			"expires_in": int(artifactURLExpiry.Seconds()),
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+)
+
+// ModelPerformanceHandler reports a model's prediction errors over time,
+// from the anomaly evaluations its predictions were scored in.
+// GET /models/{id}/performance?days=30&bucket=day&source=stream ->
+// {"model":"aquawatch-run-1","bucket":"day","from_ms":..,"to_ms":..,"overall":{"count":120,"mae":..,"mape":..,"bias":..},"buckets":[{"start_ms":..,"count":4,...}],"sites":[{"site":"03339000","count":..,...}]}
+// id is a train-model-tracker uuid; ?sites= overrides (or, for models not in
+// the tracker such as the default model, supplies) its sites.
+func ModelPerformanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	id := r.PathValue("id")
+	q := r.URL.Query()
+	query := internal.PerformanceQuery{
+		Model:     id,
+		Parameter: strings.TrimSpace(q.Get("parameter")),
+		Source:    strings.TrimSpace(q.Get("source")),
+		Days:      30,
+		Bucket:    internal.PerformanceBucketDay,
+	}
+	if v := q.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("days must be between 1 and %d", internal.MaxPerformanceDays)})
+			return
+		}
+		query.Days = n
+	}
+	if v := strings.TrimSpace(q.Get("bucket")); v != "" {
+		query.Bucket = v
+	}
+	for _, s := range strings.Split(q.Get("sites"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			query.Sites = append(query.Sites, s)
+		}
+	}
+
+	explicit := len(query.Sites) > 0
+	model, err := internal.GetTrainModel(r.Context(), id)
+	switch {
+	case errors.Is(err, internal.ErrTrainModelNotFound) && !explicit:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "model not found"})
+		return
+	case errors.Is(err, internal.ErrTrainModelNotFound):
+	case err != nil:
+		log.Printf("get model %s failed: %v", id, err)
+		writeError(w, err, "failed to load model")
+		return
+	default:
+		if !explicit {
+			query.Sites = model.Sites
+		}
+		query.Parameter = cmp.Or(query.Parameter, model.Parameter)
+	}
+	if explicit {
+		if err := pipeline.ValidateSelection(query.Sites, cmp.Or(query.Parameter, "00060")); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	perf, err := internal.GetModelPerformance(r.Context(), query)
+	switch {
+	case errors.Is(err, internal.ErrInvalidPerformanceQuery):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case err != nil:
+		log.Printf("model %s performance failed: %v", id, err)
+		writeError(w, err, "failed to compute model performance")
+	default:
+		writeJSON(w, http.StatusOK, perf)
+	}
+}
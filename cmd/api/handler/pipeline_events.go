@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"aquawatch/internal"
+)
+
+// runEventsKeepAlive is how often an idle event stream sends a comment, so
+// proxies don't close it.
+const runEventsKeepAlive = 25 * time.Second
+
+// executionStatusEvent is the part of a Step Functions "Execution Status
+// Change" EventBridge event the callback reads.
+type executionStatusEvent struct {
+	ID     string `json:"id"`
+	Detail struct {
+		ExecutionArn string `json:"executionArn"`
+		Status       string `json:"status"`
+	} `json:"detail"`
+}
+
+// PipelineCallbackHandler receives execution status changes forwarded by the
+// aquawatch-pipeline-callback EventBridge rule and settles the run (see
+// internal.CompletePipelineRun).
+// POST /pipeline/callback <EventBridge event> -> {"execution_arn":"...","status":"SUCCEEDED"}
+func PipelineCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	// Not decodeJSON: EventBridge events carry fields the callback ignores.
+	var ev executionStatusEvent
+	if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+		writeBodyError(w, err, "invalid request: execution status event required")
+		return
+	}
+	if ev.Detail.ExecutionArn == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: execution status event required"})
+		return
+	}
+	run, err := internal.CompletePipelineRun(r.Context(), ev.Detail.ExecutionArn)
+	if err != nil {
+		// A 5xx makes EventBridge retry the delivery.
+		log.Printf("pipeline callback %s for %s failed: %v", ev.ID, ev.Detail.ExecutionArn, err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "failed to complete pipeline run"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"execution_arn": run.ExecutionArn, "status": run.Status})
+}
+
+// RunEventsHandler streams finished pipeline runs as server-sent events.
+// GET /events/runs?sites=03339000,03339001 -> text/event-stream of
+// "event: run" messages carrying internal.RunEvent JSON
+// Without sites every run is sent. Only runs completed through this API
+// instance (callbacks it received, or its own express runs) are streamed.
+func RunEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var sites []string
+	for _, s := range strings.Split(r.URL.Query().Get("sites"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			sites = append(sites, s)
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		log.Printf("run events: streaming unsupported: %v", err)
+		return
+	}
+
+	events, cancel := internal.SubscribeRunEvents(sites)
+	defer cancel()
+	ticker := time.NewTicker(runEventsKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case e := <-events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: run\nid: %s\ndata: %s\n\n", e.ExecutionArn, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+const (
+	// ingestEventsPoll is how often an ingest stream reads new progress
+	// events.
+	ingestEventsPoll = 2 * time.Second
+	// ingestStatusPolls is how many progress polls pass between refreshes of
+	// the execution's status from Step Functions.
+	ingestStatusPolls = 5
+)
+
+// IngestEventsHandler streams one execution's progress as server-sent
+// events until it finishes.
+// GET /ingest/{execution}/events -> text/event-stream of
+// "event: progress" (internal.ProgressEvent: fetch done, rows written,
+// training started...), "event: status" ({"status","current_step"}) when the
+// step changes, and a final "event: done" (internal.RunEvent).
+// {execution} is the execution name returned by /ingest, or its ARN.
+// Reconnecting clients send Last-Event-ID to resume after the last
+// progress event they saw.
+func IngestEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	ctx := r.Context()
+	arn, err := internal.ResolveExecutionArn(r.PathValue("execution"))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	run, err := internal.GetExecutionStatus(ctx, arn)
+	switch {
+	case errors.Is(err, internal.ErrPipelineRunNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "execution not found"})
+		return
+	case err != nil:
+		log.Printf("ingest events: status of %s failed: %v", arn, err)
+		writeError(w, err, "failed to load execution")
+		return
+	}
+	after, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		log.Printf("ingest events: streaming unsupported: %v", err)
+		return
+	}
+
+	finished, cancel := internal.SubscribeRunEvents(run.Sites)
+	defer cancel()
+	ticker := time.NewTicker(ingestEventsPoll)
+	defer ticker.Stop()
+	send := func(event, id string, v any) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return
+		}
+		if id != "" {
+			fmt.Fprintf(w, "id: %s\n", id)
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	}
+	sendStatus := func(run *internal.PipelineRun) {
+		send("status", "", map[string]string{"status": run.Status, "current_step": run.CurrentStep})
+	}
+	sendStatus(run)
+	step := run.CurrentStep
+	lastWrite := time.Now()
+	for polls := 0; ; polls++ {
+		events, err := internal.ListProgressEvents(ctx, arn, after)
+		if err != nil {
+			log.Printf("ingest events: progress of %s failed: %v", arn, err)
+		}
+		for _, e := range events {
+			send("progress", strconv.FormatInt(e.Seq, 10), e)
+			after = e.Seq
+		}
+		if len(events) > 0 {
+			lastWrite = time.Now()
+		}
+		if run.Done() {
+			send("done", "", internal.RunEventFrom(run))
+			_ = rc.Flush()
+			return
+		}
+		if time.Since(lastWrite) >= runEventsKeepAlive {
+			fmt.Fprint(w, ": ping\n\n")
+			lastWrite = time.Now()
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-finished:
+			if e.ExecutionArn != arn {
+				continue
+			}
+		case <-ticker.C:
+			if polls%ingestStatusPolls != ingestStatusPolls-1 {
+				continue
+			}
+		}
+		// The run finished or it's time for a refresh: read its status,
+		// then drain the events recorded before it finished.
+		latest, err := internal.GetExecutionStatus(ctx, arn)
+		if err != nil {
+			log.Printf("ingest events: status of %s failed: %v", arn, err)
+			continue
+		}
+		run = latest
+		if run.CurrentStep != step || run.Done() {
+			step = run.CurrentStep
+			sendStatus(run)
+			lastWrite = time.Now()
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"aquawatch/internal"
+)
+
+var pipelineRunStatuses = []string{
+	internal.PipelineRunRunning,
+	internal.PipelineRunSucceeded,
+	internal.PipelineRunFailed,
+	internal.PipelineRunTimedOut,
+	internal.PipelineRunAborted,
+}
+
+// ListPipelineRunsHandler returns past pipeline runs (ingests and trainings),
+// newest first, with their steps, duration and failure cause. Filters are
+// optional: site, status (RUNNING, SUCCEEDED, FAILED, TIMED_OUT, ABORTED) and
+// a start-time range; from/to accept RFC3339 or YYYY-MM-DD (a date "to" covers
+// the whole day). Filtered pages may hold fewer than limit runs; keep
+// following next_cursor until it's empty.
+// GET /pipeline/runs?site=03339000&status=FAILED&from=2026-01-01&to=2026-01-31&limit=50&cursor=...
+func ListPipelineRunsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := internal.PipelineRunFilter{
+		Site:   strings.TrimSpace(q.Get("site")),
+		Status: strings.ToUpper(strings.TrimSpace(q.Get("status"))),
+	}
+	if f.Status != "" && !slices.Contains(pipelineRunStatuses, f.Status) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid status: expected one of " + strings.Join(pipelineRunStatuses, ", ")})
+		return
+	}
+	var err error
+	if f.From, err = parseRunTime(q.Get("from"), false); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid from: expected RFC3339 or YYYY-MM-DD"})
+		return
+	}
+	if f.To, err = parseRunTime(q.Get("to"), true); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid to: expected RFC3339 or YYYY-MM-DD"})
+		return
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && f.To.Before(f.From) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid range: to is before from"})
+		return
+	}
+
+	limit := parsePageLimit(r, 50, 500)
+	cursor := strings.TrimSpace(q.Get("cursor"))
+	runs, next, err := internal.ListPipelineRunsPage(r.Context(), f, limit, cursor)
+	if err != nil {
+		if errors.Is(err, internal.ErrInvalidCursor) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+			return
+		}
+		log.Printf("failed to list pipeline runs: %v", err)
+		writeError(w, err, "failed to list pipeline runs")
+		return
+	}
+	if runs == nil {
+		runs = []internal.PipelineRun{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": runs, "next_cursor": next})
+}
+
+// parseRunTime parses an RFC3339 timestamp or a YYYY-MM-DD date (UTC). With
+// endOfDay a date resolves to its last millisecond. Empty input is the zero
+// time.
+func parseRunTime(v string, endOfDay bool) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Millisecond)
+	}
+	return t, nil
+}
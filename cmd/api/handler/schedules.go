@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"aquawatch/internal"
+)
+
+// SchedulesHandler lists or creates ingest schedules.
+// GET /schedules -> {"schedules":[Schedule...]}
+// POST /schedules {"name":"Ohio daily","sites":["03339000"],"parameter":"00060","cron":"0 6 * * ? *","train_every_days":7} -> 201 Schedule
+func SchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		schedules, err := internal.ListSchedules(r.Context())
+		if err != nil {
+			log.Printf("list schedules failed: %v", err)
+			writeError(w, err, "failed to list schedules")
+			return
+		}
+		if schedules == nil {
+			schedules = []internal.Schedule{}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"schedules": schedules})
+	case http.MethodPost:
+		var spec internal.ScheduleSpec
+		if !decodeJSON(w, r, &spec) {
+			return
+		}
+		var actor string
+		if p := PrincipalFrom(r.Context()); p != nil {
+			actor = p.Actor
+		}
+		s, err := internal.CreateSchedule(r.Context(), spec, actor)
+		switch {
+		case errors.Is(err, internal.ErrInvalidSchedule):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		case err != nil:
+			recordAudit(r, internal.AuditActionScheduleCreate, spec.Name, internal.AuditResultFailure, "")
+			log.Printf("create schedule failed: %v", err)
+			writeError(w, err, "failed to create schedule")
+		default:
+			recordAudit(r, internal.AuditActionScheduleCreate, s.ScheduleID, internal.AuditResultSuccess, "")
+			writeJSON(w, http.StatusCreated, s)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// ScheduleHandler reads, replaces or deletes one schedule.
+// GET /schedules/{id} -> Schedule
+// PUT /schedules/{id} {<same fields as POST /schedules>,"enabled":false,"version":1} -> Schedule
+// DELETE /schedules/{id} -> 204
+// PUT replaces every editable field; a stale version returns 409.
+func ScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		s, err := internal.GetSchedule(ctx, id)
+		switch {
+		case errors.Is(err, internal.ErrScheduleNotFound):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "schedule not found"})
+		case err != nil:
+			log.Printf("get schedule %s failed: %v", id, err)
+			writeError(w, err, "failed to load schedule")
+		default:
+			writeJSON(w, http.StatusOK, s)
+		}
+	case http.MethodPut:
+		var req struct {
+			internal.ScheduleSpec
+			Version *int64 `json:"version"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Version == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: version is required"})
+			return
+		}
+		s, err := internal.UpdateSchedule(ctx, id, req.ScheduleSpec, *req.Version)
+		switch {
+		case errors.Is(err, internal.ErrInvalidSchedule):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		case errors.Is(err, internal.ErrScheduleNotFound):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "schedule not found"})
+		case errors.Is(err, internal.ErrVersionConflict):
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "schedule was modified; reload and retry"})
+		case err != nil:
+			recordAudit(r, internal.AuditActionScheduleUpdate, id, internal.AuditResultFailure, "")
+			log.Printf("update schedule %s failed: %v", id, err)
+			writeError(w, err, "failed to update schedule")
+		default:
+			recordAudit(r, internal.AuditActionScheduleUpdate, id, internal.AuditResultSuccess, "")
+			writeJSON(w, http.StatusOK, s)
+		}
+	case http.MethodDelete:
+		err := internal.DeleteSchedule(ctx, id)
+		switch {
+		case errors.Is(err, internal.ErrScheduleNotFound):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "schedule not found"})
+		case err != nil:
+			recordAudit(r, internal.AuditActionScheduleDelete, id, internal.AuditResultFailure, "")
+			log.Printf("delete schedule %s failed: %v", id, err)
+			writeError(w, err, "failed to delete schedule")
+		default:
+			recordAudit(r, internal.AuditActionScheduleDelete, id, internal.AuditResultSuccess, "")
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+
+	"aquawatch/internal"
+)
+
+// ScopedTokensHandler lists or mints read-only scoped tokens for partner
+// dashboards. The token is returned once, at minting; only its record is
+// kept.
+// GET /admin/tokens -> {"tokens":[ScopedTokenRecord...]}
+// POST /admin/tokens {"label":"County dashboard","sites":["03339000"],"endpoints":["/anomaly/latest"],"expires_in_days":30} ->
+// 201 {"token":"...","id":"...","label":...,"sites":[...],"endpoints":[...],"expires_at":...}
+// Endpoints default to every route that accepts scoped tokens.
+func ScopedTokensHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := internal.ListScopedTokens(r.Context())
+		if err != nil {
+			log.Printf("list scoped tokens failed: %v", err)
+			writeError(w, err, "failed to list tokens")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"tokens": tokens})
+	case http.MethodPost:
+		var spec internal.ScopedTokenSpec
+		if !decodeJSON(w, r, &spec) {
+			return
+		}
+		if len(spec.Endpoints) == 0 {
+			spec.Endpoints = slices.Sorted(maps.Keys(scopedEndpoints))
+		}
+		for _, e := range spec.Endpoints {
+			if _, ok := scopedEndpoints[e]; !ok {
+				writeJSON(w, http.StatusBadRequest, map[string]any{
+					"error":   "endpoint " + e + " does not accept scoped tokens",
+					"allowed": slices.Sorted(maps.Keys(scopedEndpoints)),
+				})
+				return
+			}
+		}
+		var actor string
+		if p := PrincipalFrom(r.Context()); p != nil {
+			actor = p.Actor
+		}
+		token, record, err := internal.MintScopedToken(r.Context(), spec, actor)
+		switch {
+		case errors.Is(err, internal.ErrInvalidScopedToken):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		case err != nil:
+			recordAudit(r, internal.AuditActionTokenMint, spec.Label, internal.AuditResultFailure, "")
+			log.Printf("mint scoped token failed: %v", err)
+			writeError(w, err, "failed to mint token")
+		default:
+			recordAudit(r, internal.AuditActionTokenMint, record.JTI, internal.AuditResultSuccess, "")
+			writeJSON(w, http.StatusCreated, struct {
+				Token string `json:"token"`
+				*internal.ScopedTokenRecord
+			}{token, record})
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// ScopedTokenHandler revokes a scoped token; requests carrying it fail
+// within a minute.
+// DELETE /admin/tokens/{id} -> 204
+func ScopedTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	id := r.PathValue("id")
+	err := internal.RevokeScopedToken(r.Context(), id)
+	switch {
+	case errors.Is(err, internal.ErrScopedTokenNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "token not found"})
+	case err != nil:
+		recordAudit(r, internal.AuditActionTokenRevoke, id, internal.AuditResultFailure, "")
+		log.Printf("revoke scoped token %s failed: %v", id, err)
+		writeError(w, err, "failed to revoke token")
+	default:
+		recordAudit(r, internal.AuditActionTokenRevoke, id, internal.AuditResultSuccess, "")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
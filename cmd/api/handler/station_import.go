@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"aquawatch/internal"
+)
+
+// StationImportHandler onboards stations in bulk: validates them against the
+// USGS site service, adds them to a watchlist and backfills the new ones.
+// POST /stations/import {"sites":["03339000","03339500"],"watchlist":"default","parameter":"00060","backfill_days":365} -> 202 StationImport
+// POST /stations/import {"state":"IL"} or {"huc":"07120001"} imports every active stream station of the region.
+// POST /stations/import?watchlist=default&backfill_days=365 with Content-Type text/csv takes site IDs from
+// the first column; a header row is skipped.
+// The report lists each site as added, existing, invalid, not_found or failed; poll
+// GET /station-imports/{id} for backfill progress.
+func StationImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	var spec internal.StationImportSpec
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		sites, err := readSiteCSV(r.Body)
+		if err != nil {
+			writeBodyError(w, err, "invalid CSV body")
+			return
+		}
+		q := r.URL.Query()
+		spec = internal.StationImportSpec{Sites: sites, Watchlist: q.Get("watchlist"), Parameter: q.Get("parameter")}
+		if v := q.Get("backfill"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "backfill must be true or false"})
+				return
+			}
+			spec.Backfill = &b
+		}
+		if v := q.Get("backfill_days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "backfill_days must be an integer"})
+				return
+			}
+			spec.BackfillDays = n
+		}
+	} else if !decodeJSON(w, r, &spec) {
+		return
+	}
+	var actor string
+	if p := PrincipalFrom(r.Context()); p != nil {
+		actor = p.Actor
+	}
+	imp, err := internal.ImportStations(r.Context(), spec, actor)
+	switch {
+	case errors.Is(err, internal.ErrInvalidStationImport):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case err != nil:
+		recordAudit(r, internal.AuditActionStationImport, "", internal.AuditResultFailure, "")
+		log.Printf("station import failed: %v", err)
+		writeError(w, err, "failed to import stations")
+	default:
+		recordAudit(r, internal.AuditActionStationImport, imp.ImportID, internal.AuditResultSuccess, "")
+		writeJSON(w, http.StatusAccepted, imp)
+	}
+}
+
+// readSiteCSV returns the first column of a CSV body, skipping a header
+// row (one whose first cell isn't numeric).
+func readSiteCSV(body io.Reader) ([]string, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var sites []string
+	for i, rec := range records {
+		site := strings.TrimSpace(rec[0])
+		if i == 0 && strings.Trim(site, "0123456789") != "" {
+			continue
+		}
+		sites = append(sites, site)
+	}
+	return sites, nil
+}
+
+// StationImportReportHandler reports an import with the progress of its
+// backfills.
+// GET /station-imports/{id} -> StationImport (status, sites, backfill.chunks_total, backfill.chunks_done, ...)
+func StationImportReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	id := r.PathValue("id")
+	imp, err := internal.GetStationImport(r.Context(), id)
+	switch {
+	case errors.Is(err, internal.ErrStationImportNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "station import not found"})
+	case err != nil:
+		log.Printf("get station import %s failed: %v", id, err)
+		writeError(w, err, "failed to load station import")
+	default:
+		writeJSON(w, http.StatusOK, imp)
+	}
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+)
+
+// StationParametersHandler lists the parameters a station reports, from the
+// USGS series catalog, so clients only offer parameters it can ingest.
+// GET /stations/{site}/parameters ->
+// {"site":"03339000","parameters":[{"parameter":"00060","data_types":["dv","iv"],"begin_date":"1938-10-01","end_date":"2026-01-31"},...]}
+// 404 when the catalog has no daily or instantaneous series for the station.
+func StationParametersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	site := r.PathValue("site")
+	if err := pipeline.ValidateSelection([]string{site}, "00060"); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	catalogs, err := internal.SiteParameters(r.Context(), []string{site})
+	if err != nil {
+		log.Printf("series catalog of %s failed: %v", site, err)
+		writeError(w, err, "failed to load station parameters")
+		return
+	}
+	params := catalogs[site]
+	if len(params) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "station not found or reports no daily or instantaneous values"})
+		return
+	}
+	// The catalog changes at most daily.
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	writeJSONETag(w, r, map[string]any{"site": site, "parameters": params}, nil)
+}
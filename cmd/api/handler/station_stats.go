@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+)
+
+// StationStatsHandler returns a station's precomputed rolling statistics.
+// GET /stations/{site}/stats?parameter=00060 ->
+// {"site":"03339000","parameter":"00060","windows":{"30d":{"days":30,"mean":..,"std":..,"p50":..},...},"latest_day":"2026-01-31",...}
+// 404 until an ingest or backfill has covered the station.
+func StationStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	site := r.PathValue("site")
+	parameter := strings.TrimSpace(r.URL.Query().Get("parameter"))
+	if parameter == "" {
+		parameter = "00060"
+	}
+	if err := pipeline.ValidateSelection([]string{site}, parameter); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	stats, err := internal.GetStationStats(r.Context(), site, parameter)
+	switch {
+	case errors.Is(err, internal.ErrStationStatsNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no statistics for this station and parameter yet"})
+	case err != nil:
+		log.Printf("get station stats %s/%s failed: %v", site, parameter, err)
+		writeError(w, err, "failed to load station statistics")
+	default:
+		writeJSONETag(w, r, stats, nil)
+	}
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+)
+
+// maxStationLookup bounds the stations of one /stations request.
+const maxStationLookup = 100
+
+// StationsHandler resolves station numbers to what the USGS site service
+// knows about them, so clients can show names instead of numbers.
+// GET /stations?ids=03339000,03335500 ->
+// {"stations":[{"site":"03339000","name":"VERMILION RIVER NEAR DANVILLE, IL","latitude":40.1,"longitude":-87.6,"huc":"05120109","state":"IL","parameters":[...]}],"not_found":["03335500"]}
+// ids may also be repeated. Stations the service doesn't know are listed
+// under not_found.
+func StationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	var ids []string
+	for _, v := range r.URL.Query()["ids"] {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" && !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "ids required"})
+		return
+	}
+	if len(ids) > maxStationLookup {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "too many ids (max 100)"})
+		return
+	}
+	if err := pipeline.ValidateSelection(ids, "00060"); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	stations, err := internal.GetSiteMetadata(r.Context(), ids)
+	if err != nil {
+		log.Printf("site metadata of %s failed: %v", strings.Join(ids, ","), err)
+		writeError(w, err, "failed to load stations")
+		return
+	}
+	notFound := []string{}
+	for _, id := range ids {
+		if !slices.ContainsFunc(stations, func(s internal.SiteMetadata) bool { return s.Site == id }) {
+			notFound = append(notFound, id)
+		}
+	}
+	// Site metadata changes at most daily.
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	writeJSONETag(w, r, map[string]any{"stations": stations, "not_found": notFound}, nil)
+}
+
+// maxStationSearchResults bounds the stations of one /stations/search
+// response; denser viewports are truncated.
+const maxStationSearchResults = 1000
+
+// StationSearchHandler lists the stations inside a map viewport that can be
+// monitored for a parameter (active stream gages with daily values), so
+// users can pick sites before running anomaly checks.
+// GET /stations/search?bbox=-88.5,39.8,-87.5,40.5&parameter=00060 ->
+// {"bbox":{"min_lng":-88.5,...},"parameter":"00060","stations":[{"site":"03339000","name":"...","latitude":40.1,"longitude":-87.6}],"truncated":false}
+// 400 for a malformed box or one over 25 square degrees.
+func StationSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("bbox") == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "bbox required (minLng,minLat,maxLng,maxLat)"})
+		return
+	}
+	box, err := internal.ParseBBox(q.Get("bbox"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	parameter := strings.TrimSpace(q.Get("parameter"))
+	if parameter == "" {
+		parameter = "00060"
+	}
+	if err := pipeline.ValidateParameter(parameter); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	stations, err := internal.BBoxSites(r.Context(), box, parameter)
+	if err != nil {
+		log.Printf("station search %s failed: %v", q.Get("bbox"), err)
+		writeError(w, err, "failed to search stations")
+		return
+	}
+	truncated := len(stations) > maxStationSearchResults
+	if truncated {
+		stations = stations[:maxStationSearchResults]
+	}
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	writeJSONETag(w, r, map[string]any{"bbox": box, "parameter": parameter, "stations": stations, "truncated": truncated}, nil)
+}
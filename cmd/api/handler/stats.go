@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"aquawatch/internal"
+)
+
+// AnomalyStatsHandler returns anomaly and alert counts over time from the
+// nightly rollups, for the analytics page. from and to default to the 30
+// days ending today (UTC); interval is day (default), week or month.
+// GET /stats/anomalies?from=2026-01-01&to=2026-03-31&interval=week&sites=03339000,03339500 ->
+// {"from":"2026-01-01","to":"2026-03-31","interval":"week","series":[{"period":"2025-12-29","evaluations":..,"anomalies":..,"anomalies_by_severity":{"high":2},"alerts":..,"alerts_by_severity":{..}},...],"totals":{..},"days_rolled_up":89}
+func AnomalyStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	q := r.URL.Query()
+	to := strings.TrimSpace(q.Get("to"))
+	if to == "" {
+		to = time.Now().UTC().Format(time.DateOnly)
+	}
+	from := strings.TrimSpace(q.Get("from"))
+	if from == "" {
+		end, err := time.Parse(time.DateOnly, to)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "to must be YYYY-MM-DD"})
+			return
+		}
+		from = end.AddDate(0, 0, -29).Format(time.DateOnly)
+	}
+	var sites []string
+	seen := map[string]bool{}
+	for _, s := range strings.Split(q.Get("sites"), ",") {
+		if s = strings.TrimSpace(s); s != "" && !seen[s] {
+			seen[s] = true
+			sites = append(sites, s)
+		}
+	}
+
+	stats, err := internal.GetAnomalyStats(r.Context(), from, to, sites, strings.TrimSpace(q.Get("interval")))
+	switch {
+	case errors.Is(err, internal.ErrInvalidStatsRange):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case err != nil:
+		log.Printf("anomaly stats %s..%s failed: %v", from, to, err)
+		writeError(w, err, "failed to load anomaly stats")
+	default:
+		writeJSONETag(w, r, stats, nil)
+	}
+}
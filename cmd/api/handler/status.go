@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"aquawatch/internal"
+)
+
+// StatusHandler serves the public status page summary: overall status,
+// monitored stations, active alerts, the last successful ingest and whether
+// each data source answers. It needs no credentials and exposes no site
+// data; the summary is recomputed at most once a minute.
+// GET /status -> SystemStatus
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	status, err := internal.GetSystemStatus(r.Context())
+	if err != nil {
+		log.Printf("system status failed: %v", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "status unavailable"})
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(internal.StatusMaxAge/time.Second)))
+	writeJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"aquawatch/internal"
+)
+
+// subscriptionColumns are the CSV columns of exported subscriptions.
+var subscriptionColumns = []string{"channel", "endpoint", "watchlist", "min_severity", "status", "secret"}
+
+// SubscriptionsExportHandler exports every alert subscription (email
+// subscribers, SMS users, watchlist webhooks) for another environment or a
+// restore.
+// GET /admin/subscriptions -> {"subscriptions":[{"channel":"email","endpoint":"ops@example.com","status":"confirmed"},{"channel":"sms","endpoint":"+15551234567","min_severity":"medium"},{"channel":"webhook","endpoint":"https://...","watchlist":"default","min_severity":"high"}]}
+// GET /admin/subscriptions?format=csv -> the same rows as CSV with a header row.
+// channel, watchlist and status narrow the export (see SubscriptionFilter).
+// Webhook secrets are left out unless include_secrets=true.
+func SubscriptionsExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "format must be json or csv"})
+		return
+	}
+	filter := internal.SubscriptionFilter{
+		Channel:   strings.TrimSpace(q.Get("channel")),
+		Watchlist: strings.TrimSpace(q.Get("watchlist")),
+		Status:    strings.TrimSpace(q.Get("status")),
+	}
+	if err := filter.Validate(); err != nil {
+		writeError(w, err, "invalid filter")
+		return
+	}
+	includeSecrets := isTruthy(q.Get("include_secrets"))
+	subs, err := internal.ExportSubscriptions(r.Context(), filter, includeSecrets)
+	if err != nil {
+		recordAudit(r, internal.AuditActionSubscriptionsExport, "", internal.AuditResultFailure, "")
+		log.Printf("subscription export failed: %v", err)
+		writeError(w, err, "failed to export subscriptions")
+		return
+	}
+	resource := ""
+	if includeSecrets {
+		resource = "secrets"
+	}
+	recordAudit(r, internal.AuditActionSubscriptionsExport, resource, internal.AuditResultSuccess, "")
+	w.Header().Set("Cache-Control", "no-store")
+	if format != "csv" {
+		writeJSON(w, http.StatusOK, map[string]any{"subscriptions": subs})
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="subscriptions.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write(subscriptionColumns)
+	for _, s := range subs {
+		cw.Write([]string{s.Channel, s.Endpoint, s.Watchlist, s.MinSeverity, s.Status, s.Secret})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("writing subscription export failed: %v", err)
+	}
+}
+
+// SubscriptionsImportHandler applies exported subscriptions, in the JSON or
+// CSV shape of GET /admin/subscriptions, without removing any.
+// POST /admin/subscriptions/import {"subscriptions":[...]} -> SubscriptionImport
+// POST /admin/subscriptions/import with Content-Type text/csv takes the export's
+// header row; unknown columns are ignored.
+// Each subscription is reported as added, updated, existing, skipped, invalid
+// or failed; the import stops early only when the list is empty or too long.
+func SubscriptionsImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	var req struct {
+		Subscriptions []internal.SubscriptionRecord `json:"subscriptions"`
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		subs, err := readSubscriptionCSV(r.Body)
+		if err != nil {
+			writeBodyError(w, err, "invalid CSV body: "+err.Error())
+			return
+		}
+		req.Subscriptions = subs
+	} else if !decodeJSON(w, r, &req) {
+		return
+	}
+	var actor string
+	if p := PrincipalFrom(r.Context()); p != nil {
+		actor = p.Actor
+	}
+	imp, err := internal.ImportSubscriptions(r.Context(), req.Subscriptions, actor)
+	switch {
+	case errors.Is(err, internal.ErrInvalidSubscriptionImport):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case err != nil:
+		recordAudit(r, internal.AuditActionSubscriptionsImport, "", internal.AuditResultFailure, "")
+		log.Printf("subscription import failed: %v", err)
+		writeError(w, err, "failed to import subscriptions")
+	default:
+		recordAudit(r, internal.AuditActionSubscriptionsImport, "", internal.AuditResultSuccess, "")
+		writeJSON(w, http.StatusOK, imp)
+	}
+}
+
+// readSubscriptionCSV reads subscriptions from a CSV body whose header row
+// names the columns (see subscriptionColumns); channel and endpoint are
+// required.
+func readSubscriptionCSV(body io.Reader) ([]internal.SubscriptionRecord, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	cols := map[string]int{}
+	for i, name := range records[0] {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"channel", "endpoint"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("header row has no %s column", name)
+		}
+	}
+	col := func(rec []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	subs := make([]internal.SubscriptionRecord, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		subs = append(subs, internal.SubscriptionRecord{
+			Channel:     col(rec, "channel"),
+			Endpoint:    col(rec, "endpoint"),
+			Watchlist:   col(rec, "watchlist"),
+			MinSeverity: col(rec, "min_severity"),
+			Status:      col(rec, "status"),
+			Secret:      col(rec, "secret"),
+		})
+	}
+	return subs, nil
+}
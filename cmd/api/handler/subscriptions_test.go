@@ -0,0 +1,17 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSubscriptionsExportRejectsBadFilters(t *testing.T) {
+	for _, query := range []string{"channel=fax", "watchlist=Not%20Valid", "status=active", "format=xml"} {
+		rec := httptest.NewRecorder()
+		SubscriptionsExportHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/subscriptions?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"aquawatch/internal"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// uploadURLExpiry is how long a presigned upload URL stays valid.
+const uploadURLExpiry = 15 * time.Minute
+
+// uploadImageTypes maps accepted image content types to key extensions.
+var uploadImageTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+}
+
+// PresignUploadHandler returns a presigned PUT URL the client uploads a report
+// image to, so large images don't travel as base64 in /report/pdf bodies.
+// POST /uploads/presign {"content_type":"image/png"} -> {"key","url","expires_in","headers"}
+func PresignUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	var req struct {
+		ContentType string `json:"content_type"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	contentType := strings.ToLower(strings.TrimSpace(req.ContentType))
+	ext, ok := uploadImageTypes[contentType]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "content_type must be image/png or image/jpeg"})
+		return
+	}
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "S3_BUCKET not configured"})
+		return
+	}
+	// /report/pdf only accepts image keys under the layout's upload prefix
+	key := internal.Layout().UploadImageKey(time.Now().UTC(), ext)
+	url, err := internal.GeneratePresignedPutURL(r.Context(), bucket, key, contentType, uploadURLExpiry)
+	if err != nil {
+		if errors.Is(err, internal.ErrPresignUnsupported) {
+			writeJSON(w, http.StatusNotImplemented, map[string]string{"error": "uploads not supported by the configured blob store"})
+			return
+		}
+		log.Printf("presign upload failed: %v", err)
+		writeError(w, err, "failed to presign upload")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"key":        key,
+		"url":        url,
+		"method":     http.MethodPut,
+		"expires_in": int(uploadURLExpiry.Seconds()),
+		"headers":    map[string]string{"Content-Type": contentType},
+	})
+}
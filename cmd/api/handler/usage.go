@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"aquawatch/internal"
+)
+
+// UsageHandler summarizes a month of metered calls (SageMaker invocations,
+// Foxit conversions, Vonage/Twilio verifications) with their estimated cost,
+// per service, per caller and per day. Routed behind the admin policy.
+// GET /admin/usage?month=2026-01 (default: the current UTC month)
+func UsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	month := strings.TrimSpace(r.URL.Query().Get("month"))
+	if month == "" {
+		month = time.Now().UTC().Format("2006-01")
+	}
+	if _, err := time.Parse("2006-01", month); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "month must be YYYY-MM"})
+		return
+	}
+	summary, err := internal.SummarizeUsage(r.Context(), month)
+	if err != nil {
+		log.Printf("failed to summarize usage: %v", err)
+		writeError(w, err, "failed to load usage")
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"aquawatch/internal"
+)
+
+// currentUser resolves the signed-in user from the request principal.
+// Legacy sessions bound to a bare phone are mapped to (and, on first use,
+// create) the user linked to that phone. Callers without a subject, such as
+// Vonage-header or anonymous requests, get errUnauthenticated.
+func currentUser(r *http.Request) (*internal.User, error) {
+	p := PrincipalFrom(r.Context())
+	if p == nil || p.Subject == "" {
+		return nil, errUnauthenticated
+	}
+	return internal.UserForSubject(r.Context(), p.Subject)
+}
+
+// MeHandler reads or updates the caller's profile.
+// GET /me -> User
+// PUT /me {"display_name":"...","notifications":{"sms":true,"email":false,"min_severity":"high"},"default_watchlist":["03339000"],"units":"imperial","version":3} -> User
+// Omitted fields are unchanged. version is the profile version last read;
+// a stale version returns 409.
+func MeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "GET, PUT")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	user, err := currentUser(r)
+	if errors.Is(err, errUnauthenticated) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "sign-in required"})
+		return
+	}
+	if errors.Is(err, internal.ErrUserNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("resolve user failed: %v", err)
+		writeError(w, err, "failed to load user")
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, user)
+		return
+	}
+
+	var req struct {
+		internal.UserProfileUpdate
+		Version *int64 `json:"version"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Version == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: version is required"})
+		return
+	}
+	updated, err := internal.UpdateUserProfile(r.Context(), user.UserID, req.UserProfileUpdate, *req.Version)
+	switch {
+	case errors.Is(err, internal.ErrInvalidProfile):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, internal.ErrVersionConflict):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "profile was modified; reload and retry"})
+	case errors.Is(err, internal.ErrUserNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
+	case err != nil:
+		log.Printf("update profile %s failed: %v", user.UserID, err)
+		writeError(w, err, "failed to update profile")
+	default:
+		writeJSON(w, http.StatusOK, updated)
+	}
+}
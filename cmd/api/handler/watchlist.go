@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"aquawatch/internal"
+)
+
+// WatchlistHandler lists the sites of one watchlist. Archived sites are
+// left out unless include_archived=true.
+// GET /watchlists/default -> {"watchlist":"default","sites":[{"site":"03339000","name":"...","addedon_ms":...,"import_id":"imp_..."}]}
+// GET /watchlists/default?include_archived=true also lists archived sites, with archivedon_ms and archived_by.
+func WatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	name := r.PathValue("name")
+	if err := internal.ValidateWatchlistName(name); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	entries, err := internal.ListWatchlist(r.Context(), name)
+	if err != nil {
+		log.Printf("list watchlist %s failed: %v", name, err)
+		writeError(w, err, "failed to list watchlist")
+		return
+	}
+	if r.URL.Query().Get("include_archived") != "true" {
+		active := entries[:0]
+		for _, e := range entries {
+			if !e.Archived() {
+				active = append(active, e)
+			}
+		}
+		entries = active
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"watchlist": name, "sites": entries})
+}
+
+// WatchlistSiteHandler removes a site from a watchlist by archiving it: the
+// site leaves monitoring and sweeps, but its history stays queryable.
+// DELETE /watchlists/{name}/sites/{site} -> WatchlistEntry with archivedon_ms
+func WatchlistSiteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	var actor string
+	if p := PrincipalFrom(r.Context()); p != nil {
+		actor = p.Actor
+	}
+	updateWatchlistSite(w, r, internal.AuditActionWatchlistArchive, func(name, site string) (*internal.WatchlistEntry, error) {
+		return internal.ArchiveWatchlistSite(r.Context(), name, site, actor)
+	})
+}
+
+// WatchlistSiteRestoreHandler returns an archived site to monitoring.
+// POST /watchlists/{name}/sites/{site}/restore -> WatchlistEntry
+func WatchlistSiteRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	updateWatchlistSite(w, r, internal.AuditActionWatchlistRestore, func(name, site string) (*internal.WatchlistEntry, error) {
+		return internal.RestoreWatchlistSite(r.Context(), name, site)
+	})
+}
+
+func updateWatchlistSite(w http.ResponseWriter, r *http.Request, action string, update func(name, site string) (*internal.WatchlistEntry, error)) {
+	name, site := r.PathValue("name"), r.PathValue("site")
+	if err := internal.ValidateWatchlistName(name); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	resource := name + "/" + site
+	e, err := update(name, site)
+	switch {
+	case errors.Is(err, internal.ErrWatchlistSiteNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "site not found on watchlist"})
+	case err != nil:
+		recordAudit(r, action, resource, internal.AuditResultFailure, "")
+		log.Printf("%s %s failed: %v", action, resource, err)
+		writeError(w, err, "failed to update watchlist")
+	default:
+		recordAudit(r, action, resource, internal.AuditResultSuccess, "")
+		writeJSON(w, http.StatusOK, e)
+	}
+}
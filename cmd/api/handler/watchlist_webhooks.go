@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"aquawatch/internal"
+)
+
+// WatchlistWebhookHandler reads, sets or removes a watchlist's automation
+// webhook, called with the watchlist's anomalies at or above min_severity.
+// GET /watchlists/{name}/webhook -> WatchlistWebhook
+// PUT /watchlists/{name}/webhook {"url":"https://...","min_severity":"medium","rotate_secret":false} -> WatchlistWebhook, plus "secret" when one was generated
+// DELETE /watchlists/{name}/webhook -> 204
+func WatchlistWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := internal.ValidateWatchlistName(name); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		hook, err := internal.GetWatchlistWebhook(r.Context(), name)
+		switch {
+		case errors.Is(err, internal.ErrWebhookNotFound):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "webhook not found"})
+		case err != nil:
+			log.Printf("get webhook of %s failed: %v", name, err)
+			writeError(w, err, "failed to load webhook")
+		default:
+			writeJSON(w, http.StatusOK, hook)
+		}
+	case http.MethodPut:
+		var spec internal.WatchlistWebhookSpec
+		if !decodeJSON(w, r, &spec) {
+			return
+		}
+		var actor string
+		if p := PrincipalFrom(r.Context()); p != nil {
+			actor = p.Actor
+		}
+		hook, secret, err := internal.SetWatchlistWebhook(r.Context(), name, spec, actor)
+		switch {
+		case errors.Is(err, internal.ErrInvalidWebhook):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		case err != nil:
+			recordAudit(r, internal.AuditActionWebhookUpdate, name, internal.AuditResultFailure, "")
+			log.Printf("set webhook of %s failed: %v", name, err)
+			writeError(w, err, "failed to save webhook")
+		default:
+			recordAudit(r, internal.AuditActionWebhookUpdate, name, internal.AuditResultSuccess, "")
+			if secret == "" {
+				writeJSON(w, http.StatusOK, hook)
+				return
+			}
+			writeJSON(w, http.StatusOK, struct {
+				*internal.WatchlistWebhook
+				Secret string `json:"secret"`
+			}{hook, secret})
+		}
+	case http.MethodDelete:
+		err := internal.DeleteWatchlistWebhook(r.Context(), name)
+		switch {
+		case errors.Is(err, internal.ErrWebhookNotFound):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "webhook not found"})
+		case err != nil:
+			recordAudit(r, internal.AuditActionWebhookDelete, name, internal.AuditResultFailure, "")
+			log.Printf("delete webhook of %s failed: %v", name, err)
+			writeError(w, err, "failed to delete webhook")
+		default:
+			recordAudit(r, internal.AuditActionWebhookDelete, name, internal.AuditResultSuccess, "")
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// WebhookDeliveriesHandler lists a watchlist's webhook deliveries with their
+// status, newest first.
+// GET /watchlists/{name}/webhook/deliveries?limit=50&cursor=... -> {"deliveries":[{"seq":..,"severity":"high","sites":[...],"status":"delivered","attempts":1,"status_code":200,...}],"next_cursor":""}
+func WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	name := r.PathValue("name")
+	if err := internal.ValidateWatchlistName(name); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	limit := parsePageLimit(r, 50, 500)
+	cursor := strings.TrimSpace(r.URL.Query().Get("cursor"))
+	items, next, err := internal.ListWebhookDeliveries(r.Context(), name, limit, cursor)
+	if err != nil {
+		if errors.Is(err, internal.ErrInvalidCursor) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+			return
+		}
+		log.Printf("list webhook deliveries of %s failed: %v", name, err)
+		writeError(w, err, "failed to list deliveries")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deliveries": items, "next_cursor": next})
+}
+
+// WebhookRedeliverHandler sends a recorded delivery again, with the same
+// payload and delivery ID, to the watchlist's current webhook.
+// POST /watchlists/{name}/webhook/deliveries/{seq}/redeliver -> WebhookDelivery with the new status
+func WebhookRedeliverHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	name := r.PathValue("name")
+	if err := internal.ValidateWatchlistName(name); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	seq, err := strconv.ParseInt(r.PathValue("seq"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "delivery not found"})
+		return
+	}
+	resource := name + "/" + r.PathValue("seq")
+	d, err := internal.RedeliverWebhook(r.Context(), name, seq)
+	switch {
+	case errors.Is(err, internal.ErrWebhookDeliveryNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "delivery not found"})
+	case errors.Is(err, internal.ErrWebhookNotFound):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "watchlist has no webhook"})
+	case err != nil:
+		recordAudit(r, internal.AuditActionWebhookRedeliver, resource, internal.AuditResultFailure, "")
+		log.Printf("redeliver %s failed: %v", resource, err)
+		writeError(w, err, "failed to redeliver")
+	default:
+		result := internal.AuditResultSuccess
+		if d.Status != internal.WebhookDeliveryDelivered {
+			result = internal.AuditResultFailure
+		}
+		recordAudit(r, internal.AuditActionWebhookRedeliver, resource, result, "")
+		writeJSON(w, http.StatusOK, d)
+	}
+}
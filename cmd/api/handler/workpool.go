@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Heavy endpoints (/anomaly/check, /report/pdf) call SageMaker, Foxit and
+// USGS for seconds at a time. They run on a fixed pool of workers instead of
+// their own goroutines, so a burst of dashboard users queues up rather than
+// fanning out into unbounded upstream calls: WORK_POOL_WORKERS requests
+// (default 4) run at once, up to WORK_POOL_QUEUE more (default 16) wait for
+// a worker, and the rest are rejected with 429.
+
+// workPoolRetryAfterSeconds is the Retry-After sent with a 429.
+const workPoolRetryAfterSeconds = 5
+
+// Job states; a queued job is either started by a worker or abandoned by
+// its client, whichever happens first.
+const (
+	jobQueued int32 = iota
+	jobRunning
+	jobAbandoned
+)
+
+type poolJob struct {
+	run      func()
+	state    atomic.Int32
+	done     chan struct{}
+	panicked any
+}
+
+type workPool struct {
+	jobs    chan *poolJob
+	workers int
+	running atomic.Int64
+}
+
+var (
+	heavyPoolOnce sync.Once
+	heavyPool     *workPool
+)
+
+func getHeavyPool() *workPool {
+	heavyPoolOnce.Do(func() {
+		heavyPool = newWorkPool(envInt("WORK_POOL_WORKERS", 4), envInt("WORK_POOL_QUEUE", 16))
+		log.Printf("work pool: %d workers, queue %d", heavyPool.workers, cap(heavyPool.jobs))
+	})
+	return heavyPool
+}
+
+func newWorkPool(workers, queue int) *workPool {
+	p := &workPool{jobs: make(chan *poolJob, max(queue, 0)), workers: max(workers, 1)}
+	for range p.workers {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workPool) work() {
+	for j := range p.jobs {
+		if !j.state.CompareAndSwap(jobQueued, jobRunning) {
+			continue // the client gave up while the job was queued
+		}
+		p.running.Add(1)
+		func() {
+			defer func() { j.panicked = recover() }()
+			j.run()
+		}()
+		p.running.Add(-1)
+		close(j.done)
+	}
+}
+
+// submit queues run and waits for it to finish. It returns false, without
+// running it, when the queue is full or ctx ends before a worker is free.
+func (p *workPool) submit(ctx context.Context, run func()) bool {
+	j := &poolJob{run: run, done: make(chan struct{})}
+	select {
+	case p.jobs <- j:
+	default:
+		return false
+	}
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		if j.state.CompareAndSwap(jobQueued, jobAbandoned) {
+			return false
+		}
+		<-j.done // already running; it sees the cancellation itself
+	}
+	if j.panicked != nil {
+		// Re-panic on the request goroutine, where net/http recovers it.
+		panic(j.panicked)
+	}
+	return true
+}
+
+// Pooled runs next on the heavy-endpoint worker pool, answering 429 with
+// Retry-After when the pool and its queue are full. Requests whose client
+// disconnects while queued are dropped without running.
+func Pooled(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := getHeavyPool()
+		if p.submit(r.Context(), func() { next(w, r) }) {
+			return
+		}
+		if r.Context().Err() != nil {
+			return
+		}
+		log.Printf("work pool full: rejected %s %s (%d running, %d queued)", r.Method, r.URL.Path, p.running.Load(), len(p.jobs))
+		w.Header().Set("Retry-After", strconv.Itoa(workPoolRetryAfterSeconds))
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "server busy, retry later"})
+	}
+}
+
+// envInt reads a positive integer from envVar, falling back to def.
+func envInt(envVar string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(envVar)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"aquawatch/cmd/api/handler"
 	"aquawatch/internal"
+	"context"
 	"log"
 	"net/http"
 	"os"
@@ -17,10 +18,12 @@ func withCORS(next http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		allowed := r.Header.Get("Access-Control-Request-Headers")
 		if allowed == "" {
-			allowed = "Content-Type, Authorization, X-Requested-With, Accept, Origin, X-Verify-Request-Id, X-Verify-Code, X-Session-Token"
+			allowed = "Content-Type, Authorization, X-Requested-With, Accept, Origin, X-Verify-Request-Id, X-Verify-Code, X-Session-Token, X-Scoped-Token, X-Admin-Key"
 		}
 		w.Header().Set("Access-Control-Allow-Headers", allowed)
 		w.Header().Set("Access-Control-Max-Age", "86400")
+		// Pollers read ETag to send it back as If-None-Match.
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
@@ -31,72 +34,158 @@ func withCORS(next http.Handler) http.Handler {
 	})
 }
 
+// route binds a path pattern to a handler and the auth policy guarding it.
+type route struct {
+	pattern string
+	policy  handler.AuthPolicy
+	handler http.HandlerFunc
+}
+
+// vonageVerifyEnabled reads VONAGE_VERIFY_ENABLED (default true).
+func vonageVerifyEnabled() bool {
+	switch strings.ToLower(os.Getenv("VONAGE_VERIFY_ENABLED")) {
+	case "false", "0", "no", "off":
+		return false
+	}
+	return true
+}
+
+// routes declares every endpoint with its auth policy:
+//   - public: sign-in, token refresh and health checks
+//   - session: a session token, OIDC bearer token or Vonage verify headers
+//   - readOnly: session, or a scoped token granted the route and its sites
+//   - admin: the admin API key, or an OIDC user in the admin group
+//   - callback: the pipeline callback secret (EventBridge webhooks)
+//   - signed: a payload signed by an external sensor source
+func routes() []route {
+	public := handler.Public()
+	session := handler.Session(vonageVerifyEnabled())
+	readOnly := handler.AnyOf(handler.ScopedToken(), session)
+	admin := handler.AnyOf(handler.APIKey(), handler.Role(session, handler.RoleAdmin))
+	operator := handler.AnyOf(handler.Role(session, handler.RoleOperator), admin)
+	callback := handler.CallbackSecret()
+	signed := handler.SignedPayload()
+	return []route{
+		{"/healthz", public, handler.HealthHandler},
+		{"/status", public, handler.StatusHandler},
+		{"/sms/send", public, handler.SendSMSCodeHandler},
+		{"/sms/verify", public, handler.VerifySMSCodeHandler},
+		{"/sms/cancel", public, handler.CancelSMSCodeHandler},
+		{"/sms/resend", public, handler.ResendSMSCodeHandler},
+		{"/auth/refresh", public, handler.RefreshSessionHandler},
+		{"/auth/email/send", public, handler.SendMagicLinkHandler},
+		{"/auth/email/verify", public, handler.VerifyMagicLinkHandler},
+
+		{"/me", session, handler.MeHandler},
+		{"/ingest", session, handler.IngestHandler},
+		{"/prediction/status", readOnly, handler.PredictionStatusHandler},
+		{"/alerts/subscribe", session, handler.SubscribeAlertsHandler},
+		{"/alerts/subscribe/status", session, handler.AlertSubscriberStatusHandler},
+		{"/alerts/subscribe/resend", session, handler.ResendAlertConfirmationHandler},
+		{"/anomaly/check", session, handler.Pooled(handler.AnomalyCheckHandler)},
+		{"/anomaly/latest", readOnly, handler.LatestAnomalyHandler},
+		{"/anomaly/sweeps/{id}", session, handler.SweepHandler},
+		{"/stations", session, handler.StationsHandler},
+		{"/stations/search", session, handler.StationSearchHandler},
+		{"/stations/{site}/stats", readOnly, handler.StationStatsHandler},
+		{"/stations/{site}/parameters", session, handler.StationParametersHandler},
+		{"/stations/{site}/completeness", session, handler.StationCompletenessHandler},
+		{"/stations.geojson", readOnly, handler.StationsGeoJSONHandler},
+		{"/anomalies.geojson", readOnly, handler.AnomaliesGeoJSONHandler},
+		{"/compare", readOnly, handler.CompareHandler},
+		{"/history", readOnly, handler.HistoryHandler},
+		{"/stats/anomalies", session, handler.AnomalyStatsHandler},
+		{"/report/pdf", session, handler.Pooled(handler.GenerateReportPDFHandler)},
+		{"/uploads/presign", session, handler.PresignUploadHandler},
+		{"/datasets", session, handler.DatasetsHandler},
+		{"/datasets/{id}", session, handler.DatasetHandler},
+		{"/alerts", readOnly, handler.ListAlertsHandler},
+		{"/alerts/daily", readOnly, handler.DailyAlertsHandler},
+		{"/alerts/{id}", session, handler.AlertHandler},
+		{"/alerts/{id}/state", session, handler.UpdateAlertStateHandler},
+		{"/alerts/{id}/cap", session, handler.AlertCAPHandler},
+		{"/alerts/{id}/images", session, handler.AlertImagesHandler},
+		{"/alerts/{id}/comments", session, handler.AlertCommentsHandler},
+		{"/train/models", session, handler.ListTrainModelsHandler},
+		{"/train/models/{uuid}", session, handler.TrainModelHandler},
+		{"/models/{id}/performance", session, handler.ModelPerformanceHandler},
+		{"/events/runs", session, handler.RunEventsHandler},
+		{"/ingest/{execution}/events", session, handler.IngestEventsHandler},
+		{"/pipeline/runs", session, handler.ListPipelineRunsHandler},
+		{"/correlated-events", session, handler.ListCorrelatedEventsHandler},
+		{"/correlated-events/{id}", session, handler.CorrelatedEventHandler},
+		{"/basins/status", session, handler.BasinsStatusHandler},
+		{"/basins/{id}/status", session, handler.BasinStatusHandler},
+		{"/watchlists/{name}", session, handler.WatchlistHandler},
+		{"/watchlists/{name}/sites/{site}", admin, handler.WatchlistSiteHandler},
+		{"/watchlists/{name}/sites/{site}/restore", admin, handler.WatchlistSiteRestoreHandler},
+		{"/watchlists/{name}/webhook", admin, handler.WatchlistWebhookHandler},
+		{"/watchlists/{name}/webhook/deliveries", admin, handler.WebhookDeliveriesHandler},
+		{"/watchlists/{name}/webhook/deliveries/{seq}/redeliver", admin, handler.WebhookRedeliverHandler},
+		{"/basins/{id}/ingest", session, handler.BasinIngestHandler},
+		{"/basins/{id}/anomaly", session, handler.Pooled(handler.BasinAnomalyHandler)},
+
+		{"/admin/audit", admin, handler.ListAuditHandler},
+		{"/admin/export", admin, handler.ExportHandler},
+		{"/admin/usage", admin, handler.UsageHandler},
+		{"/admin/tokens", admin, handler.ScopedTokensHandler},
+		{"/admin/tokens/{id}", admin, handler.ScopedTokenHandler},
+		{"/admin/caches", admin, handler.CachesHandler},
+		{"/admin/caches/{name}", admin, handler.CacheHandler},
+		{"/admin/breakers", admin, handler.BreakersHandler},
+		{"/admin/subscriptions", admin, handler.SubscriptionsExportHandler},
+		{"/admin/subscriptions/import", admin, handler.SubscriptionsImportHandler},
+		{"/stations/{site}/impacts", admin, handler.SiteImpactsHandler},
+		{"/stations/{site}/impacts/{id}", admin, handler.SiteImpactHandler},
+		{"/basins", admin, handler.BasinsHandler},
+		{"/basins/{id}", admin, handler.BasinHandler},
+		{"/schedules", admin, handler.SchedulesHandler},
+		{"/schedules/{id}", admin, handler.ScheduleHandler},
+		{"/backfills", admin, handler.BackfillsHandler},
+		{"/backfills/{id}", admin, handler.BackfillHandler},
+		{"/backfills/{id}/resume", admin, handler.ResumeBackfillHandler},
+		{"/train/models/{uuid}/artifact", operator, handler.ModelArtifactHandler},
+		{"/stations/import", admin, handler.StationImportHandler},
+		{"/station-imports/{id}", admin, handler.StationImportReportHandler},
+
+		{"/pipeline/callback", callback, handler.PipelineCallbackHandler},
+		{"/ingest/external", signed, handler.ExternalIngestHandler},
+	}
+}
+
 func main() {
+	// Load the AWS config up front: a broken config would otherwise fail
+	// every request.
+	if _, err := internal.AWSConfig(); err != nil {
+		log.Fatalf("aws config: %v", err)
+	}
+	if err := internal.LoadSecrets(context.Background()); err != nil {
+		log.Fatalf("secrets: %v", err)
+	}
+	if err := internal.CheckOIDCConfig(); err != nil {
+		log.Fatalf("oidc: %v", err)
+	}
+	// SNS doesn't report email confirmations; poll for them.
+	if internal.AlertSubscriberPollEnabled() {
+		go internal.PollAlertSubscribers(context.Background(), internal.AlertSubscriberPollInterval())
+	}
+	// Keep last good copies fresh for the fallback policy.
+	if internal.LastGoodRefreshEnabled() {
+		go internal.PollLastGood(context.Background(), internal.LastGoodRefreshInterval())
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", handler.HealthHandler)
-	mux.HandleFunc("/ingest", handler.IngestHandler)
-	mux.HandleFunc("/prediction/status", handler.PredictionStatusHandler)
-	mux.HandleFunc("/alerts/subscribe", handler.SubscribeAlertsHandler)
-	mux.HandleFunc("/anomaly/check", handler.AnomalyCheckHandler)
-	mux.HandleFunc("/sms/send", handler.SendSMSCodeHandler)
-	mux.HandleFunc("/sms/verify", handler.VerifySMSCodeHandler)
-	mux.HandleFunc("/report/pdf", handler.GenerateReportPDFHandler)
-	mux.HandleFunc("/alerts", handler.ListAlertsHandler)
-	mux.HandleFunc("/train/models", handler.ListTrainModelsHandler)
+	for _, rt := range routes() {
+		mux.Handle(rt.pattern, handler.Protect(rt.policy, handler.BodyLimit(rt.pattern, rt.handler)))
+	}
 
 	addr := os.Getenv("PORT")
 	if addr == "" {
 		addr = "8080"
 	}
 
-	// Vonage Verify middleware (skips /healthz and OPTIONS)
-	flag := os.Getenv("VONAGE_VERIFY_ENABLED")
-	useVonage := true
-	if flag != "" {
-		switch strings.ToLower(flag) {
-		case "false", "0", "no", "off":
-			useVonage = false
-		}
-	}
-	authenticated := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodOptions || r.URL.Path == "/healthz" {
-			mux.ServeHTTP(w, r)
-			return
-		}
-		// Allow unauthenticated access to SMS start/verify endpoints
-		if r.URL.Path == "/sms/send" || r.URL.Path == "/sms/verify" {
-			mux.ServeHTTP(w, r)
-			return
-		}
-		// Accept either Vonage verify headers or an app session token
-		if tok := r.Header.Get("X-Session-Token"); tok != "" {
-			if _, err := internal.ValidateSessionToken(tok); err == nil {
-				mux.ServeHTTP(w, r)
-				return
-			}
-		}
-		if useVonage {
-			reqID := r.Header.Get("X-Verify-Request-Id")
-			code := r.Header.Get("X-Verify-Code")
-			if reqID == "" || code == "" {
-				http.Error(w, "missing verification headers", http.StatusUnauthorized)
-				return
-			}
-			ok, err := internal.VerifyCheck(r.Context(), reqID, code)
-			if err != nil || !ok {
-				http.Error(w, "verification failed", http.StatusUnauthorized)
-				return
-			}
-		} else {
-			// Vonage disabled: allow through without additional auth
-			mux.ServeHTTP(w, r)
-			return
-		}
-		mux.ServeHTTP(w, r)
-	})
-
 	log.Printf("Starting AquaWatch API on :%s", addr)
-	if err := http.ListenAndServe(":"+addr, withLogging(withCORS(authenticated))); err != nil {
+	if err := http.ListenAndServe(":"+addr, withLogging(withCORS(mux))); err != nil {
 		log.Fatalf("server error: %v", err)
 	}
 }
@@ -122,6 +211,12 @@ func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Unwrap exposes the underlying writer to http.ResponseController, so
+// streaming handlers can flush through the logging wrapper.
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
 func withLogging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Alerts can carry imagery for visual confirmation: images uploaded through
+// a presigned URL (/uploads/presign), or links to USGS/NWS webcam frames.
+// Uploads are stored by key and presigned whenever they are shown; webcam
+// links are kept as given, limited to trusted hosts.
+
+const (
+	alertImageIDPrefix = "img_"
+	// MaxAlertImages bounds the images of one alert.
+	MaxAlertImages     = 20
+	maxAlertCaptionLen = 200
+)
+
+// Alert image sources.
+const (
+	AlertImageUpload = "upload"
+	AlertImageWebcam = "webcam"
+)
+
+// ErrInvalidAlertImage is returned for image references that fail validation.
+var ErrInvalidAlertImage = validationError("invalid alert image")
+
+// AlertImage is an image attached to an alert. For uploads URL is empty in
+// the stored record and set to a presigned link when shown (see
+// LinkAlertImages).
+type AlertImage struct {
+	ImageID string `dynamodbav:"image_id" json:"image_id"`
+	Source  string `dynamodbav:"source" json:"source"`
+	Key     string `dynamodbav:"key,omitempty" json:"key,omitempty"`
+	URL     string `dynamodbav:"url,omitempty" json:"url,omitempty"`
+	Caption string `dynamodbav:"caption,omitempty" json:"caption,omitempty"`
+	Site    string `dynamodbav:"site,omitempty" json:"site,omitempty"`
+	AddedBy string `dynamodbav:"added_by,omitempty" json:"added_by,omitempty"`
+	AddedOn int64  `dynamodbav:"addedon" json:"addedon_ms"`
+}
+
+// AlertImageSpec references an image to attach: the Key of an upload, or
+// the URL of a webcam frame.
+type AlertImageSpec struct {
+	Key     string `json:"key"`
+	URL     string `json:"url"`
+	Caption string `json:"caption"`
+	Site    string `json:"site"`
+}
+
+// alertImageHosts returns the hosts webcam links may point at
+// (ALERT_IMAGE_HOSTS, comma-separated; subdomains included).
+func alertImageHosts() []string {
+	hosts := os.Getenv("ALERT_IMAGE_HOSTS")
+	if hosts == "" {
+		hosts = "usgs.gov,weather.gov,noaa.gov"
+	}
+	var out []string
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// NewAlertImages validates specs and returns the images they describe.
+// Uploaded keys must exist in S3_BUCKET. Errors match ErrInvalidAlertImage
+// for bad references.
+func NewAlertImages(ctx context.Context, specs []AlertImageSpec, addedBy string) ([]AlertImage, error) {
+	if len(specs) > MaxAlertImages {
+		return nil, fmt.Errorf("%w: at most %d images", ErrInvalidAlertImage, MaxAlertImages)
+	}
+	now := time.Now().UTC().UnixMilli()
+	out := make([]AlertImage, 0, len(specs))
+	for _, spec := range specs {
+		img, err := newAlertImage(ctx, spec)
+		if err != nil {
+			return nil, err
+		}
+		img.AddedBy, img.AddedOn = addedBy, now
+		out = append(out, img)
+	}
+	return out, nil
+}
+
+func newAlertImage(ctx context.Context, spec AlertImageSpec) (AlertImage, error) {
+	key, link := strings.TrimSpace(spec.Key), strings.TrimSpace(spec.URL)
+	img := AlertImage{Caption: strings.TrimSpace(spec.Caption), Site: strings.TrimSpace(spec.Site)}
+	if len(img.Caption) > maxAlertCaptionLen {
+		return img, fmt.Errorf("%w: caption is at most %d characters", ErrInvalidAlertImage, maxAlertCaptionLen)
+	}
+	if img.Site != "" && !siteIDPattern.MatchString(img.Site) {
+		return img, fmt.Errorf("%w: invalid site id %q", ErrInvalidAlertImage, img.Site)
+	}
+	switch {
+	case (key == "") == (link == ""):
+		return img, fmt.Errorf("%w: exactly one of key and url is required", ErrInvalidAlertImage)
+	case key != "":
+		if !strings.HasPrefix(key, Layout().UploadImagePrefix()) || strings.Contains(key, "..") {
+			return img, fmt.Errorf("%w: key must be an image upload (see /uploads/presign)", ErrInvalidAlertImage)
+		}
+		bucket := os.Getenv("S3_BUCKET")
+		if bucket == "" {
+			return img, errors.New("S3_BUCKET not configured")
+		}
+		if _, _, err := getBlobStore().GetTail(ctx, bucket, key, 1); err != nil {
+			if errors.Is(err, ErrBlobNotFound) {
+				return img, fmt.Errorf("%w: %s not found; upload the image first", ErrInvalidAlertImage, key)
+			}
+			return img, err
+		}
+		img.Source, img.Key = AlertImageUpload, key
+	default:
+		u, err := url.Parse(link)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return img, fmt.Errorf("%w: url must be an https URL", ErrInvalidAlertImage)
+		}
+		host := strings.ToLower(u.Hostname())
+		trusted := false
+		for _, h := range alertImageHosts() {
+			if host == h || strings.HasSuffix(host, "."+h) {
+				trusted = true
+				break
+			}
+		}
+		if !trusted {
+			return img, fmt.Errorf("%w: url host %s is not an allowed webcam host", ErrInvalidAlertImage, host)
+		}
+		img.Source, img.URL = AlertImageWebcam, u.String()
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return img, err
+	}
+	img.ImageID = alertImageIDPrefix + id
+	return img, nil
+}
+
+// AttachAlertImage appends img to the alert's images and timeline.
+// Attaching doesn't change the alert's version, so it never conflicts with
+// state updates.
+// Errors match ErrAlertNotFound, or ErrInvalidAlertImage when the alert
+// already has MaxAlertImages images.
+func AttachAlertImage(ctx context.Context, createdOnMs int64, img AlertImage) (*AlertTrackerItem, error) {
+	table := alertTrackerTable()
+	key, err := attributevalue.MarshalMap(map[string]any{"createdon": createdOnMs})
+	if err != nil {
+		return nil, err
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{
+		":img":   []AlertImage{img},
+		":empty": []AlertImage{},
+		":now":   time.Now().UTC().UnixMilli(),
+		":max":   MaxAlertImages,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out, err := getDynamoClient().UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 &table,
+		Key:                       key,
+		UpdateExpression:          awsString("SET images = list_append(if_not_exists(images, :empty), :img), updatedon = :now"),
+		ConditionExpression:       awsString("attribute_exists(createdon) AND (attribute_not_exists(images) OR size(images) < :max)"),
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		if _, err := GetAlert(ctx, createdOnMs); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: alert already has %d images", ErrInvalidAlertImage, MaxAlertImages)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var updated AlertTrackerItem
+	if err := attributevalue.UnmarshalMap(out.Attributes, &updated); err != nil {
+		return nil, err
+	}
+	// Keep per-site copies in sync (best-effort; the alert-tracker record is authoritative)
+	_ = indexAlertBySite(ctx, updated)
+	logAlertEvent(ctx, AlertTimelineEntry{AlertCreatedOn: createdOnMs, Kind: AlertEventImageAttached, Actor: img.AddedBy, ImageID: img.ImageID})
+	return &updated, nil
+}
+
+// LinkAlertImages returns copies of images with URL set: uploads get a
+// presigned link valid for expiry. Uploads that can't be presigned keep an
+// empty URL.
+func LinkAlertImages(ctx context.Context, images []AlertImage, expiry time.Duration) []AlertImage {
+	bucket := os.Getenv("S3_BUCKET")
+	out := make([]AlertImage, 0, len(images))
+	for _, img := range images {
+		if img.Source == AlertImageUpload && bucket != "" {
+			if u, err := GeneratePresignedGetURL(ctx, bucket, img.Key, expiry); err == nil {
+				img.URL = u
+			}
+		}
+		out = append(out, img)
+	}
+	return out
+}
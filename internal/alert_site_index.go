@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// alertSiteIndexItem duplicates an alert under each impacted site so a single
+// gauge's alert history can be read with one Query.
+// Table name defaults to "alert-site-index"; override with ALERT_SITE_INDEX_TABLE.
+// Keys: PK site (String), SK createdon (Number, epoch ms).
+type alertSiteIndexItem struct {
+	Site string `dynamodbav:"site"`
+	AlertTrackerItem
+}
+
+func alertSiteIndexTable() string {
+	return tableName("ALERT_SITE_INDEX_TABLE", "alert-site-index")
+}
+
+// indexAlertBySite writes one index row per impacted site. Rows are upserted so
+// re-indexing the same alert is harmless.
+func indexAlertBySite(ctx context.Context, item AlertTrackerItem) error {
+	repo := newRepository[AlertTrackerItem](alertSiteIndexTable())
+	for _, site := range item.SitesImpacted {
+		if site == "" {
+			continue
+		}
+		if err := repo.Put(ctx, alertSiteIndexItem{Site: site, AlertTrackerItem: item}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexAlertRecordBySite is indexAlertBySite for map-shaped alert records.
+func indexAlertRecordBySite(ctx context.Context, record map[string]any) error {
+	av, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return err
+	}
+	var item AlertTrackerItem
+	if err := attributevalue.UnmarshalMap(av, &item); err != nil {
+		return err
+	}
+	return indexAlertBySite(ctx, item)
+}
+
+// ListAlertsBySitePage returns alerts impacting site created since
+// sinceEpochMs, newest first. See ListRecentAlertsPage for cursor semantics.
+func ListAlertsBySitePage(ctx context.Context, site string, sinceEpochMs int64, limit int, cursor string) ([]AlertTrackerItem, string, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{
+		":site":  site,
+		":since": sinceEpochMs,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	items, next, err := newRepository[AlertTrackerItem](alertSiteIndexTable()).Query(ctx, &dynamodb.QueryInput{
+		KeyConditionExpression:    awsString("site = :site AND createdon >= :since"),
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          awsBool(false),
+		Limit:                     awsInt32(int32(limit)),
+	}, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedOnMs > items[j].CreatedOnMs })
+	return items, next, nil
+}
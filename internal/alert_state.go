@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Alert lifecycle states. Alerts without a stored state are "open".
+const (
+	AlertStateOpen         = "open"
+	AlertStateAcknowledged = "acknowledged"
+	AlertStateResolved     = "resolved"
+)
+
+// ErrAlertNotFound indicates no alert exists for the given id.
+var ErrAlertNotFound = notFoundError("alert not found")
+
+// ErrInvalidAlertState indicates a disallowed state or transition.
+var ErrInvalidAlertState = errors.New("invalid alert state transition")
+
+// ParseAlertID accepts either an alert_id ("alert-<ms>") or the raw createdon
+// value and returns the alert-tracker partition key.
+func ParseAlertID(id string) (int64, error) {
+	id = strings.TrimPrefix(strings.TrimSpace(id), "alert-")
+	ms, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || ms <= 0 {
+		return 0, fmt.Errorf("invalid alert id %q", id)
+	}
+	return ms, nil
+}
+
+// GetAlert fetches a single alert by its createdon key.
+// Returns ErrAlertNotFound if it does not exist.
+func GetAlert(ctx context.Context, createdOnMs int64) (*AlertTrackerItem, error) {
+	item, err := newRepository[AlertTrackerItem](alertTrackerTable()).Get(ctx, map[string]any{"createdon": createdOnMs})
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, ErrAlertNotFound
+	}
+	return item, nil
+}
+
+func allowedAlertTransition(from, to string) bool {
+	if from == "" {
+		from = AlertStateOpen
+	}
+	switch to {
+	case AlertStateAcknowledged:
+		return from == AlertStateOpen
+	case AlertStateResolved:
+		return from == AlertStateOpen || from == AlertStateAcknowledged
+	}
+	return false
+}
+
+// UpdateAlertState moves an alert to a new state using optimistic locking:
+// the write succeeds only if the stored version still equals expectedVersion.
+// On success the version is incremented, the change is added to the alert's
+// timeline as done by actor, and the updated alert is returned.
+// Errors match ErrAlertNotFound, ErrVersionConflict or ErrInvalidAlertState.
+func UpdateAlertState(ctx context.Context, createdOnMs int64, state string, expectedVersion int64, actor string) (*AlertTrackerItem, error) {
+	current, err := GetAlert(ctx, createdOnMs)
+	if err != nil {
+		return nil, err
+	}
+	if current.Version != expectedVersion {
+		return nil, &VersionConflictError{Table: alertTrackerTable(), Expected: expectedVersion}
+	}
+	if !allowedAlertTransition(current.State, state) {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrInvalidAlertState, current.State, state)
+	}
+
+	table := alertTrackerTable()
+	key, err := attributevalue.MarshalMap(map[string]any{"createdon": createdOnMs})
+	if err != nil {
+		return nil, err
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{
+		":state":    state,
+		":now":      time.Now().UTC().UnixMilli(),
+		":expected": expectedVersion,
+		":zero":     0,
+		":one":      1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out, err := getDynamoClient().UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 &table,
+		Key:                       key,
+		UpdateExpression:          awsString("SET #state = :state, updatedon = :now, #version = if_not_exists(#version, :zero) + :one"),
+		ConditionExpression:       awsString("attribute_exists(createdon) AND " + versionCondition(expectedVersion)),
+		ExpressionAttributeNames:  map[string]string{"#state": "state", "#version": "version"},
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return nil, &VersionConflictError{Table: table, Expected: expectedVersion}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var updated AlertTrackerItem
+	if err := attributevalue.UnmarshalMap(out.Attributes, &updated); err != nil {
+		return nil, err
+	}
+	// Keep per-site copies in sync (best-effort; the alert-tracker record is authoritative)
+	_ = indexAlertBySite(ctx, updated)
+	logAlertEvent(ctx, AlertTimelineEntry{AlertCreatedOn: createdOnMs, Kind: state, Actor: actor})
+	return &updated, nil
+}
@@ -0,0 +1,299 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"aquawatch/internal/cache"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// Email alert subscriptions are confirmed by the recipient through a link SNS
+// mails them, which SNS doesn't report back. Each subscription request is
+// recorded in the alert-subscribers table as pending, and the record is
+// brought in line with the topic's subscriptions when its status is read and
+// by PollAlertSubscribers, which an API server with
+// ALERT_SUBSCRIBER_POLL_ENABLED runs every ALERT_SUBSCRIBER_POLL_MINUTES
+// (enable it on one instance: each round scans the table and lists the
+// topic, and replicas would race each other's writes): a subscription with an ARN is confirmed, a
+// pending one whose link went unused for snsConfirmationValidity has expired,
+// and a confirmed one that left the topic was unsubscribed. Pending and
+// expired subscribers can have the confirmation mailed again.
+
+// Alert subscriber statuses.
+const (
+	SubscriberPending      = "pending"
+	SubscriberConfirmed    = "confirmed"
+	SubscriberExpired      = "expired"
+	SubscriberUnsubscribed = "unsubscribed"
+)
+
+const (
+	// snsConfirmationValidity is how long SNS confirmation links work.
+	snsConfirmationValidity = 72 * time.Hour
+	// snsPendingConfirmation is the subscription ARN SNS lists for
+	// subscriptions awaiting confirmation.
+	snsPendingConfirmation = "PendingConfirmation"
+	// maxConfirmationResends bounds the resends of one subscription request.
+	maxConfirmationResends = 5
+)
+
+// ErrSubscriberNotFound is returned for emails that never subscribed.
+var ErrSubscriberNotFound = notFoundError("subscriber not found")
+
+// AlertSubscriber is the confirmation state of an email alert subscription.
+// Table name defaults to "alert-subscribers"; override with
+// ALERT_SUBSCRIBERS_TABLE.
+// Keys: PK email (lowercased).
+type AlertSubscriber struct {
+	Email           string `dynamodbav:"email" json:"email"`
+	Status          string `dynamodbav:"status" json:"status"`
+	SubscriptionARN string `dynamodbav:"subscription_arn,omitempty" json:"subscription_arn,omitempty"`
+	RequestedOn     int64  `dynamodbav:"requestedon" json:"requested_on_ms"`
+	// SentOn is when the confirmation email was last sent.
+	SentOn      int64 `dynamodbav:"senton" json:"sent_on_ms"`
+	Resends     int   `dynamodbav:"resends" json:"resends"`
+	ConfirmedOn int64 `dynamodbav:"confirmedon,omitempty" json:"confirmed_on_ms,omitempty"`
+	// CheckedOn is when the status was last compared with SNS.
+	CheckedOn int64 `dynamodbav:"checkedon,omitempty" json:"checked_on_ms,omitempty"`
+}
+
+func alertSubscribersTable() string {
+	return tableName("ALERT_SUBSCRIBERS_TABLE", "alert-subscribers")
+}
+
+// confirmationResendInterval is the minimum time between confirmation
+// emails to one address (ALERT_CONFIRM_RESEND_MINUTES, default 5).
+func confirmationResendInterval() time.Duration {
+	return time.Duration(envInt("ALERT_CONFIRM_RESEND_MINUTES", 5)) * time.Minute
+}
+
+// AlertSubscriberPollEnabled reports whether the API server syncs
+// subscribers with SNS in the background (ALERT_SUBSCRIBER_POLL_ENABLED,
+// default off).
+func AlertSubscriberPollEnabled() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("ALERT_SUBSCRIBER_POLL_ENABLED"))) {
+	case "true", "1", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// AlertSubscriberPollInterval is how often the API server syncs subscribers
+// with SNS (ALERT_SUBSCRIBER_POLL_MINUTES, default 5).
+func AlertSubscriberPollInterval() time.Duration {
+	return time.Duration(envInt("ALERT_SUBSCRIBER_POLL_MINUTES", 5)) * time.Minute
+}
+
+// recordAlertSubscription stores a subscription request as pending, or as
+// confirmed when SNS already returned its ARN. Failures are logged: the
+// subscription itself went through.
+func recordAlertSubscription(ctx context.Context, email, arn string) {
+	now := time.Now().UTC().UnixMilli()
+	sub := &AlertSubscriber{Email: strings.ToLower(email), Status: SubscriberPending, RequestedOn: now, SentOn: now}
+	if isConfirmedARN(arn) {
+		sub.Status, sub.SubscriptionARN, sub.ConfirmedOn = SubscriberConfirmed, arn, now
+	}
+	if err := newRepository[AlertSubscriber](alertSubscribersTable()).Put(ctx, sub); err != nil {
+		log.Printf("recording alert subscriber %s failed: %v", sub.Email, err)
+	}
+}
+
+func isConfirmedARN(arn string) bool {
+	return strings.HasPrefix(arn, "arn:")
+}
+
+// GetAlertSubscriber returns the subscription status of email, refreshed
+// from the topic's subscriptions. Errors match ErrSubscriberNotFound.
+func GetAlertSubscriber(ctx context.Context, email string) (*AlertSubscriber, error) {
+	sub, err := newRepository[AlertSubscriber](alertSubscribersTable()).Get(ctx, map[string]any{"email": strings.ToLower(email)})
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil {
+		return nil, ErrSubscriberNotFound
+	}
+	subs, err := emailSubscriptions(ctx)
+	if err != nil {
+		// The stored status is still the best answer.
+		log.Printf("listing alert subscriptions failed: %v", err)
+		return sub, nil
+	}
+	if err := refreshAlertSubscriber(ctx, sub, subs, time.Now().UTC()); err != nil {
+		log.Printf("updating alert subscriber %s failed: %v", sub.Email, err)
+	}
+	return sub, nil
+}
+
+// ResendAlertConfirmation mails the confirmation link of a pending or
+// expired subscription again. Errors match ErrSubscriberNotFound,
+// ErrAlreadySubscribed, or ErrRateLimited (a *RateLimitError) when the last
+// email went out less than ALERT_CONFIRM_RESEND_MINUTES ago or the resends
+// are used up.
+func ResendAlertConfirmation(ctx context.Context, email string) (*AlertSubscriber, error) {
+	sub, err := GetAlertSubscriber(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if sub.Status == SubscriberConfirmed {
+		return nil, ErrAlreadySubscribed
+	}
+	now := time.Now().UTC()
+	if sub.Resends >= maxConfirmationResends {
+		return nil, &RateLimitError{Scope: RateLimitScopeEmail, RetryAfter: snsConfirmationValidity}
+	}
+	if wait := time.UnixMilli(sub.SentOn).Add(confirmationResendInterval()).Sub(now); wait > 0 {
+		return nil, &RateLimitError{Scope: RateLimitScopeCooldown, RetryAfter: max(wait, time.Second)}
+	}
+	topicArn, err := topicARN(ctx, alertsTopicName())
+	if err != nil {
+		return nil, err
+	}
+	// Subscribing again sends a new confirmation email for a pending
+	// subscription and starts a new one for an expired or removed one.
+	if _, err := getSNSClient().Subscribe(ctx, &sns.SubscribeInput{
+		Protocol: aws.String("email"),
+		Endpoint: aws.String(sub.Email),
+		TopicArn: aws.String(topicArn),
+	}); err != nil {
+		return nil, err
+	}
+	if sub.Status != SubscriberPending {
+		sub.RequestedOn, sub.Resends = now.UnixMilli(), 0
+	} else {
+		sub.Resends++
+	}
+	sub.Status, sub.SentOn = SubscriberPending, now.UnixMilli()
+	if err := newRepository[AlertSubscriber](alertSubscribersTable()).Put(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// SyncAlertSubscribers compares every pending or confirmed subscriber with
+// the topic's subscriptions and stores the changes, returning how many
+// subscribers changed status.
+func SyncAlertSubscribers(ctx context.Context) (int, error) {
+	table := alertSubscribersTable()
+	subs, err := emailSubscriptions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	var changed int
+	paginator := dynamodb.NewScanPaginator(getDynamoClient(), &dynamodb.ScanInput{TableName: &table})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return changed, err
+		}
+		var items []AlertSubscriber
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return changed, err
+		}
+		for i := range items {
+			sub := &items[i]
+			if sub.Status != SubscriberPending && sub.Status != SubscriberConfirmed {
+				continue
+			}
+			before := sub.Status
+			if err := refreshAlertSubscriber(ctx, sub, subs, now); err != nil {
+				return changed, err
+			}
+			if sub.Status != before {
+				changed++
+			}
+		}
+	}
+	return changed, nil
+}
+
+// PollAlertSubscribers runs SyncAlertSubscribers every interval until ctx
+// is done. A failed round is logged and retried on the next tick.
+func PollAlertSubscribers(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := SyncAlertSubscribers(ctx)
+		if err != nil {
+			log.Printf("alert subscriber sync failed: %v", err)
+			continue
+		}
+		if changed > 0 {
+			log.Printf("alert subscriber sync: %d subscribers changed status", changed)
+		}
+	}
+}
+
+// refreshAlertSubscriber moves sub to the status its subscription in subs
+// (email -> subscription ARN) implies, storing it when the status changed.
+func refreshAlertSubscriber(ctx context.Context, sub *AlertSubscriber, subs map[string]string, now time.Time) error {
+	arn, listed := subs[sub.Email]
+	before := sub.Status
+	switch {
+	case listed && isConfirmedARN(arn):
+		if sub.Status != SubscriberConfirmed {
+			sub.ConfirmedOn = now.UnixMilli()
+		}
+		sub.Status, sub.SubscriptionARN = SubscriberConfirmed, arn
+	case listed:
+		// Still awaiting confirmation.
+	case sub.Status == SubscriberConfirmed:
+		sub.Status = SubscriberUnsubscribed
+	case sub.Status == SubscriberPending && now.Sub(time.UnixMilli(sub.SentOn)) > snsConfirmationValidity:
+		sub.Status = SubscriberExpired
+	}
+	sub.CheckedOn = now.UnixMilli()
+	if sub.Status == before {
+		return nil
+	}
+	return newRepository[AlertSubscriber](alertSubscribersTable()).Put(ctx, sub)
+}
+
+// topicSubscriptions caches the alerts topic's email subscriptions, so
+// status checks and polls within a minute list the topic once.
+var topicSubscriptions = cache.New[string, map[string]string]("sns-subscriptions", 4, time.Minute)
+
+// emailSubscriptions maps each email subscribed to the alerts topic
+// (lowercased) to its subscription ARN, PendingConfirmation until
+// confirmed.
+func emailSubscriptions(ctx context.Context) (map[string]string, error) {
+	topicName := alertsTopicName()
+	return topicSubscriptions.GetOrLoad(ctx, topicName, func(ctx context.Context) (map[string]string, error) {
+		topicArn, err := topicARN(ctx, topicName)
+		if err != nil {
+			return nil, err
+		}
+		out := map[string]string{}
+		p := sns.NewListSubscriptionsByTopicPaginator(getSNSClient(), &sns.ListSubscriptionsByTopicInput{TopicArn: aws.String(topicArn)})
+		for p.HasMorePages() {
+			page, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range page.Subscriptions {
+				if aws.ToString(s.Protocol) != "email" {
+					continue
+				}
+				email := strings.ToLower(aws.ToString(s.Endpoint))
+				// A confirmed subscription wins over a pending duplicate.
+				if arn := aws.ToString(s.SubscriptionArn); !isConfirmedARN(out[email]) {
+					out[email] = arn
+				}
+			}
+		}
+		return out, nil
+	})
+}
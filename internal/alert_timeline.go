@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// An alert's timeline is what happened to it: state changes, comments and
+// attached images, with who did them, so teams can coordinate on an alert
+// within AquaWatch. Creation isn't stored; it is derived from the alert
+// record itself.
+
+// Alert timeline event kinds.
+const (
+	AlertEventCreated       = "created"
+	AlertEventAcknowledged  = "acknowledged"
+	AlertEventResolved      = "resolved"
+	AlertEventCommented     = "commented"
+	AlertEventImageAttached = "image_attached"
+)
+
+// maxAlertCommentLen bounds a comment's text.
+const maxAlertCommentLen = 2000
+
+// ErrInvalidAlertComment is returned for empty or oversized comments.
+var ErrInvalidAlertComment = validationError("invalid alert comment")
+
+// AlertTimelineEntry is one event of an alert's timeline.
+// Table name defaults to "alert-timeline"; override with
+// ALERT_TIMELINE_TABLE. Keys: PK alert_createdon (the alert's key), SK seq.
+// Items expire with their alert (ALERT_TRACKER_TTL_DAYS).
+type AlertTimelineEntry struct {
+	AlertCreatedOn int64 `dynamodbav:"alert_createdon" json:"-"`
+	// Seq orders an alert's events: the recording time in epoch
+	// microseconds.
+	Seq     int64  `dynamodbav:"seq" json:"seq"`
+	Kind    string `dynamodbav:"kind" json:"kind"`
+	Actor   string `dynamodbav:"actor,omitempty" json:"actor,omitempty"`
+	Comment string `dynamodbav:"comment,omitempty" json:"comment,omitempty"`
+	// ImageID names the image of image_attached events.
+	ImageID   string `dynamodbav:"image_id,omitempty" json:"image_id,omitempty"`
+	At        int64  `dynamodbav:"at" json:"at_ms"`
+	ExpiresAt int64  `dynamodbav:"expires_at,omitempty" json:"-"`
+}
+
+func alertTimelineTable() string {
+	return tableName("ALERT_TIMELINE_TABLE", "alert-timeline")
+}
+
+// recordAlertEvent stores e on the timeline of its alert, stamping Seq, At
+// and ExpiresAt.
+func recordAlertEvent(ctx context.Context, e AlertTimelineEntry) (*AlertTimelineEntry, error) {
+	now := time.Now().UTC()
+	e.Seq, e.At = now.UnixMicro(), now.UnixMilli()
+	e.ExpiresAt = AlertTrackerRetention().ExpiresAt(time.UnixMilli(e.AlertCreatedOn))
+	if err := newRepository[AlertTimelineEntry](alertTimelineTable()).Put(ctx, e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// logAlertEvent is recordAlertEvent for events whose change already
+// happened: a failure is only logged.
+func logAlertEvent(ctx context.Context, e AlertTimelineEntry) {
+	if _, err := recordAlertEvent(context.WithoutCancel(ctx), e); err != nil {
+		log.Printf("recording %s event of alert %d failed: %v", e.Kind, e.AlertCreatedOn, err)
+	}
+}
+
+// AddAlertComment adds a comment by actor to the timeline of an alert.
+// Errors match ErrAlertNotFound or ErrInvalidAlertComment.
+func AddAlertComment(ctx context.Context, createdOnMs int64, actor, text string) (*AlertTimelineEntry, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || len(text) > maxAlertCommentLen {
+		return nil, fmt.Errorf("%w: text is required (at most %d characters)", ErrInvalidAlertComment, maxAlertCommentLen)
+	}
+	if _, err := GetAlert(ctx, createdOnMs); err != nil {
+		return nil, err
+	}
+	return recordAlertEvent(ctx, AlertTimelineEntry{
+		AlertCreatedOn: createdOnMs,
+		Kind:           AlertEventCommented,
+		Actor:          actor,
+		Comment:        text,
+	})
+}
+
+// AlertTimeline returns the timeline of alert, oldest first, starting with
+// its creation.
+func AlertTimeline(ctx context.Context, alert AlertTrackerItem) ([]AlertTimelineEntry, error) {
+	values, err := attributevalue.MarshalMap(map[string]any{":alert": alert.CreatedOnMs})
+	if err != nil {
+		return nil, err
+	}
+	in := &dynamodb.QueryInput{
+		KeyConditionExpression:    awsString("alert_createdon = :alert"),
+		ExpressionAttributeValues: values,
+	}
+	out := []AlertTimelineEntry{{
+		AlertCreatedOn: alert.CreatedOnMs,
+		Seq:            alert.CreatedOnMs * 1000,
+		Kind:           AlertEventCreated,
+		At:             alert.CreatedOnMs,
+	}}
+	repo := newRepository[AlertTimelineEntry](alertTimelineTable())
+	var cursor string
+	for {
+		items, next, err := repo.Query(ctx, in, cursor)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+		if next == "" {
+			return out, nil
+		}
+		cursor = next
+	}
+}
@@ -3,39 +3,51 @@ package internal
 import (
 	"context"
 	"errors"
-	"os"
 	"strings"
+	"sync"
+	"time"
+
+	"aquawatch/internal/cache"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sns"
 )
 
+var (
+	snsClientOnce sync.Once
+	snsClient     *sns.Client
+)
+
+// getSNSClient returns a process-wide SNS client, honoring any SNS endpoint
+// override.
+func getSNSClient() *sns.Client {
+	snsClientOnce.Do(func() {
+		snsClient = sns.NewFromConfig(regionalConfig("SNS_REGION"), snsEndpointOptions)
+	})
+	return snsClient
+}
+
 // ErrAlreadySubscribed indicates the email is already subscribed to the topic.
 var ErrAlreadySubscribed = errors.New("email already subscribed")
 
-// SubscribeAlertsEmail subscribes the provided email to the alerts SNS topic.
+// SubscribeAlertsEmail subscribes the provided email to the alerts SNS topic
+// and records the subscriber (see GetAlertSubscriber).
 // The topic is created if it does not already exist.
 // Returns the SubscriptionArn if immediately available; for email subscriptions
 // this is typically "pending confirmation" until the recipient confirms.
 func SubscribeAlertsEmail(ctx context.Context, email string) (string, error) {
-	cfg := getAWSConfig()
-	client := sns.NewFromConfig(cfg)
+	client := getSNSClient()
 
-	topicName := os.Getenv("SNS_TOPIC_NAME")
-	if topicName == "" {
-		topicName = "aquawatch-alerts"
-	}
+	topicName := alertsTopicName()
 
-	createOut, err := client.CreateTopic(ctx, &sns.CreateTopicInput{
-		Name: aws.String(topicName),
-	})
+	topicArn, err := topicARN(ctx, topicName)
 	if err != nil {
 		return "", err
 	}
 
 	// Check if email is already subscribed (confirmed) to the topic
 	p := sns.NewListSubscriptionsByTopicPaginator(client, &sns.ListSubscriptionsByTopicInput{
-		TopicArn: createOut.TopicArn,
+		TopicArn: aws.String(topicArn),
 	})
 	for p.HasMorePages() {
 		page, err := p.NextPage(ctx)
@@ -44,7 +56,8 @@ func SubscribeAlertsEmail(ctx context.Context, email string) (string, error) {
 		}
 		for _, s := range page.Subscriptions {
 			if s.Endpoint != nil && strings.EqualFold(*s.Endpoint, email) && s.Protocol != nil && *s.Protocol == "email" {
-				if s.SubscriptionArn != nil && *s.SubscriptionArn != "" && *s.SubscriptionArn != "PendingConfirmation" {
+				if s.SubscriptionArn != nil && *s.SubscriptionArn != "" && *s.SubscriptionArn != snsPendingConfirmation {
+					recordAlertSubscription(ctx, email, *s.SubscriptionArn)
 					return "", ErrAlreadySubscribed
 				}
 			}
@@ -54,36 +67,62 @@ func SubscribeAlertsEmail(ctx context.Context, email string) (string, error) {
 	subOut, err := client.Subscribe(ctx, &sns.SubscribeInput{
 		Protocol: aws.String("email"),
 		Endpoint: aws.String(email),
-		TopicArn: createOut.TopicArn,
+		TopicArn: aws.String(topicArn),
 	})
 	if err != nil {
 		return "", err
 	}
-	if subOut.SubscriptionArn == nil {
-		return "", nil
-	}
-	return *subOut.SubscriptionArn, nil
+	topicSubscriptions.Delete(topicName)
+	recordAlertSubscription(ctx, email, aws.ToString(subOut.SubscriptionArn))
+	return aws.ToString(subOut.SubscriptionArn), nil
 }
 
 // PublishAlert publishes a plain-text alert message to the SNS topic configured by SNS_TOPIC_NAME.
 // If the topic doesn't exist, it will be created. Subject is optional.
 func PublishAlert(ctx context.Context, subject, message string) error {
-	cfg := getAWSConfig()
-	client := sns.NewFromConfig(cfg)
+	return publishToTopic(ctx, alertsTopicName(), subject, message)
+}
 
-	topicName := os.Getenv("SNS_TOPIC_NAME")
-	if topicName == "" {
-		topicName = "aquawatch-alerts"
-	}
+// PublishOperatorAlert publishes to the operators' topic
+// (OPERATOR_SNS_TOPIC_NAME, default "aquawatch-operators"), kept apart from
+// the public alerts topic so pipeline failures only reach operators.
+func PublishOperatorAlert(ctx context.Context, subject, message string) error {
+	return publishToTopic(ctx, resourceName("OPERATOR_SNS_TOPIC_NAME", "aquawatch-operators"), subject, message)
+}
+
+// alertsTopicName returns the public alerts topic: SNS_TOPIC_NAME, default
+// "aquawatch-alerts".
+func alertsTopicName() string {
+	return resourceName("SNS_TOPIC_NAME", "aquawatch-alerts")
+}
 
-	createOut, err := client.CreateTopic(ctx, &sns.CreateTopicInput{Name: aws.String(topicName)})
+// publishToTopic publishes message to topicName, creating the topic if it
+// doesn't exist.
+func publishToTopic(ctx context.Context, topicName, subject, message string) error {
+	topicArn, err := topicARN(ctx, topicName)
 	if err != nil {
 		return err
 	}
-	pubIn := &sns.PublishInput{TopicArn: createOut.TopicArn, Message: aws.String(message)}
+	pubIn := &sns.PublishInput{TopicArn: aws.String(topicArn), Message: aws.String(message)}
 	if strings.TrimSpace(subject) != "" {
 		pubIn.Subject = aws.String(subject)
 	}
-	_, err = client.Publish(ctx, pubIn)
+	_, err = getSNSClient().Publish(ctx, pubIn)
 	return err
 }
+
+// topicARNs caches topic ARNs by name; a topic's ARN never changes.
+var topicARNs = cache.New[string, string]("sns-topics", 16, time.Hour)
+
+// topicARN returns the ARN of topicName, creating the topic if it doesn't
+// exist. CreateTopic is idempotent, so the first call per process resolves it
+// and later ones are served from topicARNs.
+func topicARN(ctx context.Context, topicName string) (string, error) {
+	return topicARNs.GetOrLoad(ctx, topicName, func(ctx context.Context) (string, error) {
+		out, err := getSNSClient().CreateTopic(ctx, &sns.CreateTopicInput{Name: aws.String(topicName)})
+		if err != nil {
+			return "", err
+		}
+		return aws.ToString(out.TopicArn), nil
+	})
+}
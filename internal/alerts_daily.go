@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// GET /alerts/daily backs the history calendar: the alerts of one calendar
+// day grouped per impacted site. Alert records carry alert_day, the UTC date
+// of their createdon, indexed by gsi_day (PK alert_day, SK createdon), so a
+// day is read with one or two Queries (two when a time zone other than UTC
+// makes the day span two UTC dates) instead of paging through gsi_recent.
+// Records written before alert_day existed are not in the index.
+
+// alertDayAttribute is the gsi_day partition key of alert records.
+const alertDayAttribute = "alert_day"
+
+// maxDailyAlerts bounds the alerts read for one day.
+const maxDailyAlerts = 5000
+
+// ErrInvalidAlertDay is returned for unparseable dates or time zones.
+var ErrInvalidAlertDay = validationError("invalid alert day")
+
+// DailyAlerts are the alerts of one calendar day grouped per site.
+type DailyAlerts struct {
+	Date     string `json:"date"`
+	TimeZone string `json:"tz"`
+	// Total counts the day's alerts; an alert impacting several sites is
+	// listed under each of them.
+	Total       int               `json:"total"`
+	MaxSeverity string            `json:"max_severity,omitempty"`
+	Sites       []DailySiteAlerts `json:"sites"`
+	// Truncated is set when the day had more than maxDailyAlerts alerts and
+	// only the first were read.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// DailySiteAlerts are one site's alerts of the day, oldest first.
+type DailySiteAlerts struct {
+	Site        string           `json:"site"`
+	Count       int              `json:"count"`
+	MaxSeverity string           `json:"max_severity,omitempty"`
+	Alerts      []DailyAlertItem `json:"alerts"`
+}
+
+// DailyAlertItem summarizes an alert for the calendar; GET /alerts/{id} has
+// the rest.
+type DailyAlertItem struct {
+	AlertID     string `json:"alert_id"`
+	AlertName   string `json:"alert_name"`
+	Severity    string `json:"severity"`
+	State       string `json:"state"`
+	CreatedOnMs int64  `json:"createdon_ms"`
+}
+
+// AlertDay returns the UTC date (YYYY-MM-DD) of an alert created at epochMs.
+func AlertDay(epochMs int64) string {
+	return time.UnixMilli(epochMs).UTC().Format(time.DateOnly)
+}
+
+// ListDailyAlerts returns the alerts created on date (YYYY-MM-DD) in the
+// time zone tz (IANA name, default UTC), grouped per impacted site. With
+// sites, only those sites are listed. Errors match ErrInvalidAlertDay for bad
+// input.
+func ListDailyAlerts(ctx context.Context, date, tz string, sites []string) (*DailyAlerts, error) {
+	if tz == "" {
+		tz = "UTC"
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unknown time zone %q", ErrInvalidAlertDay, tz)
+	}
+	day, err := time.ParseInLocation(time.DateOnly, date, loc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: date must be YYYY-MM-DD", ErrInvalidAlertDay)
+	}
+	from := day.UnixMilli()
+	to := day.AddDate(0, 0, 1).UnixMilli() - 1
+
+	out := &DailyAlerts{Date: date, TimeZone: tz, Sites: []DailySiteAlerts{}}
+	days := []string{AlertDay(from)}
+	if last := AlertDay(to); last != days[0] {
+		days = append(days, last)
+	}
+	var items []AlertTrackerItem
+	for _, utcDay := range days {
+		if len(items) >= maxDailyAlerts {
+			break
+		}
+		got, truncated, err := queryAlertDay(ctx, utcDay, from, to, maxDailyAlerts-len(items))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, got...)
+		out.Truncated = out.Truncated || truncated
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedOnMs < items[j].CreatedOnMs })
+
+	bySite := map[string]*DailySiteAlerts{}
+	for _, it := range items {
+		severity := strings.ToLower(it.Severity)
+		listed := false
+		for _, site := range it.SitesImpacted {
+			if site == "" || (len(sites) > 0 && !slices.Contains(sites, site)) {
+				continue
+			}
+			g := bySite[site]
+			if g == nil {
+				g = &DailySiteAlerts{Site: site}
+				bySite[site] = g
+			}
+			g.Count++
+			if alertSeverityRank[severity] > alertSeverityRank[g.MaxSeverity] {
+				g.MaxSeverity = severity
+			}
+			g.Alerts = append(g.Alerts, DailyAlertItem{
+				AlertID:     it.AlertID,
+				AlertName:   it.AlertName,
+				Severity:    it.Severity,
+				State:       it.State,
+				CreatedOnMs: it.CreatedOnMs,
+			})
+			listed = true
+		}
+		if !listed {
+			continue
+		}
+		out.Total++
+		if alertSeverityRank[severity] > alertSeverityRank[out.MaxSeverity] {
+			out.MaxSeverity = severity
+		}
+	}
+	for _, g := range bySite {
+		out.Sites = append(out.Sites, *g)
+	}
+	sort.Slice(out.Sites, func(i, j int) bool { return out.Sites[i].Site < out.Sites[j].Site })
+	return out, nil
+}
+
+// queryAlertDay reads up to limit alerts of the UTC day utcDay created
+// between from and to (epoch ms, inclusive), reporting whether more were
+// left.
+func queryAlertDay(ctx context.Context, utcDay string, from, to int64, limit int) ([]AlertTrackerItem, bool, error) {
+	values, err := attributevalue.MarshalMap(map[string]any{
+		":day":  utcDay,
+		":from": from,
+		":to":   to,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	repo := newRepository[AlertTrackerItem](alertTrackerTable())
+	var out []AlertTrackerItem
+	var cursor string
+	for {
+		if len(out) >= limit {
+			return out[:limit], true, nil
+		}
+		items, next, err := repo.Query(ctx, &dynamodb.QueryInput{
+			IndexName:                 awsString("gsi_day"),
+			KeyConditionExpression:    awsString("alert_day = :day AND createdon BETWEEN :from AND :to"),
+			ExpressionAttributeValues: values,
+			Limit:                     awsInt32(int32(min(limit-len(out), 1000))),
+		}, cursor)
+		if err != nil {
+			return nil, false, err
+		}
+		out = append(out, items...)
+		if next == "" {
+			return out, false, nil
+		}
+		cursor = next
+	}
+}
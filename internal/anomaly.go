@@ -13,6 +13,8 @@ import (
 	"time"
 )
 
+// The discharge anomaly thresholds, also used for parameters without a
+// profile (see ProfileFor).
 const (
 	minPredictedValue       = 15
 	defaultThresholdPercent = 20
@@ -25,6 +27,19 @@ type AnomalyResult struct {
 	PredictedValue float64 `json:"predicted_value"`
 	PercentChange  float64 `json:"percent_change"`
 	Anomalous      bool    `json:"anomalous"`
+	// Severity and Reason are set for parameters judged by a threshold rule
+	// (see judgeObservation); Unit for every parameter with a known unit.
+	Severity string `json:"severity,omitempty"`
+	Unit     string `json:"unit,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	// Model is the endpoint target that made the prediction.
+	Model string `json:"model"`
+	// Percentile ranks the observed value in the site's history; nil when
+	// no history is available.
+	Percentile *PercentileContext `json:"percentile,omitempty"`
+	// LeadTime estimates when the site reaches its next impact threshold;
+	// nil when the forecast doesn't reach one (see estimateLeadTime).
+	LeadTime *FloodLeadTime `json:"lead_time,omitempty"`
 }
 
 // parseLatestObserved extracts the most recent observed value from USGS JSON.
@@ -60,12 +75,12 @@ func parseLatestObserved(raw []byte) (float64, error) {
 	return 0, errors.New("no observations found")
 }
 
-// parsePredictions attempts to parse numeric predictions from the model output.
-// It accepts CSV-like or newline-delimited numbers and returns the last value.
-func parsePredictions(output []byte) (float64, error) {
+// ParsePredictionValues parses every numeric prediction from the model output,
+// in order. It accepts CSV-like, bracketed, or newline-delimited numbers.
+func ParsePredictionValues(output []byte) ([]float64, error) {
 	text := strings.TrimSpace(string(output))
 	if text == "" {
-		return 0, errors.New("empty prediction output")
+		return nil, errors.New("empty prediction output")
 	}
 	// Remove surrounding brackets if present, e.g., "[66]" -> "66"
 	text = strings.TrimPrefix(text, "[")
@@ -76,8 +91,7 @@ func parsePredictions(output []byte) (float64, error) {
 		text = strings.ReplaceAll(text, sep, ",")
 	}
 	parts := strings.Split(text, ",")
-	var last float64
-	found := false
+	var values []float64
 	for _, p := range parts {
 		p = strings.TrimSpace(p)
 		if p == "" {
@@ -87,26 +101,133 @@ func parsePredictions(output []byte) (float64, error) {
 		if err != nil {
 			continue
 		}
-		last = v
-		found = true
+		values = append(values, v)
 	}
-	if !found {
-		return 0, errors.New("no numeric predictions parsed")
+	if len(values) == 0 {
+		return nil, errors.New("no numeric predictions parsed")
+	}
+	return values, nil
+}
+
+// detectAnomaly returns how far predicted is from observed, as a percentage
+// of observed, and whether that exceeds the profile's threshold. Predictions
+// at or below the profile's MinPredicted are never anomalous.
+func detectAnomaly(p ParameterProfile, observed, predicted float64) (float64, bool) {
+	den := math.Max(1e-9, math.Abs(observed))
+	percent := math.Abs(predicted-observed) / den * 100.0
+	return percent, percent > p.ThresholdPercent && predicted > p.MinPredicted
+}
+
+// Anomaly severities by percent change: anomalies are low below
+// mediumSeverityPercent, medium below highSeverityPercent and high above.
+const (
+	mediumSeverityPercent = 50
+	highSeverityPercent   = 100
+)
+
+// AnomalySeverity rates an anomaly by its percent change as "low",
+// "medium" or "high", the alert severities. Threshold rule anomalies carry
+// their own (see AnomalyEvaluation.Rating).
+func AnomalySeverity(percentChange float64) string {
+	switch {
+	case percentChange >= highSeverityPercent:
+		return "high"
+	case percentChange >= mediumSeverityPercent:
+		return "medium"
+	}
+	return "low"
+}
+
+// PublishAnomalies sends one alert covering the anomalous sites among evals,
+// each followed by the site's impact statement when the observed or
+// predicted value reaches one, where the observed value falls in the
+// site's history and how long until it reaches its next impact. Sites of a correlated event are listed under
+// it, leaving out those the event has already alerted. It does nothing when
+// no site is left to alert. Every anomaly is also sent to the webhooks of
+// the watchlists its site is on (see NotifyWatchlistWebhooks).
+func PublishAnomalies(ctx context.Context, evals []AnomalyEvaluation) error {
+	var anomalous []AnomalyEvaluation
+	for _, e := range evals {
+		if e.Anomalous {
+			anomalous = append(anomalous, e)
+		}
+	}
+	if len(anomalous) == 0 {
+		return nil
+	}
+	// After the SNS alert, which shouldn't wait on webhook retries
+	defer NotifyWatchlistWebhooks(ctx, anomalous)
+	events, single := correlateAnomalies(ctx, anomalous)
+
+	var count int
+	var b strings.Builder
+	for _, ea := range events {
+		e := ea.event
+		fmt.Fprintf(&b, "Correlated event in %s: %d stations anomalous since %s (%d new)\nEvent: %s\n",
+			e.Name, len(e.Sites), time.UnixMilli(e.CreatedOn).UTC().Format("2006-01-02 15:04 UTC"), len(ea.sites), e.EventID)
+		for _, ev := range ea.evals {
+			count++
+			writeAnomalyLine(ctx, &b, ev)
+		}
+		b.WriteString("\n")
+	}
+	for _, ev := range single {
+		count++
+		writeAnomalyLine(ctx, &b, ev)
+	}
+	if count == 0 {
+		return nil
+	}
+	subject := fmt.Sprintf("AquaWatch Anomalies Detected (%d)", count)
+	if len(events) == 1 && len(single) == 0 {
+		subject = fmt.Sprintf("AquaWatch Correlated Event: %s (%d stations)", events[0].event.Name, len(events[0].event.Sites))
+	}
+	return PublishAlert(ctx, subject, b.String())
+}
+
+func writeAnomalyLine(ctx context.Context, b *strings.Builder, e AnomalyEvaluation) {
+	if e.Reason != "" {
+		fmt.Fprintf(b, "Site %s anomalous: %s\n", e.Site, e.Reason)
+	} else {
+		fmt.Fprintf(b, "Site %s anomalous: observed=%.2f predicted=%.2f (%.1f%%)\n", e.Site, e.ObservedValue, e.PredictedValue, e.PercentChange)
+	}
+	if s := impactStatement(ctx, e.Site, e.Parameter, e.ObservedValue, e.PredictedValue); s != "" {
+		fmt.Fprintf(b, "  Impact: %s\n", s)
+	}
+	if e.Percentile != nil {
+		fmt.Fprintf(b, "  Context: observed value is %s\n", e.Percentile.Describe())
+	}
+	if e.LeadTime != nil {
+		fmt.Fprintf(b, "  Lead time: %s\n", e.LeadTime.Describe())
 	}
-	return last, nil
 }
 
 // ProcessInferAndDetect executes the flow: fetch -> preprocess CSV -> store -> infer -> detect anomaly.
 // thresholdPercent is a percentage (e.g., 10 means 10%).
-func ProcessInferAndDetect(ctx context.Context, stationID, parameter string) (*AnomalyResult, error) {
+// Progress is recorded in the prediction tracker (started -> completed/failed) on a best-effort basis.
+func ProcessInferAndDetect(ctx context.Context, stationID, parameter string) (res *AnomalyResult, err error) {
 	if stationID == "" {
-		return nil, errors.New("station id required")
+		return nil, fmt.Errorf("%w: station id required", ErrValidation)
 	}
 	if parameter == "" {
 		parameter = "00060"
 	}
 
-	raw, err := GetWaterDataBatch([]string{stationID}, parameter)
+	if terr := AddPredictionTrackerStarted(ctx, stationID, ""); terr != nil {
+		log.Printf("prediction tracker start failed for %s: %v", stationID, terr)
+	}
+	defer func() {
+		status, msg := PredictionStatusCompleted, ""
+		if err != nil {
+			status, msg = PredictionStatusFailed, err.Error()
+		}
+		// Record the outcome even when ctx was cancelled mid-flow.
+		if terr := UpdatePredictionTrackerStatus(context.WithoutCancel(ctx), stationID, status, msg); terr != nil {
+			log.Printf("prediction tracker update failed for %s: %v", stationID, terr)
+		}
+	}()
+
+	raw, err := GetWaterDataBatch(ctx, []string{stationID}, parameter)
 	if err != nil {
 		return nil, err
 	}
@@ -122,16 +243,19 @@ func ProcessInferAndDetect(ctx context.Context, stationID, parameter string) (*A
 	}
 
 	bucket := os.Getenv("S3_BUCKET")
-	key := fmt.Sprintf("processed/%s/%d.csv", stationID, time.Now().UTC().Unix())
+	key := Layout().StationSnapshotKey(stationID, time.Now().UTC())
 	if bucket != "" {
-		_ = SaveToS3WithKey(ctx, csvBytes, bucket, key)
+		_ = SaveObject(ctx, csvBytes, bucket, key, ObjectOptions(ctx, key, PurposeProcessed, map[string]string{
+			MetaSites:         stationID,
+			MetaSchemaVersion: ProcessedSchemaVersion,
+		}))
 	}
 
 	endpoint := os.Getenv("SAGEMAKER_ENDPOINT")
 	if endpoint == "" {
 		return nil, errors.New("SAGEMAKER_ENDPOINT not configured")
 	}
-	targetModel := os.Getenv("DEFAULT_MODEL")
+	targetModel := ProfileFor(parameter).DefaultModel()
 	if targetModel == "" {
 		return nil, errors.New("DEFAULT_MODEL not configured")
 	}
@@ -153,29 +277,48 @@ func ProcessInferAndDetect(ctx context.Context, stationID, parameter string) (*A
 		b.WriteByte('\n')
 	}
 
+	// Forecast rows for the lead time follow the observed rows, so the last
+	// observed row's prediction is at index rows-1.
+	rows := strings.Count(b.String(), "\n")
+	now := time.Now().UTC()
+	next := nextImpact(ctx, stationID, parameter, observed)
+	if next != nil && rows > 0 {
+		b.WriteString(forecastRows(b.String(), now, forecastHorizonHours()))
+	}
+
 	predOut, err := InvokeEndpoint(ctx, endpoint, []byte(b.String()), targetModel)
 	if err != nil {
 		return nil, err
 	}
-	log.Println("for station", stationID, "predOut", string(predOut))
-	predicted, err := parsePredictions(predOut)
+	LogPayload("prediction for "+stationID, predOut)
+	values, err := ParsePredictionValues(predOut)
 	if err != nil {
 		return nil, err
 	}
+	predicted := values[len(values)-1]
+	var leadTime *FloodLeadTime
+	if next != nil && len(values) > rows && rows > 0 {
+		predicted = values[rows-1]
+		leadTime = estimateLeadTime(next, observed, values[rows:], now)
+	}
 
 	// Round observed and predicted to 2 decimal places for response consistency
 	obsRounded := math.Round(observed*100) / 100
 	predRounded := math.Round(predicted*100) / 100
 
-	den := math.Max(1e-9, math.Abs(observed))
-	percent := math.Abs(predicted-observed) / den * 100.0
-	anom := percent > defaultThresholdPercent && predicted > minPredictedValue
+	verdict := judgeObservation(parameter, observed, predicted)
 
 	return &AnomalyResult{
 		S3Key:          key,
 		ObservedValue:  obsRounded,
 		PredictedValue: predRounded,
-		PercentChange:  percent,
-		Anomalous:      anom,
+		PercentChange:  verdict.percent,
+		Anomalous:      verdict.anomalous,
+		Severity:       verdict.severity,
+		Unit:           verdict.unit,
+		Reason:         verdict.reason,
+		Model:          targetModel,
+		Percentile:     ObservedPercentile(ctx, stationID, parameter, observed, now),
+		LeadTime:       leadTime,
 	}, nil
 }
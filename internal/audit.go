@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+)
+
+// Audit actions recorded for security-sensitive operations.
+const (
+	AuditActionSMSSend             = "sms.send"
+	AuditActionSMSVerify           = "sms.verify"
+	AuditActionSMSCancel           = "sms.cancel"
+	AuditActionSMSResend           = "sms.resend"
+	AuditActionSessionMint         = "session.mint"
+	AuditActionSessionRefresh      = "session.refresh"
+	AuditActionEmailSend           = "email.send"
+	AuditActionEmailVerify         = "email.verify"
+	AuditActionSubscribe           = "alerts.subscribe"
+	AuditActionSubscribeResend     = "alerts.subscribe.resend"
+	AuditActionReportGenerate      = "report.generate"
+	AuditActionIngestStart         = "ingest.start"
+	AuditActionIngestExternal      = "ingest.external"
+	AuditActionExport              = "analytics.export"
+	AuditActionScheduleCreate      = "schedule.create"
+	AuditActionScheduleUpdate      = "schedule.update"
+	AuditActionScheduleDelete      = "schedule.delete"
+	AuditActionBackfillCreate      = "backfill.create"
+	AuditActionBackfillResume      = "backfill.resume"
+	AuditActionImpactCreate        = "impact.create"
+	AuditActionImpactDelete        = "impact.delete"
+	AuditActionBasinCreate         = "basin.create"
+	AuditActionBasinUpdate         = "basin.update"
+	AuditActionBasinDelete         = "basin.delete"
+	AuditActionTokenMint           = "token.mint"
+	AuditActionTokenRevoke         = "token.revoke"
+	AuditActionStationImport       = "stations.import"
+	AuditActionWatchlistArchive    = "watchlist.archive"
+	AuditActionWatchlistRestore    = "watchlist.restore"
+	AuditActionAlertImage          = "alert.image"
+	AuditActionAlertComment        = "alert.comment"
+	AuditActionDatasetUpload       = "dataset.upload"
+	AuditActionModelDownload       = "model.download"
+	AuditActionWebhookUpdate       = "webhook.update"
+	AuditActionWebhookDelete       = "webhook.delete"
+	AuditActionWebhookRedeliver    = "webhook.redeliver"
+	AuditActionCacheFlush          = "cache.flush"
+	AuditActionSubscriptionsExport = "subscriptions.export"
+	AuditActionSubscriptionsImport = "subscriptions.import"
+)
+
+// Audit results.
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+	AuditResultDenied  = "denied"
+)
+
+// AuditEntry is a single record in the audit-log table.
+// Table name defaults to "audit-log"; override with AUDIT_LOG_TABLE.
+// Keys: PK id; GSI gsi_recent (gsi_pk='recent', createdon) for time-ordered listing.
+type AuditEntry struct {
+	ID        string `dynamodbav:"id" json:"id"`
+	CreatedOn int64  `dynamodbav:"createdon" json:"createdon_ms"`
+	Actor     string `dynamodbav:"actor" json:"actor"`
+	Action    string `dynamodbav:"action" json:"action"`
+	Resource  string `dynamodbav:"resource" json:"resource"`
+	Result    string `dynamodbav:"result" json:"result"`
+	IP        string `dynamodbav:"ip" json:"ip"`
+	GSIPK     string `dynamodbav:"gsi_pk" json:"-"`
+}
+
+func auditLogTable() string {
+	return tableName("AUDIT_LOG_TABLE", "audit-log")
+}
+
+// RecordAudit writes an audit entry. ID, CreatedOn and the GSI key are filled
+// in when empty.
+func RecordAudit(ctx context.Context, entry AuditEntry) error {
+	if entry.Action == "" {
+		return fmt.Errorf("audit action is required")
+	}
+	now := time.Now().UTC()
+	if entry.CreatedOn == 0 {
+		entry.CreatedOn = now.UnixMilli()
+	}
+	if entry.ID == "" {
+		entry.ID = fmt.Sprintf("audit-%d", now.UnixNano())
+	}
+	entry.GSIPK = "recent"
+	return newRepository[AuditEntry](auditLogTable()).Create(ctx, entry, "id")
+}
+
+// ListAuditEntriesPage returns audit entries created since sinceEpochMs, newest
+// first. If action is non-empty only entries for that action are returned.
+// See ListRecentAlertsPage for cursor semantics.
+func ListAuditEntriesPage(ctx context.Context, sinceEpochMs int64, action string, limit int, cursor string) ([]AuditEntry, string, error) {
+	in, err := recentQueryInput(sinceEpochMs, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	if action != "" {
+		in.FilterExpression = awsString("#action = :action")
+		in.ExpressionAttributeNames = map[string]string{"#action": "action"}
+		av, err := attributevalue.Marshal(action)
+		if err != nil {
+			return nil, "", err
+		}
+		in.ExpressionAttributeValues[":action"] = av
+	}
+	items, next, err := newRepository[AuditEntry](auditLogTable()).Query(ctx, in, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedOn > items[j].CreatedOn })
+	return items, next, nil
+}
@@ -0,0 +1,414 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// A backfill loads months or years of USGS daily values for a set of sites
+// into one processed dataset (processed/backfill/<id>.csv), e.g. to train on
+// more history than the 30 days an ingest fetches. The date range is split
+// into per-site chunks, each run as a "backfill" site task by the site
+// worker. Completed chunk IDs are collected in the backfill record, so
+// resuming only re-queues the missing ones; once every chunk is done the
+// dataset manifest is rewritten in site and date order.
+
+const (
+	backfillIDPrefix         = "bf_"
+	maxBackfillSites         = 50
+	maxBackfillChunks        = 5000
+	maxBackfillDays          = 3660
+	defaultBackfillChunkDays = 30
+	backfillDateLayout       = "2006-01-02"
+)
+
+// Backfill statuses.
+const (
+	BackfillRunning   = "running"
+	BackfillCompleted = "completed"
+)
+
+// ErrBackfillNotFound is returned when a backfill ID has no record.
+var ErrBackfillNotFound = notFoundError("backfill not found")
+
+// ErrInvalidBackfill is returned for backfill specs that fail validation.
+var ErrInvalidBackfill = validationError("invalid backfill")
+
+// Backfill is a multi-chunk historical load.
+// Table name defaults to "backfills"; override with BACKFILLS_TABLE.
+// Keys: PK backfill_id.
+type Backfill struct {
+	BackfillID  string   `dynamodbav:"backfill_id" json:"backfill_id"`
+	Sites       []string `dynamodbav:"sites" json:"sites"`
+	Parameter   string   `dynamodbav:"parameter" json:"parameter"`
+	From        string   `dynamodbav:"from" json:"from"`
+	To          string   `dynamodbav:"to" json:"to"`
+	ChunkDays   int      `dynamodbav:"chunk_days" json:"chunk_days"`
+	Bucket      string   `dynamodbav:"bucket" json:"bucket"`
+	Dataset     string   `dynamodbav:"dataset" json:"dataset"`
+	Status      string   `dynamodbav:"status" json:"status"`
+	ChunksTotal int      `dynamodbav:"chunks_total" json:"chunks_total"`
+	// DoneChunks is a string set of completed chunk IDs.
+	DoneChunks  []string `dynamodbav:"done_chunks,stringset,omitempty" json:"-"`
+	ChunksDone  int      `dynamodbav:"-" json:"chunks_done"`
+	Rows        int64    `dynamodbav:"rows_written" json:"rows_written"`
+	CreatedBy   string   `dynamodbav:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedOn   int64    `dynamodbav:"createdon" json:"createdon_ms"`
+	ResumedOn   int64    `dynamodbav:"resumed_on,omitempty" json:"resumed_on_ms,omitempty"`
+	CompletedOn int64    `dynamodbav:"completed_on,omitempty" json:"completed_on_ms,omitempty"`
+}
+
+// BackfillSpec requests a backfill of sites over the days From through To
+// (YYYY-MM-DD, inclusive), fetched ChunkDays at a time (default 30).
+// Parameter defaults to 00060.
+type BackfillSpec struct {
+	Sites     []string `json:"sites"`
+	Parameter string   `json:"parameter"`
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	ChunkDays int      `json:"chunk_days"`
+}
+
+// BackfillChunk is one site's slice of the backfill's date range.
+type BackfillChunk struct {
+	Site  string
+	Start time.Time
+	End   time.Time
+}
+
+// ID identifies the chunk within its backfill; it also names the chunk's
+// dataset part, so IDs sort by site and date.
+func (c BackfillChunk) ID() string {
+	return c.Site + "_" + c.Start.Format("20060102") + "_" + c.End.Format("20060102")
+}
+
+func backfillsTable() string {
+	return tableName("BACKFILLS_TABLE", "backfills")
+}
+
+// normalize fills defaults and validates the spec.
+func (s *BackfillSpec) normalize() error {
+	if len(s.Sites) == 0 || len(s.Sites) > maxBackfillSites {
+		return fmt.Errorf("%w: between 1 and %d sites required", ErrInvalidBackfill, maxBackfillSites)
+	}
+	for _, site := range s.Sites {
+		if !siteIDPattern.MatchString(site) {
+			return fmt.Errorf("%w: invalid site id %q", ErrInvalidBackfill, site)
+		}
+	}
+	if s.Parameter == "" {
+		s.Parameter = "00060"
+	}
+	if !parameterCodePattern.MatchString(s.Parameter) {
+		return fmt.Errorf("%w: parameter must be a 5-digit USGS parameter code", ErrInvalidBackfill)
+	}
+	from, err := time.Parse(backfillDateLayout, s.From)
+	if err != nil {
+		return fmt.Errorf("%w: from must be YYYY-MM-DD", ErrInvalidBackfill)
+	}
+	to, err := time.Parse(backfillDateLayout, s.To)
+	if err != nil {
+		return fmt.Errorf("%w: to must be YYYY-MM-DD", ErrInvalidBackfill)
+	}
+	if to.Before(from) {
+		return fmt.Errorf("%w: to is before from", ErrInvalidBackfill)
+	}
+	if to.After(time.Now().UTC()) {
+		return fmt.Errorf("%w: to is in the future", ErrInvalidBackfill)
+	}
+	if days := int(to.Sub(from).Hours()/24) + 1; days > maxBackfillDays {
+		return fmt.Errorf("%w: range is limited to %d days", ErrInvalidBackfill, maxBackfillDays)
+	}
+	if s.ChunkDays == 0 {
+		s.ChunkDays = defaultBackfillChunkDays
+	}
+	if s.ChunkDays < 1 || s.ChunkDays > 366 {
+		return fmt.Errorf("%w: chunk_days must be between 1 and 366", ErrInvalidBackfill)
+	}
+	if n := len(backfillChunks(s.Sites, from, to, s.ChunkDays)); n > maxBackfillChunks {
+		return fmt.Errorf("%w: %d chunks exceed the limit of %d; use larger chunk_days or fewer sites", ErrInvalidBackfill, n, maxBackfillChunks)
+	}
+	return nil
+}
+
+// backfillChunks splits from..to into chunkDays-day windows for every site.
+func backfillChunks(sites []string, from, to time.Time, chunkDays int) []BackfillChunk {
+	var chunks []BackfillChunk
+	for _, site := range sites {
+		for start := from; !start.After(to); start = start.AddDate(0, 0, chunkDays) {
+			end := start.AddDate(0, 0, chunkDays-1)
+			if end.After(to) {
+				end = to
+			}
+			chunks = append(chunks, BackfillChunk{Site: site, Start: start, End: end})
+		}
+	}
+	return chunks
+}
+
+// Chunks returns every chunk of the backfill.
+func (b *Backfill) Chunks() []BackfillChunk {
+	from, _ := time.Parse(backfillDateLayout, b.From)
+	to, _ := time.Parse(backfillDateLayout, b.To)
+	return backfillChunks(b.Sites, from, to, b.ChunkDays)
+}
+
+// PendingChunks returns the chunks not yet completed.
+func (b *Backfill) PendingChunks() []BackfillChunk {
+	done := make(map[string]bool, len(b.DoneChunks))
+	for _, id := range b.DoneChunks {
+		done[id] = true
+	}
+	var pending []BackfillChunk
+	for _, c := range b.Chunks() {
+		if !done[c.ID()] {
+			pending = append(pending, c)
+		}
+	}
+	return pending
+}
+
+func (b *Backfill) withProgress() *Backfill {
+	b.ChunksDone = len(b.DoneChunks)
+	return b
+}
+
+// backfillTasks builds the site tasks of chunks.
+func backfillTasks(b *Backfill, chunks []BackfillChunk) []SiteTask {
+	tasks := make([]SiteTask, 0, len(chunks))
+	for _, c := range chunks {
+		tasks = append(tasks, SiteTask{
+			Kind:       SiteTaskBackfill,
+			BatchID:    b.BackfillID,
+			Sites:      []string{c.Site},
+			Parameter:  b.Parameter,
+			BackfillID: b.BackfillID,
+			Start:      c.Start.Format(backfillDateLayout),
+			End:        c.End.Format(backfillDateLayout),
+		})
+	}
+	return tasks
+}
+
+// CreateBackfill validates spec, stores the backfill and queues its chunks
+// on the site task queue. Errors match ErrInvalidBackfill for bad specs and
+// ErrSiteQueueNotConfigured without a queue. If queueing fails part way the
+// backfill is still returned with the error; ResumeBackfill re-queues it.
+func CreateBackfill(ctx context.Context, spec BackfillSpec, createdBy string) (*Backfill, error) {
+	if err := spec.normalize(); err != nil {
+		return nil, err
+	}
+	if !SiteTaskQueueEnabled() {
+		return nil, ErrSiteQueueNotConfigured
+	}
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return nil, errors.New("S3_BUCKET not configured")
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+	id = backfillIDPrefix + id
+	b := &Backfill{
+		BackfillID: id,
+		Sites:      spec.Sites,
+		Parameter:  spec.Parameter,
+		From:       spec.From,
+		To:         spec.To,
+		ChunkDays:  spec.ChunkDays,
+		Bucket:     bucket,
+		Dataset:    Layout().BackfillDatasetKey(id),
+		Status:     BackfillRunning,
+		CreatedBy:  createdBy,
+		CreatedOn:  time.Now().UTC().UnixMilli(),
+	}
+	chunks := b.Chunks()
+	b.ChunksTotal = len(chunks)
+	if err := newRepository[Backfill](backfillsTable()).Create(ctx, b, "backfill_id"); err != nil {
+		return nil, err
+	}
+	if err := EnqueueSiteTasks(ctx, backfillTasks(b, chunks)); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+// GetBackfill loads a backfill, returning ErrBackfillNotFound when missing.
+func GetBackfill(ctx context.Context, id string) (*Backfill, error) {
+	b, err := newRepository[Backfill](backfillsTable()).Get(ctx, map[string]any{"backfill_id": id})
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, ErrBackfillNotFound
+	}
+	return b.withProgress(), nil
+}
+
+// ListBackfills returns every backfill, newest first.
+func ListBackfills(ctx context.Context) ([]Backfill, error) {
+	table := backfillsTable()
+	paginator := dynamodb.NewScanPaginator(getDynamoClient(), &dynamodb.ScanInput{TableName: &table})
+	var out []Backfill
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var items []Backfill
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+	}
+	for i := range out {
+		out[i].withProgress()
+	}
+	slices.SortFunc(out, func(a, b Backfill) int { return cmp.Compare(b.CreatedOn, a.CreatedOn) })
+	return out, nil
+}
+
+// ResumeBackfill re-queues the chunks of backfill id that haven't completed,
+// e.g. after tasks ended up in the dead-letter queue, and returns how many
+// were queued. Chunks still in flight may run twice; that is harmless.
+func ResumeBackfill(ctx context.Context, id string) (int, error) {
+	b, err := GetBackfill(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	pending := b.PendingChunks()
+	if len(pending) == 0 {
+		// Finalization may have failed after the last chunk completed.
+		return 0, finalizeBackfill(ctx, b)
+	}
+	if err := EnqueueSiteTasks(ctx, backfillTasks(b, pending)); err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC().UnixMilli()
+	if err := updateBackfill(ctx, id, "SET resumed_on = :now", map[string]any{":now": now}, ""); err != nil {
+		log.Printf("recording resume of backfill %s failed: %v", id, err)
+	}
+	return len(pending), nil
+}
+
+// RunBackfillChunk fetches the daily values of one chunk, appends them to
+// the backfill dataset and marks the chunk done, finalizing the backfill
+// when it was the last one. Returns the rows written (0 for chunks already
+// done).
+func RunBackfillChunk(ctx context.Context, t SiteTask) (int, error) {
+	b, err := GetBackfill(ctx, t.BackfillID)
+	if err != nil {
+		return 0, err
+	}
+	start, err := time.Parse(backfillDateLayout, t.Start)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chunk start %q: %w", t.Start, err)
+	}
+	end, err := time.Parse(backfillDateLayout, t.End)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chunk end %q: %w", t.End, err)
+	}
+	chunk := BackfillChunk{Site: t.Sites[0], Start: start, End: end}
+	if slices.Contains(b.DoneChunks, chunk.ID()) {
+		return 0, nil
+	}
+
+	raw, err := getDailyValues(ctx, chunk.Site, b.Parameter, chunk.Start, chunk.End)
+	if err != nil {
+		return 0, err
+	}
+	csvBytes, err := PreprocessDataCSV(ctx, raw)
+	if err != nil {
+		return 0, fmt.Errorf("preprocess chunk %s: %w", chunk.ID(), err)
+	}
+	rows := strings.Count(string(csvBytes), "\n")
+	if rows > 0 {
+		if _, err := AppendDatasetPart(ctx, b.Bucket, b.Dataset, chunk.ID(), csvBytes, map[string]string{
+			MetaSites:      chunk.Site,
+			MetaDataSource: DataSourceDaily,
+		}); err != nil {
+			return 0, err
+		}
+	}
+	// Best-effort: recent chunks also fill in the station's statistics.
+	if err := RecordStationStats(ctx, b.Parameter, [][]byte{raw}); err != nil {
+		log.Printf("backfill %s chunk %s: updating station stats failed: %v", b.BackfillID, chunk.ID(), err)
+	}
+
+	// Only the first completion of a chunk counts its rows.
+	err = updateBackfill(ctx, b.BackfillID,
+		"ADD done_chunks :id, rows_written :rows",
+		map[string]any{
+			":id":    &types.AttributeValueMemberSS{Value: []string{chunk.ID()}},
+			":chunk": chunk.ID(),
+			":rows":  rows,
+		},
+		"NOT contains(done_chunks, :chunk)")
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return 0, nil
+	}
+	if err != nil {
+		return rows, err
+	}
+	updated, err := GetBackfill(ctx, b.BackfillID)
+	if err != nil {
+		return rows, err
+	}
+	if updated.ChunksDone >= updated.ChunksTotal && updated.Status != BackfillCompleted {
+		if err := finalizeBackfill(ctx, updated); err != nil {
+			return rows, fmt.Errorf("finalize backfill %s: %w", updated.BackfillID, err)
+		}
+	}
+	return rows, nil
+}
+
+// finalizeBackfill rewrites the dataset manifest in chunk order, registers
+// the dataset with Glue when enabled and marks the backfill completed.
+func finalizeBackfill(ctx context.Context, b *Backfill) error {
+	manifest, etag, err := loadDatasetManifest(ctx, b.Bucket, b.Dataset)
+	if err != nil {
+		return err
+	}
+	if manifest != nil && !slices.IsSorted(manifest.Parts) {
+		slices.Sort(manifest.Parts)
+		body, err := json.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		if err := putIfUnchanged(ctx, b.Bucket, DatasetManifestKey(b.Dataset), body, etag); err != nil {
+			return fmt.Errorf("write manifest: %w", err)
+		}
+	}
+	if manifest != nil && GlueRegistrationEnabled() {
+		if err := RegisterProcessedDataset(ctx, b.Bucket, b.Dataset); err != nil {
+			log.Printf("glue registration failed for %s: %v", b.Dataset, err)
+		}
+	}
+	return updateBackfill(ctx, b.BackfillID,
+		"SET #status = :completed, completed_on = :now",
+		map[string]any{":completed": BackfillCompleted, ":now": time.Now().UTC().UnixMilli()}, "")
+}
+
+// updateBackfill applies an update expression to an existing backfill, with
+// cond as an extra condition (see repository.Update).
+func updateBackfill(ctx context.Context, id, expr string, vals map[string]any, cond string) error {
+	condition := "attribute_exists(backfill_id)"
+	if cond != "" {
+		condition += " AND " + cond
+	}
+	return newRepository[Backfill](backfillsTable()).Update(ctx, map[string]any{"backfill_id": id}, expr, vals, condition)
+}
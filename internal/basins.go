@@ -0,0 +1,295 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"aquawatch/internal/cache"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// A basin groups the stations of one watershed so they can be ingested,
+// swept for anomalies and summarized together. Group operations expand the
+// basin to its sites and go through the same paths as explicit site lists.
+
+const (
+	basinIDPrefix = "bsn_"
+	// MaxBasinSites matches the largest queued ingest or anomaly sweep.
+	MaxBasinSites = 1000
+)
+
+// ErrBasinNotFound is returned when a basin ID has no record.
+var ErrBasinNotFound = notFoundError("basin not found")
+
+// ErrInvalidBasin is returned for basin specs that fail validation.
+var ErrInvalidBasin = validationError("invalid basin")
+
+// Basin is a named group of stations.
+// Table name defaults to "basins"; override with BASINS_TABLE.
+// Keys: PK basin_id.
+type Basin struct {
+	BasinID     string   `dynamodbav:"basin_id" json:"basin_id"`
+	Name        string   `dynamodbav:"name" json:"name"`
+	Description string   `dynamodbav:"description,omitempty" json:"description,omitempty"`
+	Sites       []string `dynamodbav:"sites" json:"sites"`
+	CreatedBy   string   `dynamodbav:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedOn   int64    `dynamodbav:"createdon" json:"createdon_ms"`
+	UpdatedOn   int64    `dynamodbav:"updatedon" json:"updatedon_ms"`
+	Version     int64    `dynamodbav:"version" json:"version"`
+}
+
+// BasinSpec is the user-editable part of a basin. Duplicate sites are
+// dropped, keeping the first occurrence.
+type BasinSpec struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Sites       []string `json:"sites"`
+}
+
+func (s *BasinSpec) normalize() error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" || len(s.Name) > 100 {
+		return fmt.Errorf("%w: name is required (at most 100 characters)", ErrInvalidBasin)
+	}
+	s.Description = strings.TrimSpace(s.Description)
+	if len(s.Description) > 500 {
+		return fmt.Errorf("%w: description is at most 500 characters", ErrInvalidBasin)
+	}
+	seen := map[string]bool{}
+	sites := make([]string, 0, len(s.Sites))
+	for _, site := range s.Sites {
+		site = strings.TrimSpace(site)
+		if !siteIDPattern.MatchString(site) {
+			return fmt.Errorf("%w: invalid site id %q", ErrInvalidBasin, site)
+		}
+		if !seen[site] {
+			seen[site] = true
+			sites = append(sites, site)
+		}
+	}
+	if len(sites) == 0 || len(sites) > MaxBasinSites {
+		return fmt.Errorf("%w: between 1 and %d sites required", ErrInvalidBasin, MaxBasinSites)
+	}
+	s.Sites = sites
+	return nil
+}
+
+func basinsTable() string {
+	return tableName("BASINS_TABLE", "basins")
+}
+
+// CreateBasin validates spec and stores a new basin. Errors match
+// ErrInvalidBasin for bad specs.
+func CreateBasin(ctx context.Context, spec BasinSpec, createdBy string) (*Basin, error) {
+	if err := spec.normalize(); err != nil {
+		return nil, err
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().UnixMilli()
+	b := &Basin{
+		BasinID:     basinIDPrefix + id,
+		Name:        spec.Name,
+		Description: spec.Description,
+		Sites:       spec.Sites,
+		CreatedBy:   createdBy,
+		CreatedOn:   now,
+		UpdatedOn:   now,
+		Version:     1,
+	}
+	if err := newRepository[Basin](basinsTable()).Create(ctx, b, "basin_id"); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// GetBasin loads a basin, returning ErrBasinNotFound when missing.
+func GetBasin(ctx context.Context, id string) (*Basin, error) {
+	b, err := newRepository[Basin](basinsTable()).Get(ctx, map[string]any{"basin_id": id})
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, ErrBasinNotFound
+	}
+	return b, nil
+}
+
+// ListBasins returns every basin, ordered by name.
+func ListBasins(ctx context.Context) ([]Basin, error) {
+	table := basinsTable()
+	paginator := dynamodb.NewScanPaginator(getDynamoClient(), &dynamodb.ScanInput{TableName: &table})
+	out := []Basin{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var items []Basin
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+	}
+	slices.SortFunc(out, func(a, b Basin) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), strings.Compare(a.BasinID, b.BasinID))
+	})
+	return out, nil
+}
+
+// UpdateBasin replaces the spec of basin id with optimistic locking on
+// expectedVersion. Errors match ErrBasinNotFound, ErrVersionConflict or
+// ErrInvalidBasin.
+func UpdateBasin(ctx context.Context, id string, spec BasinSpec, expectedVersion int64) (*Basin, error) {
+	if err := spec.normalize(); err != nil {
+		return nil, err
+	}
+	current, err := GetBasin(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if current.Version != expectedVersion {
+		return nil, &VersionConflictError{Table: basinsTable(), Expected: expectedVersion}
+	}
+	updated := *current
+	updated.Name = spec.Name
+	updated.Description = spec.Description
+	updated.Sites = spec.Sites
+	updated.UpdatedOn = time.Now().UTC().UnixMilli()
+	updated.Version = expectedVersion + 1
+
+	table := basinsTable()
+	item, err := attributevalue.MarshalMap(updated)
+	if err != nil {
+		return nil, err
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{":expected": expectedVersion})
+	if err != nil {
+		return nil, err
+	}
+	_, err = getDynamoClient().PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 &table,
+		Item:                      item,
+		ConditionExpression:       awsString("attribute_exists(basin_id) AND #version = :expected"),
+		ExpressionAttributeNames:  map[string]string{"#version": "version"},
+		ExpressionAttributeValues: values,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return nil, &VersionConflictError{Table: table, Expected: expectedVersion}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// DeleteBasin removes basin id. Its stations and their data are untouched.
+func DeleteBasin(ctx context.Context, id string) error {
+	key, err := attributevalue.MarshalMap(map[string]any{"basin_id": id})
+	if err != nil {
+		return err
+	}
+	out, err := getDynamoClient().DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:    awsString(basinsTable()),
+		Key:          key,
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return err
+	}
+	if len(out.Attributes) == 0 {
+		return ErrBasinNotFound
+	}
+	return nil
+}
+
+// Basin status values: anomalous when any evaluated site is, unknown when no
+// site has been evaluated yet, ok otherwise.
+const (
+	BasinStatusOK        = "ok"
+	BasinStatusAnomalous = "anomalous"
+	BasinStatusUnknown   = "unknown"
+)
+
+// BasinStatus aggregates the latest persisted anomaly evaluations of a
+// basin's sites.
+type BasinStatus struct {
+	BasinID          string   `json:"basin_id"`
+	Name             string   `json:"name"`
+	Parameter        string   `json:"parameter"`
+	Status           string   `json:"status"`
+	Sites            int      `json:"sites"`
+	Evaluated        int      `json:"evaluated"`
+	Anomalous        int      `json:"anomalous"`
+	AnomalousSites   []string `json:"anomalous_sites"`
+	Missing          []string `json:"missing"`
+	MaxPercentChange float64  `json:"max_percent_change"`
+	MaxChangeSite    string   `json:"max_change_site,omitempty"`
+	LastEvaluatedOn  int64    `json:"last_evaluated_on_ms,omitempty"`
+}
+
+// basinStatusCache holds computed statuses briefly: each one reads the latest
+// evaluation of every site, and dashboards poll.
+var basinStatusCache = cache.New[string, BasinStatus]("basin-status", 500, 30*time.Second)
+
+// GetBasinStatus aggregates b's latest evaluations of parameter, without
+// running inference. Results may be up to 30 seconds old.
+func GetBasinStatus(ctx context.Context, b *Basin, parameter string) (BasinStatus, error) {
+	key := b.BasinID + "#" + parameter + "#" + fmt.Sprint(b.Version)
+	return basinStatusCache.GetOrLoad(ctx, key, func(ctx context.Context) (BasinStatus, error) {
+		evals, err := LatestAnomalyEvaluations(ctx, b.Sites, parameter)
+		if err != nil {
+			return BasinStatus{}, err
+		}
+		return summarizeBasin(b, parameter, evals), nil
+	})
+}
+
+func summarizeBasin(b *Basin, parameter string, evals []AnomalyEvaluation) BasinStatus {
+	s := BasinStatus{
+		BasinID:        b.BasinID,
+		Name:           b.Name,
+		Parameter:      parameter,
+		Status:         BasinStatusUnknown,
+		Sites:          len(b.Sites),
+		Evaluated:      len(evals),
+		AnomalousSites: []string{},
+		Missing:        []string{},
+	}
+	evaluated := make(map[string]bool, len(evals))
+	for _, e := range evals {
+		evaluated[e.Site] = true
+		if e.Anomalous {
+			s.Anomalous++
+			s.AnomalousSites = append(s.AnomalousSites, e.Site)
+		}
+		if s.MaxChangeSite == "" || e.PercentChange > s.MaxPercentChange {
+			s.MaxPercentChange = e.PercentChange
+			s.MaxChangeSite = e.Site
+		}
+		s.LastEvaluatedOn = max(s.LastEvaluatedOn, e.EvaluatedOn)
+	}
+	for _, site := range b.Sites {
+		if !evaluated[site] {
+			s.Missing = append(s.Missing, site)
+		}
+	}
+	switch {
+	case s.Anomalous > 0:
+		s.Status = BasinStatusAnomalous
+	case s.Evaluated > 0:
+		s.Status = BasinStatusOK
+	}
+	return s
+}
@@ -0,0 +1,508 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// ErrBlobNotFound is returned by BlobStore.Get when the object does not exist.
+var ErrBlobNotFound = notFoundError("blob not found")
+
+// ErrPreconditionFailed is returned by BlobStore.Put when a conditional write
+// (IfMatch / IfNoneMatch) is rejected because the object changed.
+var ErrPreconditionFailed = errors.New("blob precondition failed")
+
+// ErrPresignUnsupported is returned by stores that cannot issue presigned
+// upload URLs (the local filesystem store).
+var ErrPresignUnsupported = errors.New("presigned uploads not supported by this blob store")
+
+// PutOptions controls optional behavior of BlobStore.Put.
+type PutOptions struct {
+	ContentType string
+	// Metadata is stored as user-defined object metadata.
+	Metadata map[string]string
+	// Tags are stored as object tags (used by lifecycle rules).
+	Tags map[string]string
+	// IfMatch only writes if the current object's ETag equals this value.
+	IfMatch string
+	// IfNoneMatch only writes if no object exists at the key yet.
+	IfNoneMatch bool
+}
+
+// Blob is an object read from a BlobStore.
+type Blob struct {
+	Data []byte
+	ETag string
+	// Metadata holds user-defined object metadata (S3 only).
+	Metadata map[string]string
+}
+
+// BlobStore is the object storage used for raw payloads, processed datasets,
+// reports and exports. Objects are addressed by bucket and key; the S3
+// implementation maps them directly, the local one to <dir>/<bucket>/<key>.
+type BlobStore interface {
+	// Get returns the object's contents, ETag and metadata.
+	Get(ctx context.Context, bucket, key string) (*Blob, error)
+	// GetTail returns at most the last n bytes of the object along with the
+	// object's total size.
+	GetTail(ctx context.Context, bucket, key string, n int64) ([]byte, int64, error)
+	Put(ctx context.Context, bucket, key string, data []byte, opts PutOptions) error
+	// PutStream uploads everything read from r without buffering the whole
+	// object in memory. Conditional options are not supported.
+	PutStream(ctx context.Context, bucket, key string, r io.Reader, opts PutOptions) error
+	// PresignGet returns a URL a client can fetch the object from until expiry.
+	PresignGet(ctx context.Context, bucket, key string, expiry time.Duration) (string, error)
+	// PresignPut returns a URL a client can PUT the object to until expiry.
+	// The upload must send the same Content-Type.
+	PresignPut(ctx context.Context, bucket, key, contentType string, expiry time.Duration) (string, error)
+	// DeletePrefix removes every object whose key starts with prefix and
+	// returns how many were removed.
+	DeletePrefix(ctx context.Context, bucket, prefix string) (int, error)
+}
+
+var (
+	blobStoreOnce sync.Once
+	blobStore     BlobStore
+)
+
+// getBlobStore returns the process-wide BlobStore selected by BLOB_STORE:
+// "s3" (default) or "local", which stores objects under BLOB_STORE_DIR
+// (default ./data) so the pipeline can run without AWS.
+func getBlobStore() BlobStore {
+	blobStoreOnce.Do(func() {
+		switch strings.ToLower(strings.TrimSpace(os.Getenv("BLOB_STORE"))) {
+		case "local":
+			dir := os.Getenv("BLOB_STORE_DIR")
+			if dir == "" {
+				dir = "data"
+			}
+			blobStore = NewLocalBlobStore(dir)
+		default:
+			blobStore = &S3BlobStore{}
+		}
+	})
+	return blobStore
+}
+
+// -------------------- S3 --------------------
+
+// S3BlobStore stores objects in Amazon S3 (or an S3-compatible endpoint set
+// via S3_ENDPOINT).
+type S3BlobStore struct {
+	once   sync.Once
+	client *s3.Client
+}
+
+func (s *S3BlobStore) s3Client() *s3.Client {
+	s.once.Do(func() { s.client = getS3Client() })
+	return s.client
+}
+
+// Get implements BlobStore.
+func (s *S3BlobStore) Get(ctx context.Context, bucket, key string) (*Blob, error) {
+	out, err := s.getObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, fmt.Errorf("s3://%s/%s: %w", bucket, key, ErrBlobNotFound)
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(out.Body); err != nil {
+		return nil, err
+	}
+	return &Blob{Data: buf.Bytes(), ETag: aws.ToString(out.ETag), Metadata: out.Metadata}, nil
+}
+
+// getObject reads an object, from the bucket's replica in the S3 failover
+// region when the home region fails (see regions.go).
+func (s *S3BlobStore) getObject(ctx context.Context, in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	replica := getS3Replica()
+	if replica == nil {
+		return s.s3Client().GetObject(ctx, in)
+	}
+	bucket := aws.ToString(in.Bucket)
+	primary := s3Target{client: s.s3Client(), bucket: bucket}
+	secondary := s3Target{client: replica.client, bucket: replica.bucket(bucket)}
+	return withFailover(ctx, replica.f, primary, secondary, func(t s3Target) (*s3.GetObjectOutput, error) {
+		read := *in
+		read.Bucket = aws.String(t.bucket)
+		return t.client.GetObject(ctx, &read)
+	})
+}
+
+// s3Target is a bucket and the client of its region.
+type s3Target struct {
+	client *s3.Client
+	bucket string
+}
+
+// GetTail implements BlobStore with a suffix range request (bytes=-n).
+func (s *S3BlobStore) GetTail(ctx context.Context, bucket, key string, n int64) ([]byte, int64, error) {
+	out, err := s.getObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=-%d", n)),
+	})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, 0, fmt.Errorf("s3://%s/%s: %w", bucket, key, ErrBlobNotFound)
+		}
+		return nil, 0, err
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Content-Range is "bytes <start>-<end>/<size>"; without it the whole
+	// object was returned.
+	size := int64(len(data))
+	if cr := aws.ToString(out.ContentRange); cr != "" {
+		if i := strings.LastIndexByte(cr, '/'); i >= 0 {
+			if v, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				size = v
+			}
+		}
+	}
+	return data, size, nil
+}
+
+// Put implements BlobStore. The SHA-256 of data is stored as the sha256
+// metadata entry so reads can detect corruption.
+func (s *S3BlobStore) Put(ctx context.Context, bucket, key string, data []byte, opts PutOptions) error {
+	in := &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	}
+	applyPutOptions(in, opts)
+	in.Metadata = withChecksum(in.Metadata, data)
+	if opts.IfMatch != "" {
+		in.IfMatch = aws.String(opts.IfMatch)
+	}
+	if opts.IfNoneMatch {
+		in.IfNoneMatch = aws.String("*")
+	}
+	_, err := s.s3Client().PutObject(ctx, in)
+	if isS3PreconditionFailed(err) {
+		return fmt.Errorf("s3://%s/%s: %w", bucket, key, ErrPreconditionFailed)
+	}
+	return err
+}
+
+// PutStream implements BlobStore using a multipart upload: parts of
+// S3_UPLOAD_PART_MB (default 8, minimum 5) are uploaded S3_UPLOAD_CONCURRENCY
+// (default 3) at a time, so memory use is bounded by part size x concurrency.
+func (s *S3BlobStore) PutStream(ctx context.Context, bucket, key string, r io.Reader, opts PutOptions) error {
+	if opts.IfMatch != "" || opts.IfNoneMatch {
+		return errors.New("conditional writes are not supported for streaming uploads")
+	}
+	uploader := manager.NewUploader(s.s3Client(), func(u *manager.Uploader) {
+		u.PartSize = int64(envInt("S3_UPLOAD_PART_MB", 8)) * 1024 * 1024
+		if u.PartSize < manager.MinUploadPartSize {
+			u.PartSize = manager.MinUploadPartSize
+		}
+		u.Concurrency = envInt("S3_UPLOAD_CONCURRENCY", 3)
+	})
+	in := &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   r,
+	}
+	applyPutOptions(in, opts)
+	_, err := uploader.Upload(ctx, in)
+	return err
+}
+
+// PresignGet implements BlobStore.
+func (s *S3BlobStore) PresignGet(ctx context.Context, bucket, key string, expiry time.Duration) (string, error) {
+	presigner := s3.NewPresignClient(s.s3Client())
+	out, err := presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", err
+	}
+	return out.URL, nil
+}
+
+// PresignPut implements BlobStore.
+func (s *S3BlobStore) PresignPut(ctx context.Context, bucket, key, contentType string, expiry time.Duration) (string, error) {
+	presigner := s3.NewPresignClient(s.s3Client())
+	out, err := presigner.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", err
+	}
+	return out.URL, nil
+}
+
+// DeletePrefix implements BlobStore, listing the prefix and deleting up to
+// 1000 keys per DeleteObjects call.
+func (s *S3BlobStore) DeletePrefix(ctx context.Context, bucket, prefix string) (int, error) {
+	client := s.s3Client()
+	p := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix)})
+	var deleted int
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return deleted, err
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+		ids := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, obj := range page.Contents {
+			ids = append(ids, types.ObjectIdentifier{Key: obj.Key})
+		}
+		out, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return deleted, err
+		}
+		if len(out.Errors) > 0 {
+			return deleted + len(ids) - len(out.Errors), fmt.Errorf("delete s3://%s/%s: %d objects failed: %s", bucket, prefix, len(out.Errors), deref(out.Errors[0].Message))
+		}
+		deleted += len(ids)
+	}
+	return deleted, nil
+}
+
+// applyPutOptions copies content type, metadata and tags onto in.
+func applyPutOptions(in *s3.PutObjectInput, opts PutOptions) {
+	if opts.ContentType != "" {
+		in.ContentType = aws.String(opts.ContentType)
+	}
+	if len(opts.Metadata) > 0 {
+		in.Metadata = make(map[string]string, len(opts.Metadata)+1)
+		for k, v := range opts.Metadata {
+			in.Metadata[k] = v
+		}
+	}
+	if t := encodeTags(opts.Tags); t != "" {
+		in.Tagging = aws.String(t)
+	}
+}
+
+// isS3PreconditionFailed reports whether err is S3 rejecting a conditional
+// write because the object changed concurrently.
+func isS3PreconditionFailed(err error) bool {
+	var ae smithy.APIError
+	if !errors.As(err, &ae) {
+		return false
+	}
+	switch ae.ErrorCode() {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	}
+	return false
+}
+
+// -------------------- Local filesystem --------------------
+
+// LocalBlobStore stores objects as files under Dir/<bucket>/<key>. ETags are
+// the hex MD5 of the contents, like single-part S3 uploads. Conditional puts
+// are serialized within the process only.
+type LocalBlobStore struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+// NewLocalBlobStore returns a LocalBlobStore rooted at dir.
+func NewLocalBlobStore(dir string) *LocalBlobStore {
+	return &LocalBlobStore{Dir: dir}
+}
+
+func (l *LocalBlobStore) path(bucket, key string) (string, error) {
+	p := filepath.Join(l.Dir, bucket, filepath.FromSlash(key))
+	root := filepath.Join(l.Dir, bucket) + string(filepath.Separator)
+	if !strings.HasPrefix(p, root) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return p, nil
+}
+
+func localETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// Get implements BlobStore.
+func (l *LocalBlobStore) Get(ctx context.Context, bucket, key string) (*Blob, error) {
+	p, err := l.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", p, ErrBlobNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Blob{Data: data, ETag: localETag(data)}, nil
+}
+
+// GetTail implements BlobStore.
+func (l *LocalBlobStore) GetTail(ctx context.Context, bucket, key string, n int64) ([]byte, int64, error) {
+	p, err := l.path(bucket, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, fmt.Errorf("%s: %w", p, ErrBlobNotFound)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := fi.Size()
+	start := max(size-n, 0)
+	data := make([]byte, size-start)
+	if _, err := f.ReadAt(data, start); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	return data, size, nil
+}
+
+// Put implements BlobStore. ContentType, Metadata and Tags are ignored.
+func (l *LocalBlobStore) Put(ctx context.Context, bucket, key string, data []byte, opts PutOptions) error {
+	p, err := l.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if opts.IfMatch != "" || opts.IfNoneMatch {
+		current, err := os.ReadFile(p)
+		exists := err == nil
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if (opts.IfNoneMatch && exists) || (opts.IfMatch != "" && (!exists || localETag(current) != opts.IfMatch)) {
+			return fmt.Errorf("%s: %w", p, ErrPreconditionFailed)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// Write to a temp file and rename so readers never see partial objects.
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// PutStream implements BlobStore by copying r to a temp file and renaming it
+// into place once complete.
+func (l *LocalBlobStore) PutStream(ctx context.Context, bucket, key string, r io.Reader, opts PutOptions) error {
+	if opts.IfMatch != "" || opts.IfNoneMatch {
+		return errors.New("conditional writes are not supported for streaming uploads")
+	}
+	p, err := l.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// PresignGet implements BlobStore by returning a file:// URL; expiry is ignored.
+func (l *LocalBlobStore) PresignGet(ctx context.Context, bucket, key string, expiry time.Duration) (string, error) {
+	p, err := l.path(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String(), nil
+}
+
+// DeletePrefix implements BlobStore by removing matching files under the
+// bucket directory.
+func (l *LocalBlobStore) DeletePrefix(ctx context.Context, bucket, prefix string) (int, error) {
+	root := filepath.Join(l.Dir, bucket)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var deleted int
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(filepath.ToSlash(rel), prefix) {
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		deleted++
+		return nil
+	})
+	return deleted, err
+}
+
+// PresignPut implements BlobStore; clients cannot upload to the local store.
+func (l *LocalBlobStore) PresignPut(ctx context.Context, bucket, key, contentType string, expiry time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
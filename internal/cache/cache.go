@@ -0,0 +1,194 @@
+// Package cache is a small in-memory cache for hot lookups (SNS topic ARNs,
+// station statistics, ...): a fixed number of entries, evicted least
+// recently used first, each expiring a fixed time after it was set. Caches
+// live per process, so in Lambda they last as long as a warm container;
+// values may be up to the TTL stale across processes.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Cache is a TTL+LRU cache. It is safe for concurrent use.
+type Cache[K comparable, V any] struct {
+	name string
+	size int
+	ttl  time.Duration
+
+	mu        sync.Mutex
+	ll        *list.List // front is most recently used
+	items     map[K]*list.Element
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// New returns a cache holding up to size entries for ttl each, registered
+// under name for Snapshot and Flush. Creating a second cache with a name
+// replaces the first in the registry.
+func New[K comparable, V any](name string, size int, ttl time.Duration) *Cache[K, V] {
+	c := &Cache[K, V]{
+		name:  name,
+		size:  max(size, 1),
+		ttl:   ttl,
+		ll:    list.New(),
+		items: map[K]*list.Element{},
+	}
+	register(name, c)
+	return c
+}
+
+// Get returns the value cached for key, if present and not expired.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		if time.Now().Before(e.expires) {
+			c.ll.MoveToFront(el)
+			c.hits++
+			return e.value, true
+		}
+		c.removeElement(el)
+	}
+	c.misses++
+	var zero V
+	return zero, false
+}
+
+// Set caches value for key, evicting the least recently used entry when the
+// cache is full.
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value, e.expires = value, expires
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&entry[K, V]{key: key, value: value, expires: expires})
+	for c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+// Delete removes key, e.g. after the value it caches changed.
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// Purge removes every entry.
+func (c *Cache[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	clear(c.items)
+}
+
+// GetOrLoad returns the cached value for key, or calls load and caches its
+// result. Errors aren't cached. Concurrent misses for a key may each call
+// load.
+func (c *Cache[K, V]) GetOrLoad(ctx context.Context, key K, load func(context.Context) (V, error)) (V, error) {
+	if v, ok := c.Get(key); ok {
+		return v, nil
+	}
+	v, err := load(ctx)
+	if err != nil {
+		return v, err
+	}
+	c.Set(key, v)
+	return v, nil
+}
+
+func (c *Cache[K, V]) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry[K, V]).key)
+}
+
+// Stats are a cache's counters since the process started. HitRate is the
+// share of lookups that hit (0 before the first lookup).
+type Stats struct {
+	Name       string  `json:"name"`
+	Size       int     `json:"size"`
+	Capacity   int     `json:"capacity"`
+	TTLSeconds float64 `json:"ttl_seconds"`
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	Evictions  int64   `json:"evictions"`
+	HitRate    float64 `json:"hit_rate"`
+}
+
+func (c *Cache[K, V]) stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := Stats{
+		Name:       c.name,
+		Size:       c.ll.Len(),
+		Capacity:   c.size,
+		TTLSeconds: c.ttl.Seconds(),
+		Hits:       c.hits,
+		Misses:     c.misses,
+		Evictions:  c.evictions,
+	}
+	if lookups := c.hits + c.misses; lookups > 0 {
+		s.HitRate = float64(c.hits) / float64(lookups)
+	}
+	return s
+}
+
+// registered is the type-erased view of a Cache kept in the registry.
+type registered interface {
+	stats() Stats
+	Purge()
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]registered{}
+)
+
+func register(name string, c registered) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = c
+}
+
+// Snapshot returns the stats of every registered cache, by name.
+func Snapshot() []Stats {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	out := make([]Stats, 0, len(registry))
+	for _, c := range registry {
+		out = append(out, c.stats())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Flush purges the cache registered under name, reporting whether it exists.
+func Flush(name string) bool {
+	registryMu.Lock()
+	c, ok := registry[name]
+	registryMu.Unlock()
+	if ok {
+		c.Purge()
+	}
+	return ok
+}
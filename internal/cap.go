@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"cmp"
+	"encoding/xml"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Alerts are rendered as CAP 1.2 (Common Alerting Protocol, OASIS) messages
+// for emergency-management systems. An open or acknowledged alert is an
+// "Alert" message; a resolved one is an "Update" referencing the original,
+// with urgency "Past", so consumers can clear it.
+
+// CAPContentType is the media type CAP messages are served with.
+const CAPContentType = "application/cap+xml"
+
+// capSiteGeocode names the geocode each impacted USGS site is listed under.
+const capSiteGeocode = "USGS-site"
+
+// CAPAlert is a CAP 1.2 alert message with the elements AquaWatch fills.
+type CAPAlert struct {
+	XMLName    xml.Name `xml:"urn:oasis:names:tc:emergency:cap:1.2 alert"`
+	Identifier string   `xml:"identifier"`
+	Sender     string   `xml:"sender"`
+	Sent       string   `xml:"sent"`
+	Status     string   `xml:"status"`
+	MsgType    string   `xml:"msgType"`
+	Scope      string   `xml:"scope"`
+	References string   `xml:"references,omitempty"`
+	Info       CAPInfo  `xml:"info"`
+}
+
+// CAPInfo is the info block of a CAP alert.
+type CAPInfo struct {
+	Language    string     `xml:"language"`
+	Category    string     `xml:"category"`
+	Event       string     `xml:"event"`
+	Urgency     string     `xml:"urgency"`
+	Severity    string     `xml:"severity"`
+	Certainty   string     `xml:"certainty"`
+	Effective   string     `xml:"effective,omitempty"`
+	SenderName  string     `xml:"senderName"`
+	Headline    string     `xml:"headline"`
+	Description string     `xml:"description"`
+	Web         string     `xml:"web,omitempty"`
+	Parameters  []CAPValue `xml:"parameter"`
+	Area        CAPArea    `xml:"area"`
+}
+
+// CAPArea is the area block of a CAP info.
+type CAPArea struct {
+	AreaDesc string     `xml:"areaDesc"`
+	Geocodes []CAPValue `xml:"geocode"`
+}
+
+// CAPValue is a CAP valueName/value pair (parameters and geocodes).
+type CAPValue struct {
+	ValueName string `xml:"valueName"`
+	Value     string `xml:"value"`
+}
+
+// capSender returns the CAP sender: CAP_SENDER, by default "aquawatch".
+func capSender() string {
+	if s := strings.TrimSpace(os.Getenv("CAP_SENDER")); s != "" {
+		return s
+	}
+	return "aquawatch"
+}
+
+// capSeverity maps alert severities (low, medium, high) to CAP severities.
+func capSeverity(severity string) string {
+	switch strings.ToLower(severity) {
+	case "high":
+		return "Severe"
+	case "medium":
+		return "Moderate"
+	case "low":
+		return "Minor"
+	}
+	return "Unknown"
+}
+
+// capTime formats t as CAP requires: seconds precision with an explicit
+// offset, "-00:00" standing for UTC.
+func capTime(t time.Time) string {
+	return strings.Replace(t.UTC().Format("2006-01-02T15:04:05Z07:00"), "Z", "-00:00", 1)
+}
+
+// AlertToCAP converts an alert to a CAP 1.2 message.
+func AlertToCAP(a AlertTrackerItem) CAPAlert {
+	id := a.AlertID
+	if id == "" {
+		id = "alert-" + strconv.FormatInt(a.CreatedOnMs, 10)
+	}
+	sender := capSender()
+	created := time.UnixMilli(a.CreatedOnMs)
+	sites := strings.Join(a.SitesImpacted, ", ")
+
+	msg := CAPAlert{
+		Identifier: id,
+		Sender:     sender,
+		Sent:       capTime(created),
+		Status:     "Actual",
+		MsgType:    "Alert",
+		Scope:      "Public",
+	}
+	info := CAPInfo{
+		Language:   "en-US",
+		Category:   "Met",
+		Event:      "Hydrologic anomaly",
+		Urgency:    "Expected",
+		Severity:   capSeverity(a.Severity),
+		Certainty:  "Observed",
+		Effective:  capTime(created),
+		SenderName: "AquaWatch",
+		Headline:   a.AlertName,
+		Description: "Observed water data departed from the model's prediction at USGS site(s) " + sites +
+			" on " + a.AnomalyDate + ".",
+		Web: a.SignedURL,
+		Parameters: []CAPValue{
+			{ValueName: "alert_id", Value: id},
+			{ValueName: "anomaly_date", Value: a.AnomalyDate},
+			{ValueName: "state", Value: cmp.Or(a.State, AlertStateOpen)},
+		},
+		Area: CAPArea{AreaDesc: "USGS monitoring site(s) " + sites},
+	}
+	if info.Headline == "" {
+		info.Headline = "Hydrologic anomaly at " + sites
+	}
+	for _, site := range a.SitesImpacted {
+		info.Area.Geocodes = append(info.Area.Geocodes, CAPValue{ValueName: capSiteGeocode, Value: site})
+	}
+	if a.State == AlertStateResolved {
+		// The update stands for the resolution and references the original.
+		msg.Identifier = id + "-resolved"
+		msg.MsgType = "Update"
+		msg.References = strings.Join([]string{sender, id, capTime(created)}, ",")
+		if a.UpdatedOnMs > 0 {
+			msg.Sent = capTime(time.UnixMilli(a.UpdatedOnMs))
+		}
+		info.Urgency = "Past"
+		info.Headline = "Resolved: " + info.Headline
+	}
+	msg.Info = info
+	return msg
+}
+
+// RenderCAP renders an alert as a CAP 1.2 XML document.
+func RenderCAP(a AlertTrackerItem) ([]byte, error) {
+	body, err := xml.MarshalIndent(AlertToCAP(a), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(body, '\n')...), nil
+}
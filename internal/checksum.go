@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// metaSHA256 is the object metadata key holding the hex SHA-256 of the
+// contents, written on every non-streaming put.
+const metaSHA256 = "sha256"
+
+// ErrChecksumMismatch is matched (via errors.Is) by ChecksumMismatchError.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumMismatchError is returned when an object's contents don't hash to
+// the SHA-256 recorded when it was written.
+type ChecksumMismatchError struct {
+	Bucket   string
+	Key      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for s3://%s/%s: expected sha256 %s, got %s", e.Bucket, e.Key, e.Expected, e.Actual)
+}
+
+// Is reports whether target is ErrChecksumMismatch.
+func (e *ChecksumMismatchError) Is(target error) bool { return target == ErrChecksumMismatch }
+
+// SHA256Hex returns the lowercase hex SHA-256 of data.
+func SHA256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// withChecksum returns meta with the sha256 entry set for data, allocating
+// the map if needed.
+func withChecksum(meta map[string]string, data []byte) map[string]string {
+	if meta == nil {
+		meta = make(map[string]string, 1)
+	}
+	meta[metaSHA256] = SHA256Hex(data)
+	return meta
+}
+
+// verifyChecksum compares blob's contents with its sha256 metadata entry.
+// Objects written without a checksum (legacy, streamed, or from the local
+// store) pass unchecked.
+func verifyChecksum(bucket, key string, blob *Blob) error {
+	expected := blob.Metadata[metaSHA256]
+	if expected == "" {
+		return nil
+	}
+	if actual := SHA256Hex(blob.Data); actual != expected {
+		return &ChecksumMismatchError{Bucket: bucket, Key: key, Expected: expected, Actual: actual}
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package internal
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Research users with their own pipelines upload already preprocessed data
+// with POST /datasets: a (usually gzipped) CSV in the processed feature
+// schema. Rows are validated and rewritten while streaming into parts of
+// datasetPartRows rows under the processed prefix, and the manifest is
+// written only once every row checked out, so a rejected upload never shows
+// up as a dataset. The dataset is then registered in the datasets table; its
+// key is what the infer step takes as processed_key.
+
+// DataSourceClient marks dataset parts uploaded by API clients.
+const DataSourceClient = "client"
+
+const (
+	datasetIDPrefix = "ds_"
+	// MaxDatasetUploadBytes bounds an upload as sent (compressed).
+	MaxDatasetUploadBytes = 64 << 20
+	// maxDatasetBytes bounds an upload once decompressed.
+	maxDatasetBytes = 1 << 30
+	// maxDatasetRows bounds the rows of one dataset.
+	maxDatasetRows = 5_000_000
+	// datasetPartRows is the number of rows per part file.
+	datasetPartRows = 100_000
+	maxDatasetName  = 100
+	// maxDatasetSites bounds the sites listed for a dataset, which are kept
+	// in object metadata.
+	maxDatasetSites = 50
+)
+
+// DatasetStatusRegistered marks datasets ready for inference.
+const DatasetStatusRegistered = "registered"
+
+// processedColumns is the processed feature schema (ProcessedSchemaVersion).
+var processedColumns = []string{"value", "timestamp_unix", "latitude", "longitude", "wx_temp"}
+
+// ErrInvalidDataset is returned for uploads that fail schema validation.
+var ErrInvalidDataset = validationError("invalid dataset")
+
+// ErrDatasetNotFound is returned when a dataset ID has no record.
+var ErrDatasetNotFound = notFoundError("dataset not found")
+
+// Dataset is a client-uploaded processed dataset.
+// Table name defaults to "datasets"; override with DATASETS_TABLE.
+// Keys: PK dataset_id.
+type Dataset struct {
+	DatasetID string `dynamodbav:"dataset_id" json:"dataset_id"`
+	Name      string `dynamodbav:"name,omitempty" json:"name,omitempty"`
+	// Key is the processed dataset key (manifest at <key>/manifest.json).
+	Key       string   `dynamodbav:"key" json:"key"`
+	Parameter string   `dynamodbav:"parameter" json:"parameter"`
+	Sites     []string `dynamodbav:"sites,omitempty" json:"sites,omitempty"`
+	Rows      int      `dynamodbav:"rows" json:"rows"`
+	Parts     int      `dynamodbav:"parts" json:"parts"`
+	// Bytes is the size of the upload once decompressed.
+	Bytes         int64  `dynamodbav:"bytes" json:"bytes"`
+	SchemaVersion string `dynamodbav:"schema_version" json:"schema_version"`
+	Status        string `dynamodbav:"status" json:"status"`
+	CreatedBy     string `dynamodbav:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedOn     int64  `dynamodbav:"createdon" json:"createdon_ms"`
+}
+
+// DatasetSpec describes an upload.
+type DatasetSpec struct {
+	Name      string
+	Parameter string
+	// Sites lists the stations the rows come from, kept as metadata.
+	Sites []string
+}
+
+func datasetsTable() string {
+	return tableName("DATASETS_TABLE", "datasets")
+}
+
+func (spec *DatasetSpec) validate() error {
+	spec.Name = strings.TrimSpace(spec.Name)
+	if len(spec.Name) > maxDatasetName {
+		return fmt.Errorf("%w: name is at most %d characters", ErrInvalidDataset, maxDatasetName)
+	}
+	if spec.Parameter == "" {
+		spec.Parameter = "00060"
+	}
+	if !parameterCodePattern.MatchString(spec.Parameter) {
+		return fmt.Errorf("%w: parameter must be a 5-digit USGS code", ErrInvalidDataset)
+	}
+	if len(spec.Sites) > maxDatasetSites {
+		return fmt.Errorf("%w: at most %d sites", ErrInvalidDataset, maxDatasetSites)
+	}
+	for _, site := range spec.Sites {
+		if !siteIDPattern.MatchString(site) {
+			return fmt.Errorf("%w: invalid site id %q", ErrInvalidDataset, site)
+		}
+	}
+	return nil
+}
+
+// IngestClientDataset validates the CSV read from body (already
+// decompressed) against the processed schema, stores it as a new processed
+// dataset and registers it. An optional header row naming the columns is
+// skipped. Errors match ErrInvalidDataset, naming the first bad line.
+func IngestClientDataset(ctx context.Context, spec DatasetSpec, body io.Reader, createdBy string) (*Dataset, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("%w: S3_BUCKET not set", ErrIngestNotConfigured)
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+	ds := &Dataset{
+		DatasetID:     datasetIDPrefix + id,
+		Name:          spec.Name,
+		Parameter:     spec.Parameter,
+		Sites:         spec.Sites,
+		SchemaVersion: ProcessedSchemaVersion,
+		Status:        DatasetStatusRegistered,
+		CreatedBy:     createdBy,
+		CreatedOn:     time.Now().UTC().UnixMilli(),
+	}
+	ds.Key = Layout().ClientDatasetKey(ds.DatasetID)
+
+	counted := &limitedReader{r: body, n: maxDatasetBytes}
+	parts, err := writeClientDatasetParts(ctx, bucket, ds, counted)
+	ds.Bytes = counted.read
+	if err == nil {
+		manifest := DatasetManifest{Prefix: fmt.Sprintf("s3://%s/%s", bucket, DatasetPartsPrefix(ds.Key)), Parts: parts}
+		var data []byte
+		if data, err = json.Marshal(manifest); err == nil {
+			err = putIfUnchanged(ctx, bucket, DatasetManifestKey(ds.Key), data, "")
+		}
+	}
+	if err != nil {
+		// Nothing references the parts without a manifest; drop them.
+		if _, derr := getBlobStore().DeletePrefix(context.WithoutCancel(ctx), bucket, DatasetPartsPrefix(ds.Key)); derr != nil {
+			log.Printf("dataset %s: removing parts failed: %v", ds.DatasetID, derr)
+		}
+		return nil, err
+	}
+	if GlueRegistrationEnabled() {
+		if err := RegisterProcessedDataset(ctx, bucket, ds.Key); err != nil {
+			log.Printf("glue registration failed for %s: %v", ds.Key, err)
+		}
+	}
+	if err := newRepository[Dataset](datasetsTable()).Put(ctx, ds); err != nil {
+		return nil, fmt.Errorf("register dataset %s: %w", ds.DatasetID, err)
+	}
+	return ds, nil
+}
+
+// writeClientDatasetParts streams rows into part files and returns their
+// names, counting rows and parts on ds.
+func writeClientDatasetParts(ctx context.Context, bucket string, ds *Dataset, body io.Reader) ([]string, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	meta := map[string]string{MetaDataSource: DataSourceClient, MetaSchemaVersion: ProcessedSchemaVersion}
+	if len(ds.Sites) > 0 {
+		meta[MetaSites] = strings.Join(ds.Sites, ",")
+	}
+	var parts []string
+	buf := make([]byte, 0, datasetPartRows*48)
+	flush := func() error {
+		name := fmt.Sprintf("part-%05d.csv", len(parts)+1)
+		key := DatasetPartsPrefix(ds.Key) + name
+		if err := SaveObject(ctx, buf, bucket, key, ObjectOptions(ctx, key, PurposeProcessed, meta)); err != nil {
+			return fmt.Errorf("write part %s: %w", key, err)
+		}
+		parts = append(parts, name)
+		buf = buf[:0]
+		return nil
+	}
+	now := time.Now().UTC()
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, errDatasetTooLarge) {
+			return parts, fmt.Errorf("%w: more than %d bytes uncompressed", ErrInvalidDataset, maxDatasetBytes)
+		}
+		if err != nil {
+			return parts, fmt.Errorf("%w: line %d: %v", ErrInvalidDataset, line, err)
+		}
+		if line == 1 && isProcessedHeader(rec) {
+			continue
+		}
+		if ds.Rows == maxDatasetRows {
+			return parts, fmt.Errorf("%w: more than %d rows", ErrInvalidDataset, maxDatasetRows)
+		}
+		if buf, err = appendClientRow(buf, rec, now); err != nil {
+			return parts, fmt.Errorf("%w: line %d: %v", ErrInvalidDataset, line, err)
+		}
+		ds.Rows++
+		if ds.Rows%datasetPartRows == 0 {
+			if err := flush(); err != nil {
+				return parts, err
+			}
+		}
+	}
+	if ds.Rows == 0 {
+		return parts, fmt.Errorf("%w: no rows", ErrInvalidDataset)
+	}
+	if len(buf) > 0 {
+		if err := flush(); err != nil {
+			return parts, err
+		}
+	}
+	ds.Parts = len(parts)
+	return parts, nil
+}
+
+func isProcessedHeader(rec []string) bool {
+	if len(rec) != len(processedColumns) {
+		return false
+	}
+	for i, c := range processedColumns {
+		if strings.ToLower(strings.TrimSpace(rec[i])) != c {
+			return false
+		}
+	}
+	return true
+}
+
+// appendClientRow validates one record of the processed schema and appends
+// it to buf in the canonical format.
+func appendClientRow(buf []byte, rec []string, now time.Time) ([]byte, error) {
+	if len(rec) != len(processedColumns) {
+		return buf, fmt.Errorf("want %d columns (%s), got %d", len(processedColumns), strings.Join(processedColumns, ","), len(rec))
+	}
+	var f [5]float64
+	for i, col := range processedColumns {
+		v, err := strconv.ParseFloat(strings.TrimSpace(rec[i]), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return buf, fmt.Errorf("%s must be a finite number", col)
+		}
+		f[i] = v
+	}
+	unix := int64(f[1])
+	if float64(unix) != f[1] || unix < 0 || unix > now.Add(24*time.Hour).Unix() {
+		return buf, errors.New("timestamp_unix must be whole seconds, not in the future")
+	}
+	if math.Abs(f[2]) > 90 || math.Abs(f[3]) > 180 {
+		return buf, errors.New("invalid coordinates")
+	}
+	return appendProcessedRow(buf, f[0], unix, f[2], f[3], int64(math.Round(f[4]))), nil
+}
+
+var errDatasetTooLarge = errors.New("dataset too large")
+
+// limitedReader reads at most n bytes from r, failing with
+// errDatasetTooLarge beyond that instead of stopping silently, and counts
+// what it read.
+type limitedReader struct {
+	r    io.Reader
+	n    int64
+	read int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.read >= l.n {
+		// Probe for more input before failing, so a body of exactly n
+		// bytes passes.
+		var one [1]byte
+		if k, _ := l.r.Read(one[:]); k > 0 {
+			return 0, errDatasetTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.n-l.read {
+		p = p[:l.n-l.read]
+	}
+	k, err := l.r.Read(p)
+	l.read += int64(k)
+	return k, err
+}
+
+// GetDataset returns the dataset with the given ID. Errors match
+// ErrDatasetNotFound.
+func GetDataset(ctx context.Context, id string) (*Dataset, error) {
+	ds, err := newRepository[Dataset](datasetsTable()).Get(ctx, map[string]any{"dataset_id": id})
+	if err != nil {
+		return nil, err
+	}
+	if ds == nil {
+		return nil, ErrDatasetNotFound
+	}
+	return ds, nil
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Station comparisons line up the daily means already kept in each station's
+// statistics record, so side-by-side charts never re-read datasets or call
+// USGS.
+
+const (
+	// MaxCompareSites bounds the stations of one comparison.
+	MaxCompareSites = 20
+	// MaxCompareDays is the longest comparison: station statistics keep a
+	// year of daily means.
+	MaxCompareDays = 365
+)
+
+// StationComparison holds the daily means of several stations on one day
+// axis.
+type StationComparison struct {
+	Parameter string `json:"parameter"`
+	// Days is the common axis (station-local dates, oldest first), ending on
+	// the latest day any compared station has.
+	Days   []string         `json:"days"`
+	Series []ComparedSeries `json:"series"`
+	// Missing lists stations with no statistics for the parameter.
+	Missing []string `json:"missing"`
+}
+
+// ComparedSeries is one station's values on the comparison's axis.
+type ComparedSeries struct {
+	Site string `json:"site"`
+	// Values has one entry per day of the axis; null where the station has
+	// no daily mean.
+	Values []*float64 `json:"values"`
+	// Summary covers the days with values; omitted when there are none.
+	Summary *WindowStats `json:"summary,omitempty"`
+	// Latest is the last value on the axis.
+	Latest *float64 `json:"latest,omitempty"`
+	// PercentChange is from the first to the last value on the axis.
+	PercentChange *float64 `json:"percent_change,omitempty"`
+}
+
+// CompareStations aligns the last days daily means of sites for parameter.
+// Statistics are read through the station statistics cache, so results may
+// be a few minutes stale.
+func CompareStations(ctx context.Context, sites []string, parameter string, days int) (StationComparison, error) {
+	c := StationComparison{Parameter: parameter, Days: []string{}, Series: []ComparedSeries{}, Missing: []string{}}
+	var found []*StationStats
+	var end string
+	for _, site := range sites {
+		stats, err := GetStationStats(ctx, site, parameter)
+		if errors.Is(err, ErrStationStatsNotFound) {
+			c.Missing = append(c.Missing, site)
+			continue
+		}
+		if err != nil {
+			return StationComparison{}, fmt.Errorf("%s: %w", site, err)
+		}
+		found = append(found, stats)
+		end = max(end, stats.LatestDay)
+	}
+	if len(found) == 0 {
+		return c, nil
+	}
+
+	last, err := time.Parse(time.DateOnly, end)
+	if err != nil {
+		return StationComparison{}, fmt.Errorf("invalid latest day %q: %w", end, err)
+	}
+	index := make(map[string]int, days)
+	for i := range days {
+		day := last.AddDate(0, 0, i-days+1).Format(time.DateOnly)
+		index[day] = i
+		c.Days = append(c.Days, day)
+	}
+
+	for _, stats := range found {
+		s := ComparedSeries{Site: stats.Site, Values: make([]*float64, days)}
+		var values []float64
+		for _, d := range stats.Daily {
+			if i, ok := index[d.Day]; ok {
+				s.Values[i] = &d.Mean
+			}
+		}
+		for _, v := range s.Values {
+			if v != nil {
+				values = append(values, *v)
+			}
+		}
+		if len(values) > 0 {
+			summary := summarize(values)
+			first, latest := values[0], values[len(values)-1]
+			s.Summary, s.Latest = &summary, &latest
+			if first != 0 {
+				change := (latest - first) / first * 100
+				s.PercentChange = &change
+			}
+		}
+		c.Series = append(c.Series, s)
+	}
+	return c, nil
+}
@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// A site's completeness is how much of its expected cadence, one daily value
+// per day, the processed history store holds over a period. Only the store
+// is read: months no /history request has loaded count as missing and are
+// listed, so a sparse record can be told apart from one never loaded. A model trained on a site with long outages has seen little
+// of its behavior, so completeness is a first check of whether to trust it.
+
+// defaultCompletenessDays is the period checked when no start is given.
+const defaultCompletenessDays = 90
+
+// Completeness is the coverage of a site's daily values over Start..End.
+type Completeness struct {
+	Site      string `json:"site"`
+	Parameter string `json:"parameter"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	// ExpectedDays is the days of the period; ObservedDays those with a
+	// value. Percent is their ratio, rounded to one decimal.
+	ExpectedDays int     `json:"expected_days"`
+	ObservedDays int     `json:"observed_days"`
+	Percent      float64 `json:"percent"`
+	// Gaps are the runs of days without a value, oldest first;
+	// LongestOutage is the longest of them.
+	Gaps          []CompletenessGap `json:"gaps"`
+	LongestOutage *CompletenessGap  `json:"longest_outage,omitempty"`
+	// UnstoredMonths (YYYY-MM) have no partition in the store.
+	UnstoredMonths []string `json:"unstored_months"`
+}
+
+// CompletenessGap is a run of days (YYYY-MM-DD, inclusive) without a value.
+type CompletenessGap struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Days  int    `json:"days"`
+}
+
+// SiteCompleteness reports the coverage of site's daily values of parameter
+// from start to end (dates, UTC). end is capped at yesterday, the last day
+// with a published daily value; a zero start is defaultCompletenessDays
+// before end. Errors match ErrInvalidHistoryRange for bad ranges.
+func SiteCompleteness(ctx context.Context, site, parameter string, start, end time.Time) (*Completeness, error) {
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return nil, errors.New("S3_BUCKET not set")
+	}
+	end = end.UTC().Truncate(24 * time.Hour)
+	if last := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1); end.After(last) {
+		end = last
+	}
+	if start.IsZero() {
+		start = end.AddDate(0, 0, -defaultCompletenessDays+1)
+	}
+	start = start.UTC().Truncate(24 * time.Hour)
+	if start.After(end) {
+		return nil, fmt.Errorf("%w: start must not be after end (or yesterday)", ErrInvalidHistoryRange)
+	}
+	if end.Sub(start) >= MaxHistoryDays*24*time.Hour {
+		return nil, fmt.Errorf("%w: at most %d days", ErrInvalidHistoryRange, MaxHistoryDays)
+	}
+
+	var months []time.Time
+	for m := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(end); m = m.AddDate(0, 1, 0) {
+		months = append(months, m)
+	}
+	points := make([][]HistoryPoint, len(months))
+	stored := make([]bool, len(months))
+	errs := make([]error, len(months))
+	sem := make(chan struct{}, historyLoaders)
+	var wg sync.WaitGroup
+	for i, month := range months {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			var err error
+			points[i], _, err = readHistoryPartition(ctx, bucket, site, parameter, month)
+			switch {
+			case err == nil:
+				stored[i] = true
+			case !errors.Is(err, ErrBlobNotFound):
+				errs[i] = err
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	c := &Completeness{
+		Site:           site,
+		Parameter:      parameter,
+		Start:          start.Format(time.DateOnly),
+		End:            end.Format(time.DateOnly),
+		Gaps:           []CompletenessGap{},
+		UnstoredMonths: []string{},
+	}
+	observed := map[string]bool{}
+	for i, month := range months {
+		if !stored[i] {
+			c.UnstoredMonths = append(c.UnstoredMonths, month.Format("2006-01"))
+		}
+		for _, p := range points[i] {
+			observed[p.Day] = true
+		}
+	}
+	var gap *CompletenessGap
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		c.ExpectedDays++
+		d := day.Format(time.DateOnly)
+		if observed[d] {
+			c.ObservedDays++
+			gap = nil
+			continue
+		}
+		if gap == nil {
+			c.Gaps = append(c.Gaps, CompletenessGap{Start: d})
+			gap = &c.Gaps[len(c.Gaps)-1]
+		}
+		gap.End = d
+		gap.Days++
+	}
+	for i := range c.Gaps {
+		if c.LongestOutage == nil || c.Gaps[i].Days > c.LongestOutage.Days {
+			c.LongestOutage = &c.Gaps[i]
+		}
+	}
+	c.Percent = math.Round(float64(c.ObservedDays)/float64(c.ExpectedDays)*1000) / 10
+	return c, nil
+}
@@ -0,0 +1,425 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"aquawatch/internal/cache"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Anomalies at stations of the same basin (or, for stations in no basin, the
+// same 8-digit hydrologic unit) within the correlation window belong to one
+// correlated event. PublishAnomalies records each event and alerts only the
+// stations it hasn't alerted yet, in one message per sweep, so a flood moving
+// down a river produces one event with a few updates instead of one alert
+// per station. Correlation is best-effort: when a lookup or write fails, the
+// stations are alerted on their own.
+
+const (
+	eventGroupBasin = "basin:"
+	eventGroupHUC   = "huc:"
+	// hucDigits is the hydrologic unit level stations are grouped by
+	// (HUC-8, a subbasin).
+	hucDigits = 8
+)
+
+// ErrEventNotFound is returned when an event ID has no record.
+var ErrEventNotFound = notFoundError("correlated event not found")
+
+// CorrelatedEvent is a group of anomalous stations in one basin or
+// hydrologic unit, open while new anomalies keep arriving within the window.
+// Table name defaults to "correlated-events"; override with
+// CORRELATED_EVENTS_TABLE.
+// Keys: PK group (String, "basin:<basin_id>" or "huc:<huc8>"), SK createdon
+// (Number, epoch ms).
+// GSI: gsi_recent (gsi_pk = "recent", createdon).
+type CorrelatedEvent struct {
+	Group       string              `dynamodbav:"group" json:"group"`
+	CreatedOn   int64               `dynamodbav:"createdon" json:"createdon_ms"`
+	GSIPK       string              `dynamodbav:"gsi_pk" json:"-"`
+	EventID     string              `dynamodbav:"event_id" json:"event_id"`
+	Name        string              `dynamodbav:"name" json:"name"`
+	BasinID     string              `dynamodbav:"basin_id,omitempty" json:"basin_id,omitempty"`
+	HUC         string              `dynamodbav:"huc,omitempty" json:"huc,omitempty"`
+	Sites       []string            `dynamodbav:"sites,stringset" json:"sites"`
+	Evaluations []AnomalyEvaluation `dynamodbav:"evaluations" json:"evaluations"`
+	LastSeenOn  int64               `dynamodbav:"last_seen_on" json:"last_seen_on_ms"`
+}
+
+// EventID formats the ID of the event of group started at createdOn.
+func EventID(group string, createdOn int64) string {
+	return group + "@" + strconv.FormatInt(createdOn, 10)
+}
+
+// ParseEventID splits an event ID into its group and createdon key.
+func ParseEventID(id string) (string, int64, error) {
+	group, ms, ok := strings.Cut(id, "@")
+	createdOn, err := strconv.ParseInt(ms, 10, 64)
+	if !ok || err != nil || createdOn <= 0 || (!strings.HasPrefix(group, eventGroupBasin) && !strings.HasPrefix(group, eventGroupHUC)) {
+		return "", 0, fmt.Errorf("invalid event id %q", id)
+	}
+	return group, createdOn, nil
+}
+
+// ReportItems returns one report row per station of the event, from its
+// latest anomalous evaluation.
+func (e *CorrelatedEvent) ReportItems() []ReportItem {
+	latest := map[string]AnomalyEvaluation{}
+	for _, ev := range e.Evaluations {
+		if cur, ok := latest[ev.Site]; !ok || ev.EvaluatedOn > cur.EvaluatedOn {
+			latest[ev.Site] = ev
+		}
+	}
+	items := make([]ReportItem, 0, len(latest))
+	for _, site := range e.Sites {
+		ev, ok := latest[site]
+		if !ok {
+			continue
+		}
+		items = append(items, ReportItem{
+			Site:           site,
+			Reason:         fmt.Sprintf("%.1f%% from predicted; part of %s", ev.PercentChange, e.Name),
+			PredictedValue: ev.PredictedValue,
+			AnomalyDate:    time.UnixMilli(ev.EvaluatedOn).UTC().Format(time.RFC3339),
+			Parameter:      ev.Parameter,
+		})
+	}
+	return items
+}
+
+func correlatedEventsTable() string {
+	return tableName("CORRELATED_EVENTS_TABLE", "correlated-events")
+}
+
+// correlationWindow is how long an event stays open after its last anomaly
+// (CORRELATION_WINDOW_MINUTES, default 60). 0 disables correlation.
+func correlationWindow() time.Duration {
+	v := strings.TrimSpace(os.Getenv("CORRELATION_WINDOW_MINUTES"))
+	if v == "" {
+		return time.Hour
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return time.Hour
+	}
+	return time.Duration(n) * time.Minute
+}
+
+// GetCorrelatedEvent loads an event by ID, returning ErrEventNotFound when
+// missing.
+func GetCorrelatedEvent(ctx context.Context, id string) (*CorrelatedEvent, error) {
+	group, createdOn, err := ParseEventID(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrEventNotFound, err)
+	}
+	e, err := newRepository[CorrelatedEvent](correlatedEventsTable()).Get(ctx, map[string]any{"group": group, "createdon": createdOn})
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, ErrEventNotFound
+	}
+	return e, nil
+}
+
+// ListCorrelatedEventsPage returns events started since sinceEpochMs, newest
+// first. See ListRecentAlertsPage for cursor semantics.
+func ListCorrelatedEventsPage(ctx context.Context, sinceEpochMs int64, limit int, cursor string) ([]CorrelatedEvent, string, error) {
+	in, err := recentQueryInput(sinceEpochMs, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	return newRepository[CorrelatedEvent](correlatedEventsTable()).Query(ctx, in, cursor)
+}
+
+// eventGroup is the correlation group of a station.
+type eventGroup struct {
+	key     string
+	name    string
+	basinID string
+	huc     string
+}
+
+// basinIndexCache maps each station to the first basin (by name) that lists
+// it.
+var basinIndexCache = cache.New[string, map[string]Basin]("basin-index", 1, 5*time.Minute)
+
+// eventGroups returns the correlation group of each site that has one: its
+// basin, else its HUC-8.
+func eventGroups(ctx context.Context, sites []string) (map[string]eventGroup, error) {
+	index, err := basinIndexCache.GetOrLoad(ctx, "all", func(ctx context.Context) (map[string]Basin, error) {
+		basins, err := ListBasins(ctx)
+		if err != nil {
+			return nil, err
+		}
+		index := map[string]Basin{}
+		for _, b := range basins {
+			for _, site := range b.Sites {
+				if _, ok := index[site]; !ok {
+					index[site] = b
+				}
+			}
+		}
+		return index, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	groups := map[string]eventGroup{}
+	var rest []string
+	for _, site := range sites {
+		if b, ok := index[site]; ok {
+			groups[site] = eventGroup{key: eventGroupBasin + b.BasinID, name: b.Name, basinID: b.BasinID}
+		} else {
+			rest = append(rest, site)
+		}
+	}
+	hucs, err := siteHUCs(ctx, rest)
+	for site, huc := range hucs {
+		if len(huc) >= hucDigits {
+			huc = huc[:hucDigits]
+			groups[site] = eventGroup{key: eventGroupHUC + huc, name: "HUC " + huc, huc: huc}
+		}
+	}
+	return groups, err
+}
+
+// siteHUCCache holds station hydrologic unit codes ("" when USGS has none),
+// which don't change.
+var siteHUCCache = cache.New[string, string]("site-huc", 5000, 24*time.Hour)
+
+// siteHUCs returns the hydrologic unit code of each site, from the USGS site
+// service for sites not cached. On error it returns the codes it has.
+func siteHUCs(ctx context.Context, sites []string) (map[string]string, error) {
+	out := map[string]string{}
+	var missing []string
+	for _, site := range sites {
+		if huc, ok := siteHUCCache.Get(site); ok {
+			out[site] = huc
+		} else {
+			missing = append(missing, site)
+		}
+	}
+	// The site service takes up to 100 sites per request.
+	for chunk := range slices.Chunk(missing, 100) {
+		fetched, err := fetchSiteHUCs(ctx, chunk)
+		if err != nil {
+			return out, err
+		}
+		for _, site := range chunk {
+			huc := fetched[site]
+			siteHUCCache.Set(site, huc)
+			out[site] = huc
+		}
+	}
+	return out, nil
+}
+
+func fetchSiteHUCs(ctx context.Context, sites []string) (map[string]string, error) {
+	ctx, cancel := withStageTimeout(ctx, StageFetch)
+	defer cancel()
+	url := "https://waterservices.usgs.gov/nwis/site/?format=rdb&siteOutput=expanded&sites=" + strings.Join(sites, ",")
+	resp, err := usgsClient.Get(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("USGS site request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("USGS site service non-OK status: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading USGS site response failed: %w", err)
+	}
+	return parseSiteHUCs(body), nil
+}
+
+// parseSiteHUCs reads site_no and huc_cd from a USGS RDB (tab-separated)
+// site listing: comment lines, a header, a column-format line, then rows.
+func parseSiteHUCs(rdb []byte) map[string]string {
+	out := map[string]string{}
+	siteCol, hucCol := -1, -1
+	formatLine := false
+	sc := bufio.NewScanner(bytes.NewReader(rdb))
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		switch {
+		case siteCol < 0:
+			siteCol, hucCol = slices.Index(fields, "site_no"), slices.Index(fields, "huc_cd")
+			if siteCol < 0 || hucCol < 0 {
+				return out
+			}
+			formatLine = true
+		case formatLine:
+			formatLine = false
+		case len(fields) > max(siteCol, hucCol):
+			out[fields[siteCol]] = strings.TrimSpace(fields[hucCol])
+		}
+	}
+	return out
+}
+
+// correlate records anomalous evaluations of one group in its open event,
+// or a new one, and returns the event and the sites it hadn't seen before.
+func correlate(ctx context.Context, g eventGroup, evals []AnomalyEvaluation, now time.Time) (*CorrelatedEvent, []string, error) {
+	var sites []string
+	for _, e := range evals {
+		if !slices.Contains(sites, e.Site) {
+			sites = append(sites, e.Site)
+		}
+	}
+	table := correlatedEventsTable()
+	values, err := attributevalue.MarshalMap(map[string]any{":group": g.key})
+	if err != nil {
+		return nil, nil, err
+	}
+	latest, _, err := newRepository[CorrelatedEvent](table).Query(ctx, &dynamodb.QueryInput{
+		KeyConditionExpression:    awsString("#group = :group"),
+		ExpressionAttributeNames:  map[string]string{"#group": "group"},
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          awsBool(false),
+		Limit:                     awsInt32(1),
+	}, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(latest) == 0 || now.Sub(time.UnixMilli(latest[0].LastSeenOn)) > correlationWindow() {
+		e := &CorrelatedEvent{
+			Group:       g.key,
+			CreatedOn:   now.UnixMilli(),
+			GSIPK:       "recent",
+			EventID:     EventID(g.key, now.UnixMilli()),
+			Name:        g.name,
+			BasinID:     g.basinID,
+			HUC:         g.huc,
+			Sites:       sites,
+			Evaluations: evals,
+			LastSeenOn:  now.UnixMilli(),
+		}
+		if err := newRepository[CorrelatedEvent](table).Create(ctx, e, "group"); err != nil {
+			return nil, nil, err
+		}
+		return e, sites, nil
+	}
+
+	// Join the open event. ALL_OLD tells which sites it already had, even
+	// when another sweep updated it concurrently.
+	e := latest[0]
+	key, err := attributevalue.MarshalMap(map[string]any{"group": e.Group, "createdon": e.CreatedOn})
+	if err != nil {
+		return nil, nil, err
+	}
+	values, err = attributevalue.MarshalMap(map[string]any{":now": now.UnixMilli(), ":evals": evals})
+	if err != nil {
+		return nil, nil, err
+	}
+	values[":sites"] = &types.AttributeValueMemberSS{Value: sites}
+	out, err := getDynamoClient().UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 &table,
+		Key:                       key,
+		UpdateExpression:          awsString("ADD sites :sites SET last_seen_on = :now, evaluations = list_append(evaluations, :evals)"),
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var old CorrelatedEvent
+	if err := attributevalue.UnmarshalMap(out.Attributes, &old); err != nil {
+		return nil, nil, err
+	}
+	var added []string
+	for _, site := range sites {
+		if !slices.Contains(old.Sites, site) {
+			added = append(added, site)
+		}
+	}
+	old.Sites = append(old.Sites, added...)
+	old.Evaluations = append(old.Evaluations, evals...)
+	old.LastSeenOn = now.UnixMilli()
+	return &old, added, nil
+}
+
+// eventAlert is the part of a correlated event to alert: the sites that
+// joined it in this sweep and their evaluations.
+type eventAlert struct {
+	event *CorrelatedEvent
+	sites []string
+	evals []AnomalyEvaluation
+}
+
+// correlateAnomalies records anomalous evaluations in their groups' events.
+// It returns the events with newly joined sites, and the evaluations of
+// sites without a group (or whose event couldn't be recorded) to alert on
+// their own.
+func correlateAnomalies(ctx context.Context, anomalous []AnomalyEvaluation) ([]eventAlert, []AnomalyEvaluation) {
+	if correlationWindow() == 0 {
+		return nil, anomalous
+	}
+	var sites []string
+	for _, e := range anomalous {
+		if !slices.Contains(sites, e.Site) {
+			sites = append(sites, e.Site)
+		}
+	}
+	groups, err := eventGroups(ctx, sites)
+	if err != nil {
+		log.Printf("correlation group lookup failed: %v", err)
+	}
+	byGroup := map[string][]AnomalyEvaluation{}
+	var order []string
+	var single []AnomalyEvaluation
+	for _, e := range anomalous {
+		g, ok := groups[e.Site]
+		if !ok {
+			single = append(single, e)
+			continue
+		}
+		if _, seen := byGroup[g.key]; !seen {
+			order = append(order, g.key)
+		}
+		byGroup[g.key] = append(byGroup[g.key], e)
+	}
+
+	now := time.Now().UTC()
+	var alerts []eventAlert
+	for _, key := range order {
+		evals := byGroup[key]
+		event, added, err := correlate(ctx, groups[evals[0].Site], evals, now)
+		if err != nil {
+			log.Printf("recording correlated event for %s failed: %v", key, err)
+			single = append(single, evals...)
+			continue
+		}
+		if len(added) == 0 {
+			log.Printf("event %s already alerted its %d sites", event.EventID, len(event.Sites))
+			continue
+		}
+		ea := eventAlert{event: event, sites: added}
+		for _, e := range evals {
+			if slices.Contains(added, e.Site) {
+				ea.evals = append(ea.evals, e)
+			}
+		}
+		alerts = append(alerts, ea)
+	}
+	return alerts, single
+}
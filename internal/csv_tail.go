@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// initialTailBytes is the first range fetched by LoadCSVTail; it grows 4x per
+// round until enough rows have been read.
+const initialTailBytes = 64 * 1024
+
+// LoadCSVTail returns the last `rows` lines of the CSV object at bucket/key
+// using range reads, so only the end of a large file is transferred. If the
+// object has fewer lines, all of them are returned. The result always ends
+// with a newline unless empty.
+func LoadCSVTail(ctx context.Context, bucket, key string, rows int) ([]byte, error) {
+	if rows <= 0 {
+		return nil, errors.New("rows must be positive")
+	}
+	store := getBlobStore()
+	for n := int64(initialTailBytes); ; n *= 4 {
+		data, size, err := store.GetTail(ctx, bucket, key, n)
+		if err != nil {
+			return nil, err
+		}
+		whole := int64(len(data)) >= size
+		if !whole {
+			// Drop the first, most likely partial, line.
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				continue
+			}
+			data = data[i+1:]
+		}
+		tail, got := lastLines(data, rows)
+		if got >= rows || whole {
+			return tail, nil
+		}
+	}
+}
+
+// LoadDatasetTail returns the last `rows` lines of a processed dataset,
+// reading parts from newest to oldest until enough rows are collected.
+// Datasets without a manifest are read as a single CSV object.
+func LoadDatasetTail(ctx context.Context, bucket, dataset string, rows int) ([]byte, error) {
+	manifest, _, err := loadDatasetManifest(ctx, bucket, dataset)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return LoadCSVTail(ctx, bucket, dataset, rows)
+	}
+	prefix := DatasetPartsPrefix(dataset)
+	var chunks [][]byte
+	remaining := rows
+	for i := len(manifest.Parts) - 1; i >= 0 && remaining > 0; i-- {
+		tail, err := LoadCSVTail(ctx, bucket, prefix+manifest.Parts[i], remaining)
+		if err != nil {
+			return nil, fmt.Errorf("read part %s: %w", manifest.Parts[i], err)
+		}
+		chunks = append(chunks, tail)
+		remaining -= bytes.Count(tail, []byte{'\n'})
+	}
+	buf := &bytes.Buffer{}
+	for i := len(chunks) - 1; i >= 0; i-- {
+		buf.Write(chunks[i])
+	}
+	return buf.Bytes(), nil
+}
+
+// lastLines returns the last n lines of data, newline-terminated, and how many
+// lines were returned.
+func lastLines(data []byte, n int) ([]byte, int) {
+	data = bytes.TrimRight(data, "\r\n")
+	if len(data) == 0 {
+		return nil, 0
+	}
+	count := 0
+	start := 0
+	for i := len(data) - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			count++
+			if count == n {
+				start = i + 1
+				break
+			}
+		}
+	}
+	if count < n {
+		count++ // the first line has no preceding newline
+	}
+	out := make([]byte, 0, len(data)-start+1)
+	out = append(out, data[start:]...)
+	return append(out, '\n'), count
+}
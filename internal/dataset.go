@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// A processed dataset is a set of immutable CSV part files plus a manifest
+// listing them. Each preprocess run writes its own part and then adds it to
+// the manifest with a conditional (ETag-guarded) put, so concurrent runs
+// appending to the same dataset never overwrite each other's rows.
+//
+// For a dataset key such as processed/1732470000.csv the layout is:
+//
+//	processed/1732470000/parts/<run>.csv
+//	processed/1732470000/manifest.json
+//
+// The manifest uses the SageMaker ManifestFile format
+// ([{"prefix": "s3://bucket/.../parts/"}, "<run>.csv", ...]) so training jobs
+// can consume it directly.
+
+// maxManifestAttempts bounds retries when another run updates the manifest
+// between our read and conditional write.
+const maxManifestAttempts = 8
+
+// DatasetManifest lists the part files of a dataset relative to Prefix.
+type DatasetManifest struct {
+	Prefix string   // s3://bucket/<dataset>/parts/
+	Parts  []string // part file names relative to Prefix, in append order
+}
+
+// MarshalJSON encodes the manifest in SageMaker ManifestFile format.
+func (m DatasetManifest) MarshalJSON() ([]byte, error) {
+	out := make([]any, 0, len(m.Parts)+1)
+	out = append(out, map[string]string{"prefix": m.Prefix})
+	for _, p := range m.Parts {
+		out = append(out, p)
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes a SageMaker ManifestFile manifest.
+func (m *DatasetManifest) UnmarshalJSON(b []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if len(raw) == 0 {
+		return errors.New("manifest is empty")
+	}
+	var head struct {
+		Prefix string `json:"prefix"`
+	}
+	if err := json.Unmarshal(raw[0], &head); err != nil || head.Prefix == "" {
+		return errors.New("manifest is missing prefix entry")
+	}
+	m.Prefix = head.Prefix
+	m.Parts = make([]string, 0, len(raw)-1)
+	for _, r := range raw[1:] {
+		var p string
+		if err := json.Unmarshal(r, &p); err != nil {
+			return fmt.Errorf("invalid manifest entry: %w", err)
+		}
+		m.Parts = append(m.Parts, p)
+	}
+	return nil
+}
+
+// datasetBase strips the .csv extension from a dataset key.
+func datasetBase(dataset string) string {
+	return strings.TrimSuffix(dataset, ".csv")
+}
+
+// DatasetPartsPrefix returns the key prefix holding a dataset's part files.
+func DatasetPartsPrefix(dataset string) string {
+	return datasetBase(dataset) + "/parts/"
+}
+
+// DatasetManifestKey returns the key of a dataset's manifest.
+func DatasetManifestKey(dataset string) string {
+	return datasetBase(dataset) + "/manifest.json"
+}
+
+// AppendDatasetPart stores data as the immutable part runID of dataset and
+// registers it in the dataset manifest. Appending the same runID twice is a
+// no-op for the manifest, so retried runs don't duplicate rows. meta is
+// attached to the part as object metadata (see ObjectOptions).
+// Returns the key of the part file.
+func AppendDatasetPart(ctx context.Context, bucket, dataset, runID string, data []byte, meta map[string]string) (string, error) {
+	if runID == "" {
+		return "", errors.New("run id is required")
+	}
+	name := runID + ".csv"
+	partKey := DatasetPartsPrefix(dataset) + name
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	if _, ok := meta[MetaSchemaVersion]; !ok {
+		meta[MetaSchemaVersion] = ProcessedSchemaVersion
+	}
+	if err := SaveObject(ctx, data, bucket, partKey, ObjectOptions(ctx, partKey, PurposeProcessed, meta)); err != nil {
+		return "", fmt.Errorf("write part %s: %w", partKey, err)
+	}
+	// Best-effort: record the part and its checksum for traceability
+	if os.Getenv("DDB_TABLE") != "" {
+		if err := SaveMetadata(ctx, partKey, len(data), SHA256Hex(data)); err != nil {
+			log.Printf("failed to record metadata for %s: %v", partKey, err)
+		}
+	}
+
+	manifestKey := DatasetManifestKey(dataset)
+	backoff := 50 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		manifest, etag, err := loadDatasetManifest(ctx, bucket, dataset)
+		if err != nil {
+			return "", err
+		}
+		if manifest == nil {
+			manifest = &DatasetManifest{Prefix: fmt.Sprintf("s3://%s/%s", bucket, DatasetPartsPrefix(dataset))}
+		}
+		for _, p := range manifest.Parts {
+			if p == name {
+				return partKey, nil
+			}
+		}
+		manifest.Parts = append(manifest.Parts, name)
+		body, err := json.Marshal(manifest)
+		if err != nil {
+			return "", err
+		}
+		err = putIfUnchanged(ctx, bucket, manifestKey, body, etag)
+		if err == nil {
+			return partKey, nil
+		}
+		if !errors.Is(err, ErrPreconditionFailed) {
+			return "", fmt.Errorf("write manifest %s: %w", manifestKey, err)
+		}
+		if attempt >= maxManifestAttempts {
+			return "", fmt.Errorf("write manifest %s: still conflicting after %d attempts", manifestKey, attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// LoadDataset returns the concatenated CSV rows of a dataset in append order.
+// Datasets written before manifests existed (a single object at the dataset
+// key) are read directly.
+func LoadDataset(ctx context.Context, bucket, dataset string) ([]byte, error) {
+	manifest, _, err := loadDatasetManifest(ctx, bucket, dataset)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return LoadFromS3(ctx, bucket, dataset)
+	}
+	prefix := DatasetPartsPrefix(dataset)
+	buf := &bytes.Buffer{}
+	for _, p := range manifest.Parts {
+		part, err := LoadFromS3(ctx, bucket, prefix+p)
+		if err != nil {
+			return nil, fmt.Errorf("read part %s: %w", p, err)
+		}
+		if len(part) == 0 {
+			continue
+		}
+		buf.Write(part)
+		if part[len(part)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// loadDatasetManifest returns the manifest and its ETag, or (nil, "", nil)
+// if the dataset has no manifest yet.
+func loadDatasetManifest(ctx context.Context, bucket, dataset string) (*DatasetManifest, string, error) {
+	blob, err := getVerifiedBlob(ctx, bucket, DatasetManifestKey(dataset))
+	if errors.Is(err, ErrBlobNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var m DatasetManifest
+	if err := json.Unmarshal(blob.Data, &m); err != nil {
+		return nil, "", fmt.Errorf("decode manifest: %w", err)
+	}
+	return &m, blob.ETag, nil
+}
+
+// putIfUnchanged writes data only if the object's ETag still equals etag, or,
+// when etag is empty, only if the object does not exist yet.
+func putIfUnchanged(ctx context.Context, bucket, key string, data []byte, etag string) error {
+	opts := ObjectOptions(ctx, key, PurposeProcessed, nil)
+	opts.IfMatch = etag
+	opts.IfNoneMatch = etag == ""
+	return getBlobStore().Put(ctx, bucket, key, data, opts)
+}
@@ -17,6 +17,7 @@ type Metadata struct {
 	ID        string `dynamodbav:"id"`
 	S3Key     string `dynamodbav:"s3_key"`
 	SizeBytes int    `dynamodbav:"size_bytes"`
+	SHA256    string `dynamodbav:"sha256,omitempty"`
 	Timestamp string `dynamodbav:"timestamp"`
 }
 
@@ -30,80 +31,121 @@ type AlertTrackerItem struct {
 	Severity      string   `dynamodbav:"severity" json:"severity"`
 	SitesImpacted []string `dynamodbav:"sites_impacted" json:"sites_impacted"`
 	AnomalyDate   string   `dynamodbav:"anomaly_date" json:"anomaly_date"`
+	State         string   `dynamodbav:"state,omitempty" json:"state"`
+	UpdatedOnMs   int64    `dynamodbav:"updatedon,omitempty" json:"updatedon_ms,omitempty"`
+	Version       int64    `dynamodbav:"version" json:"version"`
+	// Images are attached imagery (see AttachAlertImage).
+	Images []AlertImage `dynamodbav:"images,omitempty" json:"images,omitempty"`
+	// Day is the UTC date of CreatedOnMs (YYYY-MM-DD), the gsi_day key.
+	Day       string `dynamodbav:"alert_day,omitempty" json:"-"`
+	ExpiresAt int64  `dynamodbav:"expires_at,omitempty" json:"-"`
 }
 
 // SaveMetadata persists a small metadata record for an S3 object to DynamoDB.
-func SaveMetadata(ctx context.Context, s3Key string, size int) error {
-	cfg := getAWSConfig()
-	client := dynamodb.NewFromConfig(cfg)
-	table := os.Getenv("DDB_TABLE")
+// sha256 is the hex SHA-256 of the object contents (see SHA256Hex).
+func SaveMetadata(ctx context.Context, s3Key string, size int, sha256 string) error {
 	item := Metadata{
 		ID:        fmt.Sprintf("data-%d", time.Now().UnixNano()),
 		S3Key:     s3Key,
 		SizeBytes: size,
+		SHA256:    sha256,
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 	}
-	av, err := attributevalue.MarshalMap(item)
-	if err != nil {
-		return err
-	}
-	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: &table,
-		Item:      av,
-	})
-	return err
+	return newRepository[Metadata](os.Getenv("DDB_TABLE")).Put(ctx, item)
 }
 
-// SaveAlertTrackerItem writes an alert record to the alert-tracker table.
+// SaveAlertTrackerItem writes a new alert record to the alert-tracker table and
+// indexes it under each impacted site. ExpiresAt is derived from the table's retention policy when unset.
+// Returns an error matching ErrAlreadyExists if an alert with the same
+// createdon key is already stored.
 func SaveAlertTrackerItem(ctx context.Context, item AlertTrackerItem) error {
-	cfg := getAWSConfig()
-	client := dynamodb.NewFromConfig(cfg)
-	table := os.Getenv("ALERT_TRACKER_TABLE")
-	if table == "" {
-		table = "alert-tracker"
+	retention := AlertTrackerRetention()
+	if item.ExpiresAt == 0 {
+		item.ExpiresAt = retention.ExpiresAt(time.Now().UTC())
 	}
-	av, err := attributevalue.MarshalMap(item)
-	if err != nil {
+	if item.Day == "" {
+		item.Day = AlertDay(item.CreatedOnMs)
+	}
+	if err := newRepository[AlertTrackerItem](retention.Table).Create(ctx, item, "createdon"); err != nil {
 		return err
 	}
-	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: &table,
-		Item:      av,
-	})
-	return err
+	return indexAlertBySite(ctx, item)
 }
 
-// SaveAlertTrackerRecord writes a generic alert record represented as a map.
+// SaveAlertTrackerRecord writes a new generic alert record represented as a map.
+// An expires_at TTL is added from the retention policy unless already present,
+// and alert_day from createdon.
+// Like SaveAlertTrackerItem it refuses to overwrite an existing record; use
+// UpsertAlertTrackerRecord when replacing a record is intended.
 func SaveAlertTrackerRecord(ctx context.Context, record map[string]any) error {
-	cfg := getAWSConfig()
-	client := dynamodb.NewFromConfig(cfg)
-	table := os.Getenv("ALERT_TRACKER_TABLE")
-	if table == "" {
-		table = "alert-tracker"
+	retention := AlertTrackerRetention()
+	withAlertTTL(retention, record)
+	withAlertDay(record)
+	if err := newRepository[AlertTrackerItem](retention.Table).Create(ctx, record, "createdon"); err != nil {
+		return err
 	}
-	av, err := attributevalue.MarshalMap(record)
-	if err != nil {
+	return indexAlertRecordBySite(ctx, record)
+}
+
+// UpsertAlertTrackerRecord writes an alert record, replacing any existing
+// record with the same key.
+func UpsertAlertTrackerRecord(ctx context.Context, record map[string]any) error {
+	retention := AlertTrackerRetention()
+	withAlertTTL(retention, record)
+	withAlertDay(record)
+	if err := newRepository[AlertTrackerItem](retention.Table).Put(ctx, record); err != nil {
 		return err
 	}
-	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: &table,
-		Item:      av,
-	})
-	return err
+	return indexAlertRecordBySite(ctx, record)
+}
+
+func withAlertTTL(retention RetentionConfig, record map[string]any) {
+	if _, ok := record[ttlAttribute]; !ok {
+		if exp := retention.ExpiresAt(time.Now().UTC()); exp > 0 {
+			record[ttlAttribute] = exp
+		}
+	}
+}
+
+// withAlertDay sets the alert_day of a record from its createdon.
+func withAlertDay(record map[string]any) {
+	if _, ok := record[alertDayAttribute]; ok {
+		return
+	}
+	if ms, ok := record["createdon"].(int64); ok {
+		record[alertDayAttribute] = AlertDay(ms)
+	}
 }
 
 // ListRecentAlerts queries the GSI gsi_recent (HASH gsi_pk='recent', RANGE createdon) for items since a timestamp.
 func ListRecentAlerts(ctx context.Context, sinceEpochMs int64, limit int) ([]AlertTrackerItem, error) {
-	cfg := getAWSConfig()
-	client := dynamodb.NewFromConfig(cfg)
-	table := os.Getenv("ALERT_TRACKER_TABLE")
-	if table == "" {
-		table = "alert-tracker"
+	items, _, err := ListRecentAlertsPage(ctx, sinceEpochMs, limit, "")
+	return items, err
+}
+
+// ListRecentAlertsPage is the paginated form of ListRecentAlerts. Pass the
+// returned cursor back in to fetch the next page; an empty cursor in the
+// result means there are no more items.
+func ListRecentAlertsPage(ctx context.Context, sinceEpochMs int64, limit int, cursor string) ([]AlertTrackerItem, string, error) {
+	in, err := recentQueryInput(sinceEpochMs, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	items, next, err := newRepository[AlertTrackerItem](alertTrackerTable()).Query(ctx, in, cursor)
+	if err != nil {
+		return nil, "", err
 	}
+	// Defensive: ensure descending
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedOnMs > items[j].CreatedOnMs })
+	return items, next, nil
+}
+
+// recentQueryInput builds a descending gsi_recent query for items created since
+// sinceEpochMs. Shared by tables that carry the gsi_pk='recent' index.
+func recentQueryInput(sinceEpochMs int64, limit int) (*dynamodb.QueryInput, error) {
 	if limit <= 0 {
 		limit = 100
 	}
-	index := "gsi_recent"
 	values, err := attributevalue.MarshalMap(map[string]any{
 		":pk":    "recent",
 		":since": sinceEpochMs,
@@ -111,24 +153,13 @@ func ListRecentAlerts(ctx context.Context, sinceEpochMs int64, limit int) ([]Ale
 	if err != nil {
 		return nil, err
 	}
-	out, err := client.Query(ctx, &dynamodb.QueryInput{
-		TableName:                 &table,
-		IndexName:                 &index,
+	return &dynamodb.QueryInput{
+		IndexName:                 awsString("gsi_recent"),
 		KeyConditionExpression:    awsString("gsi_pk = :pk AND createdon >= :since"),
 		ExpressionAttributeValues: values,
 		ScanIndexForward:          awsBool(false),
 		Limit:                     awsInt32(int32(limit)),
-	})
-	if err != nil {
-		return nil, err
-	}
-	var items []AlertTrackerItem
-	if err := attributevalue.UnmarshalListOfMaps(out.Items, &items); err != nil {
-		return nil, err
-	}
-	// Defensive: ensure descending
-	sort.Slice(items, func(i, j int) bool { return items[i].CreatedOnMs > items[j].CreatedOnMs })
-	return items, nil
+	}, nil
 }
 
 func awsString(s string) *string { return &s }
@@ -139,13 +170,23 @@ func awsBool(b bool) *bool       { return &b }
 
 // TrainModelTrackerItem represents a training job record.
 // Table name defaults to "train-model-tracker"; override with TRAIN_MODEL_TRACKER_TABLE.
+// UUID is the SageMaker training job name for models trained by the train
+// lambda.
 type TrainModelTrackerItem struct {
-	UUID      string   `dynamodbav:"uuid" json:"uuid"`
-	CreatedOn int64    `dynamodbav:"createdon" json:"createdon"`
-	Sites     []string `dynamodbav:"sites" json:"sites"`
+	UUID          string   `dynamodbav:"uuid" json:"uuid"`
+	CreatedOn     int64    `dynamodbav:"createdon" json:"createdon"`
+	Sites         []string `dynamodbav:"sites" json:"sites"`
+	Parameter     string   `dynamodbav:"parameter,omitempty" json:"parameter,omitempty"`
+	ModelArtifact string   `dynamodbav:"model_artifact,omitempty" json:"model_artifact,omitempty"`
+	// Dataset is the training data snapshot the model was trained on.
+	Dataset *TrainingDataset `dynamodbav:"dataset,omitempty" json:"dataset,omitempty"`
+	// ArchivedOn is set (epoch ms) once the model's artifacts were removed
+	// by the model cleanup lambda.
+	ArchivedOn int64 `dynamodbav:"archived_on,omitempty" json:"archived_on,omitempty"`
 }
 
-// SaveTrainModelTrackerItem writes a record to the train-model-tracker table.
+// SaveTrainModelTrackerItem writes a new record to the train-model-tracker table.
+// Returns an error matching ErrAlreadyExists if the uuid is already recorded.
 func SaveTrainModelTrackerItem(ctx context.Context, item TrainModelTrackerItem) error {
 	if item.UUID == "" {
 		return fmt.Errorf("uuid is required")
@@ -153,12 +194,7 @@ func SaveTrainModelTrackerItem(ctx context.Context, item TrainModelTrackerItem)
 	if item.CreatedOn == 0 {
 		item.CreatedOn = time.Now().UTC().UnixMilli()
 	}
-	cfg := getAWSConfig()
-	client := dynamodb.NewFromConfig(cfg)
-	table := os.Getenv("TRAIN_MODEL_TRACKER_TABLE")
-	if table == "" {
-		table = "train-model-tracker"
-	}
+	retention := TrainModelTrackerRetention()
 	// Add GSI partition key for recent queries
 	record := map[string]any{
 		"uuid":      item.UUID,
@@ -166,51 +202,54 @@ func SaveTrainModelTrackerItem(ctx context.Context, item TrainModelTrackerItem)
 		"sites":     item.Sites,
 		"gsi_pk":    "recent",
 	}
-	av, err := attributevalue.MarshalMap(record)
-	if err != nil {
-		return err
+	if item.Parameter != "" {
+		record["parameter"] = item.Parameter
 	}
-	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: &table,
-		Item:      av,
-	})
-	return err
+	if item.ModelArtifact != "" {
+		record["model_artifact"] = item.ModelArtifact
+	}
+	if item.Dataset != nil {
+		record["dataset"] = item.Dataset
+	}
+	if exp := retention.ExpiresAt(time.UnixMilli(item.CreatedOn)); exp > 0 {
+		record[ttlAttribute] = exp
+	}
+	return newRepository[TrainModelTrackerItem](retention.Table).Create(ctx, record, "uuid")
 }
 
 // ListRecentTrainModels queries gsi_recent to get items since a timestamp in descending order of createdon.
 func ListRecentTrainModels(ctx context.Context, sinceEpochMs int64, limit int) ([]TrainModelTrackerItem, error) {
-	cfg := getAWSConfig()
-	client := dynamodb.NewFromConfig(cfg)
-	table := os.Getenv("TRAIN_MODEL_TRACKER_TABLE")
-	if table == "" {
-		table = "train-model-tracker"
-	}
-	if limit <= 0 {
-		limit = 100
+	items, _, err := ListRecentTrainModelsPage(ctx, sinceEpochMs, limit, "")
+	return items, err
+}
+
+// ListRecentTrainModelsPage is the paginated form of ListRecentTrainModels.
+// See ListRecentAlertsPage for cursor semantics.
+func ListRecentTrainModelsPage(ctx context.Context, sinceEpochMs int64, limit int, cursor string) ([]TrainModelTrackerItem, string, error) {
+	in, err := recentQueryInput(sinceEpochMs, limit)
+	if err != nil {
+		return nil, "", err
 	}
-	index := "gsi_recent"
-	values, err := attributevalue.MarshalMap(map[string]any{
-		":pk":    "recent",
-		":since": sinceEpochMs,
-	})
+	items, next, err := newRepository[TrainModelTrackerItem](trainModelTrackerTable()).Query(ctx, in, cursor)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	out, err := client.Query(ctx, &dynamodb.QueryInput{
-		TableName:                 &table,
-		IndexName:                 &index,
-		KeyConditionExpression:    awsString("gsi_pk = :pk AND createdon >= :since"),
-		ExpressionAttributeValues: values,
-		ScanIndexForward:          awsBool(false),
-		Limit:                     awsInt32(int32(limit)),
-	})
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedOn > items[j].CreatedOn })
+	return items, next, nil
+}
+
+// ErrTrainModelNotFound is returned when a training job has no record.
+var ErrTrainModelNotFound = notFoundError("model not found")
+
+// GetTrainModel loads the train-model-tracker record of uuid, returning
+// ErrTrainModelNotFound when missing.
+func GetTrainModel(ctx context.Context, uuid string) (*TrainModelTrackerItem, error) {
+	item, err := newRepository[TrainModelTrackerItem](trainModelTrackerTable()).Get(ctx, map[string]any{"uuid": uuid})
 	if err != nil {
 		return nil, err
 	}
-	var items []TrainModelTrackerItem
-	if err := attributevalue.UnmarshalListOfMaps(out.Items, &items); err != nil {
-		return nil, err
+	if item == nil {
+		return nil, ErrTrainModelNotFound
 	}
-	sort.Slice(items, func(i, j int) bool { return items[i].CreatedOn > items[j].CreatedOn })
-	return items, nil
+	return item, nil
 }
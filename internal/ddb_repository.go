@@ -0,0 +1,340 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrAlreadyExists is matched (via errors.Is) by AlreadyExistsError.
+var ErrAlreadyExists = errors.New("item already exists")
+
+// AlreadyExistsError is returned by conditional creates when an item with the
+// same primary key is already present in the table.
+type AlreadyExistsError struct {
+	Table string
+}
+
+func (e *AlreadyExistsError) Error() string {
+	return fmt.Sprintf("item already exists in table %s", e.Table)
+}
+
+// Is reports whether target is ErrAlreadyExists.
+func (e *AlreadyExistsError) Is(target error) bool { return target == ErrAlreadyExists }
+
+// ErrVersionConflict is matched (via errors.Is) by VersionConflictError.
+var ErrVersionConflict = errors.New("version conflict")
+
+// VersionConflictError is returned by optimistic-locking updates when the
+// stored version no longer matches the version the caller read.
+type VersionConflictError struct {
+	Table    string
+	Expected int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict in table %s: expected version %d", e.Table, e.Expected)
+}
+
+// Is reports whether target is ErrVersionConflict.
+func (e *VersionConflictError) Is(target error) bool { return target == ErrVersionConflict }
+
+// versionCondition returns the condition expression guarding an optimistic
+// update. Version 0 means "never versioned", matching legacy items that
+// predate the version attribute.
+func versionCondition(expected int64) string {
+	if expected == 0 {
+		return "(attribute_not_exists(#version) OR #version = :expected)"
+	}
+	return "#version = :expected"
+}
+
+var (
+	ddbClientOnce sync.Once
+	ddbClient     dynamoAPI
+)
+
+// dynamoAPI is the part of the DynamoDB client the backend uses.
+type dynamoAPI interface {
+	GetItem(context.Context, *dynamodb.GetItemInput, ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(context.Context, *dynamodb.PutItemInput, ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(context.Context, *dynamodb.UpdateItemInput, ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(context.Context, *dynamodb.DeleteItemInput, ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Query(context.Context, *dynamodb.QueryInput, ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	Scan(context.Context, *dynamodb.ScanInput, ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	BatchWriteItem(context.Context, *dynamodb.BatchWriteItemInput, ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+	TransactWriteItems(context.Context, *dynamodb.TransactWriteItemsInput, ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// getDynamoClient returns a process-wide DynamoDB client, created on first
+// use and reused across requests (and warm Lambda invocations). With a
+// failover region its reads fail over between the regions (see regions.go).
+func getDynamoClient() dynamoAPI {
+	ddbClientOnce.Do(func() {
+		primary := dynamodb.NewFromConfig(regionalConfig("DYNAMODB_REGION"), dynamoEndpointOptions)
+		region := failoverRegion("DYNAMODB_FAILOVER_REGION", "DYNAMODB_ENDPOINT")
+		if region == "" {
+			ddbClient = primary
+			return
+		}
+		secondary := dynamodb.NewFromConfig(getAWSConfig(), dynamoEndpointOptions, func(o *dynamodb.Options) { o.Region = region })
+		ddbClient = &failoverDynamo{primary: primary, secondary: secondary, f: &regionFailover{service: "dynamodb", region: region}}
+	})
+	return ddbClient
+}
+
+// failoverDynamo sends each read to the primary region's client, failing
+// over to the secondary's (see withFailover). Writes only go to the primary:
+// a timed-out write may have landed there, and global tables resolve the
+// regions' copies last-writer-wins, so conditions (Create, versioned
+// Update, single-use tokens, counters) would stop holding if it were
+// re-sent to the secondary.
+type failoverDynamo struct {
+	primary, secondary *dynamodb.Client
+	f                  *regionFailover
+}
+
+func (d *failoverDynamo) GetItem(ctx context.Context, in *dynamodb.GetItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return withFailover(ctx, d.f, d.primary, d.secondary, func(c *dynamodb.Client) (*dynamodb.GetItemOutput, error) { return c.GetItem(ctx, in, opts...) })
+}
+
+func (d *failoverDynamo) Query(ctx context.Context, in *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	return withFailover(ctx, d.f, d.primary, d.secondary, func(c *dynamodb.Client) (*dynamodb.QueryOutput, error) { return c.Query(ctx, in, opts...) })
+}
+
+func (d *failoverDynamo) Scan(ctx context.Context, in *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return withFailover(ctx, d.f, d.primary, d.secondary, func(c *dynamodb.Client) (*dynamodb.ScanOutput, error) { return c.Scan(ctx, in, opts...) })
+}
+
+func (d *failoverDynamo) PutItem(ctx context.Context, in *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	return d.primary.PutItem(ctx, in, opts...)
+}
+
+func (d *failoverDynamo) UpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	return d.primary.UpdateItem(ctx, in, opts...)
+}
+
+func (d *failoverDynamo) DeleteItem(ctx context.Context, in *dynamodb.DeleteItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	return d.primary.DeleteItem(ctx, in, opts...)
+}
+
+func (d *failoverDynamo) BatchWriteItem(ctx context.Context, in *dynamodb.BatchWriteItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	return d.primary.BatchWriteItem(ctx, in, opts...)
+}
+
+func (d *failoverDynamo) TransactWriteItems(ctx context.Context, in *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	return d.primary.TransactWriteItems(ctx, in, opts...)
+}
+
+// tableName resolves a table name from envVar, falling back to def
+// decorated by the resource naming (see ResourceNaming).
+func tableName(envVar, def string) string {
+	return resourceName(envVar, def)
+}
+
+// Tracker table names, overridable per deployment via env vars.
+func alertTrackerTable() string {
+	return tableName("ALERT_TRACKER_TABLE", "alert-tracker")
+}
+
+func predictionTrackerTable() string {
+	return tableName("PREDICTION_TRACKER_TABLE", "prediction-tracker")
+}
+
+func trainModelTrackerTable() string {
+	return tableName("TRAIN_MODEL_TRACKER_TABLE", "train-model-tracker")
+}
+
+// repository provides typed access to a single DynamoDB table. T is the item
+// type items are unmarshaled into on reads.
+type repository[T any] struct {
+	table  string
+	client dynamoAPI
+}
+
+// newRepository returns a repository for the given table using the shared client.
+func newRepository[T any](table string) *repository[T] {
+	return &repository[T]{table: table, client: getDynamoClient()}
+}
+
+// Put marshals item (a struct or map) and writes it with PutItem. It has
+// upsert semantics: an existing item with the same key is replaced. Use Create
+// when an overwrite would be a bug.
+func (r *repository[T]) Put(ctx context.Context, item any) error {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: &r.table,
+		Item:      av,
+	})
+	return err
+}
+
+// Create writes item only if no item with the same key exists, using
+// attribute_not_exists on the table's partition key attribute hashKey.
+// Returns *AlreadyExistsError when the key is taken.
+func (r *repository[T]) Create(ctx context.Context, item any, hashKey string) error {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                &r.table,
+		Item:                     av,
+		ConditionExpression:      awsString("attribute_not_exists(#pk)"),
+		ExpressionAttributeNames: map[string]string{"#pk": hashKey},
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return &AlreadyExistsError{Table: r.table}
+	}
+	return err
+}
+
+// maxBatchWriteItems is the DynamoDB BatchWriteItem per-request limit.
+const maxBatchWriteItems = 25
+
+// maxBatchWriteAttempts bounds retries of UnprocessedItems per chunk.
+const maxBatchWriteAttempts = 5
+
+// BatchPut writes items (structs or maps) with BatchWriteItem in chunks of 25,
+// retrying unprocessed items with exponential backoff. Like Put, existing
+// items with the same key are replaced.
+func (r *repository[T]) BatchPut(ctx context.Context, items []any) error {
+	for start := 0; start < len(items); start += maxBatchWriteItems {
+		end := min(start+maxBatchWriteItems, len(items))
+		reqs := make([]types.WriteRequest, 0, end-start)
+		for _, it := range items[start:end] {
+			av, err := attributevalue.MarshalMap(it)
+			if err != nil {
+				return err
+			}
+			reqs = append(reqs, types.WriteRequest{PutRequest: &types.PutRequest{Item: av}})
+		}
+		if err := r.batchWriteWithRetry(ctx, reqs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *repository[T]) batchWriteWithRetry(ctx context.Context, reqs []types.WriteRequest) error {
+	backoff := 50 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		out, err := r.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{r.table: reqs},
+		})
+		if err != nil {
+			return err
+		}
+		reqs = out.UnprocessedItems[r.table]
+		if len(reqs) == 0 {
+			return nil
+		}
+		if attempt >= maxBatchWriteAttempts {
+			return fmt.Errorf("batch write to %s: %d items unprocessed after %d attempts", r.table, len(reqs), attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Get fetches a single item by key (a struct or map of key attributes) using
+// a strongly consistent read. Returns (nil, nil) if no such item exists.
+func (r *repository[T]) Get(ctx context.Context, key any) (*T, error) {
+	k, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return nil, err
+	}
+	out, err := r.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      &r.table,
+		Key:            k,
+		ConsistentRead: awsBool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+	var item T
+	if err := attributevalue.UnmarshalMap(out.Item, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// Update applies the update expression expr to the item at key (a struct
+// or map of key attributes) under condition cond, which may be empty. #status
+// may be used for the status attribute; values that are already
+// AttributeValues (such as string sets) are passed through.
+func (r *repository[T]) Update(ctx context.Context, key any, expr string, vals map[string]any, cond string) error {
+	k, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+	values := map[string]types.AttributeValue{}
+	for name, v := range vals {
+		if av, ok := v.(types.AttributeValue); ok {
+			values[name] = av
+			continue
+		}
+		av, err := attributevalue.Marshal(v)
+		if err != nil {
+			return err
+		}
+		values[name] = av
+	}
+	in := &dynamodb.UpdateItemInput{
+		TableName:                 &r.table,
+		Key:                       k,
+		UpdateExpression:          &expr,
+		ExpressionAttributeValues: values,
+	}
+	if cond != "" {
+		in.ConditionExpression = &cond
+	}
+	if strings.Contains(expr+cond, "#status") {
+		in.ExpressionAttributeNames = map[string]string{"#status": "status"}
+	}
+	_, err = r.client.UpdateItem(ctx, in)
+	return err
+}
+
+// Query runs a single page of in against the table (TableName is set by the
+// repository). cursor resumes from a previous page; the returned cursor is
+// empty when there are no more results.
+func (r *repository[T]) Query(ctx context.Context, in *dynamodb.QueryInput, cursor string) ([]T, string, error) {
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	in.TableName = &r.table
+	in.ExclusiveStartKey = startKey
+	out, err := r.client.Query(ctx, in)
+	if err != nil {
+		return nil, "", err
+	}
+	var items []T
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &items); err != nil {
+		return nil, "", err
+	}
+	next, err := encodeCursor(out.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return items, next, nil
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"context"
+	"time"
+)
+
+// Stage deadlines bound the slow stages of a request or pipeline step, so a
+// hung upstream fails that stage instead of using up the caller's whole
+// budget (an API client's patience, or the Lambda timeout). A stage never
+// outlives its caller: context.WithTimeout keeps the earlier deadline, and a
+// cancelled caller (a client disconnect) cancels the stage with it.
+//
+// Each deadline can be overridden in seconds with its environment variable.
+
+// Stages with their own deadline.
+const (
+	// StageFetch is one station's USGS fetch, retries included
+	// (FETCH_TIMEOUT_SECONDS, default 60).
+	StageFetch = "fetch"
+	// StageWeather is one NWS forecast lookup (WEATHER_TIMEOUT_SECONDS,
+	// default 15).
+	StageWeather = "weather"
+	// StageInfer is one SageMaker endpoint invocation (INFER_TIMEOUT_SECONDS,
+	// default 60).
+	StageInfer = "infer"
+)
+
+var stageTimeouts = map[string]struct {
+	envVar string
+	def    time.Duration
+}{
+	StageFetch:   {"FETCH_TIMEOUT_SECONDS", 60 * time.Second},
+	StageWeather: {"WEATHER_TIMEOUT_SECONDS", 15 * time.Second},
+	StageInfer:   {"INFER_TIMEOUT_SECONDS", 60 * time.Second},
+}
+
+// StageTimeout returns the deadline configured for stage.
+func StageTimeout(stage string) time.Duration {
+	t, ok := stageTimeouts[stage]
+	if !ok {
+		return 0
+	}
+	return time.Duration(envInt(t.envVar, int(t.def/time.Second))) * time.Second
+}
+
+// withStageTimeout derives a context bounded by stage's deadline. Unknown
+// stages get ctx unchanged.
+func withStageTimeout(ctx context.Context, stage string) (context.Context, context.CancelFunc) {
+	d := StageTimeout(stage)
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"aquawatch/internal/pipeline"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// A dry run checks that new stations can be ingested before they're
+// onboarded: their data is fetched and turned into feature rows exactly as
+// preprocess would, and the rows are counted instead of stored. Nothing is
+// written to S3 (dataset parts, last good payloads) or DynamoDB (station
+// statistics, stream evaluations), and no model is trained or run.
+
+// DryRunIngest fetches and preprocesses the sites' data of parameter and
+// reports the rows per station. The daily feed falls back to instantaneous
+// values as in preprocess, but no fallback policy applies: a dry run
+// validates the real feeds, so it fails when both do. start and end
+// (YYYY-MM-DD, both or neither) fetch a window of days instead of the last
+// 30, without the fallback.
+func DryRunIngest(ctx context.Context, sites []string, parameter, start, end string) (*pipeline.DryRunReport, error) {
+	source := DataSourceDaily
+	if from, to, ok := pipeline.ParseWindow(start, end); ok {
+		raw, err := GetWaterDailyDataRangeBatch(ctx, sites, parameter, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("fetch failed: %w", err)
+		}
+		return dryRunReport(ctx, source, sites, raw)
+	}
+	raw, err := GetWaterDailyDataLast30DaysBatch(ctx, sites, parameter)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("dry run: daily 30d fetch failed, fallback to iv: %v", err)
+		source = DataSourceInstant
+		raw, err = GetWaterDataBatch(ctx, sites, parameter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetch failed: %w", err)
+	}
+	return dryRunReport(ctx, source, sites, raw)
+}
+
+// dryRunReport preprocesses the payloads of sites fetched from source and
+// describes the rows.
+func dryRunReport(ctx context.Context, source string, sites []string, raw [][]byte) (*pipeline.DryRunReport, error) {
+	csvBytes, err := PreprocessDataCSVBatch(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("preprocessing failed: %w", err)
+	}
+	return &pipeline.DryRunReport{
+		DataSource: source,
+		Columns:    processedColumns,
+		Rows:       bytes.Count(csvBytes, []byte{'\n'}),
+		Bytes:      len(csvBytes),
+		Stations:   dryRunStations(sites, raw),
+	}, nil
+}
+
+// dryRunStations counts the observations of each site's payload (raw holds
+// one per site, in order) that preprocess turns into rows.
+func dryRunStations(sites []string, raw [][]byte) []pipeline.DryRunStation {
+	out := make([]pipeline.DryRunStation, len(sites))
+	for i, site := range sites {
+		out[i].Site = site
+		if i >= len(raw) || len(raw[i]) == 0 {
+			continue
+		}
+		var usgs USGSJSON
+		if err := json.Unmarshal(raw[i], &usgs); err != nil {
+			continue
+		}
+		var first, last time.Time
+		for _, ts := range usgs.Value.TimeSeries {
+			if out[i].Name == "" {
+				out[i].Name = ts.SourceInfo.SiteName
+			}
+			for _, v := range ts.Values {
+				for _, point := range v.Value {
+					t, err := parseUSGSTime(point.DateTime)
+					if err != nil {
+						continue
+					}
+					out[i].Rows++
+					if first.IsZero() || t.Before(first) {
+						first = t
+					}
+					if t.After(last) {
+						last = t
+					}
+				}
+			}
+		}
+		if out[i].Rows > 0 {
+			out[i].First = first.UTC().Format(time.RFC3339)
+			out[i].Last = last.UTC().Format(time.RFC3339)
+		}
+	}
+	return out
+}
+
+// RecordPipelineRunDryRun stores a dry run's report on its pipeline-runs
+// record.
+func RecordPipelineRunDryRun(ctx context.Context, executionArn string, report *pipeline.DryRunReport) error {
+	key, err := attributevalue.MarshalMap(map[string]any{"execution_arn": executionArn})
+	if err != nil {
+		return err
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{":r": report, ":t": true})
+	if err != nil {
+		return err
+	}
+	_, err = getDynamoClient().UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 awsString(pipelineRunsTable()),
+		Key:                       key,
+		UpdateExpression:          awsString("SET dry_run = :t, dry_run_report = :r"),
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// Endpoint overrides let the backend run against DynamoDB Local / LocalStack.
+// Each service can be pointed at its own URL; AWS_ENDPOINT_OVERRIDE applies to
+// every service that has no specific override.
+//
+//	DYNAMODB_ENDPOINT=http://localhost:8000
+//	S3_ENDPOINT=http://localhost:4566
+//	SNS_ENDPOINT=http://localhost:4566
+//	SQS_ENDPOINT=http://localhost:4566
+//	AWS_ENDPOINT_OVERRIDE=http://localhost:4566
+func endpointOverride(envVar string) string {
+	if v := strings.TrimSpace(os.Getenv(envVar)); v != "" {
+		return v
+	}
+	return strings.TrimSpace(os.Getenv("AWS_ENDPOINT_OVERRIDE"))
+}
+
+// localEndpointsEnabled reports whether any endpoint override is configured.
+func localEndpointsEnabled() bool {
+	for _, v := range []string{"DYNAMODB_ENDPOINT", "S3_ENDPOINT", "SNS_ENDPOINT", "SQS_ENDPOINT", "AWS_ENDPOINT_OVERRIDE"} {
+		if strings.TrimSpace(os.Getenv(v)) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyLocalDefaults fills in a region and dummy static credentials when
+// endpoints are overridden and the environment has none, so local emulators
+// work without any AWS account configured.
+func applyLocalDefaults(cfg *aws.Config) {
+	if !localEndpointsEnabled() {
+		return
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" && os.Getenv("AWS_PROFILE") == "" {
+		cfg.Credentials = credentials.NewStaticCredentialsProvider("local", "local", "")
+	}
+}
+
+func dynamoEndpointOptions(o *dynamodb.Options) {
+	if ep := endpointOverride("DYNAMODB_ENDPOINT"); ep != "" {
+		o.BaseEndpoint = aws.String(ep)
+	}
+}
+
+func s3EndpointOptions(o *s3.Options) {
+	if ep := endpointOverride("S3_ENDPOINT"); ep != "" {
+		o.BaseEndpoint = aws.String(ep)
+		// LocalStack and MinIO don't resolve virtual-hosted bucket names
+		o.UsePathStyle = true
+	}
+}
+
+func snsEndpointOptions(o *sns.Options) {
+	if ep := endpointOverride("SNS_ENDPOINT"); ep != "" {
+		o.BaseEndpoint = aws.String(ep)
+	}
+}
+
+func sqsEndpointOptions(o *sqs.Options) {
+	if ep := endpointOverride("SQS_ENDPOINT"); ep != "" {
+		o.BaseEndpoint = aws.String(ep)
+	}
+}
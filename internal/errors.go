@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"aquawatch/internal/httpclient"
+	"aquawatch/internal/pipeline"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+)
+
+// Errors fall into a few categories the API answers differently: the
+// caller's mistake, something missing, a dependency (USGS, AWS) failing or
+// one asking us to slow down. The specific sentinels (ErrBasinNotFound,
+// ErrInvalidSchedule, ...) match their category via errors.Is, as do errors
+// wrapping them with context, so callers can check either.
+var (
+	ErrNotFound            = errors.New("not found")
+	ErrValidation          = errors.New("validation failed")
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+	ErrThrottled           = errors.New("throttled")
+)
+
+// kindError is a sentinel error of one of the categories.
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string { return e.msg }
+
+// Is reports whether target is e's category.
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+func notFoundError(msg string) error   { return &kindError{msg: msg, kind: ErrNotFound} }
+func validationError(msg string) error { return &kindError{msg: msg, kind: ErrValidation} }
+func upstreamError(msg string) error   { return &kindError{msg: msg, kind: ErrUpstreamUnavailable} }
+func throttledError(msg string) error  { return &kindError{msg: msg, kind: ErrThrottled} }
+
+// awsThrottleCodes are the error codes AWS services throttle requests with.
+var awsThrottleCodes = map[string]bool{
+	"Throttling":                             true,
+	"ThrottlingException":                    true,
+	"ThrottledException":                     true,
+	"TooManyRequestsException":               true,
+	"RequestLimitExceeded":                   true,
+	"RequestThrottled":                       true,
+	"RequestThrottledException":              true,
+	"ProvisionedThroughputExceededException": true,
+	"SlowDown":                               true,
+}
+
+// ErrorKind returns the category of err (ErrValidation, ErrNotFound,
+// ErrThrottled or ErrUpstreamUnavailable), or nil when it has none. Besides
+// the sentinels it recognizes invalid pipeline input, AWS throttling and
+// server faults, open circuit breakers, timeouts and network errors.
+func ErrorKind(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, kind := range []error{ErrValidation, ErrNotFound, ErrThrottled, ErrUpstreamUnavailable} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	if errors.Is(err, pipeline.ErrInvalidInput) {
+		return ErrValidation
+	}
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		if awsThrottleCodes[ae.ErrorCode()] {
+			return ErrThrottled
+		}
+		if ae.ErrorFault() == smithy.FaultServer {
+			return ErrUpstreamUnavailable
+		}
+	}
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		switch code := re.HTTPStatusCode(); {
+		case code == http.StatusTooManyRequests:
+			return ErrThrottled
+		case code >= 500:
+			return ErrUpstreamUnavailable
+		}
+	}
+	var ne net.Error
+	if errors.Is(err, httpclient.ErrCircuitOpen) || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) {
+		return ErrUpstreamUnavailable
+	}
+	return nil
+}
+
+// upstreamStatusError classifies a non-OK status from an upstream HTTP API
+// (what, e.g. "USGS API"): 429 is throttling, 404 a missing resource, other
+// 4xx a bad request and anything else the upstream failing.
+func upstreamStatusError(what, subject string, status int) error {
+	kind := ErrUpstreamUnavailable
+	switch {
+	case status == http.StatusTooManyRequests:
+		kind = ErrThrottled
+	case status == http.StatusNotFound:
+		kind = ErrNotFound
+	case status >= 400 && status < 500:
+		kind = ErrValidation
+	}
+	return fmt.Errorf("%w: %s non-OK status for %s: %d", kind, what, subject, status)
+}
@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Analytics exports are written below the layout's analytics prefix. Each
+// dataset lives at [<env>/]analytics/<dataset>/dt=<YYYY-MM-DD>/ (Hive-style
+// partitions) so Glue crawlers and Athena pick up the dt partition column.
+
+// Export dataset names, also used as the Glue/Athena table names.
+const (
+	ExportDatasetAlerts      = "alert_history"
+	ExportDatasetPredictions = "prediction_history"
+)
+
+// alertExportRow is the Parquet schema of the alert_history dataset.
+type alertExportRow struct {
+	CreatedOn     int64  `parquet:"name=createdon, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	AlertID       string `parquet:"name=alert_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AlertName     string `parquet:"name=alert_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Severity      string `parquet:"name=severity, type=BYTE_ARRAY, convertedtype=UTF8"`
+	State         string `parquet:"name=state, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SitesImpacted string `parquet:"name=sites_impacted, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AnomalyDate   string `parquet:"name=anomaly_date, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Version       int64  `parquet:"name=version, type=INT64"`
+}
+
+// predictionExportRow is the Parquet schema of the prediction_history dataset.
+type predictionExportRow struct {
+	Site         string `parquet:"name=site, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Status       string `parquet:"name=status, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CreatedOn    int64  `parquet:"name=createdon, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	UpdatedOn    int64  `parquet:"name=updatedon, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	ErrorMessage string `parquet:"name=error_message, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Version      int64  `parquet:"name=version, type=INT64"`
+}
+
+// exportTableSpecs are the Glue tables over the export datasets, partitioned
+// by dt. Column types match the Parquet schemas above.
+var exportTableSpecs = map[string]GlueTableSpec{
+	ExportDatasetAlerts: {
+		Name:   ExportDatasetAlerts,
+		Format: "parquet",
+		Columns: []GlueColumn{
+			{Name: "createdon", Type: "timestamp"},
+			{Name: "alert_id", Type: "string"},
+			{Name: "alert_name", Type: "string"},
+			{Name: "severity", Type: "string"},
+			{Name: "state", Type: "string"},
+			{Name: "sites_impacted", Type: "string"},
+			{Name: "anomaly_date", Type: "string"},
+			{Name: "version", Type: "bigint"},
+		},
+		PartitionKeys: []GlueColumn{{Name: "dt", Type: "string"}},
+	},
+	ExportDatasetPredictions: {
+		Name:   ExportDatasetPredictions,
+		Format: "parquet",
+		Columns: []GlueColumn{
+			{Name: "site", Type: "string"},
+			{Name: "status", Type: "string"},
+			{Name: "createdon", Type: "timestamp"},
+			{Name: "updatedon", Type: "timestamp"},
+			{Name: "error_message", Type: "string"},
+			{Name: "version", Type: "bigint"},
+		},
+		PartitionKeys: []GlueColumn{{Name: "dt", Type: "string"}},
+	},
+}
+
+// registerExportPartitions adds the dt partitions written for dataset to the
+// Glue catalog, creating the table on first use.
+func registerExportPartitions(ctx context.Context, bucket, dataset string, days []string) error {
+	spec := exportTableSpecs[dataset]
+	spec.Location = fmt.Sprintf("s3://%s/%s", bucket, Layout().AnalyticsDatasetPrefix(dataset))
+	if err := EnsureGlueTable(ctx, spec); err != nil {
+		return err
+	}
+	for _, day := range days {
+		if err := AddGluePartition(ctx, spec, []string{day}, fmt.Sprintf("%sdt=%s/", spec.Location, day)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportResult describes the objects written by ExportTrackerHistory.
+type ExportResult struct {
+	Dataset string   `json:"dataset"`
+	Rows    int      `json:"rows"`
+	Keys    []string `json:"keys"`
+}
+
+// ExportTrackerHistory dumps alert-tracker and prediction-tracker records
+// created in [since, until) to snappy-compressed Parquet in bucket, one part
+// file per dataset and day at analytics/<dataset>/dt=<day>/part-<run>.parquet.
+// Re-running for the same window adds new part files rather than replacing
+// earlier ones, so exports should cover non-overlapping windows. With Glue
+// registration enabled the dt partitions are added to the catalog.
+func ExportTrackerHistory(ctx context.Context, bucket string, since, until time.Time) ([]ExportResult, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("export bucket is required")
+	}
+	runID := time.Now().UTC().UnixNano()
+
+	alerts, err := scanCreatedBetween[AlertTrackerItem](ctx, alertTrackerTable(), since, until)
+	if err != nil {
+		return nil, fmt.Errorf("scan %s: %w", alertTrackerTable(), err)
+	}
+	alertRows := make(map[string][]any)
+	for _, a := range alerts {
+		day := exportDay(a.CreatedOnMs)
+		alertRows[day] = append(alertRows[day], alertExportRow{
+			CreatedOn:     a.CreatedOnMs,
+			AlertID:       a.AlertID,
+			AlertName:     a.AlertName,
+			Severity:      a.Severity,
+			State:         a.State,
+			SitesImpacted: strings.Join(a.SitesImpacted, ","),
+			AnomalyDate:   a.AnomalyDate,
+			Version:       a.Version,
+		})
+	}
+	alertResult, err := writeExportPartitions(ctx, bucket, ExportDatasetAlerts, runID, new(alertExportRow), alertRows)
+	if err != nil {
+		return nil, err
+	}
+
+	preds, err := scanCreatedBetween[PredictionTrackerItem](ctx, predictionTrackerTable(), since, until)
+	if err != nil {
+		return nil, fmt.Errorf("scan %s: %w", predictionTrackerTable(), err)
+	}
+	predRows := make(map[string][]any)
+	for _, p := range preds {
+		day := exportDay(p.CreatedOn)
+		predRows[day] = append(predRows[day], predictionExportRow{
+			Site:         p.Site,
+			Status:       p.Status,
+			CreatedOn:    p.CreatedOn,
+			UpdatedOn:    p.UpdatedOn,
+			ErrorMessage: p.ErrorMessage,
+			Version:      p.Version,
+		})
+	}
+	predResult, err := writeExportPartitions(ctx, bucket, ExportDatasetPredictions, runID, new(predictionExportRow), predRows)
+	if err != nil {
+		return nil, err
+	}
+	return []ExportResult{alertResult, predResult}, nil
+}
+
+// scanCreatedBetween scans table for items whose createdon (epoch ms) falls in
+// [since, until).
+func scanCreatedBetween[T any](ctx context.Context, table string, since, until time.Time) ([]T, error) {
+	values, err := attributevalue.MarshalMap(map[string]any{
+		":since": since.UnixMilli(),
+		":until": until.UnixMilli() - 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var items []T
+	p := dynamodb.NewScanPaginator(getDynamoClient(), &dynamodb.ScanInput{
+		TableName:                 &table,
+		FilterExpression:          awsString("createdon BETWEEN :since AND :until"),
+		ExpressionAttributeValues: values,
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var batch []T
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, err
+		}
+		items = append(items, batch...)
+	}
+	return items, nil
+}
+
+func exportDay(epochMs int64) string {
+	return time.UnixMilli(epochMs).UTC().Format("2006-01-02")
+}
+
+// writeExportPartitions writes one Parquet object per day partition. schema
+// is a pointer to the row struct describing the file schema.
+func writeExportPartitions(ctx context.Context, bucket, dataset string, runID int64, schema any, byDay map[string][]any) (ExportResult, error) {
+	result := ExportResult{Dataset: dataset, Keys: []string{}}
+	days := make([]string, 0, len(byDay))
+	for d := range byDay {
+		days = append(days, d)
+	}
+	sort.Strings(days)
+	for _, day := range days {
+		rows := byDay[day]
+		data, err := encodeParquet(schema, rows)
+		if err != nil {
+			return result, fmt.Errorf("encode %s dt=%s: %w", dataset, day, err)
+		}
+		key := fmt.Sprintf("%sdt=%s/part-%d.parquet", Layout().AnalyticsDatasetPrefix(dataset), day, runID)
+		opts := ObjectOptions(ctx, key, PurposeExport, map[string]string{"dataset": dataset, "dt": day})
+		if err := SaveObject(ctx, data, bucket, key, opts); err != nil {
+			return result, err
+		}
+		result.Keys = append(result.Keys, key)
+		result.Rows += len(rows)
+	}
+	if GlueRegistrationEnabled() && len(days) > 0 {
+		if err := registerExportPartitions(ctx, bucket, dataset, days); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// encodeParquet serializes rows into an in-memory Parquet file.
+func encodeParquet(schema any, rows []any) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	pw, err := writer.NewParquetWriterFromWriter(buf, schema, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	for _, row := range rows {
+		if err := pw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
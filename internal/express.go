@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"aquawatch/internal/pipeline"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	sfntypes "github.com/aws/aws-sdk-go-v2/service/sfn/types"
+)
+
+// Small no-training ingests can run on the Express state machine
+// (infra/state_machine/aquawatch_express.json, EXPRESS_STATE_MACHINE_ARN)
+// with StartSyncExecution, so the caller gets the inference result in the
+// same request instead of polling /prediction/status.
+
+// ErrExpressNotEligible is returned by RunIngestSync for requests the Express
+// workflow can't run: training runs, or more than ExpressIngestMaxSites sites.
+var ErrExpressNotEligible = errors.New("request not eligible for express run")
+
+// IngestSyncResult is the outcome of an Express execution. Output is set when
+// Status is SUCCEEDED; Error and Cause otherwise.
+type IngestSyncResult struct {
+	ExecutionArn string                `json:"execution_arn"`
+	Name         string                `json:"execution_name"`
+	Status       string                `json:"status"`
+	Output       *pipeline.InferOutput `json:"output,omitempty"`
+	Error        string                `json:"error,omitempty"`
+	Cause        string                `json:"cause,omitempty"`
+	DurationMs   int64                 `json:"duration_ms"`
+}
+
+// ExpressIngestEnabled reports whether EXPRESS_STATE_MACHINE_ARN is configured.
+func ExpressIngestEnabled() bool {
+	return os.Getenv("EXPRESS_STATE_MACHINE_ARN") != ""
+}
+
+// ExpressIngestMaxSites is the most sites run inline by an Express execution
+// (INGEST_EXPRESS_MAX_SITES, default 5).
+func ExpressIngestMaxSites() int {
+	return max(envInt("INGEST_EXPRESS_MAX_SITES", 5), 1)
+}
+
+// ExpressEligible reports whether req can run on the Express workflow, which
+// fetches the default window only.
+func ExpressEligible(req IngestRequest) bool {
+	return ExpressIngestEnabled() && !req.Train && !req.DryRun && req.Start == "" && len(req.Sites) <= ExpressIngestMaxSites()
+}
+
+// RunIngestSync runs req on the Express state machine and waits for it to
+// finish (Express executions are capped at 5 minutes). The run is recorded in
+// the pipeline-runs table with its final status, and the sites' prediction
+// tracker records follow it: the infer lambda completes them, and a run that
+// fails before inference marks them failed here.
+func RunIngestSync(ctx context.Context, req IngestRequest) (*IngestSyncResult, error) {
+	stateMachineArn := os.Getenv("EXPRESS_STATE_MACHINE_ARN")
+	if stateMachineArn == "" {
+		return nil, fmt.Errorf("%w: EXPRESS_STATE_MACHINE_ARN not set", ErrIngestNotConfigured)
+	}
+	if !ExpressEligible(req) {
+		return nil, ErrExpressNotEligible
+	}
+	now := time.Now().UTC()
+	input, err := ingestExecutionInput(req, now)
+	if err != nil {
+		return nil, err
+	}
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("marshal state machine input: %w", err)
+	}
+
+	// Express execution names need not be unique, so the deterministic name
+	// only serves to trace the run.
+	name := ingestExecutionName(req, now)
+	for _, site := range req.Sites {
+		if err := AddPredictionTrackerStarted(ctx, site, ""); err != nil {
+			log.Printf("prediction tracker start failed for %s: %v", site, err)
+		}
+	}
+
+	out, err := getSFNClient().StartSyncExecution(ctx, &sfn.StartSyncExecutionInput{
+		StateMachineArn: aws.String(stateMachineArn),
+		Name:            aws.String(name),
+		Input:           aws.String(string(inputJSON)),
+	})
+	if err != nil {
+		markPredictionsFailed(ctx, req.Sites, err.Error())
+		return nil, err
+	}
+
+	res := &IngestSyncResult{
+		ExecutionArn: deref(out.ExecutionArn),
+		Name:         deref(out.Name),
+		Status:       string(out.Status),
+		Error:        deref(out.Error),
+		Cause:        deref(out.Cause),
+	}
+	started, stopped := now, time.Now().UTC()
+	if out.StartDate != nil {
+		started = *out.StartDate
+	}
+	if out.StopDate != nil {
+		stopped = *out.StopDate
+	}
+	res.DurationMs = stopped.Sub(started).Milliseconds()
+
+	if out.Status == sfntypes.SyncExecutionStatusSucceeded && out.Output != nil {
+		var inferOut pipeline.InferOutput
+		if err := json.Unmarshal([]byte(*out.Output), &inferOut); err != nil {
+			log.Printf("decode express output of %s: %v", res.ExecutionArn, err)
+		} else {
+			res.Output = &inferOut
+		}
+	}
+	if out.Status != sfntypes.SyncExecutionStatusSucceeded {
+		// Failures inside Infer are also recorded by the lambda; rewriting
+		// the failed record is harmless and covers runs that stopped earlier.
+		markPredictionsFailed(ctx, req.Sites, res.Error+": "+res.Cause)
+	}
+
+	retention := PipelineRunRetention()
+	run := PipelineRun{
+		ExecutionArn: res.ExecutionArn,
+		Name:         res.Name,
+		Sites:        req.Sites,
+		Parameter:    req.Parameter,
+		Status:       res.Status,
+		Error:        res.Error,
+		Cause:        res.Cause,
+		StartedOn:    started.UnixMilli(),
+		StoppedOn:    stopped.UnixMilli(),
+		UpdatedOn:    stopped.UnixMilli(),
+		ExpiresAt:    retention.ExpiresAt(started),
+	}
+	// Preprocess notes its data source on the record while the run is going.
+	if stored, err := GetPipelineRun(ctx, run.ExecutionArn); err == nil && stored != nil {
+		run.DataSource, run.FallbackPolicy = stored.DataSource, stored.FallbackPolicy
+	}
+	if err := savePipelineRun(ctx, run); err != nil {
+		log.Printf("pipeline run record failed for %s: %v", res.ExecutionArn, err)
+	}
+	PublishRunEvent(RunEventFrom(&run))
+	return res, nil
+}
+
+// markPredictionsFailed records a failed prediction for each site
+// (best-effort).
+func markPredictionsFailed(ctx context.Context, sites []string, msg string) {
+	for _, site := range sites {
+		if err := UpdatePredictionTrackerStatus(ctx, site, PredictionStatusFailed, msg); err != nil {
+			log.Printf("prediction tracker update failed for %s: %v", site, err)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Third-party and IoT sensors push readings to /ingest/external. Each
+// sender has a shared secret and signs its payloads; readings are checked,
+// converted to the parameter's USGS unit and written as ProcessedData rows
+// to a per-parameter daily dataset under the processed prefix, with the
+// same CSV schema, manifest and part layout as USGS ingests, so training,
+// exports and Athena read them alike.
+
+// DataSourceExternal marks dataset parts written from external sensors.
+const DataSourceExternal = "external"
+
+const (
+	// MaxExternalPayloadBytes bounds a signed payload.
+	MaxExternalPayloadBytes = 1 << 20
+	// maxExternalReadings bounds the readings of one payload.
+	maxExternalReadings = 1000
+	// externalSignatureSkew is how far a signature timestamp may be from now.
+	externalSignatureSkew = 5 * time.Minute
+	// maxExternalReadingAge is how old a reading may be.
+	maxExternalReadingAge = 365 * 24 * time.Hour
+)
+
+// ErrInvalidSignature is returned when a payload's signature, timestamp or
+// source doesn't check out.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// ErrInvalidReading is returned for readings that fail validation.
+var ErrInvalidReading = validationError("invalid reading")
+
+// externalUnits are the units accepted per parameter and the factor that
+// converts each to the parameter's USGS unit (listed first, factor 1).
+var externalUnits = map[string][]struct {
+	unit   string
+	factor float64
+}{
+	"00060": {{"ft3/s", 1}, {"cfs", 1}, {"m3/s", 35.3146667}, {"cms", 35.3146667}, {"l/s", 0.0353146667}},
+	"00065": {{"ft", 1}, {"feet", 1}, {"in", 1.0 / 12}, {"m", 3.2808399}, {"cm", 0.032808399}},
+}
+
+// ExternalReading is one sensor reading as sent. Parameter may be omitted
+// when the unit implies it.
+type ExternalReading struct {
+	Site      string   `json:"site"`
+	Parameter string   `json:"parameter"`
+	Timestamp string   `json:"timestamp"`
+	Value     *float64 `json:"value"`
+	Unit      string   `json:"unit"`
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+}
+
+// ExternalPayload is the body of /ingest/external.
+type ExternalPayload struct {
+	Readings []ExternalReading `json:"readings"`
+}
+
+// ExternalDatasetPart is where one parameter's readings of a payload went.
+type ExternalDatasetPart struct {
+	Parameter string `json:"parameter"`
+	Dataset   string `json:"dataset"`
+	Part      string `json:"part"`
+	Rows      int    `json:"rows"`
+}
+
+// externalSecret returns the secret of source from EXTERNAL_INGEST_SECRETS,
+// a comma-separated list of source=secret pairs.
+func externalSecret(source string) (string, bool) {
+	for _, pair := range strings.Split(os.Getenv("EXTERNAL_INGEST_SECRETS"), ",") {
+		name, secret, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && name == source && secret != "" {
+			return secret, true
+		}
+	}
+	return "", false
+}
+
+// ExternalSignature returns the signature of body sent at timestamp (Unix
+// seconds): "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>".
+func ExternalSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyExternalSignature checks that source is configured, timestamp is
+// within five minutes of now and signature is body's. Errors match
+// ErrInvalidSignature.
+func VerifyExternalSignature(source, timestamp, signature string, body []byte, now time.Time) error {
+	secret, ok := externalSecret(source)
+	if !ok {
+		return fmt.Errorf("%w: unknown source %q", ErrInvalidSignature, source)
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: timestamp must be Unix seconds", ErrInvalidSignature)
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > externalSignatureSkew || d < -externalSignatureSkew {
+		return fmt.Errorf("%w: timestamp outside the allowed window", ErrInvalidSignature)
+	}
+	if !hmac.Equal([]byte(signature), []byte(ExternalSignature(secret, timestamp, body))) {
+		return fmt.Errorf("%w: signature mismatch", ErrInvalidSignature)
+	}
+	return nil
+}
+
+// normalize validates r and returns its parameter and ProcessedData row in
+// the parameter's USGS unit.
+func (r ExternalReading) normalize(now time.Time) (string, ProcessedData, error) {
+	site := strings.TrimSpace(r.Site)
+	if !siteIDPattern.MatchString(site) {
+		return "", ProcessedData{}, fmt.Errorf("%w: invalid site id %q", ErrInvalidReading, r.Site)
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(r.Timestamp))
+	if err != nil {
+		return "", ProcessedData{}, fmt.Errorf("%w: timestamp must be RFC 3339 with a zone", ErrInvalidReading)
+	}
+	if t.After(now.Add(externalSignatureSkew)) || now.Sub(t) > maxExternalReadingAge {
+		return "", ProcessedData{}, fmt.Errorf("%w: timestamp must be within the last year", ErrInvalidReading)
+	}
+	if r.Value == nil || math.IsNaN(*r.Value) || math.IsInf(*r.Value, 0) {
+		return "", ProcessedData{}, fmt.Errorf("%w: value is required", ErrInvalidReading)
+	}
+	if math.Abs(r.Latitude) > 90 || math.Abs(r.Longitude) > 180 {
+		return "", ProcessedData{}, fmt.Errorf("%w: invalid coordinates", ErrInvalidReading)
+	}
+
+	unit := strings.ToLower(strings.TrimSpace(r.Unit))
+	parameter := strings.TrimSpace(r.Parameter)
+	for p, units := range externalUnits {
+		if parameter != "" && parameter != p {
+			continue
+		}
+		for _, u := range units {
+			if u.unit == unit {
+				return p, ProcessedData{
+					StationID: site,
+					Timestamp: t.UTC(),
+					Value:     *r.Value * u.factor,
+					Unit:      units[0].unit,
+					Latitude:  r.Latitude,
+					Longitude: r.Longitude,
+				}, nil
+			}
+		}
+	}
+	if _, ok := externalUnits[parameter]; parameter != "" && !ok {
+		return "", ProcessedData{}, fmt.Errorf("%w: unsupported parameter %q (00060 or 00065)", ErrInvalidReading, parameter)
+	}
+	return "", ProcessedData{}, fmt.Errorf("%w: unsupported unit %q", ErrInvalidReading, r.Unit)
+}
+
+// IngestExternalReadings validates payload's readings and appends them to
+// the day's external dataset of each parameter, one part per parameter
+// named after the payload's hash, so a payload redelivered the same day
+// adds no rows. Readings are all-or-nothing: any invalid one fails the call
+// with an error matching ErrInvalidReading that names it.
+func IngestExternalReadings(ctx context.Context, source string, payload ExternalPayload, body []byte) ([]ExternalDatasetPart, error) {
+	if len(payload.Readings) == 0 || len(payload.Readings) > maxExternalReadings {
+		return nil, fmt.Errorf("%w: between 1 and %d readings required", ErrInvalidReading, maxExternalReadings)
+	}
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("%w: S3_BUCKET not set", ErrIngestNotConfigured)
+	}
+	now := time.Now().UTC()
+	byParameter := map[string][]ProcessedData{}
+	for i, r := range payload.Readings {
+		parameter, row, err := r.normalize(now)
+		if err != nil {
+			return nil, fmt.Errorf("reading %d: %w", i, err)
+		}
+		byParameter[parameter] = append(byParameter[parameter], row)
+	}
+
+	var coords []Coord
+	for _, rows := range byParameter {
+		for _, row := range rows {
+			if row.Latitude != 0 || row.Longitude != 0 {
+				coords = append(coords, Coord{Lat: row.Latitude, Lon: row.Longitude})
+			}
+		}
+	}
+	temps := FetchTemperatures(ctx, coords)
+
+	runID := "external-" + SHA256Hex(body)[:32]
+	parts := make([]ExternalDatasetPart, 0, len(byParameter))
+	for parameter, rows := range byParameter {
+		dataset := Layout().ExternalDatasetKey(parameter, now)
+		sites := map[string]bool{}
+		for _, row := range rows {
+			sites[row.StationID] = true
+		}
+		partKey, err := AppendDatasetPart(ctx, bucket, dataset, runID, processedRowsCSV(rows, temps), map[string]string{
+			MetaSites:      strings.Join(slices.Sorted(maps.Keys(sites)), ","),
+			MetaDataSource: DataSourceExternal,
+		})
+		if err != nil {
+			return parts, fmt.Errorf("append %s readings: %w", parameter, err)
+		}
+		log.Printf("external source %s: appended %d %s readings to %s", source, len(rows), parameter, dataset)
+		if GlueRegistrationEnabled() {
+			if err := RegisterProcessedDataset(ctx, bucket, dataset); err != nil {
+				log.Printf("glue registration failed for %s: %v", dataset, err)
+			}
+		}
+		parts = append(parts, ExternalDatasetPart{Parameter: parameter, Dataset: dataset, Part: partKey, Rows: len(rows)})
+	}
+	slices.SortFunc(parts, func(a, b ExternalDatasetPart) int { return cmp.Compare(a.Parameter, b.Parameter) })
+	return parts, nil
+}
+
+// processedRowsCSV writes rows as processed feature CSV
+// (value,timestamp_unix,latitude,longitude,wx_temp), the same columns
+// writeFeatureCSV produces from USGS payloads.
+func processedRowsCSV(rows []ProcessedData, temps map[Coord]int) []byte {
+	buf := make([]byte, 0, len(rows)*64)
+	for _, row := range rows {
+		buf = appendProcessedRow(buf, row.Value, row.Timestamp.Unix(), row.Latitude, row.Longitude,
+			int64(temps[Coord{Lat: row.Latitude, Lon: row.Longitude}]))
+	}
+	return buf
+}
+
+// appendProcessedRow appends one processed feature CSV line to buf.
+func appendProcessedRow(buf []byte, value float64, unix int64, lat, lon float64, temp int64) []byte {
+	buf = strconv.AppendFloat(buf, value, 'f', 6, 64)
+	buf = append(buf, ',')
+	buf = strconv.AppendInt(buf, unix, 10)
+	buf = append(buf, ',')
+	buf = strconv.AppendFloat(buf, lat, 'f', 6, 64)
+	buf = append(buf, ',')
+	buf = strconv.AppendFloat(buf, lon, 'f', 6, 64)
+	buf = append(buf, ',')
+	buf = strconv.AppendInt(buf, temp, 10)
+	return append(buf, '\n')
+}
@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"aquawatch/internal/synthetic"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// When both USGS feeds fail, preprocess applies the fallback policy set in
+// INGEST_FALLBACK_POLICY instead of training on made-up data:
+//
+//	fail       the step fails, and the run with it (default)
+//	last_good  reuse each station's last successfully fetched payload
+//	synthetic  generate a plausible series, tagged as synthetic
+//
+// The source the rows came from, and the policy when one was applied, are
+// recorded on the dataset part (data-source metadata) and the run's
+// pipeline-runs record.
+
+// Fallback policies.
+const (
+	FallbackFail      = "fail"
+	FallbackLastGood  = "last_good"
+	FallbackSynthetic = "synthetic"
+)
+
+// Data sources of a preprocess run.
+const (
+	DataSourceDaily     = "usgs_dv"
+	DataSourceInstant   = "usgs_iv"
+	DataSourceLastGood  = "last_good"
+	DataSourceSynthetic = "synthetic"
+)
+
+// ErrSourceUnavailable is returned by ApplyFallbackPolicy when the upstream
+// feeds failed and the policy provides no substitute.
+var ErrSourceUnavailable = upstreamError("upstream data unavailable")
+
+// FallbackPolicy returns INGEST_FALLBACK_POLICY, or FallbackFail when it is
+// unset or not a known policy.
+func FallbackPolicy() string {
+	p := strings.ToLower(strings.TrimSpace(os.Getenv("INGEST_FALLBACK_POLICY")))
+	switch p {
+	case FallbackFail, FallbackLastGood, FallbackSynthetic:
+		return p
+	case "":
+	default:
+		log.Printf("unknown INGEST_FALLBACK_POLICY %q; using %s", p, FallbackFail)
+	}
+	return FallbackFail
+}
+
+// ApplyFallbackPolicy returns substitute payloads (one per station) after
+// the upstream fetch failed with cause, and the data source they came from.
+// With FallbackFail, or FallbackLastGood when a station has no saved copy,
+// it returns an error matching ErrSourceUnavailable.
+func ApplyFallbackPolicy(ctx context.Context, policy, bucket string, stations []string, parameter string, cause error) ([][]byte, string, error) {
+	switch policy {
+	case FallbackLastGood:
+		payloads, err := LoadLastGoodPayloads(ctx, bucket, stations, parameter)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v (no last good copy: %v)", ErrSourceUnavailable, cause, err)
+		}
+		return payloads, DataSourceLastGood, nil
+	case FallbackSynthetic:
+		now := time.Now().UTC()
+		payloads := make([][]byte, 0, len(stations))
+		for _, s := range stations {
+			p, err := SyntheticWaterPayload(s, parameter, now)
+			if err != nil {
+				return nil, "", err
+			}
+			payloads = append(payloads, p)
+		}
+		return payloads, DataSourceSynthetic, nil
+	}
+	return nil, "", fmt.Errorf("%w: %v", ErrSourceUnavailable, cause)
+}
+
+// SaveLastGoodPayloads keeps each station's freshly fetched payload as its
+// last good copy, overwriting the previous one. payloads are in station
+// order; empty ones are skipped.
+func SaveLastGoodPayloads(ctx context.Context, bucket string, stations []string, parameter string, payloads [][]byte) error {
+	var errs []error
+	for i, s := range stations {
+		if i >= len(payloads) || len(payloads[i]) == 0 {
+			continue
+		}
+		key := Layout().LastGoodRawKey(parameter, s)
+		opts := ObjectOptions(ctx, key, PurposeRaw, map[string]string{MetaSites: s})
+		if err := getBlobStore().Put(ctx, bucket, key, payloads[i], opts); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LoadLastGoodPayloads returns the last good copy of every station, failing
+// if any station has none.
+func LoadLastGoodPayloads(ctx context.Context, bucket string, stations []string, parameter string) ([][]byte, error) {
+	payloads := make([][]byte, 0, len(stations))
+	for _, s := range stations {
+		blob, err := getBlobStore().Get(ctx, bucket, Layout().LastGoodRawKey(parameter, s))
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, blob.Data)
+	}
+	return payloads, nil
+}
+
+// SyntheticWaterPayload builds a USGS DV-shaped payload of 30 daily values
+// ending at now from the synthetic package's generator, so repeated runs for
+// a station look alike. The series carries the "SYN" qualifier and a
+// "synthetic" site name.
+func SyntheticWaterPayload(station, parameter string, now time.Time) ([]byte, error) {
+	end := now.UTC()
+	return synthetic.DailyPayload(station, parameter, end.AddDate(0, 0, -29), end)
+}
+
+// RecordPipelineRunDataSource notes on the run's record where its rows came
+// from and, when the upstream feeds failed, the fallback policy applied.
+// Empty values are left unset.
+func RecordPipelineRunDataSource(ctx context.Context, executionArn, source, policy string) error {
+	key, err := attributevalue.MarshalMap(map[string]any{"execution_arn": executionArn})
+	if err != nil {
+		return err
+	}
+	var sets []string
+	vals := map[string]any{}
+	if source != "" {
+		sets = append(sets, "data_source = :s")
+		vals[":s"] = source
+	}
+	if policy != "" {
+		sets = append(sets, "fallback_policy = :p")
+		vals[":p"] = policy
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+	values, err := attributevalue.MarshalMap(vals)
+	if err != nil {
+		return err
+	}
+	_, err = getDynamoClient().UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 awsString(pipelineRunsTable()),
+		Key:                       key,
+		UpdateExpression:          awsString("SET " + strings.Join(sets, ", ")),
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
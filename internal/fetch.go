@@ -1,14 +1,60 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
 	"strings"
 	"time"
+
+	"aquawatch/internal/httpclient"
+	"aquawatch/internal/synthetic"
 )
 
+// usgsClient fetches from waterservices.usgs.gov. USGS asks clients to keep
+// request rates modest, so it's limited per host. Network errors, timeouts,
+// 429 and 5xx responses are retried with jittered exponential backoff; the
+// policy is shared by the daily and instantaneous fetchers and set by
+// USGS_RETRY_MAX_ATTEMPTS (default 4), USGS_RETRY_BASE_BACKOFF_MS (default
+// 500) and USGS_RETRY_MAX_BACKOFF_MS (default 8000). Retries stop at the
+// fetch stage deadline.
+var usgsClient = httpclient.New(httpclient.Options{
+	Name:          "usgs",
+	Timeout:       30 * time.Second,
+	MaxAttempts:   envInt("USGS_RETRY_MAX_ATTEMPTS", 4),
+	BaseBackoff:   time.Duration(envInt("USGS_RETRY_BASE_BACKOFF_MS", 500)) * time.Millisecond,
+	MaxBackoff:    time.Duration(envInt("USGS_RETRY_MAX_BACKOFF_MS", 8000)) * time.Millisecond,
+	RatePerSecond: 5,
+	Burst:         5,
+	Breaker:       true,
+})
+
+// Water data providers. With ProviderSynthetic, every USGS fetch is answered
+// by the synthetic generator instead, for any station ID: ingest, sweeps and
+// backfills run end to end on demo or load-test stations without calling
+// USGS.
+const (
+	ProviderUSGS      = "usgs"
+	ProviderSynthetic = "synthetic"
+)
+
+// WaterDataProvider returns WATER_DATA_PROVIDER, or ProviderUSGS when it is
+// unset or not a known provider.
+func WaterDataProvider() string {
+	p := strings.ToLower(strings.TrimSpace(os.Getenv("WATER_DATA_PROVIDER")))
+	switch p {
+	case ProviderUSGS, ProviderSynthetic:
+		return p
+	case "":
+	default:
+		log.Printf("unknown WATER_DATA_PROVIDER %q; using %s", p, ProviderUSGS)
+	}
+	return ProviderUSGS
+}
+
 // USGSResponse is a minimal placeholder for potential parsing of the USGS
 // service response. The ingest flow currently forwards raw payloads to
 // preprocessing, so this type is intentionally lightweight.
@@ -25,90 +71,130 @@ type USGSResponse struct {
 // GetWaterDataBatch fetches USGS Instantaneous Values for each station id in the slice
 // and returns one raw JSON payload per station, in the same order.
 // parameter example: "00060" (discharge), "00065" (gage height)
-func GetWaterDataBatch(stationIDs []string, parameter string) ([][]byte, error) {
+// Each station's fetch is bounded by the fetch stage deadline, and the batch
+// stops as soon as ctx is done.
+func GetWaterDataBatch(ctx context.Context, stationIDs []string, parameter string) ([][]byte, error) {
 	results := make([][]byte, 0, len(stationIDs))
 	for _, stationID := range stationIDs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		stationID = strings.TrimSpace(stationID)
 		log.Println("get water data for stationID", stationID)
 		if stationID == "" {
 			results = append(results, nil)
 			continue
 		}
-		url := fmt.Sprintf(
-			"https://waterservices.usgs.gov/nwis/iv/?format=json&sites=%s&parameterCd=%s",
-			stationID,
-			parameter,
-		)
-		resp, err := http.Get(url)
-		if err != nil {
-			return nil, fmt.Errorf("USGS API request failed for %s: %w", stationID, err)
-		}
-		if resp.Body != nil {
-			defer resp.Body.Close()
-		}
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("USGS API non-OK status for %s: %d", stationID, resp.StatusCode)
-		}
-		data, err := io.ReadAll(resp.Body)
+		data, err := getInstantValues(ctx, stationID, parameter)
 		if err != nil {
-			return nil, fmt.Errorf("reading HTTP response failed for %s: %w", stationID, err)
+			return nil, err
 		}
 		results = append(results, data)
 	}
 	return results, nil
 }
 
+// getInstantValues fetches one station's latest USGS Instantaneous Values, or
+// generates them under the synthetic provider.
+func getInstantValues(ctx context.Context, stationID, parameter string) ([]byte, error) {
+	if WaterDataProvider() == ProviderSynthetic {
+		return synthetic.InstantPayload(stationID, parameter, time.Now())
+	}
+	ctx, cancel := withStageTimeout(ctx, StageFetch)
+	defer cancel()
+	url := fmt.Sprintf(
+		"https://waterservices.usgs.gov/nwis/iv/?format=json&sites=%s&parameterCd=%s",
+		stationID,
+		parameter,
+	)
+	resp, err := usgsClient.Get(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: USGS API request failed for %s: %w", ErrUpstreamUnavailable, stationID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, upstreamStatusError("USGS API", stationID, resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading HTTP response failed for %s: %w", ErrUpstreamUnavailable, stationID, err)
+	}
+	return data, nil
+}
+
 // GetWaterData is a compatibility wrapper for fetching a single station's payload.
-func GetWaterData(stationID string, parameter string) ([]byte, error) {
-	payloads, err := GetWaterDataBatch([]string{stationID}, parameter)
+func GetWaterData(ctx context.Context, stationID string, parameter string) ([]byte, error) {
+	payloads, err := GetWaterDataBatch(ctx, []string{stationID}, parameter)
 	if err != nil {
 		return nil, err
 	}
 	if len(payloads) == 0 || payloads[0] == nil {
-		return nil, fmt.Errorf("no data returned for station %s", stationID)
+		return nil, fmt.Errorf("%w: no data returned for station %s", ErrNotFound, stationID)
 	}
 	return payloads[0], nil
 }
 
 // GetWaterDailyDataLast30DaysBatch fetches USGS Daily Values (mean by default) for the
 // last 30 days for each station id and returns one raw JSON payload per station.
-// Uses the DV endpoint with statCd=00003 (mean).
-func GetWaterDailyDataLast30DaysBatch(stationIDs []string, parameter string) ([][]byte, error) {
-	results := make([][]byte, 0, len(stationIDs))
+// Uses the DV endpoint with statCd=00003 (mean). Like GetWaterDataBatch, it
+// stops as soon as ctx is done.
+func GetWaterDailyDataLast30DaysBatch(ctx context.Context, stationIDs []string, parameter string) ([][]byte, error) {
 	end := time.Now().UTC()
-	start := end.AddDate(0, 0, -30)
-	startStr := start.Format("2006-01-02")
-	endStr := end.Format("2006-01-02")
+	return GetWaterDailyDataRangeBatch(ctx, stationIDs, parameter, end.AddDate(0, 0, -30), end)
+}
 
+// GetWaterDailyDataRangeBatch fetches USGS Daily Values (mean) for the days
+// start through end, inclusive, for each station id and returns one raw JSON
+// payload per station, so training data can be backfilled over any
+// historical window. It stops as soon as ctx is done.
+func GetWaterDailyDataRangeBatch(ctx context.Context, stationIDs []string, parameter string, start, end time.Time) ([][]byte, error) {
+	results := make([][]byte, 0, len(stationIDs))
 	for _, stationID := range stationIDs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		stationID = strings.TrimSpace(stationID)
-		log.Println("get daily water data (30d) for stationID", stationID)
+		log.Printf("get daily water data (%s to %s) for stationID %s", start.Format(time.DateOnly), end.Format(time.DateOnly), stationID)
 		if stationID == "" {
 			results = append(results, nil)
 			continue
 		}
-		url := fmt.Sprintf(
-			"https://waterservices.usgs.gov/nwis/dv/?format=json&sites=%s&parameterCd=%s&statCd=00003&startDT=%s&endDT=%s",
-			stationID,
-			parameter,
-			startStr,
-			endStr,
-		)
-		resp, err := http.Get(url)
-		if err != nil {
-			return nil, fmt.Errorf("USGS DV API request failed for %s: %w", stationID, err)
-		}
-		if resp.Body != nil {
-			defer resp.Body.Close()
-		}
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("USGS DV API non-OK status for %s: %d", stationID, resp.StatusCode)
-		}
-		data, err := io.ReadAll(resp.Body)
+		data, err := getDailyValues(ctx, stationID, parameter, start, end)
 		if err != nil {
-			return nil, fmt.Errorf("reading DV HTTP response failed for %s: %w", stationID, err)
+			return nil, err
 		}
 		results = append(results, data)
 	}
 	return results, nil
 }
+
+// getDailyValues fetches one station's USGS Daily Values (mean, statCd=00003)
+// for the days start through end, inclusive, within the fetch stage deadline.
+// Under the synthetic provider the values are generated instead.
+func getDailyValues(ctx context.Context, stationID, parameter string, start, end time.Time) ([]byte, error) {
+	if WaterDataProvider() == ProviderSynthetic {
+		return synthetic.DailyPayload(stationID, parameter, start, end)
+	}
+	ctx, cancel := withStageTimeout(ctx, StageFetch)
+	defer cancel()
+	url := fmt.Sprintf(
+		"https://waterservices.usgs.gov/nwis/dv/?format=json&sites=%s&parameterCd=%s&statCd=00003&startDT=%s&endDT=%s",
+		stationID,
+		parameter,
+		start.Format("2006-01-02"),
+		end.Format("2006-01-02"),
+	)
+	resp, err := usgsClient.Get(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: USGS DV API request failed for %s: %w", ErrUpstreamUnavailable, stationID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, upstreamStatusError("USGS DV API", stationID, resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading DV HTTP response failed for %s: %w", ErrUpstreamUnavailable, stationID, err)
+	}
+	return data, nil
+}
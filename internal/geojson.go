@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"aquawatch/internal/cache"
+)
+
+// Map layers are GeoJSON FeatureCollections: one Point per station, with
+// the station's latest evaluation and open alert severity joined in, so
+// mapping libraries render them as they come.
+
+// GeoJSONContentType is the media type map layers are served with.
+const GeoJSONContentType = "application/geo+json"
+
+// Station map statuses.
+const (
+	StationStatusAnomalous = "anomalous"
+	StationStatusNormal    = "normal"
+	// StationStatusUnknown marks stations never evaluated for the parameter.
+	StationStatusUnknown = "unknown"
+)
+
+// MaxMapLayerSites bounds the stations of one map layer.
+const MaxMapLayerSites = 1000
+
+// alertSeverityRank orders alert severities.
+var alertSeverityRank = map[string]int{"low": 1, "medium": 2, "high": 3}
+
+// FeatureCollection is a GeoJSON feature collection.
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a GeoJSON feature.
+type Feature struct {
+	Type       string            `json:"type"`
+	ID         string            `json:"id"`
+	Geometry   Geometry          `json:"geometry"`
+	Properties StationProperties `json:"properties"`
+}
+
+// Geometry is a GeoJSON point; Coordinates are longitude, latitude.
+type Geometry struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// StationProperties are a station feature's properties.
+type StationProperties struct {
+	Site      string `json:"site"`
+	Name      string `json:"name,omitempty"`
+	Parameter string `json:"parameter"`
+	Status    string `json:"status"`
+	// Severity is the highest severity of the station's unresolved alerts
+	// of the last 7 days; omitted when it has none.
+	Severity       string             `json:"severity,omitempty"`
+	EvaluatedOn    int64              `json:"evaluatedon_ms,omitempty"`
+	ObservedValue  *float64           `json:"observed_value,omitempty"`
+	PredictedValue *float64           `json:"predicted_value,omitempty"`
+	PercentChange  *float64           `json:"percent_change,omitempty"`
+	Percentile     *PercentileContext `json:"percentile,omitempty"`
+}
+
+// mapLayerCache holds station layers briefly: each reads every station's
+// latest evaluation, and map clients poll.
+var mapLayerCache = cache.New[string, FeatureCollection]("map-layers", 100, time.Minute)
+
+// StationsGeoJSON returns a point per site with its latest evaluation of
+// parameter and alert severity. Sites the USGS site service can't locate
+// are left out. Layers may be a minute old.
+func StationsGeoJSON(ctx context.Context, sites []string, parameter string) (FeatureCollection, error) {
+	key := parameter + "#" + strings.Join(sites, ",")
+	return mapLayerCache.GetOrLoad(ctx, key, func(ctx context.Context) (FeatureCollection, error) {
+		return buildStationLayer(ctx, sites, parameter)
+	})
+}
+
+// AnomaliesGeoJSON is StationsGeoJSON limited to sites whose latest
+// evaluation is anomalous.
+func AnomaliesGeoJSON(ctx context.Context, sites []string, parameter string) (FeatureCollection, error) {
+	layer, err := StationsGeoJSON(ctx, sites, parameter)
+	if err != nil {
+		return FeatureCollection{}, err
+	}
+	out := FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+	for _, f := range layer.Features {
+		if f.Properties.Status == StationStatusAnomalous {
+			out.Features = append(out.Features, f)
+		}
+	}
+	return out, nil
+}
+
+func buildStationLayer(ctx context.Context, sites []string, parameter string) (FeatureCollection, error) {
+	locations, err := SiteLocations(ctx, sites)
+	if err != nil {
+		return FeatureCollection{}, fmt.Errorf("site locations: %w", err)
+	}
+	evals, err := LatestAnomalyEvaluations(ctx, sites, parameter)
+	if err != nil {
+		return FeatureCollection{}, fmt.Errorf("latest evaluations: %w", err)
+	}
+	latest := make(map[string]AnomalyEvaluation, len(evals))
+	for _, e := range evals {
+		latest[e.Site] = e
+	}
+	severities, err := activeAlertSeverities(ctx, time.Now().Add(-activeAlertLookback))
+	if err != nil {
+		// Severity is decoration; the layer is still useful without it.
+		log.Printf("map layer: alert severities failed: %v", err)
+	}
+
+	fc := FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+	for _, site := range sites {
+		loc, ok := locations[site]
+		if !ok {
+			continue
+		}
+		props := StationProperties{
+			Site:      site,
+			Name:      loc.Name,
+			Parameter: parameter,
+			Status:    StationStatusUnknown,
+			Severity:  severities[site],
+		}
+		if e, ok := latest[site]; ok {
+			props.Status = StationStatusNormal
+			if e.Anomalous {
+				props.Status = StationStatusAnomalous
+			}
+			props.EvaluatedOn = e.EvaluatedOn
+			props.ObservedValue, props.PredictedValue, props.PercentChange = &e.ObservedValue, &e.PredictedValue, &e.PercentChange
+			props.Percentile = e.Percentile
+		}
+		fc.Features = append(fc.Features, Feature{
+			Type:       "Feature",
+			ID:         site,
+			Geometry:   Geometry{Type: "Point", Coordinates: [2]float64{loc.Longitude, loc.Latitude}},
+			Properties: props,
+		})
+	}
+	return fc, nil
+}
+
+// activeAlertSeverities returns the highest severity of each site's
+// unresolved alerts created since since.
+func activeAlertSeverities(ctx context.Context, since time.Time) (map[string]string, error) {
+	out := map[string]string{}
+	var read int
+	var cursor string
+	for read < maxCountedAlerts {
+		items, next, err := ListRecentAlertsPage(ctx, since.UnixMilli(), 200, cursor)
+		if err != nil {
+			return out, err
+		}
+		read += len(items)
+		for _, it := range items {
+			if it.State == AlertStateResolved {
+				continue
+			}
+			severity := strings.ToLower(it.Severity)
+			for _, site := range it.SitesImpacted {
+				if alertSeverityRank[severity] > alertSeverityRank[out[site]] {
+					out[site] = severity
+				}
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	return out, nil
+}
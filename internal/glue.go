@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glue"
+	"github.com/aws/aws-sdk-go-v2/service/glue/types"
+)
+
+// Glue Data Catalog registration makes processed datasets and analytics
+// exports queryable from Athena as soon as they are written. It is off by
+// default; enable it with GLUE_REGISTRATION_ENABLED=true. Tables are created
+// in GLUE_DATABASE (default "aquawatch"), which must already exist.
+
+// ProcessedFeaturesTable is the Glue table over processed training CSVs. Each
+// dataset is one partition (dataset=<name>) pointing at its parts prefix.
+const ProcessedFeaturesTable = "processed_features"
+
+// GlueColumn is a column name and Hive type (string, bigint, double, timestamp...).
+type GlueColumn struct {
+	Name string
+	Type string
+}
+
+// GlueTableSpec describes a partitioned external table over S3 data.
+type GlueTableSpec struct {
+	Name          string
+	Location      string // s3://bucket/prefix/
+	Format        string // "csv" or "parquet"
+	Columns       []GlueColumn
+	PartitionKeys []GlueColumn
+}
+
+// processedFeatureColumns mirrors the processed CSV layout
+// (see ProcessedSchemaVersion).
+var processedFeatureColumns = []GlueColumn{
+	{Name: "value", Type: "double"},
+	{Name: "timestamp_unix", Type: "bigint"},
+	{Name: "latitude", Type: "double"},
+	{Name: "longitude", Type: "double"},
+	{Name: "wx_temp", Type: "bigint"},
+}
+
+// GlueRegistrationEnabled reports whether GLUE_REGISTRATION_ENABLED is set.
+func GlueRegistrationEnabled() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("GLUE_REGISTRATION_ENABLED"))) {
+	case "true", "1", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// glueDatabase returns GLUE_DATABASE, by default "aquawatch" decorated by
+// the resource naming with hyphens turned into underscores, as Athena
+// requires.
+func glueDatabase() string {
+	if v := os.Getenv("GLUE_DATABASE"); v != "" {
+		return v
+	}
+	return strings.ReplaceAll(Naming().Name("aquawatch"), "-", "_")
+}
+
+var (
+	glueClientOnce sync.Once
+	glueClient     *glue.Client
+)
+
+func getGlueClient() *glue.Client {
+	glueClientOnce.Do(func() {
+		glueClient = glue.NewFromConfig(regionalConfig("GLUE_REGION"))
+	})
+	return glueClient
+}
+
+// storageDescriptor builds the Glue storage descriptor for a table or
+// partition at location.
+func storageDescriptor(spec GlueTableSpec, location string) *types.StorageDescriptor {
+	cols := make([]types.Column, 0, len(spec.Columns))
+	for _, c := range spec.Columns {
+		cols = append(cols, types.Column{Name: aws.String(c.Name), Type: aws.String(c.Type)})
+	}
+	sd := &types.StorageDescriptor{Columns: cols, Location: aws.String(location)}
+	switch spec.Format {
+	case "parquet":
+		sd.InputFormat = aws.String("org.apache.hadoop.hive.ql.io.parquet.MapredParquetInputFormat")
+		sd.OutputFormat = aws.String("org.apache.hadoop.hive.ql.io.parquet.MapredParquetOutputFormat")
+		sd.SerdeInfo = &types.SerDeInfo{
+			SerializationLibrary: aws.String("org.apache.hadoop.hive.ql.io.parquet.serde.ParquetHiveSerDe"),
+		}
+	default:
+		sd.InputFormat = aws.String("org.apache.hadoop.mapred.TextInputFormat")
+		sd.OutputFormat = aws.String("org.apache.hadoop.hive.ql.io.HiveIgnoreKeyTextOutputFormat")
+		sd.SerdeInfo = &types.SerDeInfo{
+			SerializationLibrary: aws.String("org.apache.hadoop.hive.serde2.lazy.LazySimpleSerDe"),
+			Parameters:           map[string]string{"field.delim": ","},
+		}
+	}
+	return sd
+}
+
+// EnsureGlueTable creates the table, or updates its schema and location if
+// it already exists.
+func EnsureGlueTable(ctx context.Context, spec GlueTableSpec) error {
+	keys := make([]types.Column, 0, len(spec.PartitionKeys))
+	for _, k := range spec.PartitionKeys {
+		keys = append(keys, types.Column{Name: aws.String(k.Name), Type: aws.String(k.Type)})
+	}
+	classification := spec.Format
+	if classification == "" {
+		classification = "csv"
+	}
+	input := &types.TableInput{
+		Name:              aws.String(spec.Name),
+		TableType:         aws.String("EXTERNAL_TABLE"),
+		Parameters:        map[string]string{"classification": classification, "EXTERNAL": "TRUE"},
+		StorageDescriptor: storageDescriptor(spec, spec.Location),
+		PartitionKeys:     keys,
+	}
+	client := getGlueClient()
+	_, err := client.CreateTable(ctx, &glue.CreateTableInput{
+		DatabaseName: aws.String(glueDatabase()),
+		TableInput:   input,
+	})
+	var exists *types.AlreadyExistsException
+	if errors.As(err, &exists) {
+		_, err = client.UpdateTable(ctx, &glue.UpdateTableInput{
+			DatabaseName: aws.String(glueDatabase()),
+			TableInput:   input,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("glue table %s: %w", spec.Name, err)
+	}
+	return nil
+}
+
+// AddGluePartition registers a partition of the table; values are in
+// PartitionKeys order. Registering an existing partition is a no-op.
+func AddGluePartition(ctx context.Context, spec GlueTableSpec, values []string, location string) error {
+	_, err := getGlueClient().CreatePartition(ctx, &glue.CreatePartitionInput{
+		DatabaseName: aws.String(glueDatabase()),
+		TableName:    aws.String(spec.Name),
+		PartitionInput: &types.PartitionInput{
+			Values:            values,
+			StorageDescriptor: storageDescriptor(spec, location),
+		},
+	})
+	var exists *types.AlreadyExistsException
+	if err != nil && !errors.As(err, &exists) {
+		return fmt.Errorf("glue partition %s%v: %w", spec.Name, values, err)
+	}
+	return nil
+}
+
+// ProcessedFeaturesTableSpec returns the spec of the processed_features table
+// in bucket.
+func ProcessedFeaturesTableSpec(bucket string) GlueTableSpec {
+	return GlueTableSpec{
+		Name:          ProcessedFeaturesTable,
+		Location:      fmt.Sprintf("s3://%s/%s", bucket, Layout().ProcessedPrefix()),
+		Format:        "csv",
+		Columns:       processedFeatureColumns,
+		PartitionKeys: []GlueColumn{{Name: "dataset", Type: "string"}},
+	}
+}
+
+// RegisterProcessedDataset ensures the processed_features table exists and
+// adds dataset as a partition over its parts prefix. The partition value is
+// the dataset key relative to the processed prefix, without .csv.
+func RegisterProcessedDataset(ctx context.Context, bucket, dataset string) error {
+	spec := ProcessedFeaturesTableSpec(bucket)
+	if err := EnsureGlueTable(ctx, spec); err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(datasetBase(dataset), Layout().ProcessedPrefix())
+	location := fmt.Sprintf("s3://%s/%s", bucket, DatasetPartsPrefix(dataset))
+	return AddGluePartition(ctx, spec, []string{name}, location)
+}
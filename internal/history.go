@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GET /history reads a station's daily values through a store partitioned by
+// parameter, station and month under the processed prefix
+// (HistoryPartitionKey), one row per day in the processed feature schema.
+// Months missing from the store are fetched from the USGS daily values
+// service, one request per run of consecutive months, and written back, so
+// repeated chart loads of a range don't reach USGS. A month fetched after it
+// ended is final; partitions of the current month are refetched once older
+// than HISTORY_REFRESH_MINUTES.
+
+const (
+	// MaxHistoryDays bounds the range of one history request.
+	MaxHistoryDays = 3660
+	// historyLoaders bounds the partitions read from the store at once.
+	historyLoaders = 8
+)
+
+// MetaFetchedOn records when a history partition was fetched from USGS
+// (epoch seconds).
+const MetaFetchedOn = "fetched-on"
+
+// ErrInvalidHistoryRange is returned for history ranges that are empty or
+// longer than MaxHistoryDays.
+var ErrInvalidHistoryRange = validationError("invalid history range")
+
+// History is a station's daily values of a parameter over a range of days.
+type History struct {
+	Site      string `json:"site"`
+	Parameter string `json:"parameter"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	// Points holds one daily mean per day with data, oldest first.
+	Points []HistoryPoint `json:"points"`
+	// StoredMonths and FetchedMonths count the months of the range served
+	// from the store and fetched from USGS.
+	StoredMonths  int `json:"stored_months"`
+	FetchedMonths int `json:"fetched_months"`
+}
+
+// HistoryPoint is the daily mean of one day (YYYY-MM-DD).
+type HistoryPoint struct {
+	Day   string  `json:"day"`
+	Value float64 `json:"value"`
+}
+
+// historyPartition is one month of a history request.
+type historyPartition struct {
+	month  time.Time
+	points []HistoryPoint
+	// stored is set when the month was served from the store.
+	stored bool
+}
+
+// historyRefreshAfter is how long a partition of an unfinished month is
+// served before it is fetched again (HISTORY_REFRESH_MINUTES, default 60).
+func historyRefreshAfter() time.Duration {
+	return time.Duration(envInt("HISTORY_REFRESH_MINUTES", 60)) * time.Minute
+}
+
+// GetHistory returns the daily values of site for parameter from start to
+// end (dates, UTC; end is capped at today). Without S3_BUCKET every month is
+// fetched from USGS and nothing is stored. Errors match
+// ErrInvalidHistoryRange for bad ranges; upstream failures are returned as
+// is.
+func GetHistory(ctx context.Context, site, parameter string, start, end time.Time) (*History, error) {
+	now := time.Now().UTC()
+	start, end = start.UTC().Truncate(24*time.Hour), end.UTC().Truncate(24*time.Hour)
+	if today := now.Truncate(24 * time.Hour); end.After(today) {
+		end = today
+	}
+	if start.After(end) {
+		return nil, fmt.Errorf("%w: start must not be after end (or today)", ErrInvalidHistoryRange)
+	}
+	if end.Sub(start) >= MaxHistoryDays*24*time.Hour {
+		return nil, fmt.Errorf("%w: at most %d days", ErrInvalidHistoryRange, MaxHistoryDays)
+	}
+
+	var parts []historyPartition
+	for m := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(end); m = m.AddDate(0, 1, 0) {
+		parts = append(parts, historyPartition{month: m})
+	}
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket != "" {
+		sem := make(chan struct{}, historyLoaders)
+		var wg sync.WaitGroup
+		for i := range parts {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				parts[i].points, parts[i].stored = loadHistoryPartition(ctx, bucket, site, parameter, parts[i].month, now)
+			}()
+		}
+		wg.Wait()
+	}
+	for i := 0; i < len(parts); {
+		if parts[i].stored {
+			i++
+			continue
+		}
+		j := i
+		for j < len(parts) && !parts[j].stored {
+			j++
+		}
+		if err := fetchHistory(ctx, bucket, site, parameter, parts[i:j], end, now); err != nil {
+			return nil, err
+		}
+		i = j
+	}
+
+	h := &History{
+		Site:      site,
+		Parameter: parameter,
+		Start:     start.Format(time.DateOnly),
+		End:       end.Format(time.DateOnly),
+		Points:    []HistoryPoint{},
+	}
+	for _, p := range parts {
+		if p.stored {
+			h.StoredMonths++
+		} else {
+			h.FetchedMonths++
+		}
+		for _, pt := range p.points {
+			if pt.Day >= h.Start && pt.Day <= h.End {
+				h.Points = append(h.Points, pt)
+			}
+		}
+	}
+	return h, nil
+}
+
+// loadHistoryPartition reads the partition of month from the store. It
+// reports false when the partition is missing, unreadable or due for a
+// refresh, in which case the month is fetched from USGS instead.
+func loadHistoryPartition(ctx context.Context, bucket, site, parameter string, month, now time.Time) ([]HistoryPoint, bool) {
+	points, fetched, err := readHistoryPartition(ctx, bucket, site, parameter, month)
+	if err != nil {
+		if !errors.Is(err, ErrBlobNotFound) {
+			log.Printf("history: %v", err)
+		}
+		return nil, false
+	}
+	// Daily values of a month's last day are published the day after.
+	final := !fetched.Before(month.AddDate(0, 1, 1))
+	if !final && now.Sub(fetched) > historyRefreshAfter() {
+		return nil, false
+	}
+	return points, true
+}
+
+// readHistoryPartition reads the stored partition of month and when it was
+// fetched. Errors match ErrBlobNotFound when the month isn't stored.
+func readHistoryPartition(ctx context.Context, bucket, site, parameter string, month time.Time) ([]HistoryPoint, time.Time, error) {
+	key := Layout().HistoryPartitionKey(parameter, site, month)
+	blob, err := getBlobStore().Get(ctx, bucket, key)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("reading %s: %w", key, err)
+	}
+	fetchedSec, _ := strconv.ParseInt(blob.Metadata[MetaFetchedOn], 10, 64)
+	points, err := parseHistoryPartition(blob.Data)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %w", key, err)
+	}
+	return points, time.Unix(fetchedSec, 0).UTC(), nil
+}
+
+// parseHistoryPartition reads the day and value of each processed row.
+func parseHistoryPartition(data []byte) ([]HistoryPoint, error) {
+	var out []HistoryPoint
+	for line := range strings.SplitSeq(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, ",", 3)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("malformed row %q", line)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed value in row %q", line)
+		}
+		unix, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed timestamp in row %q", line)
+		}
+		out = append(out, HistoryPoint{Day: time.Unix(unix, 0).UTC().Format(time.DateOnly), Value: value})
+	}
+	return out, nil
+}
+
+// fetchHistory fetches the consecutive months of parts from USGS in one
+// request, up to end, fills in their points and, with a bucket, stores them.
+// Months without data are stored empty so they aren't fetched again.
+func fetchHistory(ctx context.Context, bucket, site, parameter string, parts []historyPartition, end, now time.Time) error {
+	from := parts[0].month
+	to := parts[len(parts)-1].month.AddDate(0, 1, -1)
+	if to.After(end) {
+		to = end
+	}
+	raw, err := getDailyValues(ctx, site, parameter, from, to)
+	if err != nil {
+		return err
+	}
+	var usgs USGSJSON
+	if err := json.Unmarshal(raw, &usgs); err != nil {
+		return fmt.Errorf("failed to parse USGS JSON: %w", err)
+	}
+	var lat, lon float64
+	for _, ts := range usgs.Value.TimeSeries {
+		if len(ts.SourceInfo.SiteCode) > 0 && ts.SourceInfo.SiteCode[0].Value == site {
+			lat, lon = ts.SourceInfo.GeoLocation.GeogLocation.Latitude, ts.SourceInfo.GeoLocation.GeogLocation.Longitude
+			break
+		}
+	}
+	byMonth := map[string][]HistoryPoint{}
+	for day, mean := range dailyMeans(&usgs)[site] {
+		byMonth[day[:7]] = append(byMonth[day[:7]], HistoryPoint{Day: day, Value: mean})
+	}
+
+	source := DataSourceDaily
+	if WaterDataProvider() == ProviderSynthetic {
+		source = DataSourceSynthetic
+	}
+	meta := map[string]string{
+		MetaSites:         site,
+		MetaSchemaVersion: ProcessedSchemaVersion,
+		MetaDataSource:    source,
+		MetaFetchedOn:     strconv.FormatInt(now.Unix(), 10),
+	}
+	for i := range parts {
+		p := &parts[i]
+		p.points = byMonth[p.month.Format("2006-01")]
+		sort.Slice(p.points, func(a, b int) bool { return p.points[a].Day < p.points[b].Day })
+		if bucket == "" {
+			continue
+		}
+		var buf []byte
+		for _, pt := range p.points {
+			day, _ := time.Parse(time.DateOnly, pt.Day)
+			buf = appendProcessedRow(buf, pt.Value, day.Unix(), lat, lon, 0)
+		}
+		// Storing is best-effort: the request is answered either way.
+		key := Layout().HistoryPartitionKey(parameter, site, p.month)
+		if err := SaveObject(ctx, buf, bucket, key, ObjectOptions(ctx, key, PurposeProcessed, meta)); err != nil {
+			log.Printf("history: storing %s failed: %v", key, err)
+		}
+	}
+	return nil
+}
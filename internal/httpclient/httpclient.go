@@ -0,0 +1,550 @@
+// Package httpclient is the shared client for outbound HTTP calls (USGS,
+// NWS, Foxit, Vonage, Twilio, captcha verification, OIDC discovery and the
+// SageMaker API). Every Client shares one transport, so connections to an
+// upstream are pooled across callers, and adds:
+//
+//   - retries with jittered exponential backoff on network errors and 429,
+//     500, 502, 503 and 504 responses (honoring Retry-After), for idempotent
+//     methods, or any method when Options.RetryPOST is set;
+//   - a per-host token-bucket rate limit shared by all clients;
+//   - per-upstream request, retry, error and latency counters (Snapshot);
+//   - an optional per-upstream circuit breaker (Options.Breaker,
+//     BreakerSnapshot);
+//   - context propagation: waits for backoff or rate limits end when the
+//     request's context is done;
+//   - an X-Ray subsegment per request in traced lambdas (see tracing).
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"aquawatch/internal/tracing"
+)
+
+// Options configures a Client. Zero values take the defaults noted.
+type Options struct {
+	// Name identifies the upstream in logs and stats, e.g. "usgs".
+	Name string
+	// Timeout bounds each attempt, including reading the body (default 10s).
+	Timeout time.Duration
+	// MaxAttempts is the most tries per request (default 3; 1 disables
+	// retries).
+	MaxAttempts int
+	// BaseBackoff is the wait before the first retry, doubled per attempt
+	// (default 200ms) up to MaxBackoff (default 5s).
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// RetryPOST also retries non-idempotent methods. Only set it for APIs
+	// where repeating a request is safe; the body must be replayable
+	// (http.NewRequest sets GetBody for bytes and strings readers).
+	RetryPOST bool
+	// RatePerSecond limits requests per host (0 is unlimited); Burst is the
+	// bucket size (default 1). The first client to limit a host sets its
+	// rate.
+	RatePerSecond float64
+	Burst         int
+	// UserAgent is set on requests that don't carry one.
+	UserAgent string
+	// Breaker fails requests fast with ErrCircuitOpen after
+	// HTTP_BREAKER_FAILURES (default 5) requests in a row failed, until
+	// HTTP_BREAKER_COOLDOWN_SECONDS (default 30) have passed; then one trial
+	// request decides whether it closes again. Only set it for clients that
+	// talk to one upstream.
+	Breaker bool
+}
+
+// Client sends requests with the policies of its Options. It is safe for
+// concurrent use.
+type Client struct {
+	opts    Options
+	hc      *http.Client
+	breaker *breaker
+}
+
+// sharedTransport pools connections for every Client.
+var sharedTransport = newTransport()
+
+// newTransport tunes the default transport for sweeps that make many calls
+// to a few hosts: Go keeps only 2 idle connections per host by default, so
+// bursts to waterservices.usgs.gov or api.weather.gov would otherwise close
+// connections and pay a new TLS handshake per request. HTTP_MAX_IDLE_CONNS_PER_HOST
+// (default 32) and HTTP_IDLE_CONN_TIMEOUT_SECONDS (default 90) override the
+// pool size and how long idle connections are kept. HTTP/2 is negotiated
+// where the server supports it, multiplexing requests over one connection.
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConnsPerHost = envInt("HTTP_MAX_IDLE_CONNS_PER_HOST", 32)
+	t.MaxIdleConns = max(t.MaxIdleConns, 4*t.MaxIdleConnsPerHost)
+	t.IdleConnTimeout = time.Duration(envInt("HTTP_IDLE_CONN_TIMEOUT_SECONDS", 90)) * time.Second
+	t.TLSHandshakeTimeout = 10 * time.Second
+	return t
+}
+
+// envInt reads a positive integer from envVar, falling back to def.
+func envInt(envVar string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(envVar)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+// New returns a Client for opts.
+func New(opts Options) *Client {
+	if opts.Name == "" {
+		opts.Name = "http"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = 200 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 5 * time.Second
+	}
+	if opts.Burst <= 0 {
+		opts.Burst = 1
+	}
+	c := &Client{opts: opts, hc: &http.Client{Timeout: opts.Timeout, Transport: sharedTransport}}
+	if opts.Breaker {
+		c.breaker = breakerFor(opts.Name)
+	}
+	return c
+}
+
+// Get sends a GET request for url.
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends req, retrying per the client's policy. Like http.Client.Do, a
+// non-2xx response is not an error; the response of the last attempt is
+// returned.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx, seg := tracing.StartRemote(req.Context(), req.URL.Host)
+	// Queries can carry API keys, so traces only get the path.
+	traceURL := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+	seg.SetHTTP(req.Method, traceURL)
+	seg.AddMetadata("upstream", c.opts.Name)
+	resp, err := c.do(ctx, req.WithContext(ctx))
+	if resp != nil {
+		seg.SetStatus(resp.StatusCode)
+	}
+	traceErr := err
+	if ue, ok := err.(*url.Error); ok {
+		traceErr = &url.Error{Op: ue.Op, URL: traceURL, Err: ue.Err}
+	}
+	seg.End(traceErr)
+	return resp, err
+}
+
+func (c *Client) do(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+	if b := c.breaker; b != nil {
+		if !b.allow(time.Now()) {
+			return nil, fmt.Errorf("%s %s: %w", c.opts.Name, req.URL.Host, ErrCircuitOpen)
+		}
+		defer func() { b.done(ctx, resp, err) }()
+	}
+	if c.opts.UserAgent != "" && req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", c.opts.UserAgent)
+	}
+	retryable := c.opts.RetryPOST || idempotent(req.Method)
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		retryable = false
+	}
+	attempts := 1
+	if retryable {
+		attempts = c.opts.MaxAttempts
+	}
+
+	start := time.Now()
+	st := statsFor(c.opts.Name)
+	st.requests.Add(1)
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		if err := waitForHost(ctx, req.URL.Host, c.opts.RatePerSecond, c.opts.Burst); err != nil {
+			st.record(start, err)
+			return nil, err
+		}
+		st.attempts.Add(1)
+		resp, err := c.hc.Do(req.WithContext(httptrace.WithClientTrace(ctx, st.trace)))
+		if attempt >= attempts || !shouldRetry(ctx, resp, err) {
+			if err == nil && resp.StatusCode >= 500 {
+				st.record(start, fmt.Errorf("status %d", resp.StatusCode))
+			} else {
+				st.record(start, err)
+			}
+			return resp, err
+		}
+
+		wait := c.backoff(attempt)
+		if resp != nil {
+			if ra := retryAfter(resp); ra > 0 {
+				wait = min(ra, c.opts.MaxBackoff)
+			}
+			log.Printf("%s %s %s: status %d, retrying in %s (attempt %d/%d)", c.opts.Name, req.Method, req.URL.Host, resp.StatusCode, wait, attempt, attempts)
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		} else {
+			log.Printf("%s %s %s: %v, retrying in %s (attempt %d/%d)", c.opts.Name, req.Method, req.URL.Host, err, wait, attempt, attempts)
+		}
+		st.retries.Add(1)
+		if err := sleep(ctx, wait); err != nil {
+			st.record(start, err)
+			return nil, err
+		}
+	}
+}
+
+// backoff returns the jittered wait before retry number attempt (1-based):
+// a random duration up to BaseBackoff*2^(attempt-1), capped at MaxBackoff.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.opts.BaseBackoff << (attempt - 1)
+	if d <= 0 || d > c.opts.MaxBackoff {
+		d = c.opts.MaxBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// shouldRetry reports whether an attempt's outcome is transient. Nothing is
+// retried once ctx is done.
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// -------------------- Per-host rate limiting --------------------
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+var (
+	bucketsMu sync.Mutex
+	buckets   = map[string]*tokenBucket{}
+)
+
+// waitForHost blocks until host's bucket allows another request. Hosts
+// without a rate are not limited.
+func waitForHost(ctx context.Context, host string, rate float64, burst int) error {
+	if rate <= 0 {
+		return nil
+	}
+	bucketsMu.Lock()
+	b, ok := buckets[host]
+	if !ok {
+		b = &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+		buckets[host] = b
+	}
+	bucketsMu.Unlock()
+	return sleep(ctx, b.reserve(time.Now()))
+}
+
+// -------------------- Stats --------------------
+
+type counters struct {
+	requests, attempts, retries, errors atomic.Int64
+	latencyMs                           atomic.Int64
+	connsNew, connsReused               atomic.Int64
+	trace                               *httptrace.ClientTrace
+}
+
+func newCounters() *counters {
+	c := &counters{}
+	c.trace = &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				c.connsReused.Add(1)
+			} else {
+				c.connsNew.Add(1)
+			}
+		},
+	}
+	return c
+}
+
+func (c *counters) record(start time.Time, err error) {
+	c.latencyMs.Add(time.Since(start).Milliseconds())
+	if err != nil {
+		c.errors.Add(1)
+	}
+}
+
+var (
+	statsMu sync.Mutex
+	stats   = map[string]*counters{}
+)
+
+func statsFor(name string) *counters {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	c, ok := stats[name]
+	if !ok {
+		c = newCounters()
+		stats[name] = c
+	}
+	return c
+}
+
+// Stats are the counters of one upstream since the process started.
+// Errors counts requests that failed outright or ended with a 5xx response;
+// AvgLatencyMs includes retries and waits. ConnsNew and ConnsReused count
+// the attempts that opened a connection and those that reused a pooled one.
+type Stats struct {
+	Name         string  `json:"name"`
+	Requests     int64   `json:"requests"`
+	Attempts     int64   `json:"attempts"`
+	Retries      int64   `json:"retries"`
+	Errors       int64   `json:"errors"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	ConnsNew     int64   `json:"conns_new"`
+	ConnsReused  int64   `json:"conns_reused"`
+}
+
+// Snapshot returns the stats of every upstream used so far, by name.
+func Snapshot() []Stats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	out := make([]Stats, 0, len(stats))
+	for name, c := range stats {
+		s := Stats{
+			Name:        name,
+			Requests:    c.requests.Load(),
+			Attempts:    c.attempts.Load(),
+			Retries:     c.retries.Load(),
+			Errors:      c.errors.Load(),
+			ConnsNew:    c.connsNew.Load(),
+			ConnsReused: c.connsReused.Load(),
+		}
+		if s.Requests > 0 {
+			s.AvgLatencyMs = float64(c.latencyMs.Load()) / float64(s.Requests)
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// -------------------- Circuit breakers --------------------
+
+// ErrCircuitOpen is returned without sending the request while the
+// upstream's circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+type breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int // consecutive
+	openedAt time.Time
+	// trial is set while the half-open trial request is in flight.
+	trial    bool
+	trips    int64
+	rejected int64
+}
+
+// allow reports whether a request may be sent now.
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			b.rejected++
+			return false
+		}
+		b.state, b.trial = BreakerHalfOpen, true
+	case BreakerHalfOpen:
+		if b.trial {
+			b.rejected++
+			return false
+		}
+		b.trial = true
+	}
+	return true
+}
+
+// done records the outcome of an allowed request: network errors and 5xx
+// responses are failures. Requests whose context ended count neither way.
+func (b *breaker) done(ctx context.Context, resp *http.Response, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if ctx.Err() != nil {
+		return
+	}
+	if err == nil && resp.StatusCode < 500 {
+		b.state, b.failures = BreakerClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		log.Printf("%s: circuit breaker open after %d failures in a row", b.name, b.failures)
+		b.state, b.openedAt = BreakerOpen, time.Now()
+		b.trips++
+	}
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*breaker{}
+)
+
+// breakerFor returns the breaker of upstream name, shared by its clients.
+func breakerFor(name string) *breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[name]
+	if !ok {
+		b = &breaker{
+			name:      name,
+			threshold: envInt("HTTP_BREAKER_FAILURES", 5),
+			cooldown:  time.Duration(envInt("HTTP_BREAKER_COOLDOWN_SECONDS", 30)) * time.Second,
+			state:     BreakerClosed,
+		}
+		breakers[name] = b
+	}
+	return b
+}
+
+// BreakerStats describe an upstream's circuit breaker. Failures counts the
+// failed requests in a row; Trips how often it opened and Rejected the
+// requests failed fast since the process started.
+type BreakerStats struct {
+	Name            string  `json:"name"`
+	State           string  `json:"state"`
+	Failures        int     `json:"failures"`
+	Threshold       int     `json:"threshold"`
+	CooldownSeconds float64 `json:"cooldown_seconds"`
+	OpenedOnMs      int64   `json:"openedon_ms,omitempty"`
+	Trips           int64   `json:"trips"`
+	Rejected        int64   `json:"rejected"`
+}
+
+// BreakerSnapshot returns the state of every circuit breaker, by name.
+func BreakerSnapshot() []BreakerStats {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	out := make([]BreakerStats, 0, len(breakers))
+	for _, b := range breakers {
+		b.mu.Lock()
+		s := BreakerStats{
+			Name:            b.name,
+			State:           b.state,
+			Failures:        b.failures,
+			Threshold:       b.threshold,
+			CooldownSeconds: b.cooldown.Seconds(),
+			Trips:           b.trips,
+			Rejected:        b.rejected,
+		}
+		if b.state != BreakerClosed {
+			s.OpenedOnMs = b.openedAt.UnixMilli()
+		}
+		b.mu.Unlock()
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"aquawatch/internal/cache"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Impact statements translate a reading into what happens on the ground
+// ("at 18 ft, Route 9 floods"). Each site has a list of thresholds per
+// parameter, usually gage height (00065); alerts and reports quote the
+// highest threshold the observed or predicted value reaches.
+
+const (
+	impactIDPrefix        = "imp_"
+	maxImpactsPerSite     = 50
+	maxImpactStatementLen = 300
+	// DefaultImpactParameter is gage height, the usual basis of impacts.
+	DefaultImpactParameter = "00065"
+)
+
+// ErrImpactNotFound is returned when an impact ID has no record.
+var ErrImpactNotFound = notFoundError("impact not found")
+
+// ErrInvalidImpact is returned for impact specs that fail validation.
+var ErrInvalidImpact = validationError("invalid impact")
+
+// SiteImpact is one threshold and what happens once a reading reaches it.
+// Table name defaults to "site-impacts"; override with SITE_IMPACTS_TABLE.
+// Keys: PK site (String), SK impact_id (String).
+type SiteImpact struct {
+	Site      string  `dynamodbav:"site" json:"site"`
+	ImpactID  string  `dynamodbav:"impact_id" json:"impact_id"`
+	Parameter string  `dynamodbav:"parameter" json:"parameter"`
+	Threshold float64 `dynamodbav:"threshold" json:"threshold"`
+	Unit      string  `dynamodbav:"unit" json:"unit"`
+	Statement string  `dynamodbav:"statement" json:"statement"`
+	CreatedBy string  `dynamodbav:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedOn int64   `dynamodbav:"createdon" json:"createdon_ms"`
+}
+
+// ImpactSpec is the user-editable part of an impact. Parameter defaults to
+// 00065 and Unit to "ft".
+type ImpactSpec struct {
+	Parameter string  `json:"parameter"`
+	Threshold float64 `json:"threshold"`
+	Unit      string  `json:"unit"`
+	Statement string  `json:"statement"`
+}
+
+func (s *ImpactSpec) normalize() error {
+	if s.Parameter == "" {
+		s.Parameter = DefaultImpactParameter
+	}
+	if !parameterCodePattern.MatchString(s.Parameter) {
+		return fmt.Errorf("%w: parameter must be a 5-digit USGS parameter code", ErrInvalidImpact)
+	}
+	s.Unit = strings.TrimSpace(s.Unit)
+	if s.Unit == "" {
+		s.Unit = "ft"
+	}
+	if len(s.Unit) > 16 {
+		return fmt.Errorf("%w: unit is at most 16 characters", ErrInvalidImpact)
+	}
+	s.Statement = strings.TrimSpace(s.Statement)
+	if s.Statement == "" || len(s.Statement) > maxImpactStatementLen {
+		return fmt.Errorf("%w: statement is required (at most %d characters)", ErrInvalidImpact, maxImpactStatementLen)
+	}
+	return nil
+}
+
+func siteImpactsTable() string {
+	return tableName("SITE_IMPACTS_TABLE", "site-impacts")
+}
+
+// siteImpactsCache holds each site's impacts; alerts look them up for every
+// anomalous site, and they change rarely. Changes made in this process
+// invalidate the site's entry.
+var siteImpactsCache = cache.New[string, []SiteImpact]("site-impacts", 1000, 10*time.Minute)
+
+// ListSiteImpacts returns the site's impacts ordered by parameter and
+// threshold.
+func ListSiteImpacts(ctx context.Context, site string) ([]SiteImpact, error) {
+	return siteImpactsCache.GetOrLoad(ctx, site, func(ctx context.Context) ([]SiteImpact, error) {
+		values, err := attributevalue.MarshalMap(map[string]any{":site": site})
+		if err != nil {
+			return nil, err
+		}
+		repo := newRepository[SiteImpact](siteImpactsTable())
+		impacts := []SiteImpact{}
+		var cursor string
+		for {
+			page, next, err := repo.Query(ctx, &dynamodb.QueryInput{
+				KeyConditionExpression:    awsString("site = :site"),
+				ExpressionAttributeValues: values,
+			}, cursor)
+			if err != nil {
+				return nil, err
+			}
+			impacts = append(impacts, page...)
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		slices.SortFunc(impacts, func(a, b SiteImpact) int {
+			return cmp.Or(strings.Compare(a.Parameter, b.Parameter), cmp.Compare(a.Threshold, b.Threshold))
+		})
+		return impacts, nil
+	})
+}
+
+// CreateSiteImpact adds an impact to site.
+func CreateSiteImpact(ctx context.Context, site string, spec ImpactSpec, actor string) (*SiteImpact, error) {
+	if !siteIDPattern.MatchString(site) {
+		return nil, fmt.Errorf("%w: invalid site id %q", ErrInvalidImpact, site)
+	}
+	if err := spec.normalize(); err != nil {
+		return nil, err
+	}
+	existing, err := ListSiteImpacts(ctx, site)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= maxImpactsPerSite {
+		return nil, fmt.Errorf("%w: at most %d impacts per site", ErrInvalidImpact, maxImpactsPerSite)
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+	impact := &SiteImpact{
+		Site:      site,
+		ImpactID:  impactIDPrefix + id,
+		Parameter: spec.Parameter,
+		Threshold: spec.Threshold,
+		Unit:      spec.Unit,
+		Statement: spec.Statement,
+		CreatedBy: actor,
+		CreatedOn: time.Now().UTC().UnixMilli(),
+	}
+	if err := newRepository[SiteImpact](siteImpactsTable()).Put(ctx, impact); err != nil {
+		return nil, err
+	}
+	siteImpactsCache.Delete(site)
+	return impact, nil
+}
+
+// DeleteSiteImpact removes one of site's impacts.
+func DeleteSiteImpact(ctx context.Context, site, impactID string) error {
+	key, err := attributevalue.MarshalMap(map[string]string{"site": site, "impact_id": impactID})
+	if err != nil {
+		return err
+	}
+	out, err := getDynamoClient().DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:    awsString(siteImpactsTable()),
+		Key:          key,
+		ReturnValues: "ALL_OLD",
+	})
+	if err != nil {
+		return err
+	}
+	siteImpactsCache.Delete(site)
+	if len(out.Attributes) == 0 {
+		return ErrImpactNotFound
+	}
+	return nil
+}
+
+// ReachedImpact returns the impact with the highest threshold of parameter
+// that value reaches at site, or nil when it reaches none.
+func ReachedImpact(ctx context.Context, site, parameter string, value float64) (*SiteImpact, error) {
+	impacts, err := ListSiteImpacts(ctx, site)
+	if err != nil {
+		return nil, err
+	}
+	var reached *SiteImpact
+	for _, im := range impacts {
+		if im.Parameter == parameter && value >= im.Threshold && (reached == nil || im.Threshold > reached.Threshold) {
+			reached = &im
+		}
+	}
+	return reached, nil
+}
+
+// Describe formats the impact for messages: "At 18 ft: Route 9 floods".
+func (im *SiteImpact) Describe() string {
+	return fmt.Sprintf("At %s %s: %s", formatThreshold(im.Threshold), im.Unit, im.Statement)
+}
+
+func formatThreshold(v float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", v), "0"), ".")
+}
+
+// impactStatement returns the description of the impact that the higher of
+// observed and predicted reaches, or "" when none is reached or the lookup
+// fails (logged; alerts go out without it).
+func impactStatement(ctx context.Context, site, parameter string, observed, predicted float64) string {
+	im, err := ReachedImpact(ctx, site, parameter, max(observed, predicted))
+	if err != nil {
+		log.Printf("impact lookup for %s failed: %v", site, err)
+		return ""
+	}
+	if im == nil {
+		return ""
+	}
+	return im.Describe()
+}
+
+// AddReportImpacts appends the reached impact to each item's reason, judged
+// on its predicted value for the item's parameter (default 00060).
+func AddReportImpacts(ctx context.Context, items []ReportItem) {
+	for i := range items {
+		it := &items[i]
+		parameter := cmp.Or(it.Parameter, "00060")
+		if s := impactStatement(ctx, strings.TrimSpace(it.Site), parameter, it.PredictedValue, it.PredictedValue); s != "" {
+			if it.Reason != "" {
+				it.Reason += ". "
+			}
+			it.Reason += s
+		}
+	}
+}
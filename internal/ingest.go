@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"aquawatch/internal/pipeline"
+
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	sfntypes "github.com/aws/aws-sdk-go-v2/service/sfn/types"
+)
+
+// ErrIngestNotConfigured is returned by StartIngest when STATE_MACHINE_ARN or
+// S3_BUCKET is unset.
+var ErrIngestNotConfigured = errors.New("ingest pipeline not configured")
+
+// maxExecutionNameAttempts bounds the suffixed names tried for one day's
+// reruns of the same request.
+const maxExecutionNameAttempts = 10
+
+// IngestRequest describes one pipeline run. IdempotencyToken is optional;
+// requests with the same token map to the same execution. DryRun runs only
+// the fetch and preprocessing, storing nothing (see DryRunIngest); Train is
+// ignored. Start and End (YYYY-MM-DD, see IngestWindow) replace the last 30
+// days with a window of days to fetch.
+type IngestRequest struct {
+	Sites            []string
+	Parameter        string
+	Train            bool
+	DryRun           bool
+	Start            string
+	End              string
+	IdempotencyToken string
+}
+
+// ErrInvalidIngestWindow is returned by IngestWindow for bad windows.
+var ErrInvalidIngestWindow = validationError("invalid ingest window")
+
+// IngestWindow resolves the start and end days (YYYY-MM-DD, either may be
+// empty) of an ingest request: end defaults to today and start to 30 days
+// before end. Both empty is the default window and stays empty. Windows end
+// by today and span at most maxBackfillDays.
+func IngestWindow(start, end string, now time.Time) (string, string, error) {
+	if start == "" && end == "" {
+		return "", "", nil
+	}
+	today := now.UTC().Truncate(24 * time.Hour)
+	to := today
+	if end != "" {
+		t, err := time.Parse(pipeline.DateLayout, end)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: end must be YYYY-MM-DD", ErrInvalidIngestWindow)
+		}
+		if t.After(today) {
+			return "", "", fmt.Errorf("%w: end is in the future", ErrInvalidIngestWindow)
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -30)
+	if start != "" {
+		t, err := time.Parse(pipeline.DateLayout, start)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: start must be YYYY-MM-DD", ErrInvalidIngestWindow)
+		}
+		from = t
+	}
+	if to.Before(from) {
+		return "", "", fmt.Errorf("%w: end is before start", ErrInvalidIngestWindow)
+	}
+	if days := int(to.Sub(from).Hours()/24) + 1; days > maxBackfillDays {
+		return "", "", fmt.Errorf("%w: at most %d days", ErrInvalidIngestWindow, maxBackfillDays)
+	}
+	return from.Format(pipeline.DateLayout), to.Format(pipeline.DateLayout), nil
+}
+
+// IngestExecution is the execution an ingest request maps to. Existing is
+// true when a duplicate request was matched to an earlier execution instead
+// of starting a new one.
+type IngestExecution struct {
+	ExecutionArn string
+	Name         string
+	Existing     bool
+}
+
+// ingestExecutionName derives the base execution name of req. With an
+// idempotency token the name is "idem-<hash(token)>"; otherwise it is
+// "ingest-<yyyymmdd>-<hash>" over the sorted sites, parameter, train flag
+// and window, so the same request on the same UTC day gets the same name. Dry
+// runs are named "dryrun-<yyyymmdd>-<hash>", apart from the runs they
+// preview.
+func ingestExecutionName(req IngestRequest, now time.Time) string {
+	if req.IdempotencyToken != "" {
+		sum := sha256.Sum256([]byte(req.IdempotencyToken))
+		return "idem-" + hex.EncodeToString(sum[:16])
+	}
+	sites := slices.Clone(req.Sites)
+	slices.Sort(sites)
+	key := strings.Join(sites, ",") + "|" + req.Parameter + "|" + strconv.FormatBool(req.Train)
+	if req.Start != "" {
+		// Only windowed requests add it, keeping the names of the others.
+		key += "|" + req.Start + "|" + req.End
+	}
+	sum := sha256.Sum256([]byte(key))
+	prefix := "ingest-"
+	if req.DryRun {
+		prefix = "dryrun-"
+	}
+	return prefix + now.UTC().Format("20060102") + "-" + hex.EncodeToString(sum[:8])
+}
+
+// ingestExecutionInput builds and validates the execution input of req.
+func ingestExecutionInput(req IngestRequest, now time.Time) (pipeline.ExecutionInput, error) {
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return pipeline.ExecutionInput{}, fmt.Errorf("%w: S3_BUCKET not set", ErrIngestNotConfigured)
+	}
+	layout := Layout()
+	processedKey := layout.ProcessedDatasetKey(now)
+	input := pipeline.ExecutionInput{
+		SchemaVersion:        pipeline.SchemaVersion,
+		Station:              req.Sites,
+		Parameter:            req.Parameter,
+		Bucket:               bucket,
+		ProcessedKey:         processedKey,
+		ManifestKey:          DatasetManifestKey(processedKey),
+		ModelOutputPath:      layout.ModelOutputURI(bucket),
+		DefaultModelArtifact: layout.DefaultModelArtifactURI(bucket, req.Parameter),
+		Train:                req.Train && !req.DryRun,
+		DryRun:               req.DryRun,
+		Start:                req.Start,
+		End:                  req.End,
+	}
+	return input, input.Validate()
+}
+
+// StartIngest validates req and starts a state machine execution for it,
+// named by ingestExecutionName. On a name collision the existing execution
+// is returned when the request carries an idempotency token or the earlier
+// run is still in progress (a duplicate call); a finished run is a
+// deliberate rerun and gets the next suffixed name ("-2", "-3", ...).
+//
+// New runs are recorded in the pipeline-runs table and each site is marked
+// started in the prediction tracker (best-effort; the infer lambda records
+// the outcome). Invalid requests return an error matching
+// pipeline.ErrInvalidInput.
+func StartIngest(ctx context.Context, req IngestRequest) (*IngestExecution, error) {
+	stateMachineArn := os.Getenv("STATE_MACHINE_ARN")
+	if stateMachineArn == "" {
+		return nil, fmt.Errorf("%w: STATE_MACHINE_ARN not set", ErrIngestNotConfigured)
+	}
+	now := time.Now().UTC()
+	input, err := ingestExecutionInput(req, now)
+	if err != nil {
+		return nil, err
+	}
+
+	base := ingestExecutionName(req, now)
+	for attempt := 1; attempt <= maxExecutionNameAttempts; attempt++ {
+		name := base
+		if attempt > 1 {
+			name = fmt.Sprintf("%s-%d", base, attempt)
+		}
+		execArn, err := StartStateMachine(ctx, stateMachineArn, name, input)
+		var exists *ExecutionExistsError
+		if errors.As(err, &exists) {
+			reuse, derr := reuseExistingExecution(ctx, exists.ExecutionArn, req.IdempotencyToken != "")
+			if derr != nil {
+				return nil, derr
+			}
+			if reuse {
+				return &IngestExecution{ExecutionArn: exists.ExecutionArn, Name: name, Existing: true}, nil
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := RecordPipelineRunStarted(ctx, execArn, req.Sites, req.Parameter, input.Train, req.DryRun); err != nil {
+			log.Printf("pipeline run record failed for %s: %v", execArn, err)
+		}
+		if req.DryRun {
+			// No inference follows to complete the sites' predictions.
+			return &IngestExecution{ExecutionArn: execArn, Name: name}, nil
+		}
+		for _, site := range req.Sites {
+			if err := AddPredictionTrackerStarted(ctx, site, execArn); err != nil {
+				log.Printf("prediction tracker start failed for %s: %v", site, err)
+			}
+		}
+		return &IngestExecution{ExecutionArn: execArn, Name: name}, nil
+	}
+	return nil, fmt.Errorf("%w: %s has %d runs today", ErrExecutionExists, base, maxExecutionNameAttempts)
+}
+
+// reuseExistingExecution reports whether a request colliding with
+// executionArn should be answered with that execution: always for
+// idempotent requests, otherwise only while it is still running.
+func reuseExistingExecution(ctx context.Context, executionArn string, idempotent bool) (bool, error) {
+	if idempotent {
+		return true, nil
+	}
+	desc, err := getSFNClient().DescribeExecution(ctx, &sfn.DescribeExecutionInput{ExecutionArn: &executionArn})
+	if err != nil {
+		return false, fmt.Errorf("describe existing execution: %w", err)
+	}
+	return desc.Status == sfntypes.ExecutionStatusRunning, nil
+}
@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Session tokens are HS256 JWTs signed with SESSION_SECRET. The issuer and
+// audience default to "aquawatch" and "aquawatch-api"; override them with
+// JWT_ISSUER and JWT_AUDIENCE when several deployments share a secret.
+
+// Token types carried in the typ claim, so a refresh token can never be used
+// as an access token and vice versa.
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+	TokenTypeScoped  = "scoped"
+)
+
+// ErrInvalidToken is returned for malformed, unsigned, expired or mis-scoped tokens.
+var ErrInvalidToken = errors.New("invalid token")
+
+// SessionClaims are the registered JWT claims used by session tokens plus the
+// token type. Sub is a user ID (usr_...) as resolved by UserForSubject;
+// tokens minted before users existed carry the bare phone number.
+type SessionClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+	ID        string `json:"jti"`
+	Type      string `json:"typ"`
+	// Sites and Endpoints scope a scoped token (see MintScopedToken).
+	Sites     []string `json:"sites,omitempty"`
+	Endpoints []string `json:"endpoints,omitempty"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+func jwtIssuer() string {
+	if v := os.Getenv("JWT_ISSUER"); v != "" {
+		return v
+	}
+	return "aquawatch"
+}
+
+func jwtAudience() string {
+	if v := os.Getenv("JWT_AUDIENCE"); v != "" {
+		return v
+	}
+	return "aquawatch-api"
+}
+
+func sessionSecret() ([]byte, error) {
+	secret := secretValue("SESSION_SECRET")
+	if secret == "" {
+		return nil, errors.New("SESSION_SECRET not configured")
+	}
+	return []byte(secret), nil
+}
+
+// newTokenID returns a random 128-bit hex identifier for the jti claim.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newSessionClaims fills in issuer, audience, iat/exp and a fresh jti.
+func newSessionClaims(subject, typ string, ttl time.Duration) (SessionClaims, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return SessionClaims{}, err
+	}
+	now := time.Now()
+	return SessionClaims{
+		Issuer:    jwtIssuer(),
+		Subject:   subject,
+		Audience:  jwtAudience(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		IssuedAt:  now.Unix(),
+		ID:        jti,
+		Type:      typ,
+	}, nil
+}
+
+// signJWT encodes claims as a compact HS256 JWT.
+func signJWT(claims SessionClaims) (string, error) {
+	secret, err := sessionSecret()
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signing + "." + jwtSignature(secret, signing), nil
+}
+
+func jwtSignature(secret []byte, signing string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(signing))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// parseJWT verifies the signature, issuer, audience, expiry and token type
+// of a compact HS256 JWT and returns its claims.
+func parseJWT(token, wantType string) (*SessionClaims, error) {
+	secret, err := sessionSecret()
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: header encoding", ErrInvalidToken)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(rawHeader, &header); err != nil || header.Alg != "HS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm", ErrInvalidToken)
+	}
+	expected := jwtSignature(secret, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, fmt.Errorf("%w: signature", ErrInvalidToken)
+	}
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: claims encoding", ErrInvalidToken)
+	}
+	var claims SessionClaims
+	if err := json.Unmarshal(rawClaims, &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims", ErrInvalidToken)
+	}
+	switch {
+	case claims.Issuer != jwtIssuer():
+		return nil, fmt.Errorf("%w: issuer", ErrInvalidToken)
+	case claims.Audience != jwtAudience():
+		return nil, fmt.Errorf("%w: audience", ErrInvalidToken)
+	case time.Now().Unix() >= claims.ExpiresAt:
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.Type != wantType:
+		return nil, fmt.Errorf("%w: token type %q", ErrInvalidToken, claims.Type)
+	}
+	return &claims, nil
+}
+
+// isJWT reports whether token has the three-segment compact JWT shape.
+// Legacy session tokens are a single base64url segment.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSessionSecret = "test-session-secret"
+
+// useSessionSecret sets SESSION_SECRET for the test, past the secret cache.
+func useSessionSecret(t *testing.T, secret string) {
+	t.Helper()
+	t.Setenv("SESSION_SECRET", secret)
+	secretCache.Delete("SESSION_SECRET")
+	t.Cleanup(func() { secretCache.Delete("SESSION_SECRET") })
+}
+
+// craftJWT encodes header and claims and signs them with HS256 under secret.
+func craftJWT(t *testing.T, header, claims any, secret string) string {
+	t.Helper()
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	return signing + "." + jwtSignature([]byte(secret), signing)
+}
+
+// legacyToken builds a pre-JWT base64(phone|exp|sig) session token.
+func legacyToken(phone string, exp time.Time, secret string) string {
+	payload := phone + "|" + strconv.FormatInt(exp.Unix(), 10)
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(payload))
+	sig := base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload + "|" + sig))
+}
+
+func TestSessionTokenRoundTrip(t *testing.T) {
+	useSessionSecret(t, testSessionSecret)
+	tok, err := MintSessionToken("usr_0123abcd", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := ValidateSessionToken(tok)
+	if err != nil || subject != "usr_0123abcd" {
+		t.Errorf("ValidateSessionToken = %q, %v; want usr_0123abcd", subject, err)
+	}
+}
+
+func TestValidateSessionTokenRejects(t *testing.T) {
+	useSessionSecret(t, testSessionSecret)
+	valid := func(typ string, ttl time.Duration) SessionClaims {
+		c, err := newSessionClaims("usr_0123abcd", typ, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	hs256 := jwtHeader{Alg: "HS256", Typ: "JWT"}
+	good := craftJWT(t, hs256, valid(TokenTypeAccess, time.Hour), testSessionSecret)
+	parts := strings.Split(good, ".")
+	otherIssuer := valid(TokenTypeAccess, time.Hour)
+	otherIssuer.Issuer = "someone-else"
+	otherAudience := valid(TokenTypeAccess, time.Hour)
+	otherAudience.Audience = "other-api"
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"alg none", base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "."},
+		{"alg HS512", craftJWT(t, jwtHeader{Alg: "HS512", Typ: "JWT"}, valid(TokenTypeAccess, time.Hour), testSessionSecret)},
+		{"alg RS256", craftJWT(t, jwtHeader{Alg: "RS256", Typ: "JWT"}, valid(TokenTypeAccess, time.Hour), testSessionSecret)},
+		{"other secret", craftJWT(t, hs256, valid(TokenTypeAccess, time.Hour), "not-the-secret")},
+		{"tampered claims", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"usr_admin"}`)) + "." + parts[2]},
+		{"truncated signature", parts[0] + "." + parts[1] + "." + parts[2][:len(parts[2])-2]},
+		{"empty signature", parts[0] + "." + parts[1] + "."},
+		{"expired", craftJWT(t, hs256, valid(TokenTypeAccess, -time.Second), testSessionSecret)},
+		{"refresh as access", craftJWT(t, hs256, valid(TokenTypeRefresh, time.Hour), testSessionSecret)},
+		{"scoped as access", craftJWT(t, hs256, valid(TokenTypeScoped, time.Hour), testSessionSecret)},
+		{"missing type", craftJWT(t, hs256, valid("", time.Hour), testSessionSecret)},
+		{"other issuer", craftJWT(t, hs256, otherIssuer, testSessionSecret)},
+		{"other audience", craftJWT(t, hs256, otherAudience, testSessionSecret)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if subject, err := ValidateSessionToken(tt.token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("ValidateSessionToken = %q, %v; want ErrInvalidToken", subject, err)
+			}
+		})
+	}
+}
+
+func TestParseJWTTokenTypes(t *testing.T) {
+	useSessionSecret(t, testSessionSecret)
+	for _, minted := range []string{TokenTypeAccess, TokenTypeRefresh, TokenTypeScoped} {
+		c, err := newSessionClaims("usr_0123abcd", minted, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tok, err := signJWT(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{TokenTypeAccess, TokenTypeRefresh, TokenTypeScoped} {
+			_, err := parseJWT(tok, want)
+			if ok := err == nil; ok != (minted == want) {
+				t.Errorf("%s token parsed as %s: err = %v", minted, want, err)
+			}
+		}
+	}
+}
+
+func TestLegacySessionTokens(t *testing.T) {
+	useSessionSecret(t, testSessionSecret)
+	tests := []struct {
+		name    string
+		legacy  string // SESSION_LEGACY_TOKENS
+		token   string
+		wantSub string // empty when the token must be rejected
+	}{
+		{"accepted by default", "", legacyToken("+15551234567", time.Now().Add(time.Hour), testSessionSecret), "+15551234567"},
+		{"accepted while enabled", "true", legacyToken("+15551234567", time.Now().Add(time.Hour), testSessionSecret), "+15551234567"},
+		{"rejected once disabled", "false", legacyToken("+15551234567", time.Now().Add(time.Hour), testSessionSecret), ""},
+		{"expired", "", legacyToken("+15551234567", time.Now().Add(-time.Minute), testSessionSecret), ""},
+		{"other secret", "", legacyToken("+15551234567", time.Now().Add(time.Hour), "not-the-secret"), ""},
+		{"malformed", "", base64.RawURLEncoding.EncodeToString([]byte("+15551234567|123")), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SESSION_LEGACY_TOKENS", tt.legacy)
+			subject, err := ValidateSessionToken(tt.token)
+			if tt.wantSub == "" {
+				if err == nil {
+					t.Errorf("ValidateSessionToken = %q, want an error", subject)
+				}
+				return
+			}
+			if err != nil || subject != tt.wantSub {
+				t.Errorf("ValidateSessionToken = %q, %v; want %q", subject, err, tt.wantSub)
+			}
+		})
+	}
+}
+
+func TestLegacyTokenTamperedPhone(t *testing.T) {
+	useSessionSecret(t, testSessionSecret)
+	raw, _ := base64.RawURLEncoding.DecodeString(legacyToken("+15551234567", time.Now().Add(time.Hour), testSessionSecret))
+	forged := base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(raw), "+15551234567", "+15557654321", 1)))
+	if subject, err := ValidateSessionToken(forged); err == nil {
+		t.Errorf("forged legacy token accepted for %q", subject)
+	}
+}
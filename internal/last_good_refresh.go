@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"aquawatch/internal/httpclient"
+)
+
+// Last good copies are otherwise only written by ingest runs, so a site
+// that hasn't been ingested lately has a stale copy (or none) when USGS goes
+// down. With LAST_GOOD_REFRESH_ENABLED the API server refreshes the copies
+// of every monitored site in the background, a few sites per round so USGS
+// sees a trickle rather than a burst.
+
+// LastGoodRefreshEnabled reports whether the API server keeps last good
+// copies refreshed (LAST_GOOD_REFRESH_ENABLED, default off).
+func LastGoodRefreshEnabled() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("LAST_GOOD_REFRESH_ENABLED"))) {
+	case "true", "1", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// LastGoodRefreshInterval is the time between refresh rounds
+// (LAST_GOOD_REFRESH_MINUTES, default 15).
+func LastGoodRefreshInterval() time.Duration {
+	return time.Duration(envInt("LAST_GOOD_REFRESH_MINUTES", 15)) * time.Minute
+}
+
+// lastGoodRefreshBatch is the most sites fetched per round
+// (LAST_GOOD_REFRESH_SITES, default 10).
+func lastGoodRefreshBatch() int {
+	return envInt("LAST_GOOD_REFRESH_SITES", 10)
+}
+
+// lastGoodRefreshDelay is the pause between two fetches of a round
+// (LAST_GOOD_REFRESH_DELAY_MS, default 2000).
+func lastGoodRefreshDelay() time.Duration {
+	return time.Duration(envInt("LAST_GOOD_REFRESH_DELAY_MS", 2000)) * time.Millisecond
+}
+
+// lastGoodTarget is a site whose copy of parameter is kept refreshed.
+type lastGoodTarget struct {
+	Site      string
+	Parameter string
+}
+
+// lastGoodTargets returns the monitored sites with the parameters their
+// copies are kept for: 00060 for every site, plus the parameter of each
+// enabled schedule listing it. Sorted, so rounds walk them in a stable order.
+func lastGoodTargets(ctx context.Context) ([]lastGoodTarget, error) {
+	sites, err := MonitoredStations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[lastGoodTarget]bool{}
+	for _, site := range sites {
+		seen[lastGoodTarget{site, "00060"}] = true
+	}
+	schedules, err := ListSchedules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, sch := range schedules {
+		if !sch.Enabled {
+			continue
+		}
+		for _, site := range sch.Sites {
+			if slices.Contains(sites, site) {
+				seen[lastGoodTarget{site, sch.Parameter}] = true
+			}
+		}
+	}
+	targets := make([]lastGoodTarget, 0, len(seen))
+	for t := range seen {
+		targets = append(targets, t)
+	}
+	slices.SortFunc(targets, func(a, b lastGoodTarget) int {
+		return strings.Compare(a.Site+"/"+a.Parameter, b.Site+"/"+b.Parameter)
+	})
+	return targets, nil
+}
+
+// RefreshLastGood refreshes the last good copies of the next batch of
+// targets after cursor, returning the cursor of the following round and the
+// copies written. The round stops at the first upstream failure: USGS is
+// down or throttling, which is when the existing copies are needed, and
+// they are left as they are. Payloads without values are not saved.
+func RefreshLastGood(ctx context.Context, cursor int) (int, int, error) {
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return cursor, 0, errors.New("S3_BUCKET not set")
+	}
+	targets, err := lastGoodTargets(ctx)
+	if err != nil {
+		return cursor, 0, err
+	}
+	if len(targets) == 0 {
+		return 0, 0, nil
+	}
+	saved := 0
+	batch := min(lastGoodRefreshBatch(), len(targets))
+	for i := range batch {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return cursor, saved, ctx.Err()
+			case <-time.After(lastGoodRefreshDelay()):
+			}
+		}
+		t := targets[cursor%len(targets)]
+		payloads, err := GetWaterDailyDataLast30DaysBatch(ctx, []string{t.Site}, t.Parameter)
+		if err != nil {
+			if kind := ErrorKind(err); kind == ErrUpstreamUnavailable || kind == ErrThrottled {
+				return cursor, saved, fmt.Errorf("%s/%s: %w", t.Site, t.Parameter, err)
+			}
+			// Not an outage (unknown site, no data): move on.
+			log.Printf("last good refresh of %s/%s skipped: %v", t.Site, t.Parameter, err)
+			cursor++
+			continue
+		}
+		cursor++
+		if latest, err := latestObservedBySite(payloads[0]); err != nil || len(latest) == 0 {
+			continue
+		}
+		if err := SaveLastGoodPayloads(ctx, bucket, []string{t.Site}, t.Parameter, payloads); err != nil {
+			return cursor, saved, err
+		}
+		saved++
+	}
+	return cursor % len(targets), saved, nil
+}
+
+// PollLastGood runs RefreshLastGood every interval until ctx is done,
+// skipping rounds while the USGS circuit breaker is open.
+func PollLastGood(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	cursor := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if usgsBreakerOpen() {
+			continue
+		}
+		var saved int
+		var err error
+		cursor, saved, err = RefreshLastGood(ctx, cursor)
+		if err != nil {
+			log.Printf("last good refresh failed after %d copies: %v", saved, err)
+			continue
+		}
+		if saved > 0 {
+			log.Printf("last good refresh: %d copies refreshed", saved)
+		}
+	}
+}
+
+// usgsBreakerOpen reports whether the USGS circuit breaker is open and
+// still cooling down, when every fetch would fail fast anyway. Past the
+// cooldown the round's first fetch is the breaker's trial request.
+func usgsBreakerOpen() bool {
+	for _, b := range httpclient.BreakerSnapshot() {
+		if b.Name == "usgs" && b.State == httpclient.BreakerOpen {
+			cooldown := time.Duration(b.CooldownSeconds * float64(time.Second))
+			return time.Since(time.UnixMilli(b.OpenedOnMs)) < cooldown
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// StorageLayout centralizes how object keys are built. Every key is
+// namespaced by Env (dev/stage/prod) when set, followed by a per-kind prefix:
+//
+//	[<env>/]<prefix>/<...>
+//
+// Deployments that use a bucket per environment can leave Env empty; shared
+// buckets set STORAGE_ENV so environments never overwrite each other.
+type StorageLayout struct {
+	Env       string
+	Raw       string
+	Processed string
+	Reports   string
+	Models    string
+	Archive   string
+	Analytics string
+	Uploads   string
+}
+
+// Layout returns the storage layout from the environment:
+// STORAGE_ENV (namespace, default the resource naming's namespace, usually
+// none) and S3_PREFIX_RAW, S3_PREFIX_PROCESSED,
+// S3_PREFIX_REPORTS, S3_PREFIX_MODELS, S3_PREFIX_ARCHIVE, S3_PREFIX_ANALYTICS,
+// S3_PREFIX_UPLOADS (defaults: raw, processed, reports, model, archive,
+// analytics, uploads).
+func Layout() StorageLayout {
+	return StorageLayout{
+		Env:       storageEnv(),
+		Raw:       prefixFromEnv("S3_PREFIX_RAW", "raw"),
+		Processed: prefixFromEnv("S3_PREFIX_PROCESSED", "processed"),
+		Reports:   prefixFromEnv("S3_PREFIX_REPORTS", "reports"),
+		Models:    prefixFromEnv("S3_PREFIX_MODELS", "model"),
+		Archive:   prefixFromEnv("S3_PREFIX_ARCHIVE", "archive"),
+		Analytics: prefixFromEnv("S3_PREFIX_ANALYTICS", "analytics"),
+		Uploads:   prefixFromEnv("S3_PREFIX_UPLOADS", "uploads"),
+	}
+}
+
+// storageEnv returns STORAGE_ENV, or the resource naming's namespace when
+// the variable is unset. Setting it empty opts a named stack out of the
+// namespace (e.g. with a bucket of its own).
+func storageEnv() string {
+	if v, ok := os.LookupEnv("STORAGE_ENV"); ok {
+		return cleanPrefix(v)
+	}
+	return cleanPrefix(Naming().Namespace())
+}
+
+func prefixFromEnv(envVar, def string) string {
+	if v := cleanPrefix(os.Getenv(envVar)); v != "" {
+		return v
+	}
+	return def
+}
+
+func cleanPrefix(p string) string {
+	return strings.Trim(strings.TrimSpace(p), "/")
+}
+
+// key joins the env namespace, a kind prefix and the remaining elements.
+func (l StorageLayout) key(prefix string, elem ...string) string {
+	parts := make([]string, 0, len(elem)+2)
+	if l.Env != "" {
+		parts = append(parts, l.Env)
+	}
+	parts = append(parts, prefix)
+	parts = append(parts, elem...)
+	return path.Join(parts...)
+}
+
+// RawKey is where a raw upstream payload fetched at t is stored.
+func (l StorageLayout) RawKey(t time.Time) string {
+	return l.key(l.Raw, fmt.Sprintf("%d.json", t.Unix()))
+}
+
+// LastGoodRawKey is where the last successfully fetched payload of a
+// station and parameter is kept for the last_good fallback policy.
+func (l StorageLayout) LastGoodRawKey(parameter, station string) string {
+	return l.key(l.Raw, "last-good", parameter, station+".json")
+}
+
+// ProcessedPrefix is the prefix holding all processed datasets (trailing slash).
+func (l StorageLayout) ProcessedPrefix() string {
+	return l.key(l.Processed) + "/"
+}
+
+// ProcessedDatasetKey names the processed dataset of an ingest run started at t.
+func (l StorageLayout) ProcessedDatasetKey(t time.Time) string {
+	return l.key(l.Processed, fmt.Sprintf("%d.csv", t.Unix()))
+}
+
+// BackfillDatasetKey names the processed dataset a backfill writes its
+// chunks to.
+func (l StorageLayout) BackfillDatasetKey(backfillID string) string {
+	return l.key(l.Processed, "backfill", backfillID+".csv")
+}
+
+// ExternalDatasetKey names the processed dataset external sensor readings
+// of parameter received on day (UTC) are appended to.
+func (l StorageLayout) ExternalDatasetKey(parameter string, day time.Time) string {
+	return l.key(l.Processed, "external", parameter, day.UTC().Format(time.DateOnly)+".csv")
+}
+
+// ClientDatasetKey names the processed dataset of a client upload
+// (POST /datasets).
+func (l StorageLayout) ClientDatasetKey(datasetID string) string {
+	return l.key(l.Processed, "client", datasetID+".csv")
+}
+
+// StationSnapshotKey names a single-station processed CSV written by the
+// anomaly check at t.
+func (l StorageLayout) StationSnapshotKey(station string, t time.Time) string {
+	return l.key(l.Processed, station, fmt.Sprintf("%d.csv", t.Unix()))
+}
+
+// ReportKey names a generated PDF report.
+func (l StorageLayout) ReportKey(t time.Time) string {
+	return l.key(l.Reports, fmt.Sprintf("%d.pdf", t.UnixNano()))
+}
+
+// ArchiveKey names the archive of a tracker table's items for a day.
+func (l StorageLayout) ArchiveKey(table string, day time.Time) string {
+	return l.key(l.Archive, table, day.Format("2006-01-02")+".jsonl")
+}
+
+// AnalyticsDatasetPrefix is the prefix of an analytics export dataset
+// (trailing slash); partitions live below it as dt=<day>/.
+func (l StorageLayout) AnalyticsDatasetPrefix(dataset string) string {
+	return l.key(l.Analytics, dataset) + "/"
+}
+
+// UploadImagePrefix is the prefix client image uploads are confined to
+// (trailing slash).
+func (l StorageLayout) UploadImagePrefix() string {
+	return l.key(l.Uploads, "images") + "/"
+}
+
+// UploadImageKey names a client image upload.
+func (l StorageLayout) UploadImageKey(t time.Time, ext string) string {
+	return l.key(l.Uploads, "images", fmt.Sprintf("%d%s", t.UnixNano(), ext))
+}
+
+// ModelOutputURI is the S3 URI training jobs write model artifacts under.
+func (l StorageLayout) ModelOutputURI(bucket string) string {
+	return fmt.Sprintf("s3://%s/%s", bucket, l.key(l.Models))
+}
+
+// defaultModelJob names the default model's directory under the models
+// prefix; parameters other than discharge add "-<code>".
+const defaultModelJob = "aquawatch-train-default"
+
+// isDefaultModelJob reports whether job is the default model of a parameter.
+func isDefaultModelJob(job string) bool {
+	return job == defaultModelJob || strings.HasPrefix(job, defaultModelJob+"-")
+}
+
+// DefaultModelArtifactURI is the artifact used when an ingest of parameter
+// skips training: aquawatch-train-default for discharge,
+// aquawatch-train-default-<code> for other parameters, so a stage ingest is
+// never scored by a discharge model.
+func (l StorageLayout) DefaultModelArtifactURI(bucket, parameter string) string {
+	job := defaultModelJob
+	if parameter != "" && parameter != ParameterDischarge {
+		job += "-" + parameter
+	}
+	return fmt.Sprintf("s3://%s/%s", bucket, l.key(l.Models, job, "output", "model.tar.gz"))
+}
+
+// HistoryPartitionKey names the partition of a station's daily values of
+// parameter for the month of day, kept by the /history read-through store.
+func (l StorageLayout) HistoryPartitionKey(parameter, station string, day time.Time) string {
+	return l.key(l.Processed, "history", parameter, station, day.UTC().Format("2006-01")+".csv")
+}
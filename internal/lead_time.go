@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lead times say how long until a site reaches its next impact threshold
+// ("~14h until 18 ft"). Anomaly checks of a site with a threshold above the
+// observed value also score hourly feature rows for the next
+// FORECAST_HORIZON_HOURS, in the same endpoint call, and the first hour the
+// forecast reaches the threshold (interpolated from the hour before) is the
+// estimated crossing. Sites without impacts, or already past their highest,
+// are forecast no further than before.
+
+const (
+	defaultForecastHorizonHours = 48
+	maxForecastHorizonHours     = 168
+)
+
+// FloodLeadTime is the estimated time until a site's forecast reaches an
+// impact threshold it is below now.
+type FloodLeadTime struct {
+	ImpactID  string  `dynamodbav:"impact_id" json:"impact_id"`
+	Threshold float64 `dynamodbav:"threshold" json:"threshold"`
+	Unit      string  `dynamodbav:"unit" json:"unit"`
+	Statement string  `dynamodbav:"statement" json:"statement"`
+	// Hours from the evaluation to the crossing, rounded to one decimal.
+	Hours      float64 `dynamodbav:"hours" json:"hours"`
+	CrossingOn int64   `dynamodbav:"crossingon" json:"crossing_on_ms"`
+}
+
+// Describe formats the lead time for messages: "~14h until 18 ft (Route 9
+// floods)".
+func (lt *FloodLeadTime) Describe() string {
+	when := "<1h"
+	if lt.Hours >= 1 {
+		when = fmt.Sprintf("~%dh", int(math.Round(lt.Hours)))
+	}
+	return fmt.Sprintf("%s until %s %s (%s)", when, formatThreshold(lt.Threshold), lt.Unit, lt.Statement)
+}
+
+// forecastHorizonHours is how many hours ahead anomaly checks forecast for
+// lead times (FORECAST_HORIZON_HOURS, default 48, at most 168).
+func forecastHorizonHours() int {
+	return min(envInt("FORECAST_HORIZON_HOURS", defaultForecastHorizonHours), maxForecastHorizonHours)
+}
+
+// nextImpact returns the impact with the lowest threshold of parameter above
+// value at site, or nil when there is none or the lookup fails (logged; the
+// check goes on without a lead time).
+func nextImpact(ctx context.Context, site, parameter string, value float64) *SiteImpact {
+	impacts, err := ListSiteImpacts(ctx, site)
+	if err != nil {
+		log.Printf("impact lookup for %s failed: %v", site, err)
+		return nil
+	}
+	var next *SiteImpact
+	for _, im := range impacts {
+		if im.Parameter == parameter && im.Threshold > value && (next == nil || im.Threshold < next.Threshold) {
+			next = &im
+		}
+	}
+	return next
+}
+
+// forecastRows returns one features-only row (timestamp,lat,lng,temp) per
+// hour after now up to hours, copying the location and temperature of the
+// last row of features.
+func forecastRows(features string, now time.Time, hours int) string {
+	features = strings.TrimRight(features, "\n")
+	last := features[strings.LastIndexByte(features, '\n')+1:]
+	cols := strings.SplitN(last, ",", 2)
+	if len(cols) < 2 {
+		return ""
+	}
+	suffix := "," + cols[1] + "\n"
+	var b strings.Builder
+	for h := 1; h <= hours; h++ {
+		b.WriteString(strconv.FormatInt(now.Add(time.Duration(h)*time.Hour).Unix(), 10))
+		b.WriteString(suffix)
+	}
+	return b.String()
+}
+
+// estimateLeadTime finds the first hour of forecast (hourly values after
+// now) reaching im's threshold, interpolating from observed at now. It
+// returns nil when the forecast stays below the threshold.
+func estimateLeadTime(im *SiteImpact, observed float64, forecast []float64, now time.Time) *FloodLeadTime {
+	prevHour, prev := 0.0, observed
+	for i, v := range forecast {
+		hour := float64(i + 1)
+		if v < im.Threshold {
+			prevHour, prev = hour, v
+			continue
+		}
+		crossing := hour
+		if v > prev {
+			crossing = prevHour + (im.Threshold-prev)/(v-prev)*(hour-prevHour)
+		}
+		return &FloodLeadTime{
+			ImpactID:   im.ImpactID,
+			Threshold:  im.Threshold,
+			Unit:       im.Unit,
+			Statement:  im.Statement,
+			Hours:      math.Round(crossing*10) / 10,
+			CrossingOn: now.Add(time.Duration(crossing * float64(time.Hour))).UnixMilli(),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	sestypes "github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// Email magic links are an alternative to SMS codes. A signed one-time link
+// (HS256 JWT, typ=magic_link, sub=email) is emailed through SES; the client
+// exchanges the token for the same session tokens /sms/verify returns.
+//
+// Config: SES_FROM_EMAIL (verified sender, required), MAGIC_LINK_URL (page
+// the link opens; the token is appended as ?token=...), and
+// MAGIC_LINK_TTL_MINUTES (default 15).
+
+// TokenTypeMagicLink is the typ claim of email login tokens.
+const TokenTypeMagicLink = "magic_link"
+
+// ErrInvalidEmail is returned for addresses that do not parse.
+var ErrInvalidEmail = validationError("invalid email address")
+
+var (
+	sesClientOnce sync.Once
+	sesClient     *sesv2.Client
+)
+
+func getSESClient() *sesv2.Client {
+	sesClientOnce.Do(func() {
+		sesClient = sesv2.NewFromConfig(regionalConfig("SES_REGION"))
+	})
+	return sesClient
+}
+
+// NormalizeEmail parses addr and returns the bare, lower-cased address.
+func NormalizeEmail(addr string) (string, error) {
+	parsed, err := mail.ParseAddress(strings.TrimSpace(addr))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidEmail, err)
+	}
+	return strings.ToLower(parsed.Address), nil
+}
+
+func magicLinkTTL() time.Duration {
+	return time.Duration(envInt("MAGIC_LINK_TTL_MINUTES", 15)) * time.Minute
+}
+
+// magicLinkURL appends token to MAGIC_LINK_URL.
+func magicLinkURL(token string) (string, error) {
+	base := os.Getenv("MAGIC_LINK_URL")
+	if base == "" {
+		return "", errors.New("MAGIC_LINK_URL not configured")
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("MAGIC_LINK_URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// SendMagicLink mints a one-time login token for email and emails the link.
+// email must already be normalized (see NormalizeEmail).
+func SendMagicLink(ctx context.Context, email string) error {
+	from := os.Getenv("SES_FROM_EMAIL")
+	if from == "" {
+		return errors.New("SES_FROM_EMAIL not configured")
+	}
+	ttl := magicLinkTTL()
+	token, err := mintSingleUseToken(ctx, email, TokenTypeMagicLink, ttl)
+	if err != nil {
+		return err
+	}
+	link, err := magicLinkURL(token)
+	if err != nil {
+		return err
+	}
+	minutes := int(ttl.Minutes())
+	text := fmt.Sprintf("Sign in to AquaWatch:\n\n%s\n\nThis link expires in %d minutes and can be used once. If you did not request it, ignore this email.\n", link, minutes)
+	html := fmt.Sprintf(`<p>Sign in to AquaWatch:</p><p><a href="%s">Sign in</a></p><p>This link expires in %d minutes and can be used once. If you did not request it, ignore this email.</p>`, link, minutes)
+	_, err = getSESClient().SendEmail(ctx, &sesv2.SendEmailInput{
+		FromEmailAddress: aws.String(from),
+		Destination:      &sestypes.Destination{ToAddresses: []string{email}},
+		Content: &sestypes.EmailContent{
+			Simple: &sestypes.Message{
+				Subject: &sestypes.Content{Data: aws.String("Your AquaWatch sign-in link")},
+				Body: &sestypes.Body{
+					Text: &sestypes.Content{Data: aws.String(text)},
+					Html: &sestypes.Content{Data: aws.String(html)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("ses send: %w", err)
+	}
+	return nil
+}
+
+// RedeemMagicLink validates a magic-link token and marks it used, returning
+// the email it was sent to. Replays fail with ErrRefreshTokenReused.
+func RedeemMagicLink(ctx context.Context, token string) (string, error) {
+	return redeemSingleUseToken(ctx, token, TokenTypeMagicLink)
+}
@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"aquawatch/internal/tracing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// Lambdas report per-step metrics in CloudWatch Embedded Metric Format: one
+// JSON log line per record, which CloudWatch Logs turns into metrics in the
+// METRICS_NAMESPACE namespace (default AquaWatch). Metrics are dimensioned by
+// Step, and by Step and Site when a record covers a single site. The
+// execution name and Lambda request ID are attached as properties, so Logs
+// Insights can tie a data point back to its run without adding
+// high-cardinality dimensions.
+
+// Metric units used by the pipeline (a subset of CloudWatch's).
+const (
+	UnitCount        = "Count"
+	UnitBytes        = "Bytes"
+	UnitMilliseconds = "Milliseconds"
+	UnitSeconds      = "Seconds"
+	UnitPercent      = "Percent"
+)
+
+// Metric names shared by every step.
+const (
+	MetricErrors   = "Errors"
+	MetricDuration = "Duration"
+)
+
+const defaultMetricsNamespace = "AquaWatch"
+
+var (
+	metricsMu  sync.Mutex
+	metricsOut io.Writer = os.Stdout
+)
+
+// StepMetrics collects the metrics of one step invocation. It is not safe
+// for concurrent use; give each goroutine its own.
+type StepMetrics struct {
+	step      string
+	execution string
+	site      string
+	started   time.Time
+	names     []string
+	values    map[string]float64
+	units     map[string]string
+	props     map[string]any
+}
+
+// NewStepMetrics starts collecting metrics for step (e.g. "preprocess") of
+// the execution named execution (empty outside a state machine run).
+func NewStepMetrics(ctx context.Context, step, execution string) *StepMetrics {
+	m := &StepMetrics{
+		step:      step,
+		execution: execution,
+		started:   time.Now(),
+		values:    map[string]float64{},
+		units:     map[string]string{},
+		props:     map[string]any{},
+	}
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		m.props["RequestId"] = lc.AwsRequestID
+	}
+	if lambdacontext.FunctionName != "" {
+		m.props["FunctionName"] = lambdacontext.FunctionName
+	}
+	if id := tracing.TraceID(ctx); id != "" {
+		m.props["TraceId"] = id
+	}
+	return m
+}
+
+// ForSites sets the sites the record covers. A single site becomes the Site
+// dimension; several are attached as a Sites property only, so per-site
+// metrics aren't double counted.
+func (m *StepMetrics) ForSites(sites ...string) *StepMetrics {
+	m.site = ""
+	delete(m.props, "Sites")
+	switch len(sites) {
+	case 0:
+	case 1:
+		m.site = sites[0]
+	default:
+		m.props["Sites"] = sites
+	}
+	return m
+}
+
+// Add accumulates v into the named metric.
+func (m *StepMetrics) Add(name, unit string, v float64) {
+	if _, ok := m.values[name]; !ok {
+		m.names = append(m.names, name)
+	}
+	m.values[name] += v
+	m.units[name] = unit
+}
+
+// Set records the named metric, replacing any earlier value.
+func (m *StepMetrics) Set(name, unit string, v float64) {
+	if _, ok := m.values[name]; !ok {
+		m.names = append(m.names, name)
+	}
+	m.values[name] = v
+	m.units[name] = unit
+}
+
+// Property attaches a non-metric field to the record.
+func (m *StepMetrics) Property(key string, v any) {
+	m.props[key] = v
+}
+
+// Finish records Errors (1 when err is non-nil, so its average is the error
+// rate) and Duration since NewStepMetrics, then writes the record.
+func (m *StepMetrics) Finish(err error) {
+	var failed float64
+	if err != nil {
+		failed = 1
+		m.props["Error"] = err.Error()
+	}
+	m.Set(MetricErrors, UnitCount, failed)
+	m.Set(MetricDuration, UnitMilliseconds, float64(time.Since(m.started).Milliseconds()))
+	m.Emit()
+}
+
+// Emit writes the record collected so far. Nothing is written when no
+// metric was recorded.
+func (m *StepMetrics) Emit() {
+	if len(m.names) == 0 {
+		return
+	}
+	dims := [][]string{{"Step"}}
+	rec := map[string]any{}
+	for k, v := range m.props {
+		rec[k] = v
+	}
+	rec["Step"] = m.step
+	if m.site != "" {
+		dims = append(dims, []string{"Step", "Site"})
+		rec["Site"] = m.site
+	}
+	if m.execution != "" {
+		rec["Execution"] = m.execution
+	}
+	defs := make([]map[string]string, 0, len(m.names))
+	for _, name := range m.names {
+		defs = append(defs, map[string]string{"Name": name, "Unit": m.units[name]})
+		rec[name] = m.values[name]
+	}
+	rec["_aws"] = map[string]any{
+		"Timestamp": time.Now().UnixMilli(),
+		"CloudWatchMetrics": []any{map[string]any{
+			"Namespace":  metricsNamespace(),
+			"Dimensions": dims,
+			"Metrics":    defs,
+		}},
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("encode %s metrics: %v", m.step, err)
+		return
+	}
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	// EMF records must be written as-is, without the log package's prefix.
+	metricsOut.Write(append(line, '\n'))
+}
+
+func metricsNamespace() string {
+	if ns := os.Getenv("METRICS_NAMESPACE"); ns != "" {
+		return ns
+	}
+	return defaultMetricsNamespace
+}
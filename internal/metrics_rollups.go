@@ -0,0 +1,361 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// The analytics page charts anomalies and alerts over months, which would
+// mean reading every evaluation and alert of the range. The metrics-rollup
+// lambda instead runs nightly and sums each UTC day per site into the
+// metrics-rollups table: one row per site with evaluations or alerts that
+// day, plus a row under site "*" for the whole deployment, always written so
+// a rolled-up day can be told from a missing one. Rolling a day up again
+// replaces its rows. GET /stats/anomalies reads only these rows.
+
+// rollupAllSites is the site of the deployment-wide rollup rows.
+const rollupAllSites = "*"
+
+const (
+	// defaultMetricsRollupTTLDays is how long rollups are kept.
+	defaultMetricsRollupTTLDays = 730
+	// MaxStatsDays bounds the range of one stats request.
+	MaxStatsDays = 366
+	// MaxStatsSites bounds the sites of one stats request.
+	MaxStatsSites = 50
+)
+
+// Stats intervals.
+const (
+	StatsIntervalDay   = "day"
+	StatsIntervalWeek  = "week"
+	StatsIntervalMonth = "month"
+)
+
+// ErrInvalidStatsRange is returned for unparseable or oversized stats
+// requests.
+var ErrInvalidStatsRange = validationError("invalid stats range")
+
+// RollupCounts are the anomaly and alert counts of a site, day or period.
+// Severities are keyed low, medium and high.
+type RollupCounts struct {
+	Evaluations         int            `dynamodbav:"evaluations" json:"evaluations"`
+	Anomalies           int            `dynamodbav:"anomalies" json:"anomalies"`
+	AnomaliesBySeverity map[string]int `dynamodbav:"anomalies_by_severity" json:"anomalies_by_severity"`
+	Alerts              int            `dynamodbav:"alerts" json:"alerts"`
+	AlertsBySeverity    map[string]int `dynamodbav:"alerts_by_severity" json:"alerts_by_severity"`
+}
+
+func newRollupCounts() RollupCounts {
+	return RollupCounts{AnomaliesBySeverity: map[string]int{}, AlertsBySeverity: map[string]int{}}
+}
+
+func (c *RollupCounts) add(o RollupCounts) {
+	c.Evaluations += o.Evaluations
+	c.Anomalies += o.Anomalies
+	c.Alerts += o.Alerts
+	for k, n := range o.AnomaliesBySeverity {
+		c.AnomaliesBySeverity[k] += n
+	}
+	for k, n := range o.AlertsBySeverity {
+		c.AlertsBySeverity[k] += n
+	}
+}
+
+// MetricsRollup is one site's counts of one UTC day.
+// Table name defaults to "metrics-rollups"; override with
+// METRICS_ROLLUPS_TABLE.
+// Keys: PK site ("*" for all sites), SK day (YYYY-MM-DD). Items expire after
+// METRICS_ROLLUP_TTL_DAYS (default 730).
+type MetricsRollup struct {
+	Site string `dynamodbav:"site" json:"site"`
+	Day  string `dynamodbav:"day" json:"day"`
+	RollupCounts
+	RolledUpOn int64 `dynamodbav:"rolledupon" json:"rolled_up_on_ms"`
+	ExpiresAt  int64 `dynamodbav:"expires_at,omitempty" json:"-"`
+}
+
+// MetricsRollupRetention returns the retention policy for the
+// metrics-rollups table.
+func MetricsRollupRetention() RetentionConfig {
+	return RetentionConfig{
+		Table: tableName("METRICS_ROLLUPS_TABLE", "metrics-rollups"),
+		TTL:   retentionFromEnv("METRICS_ROLLUP_TTL_DAYS", defaultMetricsRollupTTLDays),
+	}
+}
+
+// RollupResult summarizes one day's rollup.
+type RollupResult struct {
+	Day         string `json:"day"`
+	Sites       int    `json:"sites"`
+	Evaluations int    `json:"evaluations"`
+	Anomalies   int    `json:"anomalies"`
+	Alerts      int    `json:"alerts"`
+}
+
+// RollupMetrics sums the anomaly evaluations and alerts of the UTC day
+// (YYYY-MM-DD) per site and stores the rows, replacing an earlier rollup of
+// the day. An alert impacting several sites counts once for each site and
+// once for "*".
+func RollupMetrics(ctx context.Context, day string) (*RollupResult, error) {
+	start, err := time.Parse(time.DateOnly, day)
+	if err != nil {
+		return nil, fmt.Errorf("%w: day must be YYYY-MM-DD", ErrInvalidStatsRange)
+	}
+	from := start.UnixMilli()
+	to := start.AddDate(0, 0, 1).UnixMilli() - 1
+
+	all := newRollupCounts()
+	bySite := map[string]*RollupCounts{}
+	siteCounts := func(site string) *RollupCounts {
+		c := bySite[site]
+		if c == nil {
+			nc := newRollupCounts()
+			c = &nc
+			bySite[site] = c
+		}
+		return c
+	}
+
+	evals, err := dayEvaluations(ctx, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("read evaluations: %w", err)
+	}
+	for _, ev := range evals {
+		c := siteCounts(ev.Site)
+		c.Evaluations++
+		all.Evaluations++
+		if !ev.Anomalous {
+			continue
+		}
+		severity := ev.Rating()
+		c.Anomalies++
+		c.AnomaliesBySeverity[severity]++
+		all.Anomalies++
+		all.AnomaliesBySeverity[severity]++
+	}
+
+	alerts, truncated, err := queryAlertDay(ctx, day, from, to, maxDailyAlerts)
+	if err != nil {
+		return nil, fmt.Errorf("read alerts: %w", err)
+	}
+	if truncated {
+		log.Printf("metrics rollup %s: more than %d alerts, counting the first", day, maxDailyAlerts)
+	}
+	for _, a := range alerts {
+		severity := normalizedSeverity(a.Severity)
+		all.Alerts++
+		all.AlertsBySeverity[severity]++
+		for _, site := range a.SitesImpacted {
+			if site == "" {
+				continue
+			}
+			c := siteCounts(site)
+			c.Alerts++
+			c.AlertsBySeverity[severity]++
+		}
+	}
+
+	now := time.Now().UTC()
+	expiresAt := MetricsRollupRetention().ExpiresAt(now)
+	rows := []any{&MetricsRollup{Site: rollupAllSites, Day: day, RollupCounts: all, RolledUpOn: now.UnixMilli(), ExpiresAt: expiresAt}}
+	for site, c := range bySite {
+		rows = append(rows, &MetricsRollup{Site: site, Day: day, RollupCounts: *c, RolledUpOn: now.UnixMilli(), ExpiresAt: expiresAt})
+	}
+	if err := newRepository[MetricsRollup](MetricsRollupRetention().Table).BatchPut(ctx, rows); err != nil {
+		return nil, fmt.Errorf("store rollups: %w", err)
+	}
+	return &RollupResult{Day: day, Sites: len(bySite), Evaluations: all.Evaluations, Anomalies: all.Anomalies, Alerts: all.Alerts}, nil
+}
+
+// normalizedSeverity maps an alert severity to low, medium or high; unknown
+// ones count as low.
+func normalizedSeverity(severity string) string {
+	severity = strings.ToLower(severity)
+	if _, ok := alertSeverityRank[severity]; ok {
+		return severity
+	}
+	return "low"
+}
+
+// dayEvaluations scans the anomaly evaluations made between from and to
+// (epoch ms, inclusive). Evaluations are keyed by site, so a day across all
+// sites takes a filtered scan; the nightly rollup is its only reader.
+func dayEvaluations(ctx context.Context, from, to int64) ([]AnomalyEvaluation, error) {
+	table := anomalyEvaluationsTable()
+	values, err := attributevalue.MarshalMap(map[string]any{":from": from, ":to": to})
+	if err != nil {
+		return nil, err
+	}
+	paginator := dynamodb.NewScanPaginator(getDynamoClient(), &dynamodb.ScanInput{
+		TableName:                 &table,
+		FilterExpression:          awsString("#e BETWEEN :from AND :to"),
+		ProjectionExpression:      awsString("#s, #e, #a, #sev, #pc"),
+		ExpressionAttributeValues: values,
+		ExpressionAttributeNames: map[string]string{
+			"#s":   "site",
+			"#e":   "evaluatedon",
+			"#a":   "anomalous",
+			"#sev": "severity",
+			"#pc":  "percent_change",
+		},
+	})
+	var out []AnomalyEvaluation
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var items []AnomalyEvaluation
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+	}
+	return out, nil
+}
+
+// AnomalyStats are the rolled-up counts of a date range per period.
+type AnomalyStats struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Interval string `json:"interval"`
+	// Sites is the filter, empty for the whole deployment.
+	Sites  []string            `json:"sites,omitempty"`
+	Series []AnomalyStatsPoint `json:"series"`
+	Totals RollupCounts        `json:"totals"`
+	// DaysRolledUp counts the days of the range the rollup has covered;
+	// days not rolled up yet (such as today) count as zero.
+	DaysRolledUp int `json:"days_rolled_up"`
+}
+
+// AnomalyStatsPoint is one period of AnomalyStats: the day, the Monday
+// starting the week, or the month (YYYY-MM).
+type AnomalyStatsPoint struct {
+	Period string `json:"period"`
+	RollupCounts
+}
+
+// GetAnomalyStats returns the rollups from from to to (YYYY-MM-DD,
+// inclusive) summed per interval, for sites or the whole deployment. Every
+// period of the range is listed, with zeros where nothing was rolled up.
+// Errors match ErrInvalidStatsRange for bad input.
+func GetAnomalyStats(ctx context.Context, from, to string, sites []string, interval string) (*AnomalyStats, error) {
+	start, err := time.Parse(time.DateOnly, from)
+	if err != nil {
+		return nil, fmt.Errorf("%w: from must be YYYY-MM-DD", ErrInvalidStatsRange)
+	}
+	end, err := time.Parse(time.DateOnly, to)
+	if err != nil {
+		return nil, fmt.Errorf("%w: to must be YYYY-MM-DD", ErrInvalidStatsRange)
+	}
+	switch {
+	case end.Before(start):
+		return nil, fmt.Errorf("%w: from is after to", ErrInvalidStatsRange)
+	case end.Sub(start) >= MaxStatsDays*24*time.Hour:
+		return nil, fmt.Errorf("%w: at most %d days", ErrInvalidStatsRange, MaxStatsDays)
+	case len(sites) > MaxStatsSites:
+		return nil, fmt.Errorf("%w: at most %d sites", ErrInvalidStatsRange, MaxStatsSites)
+	}
+	if interval == "" {
+		interval = StatsIntervalDay
+	}
+	if interval != StatsIntervalDay && interval != StatsIntervalWeek && interval != StatsIntervalMonth {
+		return nil, fmt.Errorf("%w: interval must be day, week or month", ErrInvalidStatsRange)
+	}
+
+	out := &AnomalyStats{From: from, To: to, Interval: interval, Sites: sites, Totals: newRollupCounts()}
+	periods := map[string]*AnomalyStatsPoint{}
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		p := statsPeriod(d, interval)
+		if periods[p] == nil {
+			periods[p] = &AnomalyStatsPoint{Period: p, RollupCounts: newRollupCounts()}
+		}
+	}
+
+	keys := sites
+	if len(keys) == 0 {
+		keys = []string{rollupAllSites}
+	}
+	rolledUp := map[string]bool{}
+	for _, site := range keys {
+		rows, err := siteRollups(ctx, site, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			day, err := time.Parse(time.DateOnly, row.Day)
+			if err != nil {
+				continue
+			}
+			periods[statsPeriod(day, interval)].add(row.RollupCounts)
+			out.Totals.add(row.RollupCounts)
+			if site == rollupAllSites {
+				rolledUp[row.Day] = true
+			}
+		}
+	}
+	if len(sites) > 0 {
+		// Sites without activity have no row for a day; the deployment row
+		// says whether the day was rolled up.
+		rows, err := siteRollups(ctx, rollupAllSites, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			rolledUp[row.Day] = true
+		}
+	}
+	out.DaysRolledUp = len(rolledUp)
+
+	for _, p := range periods {
+		out.Series = append(out.Series, *p)
+	}
+	sort.Slice(out.Series, func(i, j int) bool { return out.Series[i].Period < out.Series[j].Period })
+	return out, nil
+}
+
+// statsPeriod names the period of interval that day falls in.
+func statsPeriod(day time.Time, interval string) string {
+	switch interval {
+	case StatsIntervalWeek:
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset).Format(time.DateOnly)
+	case StatsIntervalMonth:
+		return day.Format("2006-01")
+	}
+	return day.Format(time.DateOnly)
+}
+
+// siteRollups reads the rollup rows of site from from to to.
+func siteRollups(ctx context.Context, site, from, to string) ([]MetricsRollup, error) {
+	values, err := attributevalue.MarshalMap(map[string]any{":site": site, ":from": from, ":to": to})
+	if err != nil {
+		return nil, err
+	}
+	repo := newRepository[MetricsRollup](MetricsRollupRetention().Table)
+	var out []MetricsRollup
+	var cursor string
+	for {
+		items, next, err := repo.Query(ctx, &dynamodb.QueryInput{
+			KeyConditionExpression:    awsString("#s = :site AND #d BETWEEN :from AND :to"),
+			ExpressionAttributeNames:  map[string]string{"#s": "site", "#d": "day"},
+			ExpressionAttributeValues: values,
+		}, cursor)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+		if next == "" {
+			return out, nil
+		}
+		cursor = next
+	}
+}
@@ -1,28 +1,38 @@
 package internal
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sagemakerruntime"
 )
 
+var (
+	sagemakerRuntimeOnce   sync.Once
+	sagemakerRuntimeClient *sagemakerruntime.Client
+)
+
+// getSageMakerRuntimeClient returns a process-wide SageMaker Runtime client.
+func getSageMakerRuntimeClient() *sagemakerruntime.Client {
+	sagemakerRuntimeOnce.Do(func() {
+		sagemakerRuntimeClient = sagemakerruntime.NewFromConfig(regionalConfig("SAGEMAKER_REGION"))
+	})
+	return sagemakerRuntimeClient
+}
+
 // InvokeEndpoint calls a SageMaker endpoint with CSV payload bytes. If targetModel
 // is non-empty, it sets the TargetModel header (for multi-model endpoints).
 func InvokeEndpoint(ctx context.Context, endpointName string, inputData []byte, targetModel string) ([]byte, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS config: %w", err)
-	}
-
-	client := sagemakerruntime.NewFromConfig(cfg)
+	ctx, cancel := withStageTimeout(ctx, StageInfer)
+	defer cancel()
+	client := getSageMakerRuntimeClient()
 
-	log.Println("endpointName", endpointName)
-	log.Println("targetModel", targetModel)
-	log.Println("inputData", string(inputData))
+	log.Printf("invoking endpoint %s (target model %q)", endpointName, targetModel)
+	LogPayload("endpoint input", inputData)
 
 	in := &sagemakerruntime.InvokeEndpointInput{
 		EndpointName: &endpointName,
@@ -37,6 +47,7 @@ func InvokeEndpoint(ctx context.Context, endpointName string, inputData []byte,
 	if err != nil {
 		return nil, fmt.Errorf("invoke endpoint failed: %w", err)
 	}
+	RecordUsage(ctx, UsageSageMakerInvocation, int64(bytes.Count(inputData, []byte{'\n'})))
 
 	return resp.Body, nil
 }
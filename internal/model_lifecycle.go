@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Every training run leaves a model under the models prefix, which is also
+// the multi-model endpoint's model source. CleanupModels keeps the newest
+// MODEL_KEEP_PER_SITE (default 3) registered models of each site and
+// parameter, so a site's gage height models aren't pushed out by its
+// discharge ones, and removes the artifacts of older ones, marking their
+// registry entries archived.
+
+// defaultModelsKeptPerSite is the default of MODEL_KEEP_PER_SITE.
+const defaultModelsKeptPerSite = 3
+
+// ModelsKeptPerSite returns MODEL_KEEP_PER_SITE (minimum 1).
+func ModelsKeptPerSite() int {
+	return max(envInt("MODEL_KEEP_PER_SITE", defaultModelsKeptPerSite), 1)
+}
+
+// ModelCleanupResult summarizes a cleanup run.
+type ModelCleanupResult struct {
+	Scanned        int      `json:"scanned"`
+	Archived       []string `json:"archived"`
+	ObjectsDeleted int      `json:"objects_deleted"`
+	DryRun         bool     `json:"dry_run"`
+}
+
+// CleanupModels archives superseded models: a registered model is kept when
+// it is among the newest keep models of its parameter at any of its sites
+// (see supersededModels). For the others,
+// every object under <models>/<job>/ and <models>/checkpoints/<job>/ in
+// bucket is deleted and the registry entry gets archived_on. The default
+// model and entries without a recorded artifact are never touched. With
+// dryRun, candidates are reported without deleting anything.
+func CleanupModels(ctx context.Context, bucket string, keep int, dryRun bool) (*ModelCleanupResult, error) {
+	models, err := listRegisteredModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := &ModelCleanupResult{Scanned: len(models), DryRun: dryRun}
+	layout := Layout()
+	for _, m := range supersededModels(models, keep) {
+		prefixes, ok := modelArtifactPrefixes(layout, bucket, m.ModelArtifact)
+		if !ok {
+			log.Printf("model %s: artifact %s is outside the models prefix; skipped", m.UUID, m.ModelArtifact)
+			continue
+		}
+		res.Archived = append(res.Archived, m.UUID)
+		if dryRun {
+			continue
+		}
+		for _, prefix := range prefixes {
+			n, err := getBlobStore().DeletePrefix(ctx, bucket, prefix)
+			res.ObjectsDeleted += n
+			if err != nil {
+				return res, fmt.Errorf("delete artifacts of %s: %w", m.UUID, err)
+			}
+		}
+		if err := markModelArchived(ctx, m); err != nil {
+			return res, fmt.Errorf("archive registry entry %s: %w", m.UUID, err)
+		}
+	}
+	return res, nil
+}
+
+// supersededModels returns the models, newest first, that aren't among the
+// newest keep of their parameter (00060 when unrecorded) at any of their
+// sites. Archived models and those without a recorded artifact are left out.
+func supersededModels(models []TrainModelTrackerItem, keep int) []TrainModelTrackerItem {
+	type siteParameter struct{ site, parameter string }
+	counts := map[siteParameter]int{}
+	var out []TrainModelTrackerItem
+	for _, m := range models {
+		if m.ArchivedOn != 0 {
+			continue
+		}
+		kept := false
+		for _, site := range m.Sites {
+			k := siteParameter{site, cmp.Or(m.Parameter, "00060")}
+			if counts[k] < keep {
+				kept = true
+			}
+			counts[k]++
+		}
+		if !kept && m.ModelArtifact != "" {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// listRegisteredModels returns every registry entry, newest first.
+func listRegisteredModels(ctx context.Context) ([]TrainModelTrackerItem, error) {
+	var all []TrainModelTrackerItem
+	cursor := ""
+	for {
+		items, next, err := ListRecentTrainModelsPage(ctx, 0, 500, cursor)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, items...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	slices.SortStableFunc(all, func(a, b TrainModelTrackerItem) int { return cmp.Compare(b.CreatedOn, a.CreatedOn) })
+	return all, nil
+}
+
+// modelArtifactPrefixes returns the key prefixes holding a model's job
+// output and checkpoints, given its artifact URI
+// (s3://<bucket>/<models>/<job>/output/model.tar.gz). It reports false for
+// artifacts in another bucket, outside the models prefix, or of the default
+// model.
+func modelArtifactPrefixes(layout StorageLayout, bucket, artifact string) ([]string, bool) {
+	models := layout.key(layout.Models) + "/"
+	key, ok := strings.CutPrefix(artifact, "s3://"+bucket+"/")
+	if !ok {
+		return nil, false
+	}
+	rest, ok := strings.CutPrefix(key, models)
+	if !ok {
+		return nil, false
+	}
+	job, _, _ := strings.Cut(rest, "/")
+	if job == "" || job == "checkpoints" || isDefaultModelJob(job) {
+		return nil, false
+	}
+	return []string{models + job + "/", models + "checkpoints/" + job + "/"}, true
+}
+
+// ErrModelArtifactUnavailable is returned when a registered model has no
+// downloadable artifact: none was recorded, it was archived, or the object
+// is missing.
+var ErrModelArtifactUnavailable = errors.New("model artifact unavailable")
+
+// ModelArtifactURL returns a presigned GET URL for the model.tar.gz of the
+// registered model uuid, valid for expiry, along with the artifact's key.
+// Only artifacts under the models prefix of bucket are handed out. Errors
+// match ErrTrainModelNotFound or ErrModelArtifactUnavailable.
+func ModelArtifactURL(ctx context.Context, bucket, uuid string, expiry time.Duration) (string, string, error) {
+	m, err := GetTrainModel(ctx, uuid)
+	if err != nil {
+		return "", "", err
+	}
+	if m.ArchivedOn != 0 {
+		return "", "", fmt.Errorf("%w: model was archived", ErrModelArtifactUnavailable)
+	}
+	if m.ModelArtifact == "" {
+		return "", "", fmt.Errorf("%w: no artifact recorded", ErrModelArtifactUnavailable)
+	}
+	layout := Layout()
+	key, ok := strings.CutPrefix(m.ModelArtifact, "s3://"+bucket+"/")
+	if !ok || !strings.HasPrefix(key, layout.key(layout.Models)+"/") {
+		return "", "", fmt.Errorf("%w: artifact %s is outside the models prefix", ErrModelArtifactUnavailable, m.ModelArtifact)
+	}
+	if _, _, err := getBlobStore().GetTail(ctx, bucket, key, 1); err != nil {
+		if errors.Is(err, ErrBlobNotFound) {
+			return "", "", fmt.Errorf("%w: %s not found", ErrModelArtifactUnavailable, key)
+		}
+		return "", "", err
+	}
+	url, err := GeneratePresignedGetURL(ctx, bucket, key, expiry)
+	if err != nil {
+		return "", "", err
+	}
+	return url, key, nil
+}
+
+// markModelArchived sets archived_on on a registry entry.
+func markModelArchived(ctx context.Context, m TrainModelTrackerItem) error {
+	key, err := attributevalue.MarshalMap(map[string]any{"uuid": m.UUID, "createdon": m.CreatedOn})
+	if err != nil {
+		return err
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{":now": time.Now().UTC().UnixMilli()})
+	if err != nil {
+		return err
+	}
+	_, err = getDynamoClient().UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 awsString(trainModelTrackerTable()),
+		Key:                       key,
+		UpdateExpression:          awsString("SET archived_on = :now"),
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
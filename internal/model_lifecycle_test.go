@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSupersededModels(t *testing.T) {
+	const art = "s3://bucket/models/job/output/model.tar.gz"
+	// newest first, as listRegisteredModels returns them
+	models := []TrainModelTrackerItem{
+		{UUID: "q3", Sites: []string{"A"}, Parameter: "00060", ModelArtifact: art},
+		{UUID: "q2", Sites: []string{"A"}, ModelArtifact: art},
+		{UUID: "h2", Sites: []string{"A"}, Parameter: "00065", ModelArtifact: art},
+		{UUID: "q1", Sites: []string{"A"}, Parameter: "00060", ModelArtifact: art},
+		{UUID: "h1", Sites: []string{"A"}, Parameter: "00065", ModelArtifact: art},
+		{UUID: "ab", Sites: []string{"A", "B"}, Parameter: "00060", ModelArtifact: art},
+		{UUID: "q0", Sites: []string{"A"}, Parameter: "00060", ModelArtifact: art},
+		{UUID: "old-archived", Sites: []string{"A"}, Parameter: "00060", ModelArtifact: art, ArchivedOn: 1},
+		{UUID: "no-artifact", Sites: []string{"A"}, Parameter: "00060"},
+		{UUID: "h0", Sites: []string{"A"}, Parameter: "00065", ModelArtifact: art},
+	}
+	tests := []struct {
+		keep int
+		want []string
+	}{
+		// ab stays for site B; unrecorded parameters count as 00060
+		{keep: 2, want: []string{"q1", "q0", "h0"}},
+		{keep: 1, want: []string{"q2", "q1", "h1", "q0", "h0"}},
+		{keep: 3, want: []string{"q0"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, m := range supersededModels(models, tt.keep) {
+			got = append(got, m.UUID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("keep %d: superseded = %q, want %q", tt.keep, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"aquawatch/internal/cache"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// A model's performance is measured on the anomaly evaluations its
+// predictions were scored in: each pairs a stored prediction with the
+// observation it was checked against (for stream evaluations, an
+// observation made after the prediction). Errors are predicted minus
+// observed, bucketed by evaluation time.
+
+// Performance buckets.
+const (
+	PerformanceBucketHour = "hour"
+	PerformanceBucketDay  = "day"
+	PerformanceBucketWeek = "week"
+)
+
+// PerformanceSourceCheck selects the evaluations of anomaly checks, which
+// carry no source.
+const PerformanceSourceCheck = "check"
+
+const (
+	// MaxPerformanceDays bounds the window a performance report covers.
+	MaxPerformanceDays = 90
+	// maxPerformanceSites matches the largest queued anomaly sweep.
+	maxPerformanceSites = 1000
+)
+
+var performanceBuckets = map[string]time.Duration{
+	PerformanceBucketHour: time.Hour,
+	PerformanceBucketDay:  24 * time.Hour,
+	PerformanceBucketWeek: 7 * 24 * time.Hour,
+}
+
+// ErrInvalidPerformanceQuery is returned for performance queries that fail
+// validation.
+var ErrInvalidPerformanceQuery = validationError("invalid performance query")
+
+// PerformanceQuery selects the evaluations a performance report covers.
+type PerformanceQuery struct {
+	// Model is a training job name, as in the train-model-tracker.
+	Model     string
+	Sites     []string
+	Parameter string
+	// Source limits the report to one evaluation source
+	// (EvaluationSourceStream or PerformanceSourceCheck); empty covers both.
+	Source string
+	Days   int
+	Bucket string
+}
+
+// PerformanceMetrics summarizes prediction errors.
+type PerformanceMetrics struct {
+	Count int     `json:"count"`
+	MAE   float64 `json:"mae"`
+	// MAPE is the mean absolute percentage error, in percent, over
+	// observations other than zero; omitted when there are none.
+	MAPE *float64 `json:"mape,omitempty"`
+	// Bias is the mean error: positive when the model over-predicts.
+	Bias float64 `json:"bias"`
+}
+
+// PerformanceBucket is the metrics of one time bucket.
+type PerformanceBucket struct {
+	Start int64 `json:"start_ms"`
+	PerformanceMetrics
+}
+
+// SitePerformance is the metrics of one site over the whole window.
+type SitePerformance struct {
+	Site string `json:"site"`
+	PerformanceMetrics
+}
+
+// ModelPerformance is a model's error metrics over a window.
+type ModelPerformance struct {
+	Model     string              `json:"model"`
+	Parameter string              `json:"parameter,omitempty"`
+	Source    string              `json:"source,omitempty"`
+	Bucket    string              `json:"bucket"`
+	From      int64               `json:"from_ms"`
+	To        int64               `json:"to_ms"`
+	Overall   PerformanceMetrics  `json:"overall"`
+	Buckets   []PerformanceBucket `json:"buckets"`
+	Sites     []SitePerformance   `json:"sites"`
+}
+
+// ModelName returns the training job name of a model reference: an artifact
+// URI or endpoint target ending in <job>/output/model.tar.gz, or a bare job
+// name.
+func ModelName(ref string) string {
+	ref = strings.TrimSuffix(strings.TrimSuffix(ref, "/model.tar.gz"), "/output")
+	return path.Base(ref)
+}
+
+// errorStats accumulates prediction errors.
+type errorStats struct {
+	n, pctN        int
+	absSum, errSum float64
+	pctSum         float64
+}
+
+func (s *errorStats) add(observed, predicted float64) {
+	diff := predicted - observed
+	s.n++
+	s.errSum += diff
+	s.absSum += math.Abs(diff)
+	if observed != 0 {
+		s.pctN++
+		s.pctSum += math.Abs(diff / observed * 100)
+	}
+}
+
+func (s *errorStats) metrics() PerformanceMetrics {
+	m := PerformanceMetrics{Count: s.n}
+	if s.n > 0 {
+		m.MAE = s.absSum / float64(s.n)
+		m.Bias = s.errSum / float64(s.n)
+	}
+	if s.pctN > 0 {
+		mape := s.pctSum / float64(s.pctN)
+		m.MAPE = &mape
+	}
+	return m
+}
+
+// modelPerformanceCache holds reports for a few minutes: each reads every
+// site's evaluations over the window, and quality pages get reloaded.
+var modelPerformanceCache = cache.New[string, *ModelPerformance]("model-performance", 200, 5*time.Minute)
+
+// GetModelPerformance reports q.Model's error metrics over the last q.Days
+// days. Errors match ErrInvalidPerformanceQuery for bad queries. Reports may
+// be up to five minutes old.
+func GetModelPerformance(ctx context.Context, q PerformanceQuery) (*ModelPerformance, error) {
+	step, ok := performanceBuckets[q.Bucket]
+	if !ok {
+		return nil, fmt.Errorf("%w: bucket must be hour, day or week", ErrInvalidPerformanceQuery)
+	}
+	if q.Days < 1 || q.Days > MaxPerformanceDays {
+		return nil, fmt.Errorf("%w: days must be between 1 and %d", ErrInvalidPerformanceQuery, MaxPerformanceDays)
+	}
+	if q.Source != "" && q.Source != EvaluationSourceStream && q.Source != PerformanceSourceCheck {
+		return nil, fmt.Errorf("%w: source must be stream or check", ErrInvalidPerformanceQuery)
+	}
+	if len(q.Sites) == 0 || len(q.Sites) > maxPerformanceSites {
+		return nil, fmt.Errorf("%w: between 1 and %d sites required", ErrInvalidPerformanceQuery, maxPerformanceSites)
+	}
+	key := strings.Join([]string{q.Model, q.Parameter, q.Source, q.Bucket, fmt.Sprint(q.Days), strings.Join(q.Sites, ",")}, "#")
+	return modelPerformanceCache.GetOrLoad(ctx, key, func(ctx context.Context) (*ModelPerformance, error) {
+		now := time.Now().UTC()
+		from := now.AddDate(0, 0, -q.Days)
+		evals, err := evaluationsSince(ctx, q.Sites, q.Parameter, from)
+		if err != nil {
+			return nil, err
+		}
+		return summarizePerformance(q, evals, from, now, step), nil
+	})
+}
+
+func summarizePerformance(q PerformanceQuery, evals []AnomalyEvaluation, from, to time.Time, step time.Duration) *ModelPerformance {
+	var overall errorStats
+	buckets := map[int64]*errorStats{}
+	sites := map[string]*errorStats{}
+	for _, e := range evals {
+		if e.Model == "" || ModelName(e.Model) != q.Model {
+			continue
+		}
+		if q.Source == EvaluationSourceStream && e.Source != EvaluationSourceStream ||
+			q.Source == PerformanceSourceCheck && e.Source != "" {
+			continue
+		}
+		start := time.UnixMilli(e.EvaluatedOn).UTC().Truncate(step).UnixMilli()
+		if buckets[start] == nil {
+			buckets[start] = &errorStats{}
+		}
+		if sites[e.Site] == nil {
+			sites[e.Site] = &errorStats{}
+		}
+		overall.add(e.ObservedValue, e.PredictedValue)
+		buckets[start].add(e.ObservedValue, e.PredictedValue)
+		sites[e.Site].add(e.ObservedValue, e.PredictedValue)
+	}
+
+	p := &ModelPerformance{
+		Model:     q.Model,
+		Parameter: q.Parameter,
+		Source:    q.Source,
+		Bucket:    q.Bucket,
+		From:      from.UnixMilli(),
+		To:        to.UnixMilli(),
+		Overall:   overall.metrics(),
+		Buckets:   []PerformanceBucket{},
+		Sites:     []SitePerformance{},
+	}
+	for start, s := range buckets {
+		p.Buckets = append(p.Buckets, PerformanceBucket{Start: start, PerformanceMetrics: s.metrics()})
+	}
+	slices.SortFunc(p.Buckets, func(a, b PerformanceBucket) int { return cmp.Compare(a.Start, b.Start) })
+	for _, site := range q.Sites {
+		if s := sites[site]; s != nil {
+			p.Sites = append(p.Sites, SitePerformance{Site: site, PerformanceMetrics: s.metrics()})
+		}
+	}
+	return p
+}
+
+// evaluationsSince returns the evaluations of parameter (any, when empty)
+// made at or after from for each site. Sites are read concurrently.
+func evaluationsSince(ctx context.Context, sites []string, parameter string, from time.Time) ([]AnomalyEvaluation, error) {
+	found := make([][]AnomalyEvaluation, len(sites))
+	errs := make([]error, len(sites))
+	sem := make(chan struct{}, 8)
+	var wg sync.WaitGroup
+	for i, site := range sites {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			found[i], errs[i] = siteEvaluationsSince(ctx, site, parameter, from)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	var out []AnomalyEvaluation
+	for _, evals := range found {
+		out = append(out, evals...)
+	}
+	return out, nil
+}
+
+func siteEvaluationsSince(ctx context.Context, site, parameter string, from time.Time) ([]AnomalyEvaluation, error) {
+	vals := map[string]any{":site": site, ":from": from.UnixMilli()}
+	in := &dynamodb.QueryInput{KeyConditionExpression: awsString("site = :site AND evaluatedon >= :from")}
+	if parameter != "" {
+		vals[":p"] = parameter
+		in.FilterExpression = awsString("parameter = :p")
+	}
+	values, err := attributevalue.MarshalMap(vals)
+	if err != nil {
+		return nil, err
+	}
+	in.ExpressionAttributeValues = values
+	repo := newRepository[AnomalyEvaluation](anomalyEvaluationsTable())
+	var out []AnomalyEvaluation
+	var cursor string
+	for {
+		items, next, err := repo.Query(ctx, in, cursor)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+		if next == "" {
+			return out, nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"os"
+	"strings"
+)
+
+// ResourceNaming decorates the default names of the AWS resources AquaWatch
+// owns (DynamoDB tables, SNS topics, the Glue database) so several stacks can
+// share one AWS account: with Prefix "aw-" and Suffix "-stage" the
+// alert-tracker table is "aw-alert-tracker-stage". Names set explicitly
+// through their own variables (ALERT_TRACKER_TABLE, SNS_TOPIC_NAME, ...) are
+// used verbatim. scripts/install.sh applies the same rule when it creates
+// the resources.
+type ResourceNaming struct {
+	Prefix string
+	Suffix string
+}
+
+// Naming returns the resource naming from RESOURCE_PREFIX and
+// RESOURCE_SUFFIX (both default empty, leaving names unchanged).
+func Naming() ResourceNaming {
+	return ResourceNaming{
+		Prefix: strings.TrimSpace(os.Getenv("RESOURCE_PREFIX")),
+		Suffix: strings.TrimSpace(os.Getenv("RESOURCE_SUFFIX")),
+	}
+}
+
+// Name decorates the default name base.
+func (n ResourceNaming) Name(base string) string {
+	return n.Prefix + base + n.Suffix
+}
+
+// Namespace is the storage namespace of the stack: prefix and suffix
+// without separators, joined by "-" ("aw-stage" for the example above).
+// Layout uses it when STORAGE_ENV is unset.
+func (n ResourceNaming) Namespace() string {
+	var parts []string
+	for _, p := range []string{n.Prefix, n.Suffix} {
+		if p = strings.Trim(p, "-_./"); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// resourceName resolves a resource name from envVar, falling back to def
+// decorated by Naming.
+func resourceName(envVar, def string) string {
+	if v := strings.TrimSpace(os.Getenv(envVar)); v != "" {
+		return v
+	}
+	return Naming().Name(def)
+}
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// Object purposes, attached as the "purpose" tag on every object we write so
+// S3 lifecycle rules can target them (e.g. expire purpose=raw after 30 days).
+const (
+	PurposeRaw       = "raw"
+	PurposeProcessed = "processed"
+	PurposeReport    = "report"
+	PurposeArchive   = "archive"
+	PurposeExport    = "export"
+)
+
+// ProcessedSchemaVersion identifies the processed CSV column layout
+// (value,timestamp_unix,latitude,longitude,wx_temp). Bump it when the
+// columns change so consumers can tell old datasets apart.
+const ProcessedSchemaVersion = "1"
+
+// Object metadata keys (stored by S3 as x-amz-meta-<key>).
+const (
+	MetaRequestID     = "request-id"
+	MetaSites         = "sites"
+	MetaSchemaVersion = "schema-version"
+	MetaDataSource    = "data-source"
+)
+
+// contentTypeForKey guesses the Content-Type from the key's extension.
+func contentTypeForKey(key string) string {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".csv":
+		return "text/csv"
+	case ".pdf":
+		return "application/pdf"
+	case ".json":
+		return "application/json"
+	case ".jsonl":
+		return "application/x-ndjson"
+	case ".parquet":
+		return "application/vnd.apache.parquet"
+	case ".png":
+		return "image/png"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	}
+	return "application/octet-stream"
+}
+
+// ObjectOptions builds PutOptions for an object written for purpose: the
+// content type is derived from key, the purpose becomes a tag, and the Lambda
+// request id (when running in Lambda) is added to meta.
+func ObjectOptions(ctx context.Context, key, purpose string, meta map[string]string) PutOptions {
+	opts := PutOptions{
+		ContentType: contentTypeForKey(key),
+		Metadata:    map[string]string{},
+		Tags:        map[string]string{"purpose": purpose},
+	}
+	for k, v := range meta {
+		if v != "" {
+			opts.Metadata[k] = v
+		}
+	}
+	if lc, ok := lambdacontext.FromContext(ctx); ok && lc.AwsRequestID != "" {
+		opts.Metadata[MetaRequestID] = lc.AwsRequestID
+	}
+	return opts
+}
+
+// encodeTags renders tags as the URL-encoded query string S3 expects in the
+// x-amz-tagging header, with keys sorted for stable output.
+func encodeTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(tags[k]))
+	}
+	return strings.Join(parts, "&")
+}
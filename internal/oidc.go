@@ -0,0 +1,388 @@
+package internal
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"aquawatch/internal/httpclient"
+)
+
+// OIDC bearer tokens let organizations sign in with their own identity
+// provider (a Cognito user pool, or an OIDC issuer with Cognito-style
+// token_use claims) instead of SMS/email session tokens. Enable by setting
+// OIDC_ISSUER; tokens are sent as "Authorization: Bearer <jwt>" and
+// verified against the issuer's JWKS.
+//
+//   - OIDC_ISSUER: issuer URL, e.g. https://cognito-idp.<region>.amazonaws.com/<pool-id>
+//   - OIDC_AUDIENCE: expected aud (ID tokens) or client_id (Cognito access
+//     tokens); comma-separated for several app clients. Required: without it
+//     a token minted for any app client of the issuer would be accepted,
+//     groups and all, so the server refuses to start (see CheckOIDCConfig).
+//   - OIDC_JWKS_URL: key set URL; by default discovered from
+//     <issuer>/.well-known/openid-configuration.
+//
+// Tokens must carry token_use "access" (matched on client_id) or "id"
+// (matched on aud), as Cognito tokens do. RS256 and ES256 signatures are
+// supported.
+
+// jwksCacheTTL is how long a fetched key set is trusted before re-fetching.
+const jwksCacheTTL = time.Hour
+
+// jwksMinRefresh rate-limits re-fetches triggered by unknown key IDs.
+const jwksMinRefresh = time.Minute
+
+// jwksFetchTimeout bounds a key set fetch, which runs apart from the
+// requests waiting for it.
+const jwksFetchTimeout = 15 * time.Second
+
+// OIDCClaims are the claims read from a verified OIDC token.
+type OIDCClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ClientID  string   `json:"client_id"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	IssuedAt  int64    `json:"iat"`
+	TokenUse  string   `json:"token_use"`
+	Email     string   `json:"email"`
+	Phone     string   `json:"phone_number"`
+	Groups    []string `json:"cognito:groups"`
+}
+
+// Identity is the identity recorded for an OIDC caller: email or phone
+// when the token carries one, otherwise "oidc:<sub>".
+func (c *OIDCClaims) Identity() string {
+	switch {
+	case c.Email != "":
+		return strings.ToLower(c.Email)
+	case c.Phone != "":
+		return c.Phone
+	}
+	return "oidc:" + c.Subject
+}
+
+// audience accepts aud as either a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// OIDCEnabled reports whether OIDC_ISSUER is configured.
+func OIDCEnabled() bool {
+	return oidcIssuer() != ""
+}
+
+func oidcIssuer() string {
+	return strings.TrimRight(strings.TrimSpace(os.Getenv("OIDC_ISSUER")), "/")
+}
+
+// CheckOIDCConfig fails when OIDC is enabled without OIDC_AUDIENCE.
+func CheckOIDCConfig() error {
+	if OIDCEnabled() && len(oidcAudiences()) == 0 {
+		return errors.New("OIDC_ISSUER is set but OIDC_AUDIENCE is empty; set the app client IDs tokens must be issued to")
+	}
+	return nil
+}
+
+func oidcAudiences() []string {
+	var out []string
+	for _, a := range strings.Split(os.Getenv("OIDC_AUDIENCE"), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// ValidateOIDCToken verifies the signature of a bearer JWT against the
+// issuer's JWKS and checks iss, exp/nbf, token_use and the audience.
+func ValidateOIDCToken(ctx context.Context, token string) (*OIDCClaims, error) {
+	issuer := oidcIssuer()
+	if issuer == "" {
+		return nil, errors.New("OIDC_ISSUER not configured")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: header encoding", ErrInvalidToken)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, fmt.Errorf("%w: header", ErrInvalidToken)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding", ErrInvalidToken)
+	}
+	key, err := defaultJWKS.key(ctx, issuer, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := verifyJWSSignature(header.Alg, key, digest[:], sig); err != nil {
+		return nil, err
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: claims encoding", ErrInvalidToken)
+	}
+	var claims OIDCClaims
+	if err := json.Unmarshal(rawClaims, &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims", ErrInvalidToken)
+	}
+	now := time.Now().Unix()
+	switch {
+	case strings.TrimRight(claims.Issuer, "/") != issuer:
+		return nil, fmt.Errorf("%w: issuer", ErrInvalidToken)
+	case now >= claims.ExpiresAt:
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.NotBefore != 0 && now < claims.NotBefore:
+		return nil, fmt.Errorf("%w: not yet valid", ErrInvalidToken)
+	case claims.TokenUse != "access" && claims.TokenUse != "id":
+		return nil, fmt.Errorf("%w: token_use", ErrInvalidToken)
+	case !oidcAudienceAllowed(claims):
+		return nil, fmt.Errorf("%w: audience", ErrInvalidToken)
+	}
+	return &claims, nil
+}
+
+// oidcAudienceAllowed matches client_id (access tokens, which carry no aud)
+// or aud (ID tokens) against OIDC_AUDIENCE. Nothing matches an empty one.
+func oidcAudienceAllowed(c OIDCClaims) bool {
+	for _, want := range oidcAudiences() {
+		if c.TokenUse == "access" && c.ClientID == want {
+			return true
+		}
+		if c.TokenUse == "id" && slices.Contains(c.Audience, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyJWSSignature checks a SHA-256 JWS signature with key.
+func verifyJWSSignature(alg string, key crypto.PublicKey, digest, sig []byte) error {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key type does not match %s", ErrInvalidToken, alg)
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig) != nil {
+			return fmt.Errorf("%w: signature", ErrInvalidToken)
+		}
+		return nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return fmt.Errorf("%w: key type does not match %s", ErrInvalidToken, alg)
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("%w: signature", ErrInvalidToken)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, alg)
+}
+
+// jwksCache holds the issuer's public keys by kid.
+type jwksCache struct {
+	mu        sync.Mutex
+	issuer    string
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// fetching is closed when the key set fetch in flight is done, with
+	// fetchErr its error. Only one fetch runs and every caller waits for it
+	// without holding mu, so a slow issuer doesn't stall requests with
+	// cached keys.
+	fetching chan struct{}
+	fetchErr error
+}
+
+var defaultJWKS = &jwksCache{}
+
+// key returns the public key for kid, fetching the key set when it is stale
+// or (at most once per jwksMinRefresh) when kid is unknown after a rotation.
+func (c *jwksCache) key(ctx context.Context, issuer, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	stale := c.issuer != issuer || time.Since(c.fetchedAt) > jwksCacheTTL
+	if k, ok := c.keys[kid]; ok && !stale {
+		c.mu.Unlock()
+		return k, nil
+	}
+	if !stale && time.Since(c.fetchedAt) < jwksMinRefresh {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, kid)
+	}
+	done := c.fetching
+	if done == nil {
+		done = make(chan struct{})
+		c.fetching = done
+		go c.refresh(ctx, issuer, done)
+	}
+	c.mu.Unlock()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if k, ok := c.keys[kid]; ok && c.issuer == issuer {
+		return k, nil
+	}
+	if c.fetchErr != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", c.fetchErr)
+	}
+	return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, kid)
+}
+
+// refresh fetches issuer's key set into c and closes done. The fetch is
+// detached from ctx, the context of the request that started it, and
+// limited to jwksFetchTimeout instead: that caller giving up must not fail
+// it for everyone else waiting.
+func (c *jwksCache) refresh(ctx context.Context, issuer string, done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jwksFetchTimeout)
+	defer cancel()
+	keys, err := fetchJWKS(ctx, issuer)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.issuer, c.keys, c.fetchedAt = issuer, keys, time.Now()
+	}
+	c.fetching, c.fetchErr = nil, err
+	close(done)
+}
+
+// jwk is a single JSON Web Key (RSA or EC P-256 public key).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// fetchJWKS downloads the issuer's signing keys. Keys of unsupported types
+// or marked for encryption are skipped.
+func fetchJWKS(ctx context.Context, issuer string) (map[string]crypto.PublicKey, error) {
+	jwksURL := os.Getenv("OIDC_JWKS_URL")
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := getJSON(ctx, issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, fmt.Errorf("discovery: %w", err)
+		}
+		if discovery.JWKSURI == "" {
+			return nil, errors.New("discovery document has no jwks_uri")
+		}
+		jwksURL = discovery.JWKSURI
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable signing keys")
+	}
+	return keys, nil
+}
+
+// oidcClient fetches discovery documents and signing keys.
+var oidcClient = httpclient.New(httpclient.Options{Name: "oidc", Timeout: 5 * time.Second})
+
+// getJSON fetches url and decodes a JSON body into out.
+func getJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
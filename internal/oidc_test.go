@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testOIDCIssuer = "https://idp.example.com/pool"
+
+// testIdP serves a JWKS whose keys can be rotated, counting fetches.
+type testIdP struct {
+	mu      sync.Mutex
+	keys    []map[string]string
+	fetches atomic.Int32
+	// gate, when set, holds each fetch until it is closed; arrived
+	// receives a value as a fetch comes in.
+	gate    chan struct{}
+	arrived chan struct{}
+}
+
+func (p *testIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.fetches.Add(1)
+	if p.arrived != nil {
+		p.arrived <- struct{}{}
+	}
+	if p.gate != nil {
+		<-p.gate
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]any{"keys": p.keys})
+}
+
+func (p *testIdP) publish(keys ...map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func rsaJWK(kid string, k *rsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+}
+
+func ecJWK(kid string, k *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(k.X.FillBytes(make([]byte, 32))), "y": b64(k.Y.FillBytes(make([]byte, 32)))}
+}
+
+// signOIDC signs claims with key (RSA or ECDSA) under a header of alg and kid.
+func signOIDC(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signing := b64(h) + "." + b64(c)
+	digest := sha256.Sum256([]byte(signing))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signing + "." + b64(sig)
+}
+
+// useTestIdP points OIDC at idp with a fresh key cache.
+func useTestIdP(t *testing.T, idp *testIdP) {
+	t.Helper()
+	srv := httptest.NewServer(idp)
+	t.Cleanup(srv.Close)
+	t.Setenv("OIDC_ISSUER", testOIDCIssuer)
+	t.Setenv("OIDC_AUDIENCE", "web-client, mobile-client")
+	t.Setenv("OIDC_JWKS_URL", srv.URL)
+	orig := defaultJWKS
+	defaultJWKS = &jwksCache{}
+	t.Cleanup(func() { defaultJWKS = orig })
+}
+
+func accessClaims(mods ...func(map[string]any)) map[string]any {
+	now := time.Now().Unix()
+	c := map[string]any{"iss": testOIDCIssuer, "sub": "abc-123", "client_id": "web-client", "token_use": "access", "iat": now, "exp": now + 300}
+	for _, mod := range mods {
+		mod(c)
+	}
+	return c
+}
+
+func TestValidateOIDCToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{}
+	idp.publish(rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey))
+	useTestIdP(t, idp)
+
+	set := func(k string, v any) func(map[string]any) { return func(c map[string]any) { c[k] = v } }
+	del := func(k string) func(map[string]any) { return func(c map[string]any) { delete(c, k) } }
+	idToken := func(aud any, mods ...func(map[string]any)) map[string]any {
+		base := []func(map[string]any){set("token_use", "id"), del("client_id"), set("aud", aud), set("email", "Ops@Example.com")}
+		return accessClaims(append(base, mods...)...)
+	}
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"access token", signOIDC(t, "RS256", "rsa-1", rsaKey, accessClaims()), true},
+		{"access token of second client", signOIDC(t, "RS256", "rsa-1", rsaKey, accessClaims(set("client_id", "mobile-client"))), true},
+		{"id token", signOIDC(t, "RS256", "rsa-1", rsaKey, idToken("mobile-client")), true},
+		{"id token with aud list", signOIDC(t, "RS256", "rsa-1", rsaKey, idToken([]string{"other", "web-client"})), true},
+		{"ES256", signOIDC(t, "ES256", "ec-1", ecKey, accessClaims()), true},
+
+		{"access token of other client", signOIDC(t, "RS256", "rsa-1", rsaKey, accessClaims(set("client_id", "other-client"))), false},
+		{"access token matched on aud only", signOIDC(t, "RS256", "rsa-1", rsaKey, accessClaims(set("client_id", "other-client"), set("aud", "web-client"))), false},
+		{"id token of other audience", signOIDC(t, "RS256", "rsa-1", rsaKey, idToken("other-client")), false},
+		{"id token matched on client_id only", signOIDC(t, "RS256", "rsa-1", rsaKey, idToken("other-client", set("client_id", "web-client"))), false},
+
+		{"token_use refresh", signOIDC(t, "RS256", "rsa-1", rsaKey, accessClaims(set("token_use", "refresh"))), false},
+		{"token_use missing", signOIDC(t, "RS256", "rsa-1", rsaKey, accessClaims(del("token_use"))), false},
+
+		{"ES256 header on RSA key", signOIDC(t, "ES256", "rsa-1", ecKey, accessClaims()), false},
+		{"RS256 header on EC key", signOIDC(t, "RS256", "ec-1", rsaKey, accessClaims()), false},
+		{"alg none", signOIDC(t, "none", "rsa-1", rsaKey, accessClaims()), false},
+		{"alg HS256", signOIDC(t, "HS256", "rsa-1", rsaKey, accessClaims()), false},
+		{"signed by another key", signOIDC(t, "RS256", "rsa-1", otherRSA, accessClaims()), false},
+		{"unknown kid", signOIDC(t, "RS256", "rsa-9", otherRSA, accessClaims()), false},
+
+		{"expired", signOIDC(t, "RS256", "rsa-1", rsaKey, accessClaims(set("exp", now-1))), false},
+		{"not yet valid", signOIDC(t, "RS256", "rsa-1", rsaKey, accessClaims(set("nbf", now+60))), false},
+		{"other issuer", signOIDC(t, "RS256", "rsa-1", rsaKey, accessClaims(set("iss", "https://evil.example.com"))), false},
+		{"malformed", "not.a-jwt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ValidateOIDCToken(context.Background(), tt.token)
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("ValidateOIDCToken: %v", err)
+				}
+				if claims.Subject != "abc-123" {
+					t.Errorf("subject = %q, want abc-123", claims.Subject)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("ValidateOIDCToken = %+v, %v; want ErrInvalidToken", claims, err)
+			}
+		})
+	}
+	if n := idp.fetches.Load(); n != 1 {
+		t.Errorf("key set fetched %d times, want once", n)
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{}
+	idp.publish(ecJWK("old", oldKey))
+	useTestIdP(t, idp)
+	ctx := context.Background()
+
+	if _, err := ValidateOIDCToken(ctx, signOIDC(t, "ES256", "old", oldKey, accessClaims())); err != nil {
+		t.Fatalf("token of the published key: %v", err)
+	}
+	idp.publish(ecJWK("old", oldKey), ecJWK("new", newKey))
+	rotated := signOIDC(t, "ES256", "new", newKey, accessClaims())
+
+	// Within jwksMinRefresh of the last fetch an unknown kid is refused
+	// without asking the issuer again.
+	if _, err := ValidateOIDCToken(ctx, rotated); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("unknown kid right after a fetch: err = %v, want ErrInvalidToken", err)
+	}
+	if n := idp.fetches.Load(); n != 1 {
+		t.Fatalf("fetches = %d, want 1", n)
+	}
+
+	defaultJWKS.mu.Lock()
+	defaultJWKS.fetchedAt = time.Now().Add(-jwksMinRefresh - time.Second)
+	defaultJWKS.mu.Unlock()
+	if _, err := ValidateOIDCToken(ctx, rotated); err != nil {
+		t.Fatalf("token of the rotated-in key: %v", err)
+	}
+	if n := idp.fetches.Load(); n != 2 {
+		t.Errorf("fetches = %d, want 2", n)
+	}
+	if _, err := ValidateOIDCToken(ctx, signOIDC(t, "ES256", "old", oldKey, accessClaims())); err != nil {
+		t.Errorf("token of the old key after the refresh: %v", err)
+	}
+}
+
+func TestOIDCKeyFetchOutlivesCanceledCaller(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{gate: make(chan struct{}), arrived: make(chan struct{}, 4)}
+	idp.publish(ecJWK("k", key))
+	useTestIdP(t, idp)
+	token := signOIDC(t, "ES256", "k", key, accessClaims())
+
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := ValidateOIDCToken(first, token)
+		firstErr <- err
+	}()
+	<-idp.arrived // the first caller's fetch is in flight
+
+	secondErr := make(chan error, 1)
+	go func() {
+		_, err := ValidateOIDCToken(context.Background(), token)
+		secondErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond) // let the second caller wait on the fetch
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller: err = %v, want context.Canceled", err)
+	}
+	close(idp.gate)
+	if err := <-secondErr; err != nil {
+		t.Errorf("waiting caller: %v", err)
+	}
+	if n := idp.fetches.Load(); n != 1 {
+		t.Errorf("fetches = %d, want 1", n)
+	}
+}
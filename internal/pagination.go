@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded.
+var ErrInvalidCursor = validationError("invalid cursor")
+
+// cursorAttr is the JSON form of a single key attribute inside a cursor.
+// Only the scalar types used by our table keys (S, N) are supported.
+type cursorAttr struct {
+	S *string `json:"s,omitempty"`
+	N *string `json:"n,omitempty"`
+}
+
+// encodeCursor turns a DynamoDB LastEvaluatedKey into an opaque, URL-safe
+// cursor string. An empty key yields an empty cursor (no more pages).
+func encodeCursor(key map[string]types.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+	raw := make(map[string]cursorAttr, len(key))
+	for name, av := range key {
+		switch v := av.(type) {
+		case *types.AttributeValueMemberS:
+			s := v.Value
+			raw[name] = cursorAttr{S: &s}
+		case *types.AttributeValueMemberN:
+			n := v.Value
+			raw[name] = cursorAttr{N: &n}
+		default:
+			return "", errors.New("unsupported key attribute type in cursor")
+		}
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeCursor reverses encodeCursor. An empty cursor yields a nil key so the
+// query starts from the beginning.
+func decodeCursor(cursor string) (map[string]types.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var raw map[string]cursorAttr
+	if err := json.Unmarshal(b, &raw); err != nil || len(raw) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	key := make(map[string]types.AttributeValue, len(raw))
+	for name, a := range raw {
+		switch {
+		case a.S != nil:
+			key[name] = &types.AttributeValueMemberS{Value: *a.S}
+		case a.N != nil:
+			key[name] = &types.AttributeValueMemberN{Value: *a.N}
+		default:
+			return nil, ErrInvalidCursor
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Models are trained and judged per parameter: a discharge model says
+// nothing about stage, and a 20% swing in discharge is routine where 20% of
+// gage height is not. Each parameter has a profile of how its anomalies are
+// judged and which model scores it; parameters without one are judged like
+// discharge. Every knob can be overridden per parameter code:
+//
+//	ANOMALY_THRESHOLD_PERCENT_<code>   percent change from the prediction that is anomalous
+//	ANOMALY_MIN_PREDICTED_<code>       predictions at or below this are never anomalous
+//	DEFAULT_MODEL_<code>               endpoint target of anomaly checks (else DEFAULT_MODEL)
+//	TRAINING_HYPERPARAMETERS_<code>    merged over TRAINING_HYPERPARAMETERS
+
+// ParameterDischarge and ParameterGageHeight are the hydrologic parameters
+// models are trained for.
+const (
+	ParameterDischarge  = "00060"
+	ParameterGageHeight = "00065"
+)
+
+// ParameterProfile is how observations of a parameter are judged against
+// its model's predictions.
+type ParameterProfile struct {
+	Parameter string
+	Name      string
+	Unit      string
+	// ThresholdPercent is the percent change from the prediction beyond
+	// which an observation is anomalous.
+	ThresholdPercent float64
+	// MinPredicted: predictions at or below it are never anomalous, so
+	// near-zero flows don't alert on noise.
+	MinPredicted float64
+}
+
+// parameterProfiles are the built-in profiles. Stage moves over a few feet
+// where discharge moves over orders of magnitude, so gage height alerts on a
+// smaller change and a much lower floor.
+var parameterProfiles = map[string]ParameterProfile{
+	ParameterDischarge:  {Name: "discharge", Unit: "ft3/s", ThresholdPercent: defaultThresholdPercent, MinPredicted: minPredictedValue},
+	ParameterGageHeight: {Name: "gage height", Unit: "ft", ThresholdPercent: 10, MinPredicted: 0.5},
+}
+
+// ProfileFor returns parameter's profile with its environment overrides.
+// Parameters without a built-in profile get the discharge thresholds, no
+// floor, and the name and unit of their threshold rule parameter if known.
+func ProfileFor(parameter string) ParameterProfile {
+	p, ok := parameterProfiles[parameter]
+	if !ok {
+		p = ParameterProfile{Name: "parameter " + parameter, ThresholdPercent: defaultThresholdPercent}
+		if info, ok := ecologicalParameters[parameter]; ok {
+			p.Name, p.Unit = strings.ToLower(info.Name), info.Unit
+		}
+	}
+	p.Parameter = parameter
+	p.ThresholdPercent = floatFromEnv("ANOMALY_THRESHOLD_PERCENT_"+parameter, p.ThresholdPercent)
+	p.MinPredicted = floatFromEnv("ANOMALY_MIN_PREDICTED_"+parameter, p.MinPredicted)
+	return p
+}
+
+// floatFromEnv reads a non-negative number from envVar, falling back to def.
+func floatFromEnv(envVar string, def float64) float64 {
+	if v, err := strconv.ParseFloat(strings.TrimSpace(os.Getenv(envVar)), 64); err == nil && v >= 0 && !math.IsInf(v, 0) {
+		return v
+	}
+	return def
+}
+
+// PercentChangeReason describes a percent-change anomaly, e.g. "high gage
+// height" when observed is above the prediction.
+func (p ParameterProfile) PercentChangeReason(observed, predicted float64) string {
+	if observed < predicted {
+		return "low " + p.Name
+	}
+	return "high " + p.Name
+}
+
+// DefaultModel returns the endpoint target anomaly checks of the parameter
+// use: DEFAULT_MODEL_<code>, or DEFAULT_MODEL.
+func (p ParameterProfile) DefaultModel() string {
+	return cmp.Or(os.Getenv("DEFAULT_MODEL_"+p.Parameter), os.Getenv("DEFAULT_MODEL"))
+}
+
+// HyperParameterOverrides returns TRAINING_HYPERPARAMETERS_<code> (a JSON
+// object of strings), nil when unset.
+func (p ParameterProfile) HyperParameterOverrides() (map[string]string, error) {
+	raw := os.Getenv("TRAINING_HYPERPARAMETERS_" + p.Parameter)
+	if raw == "" {
+		return nil, nil
+	}
+	var overrides map[string]string
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+		return nil, fmt.Errorf("invalid TRAINING_HYPERPARAMETERS_%s: %w", p.Parameter, err)
+	}
+	return overrides, nil
+}
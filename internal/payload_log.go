@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Payloads (USGS documents, CSV batches, model inputs and outputs) are
+// logged as size and row-count summaries only. Setting LOG_PAYLOADS=true
+// also logs the first LOG_PAYLOAD_MAX_BYTES bytes (default 512) of each, for
+// debugging; leave it off in production, where full payloads would dominate
+// CloudWatch Logs costs and can exceed the 256 KB event limit.
+
+const defaultPayloadLogBytes = 512
+
+// LogPayload logs a summary of data: its size and line count, plus a
+// truncated preview when LOG_PAYLOADS is enabled. label names the payload,
+// e.g. "preprocess input 03339000".
+func LogPayload(label string, data []byte) {
+	lines := bytes.Count(data, []byte{'\n'})
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		lines++
+	}
+	if !payloadDebug() {
+		log.Printf("%s: %d bytes, %d lines", label, len(data), lines)
+		return
+	}
+	limit := envInt("LOG_PAYLOAD_MAX_BYTES", defaultPayloadLogBytes)
+	preview := data
+	var more string
+	if len(preview) > limit {
+		preview = preview[:limit]
+		more = " ... (" + strconv.Itoa(len(data)-limit) + " more bytes)"
+	}
+	log.Printf("%s: %d bytes, %d lines: %q%s", label, len(data), lines, preview, more)
+}
+
+func payloadDebug() bool {
+	on, _ := strconv.ParseBool(os.Getenv("LOG_PAYLOADS"))
+	return on
+}
@@ -18,38 +18,65 @@ import (
 	"strings"
 	"time"
 
+	"aquawatch/internal/httpclient"
+
 	"github.com/jung-kurt/gofpdf"
 )
 
+// foxitClient calls the Foxit document APIs. Uploads and task creation are
+// POSTs and aren't retried; status polls and downloads are.
+var foxitClient = httpclient.New(httpclient.Options{Name: "foxit", Timeout: 30 * time.Second})
+
 // ReportItem represents a single anomalous site row in the PDF table.
 type ReportItem struct {
 	Site           string  `json:"site"`
 	Reason         string  `json:"reason"`
 	PredictedValue float64 `json:"predicted_value"`
 	AnomalyDate    string  `json:"anomaly_date"`
+	// Parameter is the USGS code of PredictedValue (default 00060), used to
+	// look up the site's impact statements.
+	Parameter string `json:"parameter,omitempty"`
 }
 
 // GenerateReportPDF produces a PDF with image on the left and a table on the right.
+// Attached imagery (with URLs set, see LinkAlertImages) is listed as links
+// below the table.
 // If FOXIT_API_URL and FOXIT_API_KEY are set, it attempts to use Foxit API; otherwise
 // it falls back to a local generator.
-func GenerateReportPDF(ctx context.Context, imageBytes []byte, items []ReportItem) ([]byte, error) {
+func GenerateReportPDF(ctx context.Context, imageBytes []byte, items []ReportItem, images []AlertImage) ([]byte, error) {
 	// Prefer Foxit when client credentials are configured
-	if os.Getenv("FOXIT_CLIENT_ID") != "" && os.Getenv("FOXIT_CLIENT_SECRET") != "" {
+	if secretValue("FOXIT_CLIENT_ID") != "" && secretValue("FOXIT_CLIENT_SECRET") != "" {
 		log.Println("using foxit api")
-		b, err := generateWithFoxit(ctx, imageBytes, items)
+		b, err := generateWithFoxit(ctx, imageBytes, items, images)
 		if err == nil {
+			RecordUsage(ctx, UsageFoxitConversion, 1)
 			return b, nil
 		}
 		log.Println("foxit api error:", err)
 		// fall back to local on error
 	}
 	log.Println("using local generator")
-	return generateWithLocal(imageBytes, items)
+	return generateWithLocal(imageBytes, items, images)
+}
+
+// reportImageLabel is the text an attached image is listed under.
+func reportImageLabel(img AlertImage) string {
+	label := img.Caption
+	if label == "" {
+		label = "Webcam image"
+		if img.Source == AlertImageUpload {
+			label = "Uploaded image"
+		}
+	}
+	if img.Site != "" {
+		label += " (site " + img.Site + ")"
+	}
+	return label
 }
 
 // generateWithFoxit posts multipart data to a configurable Foxit generation endpoint.
 // The concrete API contract can vary; we send image and a JSON layout description.
-func generateWithFoxit(ctx context.Context, imageBytes []byte, items []ReportItem) ([]byte, error) {
+func generateWithFoxit(ctx context.Context, imageBytes []byte, items []ReportItem, images []AlertImage) ([]byte, error) {
 	url := os.Getenv("FOXIT_API_URL")
 	if url == "" {
 		url = "https://na1.fusion.foxit.com/pdf-services/api/documents/create/pdf-from-html"
@@ -66,8 +93,8 @@ func generateWithFoxit(ctx context.Context, imageBytes []byte, items []ReportIte
 	if downloadBase == "" {
 		downloadBase = "https://na1.fusion.foxit.com/pdf-services/api/documents"
 	}
-	apiKey := os.Getenv("FOXIT_CLIENT_ID")
-	apiSecret := os.Getenv("FOXIT_CLIENT_SECRET")
+	apiKey := secretValue("FOXIT_CLIENT_ID")
+	apiSecret := secretValue("FOXIT_CLIENT_SECRET")
 	if apiKey == "" || apiSecret == "" {
 		return nil, errors.New("foxit api not configured")
 	}
@@ -89,6 +116,19 @@ func generateWithFoxit(ctx context.Context, imageBytes []byte, items []ReportIte
 		rows.WriteString(fmt.Sprintf("<td>%.2f</td>", it.PredictedValue))
 		rows.WriteString("</tr>")
 	}
+	var imagery bytes.Buffer
+	for _, img := range images {
+		if img.URL == "" {
+			continue
+		}
+		if imagery.Len() == 0 {
+			imagery.WriteString("<h2>Imagery</h2><ul>")
+		}
+		imagery.WriteString(`<li><a href="` + htmlEscape(img.URL) + `">` + htmlEscape(reportImageLabel(img)) + "</a></li>")
+	}
+	if imagery.Len() > 0 {
+		imagery.WriteString("</ul>")
+	}
 
 	html := `<!DOCTYPE html>
 <html>
@@ -102,6 +142,8 @@ func generateWithFoxit(ctx context.Context, imageBytes []byte, items []ReportIte
     table { width: 100%; border-collapse: collapse; }
     th, td { border: 1px solid #333; padding: 6px; font-size: 12px; }
     th { background: #f0f0f0; text-align: left; }
+    h2 { font-size: 14px; margin: 16px 0 6px 0; }
+    li { font-size: 12px; }
   </style>
 </head>
 <body>
@@ -120,6 +162,7 @@ func generateWithFoxit(ctx context.Context, imageBytes []byte, items []ReportIte
       ` + rows.String() + `
     </tbody>
   </table>
+  ` + imagery.String() + `
 </body>
 </html>`
 
@@ -162,7 +205,7 @@ func foxitStartCreateTask(ctx context.Context, createURL, clientID, clientSecret
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("client_id", clientID)
 	req.Header.Set("client_secret", clientSecret)
-	resp, err := (&http.Client{Timeout: 20 * time.Second}).Do(req)
+	resp, err := foxitClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -197,7 +240,7 @@ func foxitPollTask(ctx context.Context, tasksBase, clientID, clientSecret, taskI
 		}
 		req.Header.Set("client_id", clientID)
 		req.Header.Set("client_secret", clientSecret)
-		resp, err := (&http.Client{Timeout: 20 * time.Second}).Do(req)
+		resp, err := foxitClient.Do(req)
 		if err != nil {
 			time.Sleep(interval)
 			continue
@@ -234,7 +277,7 @@ func foxitDownload(ctx context.Context, downloadBase, resultDocumentID, clientID
 	}
 	req.Header.Set("client_id", clientID)
 	req.Header.Set("client_secret", clientSecret)
-	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	resp, err := foxitClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -278,7 +321,7 @@ func foxitUploadHTML(ctx context.Context, uploadURL, apiKey, apiSecret, html str
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 	req.Header.Set("client_id", apiKey)
 	req.Header.Set("client_secret", apiSecret)
-	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	resp, err := foxitClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -300,7 +343,7 @@ func foxitUploadHTML(ctx context.Context, uploadURL, apiKey, apiSecret, html str
 }
 
 // generateWithLocal draws a PDF with title on top, image below, and table under the image.
-func generateWithLocal(imageBytes []byte, items []ReportItem) ([]byte, error) {
+func generateWithLocal(imageBytes []byte, items []ReportItem, images []AlertImage) ([]byte, error) {
 	// Validate image decodability early
 	imgDecoded, _, err := image.Decode(bytes.NewReader(imageBytes))
 	if err != nil {
@@ -367,6 +410,24 @@ func generateWithLocal(imageBytes []byte, items []ReportItem) ([]byte, error) {
 		y += rowH + 2
 	}
 
+	// Imagery links below the table
+	heading := false
+	for _, img := range images {
+		if img.URL == "" {
+			continue
+		}
+		if !heading {
+			heading = true
+			pdf.SetFont("Arial", "B", 11)
+			pdf.SetXY(left, y)
+			pdf.CellFormat(usableW, 8, "Imagery", "", 1, "L", false, 0, "")
+			pdf.SetFont("Arial", "U", 10)
+			pdf.SetTextColor(0, 0, 200)
+		}
+		pdf.SetX(left)
+		pdf.CellFormat(usableW, 6, reportImageLabel(img), "", 1, "L", false, 0, img.URL)
+	}
+
 	var out bytes.Buffer
 	if err := pdf.Output(&out); err != nil {
 		return nil, err
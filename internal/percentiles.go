@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"aquawatch/internal/cache"
+)
+
+// Percentile context tells how unusual a reading is for its site: "98th
+// percentile for this date" compares it with the USGS daily statistics of
+// the same calendar day over the years of record. Sites the statistics
+// service doesn't cover fall back to the daily means kept in station-stats
+// (the last year), which says less but still ranks the reading.
+
+// Percentile bases.
+const (
+	PercentileBasisDailyStats = "usgs_daily_stats"
+	PercentileBasisHistory    = "station_history"
+)
+
+// minHistoryDays is the fewest stored daily means a history percentile is
+// computed from.
+const minHistoryDays = 30
+
+// PercentileContext ranks a value within a site's historical distribution.
+type PercentileContext struct {
+	// Percentile is 0-100, rounded to one decimal.
+	Percentile float64 `dynamodbav:"percentile" json:"percentile"`
+	Basis      string  `dynamodbav:"basis" json:"basis"`
+	// Day is the calendar day compared (MM-DD) for daily statistics.
+	Day string `dynamodbav:"day,omitempty" json:"day,omitempty"`
+	// Years of record behind daily statistics, or Days of stored history.
+	Years int `dynamodbav:"years,omitempty" json:"years,omitempty"`
+	Days  int `dynamodbav:"days,omitempty" json:"days,omitempty"`
+}
+
+// Describe formats the context to follow "observed value is": "at the
+// 98th percentile for this date (52 years of record)". Values outside the
+// record are said to be.
+func (p *PercentileContext) Describe() string {
+	rank := ordinal(int(math.Round(p.Percentile)))
+	if p.Basis == PercentileBasisDailyStats {
+		record := fmt.Sprintf("for this date (%d years of record)", p.Years)
+		switch {
+		case p.Percentile >= 100:
+			return "at or above the record maximum " + record
+		case p.Percentile <= 0:
+			return "at or below the record minimum " + record
+		}
+		return fmt.Sprintf("at the %s percentile %s", rank, record)
+	}
+	switch {
+	case p.Percentile >= 100:
+		return fmt.Sprintf("above every daily mean of the last %d days", p.Days)
+	case p.Percentile <= 0:
+		return fmt.Sprintf("below every daily mean of the last %d days", p.Days)
+	}
+	return fmt.Sprintf("at the %s percentile of the last %d days", rank, p.Days)
+}
+
+func ordinal(n int) string {
+	suffix := "th"
+	switch n % 10 {
+	case 1:
+		suffix = "st"
+	case 2:
+		suffix = "nd"
+	case 3:
+		suffix = "rd"
+	}
+	if n%100 >= 11 && n%100 <= 13 {
+		suffix = "th"
+	}
+	return strconv.Itoa(n) + suffix
+}
+
+// dailyStat is one calendar day's row of the USGS daily statistics: value
+// quantiles over the years of record.
+type dailyStat struct {
+	// points are (value, percentile) pairs, ascending: min, p05 ... p95, max.
+	points [][2]float64
+	years  int
+}
+
+// dailyStatsCache holds each site's daily statistics by "MM-DD". They change
+// once a water year, so a day's staleness is nothing; sites without
+// statistics are cached as empty maps.
+var dailyStatsCache = cache.New[string, map[string]dailyStat]("usgs-daily-stats", 1000, 24*time.Hour)
+
+// ObservedPercentile ranks value, observed at at, for site and parameter.
+// It returns nil when neither basis is available; lookup failures are logged
+// and also return nil, so callers go on without context.
+func ObservedPercentile(ctx context.Context, site, parameter string, value float64, at time.Time) *PercentileContext {
+	stats, err := dailyStatsCache.GetOrLoad(ctx, site+"#"+parameter, func(ctx context.Context) (map[string]dailyStat, error) {
+		return fetchDailyStats(ctx, site, parameter)
+	})
+	if err != nil {
+		log.Printf("daily statistics lookup for %s failed: %v", site, err)
+	}
+	day := at.UTC().Format("01-02")
+	if s, ok := stats[day]; ok && len(s.points) > 1 {
+		return &PercentileContext{
+			Percentile: math.Round(interpolatePercentile(s.points, value)*10) / 10,
+			Basis:      PercentileBasisDailyStats,
+			Day:        day,
+			Years:      s.years,
+		}
+	}
+
+	history, err := GetStationStats(ctx, site, parameter)
+	if err != nil {
+		if !errors.Is(err, ErrStationStatsNotFound) {
+			log.Printf("station history lookup for %s failed: %v", site, err)
+		}
+		return nil
+	}
+	if len(history.Daily) < minHistoryDays {
+		return nil
+	}
+	var below, equal int
+	for _, d := range history.Daily {
+		switch {
+		case d.Mean < value:
+			below++
+		case d.Mean == value:
+			equal++
+		}
+	}
+	rank := (float64(below) + float64(equal)/2) / float64(len(history.Daily)) * 100
+	return &PercentileContext{
+		Percentile: math.Round(rank*10) / 10,
+		Basis:      PercentileBasisHistory,
+		Days:       len(history.Daily),
+	}
+}
+
+// interpolatePercentile places value among points, interpolating linearly;
+// values beyond the record are 0 or 100.
+func interpolatePercentile(points [][2]float64, value float64) float64 {
+	if value <= points[0][0] {
+		return 0
+	}
+	for i := 1; i < len(points); i++ {
+		lo, hi := points[i-1], points[i]
+		if value <= hi[0] {
+			if hi[0] == lo[0] {
+				return hi[1]
+			}
+			return lo[1] + (value-lo[0])/(hi[0]-lo[0])*(hi[1]-lo[1])
+		}
+	}
+	return 100
+}
+
+func fetchDailyStats(ctx context.Context, site, parameter string) (map[string]dailyStat, error) {
+	ctx, cancel := withStageTimeout(ctx, StageFetch)
+	defer cancel()
+	url := fmt.Sprintf(
+		"https://waterservices.usgs.gov/nwis/stat/?format=rdb&sites=%s&parameterCd=%s&statReportType=daily&statTypeCd=all",
+		site,
+		parameter,
+	)
+	resp, err := usgsClient.Get(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("USGS statistics request failed for %s: %w", site, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// No statistics for this site and parameter.
+		return map[string]dailyStat{}, nil
+	default:
+		return nil, fmt.Errorf("USGS statistics non-OK status for %s: %d", site, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading USGS statistics response failed for %s: %w", site, err)
+	}
+	return parseDailyStats(body), nil
+}
+
+// dailyStatColumns are the quantile columns of the statistics service and
+// the percentile each stands for.
+var dailyStatColumns = []struct {
+	name       string
+	percentile float64
+}{
+	{"min_va", 0}, {"p05_va", 5}, {"p10_va", 10}, {"p20_va", 20}, {"p25_va", 25},
+	{"p50_va", 50}, {"p75_va", 75}, {"p80_va", 80}, {"p90_va", 90}, {"p95_va", 95}, {"max_va", 100},
+}
+
+// parseDailyStats reads a USGS statistics RDB listing into rows by "MM-DD".
+// When a site has several time series for the parameter, the first listed
+// wins. Blank quantiles are skipped.
+func parseDailyStats(rdb []byte) map[string]dailyStat {
+	out := map[string]dailyStat{}
+	var header []string
+	formatLine := false
+	sc := bufio.NewScanner(bytes.NewReader(rdb))
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if header == nil {
+			header, formatLine = fields, true
+			continue
+		}
+		if formatLine {
+			formatLine = false
+			continue
+		}
+		col := func(name string) string {
+			if i := slices.Index(header, name); i >= 0 && i < len(fields) {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		}
+		month, err1 := strconv.Atoi(col("month_nu"))
+		day, err2 := strconv.Atoi(col("day_nu"))
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		key := fmt.Sprintf("%02d-%02d", month, day)
+		if _, ok := out[key]; ok {
+			continue
+		}
+		var s dailyStat
+		for _, c := range dailyStatColumns {
+			v, err := strconv.ParseFloat(col(c.name), 64)
+			if err != nil {
+				continue
+			}
+			if n := len(s.points); n > 0 && v < s.points[n-1][0] {
+				continue
+			}
+			s.points = append(s.points, [2]float64{v, c.percentile})
+		}
+		s.years, _ = strconv.Atoi(col("count_nu"))
+		out[key] = s
+	}
+	return out
+}
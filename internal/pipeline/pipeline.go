@@ -0,0 +1,356 @@
+// Package pipeline defines the payloads exchanged between the API, the Step
+// Functions state machine (infra/state_machine/aquawatch.json) and the
+// pipeline lambdas. JSON field names here must match the state machine's
+// Parameters blocks; keeping one definition per payload means drift between
+// steps shows up as a compile error rather than a silently empty field.
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the current version of ExecutionInput. Bump it when a
+// change is not backward compatible with executions already in flight.
+const SchemaVersion = "1"
+
+// ErrInvalidInput is matched (via errors.Is) by ValidationError.
+var ErrInvalidInput = errors.New("invalid pipeline input")
+
+// ValidationError reports the first invalid field of a payload.
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid pipeline input: %s %s", e.Field, e.Reason)
+}
+
+// Is reports whether target is ErrInvalidInput.
+func (e *ValidationError) Is(target error) bool { return target == ErrInvalidInput }
+
+var (
+	siteIDPattern    = regexp.MustCompile(`^[0-9]{8,15}$`)
+	parameterPattern = regexp.MustCompile(`^[0-9]{5}$`)
+)
+
+func invalid(field, reason string) error {
+	return &ValidationError{Field: field, Reason: reason}
+}
+
+func validateSites(field string, sites []string) error {
+	if len(sites) == 0 {
+		return invalid(field, "is required")
+	}
+	for _, s := range sites {
+		if !siteIDPattern.MatchString(s) {
+			return invalid(field, fmt.Sprintf("has invalid site id %q", s))
+		}
+	}
+	return nil
+}
+
+func required(field, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return invalid(field, "is required")
+	}
+	return nil
+}
+
+func validateS3URI(field, value string) error {
+	if !strings.HasPrefix(value, "s3://") {
+		return invalid(field, "must be an s3:// URI")
+	}
+	return nil
+}
+
+// ValidateSelection checks the site IDs and parameter code of a run, for
+// callers that split work before building an ExecutionInput.
+func ValidateSelection(sites []string, parameter string) error {
+	if err := validateSites("station", sites); err != nil {
+		return err
+	}
+	return ValidateParameter(parameter)
+}
+
+// DateLayout is the layout of the Start and End days of a run.
+const DateLayout = "2006-01-02"
+
+// validateWindow checks a run's optional window of days: both or neither of
+// start and end, in order.
+func validateWindow(start, end string) error {
+	if start == "" && end == "" {
+		return nil
+	}
+	from, err := time.Parse(DateLayout, start)
+	if err != nil {
+		return invalid("start", "must be YYYY-MM-DD")
+	}
+	to, err := time.Parse(DateLayout, end)
+	if err != nil {
+		return invalid("end", "must be YYYY-MM-DD")
+	}
+	if to.Before(from) {
+		return invalid("end", "is before start")
+	}
+	return nil
+}
+
+// ParseWindow parses a run's window of days; ok is false when none is set
+// (the last 30 days are fetched) or it is invalid.
+func ParseWindow(start, end string) (from, to time.Time, ok bool) {
+	if validateWindow(start, end) != nil || start == "" {
+		return time.Time{}, time.Time{}, false
+	}
+	from, _ = time.Parse(DateLayout, start)
+	to, _ = time.Parse(DateLayout, end)
+	return from, to, true
+}
+
+// ValidateParameter checks a USGS parameter code.
+func ValidateParameter(parameter string) error {
+	if !parameterPattern.MatchString(parameter) {
+		return invalid("parameter", "must be a 5-digit USGS parameter code")
+	}
+	return nil
+}
+
+// ExecutionInput is the input of a state machine execution, built by the
+// API's /ingest handler. DryRun runs Preprocess alone, writing nothing, and
+// ends the execution with its DryRunReport. Start and End (YYYY-MM-DD) fetch
+// the daily values of those days instead of the last 30, e.g. to backfill a
+// historical window for training. Both are always sent, empty for the
+// default window, because the state machine reads them.
+type ExecutionInput struct {
+	SchemaVersion        string   `json:"schemaVersion"`
+	Station              []string `json:"station"`
+	Parameter            string   `json:"parameter"`
+	Bucket               string   `json:"bucket"`
+	ProcessedKey         string   `json:"processedKey"`
+	ManifestKey          string   `json:"manifestKey"`
+	ModelOutputPath      string   `json:"modelOutputPath"`
+	DefaultModelArtifact string   `json:"defaultModelArtifact"`
+	Train                bool     `json:"train"`
+	DryRun               bool     `json:"dryRun"`
+	Start                string   `json:"start"`
+	End                  string   `json:"end"`
+}
+
+// Validate checks every field the state machine reads. An empty
+// SchemaVersion is accepted as the current version.
+func (in ExecutionInput) Validate() error {
+	if in.SchemaVersion != "" && in.SchemaVersion != SchemaVersion {
+		return invalid("schemaVersion", fmt.Sprintf("%q is not supported (want %q)", in.SchemaVersion, SchemaVersion))
+	}
+	if err := ValidateSelection(in.Station, in.Parameter); err != nil {
+		return err
+	}
+	if err := validateWindow(in.Start, in.End); err != nil {
+		return err
+	}
+	if err := required("bucket", in.Bucket); err != nil {
+		return err
+	}
+	if err := required("processedKey", in.ProcessedKey); err != nil {
+		return err
+	}
+	if err := required("manifestKey", in.ManifestKey); err != nil {
+		return err
+	}
+	if err := validateS3URI("modelOutputPath", in.ModelOutputPath); err != nil {
+		return err
+	}
+	return validateS3URI("defaultModelArtifact", in.DefaultModelArtifact)
+}
+
+// PreprocessInput is the Preprocess state's payload. RunID is the execution
+// name, so a retried invocation replaces its own dataset part; ExecutionArn
+// identifies the run's pipeline-runs record. DryRun fetches and builds the
+// rows but stores nothing. Start and End, when set, are the days to fetch
+// (see ExecutionInput).
+type PreprocessInput struct {
+	Station      []string `json:"station"`
+	Parameter    string   `json:"parameter"`
+	Bucket       string   `json:"bucket"`
+	ProcessedKey string   `json:"processedKey"`
+	RunID        string   `json:"runId,omitempty"`
+	ExecutionArn string   `json:"executionArn,omitempty"`
+	DryRun       bool     `json:"dryRun,omitempty"`
+	Start        string   `json:"start,omitempty"`
+	End          string   `json:"end,omitempty"`
+}
+
+// Validate checks the fields the preprocess lambda needs.
+func (in PreprocessInput) Validate() error {
+	if err := validateSites("station", in.Station); err != nil {
+		return err
+	}
+	if err := required("parameter", in.Parameter); err != nil {
+		return err
+	}
+	if err := validateWindow(in.Start, in.End); err != nil {
+		return err
+	}
+	if err := required("bucket", in.Bucket); err != nil {
+		return err
+	}
+	return required("processedKey", in.ProcessedKey)
+}
+
+// PreprocessOutput is returned by the preprocess lambda.
+type PreprocessOutput struct {
+	PartKey        string `json:"partKey"`
+	Bytes          int    `json:"bytes"`
+	DataSource     string `json:"dataSource"`
+	FallbackPolicy string `json:"fallbackPolicy,omitempty"`
+	// Evaluated and Anomalies count the stations checked against their
+	// latest prediction on ingest (STREAM_EVALUATION_ENABLED).
+	Evaluated int `json:"evaluated,omitempty"`
+	Anomalies int `json:"anomalies,omitempty"`
+	// Report is set for dry runs.
+	Report *DryRunReport `json:"report,omitempty"`
+}
+
+// DryRunReport describes the rows a dry run would have written.
+type DryRunReport struct {
+	DataSource string `json:"dataSource"`
+	// Columns of each row, label first.
+	Columns  []string        `json:"columns"`
+	Rows     int             `json:"rows"`
+	Bytes    int             `json:"bytes"`
+	Stations []DryRunStation `json:"stations"`
+}
+
+// DryRunStation is one station's observations in a DryRunReport. First and
+// Last are RFC 3339 times, empty when the station returned no rows.
+type DryRunStation struct {
+	Site  string `json:"site"`
+	Name  string `json:"name,omitempty"`
+	Rows  int    `json:"rows"`
+	First string `json:"first,omitempty"`
+	Last  string `json:"last,omitempty"`
+}
+
+// Train lambda actions: "start" creates the training job, "status" reports
+// its progress until the state machine's polling loop sees it finish.
+const (
+	TrainActionStart  = "start"
+	TrainActionStatus = "status"
+)
+
+// TrainInput is the payload of the Train and CheckTraining states. Start
+// needs the dataset and output locations; status needs TrainingJobName.
+// RunID is the execution name, from which the job name is derived;
+// ExecutionArn identifies the run for progress events. When ProcessedKey is
+// set, start trains from a snapshot of the dataset's manifest instead of
+// ManifestKey.
+type TrainInput struct {
+	Action          string   `json:"action"`
+	Bucket          string   `json:"bucket,omitempty"`
+	ProcessedKey    string   `json:"processedKey,omitempty"`
+	ManifestKey     string   `json:"manifestKey,omitempty"`
+	ModelOutputPath string   `json:"modelOutputPath,omitempty"`
+	Sites           []string `json:"sites,omitempty"`
+	Parameter       string   `json:"parameter,omitempty"`
+	RunID           string   `json:"runId,omitempty"`
+	ExecutionArn    string   `json:"executionArn,omitempty"`
+	TrainingJobName string   `json:"trainingJobName,omitempty"`
+}
+
+// Validate checks the fields the train lambda needs for the action.
+func (in TrainInput) Validate() error {
+	switch in.Action {
+	case TrainActionStart:
+		if err := validateSites("sites", in.Sites); err != nil {
+			return err
+		}
+		if err := required("bucket", in.Bucket); err != nil {
+			return err
+		}
+		if err := required("manifestKey", in.ManifestKey); err != nil {
+			return err
+		}
+		return validateS3URI("modelOutputPath", in.ModelOutputPath)
+	case TrainActionStatus:
+		return required("trainingJobName", in.TrainingJobName)
+	}
+	return invalid("action", fmt.Sprintf("%q is not one of start, status", in.Action))
+}
+
+// ModelArtifacts mirrors SageMaker's field of the same name, so Infer reads
+// $.trainResult.ModelArtifacts.S3ModelArtifacts whether the model was just
+// trained or is the default artifact.
+type ModelArtifacts struct {
+	S3ModelArtifacts string `json:"S3ModelArtifacts"`
+}
+
+// TrainOutput is returned by the train lambda. Fields are never omitted
+// because the state machine selects each of them.
+type TrainOutput struct {
+	TrainingJobName   string         `json:"TrainingJobName"`
+	TrainingJobStatus string         `json:"TrainingJobStatus"`
+	ModelArtifacts    ModelArtifacts `json:"ModelArtifacts"`
+	FailureReason     string         `json:"FailureReason"`
+}
+
+// TrainTrackerInput is the RecordTrainModel state's payload. CreatedOn is
+// epoch ms and defaults to now. TrainingJobName, when set, is the registry
+// key of the model; ModelArtifact is its S3 URI. Bucket and ProcessedKey
+// locate the dataset the model was trained on.
+type TrainTrackerInput struct {
+	CreatedOn       int64    `json:"createdon,omitempty"`
+	Sites           []string `json:"sites,omitempty"`
+	Parameter       string   `json:"parameter,omitempty"`
+	TrainingJobName string   `json:"trainingJobName,omitempty"`
+	ModelArtifact   string   `json:"modelArtifact,omitempty"`
+	Bucket          string   `json:"bucket,omitempty"`
+	ProcessedKey    string   `json:"processedKey,omitempty"`
+}
+
+// Validate checks the optional site list.
+func (in TrainTrackerInput) Validate() error {
+	if len(in.Sites) == 0 {
+		return nil
+	}
+	return validateSites("sites", in.Sites)
+}
+
+// InferInput is the Infer state's payload. S3ModelArtifacts is the model
+// trained in this execution, or the default artifact when training was
+// skipped. MaxRows limits inference to the newest rows (0 uses
+// INFER_MAX_ROWS, or the whole dataset). RunID is the execution name and
+// ExecutionArn its ARN.
+type InferInput struct {
+	Bucket           string   `json:"bucket"`
+	ProcessedKey     string   `json:"processed_key"`
+	S3ModelArtifacts string   `json:"s3_model_artifacts,omitempty"`
+	Sites            []string `json:"sites"`
+	MaxRows          int      `json:"max_rows,omitempty"`
+	RunID            string   `json:"run_id,omitempty"`
+	ExecutionArn     string   `json:"execution_arn,omitempty"`
+}
+
+// Validate checks the fields the infer lambda needs.
+func (in InferInput) Validate() error {
+	if err := required("bucket", in.Bucket); err != nil {
+		return err
+	}
+	if err := required("processed_key", in.ProcessedKey); err != nil {
+		return err
+	}
+	if in.MaxRows < 0 {
+		return invalid("max_rows", "must not be negative")
+	}
+	return nil
+}
+
+// InferOutput is returned by the infer lambda.
+type InferOutput struct {
+	Model       string `json:"model"`
+	Rows        int    `json:"rows"`
+	Predictions int    `json:"predictions"`
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Sources of pipeline failure records.
+const (
+	PipelineErrorSourceStepFunctions = "stepfunctions"
+	PipelineErrorSourceDLQ           = "dlq"
+)
+
+// defaultPipelineErrorTTLDays bounds how long failure records are kept;
+// override with PIPELINE_ERROR_TTL_DAYS (0 keeps them forever).
+const defaultPipelineErrorTTLDays = 90
+
+// maxPipelineErrorPayload bounds the failed payload stored with a DLQ record.
+const maxPipelineErrorPayload = 4096
+
+// PipelineError is a failed execution or a lambda invocation that landed in a
+// dead-letter queue. ErrorID is the delivering event's ID (EventBridge event
+// ID or SQS message ID), so redelivered events are recorded and announced
+// once.
+// Table name defaults to "pipeline-errors"; override with
+// PIPELINE_ERRORS_TABLE. Keys: PK error_id.
+type PipelineError struct {
+	ErrorID      string `dynamodbav:"error_id" json:"error_id"`
+	Source       string `dynamodbav:"source" json:"source"`
+	ExecutionArn string `dynamodbav:"execution_arn,omitempty" json:"execution_arn,omitempty"`
+	Status       string `dynamodbav:"status,omitempty" json:"status,omitempty"`
+	State        string `dynamodbav:"state,omitempty" json:"state,omitempty"`
+	Queue        string `dynamodbav:"queue,omitempty" json:"queue,omitempty"`
+	Error        string `dynamodbav:"error,omitempty" json:"error,omitempty"`
+	Cause        string `dynamodbav:"cause,omitempty" json:"cause,omitempty"`
+	Payload      string `dynamodbav:"payload,omitempty" json:"payload,omitempty"`
+	CreatedOn    int64  `dynamodbav:"createdon" json:"createdon_ms"`
+	ExpiresAt    int64  `dynamodbav:"expires_at,omitempty" json:"-"`
+}
+
+func pipelineErrorsTable() string {
+	return tableName("PIPELINE_ERRORS_TABLE", "pipeline-errors")
+}
+
+// PipelineErrorRetention returns the retention policy for the pipeline-errors table.
+func PipelineErrorRetention() RetentionConfig {
+	return RetentionConfig{Table: pipelineErrorsTable(), TTL: retentionFromEnv("PIPELINE_ERROR_TTL_DAYS", defaultPipelineErrorTTLDays)}
+}
+
+// RecordPipelineFailure stores e and publishes an operator alert for it.
+// Step Functions failures are first enriched from the execution history
+// (failing state and its error cause), which also refreshes the run in the
+// pipeline-runs table. A failure already recorded under the same ErrorID is
+// skipped, so retried deliveries don't page twice.
+func RecordPipelineFailure(ctx context.Context, e PipelineError) error {
+	if e.ErrorID == "" {
+		return errors.New("pipeline error id is required")
+	}
+	if e.Source == PipelineErrorSourceStepFunctions && e.ExecutionArn != "" {
+		if run, err := GetExecutionStatus(ctx, e.ExecutionArn); err != nil {
+			log.Printf("describe failed execution %s: %v", e.ExecutionArn, err)
+		} else {
+			e.State = run.CurrentStep
+			if e.Error == "" {
+				e.Error, e.Cause = run.Error, run.Cause
+			}
+		}
+	}
+	if len(e.Payload) > maxPipelineErrorPayload {
+		e.Payload = e.Payload[:maxPipelineErrorPayload]
+	}
+	retention := PipelineErrorRetention()
+	now := time.Now().UTC()
+	e.CreatedOn = now.UnixMilli()
+	e.ExpiresAt = retention.ExpiresAt(now)
+
+	err := newRepository[PipelineError](retention.Table).Create(ctx, e, "error_id")
+	if errors.Is(err, ErrAlreadyExists) {
+		log.Printf("pipeline error %s already recorded", e.ErrorID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return PublishOperatorAlert(ctx, e.alertSubject(), e.alertMessage())
+}
+
+func (e PipelineError) alertSubject() string {
+	subject := "AquaWatch pipeline failure"
+	if e.Source == PipelineErrorSourceDLQ {
+		subject = "AquaWatch dead-lettered invocation"
+	}
+	if e.Status != "" {
+		subject += " (" + e.Status + ")"
+	}
+	return subject
+}
+
+func (e PipelineError) alertMessage() string {
+	var b strings.Builder
+	line := func(label, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", label, value)
+		}
+	}
+	line("Execution", e.ExecutionArn)
+	line("Status", e.Status)
+	line("Failed state", e.State)
+	line("Queue", e.Queue)
+	line("Error", e.Error)
+	line("Cause", e.Cause)
+	line("Record", e.ErrorID)
+	return b.String()
+}
@@ -0,0 +1,385 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"aquawatch/internal/pipeline"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	sfntypes "github.com/aws/aws-sdk-go-v2/service/sfn/types"
+)
+
+// Pipeline run statuses, as reported by Step Functions.
+const (
+	PipelineRunRunning   = string(sfntypes.ExecutionStatusRunning)
+	PipelineRunSucceeded = string(sfntypes.ExecutionStatusSucceeded)
+	PipelineRunFailed    = string(sfntypes.ExecutionStatusFailed)
+	PipelineRunTimedOut  = string(sfntypes.ExecutionStatusTimedOut)
+	PipelineRunAborted   = string(sfntypes.ExecutionStatusAborted)
+)
+
+// Step statuses within a pipeline run.
+const (
+	PipelineStepRunning   = "running"
+	PipelineStepSucceeded = "succeeded"
+	PipelineStepFailed    = "failed"
+)
+
+// ErrPipelineRunNotFound is returned for executions Step Functions doesn't
+// know.
+var ErrPipelineRunNotFound = notFoundError("pipeline run not found")
+
+// defaultPipelineRunTTLDays bounds how long run records are kept; override
+// with PIPELINE_RUN_TTL_DAYS (0 keeps them forever).
+const defaultPipelineRunTTLDays = 30
+
+// PipelineStep is one state of the state machine as entered by a run.
+type PipelineStep struct {
+	Name      string `dynamodbav:"name" json:"name"`
+	Status    string `dynamodbav:"status" json:"status"`
+	EnteredOn int64  `dynamodbav:"enteredon" json:"enteredon_ms"`
+	ExitedOn  int64  `dynamodbav:"exitedon,omitempty" json:"exitedon_ms,omitempty"`
+	Error     string `dynamodbav:"error,omitempty" json:"error,omitempty"`
+	Cause     string `dynamodbav:"cause,omitempty" json:"cause,omitempty"`
+}
+
+// PipelineRun is the progress of one state machine execution.
+// Table name defaults to "pipeline-runs"; override with PIPELINE_RUNS_TABLE.
+// Keys: PK execution_arn.
+type PipelineRun struct {
+	ExecutionArn string         `dynamodbav:"execution_arn" json:"execution_arn"`
+	Name         string         `dynamodbav:"name" json:"name"`
+	Sites        []string       `dynamodbav:"sites,omitempty" json:"sites,omitempty"`
+	Parameter    string         `dynamodbav:"parameter,omitempty" json:"parameter,omitempty"`
+	Train        bool           `dynamodbav:"train" json:"train"`
+	Status       string         `dynamodbav:"status" json:"status"`
+	CurrentStep  string         `dynamodbav:"current_step,omitempty" json:"current_step,omitempty"`
+	Steps        []PipelineStep `dynamodbav:"steps,omitempty" json:"steps"`
+	Error        string         `dynamodbav:"error,omitempty" json:"error,omitempty"`
+	Cause        string         `dynamodbav:"cause,omitempty" json:"cause,omitempty"`
+	// DataSource is where preprocess got the run's rows (usgs_dv, usgs_iv,
+	// last_good, synthetic); FallbackPolicy is set when the upstream feeds
+	// failed and a fallback policy supplied them.
+	DataSource     string `dynamodbav:"data_source,omitempty" json:"data_source,omitempty"`
+	FallbackPolicy string `dynamodbav:"fallback_policy,omitempty" json:"fallback_policy,omitempty"`
+	// DryRun marks runs that wrote nothing; DryRunReport is what their
+	// preprocess step would have written.
+	DryRun       bool                   `dynamodbav:"dry_run,omitempty" json:"dry_run,omitempty"`
+	DryRunReport *pipeline.DryRunReport `dynamodbav:"dry_run_report,omitempty" json:"dry_run_report,omitempty"`
+	StartedOn    int64                  `dynamodbav:"startedon" json:"startedon_ms"`
+	StoppedOn    int64                  `dynamodbav:"stoppedon,omitempty" json:"stoppedon_ms,omitempty"`
+	UpdatedOn    int64                  `dynamodbav:"updatedon" json:"updatedon_ms"`
+	ExpiresAt    int64                  `dynamodbav:"expires_at,omitempty" json:"-"`
+	// GSIPK is the constant partition of gsi_started, which orders all runs
+	// by start time for ListPipelineRunsPage.
+	GSIPK string `dynamodbav:"gsi_pk,omitempty" json:"-"`
+	// DurationMs is derived on read: stop (or last update, while running)
+	// minus start.
+	DurationMs int64 `dynamodbav:"-" json:"duration_ms"`
+}
+
+// pipelineRunsStartedIndex is the GSI (HASH gsi_pk, RANGE startedon) used to
+// list runs by start time.
+const (
+	pipelineRunsStartedIndex = "gsi_started"
+	pipelineRunsPartition    = "run"
+)
+
+// Done reports whether the run has reached a terminal status.
+func (r *PipelineRun) Done() bool {
+	return r.Status != "" && r.Status != PipelineRunRunning
+}
+
+func pipelineRunsTable() string {
+	return tableName("PIPELINE_RUNS_TABLE", "pipeline-runs")
+}
+
+// PipelineRunRetention returns the retention policy for the pipeline-runs table.
+func PipelineRunRetention() RetentionConfig {
+	return RetentionConfig{Table: pipelineRunsTable(), TTL: retentionFromEnv("PIPELINE_RUN_TTL_DAYS", defaultPipelineRunTTLDays)}
+}
+
+// RecordPipelineRunStarted stores a RUNNING record for an execution that was
+// just started, so its progress can be reported before the first refresh.
+func RecordPipelineRunStarted(ctx context.Context, executionArn string, sites []string, parameter string, train, dryRun bool) error {
+	retention := PipelineRunRetention()
+	now := time.Now().UTC()
+	return savePipelineRun(ctx, PipelineRun{
+		ExecutionArn: executionArn,
+		Name:         executionName(executionArn),
+		Sites:        sites,
+		Parameter:    parameter,
+		Train:        train,
+		DryRun:       dryRun,
+		Status:       PipelineRunRunning,
+		StartedOn:    now.UnixMilli(),
+		UpdatedOn:    now.UnixMilli(),
+		ExpiresAt:    retention.ExpiresAt(now),
+	})
+}
+
+// savePipelineRun writes run, indexing it in gsi_started.
+func savePipelineRun(ctx context.Context, run PipelineRun) error {
+	run.GSIPK = pipelineRunsPartition
+	return newRepository[PipelineRun](pipelineRunsTable()).Put(ctx, run)
+}
+
+// withDuration fills in DurationMs.
+func (r *PipelineRun) withDuration() *PipelineRun {
+	end := r.StoppedOn
+	if end == 0 {
+		end = r.UpdatedOn
+	}
+	if r.StartedOn > 0 && end > r.StartedOn {
+		r.DurationMs = end - r.StartedOn
+	}
+	return r
+}
+
+// GetPipelineRun loads the stored record for an execution without contacting
+// Step Functions. Returns (nil, nil) if there is none.
+func GetPipelineRun(ctx context.Context, executionArn string) (*PipelineRun, error) {
+	run, err := newRepository[PipelineRun](pipelineRunsTable()).Get(ctx, map[string]any{"execution_arn": executionArn})
+	if run != nil {
+		run.withDuration()
+	}
+	return run, err
+}
+
+// PipelineRunFilter narrows ListPipelineRunsPage. Zero fields don't filter;
+// From and To bound the start time (inclusive).
+type PipelineRunFilter struct {
+	Site   string
+	Status string
+	From   time.Time
+	To     time.Time
+}
+
+// ListPipelineRunsPage lists stored runs newest first. Site and status are
+// applied as filter expressions after the index range is read, so a page
+// can hold fewer than limit runs while next is still non-empty. See
+// ListRecentAlertsPage for cursor semantics.
+func ListPipelineRunsPage(ctx context.Context, f PipelineRunFilter, limit int, cursor string) ([]PipelineRun, string, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	var from int64
+	if !f.From.IsZero() {
+		from = f.From.UnixMilli()
+	}
+	to := f.To
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	vals := map[string]any{":pk": pipelineRunsPartition, ":from": from, ":to": to.UnixMilli()}
+	names := map[string]string{}
+	var filters []string
+	if f.Site != "" {
+		filters = append(filters, "contains(sites, :site)")
+		vals[":site"] = f.Site
+	}
+	if f.Status != "" {
+		filters = append(filters, "#status = :status")
+		names["#status"] = "status"
+		vals[":status"] = f.Status
+	}
+	values, err := attributevalue.MarshalMap(vals)
+	if err != nil {
+		return nil, "", err
+	}
+	in := &dynamodb.QueryInput{
+		IndexName:                 awsString(pipelineRunsStartedIndex),
+		KeyConditionExpression:    awsString("gsi_pk = :pk AND startedon BETWEEN :from AND :to"),
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          awsBool(false),
+		Limit:                     awsInt32(int32(limit)),
+	}
+	if len(filters) > 0 {
+		in.FilterExpression = awsString(strings.Join(filters, " AND "))
+	}
+	if len(names) > 0 {
+		in.ExpressionAttributeNames = names
+	}
+	runs, next, err := newRepository[PipelineRun](pipelineRunsTable()).Query(ctx, in, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range runs {
+		runs[i].withDuration()
+	}
+	return runs, next, nil
+}
+
+// GetExecutionStatus returns the progress of an execution. Finished runs are
+// served from the pipeline-runs table; running (or unknown) ones are read
+// from Step Functions with DescribeExecution and GetExecutionHistory, and the
+// result is written back to the table.
+func GetExecutionStatus(ctx context.Context, executionArn string) (*PipelineRun, error) {
+	stored, err := GetPipelineRun(ctx, executionArn)
+	if err != nil {
+		return nil, err
+	}
+	if stored != nil && stored.Done() {
+		return stored, nil
+	}
+
+	run, err := describePipelineRun(ctx, executionArn)
+	if err != nil {
+		return nil, err
+	}
+	retention := PipelineRunRetention()
+	if stored != nil {
+		run.Sites, run.Parameter, run.Train = stored.Sites, stored.Parameter, stored.Train
+		run.DataSource, run.FallbackPolicy = stored.DataSource, stored.FallbackPolicy
+		run.DryRun, run.DryRunReport = stored.DryRun, stored.DryRunReport
+		run.ExpiresAt = stored.ExpiresAt
+	} else {
+		run.ExpiresAt = retention.ExpiresAt(time.UnixMilli(run.StartedOn))
+	}
+	if err := savePipelineRun(ctx, *run); err != nil {
+		return nil, err
+	}
+	return run.withDuration(), nil
+}
+
+// describePipelineRun builds a PipelineRun from the execution's description
+// and its event history.
+func describePipelineRun(ctx context.Context, executionArn string) (*PipelineRun, error) {
+	client := getSFNClient()
+	desc, err := client.DescribeExecution(ctx, &sfn.DescribeExecutionInput{ExecutionArn: &executionArn})
+	var missing *sfntypes.ExecutionDoesNotExist
+	var invalid *sfntypes.InvalidArn
+	if errors.As(err, &missing) || errors.As(err, &invalid) {
+		return nil, fmt.Errorf("%w: %s", ErrPipelineRunNotFound, executionArn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	run := &PipelineRun{
+		ExecutionArn: executionArn,
+		Name:         deref(desc.Name),
+		Status:       string(desc.Status),
+		Error:        deref(desc.Error),
+		Cause:        deref(desc.Cause),
+		UpdatedOn:    time.Now().UTC().UnixMilli(),
+	}
+	if desc.StartDate != nil {
+		run.StartedOn = desc.StartDate.UnixMilli()
+	}
+	if desc.StopDate != nil {
+		run.StoppedOn = desc.StopDate.UnixMilli()
+	}
+
+	paginator := sfn.NewGetExecutionHistoryPaginator(client, &sfn.GetExecutionHistoryInput{
+		ExecutionArn:         &executionArn,
+		IncludeExecutionData: awsBool(false),
+		MaxResults:           1000,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range page.Events {
+			applyHistoryEvent(run, ev)
+		}
+	}
+
+	for i := len(run.Steps) - 1; i >= 0; i-- {
+		step := run.Steps[i]
+		if step.Status == PipelineStepRunning || step.Status == PipelineStepFailed {
+			run.CurrentStep = step.Name
+			if run.Done() && step.Status == PipelineStepRunning {
+				run.Steps[i].Status = PipelineStepFailed
+			}
+			break
+		}
+	}
+	if run.Status == PipelineRunSucceeded {
+		run.CurrentStep = ""
+	}
+	return run, nil
+}
+
+// applyHistoryEvent folds one history event into run's step list: entering a
+// state opens a step, exiting closes it, and task or lambda failures record
+// their error on the open step.
+func applyHistoryEvent(run *PipelineRun, ev sfntypes.HistoryEvent) {
+	var at int64
+	if ev.Timestamp != nil {
+		at = ev.Timestamp.UnixMilli()
+	}
+	open := func() *PipelineStep {
+		for i := len(run.Steps) - 1; i >= 0; i-- {
+			if run.Steps[i].Status == PipelineStepRunning {
+				return &run.Steps[i]
+			}
+		}
+		return nil
+	}
+
+	if d := ev.StateEnteredEventDetails; d != nil {
+		run.Steps = append(run.Steps, PipelineStep{Name: deref(d.Name), Status: PipelineStepRunning, EnteredOn: at})
+		return
+	}
+	if d := ev.StateExitedEventDetails; d != nil {
+		if step := open(); step != nil && step.Name == deref(d.Name) {
+			step.Status = PipelineStepSucceeded
+			step.ExitedOn = at
+		}
+		return
+	}
+
+	errName, cause, failed := historyEventFailure(ev)
+	if !failed {
+		return
+	}
+	if step := open(); step != nil {
+		step.Status = PipelineStepFailed
+		step.ExitedOn = at
+		step.Error, step.Cause = errName, cause
+	}
+	if run.Error == "" {
+		run.Error, run.Cause = errName, cause
+	}
+}
+
+// historyEventFailure extracts the error and cause of a failure event.
+func historyEventFailure(ev sfntypes.HistoryEvent) (string, string, bool) {
+	switch {
+	case ev.TaskFailedEventDetails != nil:
+		return deref(ev.TaskFailedEventDetails.Error), deref(ev.TaskFailedEventDetails.Cause), true
+	case ev.TaskTimedOutEventDetails != nil:
+		return deref(ev.TaskTimedOutEventDetails.Error), deref(ev.TaskTimedOutEventDetails.Cause), true
+	case ev.LambdaFunctionFailedEventDetails != nil:
+		return deref(ev.LambdaFunctionFailedEventDetails.Error), deref(ev.LambdaFunctionFailedEventDetails.Cause), true
+	case ev.LambdaFunctionTimedOutEventDetails != nil:
+		return deref(ev.LambdaFunctionTimedOutEventDetails.Error), deref(ev.LambdaFunctionTimedOutEventDetails.Cause), true
+	case ev.ExecutionFailedEventDetails != nil:
+		return deref(ev.ExecutionFailedEventDetails.Error), deref(ev.ExecutionFailedEventDetails.Cause), true
+	case ev.ExecutionTimedOutEventDetails != nil:
+		return deref(ev.ExecutionTimedOutEventDetails.Error), deref(ev.ExecutionTimedOutEventDetails.Cause), true
+	case ev.ExecutionAbortedEventDetails != nil:
+		return deref(ev.ExecutionAbortedEventDetails.Error), deref(ev.ExecutionAbortedEventDetails.Cause), true
+	}
+	return "", "", false
+}
+
+// executionName returns the name segment of an execution ARN
+// (arn:aws:states:<region>:<account>:execution:<machine>:<name>).
+func executionName(executionArn string) string {
+	return executionArn[strings.LastIndex(executionArn, ":")+1:]
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
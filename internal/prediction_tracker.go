@@ -2,96 +2,180 @@ package internal
 
 import (
 	"context"
-	"os"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
+// Prediction tracker statuses. A run starts as "started" and transitions to
+// exactly one of "completed" or "failed".
+const (
+	PredictionStatusStarted   = "started"
+	PredictionStatusCompleted = "completed"
+	PredictionStatusFailed    = "failed"
+)
+
+// predictionTrackerSiteIndex is the GSI (HASH site, RANGE updatedon) used to
+// find the most recent record for a site regardless of status.
+const predictionTrackerSiteIndex = "gsi_site_updated"
+
 // PredictionTrackerItem represents an item in the prediction-tracker DynamoDB table.
 // Primary key: site (HASH), status (RANGE).
 type PredictionTrackerItem struct {
-	Site      string `dynamodbav:"site"`
-	Status    string `dynamodbav:"status"`
-	CreatedOn int64  `dynamodbav:"createdon"`
-	UpdatedOn int64  `dynamodbav:"updatedon"`
+	Site         string `dynamodbav:"site"`
+	Status       string `dynamodbav:"status"`
+	CreatedOn    int64  `dynamodbav:"createdon"`
+	UpdatedOn    int64  `dynamodbav:"updatedon"`
+	ErrorMessage string `dynamodbav:"error_message,omitempty"`
+	// ExecutionArn is the state machine execution of the run, whose
+	// progress is tracked in the pipeline-runs table.
+	ExecutionArn string `dynamodbav:"execution_arn,omitempty"`
+	// Version increments on every start of the site's run; outcome records
+	// carry the started version they were written against.
+	Version   int64 `dynamodbav:"version"`
+	ExpiresAt int64 `dynamodbav:"expires_at,omitempty"`
 }
 
-// AddPredictionTrackerStarted inserts a new entry into the prediction-tracker table
+// AddPredictionTrackerStarted upserts an entry into the prediction-tracker table
 // with status set to "started" and both createdon/updatedon set to the current
-// epoch time in milliseconds.
+// epoch time in milliseconds. Each call bumps the item's version so outcomes
+// recorded for an older run can be detected. executionArn links the record
+// to the run's execution (see GetExecutionStatus).
 //
 // The table name can be overridden with PREDICTION_TRACKER_TABLE env var;
-// defaults to "prediction-tracker".
-func AddPredictionTrackerStarted(ctx context.Context, site string) error {
-	cfg := getAWSConfig()
-	client := dynamodb.NewFromConfig(cfg)
-
-	table := os.Getenv("PREDICTION_TRACKER_TABLE")
-	if table == "" {
-		table = "prediction-tracker"
+// defaults to "prediction-tracker". Items expire per PREDICTION_TRACKER_TTL_DAYS.
+func AddPredictionTrackerStarted(ctx context.Context, site, executionArn string) error {
+	retention := PredictionTrackerRetention()
+	now := time.Now().UTC()
+	nowEpochMs := now.UnixMilli()
+	key, err := attributevalue.MarshalMap(predictionTrackerKey{Site: site, Status: PredictionStatusStarted})
+	if err != nil {
+		return err
 	}
-
-	nowEpochMs := time.Now().UTC().UnixMilli()
-	item := PredictionTrackerItem{
-		Site:      site,
-		Status:    "started",
-		CreatedOn: nowEpochMs,
-		UpdatedOn: nowEpochMs,
+	update := "SET createdon = :now, updatedon = :now, execution_arn = :exec ADD #version :one"
+	values := map[string]any{":now": nowEpochMs, ":exec": executionArn, ":one": 1}
+	if exp := retention.ExpiresAt(now); exp > 0 {
+		update = "SET createdon = :now, updatedon = :now, execution_arn = :exec, expires_at = :exp ADD #version :one"
+		values[":exp"] = exp
 	}
-
-	av, err := attributevalue.MarshalMap(item)
+	av, err := attributevalue.MarshalMap(values)
 	if err != nil {
 		return err
 	}
-
-	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: &table,
-		Item:      av,
+	_, err = getDynamoClient().UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 &retention.Table,
+		Key:                       key,
+		UpdateExpression:          awsString(update),
+		ExpressionAttributeNames:  map[string]string{"#version": "version"},
+		ExpressionAttributeValues: av,
 	})
 	return err
 }
 
+// predictionTrackerKey is the primary key of the prediction-tracker table.
+type predictionTrackerKey struct {
+	Site   string `dynamodbav:"site"`
+	Status string `dynamodbav:"status"`
+}
+
 // GetPredictionTrackerItem fetches a prediction-tracker record by site and status.
 // Returns (nil, nil) if no such item exists.
 func GetPredictionTrackerItem(ctx context.Context, site, status string) (*PredictionTrackerItem, error) {
-	cfg := getAWSConfig()
-	client := dynamodb.NewFromConfig(cfg)
+	repo := newRepository[PredictionTrackerItem](predictionTrackerTable())
+	return repo.Get(ctx, predictionTrackerKey{Site: site, Status: status})
+}
 
-	table := os.Getenv("PREDICTION_TRACKER_TABLE")
-	if table == "" {
-		table = "prediction-tracker"
+// UpdatePredictionTrackerStatus records the outcome of a prediction run for a
+// site, replacing any earlier outcome record for the same status.
+// status must be PredictionStatusCompleted or PredictionStatusFailed;
+// errMsg is stored for failures. The record keeps the createdon of the
+// matching "started" item (when present) so run duration can be derived.
+//
+// The write is guarded by the started item's version: if another run started
+// for the site after this one was read, an error matching ErrVersionConflict
+// is returned instead of overwriting the newer run's state.
+func UpdatePredictionTrackerStatus(ctx context.Context, site, status, errMsg string) error {
+	if status != PredictionStatusCompleted && status != PredictionStatusFailed {
+		return fmt.Errorf("invalid prediction status transition: started -> %q", status)
 	}
+	retention := PredictionTrackerRetention()
+	now := time.Now().UTC()
+	item := PredictionTrackerItem{
+		Site:      site,
+		Status:    status,
+		CreatedOn: now.UnixMilli(),
+		UpdatedOn: now.UnixMilli(),
+		ExpiresAt: retention.ExpiresAt(now),
+	}
+	if status == PredictionStatusFailed {
+		item.ErrorMessage = errMsg
+	}
+	started, err := GetPredictionTrackerItem(ctx, site, PredictionStatusStarted)
+	if err != nil {
+		return err
+	}
+	if started == nil {
+		return newRepository[PredictionTrackerItem](retention.Table).Put(ctx, item)
+	}
+	item.CreatedOn = started.CreatedOn
+	item.Version = started.Version
+	item.ExecutionArn = started.ExecutionArn
 
-	// Build the key for GetItem
-	key, err := attributevalue.MarshalMap(struct {
-		Site   string `dynamodbav:"site"`
-		Status string `dynamodbav:"status"`
-	}{
-		Site:   site,
-		Status: status,
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+	key, err := attributevalue.MarshalMap(predictionTrackerKey{Site: site, Status: PredictionStatusStarted})
+	if err != nil {
+		return err
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{":expected": started.Version})
+	if err != nil {
+		return err
+	}
+	_, err = getDynamoClient().TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{ConditionCheck: &types.ConditionCheck{
+				TableName:                 &retention.Table,
+				Key:                       key,
+				ConditionExpression:       awsString(versionCondition(started.Version)),
+				ExpressionAttributeNames:  map[string]string{"#version": "version"},
+				ExpressionAttributeValues: values,
+			}},
+			{Put: &types.Put{TableName: &retention.Table, Item: av}},
+		},
 	})
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		return &VersionConflictError{Table: retention.Table, Expected: started.Version}
+	}
+	return err
+}
+
+// GetLatestPredictionTrackerItem returns the most recently updated record for a
+// site regardless of status, using the gsi_site_updated index.
+// Returns (nil, nil) if the site has no records.
+func GetLatestPredictionTrackerItem(ctx context.Context, site string) (*PredictionTrackerItem, error) {
+	values, err := attributevalue.MarshalMap(map[string]any{":site": site})
 	if err != nil {
 		return nil, err
 	}
-
-	consistent := true
-	out, err := client.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName:      &table,
-		Key:            key,
-		ConsistentRead: &consistent,
-	})
+	items, _, err := newRepository[PredictionTrackerItem](predictionTrackerTable()).Query(ctx, &dynamodb.QueryInput{
+		IndexName:                 awsString(predictionTrackerSiteIndex),
+		KeyConditionExpression:    awsString("site = :site"),
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          awsBool(false),
+		Limit:                     awsInt32(1),
+	}, "")
 	if err != nil {
 		return nil, err
 	}
-	if out.Item == nil || len(out.Item) == 0 {
+	if len(items) == 0 {
 		return nil, nil
 	}
-
-	var item PredictionTrackerItem
-	if err := attributevalue.UnmarshalMap(out.Item, &item); err != nil {
-		return nil, err
-	}
-	return &item, nil
+	return &items[0], nil
 }
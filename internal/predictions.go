@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// PredictionRecord is a single per-row model output persisted by the infer lambda.
+// Table name defaults to "predictions"; override with PREDICTIONS_TABLE.
+// Keys: PK dataset (String, processed S3 key), SK row (Number, 0-based CSV row).
+type PredictionRecord struct {
+	Dataset   string  `dynamodbav:"dataset" json:"dataset"`
+	Row       int     `dynamodbav:"row" json:"row"`
+	Timestamp int64   `dynamodbav:"timestamp" json:"timestamp"`
+	Latitude  float64 `dynamodbav:"latitude" json:"latitude"`
+	Longitude float64 `dynamodbav:"longitude" json:"longitude"`
+	Predicted float64 `dynamodbav:"predicted" json:"predicted"`
+	Model     string  `dynamodbav:"model" json:"model"`
+	CreatedOn int64   `dynamodbav:"createdon" json:"createdon_ms"`
+	ExpiresAt int64   `dynamodbav:"expires_at,omitempty" json:"-"`
+}
+
+// AnomalyEvaluation is the persisted outcome of one site's anomaly check.
+// Table name defaults to "anomaly-evaluations"; override with ANOMALY_EVALUATIONS_TABLE.
+// Keys: PK site (String), SK evaluatedon (Number, epoch ms).
+type AnomalyEvaluation struct {
+	Site           string  `dynamodbav:"site" json:"site"`
+	EvaluatedOn    int64   `dynamodbav:"evaluatedon" json:"evaluatedon_ms"`
+	Parameter      string  `dynamodbav:"parameter" json:"parameter"`
+	S3Key          string  `dynamodbav:"s3_key" json:"s3_key"`
+	ObservedValue  float64 `dynamodbav:"observed_value" json:"observed_value"`
+	PredictedValue float64 `dynamodbav:"predicted_value" json:"predicted_value"`
+	PercentChange  float64 `dynamodbav:"percent_change" json:"percent_change"`
+	Anomalous      bool    `dynamodbav:"anomalous" json:"anomalous"`
+	// Severity, Unit and Reason are set for parameters judged by a
+	// threshold rule: the anomaly's severity, the parameter's unit and a
+	// description of the breach.
+	Severity string `dynamodbav:"severity,omitempty" json:"severity,omitempty"`
+	Unit     string `dynamodbav:"unit,omitempty" json:"unit,omitempty"`
+	Reason   string `dynamodbav:"reason,omitempty" json:"reason,omitempty"`
+	// Source is EvaluationSourceStream for evaluations made during ingest
+	// against an earlier prediction; empty for anomaly checks.
+	Source string `dynamodbav:"source,omitempty" json:"source,omitempty"`
+	// PredictedOn is when PredictedValue was predicted, if earlier than the
+	// evaluation (epoch ms).
+	PredictedOn int64 `dynamodbav:"predictedon,omitempty" json:"predictedon_ms,omitempty"`
+	// Model is the model that made PredictedValue (an endpoint target or
+	// artifact URI; see ModelName).
+	Model string `dynamodbav:"model,omitempty" json:"model,omitempty"`
+	// Percentile ranks ObservedValue in the site's history, when known.
+	Percentile *PercentileContext `dynamodbav:"percentile,omitempty" json:"percentile,omitempty"`
+	// LeadTime estimates when the site reaches its next impact threshold.
+	LeadTime  *FloodLeadTime `dynamodbav:"lead_time,omitempty" json:"lead_time,omitempty"`
+	ExpiresAt int64          `dynamodbav:"expires_at,omitempty" json:"-"`
+}
+
+// EvaluationSourceStream marks evaluations made on ingest.
+const EvaluationSourceStream = "stream"
+
+// Rating returns the severity of the anomaly: its threshold rule's, or
+// AnomalySeverity of its percent change.
+func (e *AnomalyEvaluation) Rating() string {
+	if e.Severity != "" {
+		return e.Severity
+	}
+	return AnomalySeverity(e.PercentChange)
+}
+
+// PredictionTime returns when the evaluation's prediction was made.
+func (e *AnomalyEvaluation) PredictionTime() time.Time {
+	if e.PredictedOn > 0 {
+		return time.UnixMilli(e.PredictedOn)
+	}
+	return time.UnixMilli(e.EvaluatedOn)
+}
+
+func predictionsTable() string {
+	return tableName("PREDICTIONS_TABLE", "predictions")
+}
+
+func anomalyEvaluationsTable() string {
+	return tableName("ANOMALY_EVALUATIONS_TABLE", "anomaly-evaluations")
+}
+
+// SavePredictionRecords persists per-row predictions using chunked batch writes.
+// Records share the prediction-tracker retention policy.
+func SavePredictionRecords(ctx context.Context, records []PredictionRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	exp := PredictionTrackerRetention().ExpiresAt(now)
+	items := make([]any, 0, len(records))
+	for _, rec := range records {
+		if rec.CreatedOn == 0 {
+			rec.CreatedOn = now.UnixMilli()
+		}
+		if rec.ExpiresAt == 0 {
+			rec.ExpiresAt = exp
+		}
+		items = append(items, rec)
+	}
+	return newRepository[PredictionRecord](predictionsTable()).BatchPut(ctx, items)
+}
+
+// SaveAnomalyEvaluations persists a sweep's per-site results using chunked
+// batch writes. Records share the alert-tracker retention policy. Of
+// evaluations sharing a key (site, evaluatedon), as a site listed twice in
+// one sweep gives, only the last is written: DynamoDB rejects a batch that
+// puts one key twice.
+func SaveAnomalyEvaluations(ctx context.Context, evals []AnomalyEvaluation) error {
+	if len(evals) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	exp := AlertTrackerRetention().ExpiresAt(now)
+	type evalKey struct {
+		site string
+		on   int64
+	}
+	index := map[evalKey]int{}
+	items := make([]any, 0, len(evals))
+	for _, ev := range evals {
+		if ev.EvaluatedOn == 0 {
+			ev.EvaluatedOn = now.UnixMilli()
+		}
+		if ev.ExpiresAt == 0 {
+			ev.ExpiresAt = exp
+		}
+		k := evalKey{ev.Site, ev.EvaluatedOn}
+		if i, ok := index[k]; ok {
+			items[i] = ev
+			continue
+		}
+		index[k] = len(items)
+		items = append(items, ev)
+	}
+	return newRepository[AnomalyEvaluation](anomalyEvaluationsTable()).BatchPut(ctx, items)
+}
+
+// latestEvaluationPages bounds how many pages of a site's history are read
+// looking for its latest evaluation of one parameter.
+const latestEvaluationPages = 5
+
+// GetLatestAnomalyEvaluation returns the site's most recent evaluation for
+// parameter, or (nil, nil) if it has none.
+func GetLatestAnomalyEvaluation(ctx context.Context, site, parameter string) (*AnomalyEvaluation, error) {
+	values, err := attributevalue.MarshalMap(map[string]any{":site": site, ":p": parameter})
+	if err != nil {
+		return nil, err
+	}
+	repo := newRepository[AnomalyEvaluation](anomalyEvaluationsTable())
+	var cursor string
+	for range latestEvaluationPages {
+		// Limit applies before the filter, so a page may hold only other
+		// parameters' evaluations.
+		items, next, err := repo.Query(ctx, &dynamodb.QueryInput{
+			KeyConditionExpression:    awsString("site = :site"),
+			FilterExpression:          awsString("parameter = :p"),
+			ExpressionAttributeValues: values,
+			ScanIndexForward:          awsBool(false),
+			Limit:                     awsInt32(20),
+		}, cursor)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) > 0 {
+			return &items[0], nil
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	return nil, nil
+}
+
+// LatestAnomalyEvaluations returns the most recent persisted evaluation of
+// parameter for each site that has one, in site order, without running new
+// inference. Sites are looked up concurrently.
+func LatestAnomalyEvaluations(ctx context.Context, sites []string, parameter string) ([]AnomalyEvaluation, error) {
+	found := make([]*AnomalyEvaluation, len(sites))
+	errs := make([]error, len(sites))
+	sem := make(chan struct{}, 8)
+	var wg sync.WaitGroup
+	for i, site := range sites {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			found[i], errs[i] = GetLatestAnomalyEvaluation(ctx, site, parameter)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	out := make([]AnomalyEvaluation, 0, len(sites))
+	for _, ev := range found {
+		if ev != nil {
+			out = append(out, *ev)
+		}
+	}
+	return out, nil
+}
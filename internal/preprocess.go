@@ -3,10 +3,11 @@ package internal
 import (
 	"bytes"
 	"context"
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -110,20 +111,39 @@ type USGSJSON struct {
 	} `json:"value"`
 }
 
-// parseUSGSTime attempts multiple layouts common in USGS IV/DV feeds.
+// usgsTimeLayouts are the layouts common in USGS IV/DV feeds, in the order
+// they're tried.
+var usgsTimeLayouts = []string{
+	time.RFC3339,
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.000-07:00",
+	"2006-01-02T15:04:05.000",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02 15:04:05",
+}
+
+// parseUSGSTime attempts multiple layouts common in USGS IV/DV feeds. The
+// layout is first guessed from the string's shape, since a failed attempt
+// costs an error allocation per value; the full list is the fallback.
 func parseUSGSTime(s string) (time.Time, error) {
-	layouts := []string{
-		time.RFC3339,
-		time.RFC3339Nano,
-		"2006-01-02T15:04:05.000-07:00",
-		"2006-01-02T15:04:05.000",
-		"2006-01-02T15:04:05",
-		"2006-01-02",
-		"2006-01-02 15:04:05-07:00",
-		"2006-01-02 15:04:05",
+	var guess string
+	switch {
+	case len(s) == len(time.DateOnly):
+		guess = time.DateOnly
+	case len(s) > len(time.DateOnly) && s[10] == 'T' && !hasZone(s):
+		// Parsing accepts a fractional second after the seconds even when
+		// the layout has none, so this covers "....000" too.
+		guess = "2006-01-02T15:04:05"
+	}
+	if guess != "" {
+		if t, err := time.Parse(guess, s); err == nil {
+			return t, nil
+		}
 	}
 	var lastErr error
-	for _, layout := range layouts {
+	for _, layout := range usgsTimeLayouts {
 		t, err := time.Parse(layout, s)
 		if err == nil {
 			return t, nil
@@ -133,6 +153,12 @@ func parseUSGSTime(s string) (time.Time, error) {
 	return time.Time{}, lastErr
 }
 
+// hasZone reports whether a timestamp ends in "Z" or a ±hh:mm offset.
+func hasZone(s string) bool {
+	n := len(s)
+	return s[n-1] == 'Z' || (n > 6 && (s[n-6] == '+' || s[n-6] == '-') && s[n-3] == ':')
+}
+
 // PreprocessData parses raw USGS JSON and converts it into structured ProcessedData
 func PreprocessData(ctx context.Context, rawData []byte) ([]byte, error) {
 	var usgs USGSJSON
@@ -185,21 +211,99 @@ func PreprocessDataCSV(ctx context.Context, rawData []byte) ([]byte, error) {
 	if err := json.Unmarshal(rawData, &usgs); err != nil {
 		return nil, fmt.Errorf("failed to parse USGS JSON: %w", err)
 	}
+	temps := FetchTemperatures(ctx, seriesCoords(&usgs))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return writeFeatureCSV(&usgs, temps)
+}
+
+// PreprocessDataCSVBatch takes multiple raw USGS JSON payloads and concatenates their
+// CSV feature rows (no header). Each payload should be a standalone USGS JSON document.
+// Weather for every payload's time series is fetched up front, in parallel,
+// so stations sharing an NWS gridpoint share its forecast.
+func PreprocessDataCSVBatch(ctx context.Context, rawPayloads [][]byte) ([]byte, error) {
+	docs := make([]*USGSJSON, len(rawPayloads))
+	var coords []Coord
+	for i, p := range rawPayloads {
+		LogPayload(fmt.Sprintf("preprocess input %d", i), p)
+		if len(p) == 0 {
+			continue
+		}
+		var usgs USGSJSON
+		if err := json.Unmarshal(p, &usgs); err != nil {
+			return nil, fmt.Errorf("failed to parse USGS JSON: %w", err)
+		}
+		docs[i] = &usgs
+		coords = append(coords, seriesCoords(&usgs)...)
+	}
+	temps := FetchTemperatures(ctx, coords)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	buf := &bytes.Buffer{}
-	writer := csv.NewWriter(buf)
+	for i, usgs := range docs {
+		if usgs == nil {
+			continue
+		}
+		b, err := writeFeatureCSV(usgs, temps)
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+		LogPayload(fmt.Sprintf("preprocess output %d", i), b)
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
+
+// seriesCoords returns the location of each time series in usgs.
+func seriesCoords(usgs *USGSJSON) []Coord {
+	coords := make([]Coord, 0, len(usgs.Value.TimeSeries))
+	for _, ts := range usgs.Value.TimeSeries {
+		coords = append(coords, Coord{
+			Lat: ts.SourceInfo.GeoLocation.GeogLocation.Latitude,
+			Lon: ts.SourceInfo.GeoLocation.GeogLocation.Longitude,
+		})
+	}
+	return coords
+}
+
+// writeFeatureCSV writes one feature row per observation in usgs. The
+// weather temperature comes from temps; series whose lookup failed get 0.
+// Fields are numeric and never need quoting, so rows are appended with
+// strconv into one buffer sized for the whole payload rather than through
+// encoding/csv; the output is the same.
+func writeFeatureCSV(usgs *USGSJSON, temps map[Coord]int) ([]byte, error) {
+	points := 0
+	for _, ts := range usgs.Value.TimeSeries {
+		for _, v := range ts.Values {
+			points += len(v.Value)
+		}
+	}
+	// value,timestamp,lat,lng,temp: about 60 bytes a row
+	buf := make([]byte, 0, points*64)
 
 	for _, ts := range usgs.Value.TimeSeries {
 		lat := ts.SourceInfo.GeoLocation.GeogLocation.Latitude
 		lng := ts.SourceInfo.GeoLocation.GeogLocation.Longitude
 		// stationID := ts.SourceInfo.SiteCode[0].Value
 
-		// fetch weather once per time series (constant for all points here)
-		temp, _, _, _, wxErr := FetchWeatherForecast(lat, lng)
-		if wxErr != nil {
-			// fallback to zero if weather fetch fails
-			temp = 0
-		}
+		// weather is constant for all points of a series; missing means the
+		// lookup failed, which falls back to zero. The columns after the
+		// timestamp are the same on every row.
+		temp := temps[Coord{Lat: lat, Lon: lng}]
+		var suffix []byte
+		suffix = append(suffix, ',')
+		suffix = strconv.AppendFloat(suffix, lat, 'f', 6, 64)
+		suffix = append(suffix, ',')
+		suffix = strconv.AppendFloat(suffix, lng, 'f', 6, 64)
+		suffix = append(suffix, ',')
+		suffix = strconv.AppendInt(suffix, int64(temp), 10)
+		suffix = append(suffix, '\n')
 
 		for _, v := range ts.Values {
 			for _, point := range v.Value {
@@ -207,49 +311,27 @@ func PreprocessDataCSV(ctx context.Context, rawData []byte) ([]byte, error) {
 				if err != nil {
 					continue
 				}
-				var value float64
-				fmt.Sscanf(point.Value, "%f", &value)
-				record := []string{
-					fmt.Sprintf("%f", value),
-					// fmt.Sprintf("%s", stationID),
-					fmt.Sprintf("%d", t.Unix()),
-					fmt.Sprintf("%f", lat),
-					fmt.Sprintf("%f", lng),
-					fmt.Sprintf("%d", temp),
-				}
-				if err := writer.Write(record); err != nil {
-					return nil, fmt.Errorf("failed writing csv: %w", err)
-				}
+				value := parseFeatureValue(point.Value)
+				buf = strconv.AppendFloat(buf, value, 'f', 6, 64)
+				buf = append(buf, ',')
+				buf = strconv.AppendInt(buf, t.Unix(), 10)
+				buf = append(buf, suffix...)
 			}
 		}
 	}
-
-	writer.Flush()
-	if err := writer.Error(); err != nil {
-		return nil, fmt.Errorf("csv writer error: %w", err)
-	}
-
-	return buf.Bytes(), nil
+	return buf, nil
 }
 
-// PreprocessDataCSVBatch takes multiple raw USGS JSON payloads and concatenates their
-// CSV feature rows (no header). Each payload should be a standalone USGS JSON document.
-func PreprocessDataCSVBatch(ctx context.Context, rawPayloads [][]byte) ([]byte, error) {
-	buf := &bytes.Buffer{}
-	for i, p := range rawPayloads {
-		log.Println("PreprocessDataCSVBatch - input", i, string(p))
-		if len(p) == 0 {
-			continue
-		}
-		b, err := PreprocessDataCSV(ctx, p)
-		if err != nil {
-			return nil, err
-		}
-		if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
-			buf.WriteByte('\n')
-		}
-		log.Println("PreprocessDataCSVBatch - output", i, string(b))
-		buf.Write(b)
+// parseFeatureValue parses an observation value the way the encoder always
+// has, with fmt.Sscanf("%f"): leading space is skipped, a numeric prefix is
+// kept ("12.5 ft" is 12.5) and anything unreadable, out of range ("1e400")
+// or cut short ("1.5e") is 0. strconv.ParseFloat agrees whenever it
+// succeeds, so Sscanf and its allocations are only paid for the rest.
+func parseFeatureValue(s string) float64 {
+	if v, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+		return v
 	}
-	return buf.Bytes(), nil
+	var v float64
+	fmt.Sscanf(s, "%f", &v)
+	return v
 }
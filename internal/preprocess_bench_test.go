@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// benchUSGSPayload builds a USGS payload for one station: days of values
+// perDay apart, dated like the IV feed (with offset) or the DV feed (without).
+func benchUSGSPayload(b *testing.B, days, perDay int, withOffset bool) *USGSJSON {
+	b.Helper()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.FixedZone("EST", -5*3600))
+	values := make([]map[string]any, 0, days*perDay)
+	for i := range days * perDay {
+		t := start.Add(time.Duration(i) * 24 * time.Hour / time.Duration(perDay))
+		layout := "2006-01-02T15:04:05.000"
+		if withOffset {
+			layout = "2006-01-02T15:04:05.000-07:00"
+		}
+		values = append(values, map[string]any{
+			"value":      fmt.Sprintf("%.2f", 100+float64(i%500)/7),
+			"qualifiers": []string{"A"},
+			"dateTime":   t.Format(layout),
+		})
+	}
+	doc := map[string]any{"value": map[string]any{"timeSeries": []any{map[string]any{
+		"sourceInfo": map[string]any{
+			"siteCode":    []any{map[string]string{"value": "03339000"}},
+			"geoLocation": map[string]any{"geogLocation": map[string]any{"latitude": 40.1, "longitude": -88.2}},
+		},
+		"variable": map[string]any{"noDataValue": -999999},
+		"values":   []any{map[string]any{"value": values}},
+	}}}}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var usgs USGSJSON
+	if err := json.Unmarshal(raw, &usgs); err != nil {
+		b.Fatal(err)
+	}
+	return &usgs
+}
+
+// BenchmarkWriteFeatureCSV_DailyFiveYears covers a multi-year DV backfill:
+// five years of daily values for one station.
+func BenchmarkWriteFeatureCSV_DailyFiveYears(b *testing.B) {
+	usgs := benchUSGSPayload(b, 5*365, 1, false)
+	temps := map[Coord]int{{Lat: 40.1, Lon: -88.2}: 61}
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := writeFeatureCSV(usgs, temps); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWriteFeatureCSV_InstantMonth covers 30 days of 15-minute IV
+// values for one station.
+func BenchmarkWriteFeatureCSV_InstantMonth(b *testing.B) {
+	usgs := benchUSGSPayload(b, 30, 96, true)
+	temps := map[Coord]int{{Lat: 40.1, Lon: -88.2}: 61}
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := writeFeatureCSV(usgs, temps); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseUSGSTime(b *testing.B) {
+	for _, s := range []string{"2025-08-23T12:15:00.000-05:00", "2025-08-23T00:00:00.000", "2025-08-23"} {
+		b.Run(s, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := parseUSGSTime(s); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
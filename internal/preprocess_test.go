@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// featureValueInputs are observation values as USGS and external sources
+// send them, plus the malformed ones the encoder has to survive.
+var featureValueInputs = []string{
+	"", "0", "-0", "12", "12.5", "-3.25", "+4", ".5", "5.", "1e3", "1.5E-2",
+	" 7.5", "7.5 ", "\t8\n", " 9.5",
+	"1.5e", "1.5e+", "2e-", "12.5 ft", "12abc", "3..4", "--1", "+-1", "-",
+	"1e400", "-1e400", "1e-400", "0x1p4", "0x1.8p1", "1_000", "0x1_0p0",
+	"NaN", "nan", "Inf", "-Inf", "+inf", "infinity", "Infinity", "infinite",
+	"Ice", "Eqp", "***", "-999999", "-999999.00",
+	"1,5", "1,234.5", `"2"`, `3"`, `4,"5"`, "\"", ",",
+	"123456789012345678901234567890", "0.1234567890123",
+}
+
+func TestParseFeatureValueMatchesSscanf(t *testing.T) {
+	for _, in := range featureValueInputs {
+		var want float64
+		fmt.Sscanf(in, "%f", &want)
+		got := parseFeatureValue(in)
+		if fmt.Sprintf("%f", got) != fmt.Sprintf("%f", want) {
+			t.Errorf("parseFeatureValue(%q) = %f, Sscanf gives %f", in, got, want)
+		}
+	}
+}
+
+// writeFeatureCSVGolden is writeFeatureCSV as it was written with
+// encoding/csv and fmt, the reference the faster encoder must match byte
+// for byte.
+func writeFeatureCSVGolden(usgs *USGSJSON, temps map[Coord]int) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+	for _, ts := range usgs.Value.TimeSeries {
+		lat := ts.SourceInfo.GeoLocation.GeogLocation.Latitude
+		lng := ts.SourceInfo.GeoLocation.GeogLocation.Longitude
+		temp := temps[Coord{Lat: lat, Lon: lng}]
+		for _, v := range ts.Values {
+			for _, point := range v.Value {
+				t, err := parseUSGSTime(point.DateTime)
+				if err != nil {
+					continue
+				}
+				var value float64
+				fmt.Sscanf(point.Value, "%f", &value)
+				record := []string{
+					fmt.Sprintf("%f", value),
+					fmt.Sprintf("%d", t.Unix()),
+					fmt.Sprintf("%f", lat),
+					fmt.Sprintf("%f", lng),
+					fmt.Sprintf("%d", temp),
+				}
+				if err := writer.Write(record); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+func TestWriteFeatureCSVMatchesGolden(t *testing.T) {
+	dates := []string{
+		"2025-08-23T12:15:00.000-05:00", "2025-08-23T00:00:00.000", "2025-08-23",
+		"2025-08-23T12:15:00Z", "2025-08-23 06:30:00", "not a date", "",
+	}
+	var values []map[string]any
+	for i, in := range featureValueInputs {
+		values = append(values, map[string]any{"value": in, "dateTime": dates[i%len(dates)]})
+	}
+	series := func(lat, lng float64, values []map[string]any) map[string]any {
+		return map[string]any{
+			"sourceInfo": map[string]any{"geoLocation": map[string]any{"geogLocation": map[string]any{"latitude": lat, "longitude": lng}}},
+			"values":     []any{map[string]any{"value": values}},
+		}
+	}
+	doc := map[string]any{"value": map[string]any{"timeSeries": []any{
+		series(40.1, -88.2, values),
+		// no weather for this one: temp 0
+		series(-33.8688197, 151.2092955, values[:10]),
+		series(0, 0, nil),
+	}}}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var usgs USGSJSON
+	if err := json.Unmarshal(raw, &usgs); err != nil {
+		t.Fatal(err)
+	}
+	temps := map[Coord]int{{Lat: 40.1, Lon: -88.2}: -4}
+
+	want, err := writeFeatureCSVGolden(&usgs, temps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := writeFeatureCSV(&usgs, temps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		gotLines, wantLines := bytes.Split(got, []byte("\n")), bytes.Split(want, []byte("\n"))
+		for i := range min(len(gotLines), len(wantLines)) {
+			if !bytes.Equal(gotLines[i], wantLines[i]) {
+				t.Fatalf("line %d = %q, want %q", i+1, gotLines[i], wantLines[i])
+			}
+		}
+		t.Fatalf("got %d lines, want %d", len(gotLines), len(wantLines))
+	}
+	// Every row must read back as five unquoted fields.
+	rows, err := csv.NewReader(bytes.NewReader(got)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) == 0 || bytes.ContainsRune(got, '"') {
+		t.Errorf("want unquoted rows, got %d rows:\n%s", len(rows), got)
+	}
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrRefreshTokenReused is returned when a single-use token (refresh token or
+// magic link) that was already exchanged, or never issued by this
+// deployment, is presented again.
+var ErrRefreshTokenReused = errors.New("token already used")
+
+// RefreshTokenRecord tracks an issued single-use token by jti so each one can
+// be exchanged exactly once. Refresh tokens and email magic links share the
+// table, told apart by typ. Table name defaults to "refresh-tokens"; override
+// with REFRESH_TOKEN_TABLE. Keys: PK jti. Items expire via expires_at.
+type RefreshTokenRecord struct {
+	JTI       string `dynamodbav:"jti"`
+	Subject   string `dynamodbav:"subject"`
+	Type      string `dynamodbav:"typ,omitempty"`
+	CreatedOn int64  `dynamodbav:"createdon"`
+	UsedOn    int64  `dynamodbav:"usedon,omitempty"`
+	ExpiresAt int64  `dynamodbav:"expires_at"`
+}
+
+func refreshTokenTable() string {
+	return tableName("REFRESH_TOKEN_TABLE", "refresh-tokens")
+}
+
+// MintRefreshToken issues a refresh token (HS256 JWT, typ=refresh) for
+// userID and records its jti so it can later be rotated.
+func MintRefreshToken(ctx context.Context, userID string, ttl time.Duration) (string, error) {
+	return mintSingleUseToken(ctx, userID, TokenTypeRefresh, ttl)
+}
+
+// RedeemRefreshToken validates a refresh token and marks it used, returning
+// its subject, a user ID. The caller then mints a new access/refresh pair; a token
+// can be redeemed only once, so a replayed token fails with
+// ErrRefreshTokenReused.
+func RedeemRefreshToken(ctx context.Context, token string) (string, error) {
+	return redeemSingleUseToken(ctx, token, TokenTypeRefresh)
+}
+
+// mintSingleUseToken signs a JWT of type typ for subject and records its jti.
+func mintSingleUseToken(ctx context.Context, subject, typ string, ttl time.Duration) (string, error) {
+	claims, err := newSessionClaims(subject, typ, ttl)
+	if err != nil {
+		return "", err
+	}
+	token, err := signJWT(claims)
+	if err != nil {
+		return "", err
+	}
+	record := RefreshTokenRecord{
+		JTI:       claims.ID,
+		Subject:   subject,
+		Type:      typ,
+		CreatedOn: time.Now().UTC().UnixMilli(),
+		ExpiresAt: claims.ExpiresAt,
+	}
+	if err := newRepository[RefreshTokenRecord](refreshTokenTable()).Create(ctx, record, "jti"); err != nil {
+		return "", fmt.Errorf("record %s token: %w", typ, err)
+	}
+	return token, nil
+}
+
+// redeemSingleUseToken validates token as type typ and marks its jti used,
+// returning the subject.
+func redeemSingleUseToken(ctx context.Context, token, typ string) (string, error) {
+	claims, err := parseJWT(token, typ)
+	if err != nil {
+		return "", err
+	}
+	table := refreshTokenTable()
+	key, err := attributevalue.MarshalMap(map[string]any{"jti": claims.ID})
+	if err != nil {
+		return "", err
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{
+		":now": time.Now().UTC().UnixMilli(),
+		":sub": claims.Subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	_, err = getDynamoClient().UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 &table,
+		Key:                       key,
+		UpdateExpression:          awsString("SET usedon = :now"),
+		ConditionExpression:       awsString("attribute_exists(jti) AND attribute_not_exists(usedon) AND subject = :sub"),
+		ExpressionAttributeValues: values,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return "", ErrRefreshTokenReused
+	}
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+)
+
+// Every service client uses the region of the AWS config (AWS_REGION) unless
+// its service has a region of its own:
+//
+//	DYNAMODB_REGION, S3_REGION, SNS_REGION, SQS_REGION, SAGEMAKER_REGION,
+//	SES_REGION, GLUE_REGION, SFN_REGION, EVENTBRIDGE_REGION, SSM_REGION,
+//	SECRETSMANAGER_REGION
+//
+// DynamoDB and S3 reads can also fail over to a second region so a regional
+// outage doesn't stop alerting. With DYNAMODB_FAILOVER_REGION (tables
+// replicated there as global tables) or S3_FAILOVER_REGION (buckets
+// replicated to <bucket>-<region>, or <bucket>S3_FAILOVER_BUCKET_SUFFIX), a
+// call failing with a server error or a network error is retried in the
+// failover region, and later calls go there first for
+// AWS_FAILOVER_STICKY_SECONDS (default 60) before the home region is tried
+// again. AWS_FAILOVER_REGION sets both. Failover is off with an endpoint
+// override, which points the service at one endpoint.
+
+// regionalConfig returns the AWS config with the region of envVar, when set.
+func regionalConfig(envVar string) aws.Config {
+	cfg := getAWSConfig()
+	if region := strings.TrimSpace(os.Getenv(envVar)); region != "" {
+		cfg.Region = region
+	}
+	return cfg
+}
+
+// failoverRegion returns the failover region of envVar, falling back to
+// AWS_FAILOVER_REGION; empty when failover is off or endpointEnv overrides
+// the service endpoint.
+func failoverRegion(envVar, endpointEnv string) string {
+	if endpointOverride(endpointEnv) != "" {
+		return ""
+	}
+	if v := strings.TrimSpace(os.Getenv(envVar)); v != "" {
+		return v
+	}
+	return strings.TrimSpace(os.Getenv("AWS_FAILOVER_REGION"))
+}
+
+// failoverStickiness is how long calls go to the failover region first after
+// a failover (AWS_FAILOVER_STICKY_SECONDS, default 60).
+func failoverStickiness() time.Duration {
+	return time.Duration(envInt("AWS_FAILOVER_STICKY_SECONDS", 60)) * time.Second
+}
+
+// regionFailover tracks whether a service currently prefers its failover
+// region.
+type regionFailover struct {
+	service string
+	region  string
+	// until is when the failover region stops being tried first (Unix ns).
+	until atomic.Int64
+}
+
+// withFailover calls call with primary, or with secondary first while f is
+// failed over, and retries a regional failure with the other one.
+func withFailover[C, O any](ctx context.Context, f *regionFailover, primary, secondary C, call func(C) (O, error)) (O, error) {
+	failedOver := time.Now().UnixNano() < f.until.Load()
+	first, second := primary, secondary
+	if failedOver {
+		first, second = secondary, primary
+	}
+	out, err := call(first)
+	if err == nil || !regionalFailure(ctx, err) {
+		return out, err
+	}
+	if failedOver {
+		out, err = call(second)
+		if err == nil {
+			// The home region is back.
+			f.until.Store(0)
+		}
+		return out, err
+	}
+	log.Printf("%s: failing over to %s: %v", f.service, f.region, err)
+	f.until.Store(time.Now().Add(failoverStickiness()).UnixNano())
+	return call(second)
+}
+
+// regionalFailure reports whether err looks like the region failing rather
+// than the request: a server-side fault, a 5xx status or a network error.
+// Errors after ctx ended are the caller's.
+func regionalFailure(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) && re.HTTPStatusCode() >= 500 {
+		return true
+	}
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		return ae.ErrorFault() == smithy.FaultServer
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
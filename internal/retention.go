@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// ttlAttribute is the DynamoDB TTL attribute (epoch seconds) written on tracker
+// items. The deploy script enables TTL on this attribute for each table.
+const ttlAttribute = "expires_at"
+
+// RetentionConfig describes how long items in a tracker table are kept.
+// A zero TTL disables expiry for that table.
+type RetentionConfig struct {
+	Table string
+	TTL   time.Duration
+}
+
+// Retention defaults per tracker table, in days. Override with the
+// corresponding *_TTL_DAYS env var; set it to 0 to keep items forever.
+// Model records are kept by default: the serving model, its snapshots and
+// performance history hang off them, and CleanupModels finds artifacts
+// through them, so expiry would orphan what it can't see.
+const (
+	defaultAlertTrackerTTLDays      = 90
+	defaultPredictionTrackerTTLDays = 30
+	defaultTrainModelTrackerTTLDays = 0
+)
+
+func retentionFromEnv(envVar string, defDays int) time.Duration {
+	days := defDays
+	if v := os.Getenv(envVar); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			days = n
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// AlertTrackerRetention returns the retention policy for the alert-tracker table.
+func AlertTrackerRetention() RetentionConfig {
+	return RetentionConfig{Table: alertTrackerTable(), TTL: retentionFromEnv("ALERT_TRACKER_TTL_DAYS", defaultAlertTrackerTTLDays)}
+}
+
+// PredictionTrackerRetention returns the retention policy for the prediction-tracker table.
+func PredictionTrackerRetention() RetentionConfig {
+	return RetentionConfig{Table: predictionTrackerTable(), TTL: retentionFromEnv("PREDICTION_TRACKER_TTL_DAYS", defaultPredictionTrackerTTLDays)}
+}
+
+// TrainModelTrackerRetention returns the retention policy for the train-model-tracker table.
+func TrainModelTrackerRetention() RetentionConfig {
+	return RetentionConfig{Table: trainModelTrackerTable(), TTL: retentionFromEnv("TRAIN_MODEL_TRACKER_TTL_DAYS", defaultTrainModelTrackerTTLDays)}
+}
+
+// ExpiresAt returns the TTL value (epoch seconds) for an item created at t,
+// or 0 when retention is disabled.
+func (c RetentionConfig) ExpiresAt(t time.Time) int64 {
+	if c.TTL <= 0 {
+		return 0
+	}
+	return t.Add(c.TTL).Unix()
+}
+
+// ArchiveExpiringItems copies items from the table whose TTL falls within the
+// next `within` window to S3 as JSON lines at
+// [<env>/]archive/<table>/<YYYY-MM-DD>.jsonl (see StorageLayout), so history survives DynamoDB expiry.
+// Items are streamed to S3 as they are scanned rather than buffered.
+// Returns the S3 key written and the number of items archived; when nothing is
+// expiring no object is written and the key is empty.
+func ArchiveExpiringItems(ctx context.Context, cfg RetentionConfig, bucket string, within time.Duration) (string, int, error) {
+	if bucket == "" {
+		return "", 0, fmt.Errorf("archive bucket is required")
+	}
+	client := getDynamoClient()
+	now := time.Now().UTC()
+	values, err := attributevalue.MarshalMap(map[string]any{
+		":now":   now.Unix(),
+		":until": now.Add(within).Unix(),
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	names := map[string]string{"#ttl": ttlAttribute}
+
+	p := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{
+		TableName:                 &cfg.Table,
+		FilterExpression:          awsString("#ttl BETWEEN :now AND :until"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	nextRecords := func() ([]map[string]any, error) {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var records []map[string]any
+		err = attributevalue.UnmarshalListOfMaps(page.Items, &records)
+		return records, err
+	}
+
+	// Find the first matching page before starting the upload so that an
+	// empty window writes nothing.
+	var first []map[string]any
+	for len(first) == 0 && p.HasMorePages() {
+		if first, err = nextRecords(); err != nil {
+			return "", 0, err
+		}
+	}
+	if len(first) == 0 {
+		return "", 0, nil
+	}
+
+	pr, pw := io.Pipe()
+	counted := make(chan int, 1)
+	go func() {
+		enc := json.NewEncoder(pw)
+		count := 0
+		records := first
+		for {
+			for _, rec := range records {
+				if err := enc.Encode(rec); err != nil {
+					pw.CloseWithError(err)
+					counted <- count
+					return
+				}
+				count++
+			}
+			if !p.HasMorePages() {
+				break
+			}
+			var err error
+			if records, err = nextRecords(); err != nil {
+				pw.CloseWithError(err)
+				counted <- count
+				return
+			}
+		}
+		pw.Close()
+		counted <- count
+	}()
+
+	key := Layout().ArchiveKey(cfg.Table, now)
+	err = UploadStreamToS3(ctx, pr, bucket, key, ObjectOptions(ctx, key, PurposeArchive, map[string]string{"table": cfg.Table}))
+	pr.CloseWithError(err) // unblock the scanner if the upload stopped early
+	count := <-counted
+	if err != nil {
+		return "", 0, err
+	}
+	return key, count, nil
+}
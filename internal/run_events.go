@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+)
+
+// When an execution finishes, EventBridge delivers its status change to the
+// API (POST /pipeline/callback). CompletePipelineRun settles the run's
+// records and publishes a RunEvent to clients subscribed on this API
+// instance (GET /events/runs), so they don't have to poll
+// /prediction/status.
+
+// runEventBuffer is each subscriber's queue; events for a subscriber that
+// falls this far behind are dropped.
+const runEventBuffer = 16
+
+// RunEvent announces a finished pipeline run.
+type RunEvent struct {
+	ExecutionArn string   `json:"execution_arn"`
+	Name         string   `json:"name"`
+	Status       string   `json:"status"`
+	Sites        []string `json:"sites,omitempty"`
+	Parameter    string   `json:"parameter,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	Cause        string   `json:"cause,omitempty"`
+	StoppedOn    int64    `json:"stoppedon_ms,omitempty"`
+}
+
+// RunEventFrom returns the event announcing run.
+func RunEventFrom(run *PipelineRun) RunEvent {
+	return RunEvent{
+		ExecutionArn: run.ExecutionArn,
+		Name:         run.Name,
+		Status:       run.Status,
+		Sites:        run.Sites,
+		Parameter:    run.Parameter,
+		Error:        run.Error,
+		Cause:        run.Cause,
+		StoppedOn:    run.StoppedOn,
+	}
+}
+
+type runSubscriber struct {
+	sites []string // empty receives every run
+	ch    chan RunEvent
+}
+
+func (s *runSubscriber) wants(e RunEvent) bool {
+	if len(s.sites) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(e.Sites, func(site string) bool { return slices.Contains(s.sites, site) })
+}
+
+var (
+	runSubsMu sync.Mutex
+	runSubs   = map[*runSubscriber]struct{}{}
+)
+
+// SubscribeRunEvents returns a channel of finished runs touching any of
+// sites (every run when sites is empty) and a func that cancels the
+// subscription. The channel is not closed; stop reading after cancel.
+func SubscribeRunEvents(sites []string) (<-chan RunEvent, func()) {
+	sub := &runSubscriber{sites: sites, ch: make(chan RunEvent, runEventBuffer)}
+	runSubsMu.Lock()
+	runSubs[sub] = struct{}{}
+	runSubsMu.Unlock()
+	return sub.ch, func() {
+		runSubsMu.Lock()
+		delete(runSubs, sub)
+		runSubsMu.Unlock()
+	}
+}
+
+// PublishRunEvent delivers e to matching subscribers without blocking.
+func PublishRunEvent(e RunEvent) {
+	runSubsMu.Lock()
+	defer runSubsMu.Unlock()
+	for sub := range runSubs {
+		if !sub.wants(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			log.Printf("run event subscriber is behind; dropped %s", e.ExecutionArn)
+		}
+	}
+}
+
+// CompletePipelineRun handles a finished execution: it refreshes the run
+// from Step Functions, settles the prediction tracker of every site still
+// "started" by this execution (completed, or failed with the run's error),
+// and publishes a RunEvent. Runs that are still in progress are returned
+// unchanged. Repeated callbacks for a run are harmless.
+func CompletePipelineRun(ctx context.Context, executionArn string) (*PipelineRun, error) {
+	run, err := GetExecutionStatus(ctx, executionArn)
+	if err != nil {
+		return nil, err
+	}
+	if !run.Done() {
+		return run, nil
+	}
+	status, msg := PredictionStatusCompleted, ""
+	if run.Status != PipelineRunSucceeded {
+		status, msg = PredictionStatusFailed, run.Status
+		if run.Error != "" {
+			msg = run.Error + ": " + run.Cause
+		}
+	}
+	for _, site := range run.Sites {
+		latest, err := GetLatestPredictionTrackerItem(ctx, site)
+		if err != nil {
+			log.Printf("prediction tracker read failed for %s: %v", site, err)
+			continue
+		}
+		if latest == nil || latest.Status != PredictionStatusStarted || latest.ExecutionArn != executionArn {
+			continue
+		}
+		if err := UpdatePredictionTrackerStatus(ctx, site, status, msg); err != nil {
+			log.Printf("prediction tracker update failed for %s: %v", site, err)
+		}
+	}
+	PublishRunEvent(RunEventFrom(run))
+	return run, nil
+}
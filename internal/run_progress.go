@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// While an execution runs, its lambdas record what they've done as progress
+// events (fetch done, rows written, training started...). The API streams
+// them to clients (GET /ingest/{execution}/events), so the UI can show where
+// a run is instead of a spinner. Recording is best-effort: a lost event
+// only makes the stream less detailed.
+
+// Progress event kinds.
+const (
+	ProgressFetchDone          = "fetch_done"
+	ProgressRowsWritten        = "rows_written"
+	ProgressTrainingStarted    = "training_started"
+	ProgressTrainingFinished   = "training_finished"
+	ProgressPredictionsWritten = "predictions_written"
+)
+
+// ProgressEvent is one step of an execution's progress.
+// Table name defaults to "pipeline-events"; override with
+// PIPELINE_EVENTS_TABLE. Keys: PK execution_arn, SK seq. Items expire with
+// the run's record (PIPELINE_RUN_TTL_DAYS).
+type ProgressEvent struct {
+	ExecutionArn string `dynamodbav:"execution_arn" json:"execution_arn"`
+	// Seq orders an execution's events: the recording time in epoch
+	// microseconds.
+	Seq     int64  `dynamodbav:"seq" json:"seq"`
+	Step    string `dynamodbav:"step" json:"step"`
+	Kind    string `dynamodbav:"kind" json:"kind"`
+	Message string `dynamodbav:"message,omitempty" json:"message,omitempty"`
+	// Count is the kind's quantity: payloads fetched, rows written or
+	// predictions made.
+	Count     int   `dynamodbav:"count,omitempty" json:"count,omitempty"`
+	At        int64 `dynamodbav:"at" json:"at_ms"`
+	ExpiresAt int64 `dynamodbav:"expires_at,omitempty" json:"-"`
+}
+
+func pipelineEventsTable() string {
+	return tableName("PIPELINE_EVENTS_TABLE", "pipeline-events")
+}
+
+// RecordProgress records a progress event of executionArn. It is
+// best-effort: failures are logged. Lambdas invoked without an execution
+// (manual runs) pass an empty ARN, which records nothing.
+func RecordProgress(ctx context.Context, executionArn, step, kind string, count int, message string) {
+	if executionArn == "" {
+		return
+	}
+	now := time.Now().UTC()
+	err := newRepository[ProgressEvent](pipelineEventsTable()).Put(context.WithoutCancel(ctx), ProgressEvent{
+		ExecutionArn: executionArn,
+		Seq:          now.UnixMicro(),
+		Step:         step,
+		Kind:         kind,
+		Message:      message,
+		Count:        count,
+		At:           now.UnixMilli(),
+		ExpiresAt:    PipelineRunRetention().ExpiresAt(now),
+	})
+	if err != nil {
+		log.Printf("recording %s progress of %s failed: %v", kind, executionArn, err)
+	}
+}
+
+// ListProgressEvents returns the events of executionArn recorded after
+// seq, oldest first.
+func ListProgressEvents(ctx context.Context, executionArn string, after int64) ([]ProgressEvent, error) {
+	values, err := attributevalue.MarshalMap(map[string]any{":arn": executionArn, ":after": after})
+	if err != nil {
+		return nil, err
+	}
+	in := &dynamodb.QueryInput{
+		KeyConditionExpression:    awsString("execution_arn = :arn AND seq > :after"),
+		ExpressionAttributeValues: values,
+	}
+	repo := newRepository[ProgressEvent](pipelineEventsTable())
+	var out []ProgressEvent
+	var cursor string
+	for {
+		items, next, err := repo.Query(ctx, in, cursor)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+		if next == "" {
+			return out, nil
+		}
+		cursor = next
+	}
+}
+
+// ResolveExecutionArn returns the ARN of execution, which is either an ARN
+// or the name of an execution of STATE_MACHINE_ARN.
+func ResolveExecutionArn(execution string) (string, error) {
+	if strings.HasPrefix(execution, "arn:") {
+		return execution, nil
+	}
+	stateMachineArn := os.Getenv("STATE_MACHINE_ARN")
+	if stateMachineArn == "" {
+		return "", fmt.Errorf("%w: STATE_MACHINE_ARN not set", ErrIngestNotConfigured)
+	}
+	return executionArnFor(stateMachineArn, execution), nil
+}
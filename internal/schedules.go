@@ -0,0 +1,418 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+// Each ingest schedule is a record in the schedules table plus an EventBridge
+// rule ("aquawatch-schedule-<id>") whose target is the scheduled-ingest
+// lambda (SCHEDULE_TARGET_ARN). The rule passes {"schedule_id": ...}; the
+// lambda calls RunSchedule, which starts the pipeline for the schedule's
+// sites and decides whether this run trains a new model.
+
+const (
+	scheduleIDPrefix   = "sch_"
+	scheduleRulePrefix = "aquawatch-schedule-"
+	maxScheduleSites   = 50
+	maxTrainEveryDays  = 365
+)
+
+// ErrScheduleNotFound is returned when a schedule ID has no record.
+var ErrScheduleNotFound = notFoundError("schedule not found")
+
+// ErrInvalidSchedule is returned for schedule specs that fail validation.
+var ErrInvalidSchedule = validationError("invalid schedule")
+
+var (
+	parameterCodePattern = regexp.MustCompile(`^[0-9]{5}$`)
+	cronFieldPattern     = regexp.MustCompile(`^[0-9A-Za-z*?,/#-]+$`)
+)
+
+// Schedule is a recurring ingest run.
+// Table name defaults to "schedules"; override with SCHEDULES_TABLE.
+// Keys: PK schedule_id.
+type Schedule struct {
+	ScheduleID       string   `dynamodbav:"schedule_id" json:"schedule_id"`
+	Name             string   `dynamodbav:"name" json:"name"`
+	Sites            []string `dynamodbav:"sites" json:"sites"`
+	Parameter        string   `dynamodbav:"parameter" json:"parameter"`
+	Cron             string   `dynamodbav:"cron" json:"cron"`
+	TrainEveryDays   int      `dynamodbav:"train_every_days" json:"train_every_days"`
+	Enabled          bool     `dynamodbav:"enabled" json:"enabled"`
+	CreatedBy        string   `dynamodbav:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedOn        int64    `dynamodbav:"createdon" json:"createdon_ms"`
+	UpdatedOn        int64    `dynamodbav:"updatedon" json:"updatedon_ms"`
+	LastRunOn        int64    `dynamodbav:"last_run_on,omitempty" json:"last_run_on_ms,omitempty"`
+	LastExecutionArn string   `dynamodbav:"last_execution_arn,omitempty" json:"last_execution_arn,omitempty"`
+	LastTrainedOn    int64    `dynamodbav:"last_trained_on,omitempty" json:"last_trained_on_ms,omitempty"`
+	Version          int64    `dynamodbav:"version" json:"version"`
+}
+
+// ScheduleSpec is the user-editable part of a schedule. Cron uses the six
+// EventBridge cron fields ("0 6 * * ? *" is 06:00 UTC daily); a
+// "cron(...)" wrapper is accepted. TrainEveryDays is the training cadence:
+// a run trains a new model when the last training is at least that many days
+// old (0 never trains). Parameter defaults to 00060 and Enabled to true.
+type ScheduleSpec struct {
+	Name           string   `json:"name"`
+	Sites          []string `json:"sites"`
+	Parameter      string   `json:"parameter"`
+	Cron           string   `json:"cron"`
+	TrainEveryDays int      `json:"train_every_days"`
+	Enabled        *bool    `json:"enabled"`
+}
+
+// normalize fills defaults and validates the spec.
+func (s *ScheduleSpec) normalize() error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" || len(s.Name) > 100 {
+		return fmt.Errorf("%w: name is required (at most 100 characters)", ErrInvalidSchedule)
+	}
+	if len(s.Sites) == 0 || len(s.Sites) > maxScheduleSites {
+		return fmt.Errorf("%w: between 1 and %d sites required", ErrInvalidSchedule, maxScheduleSites)
+	}
+	for _, site := range s.Sites {
+		if !siteIDPattern.MatchString(site) {
+			return fmt.Errorf("%w: invalid site id %q", ErrInvalidSchedule, site)
+		}
+	}
+	if s.Parameter == "" {
+		s.Parameter = "00060"
+	}
+	if !parameterCodePattern.MatchString(s.Parameter) {
+		return fmt.Errorf("%w: parameter must be a 5-digit USGS parameter code", ErrInvalidSchedule)
+	}
+	cron, err := normalizeCron(s.Cron)
+	if err != nil {
+		return err
+	}
+	s.Cron = cron
+	if s.TrainEveryDays < 0 || s.TrainEveryDays > maxTrainEveryDays {
+		return fmt.Errorf("%w: train_every_days must be between 0 and %d", ErrInvalidSchedule, maxTrainEveryDays)
+	}
+	if s.Enabled == nil {
+		s.Enabled = awsBool(true)
+	}
+	return nil
+}
+
+// normalizeCron checks an EventBridge cron expression and returns its six
+// space-separated fields. Exactly one of day-of-month and day-of-week must
+// be "?".
+func normalizeCron(expr string) (string, error) {
+	expr = strings.TrimSpace(expr)
+	if inner, ok := strings.CutPrefix(expr, "cron("); ok {
+		expr = strings.TrimSuffix(inner, ")")
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 6 {
+		return "", fmt.Errorf("%w: cron must have 6 fields (minutes hours day-of-month month day-of-week year)", ErrInvalidSchedule)
+	}
+	for _, f := range fields {
+		if !cronFieldPattern.MatchString(f) {
+			return "", fmt.Errorf("%w: invalid cron field %q", ErrInvalidSchedule, f)
+		}
+	}
+	if (fields[2] == "?") == (fields[4] == "?") {
+		return "", fmt.Errorf("%w: cron needs \"?\" in exactly one of day-of-month and day-of-week", ErrInvalidSchedule)
+	}
+	return strings.Join(fields, " "), nil
+}
+
+func schedulesTable() string {
+	return tableName("SCHEDULES_TABLE", "schedules")
+}
+
+var (
+	eventBridgeClientOnce sync.Once
+	eventBridgeClient     *eventbridge.Client
+)
+
+func getEventBridgeClient() *eventbridge.Client {
+	eventBridgeClientOnce.Do(func() {
+		eventBridgeClient = eventbridge.NewFromConfig(regionalConfig("EVENTBRIDGE_REGION"))
+	})
+	return eventBridgeClient
+}
+
+func scheduleRuleName(id string) string {
+	return scheduleRulePrefix + id
+}
+
+// putScheduleRule creates or updates the EventBridge rule for s and points it
+// at the scheduled-ingest lambda.
+func putScheduleRule(ctx context.Context, s *Schedule) error {
+	target := os.Getenv("SCHEDULE_TARGET_ARN")
+	if target == "" {
+		return errors.New("SCHEDULE_TARGET_ARN not configured")
+	}
+	state := ebtypes.RuleStateEnabled
+	if !s.Enabled {
+		state = ebtypes.RuleStateDisabled
+	}
+	name := scheduleRuleName(s.ScheduleID)
+	client := getEventBridgeClient()
+	if _, err := client.PutRule(ctx, &eventbridge.PutRuleInput{
+		Name:               &name,
+		Description:        awsString("AquaWatch ingest schedule: " + s.Name),
+		ScheduleExpression: awsString("cron(" + s.Cron + ")"),
+		State:              state,
+	}); err != nil {
+		return err
+	}
+	input, err := json.Marshal(map[string]string{"schedule_id": s.ScheduleID})
+	if err != nil {
+		return err
+	}
+	out, err := client.PutTargets(ctx, &eventbridge.PutTargetsInput{
+		Rule: &name,
+		Targets: []ebtypes.Target{{
+			Id:    awsString("ingest"),
+			Arn:   &target,
+			Input: awsString(string(input)),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if out.FailedEntryCount > 0 && len(out.FailedEntries) > 0 {
+		return fmt.Errorf("put target on %s: %s", name, deref(out.FailedEntries[0].ErrorMessage))
+	}
+	return nil
+}
+
+// deleteScheduleRule removes the rule for id; a missing rule is not an error.
+func deleteScheduleRule(ctx context.Context, id string) error {
+	name := scheduleRuleName(id)
+	client := getEventBridgeClient()
+	var nf *ebtypes.ResourceNotFoundException
+	if _, err := client.RemoveTargets(ctx, &eventbridge.RemoveTargetsInput{Rule: &name, Ids: []string{"ingest"}}); err != nil && !errors.As(err, &nf) {
+		return err
+	}
+	if _, err := client.DeleteRule(ctx, &eventbridge.DeleteRuleInput{Name: &name}); err != nil && !errors.As(err, &nf) {
+		return err
+	}
+	return nil
+}
+
+// CreateSchedule validates spec, creates its EventBridge rule and stores the
+// schedule. Errors match ErrInvalidSchedule for bad specs.
+func CreateSchedule(ctx context.Context, spec ScheduleSpec, createdBy string) (*Schedule, error) {
+	if err := spec.normalize(); err != nil {
+		return nil, err
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().UnixMilli()
+	s := &Schedule{
+		ScheduleID:     scheduleIDPrefix + id,
+		Name:           spec.Name,
+		Sites:          spec.Sites,
+		Parameter:      spec.Parameter,
+		Cron:           spec.Cron,
+		TrainEveryDays: spec.TrainEveryDays,
+		Enabled:        *spec.Enabled,
+		CreatedBy:      createdBy,
+		CreatedOn:      now,
+		UpdatedOn:      now,
+		Version:        1,
+	}
+	if err := putScheduleRule(ctx, s); err != nil {
+		return nil, err
+	}
+	if err := newRepository[Schedule](schedulesTable()).Create(ctx, s, "schedule_id"); err != nil {
+		if derr := deleteScheduleRule(ctx, s.ScheduleID); derr != nil {
+			log.Printf("cleanup of rule for schedule %s failed: %v", s.ScheduleID, derr)
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetSchedule loads a schedule, returning ErrScheduleNotFound when missing.
+func GetSchedule(ctx context.Context, id string) (*Schedule, error) {
+	s, err := newRepository[Schedule](schedulesTable()).Get(ctx, map[string]any{"schedule_id": id})
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, ErrScheduleNotFound
+	}
+	return s, nil
+}
+
+// ListSchedules returns every schedule, ordered by creation time.
+func ListSchedules(ctx context.Context) ([]Schedule, error) {
+	table := schedulesTable()
+	paginator := dynamodb.NewScanPaginator(getDynamoClient(), &dynamodb.ScanInput{TableName: &table})
+	var out []Schedule
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var items []Schedule
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+	}
+	slices.SortFunc(out, func(a, b Schedule) int { return cmp.Compare(a.CreatedOn, b.CreatedOn) })
+	return out, nil
+}
+
+// UpdateSchedule replaces the spec of schedule id with optimistic locking on
+// expectedVersion, then updates its EventBridge rule. Errors match
+// ErrScheduleNotFound, ErrVersionConflict or ErrInvalidSchedule.
+func UpdateSchedule(ctx context.Context, id string, spec ScheduleSpec, expectedVersion int64) (*Schedule, error) {
+	if err := spec.normalize(); err != nil {
+		return nil, err
+	}
+	current, err := GetSchedule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if current.Version != expectedVersion {
+		return nil, &VersionConflictError{Table: schedulesTable(), Expected: expectedVersion}
+	}
+	updated := *current
+	updated.Name = spec.Name
+	updated.Sites = spec.Sites
+	updated.Parameter = spec.Parameter
+	updated.Cron = spec.Cron
+	updated.TrainEveryDays = spec.TrainEveryDays
+	updated.Enabled = *spec.Enabled
+	updated.UpdatedOn = time.Now().UTC().UnixMilli()
+	updated.Version = expectedVersion + 1
+
+	table := schedulesTable()
+	item, err := attributevalue.MarshalMap(updated)
+	if err != nil {
+		return nil, err
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{":expected": expectedVersion})
+	if err != nil {
+		return nil, err
+	}
+	_, err = getDynamoClient().PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 &table,
+		Item:                      item,
+		ConditionExpression:       awsString("attribute_exists(schedule_id) AND #version = :expected"),
+		ExpressionAttributeNames:  map[string]string{"#version": "version"},
+		ExpressionAttributeValues: values,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return nil, &VersionConflictError{Table: table, Expected: expectedVersion}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := putScheduleRule(ctx, &updated); err != nil {
+		return nil, fmt.Errorf("schedule %s saved but rule update failed: %w", id, err)
+	}
+	return &updated, nil
+}
+
+// DeleteSchedule removes the schedule's rule and then its record.
+func DeleteSchedule(ctx context.Context, id string) error {
+	if _, err := GetSchedule(ctx, id); err != nil {
+		return err
+	}
+	if err := deleteScheduleRule(ctx, id); err != nil {
+		return err
+	}
+	key, err := attributevalue.MarshalMap(map[string]any{"schedule_id": id})
+	if err != nil {
+		return err
+	}
+	table := schedulesTable()
+	_, err = getDynamoClient().DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: &table, Key: key})
+	return err
+}
+
+// shouldTrain reports whether a run at now is due to train under s's cadence.
+func (s *Schedule) shouldTrain(now time.Time) bool {
+	if s.TrainEveryDays <= 0 {
+		return false
+	}
+	if s.LastTrainedOn == 0 {
+		return true
+	}
+	return now.Sub(time.UnixMilli(s.LastTrainedOn)) >= time.Duration(s.TrainEveryDays)*24*time.Hour
+}
+
+// RunSchedule starts the pipeline for schedule id and records the run on the
+// schedule. Archived watchlist sites are left out. Disabled schedules, and
+// schedules whose sites are all archived, are skipped and return an empty
+// ARN.
+func RunSchedule(ctx context.Context, id string) (string, error) {
+	s, err := GetSchedule(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if !s.Enabled {
+		log.Printf("schedule %s is disabled; skipping", id)
+		return "", nil
+	}
+	now := time.Now().UTC()
+	train := s.shouldTrain(now)
+	sites := ExcludeArchivedSites(ctx, s.Sites)
+	if len(sites) == 0 {
+		log.Printf("schedule %s has only archived sites; skipping", id)
+		return "", nil
+	}
+	exec, err := StartIngest(ctx, IngestRequest{Sites: sites, Parameter: s.Parameter, Train: train})
+	if err != nil {
+		return "", err
+	}
+	execArn := exec.ExecutionArn
+	if exec.Existing {
+		log.Printf("schedule %s matched running execution %s", id, execArn)
+		return execArn, nil
+	}
+
+	set := "SET last_run_on = :now, last_execution_arn = :exec"
+	vals := map[string]any{":now": now.UnixMilli(), ":exec": execArn}
+	if train {
+		set += ", last_trained_on = :now"
+	}
+	key, err := attributevalue.MarshalMap(map[string]any{"schedule_id": id})
+	if err != nil {
+		return execArn, err
+	}
+	values, err := attributevalue.MarshalMap(vals)
+	if err != nil {
+		return execArn, err
+	}
+	table := schedulesTable()
+	if _, err := getDynamoClient().UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 &table,
+		Key:                       key,
+		UpdateExpression:          &set,
+		ConditionExpression:       awsString("attribute_exists(schedule_id)"),
+		ExpressionAttributeValues: values,
+	}); err != nil {
+		log.Printf("record run of schedule %s failed: %v", id, err)
+	}
+	return execArn, nil
+}
@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"aquawatch/internal/cache"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Scoped tokens give partners read-only access for public dashboards: a
+// JWT (typ=scoped) naming the sites and endpoints it may read, valid until
+// it expires or is revoked. Each issued token is recorded by jti, so admins
+// can list and revoke them; embedded tokens are public, so revocation is
+// the way to take one back.
+
+const (
+	// MaxScopedTokenSites bounds the sites of one token.
+	MaxScopedTokenSites = 200
+	// MaxScopedTokenDays bounds a token's lifetime.
+	MaxScopedTokenDays = 365
+)
+
+// ErrInvalidScopedToken is returned for token specs that fail validation.
+var ErrInvalidScopedToken = errors.New("invalid scoped token")
+
+// ErrScopedTokenNotFound is returned when a token ID has no record.
+var ErrScopedTokenNotFound = notFoundError("scoped token not found")
+
+// ScopedTokenRecord tracks an issued scoped token. The token itself is not
+// stored.
+// Table name defaults to "scoped-tokens"; override with SCOPED_TOKEN_TABLE.
+// Keys: PK jti. Items expire via expires_at.
+type ScopedTokenRecord struct {
+	JTI       string   `dynamodbav:"jti" json:"id"`
+	Label     string   `dynamodbav:"label" json:"label"`
+	Sites     []string `dynamodbav:"sites" json:"sites"`
+	Endpoints []string `dynamodbav:"endpoints" json:"endpoints"`
+	CreatedBy string   `dynamodbav:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedOn int64    `dynamodbav:"createdon" json:"createdon_ms"`
+	RevokedOn int64    `dynamodbav:"revokedon,omitempty" json:"revokedon_ms,omitempty"`
+	ExpiresAt int64    `dynamodbav:"expires_at" json:"expires_at"`
+}
+
+// ScopedTokenSpec is what a scoped token grants.
+type ScopedTokenSpec struct {
+	// Label says who the token is for, e.g. the partner's dashboard.
+	Label string   `json:"label"`
+	Sites []string `json:"sites"`
+	// Endpoints are route patterns (e.g. "/anomaly/latest"); the caller
+	// checks them against the routes that accept scoped tokens.
+	Endpoints []string `json:"endpoints"`
+	Days      int      `json:"expires_in_days"`
+}
+
+func (s *ScopedTokenSpec) normalize() error {
+	s.Label = strings.TrimSpace(s.Label)
+	if s.Label == "" || len(s.Label) > 100 {
+		return fmt.Errorf("%w: label is required (at most 100 characters)", ErrInvalidScopedToken)
+	}
+	sites := make([]string, 0, len(s.Sites))
+	for _, site := range s.Sites {
+		site = strings.TrimSpace(site)
+		if !siteIDPattern.MatchString(site) {
+			return fmt.Errorf("%w: invalid site id %q", ErrInvalidScopedToken, site)
+		}
+		if !slices.Contains(sites, site) {
+			sites = append(sites, site)
+		}
+	}
+	if len(sites) == 0 || len(sites) > MaxScopedTokenSites {
+		return fmt.Errorf("%w: between 1 and %d sites required", ErrInvalidScopedToken, MaxScopedTokenSites)
+	}
+	s.Sites = sites
+	if len(s.Endpoints) == 0 {
+		return fmt.Errorf("%w: at least one endpoint required", ErrInvalidScopedToken)
+	}
+	slices.Sort(s.Endpoints)
+	s.Endpoints = slices.Compact(s.Endpoints)
+	if s.Days == 0 {
+		s.Days = 30
+	}
+	if s.Days < 1 || s.Days > MaxScopedTokenDays {
+		return fmt.Errorf("%w: expires_in_days must be between 1 and %d", ErrInvalidScopedToken, MaxScopedTokenDays)
+	}
+	return nil
+}
+
+func scopedTokenTable() string {
+	return tableName("SCOPED_TOKEN_TABLE", "scoped-tokens")
+}
+
+// MintScopedToken issues a scoped token for spec and records it. Errors
+// match ErrInvalidScopedToken for bad specs.
+func MintScopedToken(ctx context.Context, spec ScopedTokenSpec, createdBy string) (string, *ScopedTokenRecord, error) {
+	if err := spec.normalize(); err != nil {
+		return "", nil, err
+	}
+	claims, err := newSessionClaims("scoped:"+spec.Label, TokenTypeScoped, time.Duration(spec.Days)*24*time.Hour)
+	if err != nil {
+		return "", nil, err
+	}
+	claims.Sites, claims.Endpoints = spec.Sites, spec.Endpoints
+	token, err := signJWT(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	record := &ScopedTokenRecord{
+		JTI:       claims.ID,
+		Label:     spec.Label,
+		Sites:     spec.Sites,
+		Endpoints: spec.Endpoints,
+		CreatedBy: createdBy,
+		CreatedOn: time.Now().UTC().UnixMilli(),
+		ExpiresAt: claims.ExpiresAt,
+	}
+	if err := newRepository[ScopedTokenRecord](scopedTokenTable()).Create(ctx, record, "jti"); err != nil {
+		return "", nil, fmt.Errorf("record scoped token: %w", err)
+	}
+	return token, record, nil
+}
+
+// scopedTokenCache holds token records for a minute, so a revocation takes
+// effect within a minute without a read per request.
+var scopedTokenCache = cache.New[string, *ScopedTokenRecord]("scoped-tokens", 1000, time.Minute)
+
+// ValidateScopedToken verifies a scoped token and returns its claims.
+// Revoked tokens and tokens without a record fail like invalid ones, with
+// an error matching ErrInvalidToken.
+func ValidateScopedToken(ctx context.Context, token string) (*SessionClaims, error) {
+	claims, err := parseJWT(token, TokenTypeScoped)
+	if err != nil {
+		return nil, err
+	}
+	record, err := scopedTokenCache.GetOrLoad(ctx, claims.ID, func(ctx context.Context) (*ScopedTokenRecord, error) {
+		return newRepository[ScopedTokenRecord](scopedTokenTable()).Get(ctx, map[string]any{"jti": claims.ID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("%w: unknown token", ErrInvalidToken)
+	}
+	if record.RevokedOn > 0 {
+		return nil, fmt.Errorf("%w: revoked", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// ListScopedTokens returns the recorded tokens, newest first. Expired
+// tokens are listed until the table's TTL removes them.
+func ListScopedTokens(ctx context.Context) ([]ScopedTokenRecord, error) {
+	table := scopedTokenTable()
+	paginator := dynamodb.NewScanPaginator(getDynamoClient(), &dynamodb.ScanInput{TableName: &table})
+	out := []ScopedTokenRecord{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var items []ScopedTokenRecord
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+	}
+	slices.SortFunc(out, func(a, b ScopedTokenRecord) int { return cmp.Compare(b.CreatedOn, a.CreatedOn) })
+	return out, nil
+}
+
+// RevokeScopedToken marks a token revoked; revoking twice is a no-op.
+// Errors match ErrScopedTokenNotFound.
+func RevokeScopedToken(ctx context.Context, id string) error {
+	table := scopedTokenTable()
+	key, err := attributevalue.MarshalMap(map[string]any{"jti": id})
+	if err != nil {
+		return err
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{":now": time.Now().UTC().UnixMilli()})
+	if err != nil {
+		return err
+	}
+	_, err = getDynamoClient().UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 &table,
+		Key:                       key,
+		UpdateExpression:          awsString("SET revokedon = if_not_exists(revokedon, :now)"),
+		ConditionExpression:       awsString("attribute_exists(jti)"),
+		ExpressionAttributeValues: values,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrScopedTokenNotFound
+	}
+	if err != nil {
+		return err
+	}
+	scopedTokenCache.Delete(id)
+	return nil
+}
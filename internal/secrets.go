@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"aquawatch/internal/cache"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// Credentials (SESSION_SECRET, the Vonage keys, the Foxit client) can live in
+// SSM Parameter Store or Secrets Manager instead of the environment. A
+// secret's value is resolved, in order, from:
+//
+//   - its env var holding a reference: ssm:<parameter name> reads a
+//     (decrypted) SSM parameter, secretsmanager:<secret id>[#<key>] a Secrets
+//     Manager secret, the key picking one field of a JSON secret;
+//   - with SECRETS_SSM_PATH set, the SSM parameter <path>/<NAME>, when it
+//     exists;
+//   - the env var's plain value.
+//
+// Resolved values are cached for SECRETS_REFRESH_MINUTES (default 15), so a
+// rotated secret is picked up within that time without a restart; flushing
+// the "secrets" cache (DELETE /admin/caches/secrets) picks it up at once. A
+// failed refresh keeps the previous value. LoadSecrets resolves them all at
+// startup.
+
+// Secret reference prefixes.
+const (
+	ssmSecretPrefix            = "ssm:"
+	secretsManagerSecretPrefix = "secretsmanager:"
+)
+
+// secretLoadTimeout bounds resolving one secret.
+const secretLoadTimeout = 5 * time.Second
+
+// managedSecrets are the secrets LoadSecrets resolves up front.
+var managedSecrets = []string{
+	"SESSION_SECRET",
+	"VONAGE_API_KEY",
+	"VONAGE_API_SECRET",
+	"FOXIT_CLIENT_ID",
+	"FOXIT_CLIENT_SECRET",
+}
+
+var (
+	secretCache = cache.New[string, string]("secrets", 64,
+		time.Duration(envInt("SECRETS_REFRESH_MINUTES", 15))*time.Minute)
+	// lastSecrets holds the last resolved value of each secret, served when a
+	// refresh fails.
+	lastSecrets sync.Map
+
+	ssmClientOnce            sync.Once
+	ssmClient                *ssm.Client
+	secretsManagerClientOnce sync.Once
+	secretsManagerClient     *secretsmanager.Client
+)
+
+func getSSMClient() *ssm.Client {
+	ssmClientOnce.Do(func() {
+		ssmClient = ssm.NewFromConfig(regionalConfig("SSM_REGION"))
+	})
+	return ssmClient
+}
+
+func getSecretsManagerClient() *secretsmanager.Client {
+	secretsManagerClientOnce.Do(func() {
+		secretsManagerClient = secretsmanager.NewFromConfig(regionalConfig("SECRETSMANAGER_REGION"))
+	})
+	return secretsManagerClient
+}
+
+// LoadSecrets resolves the managed secrets kept in SSM or Secrets Manager,
+// so a bad reference or missing permission fails at startup rather than on
+// the first sign-in.
+func LoadSecrets(ctx context.Context) error {
+	var errs []error
+	for _, name := range managedSecrets {
+		if !isSecretReference(os.Getenv(name)) && secretsSSMPath() == "" {
+			continue
+		}
+		if _, err := loadSecret(ctx, name); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// secretValue returns the current value of the secret name, empty when it is
+// unset or could never be resolved.
+func secretValue(name string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), secretLoadTimeout)
+	defer cancel()
+	v, err := loadSecret(ctx, name)
+	if err == nil {
+		return v
+	}
+	if last, ok := lastSecrets.Load(name); ok {
+		log.Printf("refreshing secret %s failed, keeping the previous value: %v", name, err)
+		return last.(string)
+	}
+	log.Printf("resolving secret %s failed: %v", name, err)
+	if plain := os.Getenv(name); !isSecretReference(plain) {
+		return plain
+	}
+	return ""
+}
+
+func loadSecret(ctx context.Context, name string) (string, error) {
+	return secretCache.GetOrLoad(ctx, name, func(ctx context.Context) (string, error) {
+		v, err := resolveSecret(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		lastSecrets.Store(name, v)
+		return v, nil
+	})
+}
+
+// resolveSecret reads the secret name from where it is kept.
+func resolveSecret(ctx context.Context, name string) (string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	switch {
+	case strings.HasPrefix(value, ssmSecretPrefix):
+		return ssmParameter(ctx, strings.TrimPrefix(value, ssmSecretPrefix))
+	case strings.HasPrefix(value, secretsManagerSecretPrefix):
+		return secretsManagerValue(ctx, strings.TrimPrefix(value, secretsManagerSecretPrefix))
+	}
+	if path := secretsSSMPath(); path != "" {
+		v, err := ssmParameter(ctx, path+"/"+name)
+		var nf *ssmtypes.ParameterNotFound
+		if !errors.As(err, &nf) {
+			return v, err
+		}
+	}
+	return os.Getenv(name), nil
+}
+
+func isSecretReference(value string) bool {
+	value = strings.TrimSpace(value)
+	return strings.HasPrefix(value, ssmSecretPrefix) || strings.HasPrefix(value, secretsManagerSecretPrefix)
+}
+
+// secretsSSMPath is SECRETS_SSM_PATH without a trailing slash.
+func secretsSSMPath() string {
+	return strings.TrimRight(strings.TrimSpace(os.Getenv("SECRETS_SSM_PATH")), "/")
+}
+
+func ssmParameter(ctx context.Context, name string) (string, error) {
+	out, err := getSSMClient().GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	if out.Parameter == nil {
+		return "", fmt.Errorf("ssm parameter %s has no value", name)
+	}
+	return aws.ToString(out.Parameter.Value), nil
+}
+
+// secretsManagerValue reads the secret ref, <secret id>[#<key>]: the secret
+// string, or the string field key of a JSON secret.
+func secretsManagerValue(ctx context.Context, ref string) (string, error) {
+	id, key, hasKey := strings.Cut(ref, "#")
+	out, err := getSecretsManagerClient().GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(id)})
+	if err != nil {
+		return "", err
+	}
+	secret := aws.ToString(out.SecretString)
+	if out.SecretString == nil {
+		secret = string(out.SecretBinary)
+	}
+	if !hasKey {
+		return secret, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object: %w", id, err)
+	}
+	v, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no string field %q", id, key)
+	}
+	return v, nil
+}
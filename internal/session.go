@@ -7,37 +7,80 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 )
 
-// MintSessionToken creates a signed token bound to a phone and expiry time.
-// Format: base64(phone|exp|sig) where sig=HMAC_SHA256(secret, phone|exp)
-func MintSessionToken(phoneE164 string, ttl time.Duration) (string, error) {
-	secret := os.Getenv("SESSION_SECRET")
-	if secret == "" {
-		return "", errors.New("SESSION_SECRET not configured")
+// SessionTTL is the access-token lifetime: SESSION_TTL_HOURS, default 12h.
+func SessionTTL() time.Duration {
+	return hoursFromEnv("SESSION_TTL_HOURS", 12*time.Hour)
+}
+
+// RefreshTTL is the refresh-token lifetime: REFRESH_TTL_HOURS, default 30 days.
+func RefreshTTL() time.Duration {
+	return hoursFromEnv("REFRESH_TTL_HOURS", 30*24*time.Hour)
+}
+
+func hoursFromEnv(envVar string, def time.Duration) time.Duration {
+	if v := os.Getenv(envVar); v != "" {
+		if d, err := time.ParseDuration(v + "h"); err == nil && d > 0 {
+			return d
+		}
 	}
-	exp := time.Now().Add(ttl).Unix()
-	payload := fmt.Sprintf("%s|%d", phoneE164, exp)
-	h := hmac.New(sha256.New, []byte(secret))
-	h.Write([]byte(payload))
-	sig := base64.RawURLEncoding.EncodeToString(h.Sum(nil))
-	token := base64.RawURLEncoding.EncodeToString([]byte(payload + "|" + sig))
-	return token, nil
+	return def
 }
 
-// ValidateSessionToken verifies signature and expiry.
-// Returns the bound phone if valid.
+// legacySessionTokensAllowed reports whether pre-JWT session tokens are still
+// accepted. On by default during the rollout; set SESSION_LEGACY_TOKENS=false
+// once every client has picked up a JWT.
+func legacySessionTokensAllowed() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("SESSION_LEGACY_TOKENS"))) {
+	case "false", "0", "no", "off":
+		return false
+	}
+	return true
+}
+
+// MintSessionToken creates a signed access token (HS256 JWT) bound to
+// userID, valid for ttl.
+func MintSessionToken(userID string, ttl time.Duration) (string, error) {
+	claims, err := newSessionClaims(userID, TokenTypeAccess, ttl)
+	if err != nil {
+		return "", err
+	}
+	return signJWT(claims)
+}
+
+// ValidateSessionToken verifies an access token and returns its subject, a
+// user ID. Legacy base64(phone|exp|sig) tokens, whose subject is the phone,
+// are accepted while SESSION_LEGACY_TOKENS allows them; UserForSubject
+// resolves either.
 func ValidateSessionToken(token string) (string, error) {
-	secret := os.Getenv("SESSION_SECRET")
-	if secret == "" {
-		return "", errors.New("SESSION_SECRET not configured")
+	if isJWT(token) {
+		claims, err := parseJWT(token, TokenTypeAccess)
+		if err != nil {
+			return "", err
+		}
+		return claims.Subject, nil
+	}
+	if !legacySessionTokensAllowed() {
+		return "", fmt.Errorf("%w: legacy session tokens are disabled", ErrInvalidToken)
+	}
+	return validateLegacySessionToken(token)
+}
+
+// validateLegacySessionToken verifies signature and expiry of a pre-JWT token.
+// Format: base64(phone|exp|sig) where sig=HMAC_SHA256(secret, phone|exp)
+func validateLegacySessionToken(token string) (string, error) {
+	secret, err := sessionSecret()
+	if err != nil {
+		return "", err
 	}
 	raw, err := base64.RawURLEncoding.DecodeString(token)
 	if err != nil {
 		return "", errors.New("invalid token encoding")
 	}
-	parts := bytesSplitN(string(raw), '|', 3)
+	parts := strings.SplitN(string(raw), "|", 3)
 	if len(parts) != 3 {
 		return "", errors.New("invalid token format")
 	}
@@ -50,7 +93,7 @@ func ValidateSessionToken(token string) (string, error) {
 	}
 	// verify signature
 	payload := phone + "|" + expStr
-	h := hmac.New(sha256.New, []byte(secret))
+	h := hmac.New(sha256.New, secret)
 	h.Write([]byte(payload))
 	expected := base64.RawURLEncoding.EncodeToString(h.Sum(nil))
 	if !hmac.Equal([]byte(expected), []byte(sig)) {
@@ -58,22 +101,3 @@ func ValidateSessionToken(token string) (string, error) {
 	}
 	return phone, nil
 }
-
-// bytesSplitN splits s on sep, like strings.SplitN but without importing strings for minimal footprint here.
-func bytesSplitN(s string, sep rune, n int) []string {
-	var res []string
-	start := 0
-	count := 0
-	for i, r := range s {
-		if r == sep {
-			res = append(res, s[start:i])
-			start = i + 1
-			count++
-			if count == n-1 {
-				break
-			}
-		}
-	}
-	res = append(res, s[start:])
-	return res
-}
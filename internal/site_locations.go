@@ -0,0 +1,249 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"aquawatch/internal/cache"
+)
+
+// SiteLocation is where a USGS station is, from the site service.
+type SiteLocation struct {
+	Site      string  `json:"site"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// siteLocationBatch is how many sites one site service request names.
+const siteLocationBatch = 100
+
+// siteLocationCache holds station locations for a day; stations don't move.
+// Sites the service doesn't know are cached as zero locations.
+var siteLocationCache = cache.New[string, SiteLocation]("site-locations", 5000, 24*time.Hour)
+
+// SiteLocations returns the locations of sites that the USGS site service
+// knows, keyed by site. Uncached sites are looked up in batches.
+func SiteLocations(ctx context.Context, sites []string) (map[string]SiteLocation, error) {
+	out := make(map[string]SiteLocation, len(sites))
+	var missing []string
+	for _, site := range sites {
+		if loc, ok := siteLocationCache.Get(site); ok {
+			if loc.Site != "" {
+				out[site] = loc
+			}
+			continue
+		}
+		missing = append(missing, site)
+	}
+	for batch := range slices.Chunk(missing, siteLocationBatch) {
+		found, err := fetchSiteLocations(ctx, batch)
+		if err != nil {
+			return out, err
+		}
+		for _, site := range batch {
+			loc := found[site]
+			siteLocationCache.Set(site, loc)
+			if loc.Site != "" {
+				out[site] = loc
+			}
+		}
+	}
+	return out, nil
+}
+
+func fetchSiteLocations(ctx context.Context, sites []string) (map[string]SiteLocation, error) {
+	if WaterDataProvider() == ProviderSynthetic {
+		return map[string]SiteLocation{}, nil
+	}
+	body, err := querySiteService(ctx, "siteStatus=all&sites="+strings.Join(sites, ","))
+	if err != nil {
+		return nil, err
+	}
+	return parseSiteLocations(body), nil
+}
+
+// RegionSites returns the active stream stations of a state (two-letter
+// postal code) or hydrologic unit (2- or 8-digit HUC) that report daily
+// values of parameter, in site order. Exactly one of state and huc is set.
+// The synthetic provider has no regions and returns an error.
+func RegionSites(ctx context.Context, state, huc, parameter string) ([]SiteLocation, error) {
+	if WaterDataProvider() == ProviderSynthetic {
+		return nil, errors.New("region lookups need the USGS provider")
+	}
+	query := "siteStatus=active&siteType=ST&hasDataTypeCd=dv&parameterCd=" + url.QueryEscape(parameter)
+	if state != "" {
+		query += "&stateCd=" + url.QueryEscape(state)
+	} else {
+		query += "&huc=" + url.QueryEscape(huc)
+	}
+	body, err := querySiteService(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	found := parseSiteLocations(body)
+	out := make([]SiteLocation, 0, len(found))
+	for _, site := range slices.Sorted(maps.Keys(found)) {
+		siteLocationCache.Set(site, found[site])
+		out = append(out, found[site])
+	}
+	return out, nil
+}
+
+// ErrInvalidBBox is returned by ParseBBox for malformed or oversized boxes.
+var ErrInvalidBBox = validationError("invalid bbox")
+
+// maxBBoxArea is the largest box the site service accepts: the product of
+// its latitude and longitude ranges, in degrees, may not exceed 25.
+const maxBBoxArea = 25
+
+// BBox is a map viewport in decimal degrees.
+type BBox struct {
+	MinLng float64 `json:"min_lng"`
+	MinLat float64 `json:"min_lat"`
+	MaxLng float64 `json:"max_lng"`
+	MaxLat float64 `json:"max_lat"`
+}
+
+// ParseBBox parses "minLng,minLat,maxLng,maxLat" and validates the box.
+func ParseBBox(s string) (BBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BBox{}, fmt.Errorf("%w: want minLng,minLat,maxLng,maxLat", ErrInvalidBBox)
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return BBox{}, fmt.Errorf("%w: %q is not a number", ErrInvalidBBox, p)
+		}
+		v[i] = f
+	}
+	b := BBox{MinLng: v[0], MinLat: v[1], MaxLng: v[2], MaxLat: v[3]}
+	return b, b.Validate()
+}
+
+// Validate checks that b is a non-empty box on the globe small enough for
+// the site service.
+func (b BBox) Validate() error {
+	switch {
+	case b.MinLng < -180 || b.MaxLng > 180 || b.MinLat < -90 || b.MaxLat > 90:
+		return fmt.Errorf("%w: coordinates out of range", ErrInvalidBBox)
+	case b.MinLng >= b.MaxLng || b.MinLat >= b.MaxLat:
+		return fmt.Errorf("%w: min must be below max", ErrInvalidBBox)
+	case (b.MaxLng-b.MinLng)*(b.MaxLat-b.MinLat) > maxBBoxArea:
+		return fmt.Errorf("%w: at most %d square degrees; zoom in", ErrInvalidBBox, maxBBoxArea)
+	}
+	return nil
+}
+
+// IsZero reports whether no box was given.
+func (b BBox) IsZero() bool { return b == BBox{} }
+
+// BBoxSites returns the active stream stations inside b that report daily
+// values of parameter, in site order. The synthetic provider has no
+// locations and returns an error.
+func BBoxSites(ctx context.Context, b BBox, parameter string) ([]SiteLocation, error) {
+	if WaterDataProvider() == ProviderSynthetic {
+		return nil, fmt.Errorf("%w: bounding box lookups need the USGS provider", ErrValidation)
+	}
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	bbox := strings.Join([]string{
+		strconv.FormatFloat(b.MinLng, 'f', 7, 64), strconv.FormatFloat(b.MinLat, 'f', 7, 64),
+		strconv.FormatFloat(b.MaxLng, 'f', 7, 64), strconv.FormatFloat(b.MaxLat, 'f', 7, 64),
+	}, ",")
+	body, err := querySiteService(ctx, "siteStatus=active&siteType=ST&hasDataTypeCd=dv&parameterCd="+url.QueryEscape(parameter)+"&bBox="+bbox)
+	if err != nil {
+		return nil, err
+	}
+	found := parseSiteLocations(body)
+	out := make([]SiteLocation, 0, len(found))
+	for _, site := range slices.Sorted(maps.Keys(found)) {
+		siteLocationCache.Set(site, found[site])
+		out = append(out, found[site])
+	}
+	return out, nil
+}
+
+// querySiteService runs an RDB query against the USGS site service and
+// returns the listing, which is empty when no site matches.
+func querySiteService(ctx context.Context, query string) ([]byte, error) {
+	ctx, cancel := withStageTimeout(ctx, StageFetch)
+	defer cancel()
+	resp, err := usgsClient.Get(ctx, "https://waterservices.usgs.gov/nwis/site/?format=rdb&"+query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: USGS site request failed: %w", ErrUpstreamUnavailable, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// No site matches.
+		return nil, nil
+	default:
+		return nil, upstreamStatusError("USGS site service", "query", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading USGS site response failed: %w", ErrUpstreamUnavailable, err)
+	}
+	return body, nil
+}
+
+// parseSiteLocations reads a USGS site service RDB listing. Sites without
+// coordinates are skipped.
+func parseSiteLocations(rdb []byte) map[string]SiteLocation {
+	out := map[string]SiteLocation{}
+	readRDB(rdb, func(col func(string) string) {
+		lat, err1 := strconv.ParseFloat(col("dec_lat_va"), 64)
+		lon, err2 := strconv.ParseFloat(col("dec_long_va"), 64)
+		site := col("site_no")
+		if site == "" || err1 != nil || err2 != nil {
+			return
+		}
+		out[site] = SiteLocation{Site: site, Name: col("station_nm"), Latitude: lat, Longitude: lon}
+	})
+	return out
+}
+
+// readRDB calls row for each data row of a USGS RDB (tab-separated) listing;
+// col returns a column of the row by name.
+func readRDB(rdb []byte, row func(col func(string) string)) {
+	var header []string
+	formatLine := false
+	sc := bufio.NewScanner(bytes.NewReader(rdb))
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if header == nil {
+			header, formatLine = fields, true
+			continue
+		}
+		if formatLine {
+			formatLine = false
+			continue
+		}
+		row(func(name string) string {
+			if i := slices.Index(header, name); i >= 0 && i < len(fields) {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		})
+	}
+}
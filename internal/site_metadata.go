@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"aquawatch/internal/cache"
+)
+
+// SiteMetadata is what clients show for a USGS station: its name, where it
+// is, and which parameters it reports (see SiteParameters).
+type SiteMetadata struct {
+	Site      string  `json:"site"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// HUC is the 8-digit hydrologic unit; State the two-letter postal code.
+	HUC        string          `json:"huc,omitempty"`
+	State      string          `json:"state,omitempty"`
+	Parameters []SiteParameter `json:"parameters"`
+}
+
+// siteMetadataCache holds expanded site listings for a day. Sites the
+// service doesn't know are cached as zero values.
+var siteMetadataCache = cache.New[string, SiteMetadata]("site-metadata", 5000, 24*time.Hour)
+
+// GetSiteMetadata returns the metadata of the sites that the USGS site
+// service knows, in the order of siteIDs. Uncached sites are looked up in
+// batches; the synthetic provider knows no sites.
+func GetSiteMetadata(ctx context.Context, siteIDs []string) ([]SiteMetadata, error) {
+	found := make(map[string]SiteMetadata, len(siteIDs))
+	var missing []string
+	for _, site := range siteIDs {
+		if md, ok := siteMetadataCache.Get(site); ok {
+			found[site] = md
+			continue
+		}
+		missing = append(missing, site)
+	}
+	for batch := range slices.Chunk(missing, siteLocationBatch) {
+		fetched, err := fetchSiteMetadata(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, site := range batch {
+			siteMetadataCache.Set(site, fetched[site])
+			found[site] = fetched[site]
+		}
+	}
+
+	var known []string
+	for _, site := range siteIDs {
+		if found[site].Site != "" {
+			known = append(known, site)
+		}
+	}
+	params, err := SiteParameters(ctx, known)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]SiteMetadata, 0, len(known))
+	for _, site := range known {
+		md := found[site]
+		md.Parameters = params[site]
+		out = append(out, md)
+	}
+	return out, nil
+}
+
+func fetchSiteMetadata(ctx context.Context, sites []string) (map[string]SiteMetadata, error) {
+	if WaterDataProvider() == ProviderSynthetic {
+		return map[string]SiteMetadata{}, nil
+	}
+	body, err := querySiteService(ctx, "siteStatus=all&siteOutput=expanded&sites="+strings.Join(sites, ","))
+	if err != nil {
+		return nil, err
+	}
+	return parseSiteMetadata(body), nil
+}
+
+// parseSiteMetadata reads an expanded USGS site service RDB listing. Sites
+// without coordinates are skipped.
+func parseSiteMetadata(rdb []byte) map[string]SiteMetadata {
+	out := map[string]SiteMetadata{}
+	readRDB(rdb, func(col func(string) string) {
+		lat, err1 := strconv.ParseFloat(col("dec_lat_va"), 64)
+		lon, err2 := strconv.ParseFloat(col("dec_long_va"), 64)
+		site := col("site_no")
+		if site == "" || err1 != nil || err2 != nil {
+			return
+		}
+		out[site] = SiteMetadata{
+			Site:      site,
+			Name:      col("station_nm"),
+			Latitude:  lat,
+			Longitude: lon,
+			HUC:       col("huc_cd"),
+			State:     fipsStates[col("state_cd")],
+		}
+	})
+	return out
+}
+
+// fipsStates maps the FIPS state codes of site listings to postal codes.
+var fipsStates = map[string]string{
+	"01": "AL", "02": "AK", "04": "AZ", "05": "AR", "06": "CA", "08": "CO",
+	"09": "CT", "10": "DE", "11": "DC", "12": "FL", "13": "GA", "15": "HI",
+	"16": "ID", "17": "IL", "18": "IN", "19": "IA", "20": "KS", "21": "KY",
+	"22": "LA", "23": "ME", "24": "MD", "25": "MA", "26": "MI", "27": "MN",
+	"28": "MS", "29": "MO", "30": "MT", "31": "NE", "32": "NV", "33": "NH",
+	"34": "NJ", "35": "NM", "36": "NY", "37": "NC", "38": "ND", "39": "OH",
+	"40": "OK", "41": "OR", "42": "PA", "44": "RI", "45": "SC", "46": "SD",
+	"47": "TN", "48": "TX", "49": "UT", "50": "VT", "51": "VA", "53": "WA",
+	"54": "WV", "55": "WI", "56": "WY", "60": "AS", "66": "GU", "69": "MP",
+	"72": "PR", "78": "VI",
+}
@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"aquawatch/internal/cache"
+	"aquawatch/internal/pipeline"
+)
+
+// The USGS series catalog lists, per site, each parameter it has reported as
+// instantaneous ("iv") or daily ("dv") values and over which dates. Those are
+// the two series the pipeline fetches, so a parameter outside the catalog
+// can't be ingested for the site.
+
+// SiteParameter is a parameter a site reports and its period of record.
+type SiteParameter struct {
+	Parameter string `json:"parameter"`
+	// DataTypes are "dv" (daily values) and/or "iv" (instantaneous values).
+	DataTypes []string `json:"data_types"`
+	BeginDate string   `json:"begin_date,omitempty"`
+	EndDate   string   `json:"end_date,omitempty"`
+}
+
+// syntheticSiteParameters are the parameters the synthetic generator models.
+var syntheticSiteParameters = []SiteParameter{
+	{Parameter: "00060", DataTypes: []string{"dv", "iv"}},
+	{Parameter: "00065", DataTypes: []string{"dv", "iv"}},
+}
+
+// siteParameterCache holds series catalogs for a day. Sites the catalog
+// doesn't know are cached as empty lists.
+var siteParameterCache = cache.New[string, []SiteParameter]("site-parameters", 5000, 24*time.Hour)
+
+// SiteParameters returns the parameters of each of sites, in parameter
+// order. Sites the USGS series catalog doesn't know map to empty lists.
+// Uncached sites are looked up in batches.
+func SiteParameters(ctx context.Context, sites []string) (map[string][]SiteParameter, error) {
+	out := make(map[string][]SiteParameter, len(sites))
+	var missing []string
+	for _, site := range sites {
+		if params, ok := siteParameterCache.Get(site); ok {
+			out[site] = params
+			continue
+		}
+		missing = append(missing, site)
+	}
+	for batch := range slices.Chunk(missing, siteLocationBatch) {
+		found, err := fetchSiteParameters(ctx, batch)
+		if err != nil {
+			return out, err
+		}
+		for _, site := range batch {
+			params := found[site]
+			if params == nil {
+				params = []SiteParameter{}
+			}
+			siteParameterCache.Set(site, params)
+			out[site] = params
+		}
+	}
+	return out, nil
+}
+
+func fetchSiteParameters(ctx context.Context, sites []string) (map[string][]SiteParameter, error) {
+	if WaterDataProvider() == ProviderSynthetic {
+		out := make(map[string][]SiteParameter, len(sites))
+		for _, site := range sites {
+			out[site] = syntheticSiteParameters
+		}
+		return out, nil
+	}
+	body, err := querySiteService(ctx, "siteStatus=all&seriesCatalogOutput=true&outputDataTypeCd=iv,dv&sites="+strings.Join(sites, ","))
+	if err != nil {
+		return nil, err
+	}
+	return parseSeriesCatalog(body), nil
+}
+
+// parseSeriesCatalog reads a USGS series catalog listing into each site's
+// parameters, merging the series (data types and statistics) of a
+// parameter into one period of record.
+func parseSeriesCatalog(rdb []byte) map[string][]SiteParameter {
+	bySite := map[string]map[string]*SiteParameter{}
+	readRDB(rdb, func(col func(string) string) {
+		site, code, dataType := col("site_no"), col("parm_cd"), col("data_type_cd")
+		if site == "" || code == "" || (dataType != "dv" && dataType != "iv") {
+			return
+		}
+		if bySite[site] == nil {
+			bySite[site] = map[string]*SiteParameter{}
+		}
+		p := bySite[site][code]
+		if p == nil {
+			p = &SiteParameter{Parameter: code}
+			bySite[site][code] = p
+		}
+		if !slices.Contains(p.DataTypes, dataType) {
+			p.DataTypes = append(p.DataTypes, dataType)
+			slices.Sort(p.DataTypes)
+		}
+		// Dates are YYYY-MM-DD, so they compare as strings.
+		if begin := col("begin_date"); begin != "" && (p.BeginDate == "" || begin < p.BeginDate) {
+			p.BeginDate = begin
+		}
+		if end := col("end_date"); end > p.EndDate {
+			p.EndDate = end
+		}
+	})
+	out := make(map[string][]SiteParameter, len(bySite))
+	for site, params := range bySite {
+		list := make([]SiteParameter, 0, len(params))
+		for _, p := range params {
+			list = append(list, *p)
+		}
+		slices.SortFunc(list, func(a, b SiteParameter) int { return strings.Compare(a.Parameter, b.Parameter) })
+		out[site] = list
+	}
+	return out
+}
+
+// CheckSiteParameter rejects parameter when a site's series catalog doesn't
+// list it, with an error matching pipeline.ErrInvalidInput. Malformed site
+// IDs and parameters are left to the usual validation. Checking is
+// best-effort: when the catalog can't be read the sites pass. The synthetic
+// provider generates any parameter and skips the check.
+func CheckSiteParameter(ctx context.Context, sites []string, parameter string) error {
+	if WaterDataProvider() == ProviderSynthetic || !parameterCodePattern.MatchString(parameter) {
+		return nil
+	}
+	var wellFormed []string
+	for _, site := range sites {
+		if siteIDPattern.MatchString(site) {
+			wellFormed = append(wellFormed, site)
+		}
+	}
+	catalogs, err := SiteParameters(ctx, wellFormed)
+	if err != nil {
+		log.Printf("series catalog lookup failed; not checking parameter %s: %v", parameter, err)
+		return nil
+	}
+	var unsupported []string
+	for _, site := range wellFormed {
+		if !slices.ContainsFunc(catalogs[site], func(p SiteParameter) bool { return p.Parameter == parameter }) {
+			unsupported = append(unsupported, site)
+		}
+	}
+	if len(unsupported) == 0 {
+		return nil
+	}
+	const shown = 10
+	list := strings.Join(unsupported[:min(len(unsupported), shown)], ", ")
+	if len(unsupported) > shown {
+		list += fmt.Sprintf(" and %d more", len(unsupported)-shown)
+	}
+	return &pipeline.ValidationError{
+		Field:  "parameter",
+		Reason: fmt.Sprintf("%s is not reported by station %s (see GET /stations/{site}/parameters)", parameter, list),
+	}
+}
@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Large anomaly sweeps and ingests are split into per-site (or per-chunk)
+// tasks on the SITE_TASK_QUEUE_URL SQS queue and processed by the
+// site_worker lambda, instead of running inside one API request. SQS
+// redelivers failed tasks and moves them to the dead-letter queue after the
+// queue's maxReceiveCount.
+
+// Site task kinds.
+const (
+	SiteTaskAnomaly      = "anomaly"
+	SiteTaskAnomalyShard = "anomaly_shard"
+	SiteTaskIngest       = "ingest"
+	SiteTaskBackfill     = "backfill"
+)
+
+// maxSendMessageBatch is the SQS SendMessageBatch entry limit.
+const maxSendMessageBatch = 10
+
+// ErrSiteQueueNotConfigured is returned when SITE_TASK_QUEUE_URL is unset.
+var ErrSiteQueueNotConfigured = errors.New("site task queue not configured")
+
+// SiteTask is one unit of queued work. Anomaly tasks carry a single site;
+// anomaly shard tasks the sites of one shard of a sweep (see Sweep); ingest
+// tasks carry a chunk of sites started as one execution.
+type SiteTask struct {
+	Kind      string   `json:"kind"`
+	BatchID   string   `json:"batch_id"`
+	Sites     []string `json:"sites"`
+	Parameter string   `json:"parameter"`
+	Train     bool     `json:"train,omitempty"`
+	// Backfill tasks carry one site and the chunk's days (YYYY-MM-DD).
+	BackfillID string `json:"backfill_id,omitempty"`
+	Start      string `json:"start,omitempty"`
+	End        string `json:"end,omitempty"`
+	// Shard identifies an anomaly shard task within its sweep (BatchID).
+	Shard string `json:"shard,omitempty"`
+}
+
+// SiteTaskResult is the outcome of a task. Evaluation is set for anomaly
+// tasks, Evaluations (already saved) for anomaly shard tasks, ExecutionArn
+// for ingest tasks and Rows for backfill tasks.
+type SiteTaskResult struct {
+	Evaluation   *AnomalyEvaluation
+	Evaluations  []AnomalyEvaluation
+	ExecutionArn string
+	Rows         int
+}
+
+var (
+	sqsClientOnce sync.Once
+	sqsClient     *sqs.Client
+)
+
+func getSQSClient() *sqs.Client {
+	sqsClientOnce.Do(func() {
+		sqsClient = sqs.NewFromConfig(regionalConfig("SQS_REGION"), sqsEndpointOptions)
+	})
+	return sqsClient
+}
+
+// SiteTaskQueueEnabled reports whether SITE_TASK_QUEUE_URL is configured.
+func SiteTaskQueueEnabled() bool {
+	return os.Getenv("SITE_TASK_QUEUE_URL") != ""
+}
+
+// IngestSitesPerRun is the most sites started in one execution
+// (INGEST_MAX_SITES_PER_RUN, default 25); larger ingests are queued in
+// chunks of this size.
+func IngestSitesPerRun() int {
+	return max(envInt("INGEST_MAX_SITES_PER_RUN", 25), 1)
+}
+
+// NewSiteTaskBatchID returns an ID shared by the tasks of one request.
+func NewSiteTaskBatchID() (string, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	return "batch_" + id, nil
+}
+
+// AnomalyTasks returns one anomaly task per site.
+func AnomalyTasks(batchID string, sites []string, parameter string) []SiteTask {
+	tasks := make([]SiteTask, 0, len(sites))
+	for _, site := range sites {
+		tasks = append(tasks, SiteTask{Kind: SiteTaskAnomaly, BatchID: batchID, Sites: []string{site}, Parameter: parameter})
+	}
+	return tasks
+}
+
+// IngestTasks splits sites into ingest tasks of at most IngestSitesPerRun
+// sites each.
+func IngestTasks(batchID string, sites []string, parameter string, train bool) []SiteTask {
+	size := IngestSitesPerRun()
+	var tasks []SiteTask
+	for start := 0; start < len(sites); start += size {
+		end := min(start+size, len(sites))
+		tasks = append(tasks, SiteTask{Kind: SiteTaskIngest, BatchID: batchID, Sites: sites[start:end], Parameter: parameter, Train: train})
+	}
+	return tasks
+}
+
+// EnqueueSiteTasks sends tasks to the site task queue in batches of 10.
+// Entries SQS rejects are reported in the error; the rest stay queued.
+func EnqueueSiteTasks(ctx context.Context, tasks []SiteTask) error {
+	queueURL := os.Getenv("SITE_TASK_QUEUE_URL")
+	if queueURL == "" {
+		return ErrSiteQueueNotConfigured
+	}
+	client := getSQSClient()
+	var failed int
+	for start := 0; start < len(tasks); start += maxSendMessageBatch {
+		end := min(start+maxSendMessageBatch, len(tasks))
+		entries := make([]sqstypes.SendMessageBatchRequestEntry, 0, end-start)
+		for i, t := range tasks[start:end] {
+			body, err := json.Marshal(t)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, sqstypes.SendMessageBatchRequestEntry{
+				Id:          awsString(strconv.Itoa(i)),
+				MessageBody: awsString(string(body)),
+			})
+		}
+		out, err := client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{QueueUrl: &queueURL, Entries: entries})
+		if err != nil {
+			return fmt.Errorf("enqueue site tasks: %w", err)
+		}
+		failed += len(out.Failed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("enqueue site tasks: %d of %d rejected", failed, len(tasks))
+	}
+	return nil
+}
+
+// RunSiteTask performs one queued task: an anomaly check for its site or
+// shard, an ingest execution for its chunk of sites, or a backfill chunk.
+func RunSiteTask(ctx context.Context, t SiteTask) (SiteTaskResult, error) {
+	if len(t.Sites) == 0 {
+		return SiteTaskResult{}, errors.New("site task has no sites")
+	}
+	switch t.Kind {
+	case SiteTaskAnomaly:
+		site := t.Sites[0]
+		res, err := ProcessInferAndDetect(ctx, site, t.Parameter)
+		if err != nil {
+			return SiteTaskResult{}, fmt.Errorf("anomaly check for %s: %w", site, err)
+		}
+		return SiteTaskResult{Evaluation: &AnomalyEvaluation{
+			Site:           site,
+			Parameter:      t.Parameter,
+			S3Key:          res.S3Key,
+			ObservedValue:  res.ObservedValue,
+			PredictedValue: res.PredictedValue,
+			PercentChange:  res.PercentChange,
+			Anomalous:      res.Anomalous,
+			Severity:       res.Severity,
+			Unit:           res.Unit,
+			Reason:         res.Reason,
+			Model:          res.Model,
+			Percentile:     res.Percentile,
+			LeadTime:       res.LeadTime,
+		}}, nil
+	case SiteTaskAnomalyShard:
+		evals, err := RunSweepShard(ctx, t)
+		if err != nil {
+			return SiteTaskResult{}, fmt.Errorf("sweep %s shard %s: %w", t.BatchID, t.Shard, err)
+		}
+		return SiteTaskResult{Evaluations: evals}, nil
+	case SiteTaskIngest:
+		// Chunks of a batch are disjoint, so batch + first site identifies
+		// the task and a redelivered message maps to the same execution.
+		exec, err := StartIngest(ctx, IngestRequest{
+			Sites:            t.Sites,
+			Parameter:        t.Parameter,
+			Train:            t.Train,
+			IdempotencyToken: t.BatchID + ":" + t.Sites[0],
+		})
+		if err != nil {
+			return SiteTaskResult{}, err
+		}
+		return SiteTaskResult{ExecutionArn: exec.ExecutionArn}, nil
+	case SiteTaskBackfill:
+		rows, err := RunBackfillChunk(ctx, t)
+		if err != nil {
+			return SiteTaskResult{}, fmt.Errorf("backfill %s chunk %s %s..%s: %w", t.BackfillID, t.Sites[0], t.Start, t.End, err)
+		}
+		return SiteTaskResult{Rows: rows}, nil
+	}
+	return SiteTaskResult{}, fmt.Errorf("unknown site task kind %q", t.Kind)
+}
@@ -0,0 +1,342 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"aquawatch/internal/httpclient"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// /sms/send is unauthenticated and every call costs a paid verification, so
+// sends are guarded by an optional client challenge and by rate limits kept
+// in DynamoDB (shared across API instances):
+//
+//   - cooldown: at most one send per phone every SMS_COOLDOWN_SECONDS (default 60)
+//   - per phone: SMS_PHONE_LIMIT sends (default 5) per SMS_RATE_WINDOW_MINUTES (default 60)
+//   - per IP:    SMS_IP_LIMIT sends (default 20) per window
+//
+// Magic-link emails and verification resends share the same limits, keyed by
+// address or request ID, and IP.
+//
+// Set SMS_RATE_LIMIT_ENABLED=false to disable the limits (e.g. local runs).
+
+// Rate limit scopes reported by RateLimitError.
+const (
+	RateLimitScopeCooldown = "cooldown"
+	RateLimitScopePhone    = "phone"
+	RateLimitScopeEmail    = "email"
+	RateLimitScopeRequest  = "request"
+	RateLimitScopeIP       = "ip"
+)
+
+// ErrRateLimited is matched (via errors.Is) by RateLimitError.
+var ErrRateLimited = throttledError("rate limited")
+
+// RateLimitError is returned when a send exceeds one of the limits.
+// RetryAfter is how long until the limit that tripped resets.
+type RateLimitError struct {
+	Scope      string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited (%s), retry after %s", e.Scope, e.RetryAfter)
+}
+
+// Is reports whether target is ErrRateLimited or its category, ErrThrottled.
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited || target == ErrThrottled
+}
+
+// ErrChallengeFailed is returned when a required send challenge is missing or invalid.
+var ErrChallengeFailed = errors.New("challenge failed")
+
+// smsRateLimitItem is a counter or cooldown record in the sms-rate-limits table.
+// Table name defaults to "sms-rate-limits"; override with SMS_RATE_LIMIT_TABLE.
+// Keys: PK limit_key. Items expire via expires_at.
+type smsRateLimitItem struct {
+	LimitKey  string `dynamodbav:"limit_key"`
+	Count     int    `dynamodbav:"count,omitempty"`
+	LastSent  int64  `dynamodbav:"last_sent,omitempty"`
+	ExpiresAt int64  `dynamodbav:"expires_at"`
+}
+
+// smsWindowLimit is a fixed-window send limit for one subject (phone, email or IP).
+type smsWindowLimit struct {
+	scope   string
+	subject string
+	limit   int
+}
+
+func smsRateLimitTable() string {
+	return tableName("SMS_RATE_LIMIT_TABLE", "sms-rate-limits")
+}
+
+func smsRateLimitEnabled() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("SMS_RATE_LIMIT_ENABLED"))) {
+	case "false", "0", "no", "off":
+		return false
+	}
+	return true
+}
+
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// phoneSeparators are the characters people write phone numbers with.
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "", "\u00a0", "")
+
+// NormalizePhoneE164 returns phone in E.164 form, dropping separators and
+// reading a leading 00 as +, so "+1 (555) 123-4567" and "001-555-123-4567"
+// are one number. ok is false when phone isn't an international number.
+func NormalizePhoneE164(phone string) (string, bool) {
+	p := phoneSeparators.Replace(strings.TrimSpace(phone))
+	if rest, found := strings.CutPrefix(p, "00"); found {
+		p = "+" + rest
+	}
+	if !e164Pattern.MatchString(p) {
+		return "", false
+	}
+	return p, true
+}
+
+// ReserveSMSSend records a send attempt for phone from ip, failing with
+// *RateLimitError if the cooldown or a window limit would be exceeded. The
+// cooldown and both counters are updated in one transaction, so a rejected
+// attempt does not consume quota. The phone is keyed in E.164 form, so
+// writing a number differently doesn't earn a separate quota.
+func ReserveSMSSend(ctx context.Context, phone, ip string) error {
+	if p, ok := NormalizePhoneE164(phone); ok {
+		phone = p
+	}
+	return reserveSend(ctx, RateLimitScopePhone, "phone:"+phone, ip)
+}
+
+// ReserveEmailSend applies the same cooldown and limits as ReserveSMSSend to
+// magic-link emails, keyed by the lowercased address instead of phone.
+func ReserveEmailSend(ctx context.Context, email, ip string) error {
+	return reserveSend(ctx, RateLimitScopeEmail, "email:"+strings.ToLower(strings.TrimSpace(email)), ip)
+}
+
+// ReserveSMSResend applies the same limits to resends of a pending
+// verification, keyed by its request ID (the phone is not known here).
+func ReserveSMSResend(ctx context.Context, requestID, ip string) error {
+	return reserveSend(ctx, RateLimitScopeRequest, "request:"+requestID, ip)
+}
+
+// reserveSend applies the cooldown and the per-subject and per-IP windows to
+// subject ("phone:<e164>" or "email:<address>").
+func reserveSend(ctx context.Context, scope, subject, ip string) error {
+	if !smsRateLimitEnabled() {
+		return nil
+	}
+	now := time.Now().UTC()
+	cooldown := time.Duration(envInt("SMS_COOLDOWN_SECONDS", 60)) * time.Second
+	window := time.Duration(envInt("SMS_RATE_WINDOW_MINUTES", 60)) * time.Minute
+	windowStart := now.Truncate(window)
+	windowEnd := windowStart.Add(window)
+
+	cooldownUpd, err := cooldownUpdate("cooldown#"+subject, now, cooldown)
+	if err != nil {
+		return err
+	}
+	items := []types.TransactWriteItem{{Update: cooldownUpd}}
+	scopes := []string{RateLimitScopeCooldown}
+	limits := []smsWindowLimit{{scope, subject, envInt("SMS_PHONE_LIMIT", 5)}}
+	if ip != "" {
+		limits = append(limits, smsWindowLimit{RateLimitScopeIP, "ip:" + ip, envInt("SMS_IP_LIMIT", 20)})
+	}
+	for _, l := range limits {
+		upd, err := counterUpdate(fmt.Sprintf("%s#%d", l.subject, windowStart.Unix()), l.limit, windowEnd)
+		if err != nil {
+			return err
+		}
+		items = append(items, types.TransactWriteItem{Update: upd})
+		scopes = append(scopes, l.scope)
+	}
+
+	_, err = getDynamoClient().TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return err
+	}
+	for i, reason := range canceled.CancellationReasons {
+		if i >= len(scopes) || reason.Code == nil || *reason.Code != "ConditionalCheckFailed" {
+			continue
+		}
+		retry := windowEnd.Sub(now)
+		if scopes[i] == RateLimitScopeCooldown {
+			var prev smsRateLimitItem
+			retry = cooldown
+			if attributevalue.UnmarshalMap(reason.Item, &prev) == nil && prev.LastSent > 0 {
+				retry = time.Unix(prev.LastSent, 0).Add(cooldown).Sub(now)
+			}
+		}
+		return &RateLimitError{Scope: scopes[i], RetryAfter: max(retry, time.Second)}
+	}
+	return err
+}
+
+// cooldownUpdate sets last_sent on key unless the previous send is more recent
+// than cooldown.
+func cooldownUpdate(key string, now time.Time, cooldown time.Duration) (*types.Update, error) {
+	k, err := attributevalue.MarshalMap(map[string]any{"limit_key": key})
+	if err != nil {
+		return nil, err
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{
+		":now":    now.Unix(),
+		":cutoff": now.Add(-cooldown).Unix(),
+		":exp":    now.Add(cooldown).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	table := smsRateLimitTable()
+	return &types.Update{
+		TableName:                           &table,
+		Key:                                 k,
+		UpdateExpression:                    awsString("SET last_sent = :now, expires_at = :exp"),
+		ConditionExpression:                 awsString("attribute_not_exists(last_sent) OR last_sent <= :cutoff"),
+		ExpressionAttributeValues:           values,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}, nil
+}
+
+// counterUpdate increments the fixed-window counter at key unless it has
+// already reached limit. The item expires when the window ends.
+func counterUpdate(key string, limit int, windowEnd time.Time) (*types.Update, error) {
+	k, err := attributevalue.MarshalMap(map[string]any{"limit_key": key})
+	if err != nil {
+		return nil, err
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{
+		":one":   1,
+		":limit": limit,
+		":exp":   windowEnd.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	table := smsRateLimitTable()
+	return &types.Update{
+		TableName:                 &table,
+		Key:                       k,
+		UpdateExpression:          awsString("SET expires_at = :exp ADD #count :one"),
+		ConditionExpression:       awsString("attribute_not_exists(#count) OR #count < :limit"),
+		ExpressionAttributeNames:  map[string]string{"#count": "count"},
+		ExpressionAttributeValues: values,
+	}, nil
+}
+
+// SMSChallenge carries the optional proof a client attaches to /sms/send.
+type SMSChallenge struct {
+	Phone        string
+	Timestamp    string // unix seconds, signed with Signature
+	Signature    string // hex HMAC_SHA256(SMS_CHALLENGE_SECRET, phone|timestamp)
+	CaptchaToken string
+	RemoteIP     string
+}
+
+// smsChallengeMaxSkew bounds how old (or far in the future) a signed
+// challenge timestamp may be.
+const smsChallengeMaxSkew = 5 * time.Minute
+
+// VerifySMSChallenge enforces the challenges that are configured:
+//
+//   - SMS_CHALLENGE_SECRET: first-party clients sign phone|timestamp with the
+//     shared secret (HMAC-SHA256, hex) and send a fresh timestamp.
+//   - CAPTCHA_SECRET: the CAPTCHA token is checked with a siteverify endpoint,
+//     CAPTCHA_VERIFY_URL (default Cloudflare Turnstile; reCAPTCHA and hCaptcha
+//     use the same protocol).
+//
+// With neither configured it returns nil.
+func VerifySMSChallenge(ctx context.Context, c SMSChallenge) error {
+	if secret := os.Getenv("SMS_CHALLENGE_SECRET"); secret != "" {
+		if err := verifySMSSignature(secret, c); err != nil {
+			return err
+		}
+	}
+	if secret := os.Getenv("CAPTCHA_SECRET"); secret != "" {
+		if err := verifyCaptcha(ctx, secret, c.CaptchaToken, c.RemoteIP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifySMSSignature(secret string, c SMSChallenge) error {
+	ts, err := strconv.ParseInt(c.Timestamp, 10, 64)
+	if err != nil || c.Signature == "" {
+		return fmt.Errorf("%w: missing signature", ErrChallengeFailed)
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > smsChallengeMaxSkew || skew < -smsChallengeMaxSkew {
+		return fmt.Errorf("%w: stale timestamp", ErrChallengeFailed)
+	}
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(c.Phone + "|" + c.Timestamp))
+	expected := hex.EncodeToString(h.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(c.Signature))) {
+		return fmt.Errorf("%w: bad signature", ErrChallengeFailed)
+	}
+	return nil
+}
+
+func captchaVerifyURL() string {
+	if v := os.Getenv("CAPTCHA_VERIFY_URL"); v != "" {
+		return v
+	}
+	return "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+}
+
+// captchaClient verifies challenge tokens. Tokens are single use, so the
+// POST isn't retried.
+var captchaClient = httpclient.New(httpclient.Options{Name: "captcha", Timeout: 5 * time.Second})
+
+func verifyCaptcha(ctx context.Context, secret, token, remoteIP string) error {
+	if token == "" {
+		return fmt.Errorf("%w: missing captcha token", ErrChallengeFailed)
+	}
+	form := url.Values{}
+	form.Set("secret", secret)
+	form.Set("response", token)
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, captchaVerifyURL(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := captchaClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return err
+	}
+	if !out.Success {
+		return fmt.Errorf("%w: captcha rejected", ErrChallengeFailed)
+	}
+	return nil
+}
@@ -3,18 +3,52 @@ package internal
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	sfntypes "github.com/aws/aws-sdk-go-v2/service/sfn/types"
 )
 
+var (
+	sfnClientOnce sync.Once
+	sfnClient     *sfn.Client
+)
+
+func getSFNClient() *sfn.Client {
+	sfnClientOnce.Do(func() {
+		sfnClient = sfn.NewFromConfig(regionalConfig("SFN_REGION"))
+	})
+	return sfnClient
+}
+
+// ErrExecutionExists is matched (via errors.Is) by ExecutionExistsError.
+var ErrExecutionExists = errors.New("execution already exists")
+
+// ExecutionExistsError is returned by StartStateMachine when an execution
+// with the requested name was already started with a different input.
+type ExecutionExistsError struct {
+	ExecutionArn string
+}
+
+func (e *ExecutionExistsError) Error() string {
+	return fmt.Sprintf("execution already exists: %s", e.ExecutionArn)
+}
+
+// Is reports whether target is ErrExecutionExists.
+func (e *ExecutionExistsError) Is(target error) bool { return target == ErrExecutionExists }
+
 // StartStateMachine starts an AWS Step Functions execution with the provided input.
 // The input can be any Go value that can be marshaled to JSON, or a raw []byte JSON payload.
-func StartStateMachine(ctx context.Context, stateMachineArn string, input any) (string, error) {
-	cfg := getAWSConfig()
-	client := sfn.NewFromConfig(cfg)
+// name must be unique per state machine (for 90 days); an empty name uses
+// exec-<nanos>. Reusing a name with a different input returns an
+// *ExecutionExistsError carrying the existing execution's ARN.
+func StartStateMachine(ctx context.Context, stateMachineArn, name string, input any) (string, error) {
+	client := getSFNClient()
 
 	var inputJSON []byte
 	switch v := input.(type) {
@@ -28,12 +62,18 @@ func StartStateMachine(ctx context.Context, stateMachineArn string, input any) (
 		inputJSON = b
 	}
 
-	execName := fmt.Sprintf("exec-%d", time.Now().UnixNano())
+	if name == "" {
+		name = fmt.Sprintf("exec-%d", time.Now().UnixNano())
+	}
 	out, err := client.StartExecution(ctx, &sfn.StartExecutionInput{
 		StateMachineArn: aws.String(stateMachineArn),
-		Name:            aws.String(execName),
+		Name:            aws.String(name),
 		Input:           aws.String(string(inputJSON)),
 	})
+	var exists *sfntypes.ExecutionAlreadyExists
+	if errors.As(err, &exists) {
+		return "", &ExecutionExistsError{ExecutionArn: executionArnFor(stateMachineArn, name)}
+	}
 	if err != nil {
 		return "", err
 	}
@@ -42,3 +82,9 @@ func StartStateMachine(ctx context.Context, stateMachineArn string, input any) (
 	}
 	return *out.ExecutionArn, nil
 }
+
+// executionArnFor returns the ARN of execution name of a standard state
+// machine (arn:...:stateMachine:<machine> -> arn:...:execution:<machine>:<name>).
+func executionArnFor(stateMachineArn, name string) string {
+	return strings.Replace(stateMachineArn, ":stateMachine:", ":execution:", 1) + ":" + name
+}
@@ -0,0 +1,385 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A station import onboards many stations at once: an explicit site list, or
+// every active stream station of a state or hydrologic unit. Each site is
+// checked against the USGS site service, added to a watchlist and, when
+// new, backfilled with recent history so it can be modeled right away. The
+// import record is the progress report: per-site outcomes plus the progress
+// of the backfills it started.
+
+const (
+	stationImportIDPrefix = "imp_"
+	// MaxStationImportSites matches the largest queued ingest or sweep.
+	MaxStationImportSites     = 1000
+	defaultImportBackfillDays = 365
+	maxImportBackfillDays     = 1825
+)
+
+// Per-site import outcomes.
+const (
+	SiteImportAdded = "added"
+	// SiteImportExisting marks sites already on the watchlist; they are not
+	// backfilled again.
+	SiteImportExisting = "existing"
+	SiteImportInvalid  = "invalid"
+	// SiteImportNotFound marks well-formed IDs the USGS site service doesn't
+	// know.
+	SiteImportNotFound = "not_found"
+	SiteImportFailed   = "failed"
+)
+
+// Station import statuses.
+const (
+	StationImportBackfilling = "backfilling"
+	StationImportCompleted   = "completed"
+)
+
+// ErrStationImportNotFound is returned when an import ID has no record.
+var ErrStationImportNotFound = notFoundError("station import not found")
+
+// ErrInvalidStationImport is returned for import specs that fail validation.
+var ErrInvalidStationImport = validationError("invalid station import")
+
+var (
+	stateCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+	hucPattern       = regexp.MustCompile(`^([0-9]{2}|[0-9]{8})$`)
+)
+
+// StationImportSite is the outcome of one site of an import.
+type StationImportSite struct {
+	Site   string `dynamodbav:"site" json:"site"`
+	Name   string `dynamodbav:"name,omitempty" json:"name,omitempty"`
+	Status string `dynamodbav:"status" json:"status"`
+	Error  string `dynamodbav:"error,omitempty" json:"error,omitempty"`
+}
+
+// StationImportProgress sums up the backfills of an import.
+type StationImportProgress struct {
+	ChunksTotal int   `json:"chunks_total"`
+	ChunksDone  int   `json:"chunks_done"`
+	Rows        int64 `json:"rows_written"`
+}
+
+// StationImport is the record and progress report of one import.
+// Table name defaults to "station-imports"; override with
+// STATION_IMPORTS_TABLE. Keys: PK import_id.
+type StationImport struct {
+	ImportID  string `dynamodbav:"import_id" json:"import_id"`
+	Watchlist string `dynamodbav:"watchlist" json:"watchlist"`
+	Parameter string `dynamodbav:"parameter" json:"parameter"`
+	// Source is "sites", "state:<code>" or "huc:<code>".
+	Source   string              `dynamodbav:"source" json:"source"`
+	Sites    []StationImportSite `dynamodbav:"sites" json:"sites"`
+	Added    int                 `dynamodbav:"added" json:"added"`
+	Existing int                 `dynamodbav:"existing" json:"existing"`
+	Rejected int                 `dynamodbav:"rejected" json:"rejected"`
+	Failed   int                 `dynamodbav:"failed" json:"failed"`
+	// BackfillFrom and BackfillTo bound the history loaded for added sites;
+	// empty when backfill was skipped.
+	BackfillFrom string   `dynamodbav:"backfill_from,omitempty" json:"backfill_from,omitempty"`
+	BackfillTo   string   `dynamodbav:"backfill_to,omitempty" json:"backfill_to,omitempty"`
+	BackfillIDs  []string `dynamodbav:"backfill_ids" json:"backfill_ids"`
+	// BackfillError says why some added sites weren't (fully) queued for
+	// backfill; resume the listed backfills or backfill them directly.
+	BackfillError string                 `dynamodbav:"backfill_error,omitempty" json:"backfill_error,omitempty"`
+	Status        string                 `dynamodbav:"-" json:"status"`
+	Progress      *StationImportProgress `dynamodbav:"-" json:"backfill,omitempty"`
+	CreatedBy     string                 `dynamodbav:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedOn     int64                  `dynamodbav:"createdon" json:"createdon_ms"`
+}
+
+// StationImportSpec requests an import of Sites, or of the stations of
+// State (postal code) or HUC; exactly one is given. Watchlist defaults to
+// DefaultWatchlist and Parameter to 00060. Added sites are backfilled
+// BackfillDays (default 365) unless Backfill is false.
+type StationImportSpec struct {
+	Sites        []string `json:"sites"`
+	State        string   `json:"state"`
+	HUC          string   `json:"huc"`
+	Watchlist    string   `json:"watchlist"`
+	Parameter    string   `json:"parameter"`
+	Backfill     *bool    `json:"backfill"`
+	BackfillDays int      `json:"backfill_days"`
+}
+
+// normalize fills defaults and validates the spec. Malformed site IDs are
+// not errors; the import reports them as invalid.
+func (s *StationImportSpec) normalize() error {
+	s.State = strings.ToUpper(strings.TrimSpace(s.State))
+	s.HUC = strings.TrimSpace(s.HUC)
+	given := 0
+	for _, set := range []bool{len(s.Sites) > 0, s.State != "", s.HUC != ""} {
+		if set {
+			given++
+		}
+	}
+	if given != 1 {
+		return fmt.Errorf("%w: exactly one of sites, state and huc is required", ErrInvalidStationImport)
+	}
+	if s.State != "" && !stateCodePattern.MatchString(s.State) {
+		return fmt.Errorf("%w: state must be a two-letter postal code", ErrInvalidStationImport)
+	}
+	if s.HUC != "" && !hucPattern.MatchString(s.HUC) {
+		return fmt.Errorf("%w: huc must be a 2- or 8-digit hydrologic unit code", ErrInvalidStationImport)
+	}
+	if (s.State != "" || s.HUC != "") && WaterDataProvider() == ProviderSynthetic {
+		return fmt.Errorf("%w: state and huc imports need the USGS provider", ErrInvalidStationImport)
+	}
+	seen := map[string]bool{}
+	sites := make([]string, 0, len(s.Sites))
+	for _, site := range s.Sites {
+		if site = strings.TrimSpace(site); site != "" && !seen[site] {
+			seen[site] = true
+			sites = append(sites, site)
+		}
+	}
+	if len(sites) > MaxStationImportSites {
+		return fmt.Errorf("%w: at most %d sites per import", ErrInvalidStationImport, MaxStationImportSites)
+	}
+	s.Sites = sites
+	s.Watchlist = strings.TrimSpace(s.Watchlist)
+	if s.Watchlist == "" {
+		s.Watchlist = DefaultWatchlist
+	}
+	if err := ValidateWatchlistName(s.Watchlist); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidStationImport, err)
+	}
+	if s.Parameter == "" {
+		s.Parameter = "00060"
+	}
+	if !parameterCodePattern.MatchString(s.Parameter) {
+		return fmt.Errorf("%w: parameter must be a 5-digit USGS parameter code", ErrInvalidStationImport)
+	}
+	if s.Backfill == nil {
+		s.Backfill = awsBool(true)
+	}
+	if s.BackfillDays == 0 {
+		s.BackfillDays = defaultImportBackfillDays
+	}
+	if s.BackfillDays < 1 || s.BackfillDays > maxImportBackfillDays {
+		return fmt.Errorf("%w: backfill_days must be between 1 and %d", ErrInvalidStationImport, maxImportBackfillDays)
+	}
+	return nil
+}
+
+func stationImportsTable() string {
+	return tableName("STATION_IMPORTS_TABLE", "station-imports")
+}
+
+// ImportStations validates spec's sites against the USGS site service, adds
+// the known ones to the watchlist, starts backfills for the newly added
+// ones and stores the import. Errors match ErrInvalidStationImport for bad
+// specs; failures of single sites or backfills are reported in the import.
+func ImportStations(ctx context.Context, spec StationImportSpec, createdBy string) (*StationImport, error) {
+	if err := spec.normalize(); err != nil {
+		return nil, err
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+	imp := &StationImport{
+		ImportID:    stationImportIDPrefix + id,
+		Watchlist:   spec.Watchlist,
+		Parameter:   spec.Parameter,
+		Source:      "sites",
+		BackfillIDs: []string{},
+		CreatedBy:   createdBy,
+		CreatedOn:   time.Now().UTC().UnixMilli(),
+	}
+
+	candidates, err := resolveImportSites(ctx, &spec, imp)
+	if err != nil {
+		return nil, err
+	}
+	var added []string
+	for _, c := range candidates {
+		if c.Status != "" {
+			imp.Sites = append(imp.Sites, c)
+			continue
+		}
+		ok, err := AddWatchlistSite(ctx, WatchlistEntry{
+			Watchlist: spec.Watchlist,
+			Site:      c.Site,
+			Name:      c.Name,
+			AddedBy:   createdBy,
+			ImportID:  imp.ImportID,
+		})
+		switch {
+		case err != nil:
+			log.Printf("import %s: adding %s to watchlist %s failed: %v", imp.ImportID, c.Site, spec.Watchlist, err)
+			c.Status, c.Error = SiteImportFailed, "failed to add to watchlist"
+		case ok:
+			c.Status = SiteImportAdded
+			added = append(added, c.Site)
+		default:
+			c.Status = SiteImportExisting
+		}
+		imp.Sites = append(imp.Sites, c)
+	}
+	for _, s := range imp.Sites {
+		switch s.Status {
+		case SiteImportAdded:
+			imp.Added++
+		case SiteImportExisting:
+			imp.Existing++
+		case SiteImportFailed:
+			imp.Failed++
+		default:
+			imp.Rejected++
+		}
+	}
+
+	var backfills []*Backfill
+	if *spec.Backfill && len(added) > 0 {
+		backfills = startImportBackfills(ctx, imp, added, spec.BackfillDays, createdBy)
+	}
+	if err := newRepository[StationImport](stationImportsTable()).Create(ctx, imp, "import_id"); err != nil {
+		return nil, fmt.Errorf("record station import: %w", err)
+	}
+	return imp.withStatus(backfills), nil
+}
+
+// resolveImportSites lists the import's sites, with Status set for the ones
+// that can't be added (malformed or unknown IDs). Region imports take the
+// site list from the site service and set imp.Source.
+func resolveImportSites(ctx context.Context, spec *StationImportSpec, imp *StationImport) ([]StationImportSite, error) {
+	if spec.State != "" || spec.HUC != "" {
+		kind, code := "state", spec.State
+		if spec.HUC != "" {
+			kind, code = "huc", spec.HUC
+		}
+		imp.Source = kind + ":" + code
+		locations, err := RegionSites(ctx, spec.State, spec.HUC, spec.Parameter)
+		if err != nil {
+			return nil, err
+		}
+		if len(locations) == 0 {
+			return nil, fmt.Errorf("%w: no active stations with daily %s values in %s %s", ErrInvalidStationImport, spec.Parameter, kind, code)
+		}
+		if len(locations) > MaxStationImportSites {
+			return nil, fmt.Errorf("%w: %s %s has %d stations, more than the %d per import; import smaller hydrologic units",
+				ErrInvalidStationImport, kind, code, len(locations), MaxStationImportSites)
+		}
+		out := make([]StationImportSite, 0, len(locations))
+		for _, loc := range locations {
+			out = append(out, StationImportSite{Site: loc.Site, Name: loc.Name})
+		}
+		return out, nil
+	}
+
+	var wellFormed []string
+	for _, site := range spec.Sites {
+		if siteIDPattern.MatchString(site) {
+			wellFormed = append(wellFormed, site)
+		}
+	}
+	// The synthetic provider generates data for any station ID.
+	var locations map[string]SiteLocation
+	if WaterDataProvider() != ProviderSynthetic && len(wellFormed) > 0 {
+		var err error
+		if locations, err = SiteLocations(ctx, wellFormed); err != nil {
+			return nil, err
+		}
+	}
+	out := make([]StationImportSite, 0, len(spec.Sites))
+	for _, site := range spec.Sites {
+		c := StationImportSite{Site: site}
+		switch {
+		case !siteIDPattern.MatchString(site):
+			c.Status, c.Error = SiteImportInvalid, "site ids are 8 to 15 digits"
+		case locations == nil:
+		default:
+			loc, ok := locations[site]
+			if !ok {
+				c.Status, c.Error = SiteImportNotFound, "unknown to the USGS site service"
+			}
+			c.Name = loc.Name
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// startImportBackfills backfills the last days days of sites, split into
+// backfills of at most maxBackfillSites sites, records them in imp and
+// returns them.
+func startImportBackfills(ctx context.Context, imp *StationImport, sites []string, days int, createdBy string) []*Backfill {
+	var out []*Backfill
+	to := time.Now().UTC()
+	imp.BackfillTo = to.Format(backfillDateLayout)
+	imp.BackfillFrom = to.AddDate(0, 0, 1-days).Format(backfillDateLayout)
+	for batch := range slices.Chunk(sites, maxBackfillSites) {
+		b, err := CreateBackfill(ctx, BackfillSpec{
+			Sites:     batch,
+			Parameter: imp.Parameter,
+			From:      imp.BackfillFrom,
+			To:        imp.BackfillTo,
+		}, createdBy)
+		if b != nil {
+			imp.BackfillIDs = append(imp.BackfillIDs, b.BackfillID)
+			out = append(out, b)
+		}
+		if errors.Is(err, ErrSiteQueueNotConfigured) {
+			imp.BackfillError = "backfills need the site task queue (SITE_TASK_QUEUE_URL)"
+			return out
+		}
+		if err != nil {
+			log.Printf("import %s: backfill of %d sites failed: %v", imp.ImportID, len(batch), err)
+			imp.BackfillError = "some backfills failed to start or queue"
+		}
+	}
+	return out
+}
+
+// GetStationImport loads an import with the progress of its backfills,
+// returning ErrStationImportNotFound when missing.
+func GetStationImport(ctx context.Context, id string) (*StationImport, error) {
+	imp, err := newRepository[StationImport](stationImportsTable()).Get(ctx, map[string]any{"import_id": id})
+	if err != nil {
+		return nil, err
+	}
+	if imp == nil {
+		return nil, ErrStationImportNotFound
+	}
+	backfills := make([]*Backfill, 0, len(imp.BackfillIDs))
+	for _, bid := range imp.BackfillIDs {
+		b, err := GetBackfill(ctx, bid)
+		if err != nil {
+			return nil, fmt.Errorf("backfill %s: %w", bid, err)
+		}
+		backfills = append(backfills, b)
+	}
+	return imp.withStatus(backfills), nil
+}
+
+// withStatus derives the import's status and progress from its backfills.
+// The import is completed once every backfill is.
+func (imp *StationImport) withStatus(backfills []*Backfill) *StationImport {
+	imp.Status = StationImportCompleted
+	if len(imp.BackfillIDs) == 0 {
+		return imp
+	}
+	p := &StationImportProgress{}
+	for _, b := range backfills {
+		p.ChunksTotal += b.ChunksTotal
+		p.ChunksDone += b.ChunksDone
+		p.Rows += b.Rows
+	}
+	if len(backfills) < len(imp.BackfillIDs) || p.ChunksDone < p.ChunksTotal {
+		imp.Status = StationImportBackfilling
+	}
+	imp.Progress = p
+	return imp
+}
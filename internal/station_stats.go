@@ -0,0 +1,318 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"time"
+
+	"aquawatch/internal/cache"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Each station keeps a rolling statistics record per parameter, updated on
+// ingest from the daily means of the fetched data, so detectors and the API
+// read precomputed statistics instead of re-scanning datasets in S3. The
+// record holds the last year of daily means (about 8 KB) and the statistics
+// of the 30, 90 and 365 days ending on the day it was last updated.
+
+// Rolling windows kept for each station, in days.
+var statsWindows = []int{30, 90, 365}
+
+// ErrStationStatsNotFound is returned when a station has no statistics for
+// a parameter yet.
+var ErrStationStatsNotFound = notFoundError("station statistics not found")
+
+// StationStats is a station's rolling statistics for one parameter.
+// Table name defaults to "station-stats"; override with STATION_STATS_TABLE.
+// Keys: PK site (String), SK parameter (String).
+type StationStats struct {
+	Site      string `dynamodbav:"site" json:"site"`
+	Parameter string `dynamodbav:"parameter" json:"parameter"`
+	// Windows are keyed "30d", "90d" and "365d"; a window without any daily
+	// mean is left out.
+	Windows   map[string]WindowStats `dynamodbav:"windows" json:"windows"`
+	Daily     []DailyMean            `dynamodbav:"daily" json:"-"`
+	LatestDay string                 `dynamodbav:"latest_day" json:"latest_day"`
+	UpdatedOn int64                  `dynamodbav:"updatedon" json:"updatedon_ms"`
+	Version   int64                  `dynamodbav:"version" json:"version"`
+}
+
+// DailyMean is the mean of one day's observations (a local date at the
+// station, YYYY-MM-DD).
+type DailyMean struct {
+	Day  string  `dynamodbav:"d" json:"day"`
+	Mean float64 `dynamodbav:"v" json:"mean"`
+}
+
+// WindowStats summarizes the daily means in one window. Std is the sample
+// standard deviation; percentiles interpolate linearly between days.
+type WindowStats struct {
+	Days int     `dynamodbav:"days" json:"days"`
+	Mean float64 `dynamodbav:"mean" json:"mean"`
+	Std  float64 `dynamodbav:"std" json:"std"`
+	Min  float64 `dynamodbav:"min" json:"min"`
+	Max  float64 `dynamodbav:"max" json:"max"`
+	P10  float64 `dynamodbav:"p10" json:"p10"`
+	P25  float64 `dynamodbav:"p25" json:"p25"`
+	P50  float64 `dynamodbav:"p50" json:"p50"`
+	P75  float64 `dynamodbav:"p75" json:"p75"`
+	P90  float64 `dynamodbav:"p90" json:"p90"`
+}
+
+func stationStatsTable() string {
+	return tableName("STATION_STATS_TABLE", "station-stats")
+}
+
+// stationStatsCache holds recently read records by site and parameter.
+// Stats change at most once per ingest, so a few minutes' staleness is fine;
+// updates made in this process replace the cached copy.
+var stationStatsCache = cache.New[string, *StationStats]("station-stats", 1000, 5*time.Minute)
+
+// GetStationStats returns the site's statistics for parameter, or an error
+// matching ErrStationStatsNotFound.
+func GetStationStats(ctx context.Context, site, parameter string) (*StationStats, error) {
+	key := site + "/" + parameter
+	if s, ok := stationStatsCache.Get(key); ok {
+		return s, nil
+	}
+	s, err := newRepository[StationStats](stationStatsTable()).Get(ctx, map[string]string{"site": site, "parameter": parameter})
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, ErrStationStatsNotFound
+	}
+	stationStatsCache.Set(key, s)
+	return s, nil
+}
+
+// RecordStationStats folds the daily means of raw USGS payloads into each
+// station's statistics for parameter. Payloads that can't be parsed are
+// skipped; update failures are joined.
+func RecordStationStats(ctx context.Context, parameter string, payloads [][]byte) error {
+	var errs []error
+	for _, p := range payloads {
+		if len(p) == 0 {
+			continue
+		}
+		bySite, err := DailyMeansFromPayload(p)
+		if err != nil {
+			continue
+		}
+		for site, days := range bySite {
+			if _, err := UpdateStationStats(ctx, site, parameter, days); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", site, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DailyMeansFromPayload averages a USGS IV or DV payload's observations per
+// site and local day. Values equal to the series' no-data value are skipped.
+func DailyMeansFromPayload(raw []byte) (map[string]map[string]float64, error) {
+	var usgs USGSJSON
+	if err := json.Unmarshal(raw, &usgs); err != nil {
+		return nil, fmt.Errorf("failed to parse USGS JSON: %w", err)
+	}
+	return dailyMeans(&usgs), nil
+}
+
+// dailyMeans is DailyMeansFromPayload for a parsed payload.
+func dailyMeans(usgs *USGSJSON) map[string]map[string]float64 {
+	out := map[string]map[string]float64{}
+	for _, ts := range usgs.Value.TimeSeries {
+		if len(ts.SourceInfo.SiteCode) == 0 {
+			continue
+		}
+		site := ts.SourceInfo.SiteCode[0].Value
+		sums := map[string]float64{}
+		counts := map[string]int{}
+		for _, v := range ts.Values {
+			for _, point := range v.Value {
+				t, err := parseUSGSTime(point.DateTime)
+				if err != nil {
+					continue
+				}
+				value, err := strconv.ParseFloat(point.Value, 64)
+				if err != nil || value == ts.Variable.NoDataValue {
+					continue
+				}
+				day := t.Format(time.DateOnly)
+				sums[day] += value
+				counts[day]++
+			}
+		}
+		if len(counts) == 0 {
+			continue
+		}
+		if out[site] == nil {
+			out[site] = map[string]float64{}
+		}
+		for day, n := range counts {
+			out[site][day] = sums[day] / float64(n)
+		}
+	}
+	return out
+}
+
+// UpdateStationStats merges days (daily means by YYYY-MM-DD, replacing any
+// stored mean for the same day) into the site's record and recomputes its
+// windows. Concurrent updates of a record are retried on version conflicts.
+// Days older than the longest window are ignored; when none remain, the
+// record is left as is.
+func UpdateStationStats(ctx context.Context, site, parameter string, days map[string]float64) (*StationStats, error) {
+	now := time.Now().UTC()
+	oldest := now.AddDate(0, 0, -slices.Max(statsWindows)+1).Format(time.DateOnly)
+	fresh := map[string]float64{}
+	for day, v := range days {
+		if day >= oldest {
+			fresh[day] = v
+		}
+	}
+	if len(fresh) == 0 {
+		return nil, nil
+	}
+
+	repo := newRepository[StationStats](stationStatsTable())
+	for attempt := 1; ; attempt++ {
+		cur, err := repo.Get(ctx, map[string]string{"site": site, "parameter": parameter})
+		if err != nil {
+			return nil, err
+		}
+		if cur == nil {
+			cur = &StationStats{Site: site, Parameter: parameter}
+		}
+		next := *cur
+		next.Daily = mergeDailyMeans(cur.Daily, fresh, oldest)
+		next.Windows = computeWindows(next.Daily, now)
+		next.LatestDay = next.Daily[len(next.Daily)-1].Day
+		next.UpdatedOn = now.UnixMilli()
+		next.Version = cur.Version + 1
+
+		err = putStationStats(ctx, &next, cur.Version)
+		if err == nil {
+			stationStatsCache.Set(site+"/"+parameter, &next)
+			return &next, nil
+		}
+		if !errors.Is(err, ErrVersionConflict) || attempt >= 5 {
+			return nil, err
+		}
+		time.Sleep(time.Duration(20+rand.IntN(80*attempt)) * time.Millisecond)
+	}
+}
+
+func putStationStats(ctx context.Context, s *StationStats, expectedVersion int64) error {
+	table := stationStatsTable()
+	item, err := attributevalue.MarshalMap(s)
+	if err != nil {
+		return err
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{":expected": expectedVersion})
+	if err != nil {
+		return err
+	}
+	_, err = getDynamoClient().PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 &table,
+		Item:                      item,
+		ConditionExpression:       awsString(versionCondition(expectedVersion)),
+		ExpressionAttributeNames:  map[string]string{"#version": "version"},
+		ExpressionAttributeValues: values,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return &VersionConflictError{Table: table, Expected: expectedVersion}
+	}
+	return err
+}
+
+// mergeDailyMeans returns stored with fresh merged in (fresh wins), without
+// days before oldest, sorted by day.
+func mergeDailyMeans(stored []DailyMean, fresh map[string]float64, oldest string) []DailyMean {
+	byDay := make(map[string]float64, len(stored)+len(fresh))
+	for _, d := range stored {
+		byDay[d.Day] = d.Mean
+	}
+	for day, v := range fresh {
+		byDay[day] = v
+	}
+	out := make([]DailyMean, 0, len(byDay))
+	for day, v := range byDay {
+		if day >= oldest {
+			out = append(out, DailyMean{Day: day, Mean: v})
+		}
+	}
+	slices.SortFunc(out, func(a, b DailyMean) int { return cmp.Compare(a.Day, b.Day) })
+	return out
+}
+
+// computeWindows summarizes the daily means of each window ending on now's
+// day.
+func computeWindows(daily []DailyMean, now time.Time) map[string]WindowStats {
+	windows := map[string]WindowStats{}
+	for _, n := range statsWindows {
+		from := now.AddDate(0, 0, -n+1).Format(time.DateOnly)
+		var values []float64
+		for _, d := range daily {
+			if d.Day >= from {
+				values = append(values, d.Mean)
+			}
+		}
+		if len(values) > 0 {
+			windows[strconv.Itoa(n)+"d"] = summarize(values)
+		}
+	}
+	return windows
+}
+
+func summarize(values []float64) WindowStats {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	mean := sum / float64(len(sorted))
+	var std float64
+	if len(sorted) > 1 {
+		var sq float64
+		for _, v := range sorted {
+			sq += (v - mean) * (v - mean)
+		}
+		std = math.Sqrt(sq / float64(len(sorted)-1))
+	}
+	return WindowStats{
+		Days: len(sorted),
+		Mean: mean,
+		Std:  std,
+		Min:  sorted[0],
+		Max:  sorted[len(sorted)-1],
+		P10:  percentile(sorted, 10),
+		P25:  percentile(sorted, 25),
+		P50:  percentile(sorted, 50),
+		P75:  percentile(sorted, 75),
+		P90:  percentile(sorted, 90),
+	}
+}
+
+// percentile returns the p-th percentile of sorted values, interpolating
+// linearly between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := min(lo+1, len(sorted)-1)
+	return sorted[lo] + (rank-float64(lo))*(sorted[hi]-sorted[lo])
+}
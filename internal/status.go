@@ -0,0 +1,246 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"aquawatch/internal/cache"
+	"aquawatch/internal/httpclient"
+)
+
+// The public status page summarizes the system without exposing sites'
+// data: how many stations are monitored, how many alerts are open, when an
+// ingest last succeeded and whether the upstream feeds answer. It is
+// computed at most once per StatusMaxAge, so anonymous traffic costs a few
+// queries and probes a minute.
+
+// StatusMaxAge is how long a computed status is served.
+const StatusMaxAge = time.Minute
+
+// Status values.
+const (
+	StatusOperational = "operational"
+	StatusDegraded    = "degraded"
+	StatusUnknown     = "unknown"
+)
+
+const (
+	// activeAlertLookback bounds the alerts counted as active.
+	activeAlertLookback = 7 * 24 * time.Hour
+	// maxCountedAlerts bounds the alerts read per status computation.
+	maxCountedAlerts = 1000
+	// statusProbeTimeout bounds each data source probe.
+	statusProbeTimeout = 5 * time.Second
+)
+
+// SystemStatus is the public status summary.
+type SystemStatus struct {
+	Status            string `json:"status"`
+	MonitoredStations int    `json:"monitored_stations"`
+	ActiveAlerts      int    `json:"active_alerts"`
+	// LastIngestOn is when the newest successful ingest started (epoch ms);
+	// zero when none succeeded in the last week.
+	LastIngestOn int64              `json:"last_successful_ingest_ms,omitempty"`
+	Components   []ComponentStatus  `json:"components"`
+	DataSources  []DataSourceStatus `json:"data_sources"`
+	GeneratedOn  int64              `json:"generated_on_ms"`
+}
+
+// ComponentStatus is the health of one part of the system.
+type ComponentStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// DataSourceStatus is whether an upstream feed answered its probe.
+type DataSourceStatus struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latency_ms,omitempty"`
+}
+
+// statusProbes are the upstream requests that tell whether a feed is up:
+// small, and answered without data for any particular site.
+var statusProbes = []struct {
+	name   string
+	client *httpclient.Client
+	url    string
+}{
+	{"usgs", usgsClient, "https://waterservices.usgs.gov/nwis/site/?format=rdb&sites=03339000"},
+	{"nws", nwsClient, "https://api.weather.gov/"},
+}
+
+var statusCache = cache.New[string, SystemStatus]("system-status", 1, StatusMaxAge)
+
+// GetSystemStatus returns the public status, computing it when the cached
+// one is older than StatusMaxAge. Lookups that fail are logged and mark
+// their component degraded; they don't fail the status.
+func GetSystemStatus(ctx context.Context) (SystemStatus, error) {
+	return statusCache.GetOrLoad(ctx, "status", func(ctx context.Context) (SystemStatus, error) {
+		// A caller hanging up mustn't leave a degraded status cached.
+		return computeSystemStatus(context.WithoutCancel(ctx)), nil
+	})
+}
+
+func computeSystemStatus(ctx context.Context) SystemStatus {
+	now := time.Now().UTC()
+	s := SystemStatus{Status: StatusOperational, GeneratedOn: now.UnixMilli()}
+
+	database := StatusOperational
+	stations, err := MonitoredStations(ctx)
+	if err != nil {
+		log.Printf("status: counting stations failed: %v", err)
+		database = StatusDegraded
+	}
+	s.MonitoredStations = len(stations)
+	alerts, err := countActiveAlerts(ctx, now.Add(-activeAlertLookback))
+	if err != nil {
+		log.Printf("status: counting alerts failed: %v", err)
+		database = StatusDegraded
+	}
+	s.ActiveAlerts = alerts
+
+	pipeline := StatusOperational
+	s.LastIngestOn, err = lastSuccessfulIngest(ctx, now.Add(-7*24*time.Hour))
+	switch {
+	case err != nil:
+		log.Printf("status: reading pipeline runs failed: %v", err)
+		database, pipeline = StatusDegraded, StatusUnknown
+	case s.LastIngestOn == 0:
+		pipeline = StatusUnknown
+	case now.Sub(time.UnixMilli(s.LastIngestOn)) > ingestStaleAfter():
+		pipeline = StatusDegraded
+	}
+	s.Components = []ComponentStatus{{"api", StatusOperational}, {"database", database}, {"pipeline", pipeline}}
+
+	for _, p := range statusProbes {
+		s.DataSources = append(s.DataSources, probeDataSource(ctx, p.name, p.client, p.url))
+	}
+
+	for _, c := range s.Components {
+		if c.Status == StatusDegraded {
+			s.Status = StatusDegraded
+		}
+	}
+	for _, d := range s.DataSources {
+		if d.Status != StatusOperational {
+			s.Status = StatusDegraded
+		}
+	}
+	return s
+}
+
+// ingestStaleAfter is how old the last successful ingest may be before the
+// pipeline counts as degraded (STATUS_INGEST_STALE_HOURS, default 26: a
+// daily schedule plus slack).
+func ingestStaleAfter() time.Duration {
+	return time.Duration(envInt("STATUS_INGEST_STALE_HOURS", 26)) * time.Hour
+}
+
+// MonitoredStations returns the distinct stations of enabled schedules,
+// basins and watchlists, sorted. Archived sites (see ArchivedSites) are left
+// out.
+func MonitoredStations(ctx context.Context) ([]string, error) {
+	sites := map[string]bool{}
+	schedules, err := ListSchedules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, sch := range schedules {
+		if sch.Enabled {
+			for _, site := range sch.Sites {
+				sites[site] = true
+			}
+		}
+	}
+	basins, err := ListBasins(ctx)
+	if err != nil {
+		return slices.Sorted(maps.Keys(sites)), err
+	}
+	for _, b := range basins {
+		for _, site := range b.Sites {
+			sites[site] = true
+		}
+	}
+	watched, err := ListWatchlistEntries(ctx)
+	if err != nil {
+		return slices.Sorted(maps.Keys(sites)), err
+	}
+	for _, e := range watched {
+		sites[e.Site] = true
+	}
+	for site := range archivedSites(watched) {
+		delete(sites, site)
+	}
+	return slices.Sorted(maps.Keys(sites)), nil
+}
+
+// countActiveAlerts counts alerts created since since that aren't resolved.
+func countActiveAlerts(ctx context.Context, since time.Time) (int, error) {
+	var count, read int
+	var cursor string
+	for read < maxCountedAlerts {
+		items, next, err := ListRecentAlertsPage(ctx, since.UnixMilli(), 200, cursor)
+		if err != nil {
+			return count, err
+		}
+		read += len(items)
+		for _, it := range items {
+			if it.State != AlertStateResolved {
+				count++
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	return count, nil
+}
+
+// lastSuccessfulIngest returns the start time of the newest succeeded run
+// since since, or 0.
+func lastSuccessfulIngest(ctx context.Context, since time.Time) (int64, error) {
+	f := PipelineRunFilter{Status: PipelineRunSucceeded, From: since}
+	var cursor string
+	// The status filter applies after the index read, so a page can be
+	// empty with more to come; a few pages cover a week of runs.
+	for range 5 {
+		runs, next, err := ListPipelineRunsPage(ctx, f, 100, cursor)
+		if err != nil {
+			return 0, err
+		}
+		if len(runs) > 0 {
+			return runs[0].StartedOn, nil
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	return 0, nil
+}
+
+func probeDataSource(ctx context.Context, name string, client *httpclient.Client, url string) DataSourceStatus {
+	ctx, cancel := context.WithTimeout(ctx, statusProbeTimeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := client.Get(ctx, url)
+	d := DataSourceStatus{Name: name, Status: StatusOperational, LatencyMs: time.Since(start).Milliseconds()}
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+	}
+	if err != nil {
+		log.Printf("status: %s probe failed: %v", name, err)
+		d.Status, d.LatencyMs = StatusDegraded, 0
+	}
+	return d
+}
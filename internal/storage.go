@@ -1,95 +1,191 @@
 package internal
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"aquawatch/internal/tracing"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
-// getAWSConfig returns the default resolved AWS configuration used to create
-// service clients in this package.
+var (
+	awsConfigOnce sync.Once
+	awsConfig     aws.Config
+	awsConfigErr  error
+
+	s3ClientOnce sync.Once
+	s3Client     *s3.Client
+)
+
+// AWSConfig returns the process-wide AWS configuration, loaded on first use
+// and reused for every service client. A load failure (e.g. a malformed
+// shared config file) is returned to every caller; commands call it at
+// startup to fail fast.
+func AWSConfig() (aws.Config, error) {
+	awsConfigOnce.Do(func() {
+		cfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			awsConfigErr = fmt.Errorf("load AWS config: %w", err)
+			return
+		}
+		applyLocalDefaults(&cfg)
+		// Traced lambdas record every SDK call as an X-Ray subsegment.
+		cfg.APIOptions = append(cfg.APIOptions, tracing.AWSMiddleware)
+		awsConfig = cfg
+	})
+	return awsConfig, awsConfigErr
+}
+
+// getAWSConfig returns the configuration service clients are built from.
+// When loading failed, its credentials report the load error, so every call
+// made with those clients fails with it rather than the process panicking.
 func getAWSConfig() aws.Config {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		panic("failed to load AWS config: " + err.Error())
+	cfg, err := AWSConfig()
+	if err == nil {
+		return cfg
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		// Without a region the SDK would fail before asking for credentials,
+		// hiding the load error.
+		region = "us-east-1"
 	}
-	return cfg
+	return aws.Config{Region: region, Credentials: failedCredentials{err}}
+}
+
+// failedCredentials is a credentials provider that always returns err.
+type failedCredentials struct{ err error }
+
+func (c failedCredentials) Retrieve(context.Context) (aws.Credentials, error) {
+	return aws.Credentials{}, c.err
 }
 
-// getS3Client constructs a new S3 client using default config.
+// getS3Client returns a process-wide S3 client, honoring any S3 endpoint
+// override.
 func getS3Client() *s3.Client {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		panic(err)
-	}
-	return s3.NewFromConfig(cfg)
+	s3ClientOnce.Do(func() {
+		s3Client = s3.NewFromConfig(regionalConfig("S3_REGION"), s3EndpointOptions)
+	})
+	return s3Client
 }
 
-// LoadFromS3 retrieves the full contents of an object at bucket/key.
-func LoadFromS3(ctx context.Context, bucket, key string) ([]byte, error) {
-	client := getS3Client()
-	out, err := client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
+var (
+	s3ReplicaOnce sync.Once
+	s3ReplicaRead *s3Replica
+)
+
+// s3Replica reads replicated buckets in the S3 failover region.
+type s3Replica struct {
+	client *s3.Client
+	f      *regionFailover
+	suffix string
+}
+
+// getS3Replica returns the failover reader, nil unless S3_FAILOVER_REGION
+// (or AWS_FAILOVER_REGION) is set.
+func getS3Replica() *s3Replica {
+	s3ReplicaOnce.Do(func() {
+		region := failoverRegion("S3_FAILOVER_REGION", "S3_ENDPOINT")
+		if region == "" {
+			return
+		}
+		suffix := strings.TrimSpace(os.Getenv("S3_FAILOVER_BUCKET_SUFFIX"))
+		if suffix == "" {
+			suffix = "-" + region
+		}
+		s3ReplicaRead = &s3Replica{
+			client: s3.NewFromConfig(getAWSConfig(), s3EndpointOptions, func(o *s3.Options) { o.Region = region }),
+			f:      &regionFailover{service: "s3", region: region},
+			suffix: suffix,
+		}
 	})
+	return s3ReplicaRead
+}
+
+// bucket returns the replica of bucket.
+func (r *s3Replica) bucket(bucket string) string {
+	return bucket + r.suffix
+}
+
+// LoadFromS3 retrieves the full contents of an object at bucket/key from the
+// configured BlobStore. Missing objects yield an error matching
+// ErrBlobNotFound; contents that don't match the stored SHA-256 yield an
+// error matching ErrChecksumMismatch.
+func LoadFromS3(ctx context.Context, bucket, key string) ([]byte, error) {
+	blob, err := getVerifiedBlob(ctx, bucket, key)
 	if err != nil {
 		return nil, err
 	}
-	defer out.Body.Close()
-	buf := new(bytes.Buffer)
-	_, err = buf.ReadFrom(out.Body)
+	return blob.Data, nil
+}
+
+// getVerifiedBlob reads bucket/key and checks its contents against the sha256
+// metadata entry, when present.
+func getVerifiedBlob(ctx context.Context, bucket, key string) (*Blob, error) {
+	blob, err := getBlobStore().Get(ctx, bucket, key)
 	if err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	if err := verifyChecksum(bucket, key, blob); err != nil {
+		return nil, err
+	}
+	return blob, nil
 }
 
 // SaveToS3 writes data to a time-based key under the bucket configured via the
 // S3_BUCKET environment variable. It returns the generated key on success.
 func SaveToS3(ctx context.Context, data []byte) (string, error) {
-	cfg := getAWSConfig()
-	client := s3.NewFromConfig(cfg)
 	bucket := os.Getenv("S3_BUCKET")
-	key := fmt.Sprintf("raw/%d.json", time.Now().Unix())
-	_, err := client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: &bucket,
-		Key:    &key,
-		Body:   bytes.NewReader(data),
-	})
-	if err != nil {
+	key := Layout().RawKey(time.Now())
+	if err := getBlobStore().Put(ctx, bucket, key, data, ObjectOptions(ctx, key, PurposeRaw, nil)); err != nil {
 		return "", err
 	}
 	return key, nil
 }
 
-// SaveToS3WithKey stores data to the specified bucket/key.
+// SaveToS3WithKey stores data to the specified bucket/key. The Content-Type is
+// derived from the key's extension; use SaveObject to attach metadata and tags.
 func SaveToS3WithKey(ctx context.Context, data []byte, bucket, key string) error {
-	client := getS3Client()
-	_, err := client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-		Body:   bytes.NewReader(data),
-	})
-	return err
+	return getBlobStore().Put(ctx, bucket, key, data, PutOptions{ContentType: contentTypeForKey(key)})
+}
+
+// SaveObject stores data to bucket/key with the given options, typically built
+// with ObjectOptions.
+func SaveObject(ctx context.Context, data []byte, bucket, key string, opts PutOptions) error {
+	return getBlobStore().Put(ctx, bucket, key, data, opts)
+}
+
+// UploadStreamToS3 streams r to bucket/key via a multipart upload, so large
+// datasets and batch-transform outputs never need to be held in memory in
+// full.
+func UploadStreamToS3(ctx context.Context, r io.Reader, bucket, key string, opts PutOptions) error {
+	return getBlobStore().PutStream(ctx, bucket, key, r, opts)
+}
+
+// envInt reads a positive integer from envVar, falling back to def.
+func envInt(envVar string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(envVar)); err == nil && n > 0 {
+		return n
+	}
+	return def
 }
 
 // GeneratePresignedGetURL returns a presigned GET url that expires after expiry.
 func GeneratePresignedGetURL(ctx context.Context, bucket, key string, expiry time.Duration) (string, error) {
-	cfg := getAWSConfig()
-	s3Client := s3.NewFromConfig(cfg)
-	presigner := s3.NewPresignClient(s3Client)
-	out, err := presigner.PresignGetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	}, s3.WithPresignExpires(expiry))
-	if err != nil {
-		return "", err
-	}
-	return out.URL, nil
+	return getBlobStore().PresignGet(ctx, bucket, key, expiry)
+}
+
+// GeneratePresignedPutURL returns a presigned PUT url for bucket/key that
+// expires after expiry. The client must upload with the given Content-Type.
+func GeneratePresignedPutURL(ctx context.Context, bucket, key, contentType string, expiry time.Duration) (string, error) {
+	return getBlobStore().PresignPut(ctx, bucket, key, contentType, expiry)
 }
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stream evaluation checks freshly ingested data against each station's
+// latest prediction right after preprocessing, so an ingest run alerts on
+// anomalies itself instead of waiting for the next /anomaly/check or sweep.
+// The prediction comes from the station's latest anomaly evaluation; the
+// observation is the newest value in the ingested payload.
+
+// StreamEvaluationEnabled reports whether preprocessing evaluates stations
+// on ingest (STREAM_EVALUATION_ENABLED).
+func StreamEvaluationEnabled() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("STREAM_EVALUATION_ENABLED"))) {
+	case "true", "1", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// streamPredictionMaxAge is how old a prediction may be and still be
+// compared to new observations (STREAM_PREDICTION_MAX_AGE_HOURS, default 24).
+func streamPredictionMaxAge() time.Duration {
+	return time.Duration(envInt("STREAM_PREDICTION_MAX_AGE_HOURS", 24)) * time.Hour
+}
+
+// EvaluateOnIngest compares each station's newest observation in payloads
+// with its latest prediction of parameter, saves the evaluations and alerts
+// on anomalous stations. Stations without a recent enough prediction are
+// skipped. It returns the saved evaluations.
+func EvaluateOnIngest(ctx context.Context, parameter string, payloads [][]byte) ([]AnomalyEvaluation, error) {
+	observed := map[string]float64{}
+	var sites []string
+	for _, p := range payloads {
+		if len(p) == 0 {
+			continue
+		}
+		latest, err := latestObservedBySite(p)
+		if err != nil {
+			continue
+		}
+		for site, v := range latest {
+			if _, ok := observed[site]; !ok {
+				sites = append(sites, site)
+			}
+			observed[site] = v
+		}
+	}
+	if len(sites) == 0 {
+		return nil, nil
+	}
+
+	prior, err := LatestAnomalyEvaluations(ctx, sites, parameter)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	oldest := now.Add(-streamPredictionMaxAge())
+	evals := make([]AnomalyEvaluation, 0, len(prior))
+	for _, p := range prior {
+		predictedOn := p.PredictionTime()
+		if predictedOn.Before(oldest) {
+			continue
+		}
+		obs := observed[p.Site]
+		verdict := judgeObservation(parameter, obs, p.PredictedValue)
+		// Only alerted stations need context; one ingest can cover many.
+		var rank *PercentileContext
+		if verdict.anomalous {
+			rank = ObservedPercentile(ctx, p.Site, parameter, obs, now)
+		}
+		evals = append(evals, AnomalyEvaluation{
+			Site:           p.Site,
+			EvaluatedOn:    now.UnixMilli(),
+			Parameter:      parameter,
+			S3Key:          p.S3Key,
+			ObservedValue:  obs,
+			PredictedValue: p.PredictedValue,
+			PercentChange:  verdict.percent,
+			Anomalous:      verdict.anomalous,
+			Severity:       verdict.severity,
+			Unit:           verdict.unit,
+			Reason:         verdict.reason,
+			Source:         EvaluationSourceStream,
+			PredictedOn:    predictedOn.UnixMilli(),
+			Model:          p.Model,
+			Percentile:     rank,
+		})
+	}
+	log.Printf("stream evaluation: %d stations observed, %d with a recent prediction", len(sites), len(evals))
+	if len(evals) == 0 {
+		return nil, nil
+	}
+	if err := SaveAnomalyEvaluations(ctx, evals); err != nil {
+		return evals, err
+	}
+	return evals, PublishAnomalies(ctx, evals)
+}
+
+// latestObservedBySite returns the newest valid value of each site's series
+// in a USGS IV or DV payload.
+func latestObservedBySite(raw []byte) (map[string]float64, error) {
+	var usgs USGSJSON
+	if err := json.Unmarshal(raw, &usgs); err != nil {
+		return nil, err
+	}
+	out := map[string]float64{}
+	for _, ts := range usgs.Value.TimeSeries {
+		if len(ts.SourceInfo.SiteCode) == 0 {
+			continue
+		}
+		var latest time.Time
+		var value float64
+		found := false
+		for _, vv := range ts.Values {
+			for _, p := range vv.Value {
+				t, err := parseUSGSTime(p.DateTime)
+				if err != nil {
+					continue
+				}
+				v, err := strconv.ParseFloat(strings.TrimSpace(p.Value), 64)
+				if err != nil || v == ts.Variable.NoDataValue {
+					continue
+				}
+				if !found || t.After(latest) {
+					found, latest, value = true, t, v
+				}
+			}
+		}
+		if found {
+			out[ts.SourceInfo.SiteCode[0].Value] = value
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,328 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Alert subscriptions live in three places: email subscribers of the SNS
+// alerts topic (alert-subscribers), users alerted by SMS (their notification
+// preferences) and watchlist webhooks. ExportSubscriptions flattens them into
+// one list and ImportSubscriptions applies such a list, so subscriptions can
+// be moved between environments or restored after losing a table.
+//
+// An import never removes anything. Email subscribers get a new SNS
+// confirmation mail, since confirmations don't carry over; unsubscribed or
+// expired ones are skipped. Webhooks keep their exported secret when it is
+// included, so receivers go on verifying deliveries.
+
+// Subscription channels.
+const (
+	ChannelEmail   = "email"
+	ChannelSMS     = "sms"
+	ChannelWebhook = "webhook"
+)
+
+// Outcomes of one imported subscription.
+const (
+	SubscriptionImportAdded    = "added"
+	SubscriptionImportUpdated  = "updated"
+	SubscriptionImportExisting = "existing"
+	SubscriptionImportSkipped  = "skipped"
+	SubscriptionImportInvalid  = "invalid"
+	SubscriptionImportFailed   = "failed"
+)
+
+// MaxSubscriptionImport bounds the subscriptions of one import.
+const MaxSubscriptionImport = 1000
+
+// ErrInvalidSubscriptionImport is returned for imports that fail validation
+// as a whole.
+var ErrInvalidSubscriptionImport = validationError("invalid subscription import")
+
+// SubscriptionRecord is one exported subscription. Endpoint is the email
+// address, E.164 phone number or webhook URL. Watchlist is set for webhooks,
+// MinSeverity for SMS and webhooks, and Status (see SubscriberPending, ...)
+// for email. Secret is only exported on request.
+type SubscriptionRecord struct {
+	Channel     string `json:"channel"`
+	Endpoint    string `json:"endpoint"`
+	Watchlist   string `json:"watchlist,omitempty"`
+	MinSeverity string `json:"min_severity,omitempty"`
+	Status      string `json:"status,omitempty"`
+	Secret      string `json:"secret,omitempty"`
+}
+
+// SubscriptionImportResult is the outcome of one subscription of an import,
+// by its position in the request.
+type SubscriptionImportResult struct {
+	Index    int    `json:"index"`
+	Channel  string `json:"channel"`
+	Endpoint string `json:"endpoint"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SubscriptionImport reports an import.
+type SubscriptionImport struct {
+	Results  []SubscriptionImportResult `json:"results"`
+	Added    int                        `json:"added"`
+	Updated  int                        `json:"updated"`
+	Existing int                        `json:"existing"`
+	Skipped  int                        `json:"skipped"`
+	Rejected int                        `json:"rejected"`
+	Failed   int                        `json:"failed"`
+}
+
+// SubscriptionFilter narrows an export to subscriptions whose fields equal
+// the filter's; empty fields match everything. Only webhooks have a
+// watchlist and only email subscriptions a status, so filtering on either
+// leaves out the other channels.
+type SubscriptionFilter struct {
+	Channel   string
+	Watchlist string
+	Status    string
+}
+
+// Validate checks the filter's values.
+func (f SubscriptionFilter) Validate() error {
+	switch f.Channel {
+	case "", ChannelEmail, ChannelSMS, ChannelWebhook:
+	default:
+		return fmt.Errorf("%w: channel must be email, sms or webhook", ErrValidation)
+	}
+	if f.Watchlist != "" {
+		if err := ValidateWatchlistName(f.Watchlist); err != nil {
+			return err
+		}
+	}
+	switch f.Status {
+	case "", SubscriberPending, SubscriberConfirmed, SubscriberExpired, SubscriberUnsubscribed:
+	default:
+		return fmt.Errorf("%w: status must be pending, confirmed, expired or unsubscribed", ErrValidation)
+	}
+	return nil
+}
+
+// wantsChannel reports whether subscriptions of channel can match f.
+func (f SubscriptionFilter) wantsChannel(channel string) bool {
+	return (f.Channel == "" || f.Channel == channel) &&
+		(f.Watchlist == "" || channel == ChannelWebhook) &&
+		(f.Status == "" || channel == ChannelEmail)
+}
+
+// matches reports whether rec passes f.
+func (f SubscriptionFilter) matches(rec SubscriptionRecord) bool {
+	return f.wantsChannel(rec.Channel) &&
+		(f.Watchlist == "" || rec.Watchlist == f.Watchlist) &&
+		(f.Status == "" || rec.Status == f.Status)
+}
+
+// ExportSubscriptions returns the subscriptions passing filter: email
+// subscribers, users with SMS alerts on and a phone to send them to, each
+// sorted by endpoint, then watchlist webhooks by watchlist. Tables of
+// channels the filter rules out aren't read. Webhook secrets are included
+// when includeSecrets is true.
+func ExportSubscriptions(ctx context.Context, filter SubscriptionFilter, includeSecrets bool) ([]SubscriptionRecord, error) {
+	out := []SubscriptionRecord{}
+	if filter.wantsChannel(ChannelEmail) {
+		subscribers, err := scanTable[AlertSubscriber](ctx, alertSubscribersTable())
+		if err != nil {
+			return nil, err
+		}
+		slices.SortFunc(subscribers, func(a, b AlertSubscriber) int { return strings.Compare(a.Email, b.Email) })
+		for _, s := range subscribers {
+			out = append(out, SubscriptionRecord{Channel: ChannelEmail, Endpoint: s.Email, Status: s.Status})
+		}
+	}
+
+	if filter.wantsChannel(ChannelSMS) {
+		users, err := scanTable[User](ctx, usersTable())
+		if err != nil {
+			return nil, err
+		}
+		slices.SortFunc(users, func(a, b User) int { return strings.Compare(a.Subject, b.Subject) })
+		for _, u := range users {
+			if u.Notifications.SMS && e164Pattern.MatchString(u.Subject) {
+				out = append(out, SubscriptionRecord{Channel: ChannelSMS, Endpoint: u.Subject, MinSeverity: u.Notifications.MinSeverity})
+			}
+		}
+	}
+
+	if filter.wantsChannel(ChannelWebhook) {
+		hooks, err := scanTable[WatchlistWebhook](ctx, watchlistWebhooksTable())
+		if err != nil {
+			return nil, err
+		}
+		slices.SortFunc(hooks, func(a, b WatchlistWebhook) int { return strings.Compare(a.Watchlist, b.Watchlist) })
+		for _, h := range hooks {
+			rec := SubscriptionRecord{Channel: ChannelWebhook, Endpoint: h.URL, Watchlist: h.Watchlist, MinSeverity: h.MinSeverity}
+			if includeSecrets {
+				rec.Secret = h.Secret
+			}
+			out = append(out, rec)
+		}
+	}
+	return filterSubscriptions(out, filter), nil
+}
+
+// filterSubscriptions drops the records that don't pass f, in place.
+func filterSubscriptions(records []SubscriptionRecord, f SubscriptionFilter) []SubscriptionRecord {
+	return slices.DeleteFunc(records, func(rec SubscriptionRecord) bool { return !f.matches(rec) })
+}
+
+// ImportSubscriptions applies records on behalf of actor, reporting each as
+// added, updated, existing, skipped, invalid or failed. Only an empty or
+// oversized list fails the import as a whole (ErrInvalidSubscriptionImport).
+func ImportSubscriptions(ctx context.Context, records []SubscriptionRecord, actor string) (*SubscriptionImport, error) {
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%w: no subscriptions", ErrInvalidSubscriptionImport)
+	}
+	if len(records) > MaxSubscriptionImport {
+		return nil, fmt.Errorf("%w: at most %d subscriptions", ErrInvalidSubscriptionImport, MaxSubscriptionImport)
+	}
+	imp := &SubscriptionImport{Results: make([]SubscriptionImportResult, 0, len(records))}
+	for i, rec := range records {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		res := SubscriptionImportResult{Index: i, Channel: rec.Channel, Endpoint: strings.TrimSpace(rec.Endpoint)}
+		rec.Endpoint = res.Endpoint
+		var err error
+		switch rec.Channel {
+		case ChannelEmail:
+			res.Status, err = importEmailSubscription(ctx, rec)
+		case ChannelSMS:
+			res.Status, err = importSMSSubscription(ctx, rec)
+		case ChannelWebhook:
+			res.Status, err = importWebhookSubscription(ctx, rec, actor)
+		default:
+			err = fmt.Errorf("%w: channel must be email, sms or webhook", ErrValidation)
+		}
+		if err != nil {
+			res.Status, res.Error = SubscriptionImportFailed, err.Error()
+			if errors.Is(err, ErrValidation) {
+				res.Status = SubscriptionImportInvalid
+			}
+		}
+		switch res.Status {
+		case SubscriptionImportAdded:
+			imp.Added++
+		case SubscriptionImportUpdated:
+			imp.Updated++
+		case SubscriptionImportExisting:
+			imp.Existing++
+		case SubscriptionImportSkipped:
+			imp.Skipped++
+		case SubscriptionImportInvalid:
+			imp.Rejected++
+		default:
+			imp.Failed++
+		}
+		imp.Results = append(imp.Results, res)
+	}
+	return imp, nil
+}
+
+// importEmailSubscription subscribes an active (pending or confirmed, or
+// unset) subscriber unless they already are.
+func importEmailSubscription(ctx context.Context, rec SubscriptionRecord) (string, error) {
+	addr, err := mail.ParseAddress(rec.Endpoint)
+	if err != nil || addr.Address != rec.Endpoint {
+		return "", fmt.Errorf("%w: endpoint must be an email address", ErrValidation)
+	}
+	switch rec.Status {
+	case "", SubscriberPending, SubscriberConfirmed:
+	case SubscriberExpired, SubscriberUnsubscribed:
+		return SubscriptionImportSkipped, nil
+	default:
+		return "", fmt.Errorf("%w: unknown status %q", ErrValidation, rec.Status)
+	}
+	sub, err := newRepository[AlertSubscriber](alertSubscribersTable()).Get(ctx, map[string]any{"email": strings.ToLower(rec.Endpoint)})
+	if err != nil {
+		return "", err
+	}
+	if sub != nil && (sub.Status == SubscriberPending || sub.Status == SubscriberConfirmed) {
+		return SubscriptionImportExisting, nil
+	}
+	if _, err := SubscribeAlertsEmail(ctx, rec.Endpoint); err != nil {
+		if errors.Is(err, ErrAlreadySubscribed) {
+			return SubscriptionImportExisting, nil
+		}
+		return "", err
+	}
+	return SubscriptionImportAdded, nil
+}
+
+// importSMSSubscription turns SMS alerts on for the user of a phone number,
+// creating the user on first import.
+func importSMSSubscription(ctx context.Context, rec SubscriptionRecord) (string, error) {
+	if !e164Pattern.MatchString(rec.Endpoint) {
+		return "", fmt.Errorf("%w: endpoint must be an E.164 phone number", ErrValidation)
+	}
+	if rec.MinSeverity != "" && alertSeverityRank[rec.MinSeverity] == 0 {
+		return "", fmt.Errorf("%w: min_severity must be low, medium or high", ErrValidation)
+	}
+	u, err := EnsureUser(ctx, rec.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Notifications.SMS && u.Notifications.MinSeverity == rec.MinSeverity {
+		return SubscriptionImportExisting, nil
+	}
+	prefs := NotificationPreferences{SMS: true, Email: u.Notifications.Email, MinSeverity: rec.MinSeverity}
+	if _, err := UpdateUserProfile(ctx, u.UserID, UserProfileUpdate{Notifications: &prefs}, u.Version); err != nil {
+		return "", err
+	}
+	return SubscriptionImportUpdated, nil
+}
+
+// importWebhookSubscription sets a watchlist's webhook, keeping the given
+// secret (or the current one) when there is one.
+func importWebhookSubscription(ctx context.Context, rec SubscriptionRecord, actor string) (string, error) {
+	if err := ValidateWatchlistName(rec.Watchlist); err != nil {
+		return "", err
+	}
+	existing, err := GetWatchlistWebhook(ctx, rec.Watchlist)
+	if err != nil && !errors.Is(err, ErrWebhookNotFound) {
+		return "", err
+	}
+	if existing != nil && existing.URL == rec.Endpoint && existing.MinSeverity == cmp.Or(rec.MinSeverity, "high") &&
+		(rec.Secret == "" || rec.Secret == existing.Secret) {
+		return SubscriptionImportExisting, nil
+	}
+	spec := WatchlistWebhookSpec{URL: rec.Endpoint, MinSeverity: rec.MinSeverity}
+	if _, _, err := setWatchlistWebhook(ctx, rec.Watchlist, spec, rec.Secret, actor); err != nil {
+		return "", err
+	}
+	if existing != nil {
+		return SubscriptionImportUpdated, nil
+	}
+	return SubscriptionImportAdded, nil
+}
+
+// scanTable returns every item of table.
+func scanTable[T any](ctx context.Context, table string) ([]T, error) {
+	var out []T
+	p := dynamodb.NewScanPaginator(getDynamoClient(), &dynamodb.ScanInput{TableName: &table})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var items []T
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+	}
+	return out, nil
+}
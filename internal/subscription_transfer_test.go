@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestFilterSubscriptions(t *testing.T) {
+	all := []SubscriptionRecord{
+		{Channel: ChannelEmail, Endpoint: "a@example.com", Status: SubscriberConfirmed},
+		{Channel: ChannelEmail, Endpoint: "b@example.com", Status: SubscriberPending},
+		{Channel: ChannelSMS, Endpoint: "+15551234567", MinSeverity: "medium"},
+		{Channel: ChannelWebhook, Endpoint: "https://a.example.com/hook", Watchlist: "default", MinSeverity: "high"},
+		{Channel: ChannelWebhook, Endpoint: "https://b.example.com/hook", Watchlist: "ops"},
+	}
+	tests := []struct {
+		name   string
+		filter SubscriptionFilter
+		want   []string // endpoints
+	}{
+		{"no filter", SubscriptionFilter{}, []string{"a@example.com", "b@example.com", "+15551234567", "https://a.example.com/hook", "https://b.example.com/hook"}},
+		{"email", SubscriptionFilter{Channel: ChannelEmail}, []string{"a@example.com", "b@example.com"}},
+		{"sms", SubscriptionFilter{Channel: ChannelSMS}, []string{"+15551234567"}},
+		{"webhook", SubscriptionFilter{Channel: ChannelWebhook}, []string{"https://a.example.com/hook", "https://b.example.com/hook"}},
+		{"watchlist", SubscriptionFilter{Watchlist: "ops"}, []string{"https://b.example.com/hook"}},
+		{"watchlist without webhooks", SubscriptionFilter{Watchlist: "nobody"}, nil},
+		{"status", SubscriptionFilter{Status: SubscriberPending}, []string{"b@example.com"}},
+		{"channel and status", SubscriptionFilter{Channel: ChannelEmail, Status: SubscriberConfirmed}, []string{"a@example.com"}},
+		{"status rules out sms", SubscriptionFilter{Channel: ChannelSMS, Status: SubscriberConfirmed}, nil},
+		{"watchlist rules out email", SubscriptionFilter{Channel: ChannelEmail, Watchlist: "default"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, rec := range filterSubscriptions(slices.Clone(all), tt.filter) {
+				got = append(got, rec.Endpoint)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("endpoints = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionFilterValidate(t *testing.T) {
+	tests := []struct {
+		filter SubscriptionFilter
+		valid  bool
+	}{
+		{SubscriptionFilter{}, true},
+		{SubscriptionFilter{Channel: ChannelWebhook, Watchlist: "ops-1", Status: ""}, true},
+		{SubscriptionFilter{Status: SubscriberUnsubscribed}, true},
+		{SubscriptionFilter{Channel: "fax"}, false},
+		{SubscriptionFilter{Watchlist: "Not Valid"}, false},
+		{SubscriptionFilter{Status: "active"}, false},
+	}
+	for _, tt := range tests {
+		err := tt.filter.Validate()
+		if (err == nil) != tt.valid || (err != nil && !errors.Is(err, ErrValidation)) {
+			t.Errorf("Validate(%+v) = %v, want valid %v", tt.filter, err, tt.valid)
+		}
+	}
+}
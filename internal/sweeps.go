@@ -0,0 +1,280 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Queued anomaly sweeps can be sharded for deployments watching thousands of
+// sites: with SWEEP_SHARDS set, each site ID is hashed to one of that many
+// shards and one "anomaly_shard" site task is queued per non-empty shard
+// instead of one task per site. A shard checks its sites
+// SWEEP_SHARD_CONCURRENCY at a time and saves their evaluations. The sweep
+// record coordinates: each shard adds its counts once (done_shards), and the
+// shard completing the set marks the sweep completed, so the aggregate can be
+// read from GET /anomaly/sweeps/{id}.
+
+const (
+	// MaxShardedSweepSites bounds the sites of one sharded sweep.
+	MaxShardedSweepSites = 10000
+	// maxSweepShards bounds SWEEP_SHARDS.
+	maxSweepShards = 256
+)
+
+// Sweep statuses.
+const (
+	SweepRunning   = "running"
+	SweepCompleted = "completed"
+)
+
+// defaultSweepTTLDays is how long sweep records are kept.
+const defaultSweepTTLDays = 7
+
+// ErrSweepNotFound is returned when a sweep ID has no record.
+var ErrSweepNotFound = notFoundError("sweep not found")
+
+// Sweep is a sharded anomaly sweep.
+// Table name defaults to "sweeps"; override with SWEEPS_TABLE.
+// Keys: PK sweep_id (the batch ID of its tasks). Items expire after
+// SWEEP_TTL_DAYS (default 7).
+type Sweep struct {
+	SweepID     string `dynamodbav:"sweep_id" json:"sweep_id"`
+	Parameter   string `dynamodbav:"parameter" json:"parameter"`
+	Sites       int    `dynamodbav:"site_count" json:"sites"`
+	ShardsTotal int    `dynamodbav:"shards_total" json:"shards_total"`
+	// DoneShards is a string set of completed shard IDs.
+	DoneShards []string `dynamodbav:"done_shards,stringset,omitempty" json:"-"`
+	ShardsDone int      `dynamodbav:"-" json:"shards_done"`
+	Evaluated  int      `dynamodbav:"evaluated" json:"evaluated"`
+	Anomalies  int      `dynamodbav:"anomalies" json:"anomalies"`
+	// AnomalousSites and FailedSites are string sets: the sites found
+	// anomalous and those whose check failed.
+	AnomalousSites []string `dynamodbav:"anomalous_sites,stringset,omitempty" json:"anomalous_sites"`
+	FailedSites    []string `dynamodbav:"failed_sites,stringset,omitempty" json:"failed_sites"`
+	Status         string   `dynamodbav:"status" json:"status"`
+	CreatedBy      string   `dynamodbav:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedOn      int64    `dynamodbav:"createdon" json:"createdon_ms"`
+	CompletedOn    int64    `dynamodbav:"completed_on,omitempty" json:"completed_on_ms,omitempty"`
+	ExpiresAt      int64    `dynamodbav:"expires_at,omitempty" json:"-"`
+}
+
+// SweepRetention returns the retention policy for the sweeps table.
+func SweepRetention() RetentionConfig {
+	return RetentionConfig{
+		Table: tableName("SWEEPS_TABLE", "sweeps"),
+		TTL:   retentionFromEnv("SWEEP_TTL_DAYS", defaultSweepTTLDays),
+	}
+}
+
+// SweepShards is the number of shards queued sweeps are split into
+// (SWEEP_SHARDS, at most 256). 0, the default, queues one task per site.
+func SweepShards() int {
+	return min(envInt("SWEEP_SHARDS", 0), maxSweepShards)
+}
+
+// sweepShardConcurrency is how many sites of a shard are checked at once
+// (SWEEP_SHARD_CONCURRENCY, default 8).
+func sweepShardConcurrency() int {
+	return max(envInt("SWEEP_SHARD_CONCURRENCY", 8), 1)
+}
+
+// ShardSites assigns each site to one of shards shards by the FNV-1a hash
+// of its ID, so a site lands in the same shard in every sweep. Shards may
+// be empty.
+func ShardSites(sites []string, shards int) [][]string {
+	out := make([][]string, max(shards, 1))
+	for _, site := range sites {
+		h := fnv.New32a()
+		h.Write([]byte(site))
+		i := h.Sum32() % uint32(len(out))
+		out[i] = append(out[i], site)
+	}
+	return out
+}
+
+func shardID(i int) string {
+	return fmt.Sprintf("%03d", i)
+}
+
+func (s *Sweep) withProgress() *Sweep {
+	s.ShardsDone = len(s.DoneShards)
+	s.AnomalousSites = sortedSet(s.AnomalousSites)
+	s.FailedSites = sortedSet(s.FailedSites)
+	return s
+}
+
+// sortedSet returns a sorted copy of a string set, empty rather than nil.
+func sortedSet(set []string) []string {
+	out := append([]string{}, set...)
+	slices.Sort(out)
+	return out
+}
+
+// CreateSweep stores a sweep of sites over SweepShards shards and returns
+// it with one anomaly_shard task per non-empty shard, for the caller to
+// queue.
+func CreateSweep(ctx context.Context, batchID string, sites []string, parameter, createdBy string) (*Sweep, []SiteTask, error) {
+	now := time.Now().UTC()
+	s := &Sweep{
+		SweepID:   batchID,
+		Parameter: parameter,
+		Sites:     len(sites),
+		Status:    SweepRunning,
+		CreatedBy: createdBy,
+		CreatedOn: now.UnixMilli(),
+		ExpiresAt: SweepRetention().ExpiresAt(now),
+	}
+	var tasks []SiteTask
+	for i, shard := range ShardSites(sites, SweepShards()) {
+		if len(shard) > 0 {
+			tasks = append(tasks, SiteTask{Kind: SiteTaskAnomalyShard, BatchID: batchID, Sites: shard, Parameter: parameter, Shard: shardID(i)})
+		}
+	}
+	s.ShardsTotal = len(tasks)
+	if err := newRepository[Sweep](SweepRetention().Table).Create(ctx, s, "sweep_id"); err != nil {
+		return nil, nil, err
+	}
+	return s.withProgress(), tasks, nil
+}
+
+// GetSweep loads a sweep, returning ErrSweepNotFound when missing.
+func GetSweep(ctx context.Context, id string) (*Sweep, error) {
+	s, err := newRepository[Sweep](SweepRetention().Table).Get(ctx, map[string]any{"sweep_id": id})
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, ErrSweepNotFound
+	}
+	return s.withProgress(), nil
+}
+
+// RunSweepShard checks the sites of one shard, saves their evaluations and
+// adds the shard's counts to the sweep, completing the sweep when it was the
+// last shard. It returns the saved evaluations for alerting; a shard already
+// counted returns none. Sites whose check fails are recorded, not retried.
+func RunSweepShard(ctx context.Context, t SiteTask) ([]AnomalyEvaluation, error) {
+	sweep, err := GetSweep(ctx, t.BatchID)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(sweep.DoneShards, t.Shard) {
+		return nil, nil
+	}
+
+	var (
+		mu     sync.Mutex
+		evals  []AnomalyEvaluation
+		failed []string
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, sweepShardConcurrency())
+	)
+	for _, site := range t.Sites {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res, err := ProcessInferAndDetect(ctx, site, t.Parameter)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("sweep %s shard %s: anomaly check for %s failed: %v", t.BatchID, t.Shard, site, err)
+				failed = append(failed, site)
+				return
+			}
+			evals = append(evals, AnomalyEvaluation{
+				Site:           site,
+				Parameter:      t.Parameter,
+				S3Key:          res.S3Key,
+				ObservedValue:  res.ObservedValue,
+				PredictedValue: res.PredictedValue,
+				PercentChange:  res.PercentChange,
+				Anomalous:      res.Anomalous,
+				Severity:       res.Severity,
+				Unit:           res.Unit,
+				Reason:         res.Reason,
+				Model:          res.Model,
+				Percentile:     res.Percentile,
+				LeadTime:       res.LeadTime,
+			})
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		// Timed out part way; the redelivered task runs the shard again.
+		return nil, err
+	}
+	if err := SaveAnomalyEvaluations(ctx, evals); err != nil {
+		return nil, fmt.Errorf("save evaluations: %w", err)
+	}
+
+	var anomalous []string
+	for _, ev := range evals {
+		if ev.Anomalous {
+			anomalous = append(anomalous, ev.Site)
+		}
+	}
+	expr := "ADD done_shards :id, evaluated :evaluated, anomalies :anomalies"
+	vals := map[string]any{
+		":id":        &types.AttributeValueMemberSS{Value: []string{t.Shard}},
+		":shard":     t.Shard,
+		":evaluated": len(evals),
+		":anomalies": len(anomalous),
+	}
+	if len(anomalous) > 0 {
+		expr += ", anomalous_sites :anomalous"
+		vals[":anomalous"] = &types.AttributeValueMemberSS{Value: anomalous}
+	}
+	if len(failed) > 0 {
+		expr += ", failed_sites :failed"
+		vals[":failed"] = &types.AttributeValueMemberSS{Value: failed}
+	}
+	// Only the first completion of a shard counts.
+	err = updateSweep(ctx, t.BatchID, expr, vals, "NOT contains(done_shards, :shard)")
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := GetSweep(ctx, t.BatchID)
+	if err != nil {
+		return evals, err
+	}
+	if updated.ShardsDone >= updated.ShardsTotal && updated.Status == SweepRunning {
+		err := updateSweep(ctx, t.BatchID,
+			"SET #status = :completed, completed_on = :now",
+			map[string]any{":completed": SweepCompleted, ":running": SweepRunning, ":now": time.Now().UTC().UnixMilli()},
+			"#status = :running")
+		switch {
+		case errors.As(err, &ccf):
+		case err != nil:
+			return evals, fmt.Errorf("complete sweep %s: %w", t.BatchID, err)
+		default:
+			log.Printf("sweep %s completed: %d of %d sites evaluated, %d anomalous, %d failed",
+				t.BatchID, updated.Evaluated, updated.Sites, updated.Anomalies, len(updated.FailedSites))
+		}
+	}
+	return evals, nil
+}
+
+// updateSweep applies an update expression to an existing sweep, with cond
+// as an extra condition (see repository.Update).
+func updateSweep(ctx context.Context, id, expr string, vals map[string]any, cond string) error {
+	condition := "attribute_exists(sweep_id)"
+	if cond != "" {
+		condition += " AND " + cond
+	}
+	return newRepository[Sweep](SweepRetention().Table).Update(ctx, map[string]any{"sweep_id": id}, expr, vals, condition)
+}
@@ -0,0 +1,250 @@
+// Package synthetic generates realistic-looking discharge series for fake
+// stations, so demos and load tests can run the whole pipeline without
+// USGS. A series is a seasonal baseflow, storm hydrographs (a quick rise to
+// a peak, then an exponential recession) and multiplicative noise.
+//
+// Everything is derived from hashes of the station, parameter and time, so
+// a station's value at a given instant is the same in every call, process
+// and overlapping window: a 30-day fetch and a backfill of the same days
+// agree, and replaying a run reproduces its data.
+package synthetic
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+// Qualifier tags every synthetic value, and SiteName names every synthetic
+// station, so synthetic data can't pass for observations.
+const (
+	Qualifier = "SYN"
+	SiteName  = "synthetic"
+)
+
+// Profile shapes a station's series. Values are in the parameter's unit.
+type Profile struct {
+	// Baseflow is the mean flow between storms.
+	Baseflow float64
+	// Seasonal is the fraction of Baseflow swung over a year, highest in
+	// spring.
+	Seasonal float64
+	// StormChance is the probability that a storm starts on a given day.
+	StormChance float64
+	// StormPeak is the median storm peak above baseflow, as a multiple of
+	// Baseflow.
+	StormPeak float64
+	// TimeToPeak is how long a storm takes to reach its peak.
+	TimeToPeak time.Duration
+	// Recession is the e-folding time of the falling limb.
+	Recession time.Duration
+	// Noise is the standard deviation of the multiplicative noise.
+	Noise float64
+}
+
+// Generator produces one station's series.
+type Generator struct {
+	Station   string
+	Parameter string
+	Profile   Profile
+	seed      uint64
+}
+
+// New returns the generator of station and parameter with its
+// station-seeded profile. Any station ID works, real or made up.
+func New(station, parameter string) *Generator {
+	seed := hashString(station + ":" + parameter)
+	g := &Generator{Station: station, Parameter: parameter, seed: seed}
+	r := func(i uint64) float64 { return unit(mix(seed + i)) }
+	switch parameter {
+	case "00065": // gage height, ft: storms raise it a few feet at most
+		g.Profile = Profile{
+			Baseflow:    1 + 14*r(1),
+			Seasonal:    0.05 + 0.1*r(2),
+			StormChance: 0.05 + 0.1*r(3),
+			StormPeak:   0.2 + 0.6*r(4),
+			TimeToPeak:  time.Duration(4+20*r(5)) * time.Hour,
+			Recession:   time.Duration(24+72*r(6)) * time.Hour,
+			Noise:       0.005,
+		}
+	default: // discharge, cfs: baseflow spans small creeks to rivers
+		g.Profile = Profile{
+			Baseflow:    20 * math.Pow(100, r(1)),
+			Seasonal:    0.2 + 0.3*r(2),
+			StormChance: 0.05 + 0.1*r(3),
+			StormPeak:   1 + 7*r(4),
+			TimeToPeak:  time.Duration(3+30*r(5)) * time.Hour,
+			Recession:   time.Duration(12+60*r(6)) * time.Hour,
+			Noise:       0.02 + 0.03*r(7),
+		}
+	}
+	return g
+}
+
+// At returns the value at t.
+func (g *Generator) At(t time.Time) float64 {
+	p := g.Profile
+	day := float64(t.YearDay())
+	v := p.Baseflow * (1 + p.Seasonal*math.Cos(2*math.Pi*(day-80)/365))
+
+	// A storm's influence is negligible after eight recession times.
+	reach := p.TimeToPeak + 8*p.Recession
+	today := t.UTC().Truncate(24 * time.Hour)
+	for d := today.Add(-reach).Truncate(24 * time.Hour); !d.After(today); d = d.Add(24 * time.Hour) {
+		start, peak, ok := g.storm(d)
+		if !ok || start.After(t) {
+			continue
+		}
+		v += peak * hydrograph(t.Sub(start), p.TimeToPeak, p.Recession)
+	}
+	return v * math.Exp(p.Noise*normal(g.seed^uint64(t.Unix())))
+}
+
+// storm reports whether a storm starts on day and, if so, when and how high
+// it peaks above baseflow.
+func (g *Generator) storm(day time.Time) (time.Time, float64, bool) {
+	h := mix(g.seed ^ uint64(day.Unix()/86400)*0x9e3779b97f4a7c15)
+	if unit(h) >= g.Profile.StormChance {
+		return time.Time{}, 0, false
+	}
+	start := day.Add(time.Duration(unit(mix(h+1)) * float64(24*time.Hour)))
+	// Peaks are lognormal around the median: most storms are small, a few
+	// are floods.
+	peak := g.Profile.Baseflow * g.Profile.StormPeak * math.Exp(0.6*normal(h+2))
+	return start, peak, true
+}
+
+// hydrograph is a storm's unit response at elapsed: a smooth rise to 1 at
+// toPeak, then an exponential recession.
+func hydrograph(elapsed, toPeak, recession time.Duration) float64 {
+	if elapsed < 0 {
+		return 0
+	}
+	if elapsed < toPeak {
+		s := math.Sin(math.Pi / 2 * float64(elapsed) / float64(toPeak))
+		return s * s
+	}
+	return math.Exp(-float64(elapsed-toPeak) / float64(recession))
+}
+
+// Point is one value of a series.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series returns the values from start through end, every step.
+func (g *Generator) Series(start, end time.Time, step time.Duration) []Point {
+	var points []Point
+	for t := start; !t.After(end); t = t.Add(step) {
+		points = append(points, Point{Time: t, Value: g.At(t)})
+	}
+	return points
+}
+
+// DailyMean returns the mean of the hourly values of the UTC day holding
+// day, as USGS daily values (statCd 00003) are.
+func (g *Generator) DailyMean(day time.Time) float64 {
+	start := day.UTC().Truncate(24 * time.Hour)
+	var sum float64
+	for h := range 24 {
+		sum += g.At(start.Add(time.Duration(h) * time.Hour))
+	}
+	return sum / 24
+}
+
+// Stations returns n fake station IDs, "99000001" onwards. They are valid
+// site IDs that no USGS station uses.
+func Stations(n int) []string {
+	ids := make([]string, 0, n)
+	for i := range n {
+		ids = append(ids, fmt.Sprintf("99%06d", i+1))
+	}
+	return ids
+}
+
+// DailyPayload returns a USGS DV-shaped JSON payload of station's daily
+// means for the UTC days start through end, inclusive.
+func DailyPayload(station, parameter string, start, end time.Time) ([]byte, error) {
+	g := New(station, parameter)
+	var values []map[string]any
+	last := end.UTC().Truncate(24 * time.Hour)
+	for day := start.UTC().Truncate(24 * time.Hour); !day.After(last); day = day.AddDate(0, 0, 1) {
+		values = append(values, value(g.DailyMean(day), day.Format("2006-01-02T15:04:05.000")))
+	}
+	return payload(station, parameter, "00003", values)
+}
+
+// InstantPayload returns a USGS IV-shaped JSON payload holding station's
+// latest 15-minute value at or before now, as the IV service does when no
+// period is requested.
+func InstantPayload(station, parameter string, now time.Time) ([]byte, error) {
+	g := New(station, parameter)
+	t := now.UTC().Truncate(15 * time.Minute)
+	return payload(station, parameter, "", []map[string]any{
+		value(g.At(t), t.Format("2006-01-02T15:04:05.000-07:00")),
+	})
+}
+
+func value(v float64, dateTime string) map[string]any {
+	return map[string]any{
+		"value":      fmt.Sprintf("%.2f", math.Max(v, 0)),
+		"qualifiers": []string{Qualifier},
+		"dateTime":   dateTime,
+	}
+}
+
+func payload(station, parameter, statistic string, values []map[string]any) ([]byte, error) {
+	name := fmt.Sprintf("USGS:%s:%s", station, parameter)
+	if statistic != "" {
+		name += ":" + statistic
+	}
+	if values == nil {
+		values = []map[string]any{}
+	}
+	doc := map[string]any{
+		"value": map[string]any{
+			"timeSeries": []any{map[string]any{
+				"sourceInfo": map[string]any{
+					"siteName": SiteName,
+					"siteCode": []any{map[string]string{"value": station, "network": "NWIS", "agencyCode": "USGS"}},
+				},
+				"variable": map[string]any{
+					"variableCode": []any{map[string]any{"value": parameter}},
+				},
+				"values": []any{map[string]any{"value": values}},
+				"name":   name,
+			}},
+		},
+	}
+	return json.Marshal(doc)
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// mix is splitmix64's finalizer: it spreads nearby inputs (consecutive days
+// or seconds) over the whole range.
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// unit maps h to [0, 1).
+func unit(h uint64) float64 {
+	return float64(h>>11) / (1 << 53)
+}
+
+// normal maps h to a standard normal value (Box-Muller).
+func normal(h uint64) float64 {
+	u1 := 1 - unit(mix(h))
+	u2 := unit(mix(h + 1))
+	return math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*u2)
+}
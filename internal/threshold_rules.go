@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Flow and stage anomalies are judged by how far the observation strays from
+// the model's prediction. Ecological parameters have limits of their own:
+// water too warm or too low in dissolved oxygen stresses fish whatever was
+// predicted. Parameters with a threshold rule are judged by the observed
+// value against the rule's limit instead; the percent change is still
+// recorded. Rules come from ANOMALY_THRESHOLD_RULES, a comma-separated list
+// of <parameter><op><limit> with op ">" (anomalous above the limit) or "<"
+// (below it), in the parameter's unit. Unset, it is
+// defaultThresholdRules; set empty, no parameter has a rule.
+
+// defaultThresholdRules flags water temperature above 25 °C and dissolved
+// oxygen below 5 mg/L.
+const defaultThresholdRules = "00010>25,00300<5"
+
+// Threshold anomaly severities by how far the observation is past the limit,
+// as a percentage of the limit: low below mediumThresholdPercent, medium
+// below highThresholdPercent and high beyond.
+const (
+	mediumThresholdPercent = 10
+	highThresholdPercent   = 25
+)
+
+// parameterInfo names a USGS parameter and its unit.
+type parameterInfo struct {
+	Name string
+	Unit string
+}
+
+// ecologicalParameters are the water-quality parameters reasons are worded
+// for; rules on other parameters name the code.
+var ecologicalParameters = map[string]parameterInfo{
+	"00010": {"Water temperature", "°C"},
+	"00095": {"Specific conductance", "µS/cm"},
+	"00300": {"Dissolved oxygen", "mg/L"},
+	"00301": {"Dissolved oxygen saturation", "%"},
+	"00400": {"pH", ""},
+	"63680": {"Turbidity", "FNU"},
+}
+
+// ThresholdRule flags observations of Parameter above or below Limit.
+type ThresholdRule struct {
+	Parameter string
+	// Below is set for rules flagging values under Limit.
+	Below bool
+	Limit float64
+}
+
+// thresholdRules parses ANOMALY_THRESHOLD_RULES by parameter. Malformed
+// entries are logged and skipped.
+func thresholdRules() map[string]ThresholdRule {
+	spec, ok := os.LookupEnv("ANOMALY_THRESHOLD_RULES")
+	if !ok {
+		spec = defaultThresholdRules
+	}
+	rules := map[string]ThresholdRule{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.IndexAny(entry, "<>")
+		if i < 0 {
+			log.Printf("ANOMALY_THRESHOLD_RULES: %q has no < or >", entry)
+			continue
+		}
+		parameter := strings.TrimSpace(entry[:i])
+		limit, err := strconv.ParseFloat(strings.TrimSpace(entry[i+1:]), 64)
+		if !parameterCodePattern.MatchString(parameter) || err != nil || math.IsNaN(limit) || math.IsInf(limit, 0) {
+			log.Printf("ANOMALY_THRESHOLD_RULES: malformed rule %q", entry)
+			continue
+		}
+		rules[parameter] = ThresholdRule{Parameter: parameter, Below: entry[i] == '<', Limit: limit}
+	}
+	return rules
+}
+
+// thresholdRule returns the rule of parameter, if any.
+func thresholdRule(parameter string) (ThresholdRule, bool) {
+	rule, ok := thresholdRules()[parameter]
+	return rule, ok
+}
+
+// Unit returns the unit of the rule's parameter, empty when unknown.
+func (r ThresholdRule) Unit() string {
+	return ecologicalParameters[r.Parameter].Unit
+}
+
+// Breached reports whether observed is past the limit.
+func (r ThresholdRule) Breached(observed float64) bool {
+	if r.Below {
+		return observed < r.Limit
+	}
+	return observed > r.Limit
+}
+
+// Severity rates a breach by its distance past the limit, as a percentage of
+// the limit.
+func (r ThresholdRule) Severity(observed float64) string {
+	percent := math.Abs(observed-r.Limit) / math.Max(1e-9, math.Abs(r.Limit)) * 100
+	switch {
+	case percent >= highThresholdPercent:
+		return "high"
+	case percent >= mediumThresholdPercent:
+		return "medium"
+	}
+	return "low"
+}
+
+// Reason describes a breach, e.g. "Water temperature 27.40 °C above 25 °C".
+func (r ThresholdRule) Reason(observed float64) string {
+	name := "Parameter " + r.Parameter
+	if info, ok := ecologicalParameters[r.Parameter]; ok {
+		name = info.Name
+	}
+	dir := "above"
+	if r.Below {
+		dir = "below"
+	}
+	return fmt.Sprintf("%s %s %s %s", name, withUnit(strconv.FormatFloat(observed, 'f', 2, 64), r.Unit()), dir,
+		withUnit(strconv.FormatFloat(r.Limit, 'f', -1, 64), r.Unit()))
+}
+
+func withUnit(value, unit string) string {
+	switch unit {
+	case "":
+		return value
+	case "%":
+		return value + unit
+	}
+	return value + " " + unit
+}
+
+// anomalyVerdict is the judgement of one observation.
+type anomalyVerdict struct {
+	percent   float64
+	anomalous bool
+	// severity and reason are set for parameters with a threshold rule;
+	// percent-change anomalies are rated by AnomalySeverity. unit is the
+	// parameter's, when known.
+	severity string
+	unit     string
+	reason   string
+}
+
+// judgeObservation judges observed against parameter's threshold rule, or
+// against predicted with detectAnomaly and the parameter's profile when it
+// has none.
+func judgeObservation(parameter string, observed, predicted float64) anomalyVerdict {
+	profile := ProfileFor(parameter)
+	percent, anomalous := detectAnomaly(profile, observed, predicted)
+	rule, ok := thresholdRule(parameter)
+	if !ok {
+		return anomalyVerdict{percent: percent, anomalous: anomalous, unit: profile.Unit}
+	}
+	v := anomalyVerdict{percent: percent, anomalous: rule.Breached(observed), unit: rule.Unit()}
+	if v.anomalous {
+		v.severity = rule.Severity(observed)
+		v.reason = rule.Reason(observed)
+	}
+	return v
+}
@@ -0,0 +1,39 @@
+package tracing
+
+import (
+	"context"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// AWSMiddleware records a subsegment per AWS SDK operation (retries
+// included), named after the service, e.g. "DynamoDB" with operation
+// "PutItem". Add it to aws.Config.APIOptions.
+func AWSMiddleware(stack *middleware.Stack) error {
+	// After the operation's own initialize steps, which register the
+	// service and operation names read here.
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("XRaySubsegment", func(
+		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+	) (middleware.InitializeOutput, middleware.Metadata, error) {
+		ctx, s := Start(ctx, awsmiddleware.GetServiceID(ctx))
+		if s == nil {
+			return next.HandleInitialize(ctx, in)
+		}
+		out, md, err := next.HandleInitialize(ctx, in)
+		fields := map[string]any{
+			"operation": awsmiddleware.GetOperationName(ctx),
+			"region":    awsmiddleware.GetRegion(ctx),
+		}
+		if id, ok := awsmiddleware.GetRequestIDMetadata(md); ok {
+			fields["request_id"] = id
+		}
+		s.setAWS(fields)
+		if resp, ok := awsmiddleware.GetRawResponse(md).(*smithyhttp.Response); ok {
+			s.SetStatus(resp.StatusCode)
+		}
+		s.End(err)
+		return out, md, err
+	}), middleware.After)
+}
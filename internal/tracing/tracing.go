@@ -0,0 +1,310 @@
+// Package tracing records X-Ray subsegments for the pipeline lambdas: one
+// per handler with its execution, sites and dataset as annotations, one per
+// pipeline stage, and one per outbound call (USGS, NWS and every AWS SDK
+// operation), so slow upstreams show up on the X-Ray service map.
+//
+// Subsegments are sent to the X-Ray daemon (AWS_XRAY_DAEMON_ADDRESS) as
+// UDP segment documents under the invocation's trace, which Lambda starts
+// when the function has active tracing. Without a daemon or a sampled trace
+// every call here is a no-op, so the API server and local runs are
+// unaffected.
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Segment is an open subsegment. A nil Segment (tracing disabled) ignores
+// every call.
+type Segment struct {
+	traceID  string
+	id       string
+	parentID string
+	name     string
+	start    time.Time
+
+	mu          sync.Mutex
+	namespace   string
+	annotations map[string]any
+	metadata    map[string]any
+	http        map[string]any
+	aws         map[string]any
+	status      int
+}
+
+type segmentKey struct{}
+
+// Start opens a subsegment named name under the segment in ctx, or under
+// the Lambda invocation's trace. The returned context carries it, so calls
+// made with it nest below; End must be called to record it.
+func Start(ctx context.Context, name string) (context.Context, *Segment) {
+	traceID, parentID, ok := parent(ctx)
+	if !ok {
+		return ctx, nil
+	}
+	s := &Segment{
+		traceID:  traceID,
+		id:       newID(),
+		parentID: parentID,
+		name:     name,
+		start:    time.Now(),
+	}
+	return context.WithValue(ctx, segmentKey{}, s), s
+}
+
+// StartRemote opens a subsegment for a call to another service, named after
+// it (e.g. the host), which X-Ray draws as its own node.
+func StartRemote(ctx context.Context, name string) (context.Context, *Segment) {
+	ctx, s := Start(ctx, name)
+	if s != nil {
+		s.namespace = "remote"
+	}
+	return ctx, s
+}
+
+// parent returns the trace and parent ID for a new subsegment: the open
+// segment in ctx, else the sampled Lambda trace header.
+func parent(ctx context.Context) (traceID, parentID string, ok bool) {
+	if daemonAddr() == "" {
+		return "", "", false
+	}
+	if s, _ := ctx.Value(segmentKey{}).(*Segment); s != nil {
+		return s.traceID, s.id, true
+	}
+	h := parseHeader(traceHeader(ctx))
+	if h["Root"] == "" || h["Parent"] == "" || h["Sampled"] != "1" {
+		return "", "", false
+	}
+	return h["Root"], h["Parent"], true
+}
+
+// TraceID returns the X-Ray trace ID of ctx's invocation, or "" outside a
+// traced Lambda invocation. Log it to jump from logs to the trace.
+func TraceID(ctx context.Context) string {
+	if s, _ := ctx.Value(segmentKey{}).(*Segment); s != nil {
+		return s.traceID
+	}
+	return parseHeader(traceHeader(ctx))["Root"]
+}
+
+// traceHeader returns the invocation's X-Amzn-Trace-Id, which aws-lambda-go
+// stores in the context and in _X_AMZN_TRACE_ID.
+func traceHeader(ctx context.Context) string {
+	if h, _ := ctx.Value("x-amzn-trace-id").(string); h != "" {
+		return h
+	}
+	return os.Getenv("_X_AMZN_TRACE_ID")
+}
+
+// parseHeader splits "Root=1-...;Parent=...;Sampled=1" into its fields.
+func parseHeader(h string) map[string]string {
+	out := map[string]string{}
+	for _, part := range strings.Split(h, ";") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(part), "="); ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// maxAnnotationLen bounds string annotations (X-Ray allows 1000 characters).
+const maxAnnotationLen = 250
+
+// Annotate adds an indexed annotation, searchable in trace filters such as
+// annotation.execution = "ingest-20260101-0a1b2c3d". value should be a
+// string, number or bool; long strings are truncated.
+func (s *Segment) Annotate(key string, value any) {
+	if s == nil {
+		return
+	}
+	if v, ok := value.(string); ok && len(v) > maxAnnotationLen {
+		value = v[:maxAnnotationLen]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.annotations == nil {
+		s.annotations = map[string]any{}
+	}
+	s.annotations[key] = value
+}
+
+// AnnotateRun adds the pipeline context of a lambda invocation: the
+// execution (ARN or name), its sites and the dataset key. Empty values are
+// left out; the full site list is kept as metadata.
+func (s *Segment) AnnotateRun(execution string, sites []string, dataset string) {
+	if s == nil {
+		return
+	}
+	if execution != "" {
+		s.Annotate("execution", execution)
+	}
+	if len(sites) > 0 {
+		s.Annotate("sites", strings.Join(sites, ","))
+		s.Annotate("site_count", len(sites))
+		s.AddMetadata("sites", sites)
+	}
+	if dataset != "" {
+		s.Annotate("dataset", dataset)
+	}
+}
+
+// AddMetadata attaches unindexed data, such as a full site list.
+func (s *Segment) AddMetadata(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.metadata == nil {
+		s.metadata = map[string]any{}
+	}
+	s.metadata[key] = value
+}
+
+// SetHTTP records an outbound HTTP request's method and URL. Pass the URL
+// without its query, which may carry credentials.
+func (s *Segment) SetHTTP(method, url string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.http = map[string]any{"request": map[string]any{"method": method, "url": url}}
+}
+
+// SetStatus records the response status of the call: 4xx mark the
+// subsegment as an error (429 also as throttled), 5xx as a fault.
+func (s *Segment) SetStatus(code int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = code
+	if s.http == nil {
+		s.http = map[string]any{}
+	}
+	s.http["response"] = map[string]any{"status": code}
+}
+
+// setAWS records an AWS SDK operation.
+func (s *Segment) setAWS(fields map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.namespace = "aws"
+	s.aws = fields
+}
+
+// End closes the subsegment and sends it. A non-nil err marks it as a fault
+// with err as the cause, unless the status already classified it.
+func (s *Segment) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	doc := map[string]any{
+		"type":       "subsegment",
+		"name":       segmentName(s.name),
+		"id":         s.id,
+		"trace_id":   s.traceID,
+		"parent_id":  s.parentID,
+		"start_time": epochSeconds(s.start),
+		"end_time":   epochSeconds(time.Now()),
+	}
+	if s.namespace != "" {
+		doc["namespace"] = s.namespace
+	}
+	if len(s.annotations) > 0 {
+		doc["annotations"] = s.annotations
+	}
+	if len(s.metadata) > 0 {
+		doc["metadata"] = map[string]any{"default": s.metadata}
+	}
+	if s.http != nil {
+		doc["http"] = s.http
+	}
+	if s.aws != nil {
+		doc["aws"] = s.aws
+	}
+	switch {
+	case s.status == 429:
+		doc["error"], doc["throttle"] = true, true
+	case s.status >= 500:
+		doc["fault"] = true
+	case s.status >= 400:
+		doc["error"] = true
+	case err != nil:
+		doc["fault"] = true
+	}
+	if err != nil {
+		doc["cause"] = map[string]any{"exceptions": []map[string]any{{"id": newID(), "message": err.Error()}}}
+	}
+	s.mu.Unlock()
+	send(doc)
+}
+
+// segmentName keeps the characters X-Ray accepts in names.
+func segmentName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`"<>\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > 200 {
+		name = name[:200]
+	}
+	return name
+}
+
+func epochSeconds(t time.Time) float64 {
+	return float64(t.UnixMicro()) / 1e6
+}
+
+func newID() string {
+	return fmt.Sprintf("%016x", rand.Uint64())
+}
+
+// daemonAddr returns the daemon's UDP address. AWS_XRAY_DAEMON_ADDRESS is
+// "host:port", or "tcp:host:port udp:host:port".
+func daemonAddr() string {
+	v := strings.TrimSpace(os.Getenv("AWS_XRAY_DAEMON_ADDRESS"))
+	for _, f := range strings.Fields(v) {
+		if addr, ok := strings.CutPrefix(f, "udp:"); ok {
+			return addr
+		}
+	}
+	if strings.Contains(v, " ") {
+		return ""
+	}
+	return v
+}
+
+var (
+	connOnce sync.Once
+	conn     net.Conn
+)
+
+// send writes doc to the daemon. Tracing is best-effort: failures are
+// dropped.
+func send(doc map[string]any) {
+	connOnce.Do(func() {
+		conn, _ = net.Dial("udp", daemonAddr())
+	})
+	if conn == nil {
+		return
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	_, _ = conn.Write(append([]byte("{\"format\":\"json\",\"version\":1}\n"), body...))
+}
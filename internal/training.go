@@ -0,0 +1,295 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"aquawatch/internal/httpclient"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// Training jobs are created by the train lambda rather than the state
+// machine's createTrainingJob.sync task, so hyperparameters, spot capacity
+// and job naming live in Go. The SDK's sagemaker module isn't a dependency;
+// the two control-plane actions needed here are signed and sent directly to
+// the SageMaker JSON API (SAGEMAKER_API_ENDPOINT overrides the endpoint).
+
+// Training job statuses, as reported by SageMaker.
+const (
+	TrainingJobInProgress = "InProgress"
+	TrainingJobCompleted  = "Completed"
+	TrainingJobFailed     = "Failed"
+	TrainingJobStopping   = "Stopping"
+	TrainingJobStopped    = "Stopped"
+)
+
+// defaultHyperParameters are the XGBoost settings used unless
+// TRAINING_HYPERPARAMETERS (a JSON object of strings) overrides them.
+var defaultHyperParameters = map[string]string{
+	"objective":   "reg:linear",
+	"num_round":   "50",
+	"max_depth":   "5",
+	"eta":         "0.2",
+	"subsample":   "0.8",
+	"eval_metric": "rmse",
+}
+
+// ErrTrainingJobExists matches a SageMaker ResourceInUse error from
+// CreateTrainingJob: a job with that name was already created.
+var ErrTrainingJobExists = errors.New("training job already exists")
+
+// SageMakerAPIError is an error response from the SageMaker API.
+type SageMakerAPIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *SageMakerAPIError) Error() string {
+	return fmt.Sprintf("sagemaker %s (%d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// Is reports whether target is ErrTrainingJobExists for ResourceInUse errors.
+func (e *SageMakerAPIError) Is(target error) bool {
+	return target == ErrTrainingJobExists && e.Code == "ResourceInUse"
+}
+
+// TrainingConfig is the training setup read from the environment:
+//
+//	TRAINING_ROLE_ARN              execution role passed to SageMaker (required)
+//	TRAINING_IMAGE                 algorithm image (default: XGBoost 1.7-1 in AWS_REGION)
+//	TRAINING_INSTANCE_TYPE         default ml.c4.xlarge
+//	TRAINING_VOLUME_GB             default 10
+//	TRAINING_MAX_RUNTIME_SECONDS   default 3600
+//	TRAINING_SPOT                  managed spot training (default true)
+//	TRAINING_MAX_WAIT_SECONDS      spot wait bound, >= runtime (default 2x runtime)
+//	TRAINING_HYPERPARAMETERS       JSON object merged over the defaults
+//	TRAINING_HYPERPARAMETERS_<code> JSON object merged over those for one parameter
+type TrainingConfig struct {
+	RoleArn           string
+	Image             string
+	InstanceType      string
+	VolumeGB          int
+	MaxRuntimeSeconds int
+	Spot              bool
+	MaxWaitSeconds    int
+	HyperParameters   map[string]string
+}
+
+// TrainingConfigFromEnv reads TrainingConfig from the environment for
+// models of parameter.
+func TrainingConfigFromEnv(parameter string) (TrainingConfig, error) {
+	c := TrainingConfig{
+		RoleArn:           os.Getenv("TRAINING_ROLE_ARN"),
+		Image:             os.Getenv("TRAINING_IMAGE"),
+		InstanceType:      os.Getenv("TRAINING_INSTANCE_TYPE"),
+		VolumeGB:          max(envInt("TRAINING_VOLUME_GB", 10), 1),
+		MaxRuntimeSeconds: max(envInt("TRAINING_MAX_RUNTIME_SECONDS", 3600), 60),
+		Spot:              true,
+		HyperParameters:   map[string]string{},
+	}
+	if c.RoleArn == "" {
+		return c, errors.New("TRAINING_ROLE_ARN not configured")
+	}
+	if c.Image == "" {
+		c.Image = fmt.Sprintf("246618743249.dkr.ecr.%s.amazonaws.com/sagemaker-xgboost:1.7-1", getAWSConfig().Region)
+	}
+	if c.InstanceType == "" {
+		c.InstanceType = "ml.c4.xlarge"
+	}
+	if v := os.Getenv("TRAINING_SPOT"); v != "" {
+		spot, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid TRAINING_SPOT: %w", err)
+		}
+		c.Spot = spot
+	}
+	c.MaxWaitSeconds = max(envInt("TRAINING_MAX_WAIT_SECONDS", 2*c.MaxRuntimeSeconds), c.MaxRuntimeSeconds)
+	for k, v := range defaultHyperParameters {
+		c.HyperParameters[k] = v
+	}
+	if raw := os.Getenv("TRAINING_HYPERPARAMETERS"); raw != "" {
+		var overrides map[string]string
+		if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+			return c, fmt.Errorf("invalid TRAINING_HYPERPARAMETERS: %w", err)
+		}
+		for k, v := range overrides {
+			c.HyperParameters[k] = v
+		}
+	}
+	overrides, err := ProfileFor(parameter).HyperParameterOverrides()
+	if err != nil {
+		return c, err
+	}
+	for k, v := range overrides {
+		c.HyperParameters[k] = v
+	}
+	return c, nil
+}
+
+// TrainingJob is the state of a training job.
+type TrainingJob struct {
+	Name          string
+	Status        string
+	ModelArtifact string // S3 URI of model.tar.gz once Completed
+	FailureReason string
+	BillableSecs  int64
+}
+
+var jobNameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// TrainingJobName derives the job name of a run: "aquawatch-<runID>"
+// restricted to SageMaker's [a-zA-Z0-9-]{1,63}. Because the run ID is the
+// execution name, a retried start maps to the same job.
+func TrainingJobName(runID string) string {
+	if runID == "" {
+		runID = "train-" + strconv.FormatInt(time.Now().UTC().UnixMilli(), 10)
+	}
+	name := "aquawatch-" + strings.Trim(jobNameUnsafe.ReplaceAllString(runID, "-"), "-")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// CreateTrainingJob starts training on the CSV manifest at
+// s3://bucket/manifestKey, writing the model under outputPath. With spot
+// enabled, checkpoints go to <outputPath>checkpoints/<name>/ so interrupted
+// jobs resume. A job that already exists returns an error matching
+// ErrTrainingJobExists.
+func CreateTrainingJob(ctx context.Context, cfg TrainingConfig, name, bucket, manifestKey, outputPath string, tags map[string]string) error {
+	stopping := map[string]any{"MaxRuntimeInSeconds": cfg.MaxRuntimeSeconds}
+	in := map[string]any{
+		"TrainingJobName": name,
+		"AlgorithmSpecification": map[string]any{
+			"TrainingImage":     cfg.Image,
+			"TrainingInputMode": "File",
+		},
+		"InputDataConfig": []any{map[string]any{
+			"ChannelName": "train",
+			"ContentType": "text/csv",
+			"DataSource": map[string]any{"S3DataSource": map[string]any{
+				"S3DataType":             "ManifestFile",
+				"S3Uri":                  fmt.Sprintf("s3://%s/%s", bucket, manifestKey),
+				"S3DataDistributionType": "FullyReplicated",
+			}},
+		}},
+		"OutputDataConfig": map[string]any{"S3OutputPath": outputPath},
+		"ResourceConfig": map[string]any{
+			"InstanceType":   cfg.InstanceType,
+			"InstanceCount":  1,
+			"VolumeSizeInGB": cfg.VolumeGB,
+		},
+		"RoleArn":           cfg.RoleArn,
+		"HyperParameters":   cfg.HyperParameters,
+		"StoppingCondition": stopping,
+	}
+	if cfg.Spot {
+		in["EnableManagedSpotTraining"] = true
+		stopping["MaxWaitTimeInSeconds"] = cfg.MaxWaitSeconds
+		in["CheckpointConfig"] = map[string]any{"S3Uri": strings.TrimSuffix(outputPath, "/") + "/checkpoints/" + name + "/"}
+	}
+	if len(tags) > 0 {
+		var t []map[string]string
+		for k, v := range tags {
+			t = append(t, map[string]string{"Key": k, "Value": v})
+		}
+		in["Tags"] = t
+	}
+	return sagemakerAPI(ctx, "CreateTrainingJob", in, nil)
+}
+
+// DescribeTrainingJob returns the current state of a training job.
+func DescribeTrainingJob(ctx context.Context, name string) (*TrainingJob, error) {
+	var out struct {
+		TrainingJobName    string
+		TrainingJobStatus  string
+		FailureReason      string
+		BillableTimeInSecs int64
+		ModelArtifacts     struct{ S3ModelArtifacts string }
+	}
+	if err := sagemakerAPI(ctx, "DescribeTrainingJob", map[string]string{"TrainingJobName": name}, &out); err != nil {
+		return nil, err
+	}
+	return &TrainingJob{
+		Name:          out.TrainingJobName,
+		Status:        out.TrainingJobStatus,
+		ModelArtifact: out.ModelArtifacts.S3ModelArtifacts,
+		FailureReason: out.FailureReason,
+		BillableSecs:  out.BillableTimeInSecs,
+	}, nil
+}
+
+// sagemakerClient sends SageMaker API actions. They're all POSTs but safe to
+// repeat: describes are reads, and a training job name can only be created
+// once. The SigV4 signature stays valid across the retries.
+var sagemakerClient = httpclient.New(httpclient.Options{Name: "sagemaker", Timeout: 15 * time.Second, RetryPOST: true})
+
+// sagemakerAPI sends one SigV4-signed SageMaker JSON API action and decodes
+// the response into out (when non-nil).
+func sagemakerAPI(ctx context.Context, action string, in, out any) error {
+	cfg := getAWSConfig()
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	endpoint := endpointOverride("SAGEMAKER_API_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://api.sagemaker." + cfg.Region + ".amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "SageMaker."+action)
+
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("retrieve credentials: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "sagemaker", cfg.Region, time.Now()); err != nil {
+		return fmt.Errorf("sign %s: %w", action, err)
+	}
+
+	resp, err := sagemakerClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sagemaker %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+			Msg     string `json:"Message"`
+		}
+		_ = json.Unmarshal(data, &e)
+		code := e.Type
+		if i := strings.LastIndex(code, "#"); i >= 0 {
+			code = code[i+1:]
+		}
+		return &SageMakerAPIError{StatusCode: resp.StatusCode, Code: code, Message: e.Message + e.Msg}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Processed datasets keep growing as runs append parts, so the manifest a
+// training job was started with is frozen first: the train lambda copies it
+// to <dataset>/snapshots/<job>.json and trains from the copy. The model
+// registry then records the snapshot, the parts it lists and their schema
+// version, so any model can be traced back to (and retrained on) exactly the
+// rows it saw.
+
+// TrainingDataset identifies the data a model was trained on.
+type TrainingDataset struct {
+	// ProcessedKey is the processed dataset the snapshot was taken of.
+	ProcessedKey string `dynamodbav:"processed_key" json:"processed_key"`
+	// ManifestKey is the manifest the training job read: the snapshot, or
+	// the dataset's live manifest for models trained before snapshots.
+	ManifestKey   string   `dynamodbav:"manifest_key" json:"manifest_key"`
+	PartsPrefix   string   `dynamodbav:"parts_prefix,omitempty" json:"parts_prefix,omitempty"`
+	Parts         []string `dynamodbav:"parts,omitempty" json:"parts,omitempty"`
+	SchemaVersion string   `dynamodbav:"schema_version,omitempty" json:"schema_version,omitempty"`
+}
+
+// DatasetSnapshotKey returns the key of the manifest snapshot of dataset
+// taken for training job job.
+func DatasetSnapshotKey(dataset, job string) string {
+	return datasetBase(dataset) + "/snapshots/" + job + ".json"
+}
+
+// SnapshotDatasetManifest freezes the current manifest of dataset for
+// training job job and returns the snapshot's key. A snapshot that already
+// exists (a retried invocation) is kept as is.
+func SnapshotDatasetManifest(ctx context.Context, bucket, dataset, job string) (string, error) {
+	manifest, _, err := loadDatasetManifest(ctx, bucket, dataset)
+	if err != nil {
+		return "", err
+	}
+	if manifest == nil {
+		return "", fmt.Errorf("dataset %s has no manifest", dataset)
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	key := DatasetSnapshotKey(dataset, job)
+	if err := putIfUnchanged(ctx, bucket, key, data, ""); err != nil && !errors.Is(err, ErrPreconditionFailed) {
+		return "", fmt.Errorf("write snapshot %s: %w", key, err)
+	}
+	return key, nil
+}
+
+// DescribeTrainingDataset returns what training job job read from dataset:
+// its snapshot's parts and their schema version (from the first part's
+// metadata). Without a snapshot, only the live manifest key is reported.
+func DescribeTrainingDataset(ctx context.Context, bucket, dataset, job string) (*TrainingDataset, error) {
+	out := &TrainingDataset{ProcessedKey: dataset, ManifestKey: DatasetSnapshotKey(dataset, job)}
+	blob, err := getBlobStore().Get(ctx, bucket, out.ManifestKey)
+	if errors.Is(err, ErrBlobNotFound) {
+		out.ManifestKey = DatasetManifestKey(dataset)
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest DatasetManifest
+	if err := json.Unmarshal(blob.Data, &manifest); err != nil {
+		return nil, fmt.Errorf("decode snapshot %s: %w", out.ManifestKey, err)
+	}
+	out.PartsPrefix, out.Parts = manifest.Prefix, manifest.Parts
+	if len(manifest.Parts) > 0 {
+		prefix := strings.TrimPrefix(manifest.Prefix, "s3://"+bucket+"/")
+		part, err := getBlobStore().Get(ctx, bucket, prefix+manifest.Parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("read part %s: %w", manifest.Parts[0], err)
+		}
+		out.SchemaVersion = part.Metadata[MetaSchemaVersion]
+	}
+	return out, nil
+}
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"aquawatch/internal/httpclient"
+)
+
+// twilioProvider implements VerifyProvider with Twilio Verify v2, using
+// TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_VERIFY_SERVICE_SID. The
+// brand shown in the message is configured on the Verify service, so the
+// brand argument is ignored.
+// Docs: https://www.twilio.com/docs/verify/api
+type twilioProvider struct{}
+
+func (twilioProvider) Name() string { return VerifyProviderTwilio }
+
+type twilioConfig struct {
+	accountSID string
+	authToken  string
+	serviceSID string
+}
+
+func loadTwilioConfig() (twilioConfig, error) {
+	cfg := twilioConfig{
+		accountSID: os.Getenv("TWILIO_ACCOUNT_SID"),
+		authToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+		serviceSID: os.Getenv("TWILIO_VERIFY_SERVICE_SID"),
+	}
+	if cfg.accountSID == "" || cfg.authToken == "" || cfg.serviceSID == "" {
+		return cfg, errors.New("twilio verify credentials not configured")
+	}
+	return cfg, nil
+}
+
+// twilioVerification is the subset of the Verification / VerificationCheck
+// resources we read.
+type twilioVerification struct {
+	SID     string `json:"sid"`
+	To      string `json:"to"`
+	Status  string `json:"status"`
+	Valid   bool   `json:"valid"`
+	Message string `json:"message"`
+}
+
+// twilioClient calls the Twilio Verify API. Starting and checking a
+// verification are POSTs and aren't retried; status lookups are.
+var twilioClient = httpclient.New(httpclient.Options{Name: "twilio", Timeout: 5 * time.Second})
+
+// call sends a request to a Verify service sub-resource and decodes the
+// result. form is sent as the body of POSTs and ignored otherwise.
+func (twilioProvider) call(ctx context.Context, cfg twilioConfig, method, resource string, form url.Values) (*twilioVerification, int, error) {
+	endpoint := fmt.Sprintf("https://verify.twilio.com/v2/Services/%s/%s", url.PathEscape(cfg.serviceSID), resource)
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.SetBasicAuth(cfg.accountSID, cfg.authToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := twilioClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	var out twilioVerification
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, resp.StatusCode, err
+	}
+	return &out, resp.StatusCode, nil
+}
+
+// Start creates an SMS verification and returns its SID (VE...).
+func (t twilioProvider) Start(ctx context.Context, phoneE164, _ string) (string, error) {
+	cfg, err := loadTwilioConfig()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("To", phoneE164)
+	form.Set("Channel", "sms")
+	out, status, err := t.call(ctx, cfg, http.MethodPost, "Verifications", form)
+	if err != nil {
+		return "", err
+	}
+	if status/100 == 2 && out.SID != "" {
+		return out.SID, nil
+	}
+	if out.Message != "" {
+		return "", errors.New(out.Message)
+	}
+	return "", fmt.Errorf("verify start failed: status %d", status)
+}
+
+// Check validates code against the verification SID. A wrong code returns
+// false; an expired or already approved verification yields a 404 from
+// Twilio, reported as an error.
+func (t twilioProvider) Check(ctx context.Context, requestID, code string) (bool, error) {
+	cfg, err := loadTwilioConfig()
+	if err != nil {
+		return false, err
+	}
+	form := url.Values{}
+	form.Set("VerificationSid", requestID)
+	form.Set("Code", code)
+	out, status, err := t.call(ctx, cfg, http.MethodPost, "VerificationCheck", form)
+	if err != nil {
+		return false, err
+	}
+	if status/100 == 2 {
+		return out.Status == "approved" && out.Valid, nil
+	}
+	if out.Message != "" {
+		return false, errors.New(out.Message)
+	}
+	return false, errors.New("verification failed")
+}
+
+// Cancel marks the verification canceled.
+func (t twilioProvider) Cancel(ctx context.Context, requestID string) error {
+	cfg, err := loadTwilioConfig()
+	if err != nil {
+		return err
+	}
+	form := url.Values{}
+	form.Set("Status", "canceled")
+	out, status, err := t.call(ctx, cfg, http.MethodPost, "Verifications/"+url.PathEscape(requestID), form)
+	if err != nil {
+		return err
+	}
+	if status/100 != 2 {
+		return fmt.Errorf("%w: %s", ErrVerifyControlRejected, out.Message)
+	}
+	return nil
+}
+
+// Resend delivers the pending verification again as a voice call. Twilio
+// reuses a pending verification for the same number, so the request ID is
+// unchanged.
+func (t twilioProvider) Resend(ctx context.Context, requestID string) error {
+	cfg, err := loadTwilioConfig()
+	if err != nil {
+		return err
+	}
+	current, status, err := t.call(ctx, cfg, http.MethodGet, "Verifications/"+url.PathEscape(requestID), nil)
+	if err != nil {
+		return err
+	}
+	if status/100 != 2 || current.Status != "pending" || current.To == "" {
+		return fmt.Errorf("%w: verification is not pending", ErrVerifyControlRejected)
+	}
+	form := url.Values{}
+	form.Set("To", current.To)
+	form.Set("Channel", "call")
+	out, status, err := t.call(ctx, cfg, http.MethodPost, "Verifications", form)
+	if err != nil {
+		return err
+	}
+	if status/100 != 2 {
+		return fmt.Errorf("%w: %s", ErrVerifyControlRejected, out.Message)
+	}
+	return nil
+}
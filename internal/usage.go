@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Billable calls to paid services are counted per day, service and caller
+// in the usage table, together with an estimated cost, so operators can see
+// what sweeps and reports cost per month (GET /admin/usage). Costs are
+// estimates from configured unit prices: SageMaker bills endpoint instance
+// hours, not calls, so its per-call price is an average to tune against the
+// bill.
+
+// Metered services.
+const (
+	UsageSageMakerInvocation = "sagemaker.invocation"
+	UsageFoxitConversion     = "foxit.conversion"
+	UsageVonageVerification  = "vonage.verification"
+	UsageTwilioVerification  = "twilio.verification"
+)
+
+// usagePrices are the default estimated USD prices per call, each
+// overridable with its environment variable.
+var usagePrices = map[string]struct {
+	envVar string
+	def    float64
+}{
+	UsageSageMakerInvocation: {"USAGE_PRICE_SAGEMAKER_INVOCATION", 0.0002},
+	UsageFoxitConversion:     {"USAGE_PRICE_FOXIT_CONVERSION", 0.01},
+	UsageVonageVerification:  {"USAGE_PRICE_VONAGE_VERIFICATION", 0.05},
+	UsageTwilioVerification:  {"USAGE_PRICE_TWILIO_VERIFICATION", 0.05},
+}
+
+// UsagePrice returns the estimated USD price of one call to service.
+func UsagePrice(service string) float64 {
+	p, ok := usagePrices[service]
+	if !ok {
+		return 0
+	}
+	if v, err := strconv.ParseFloat(os.Getenv(p.envVar), 64); err == nil && v >= 0 {
+		return v
+	}
+	return p.def
+}
+
+// UsageRecord is one day's usage of a service by one caller. Costs are
+// stored in micro-dollars so concurrent increments stay exact.
+// Table name defaults to "api-usage"; override with USAGE_TABLE.
+// Keys: PK month (String, YYYY-MM), SK key (String, "<day>#<service>#<source>").
+type UsageRecord struct {
+	Month      string `dynamodbav:"month" json:"-"`
+	Key        string `dynamodbav:"key" json:"-"`
+	Day        string `dynamodbav:"day" json:"day"`
+	Service    string `dynamodbav:"service" json:"service"`
+	Source     string `dynamodbav:"source" json:"source"`
+	Count      int64  `dynamodbav:"count" json:"count"`
+	Units      int64  `dynamodbav:"units" json:"units"`
+	CostMicros int64  `dynamodbav:"cost_micros" json:"-"`
+}
+
+func usageTable() string {
+	return tableName("USAGE_TABLE", "api-usage")
+}
+
+// usageSource names the caller recorded with usage: the Lambda function, or
+// "api" for the API server.
+func usageSource() string {
+	if lambdacontext.FunctionName != "" {
+		return lambdacontext.FunctionName
+	}
+	return "api"
+}
+
+// RecordUsage counts one call to service, with units of work (e.g. rows
+// scored; 0 when not meaningful). It is best-effort: failures are logged,
+// and a cancelled ctx still records the call, which was made.
+func RecordUsage(ctx context.Context, service string, units int64) {
+	if err := recordUsage(context.WithoutCancel(ctx), service, units, time.Now().UTC()); err != nil {
+		log.Printf("recording %s usage failed: %v", service, err)
+	}
+}
+
+func recordUsage(ctx context.Context, service string, units int64, now time.Time) error {
+	day := now.Format(time.DateOnly)
+	source := usageSource()
+	key, err := attributevalue.MarshalMap(map[string]string{
+		"month": day[:7],
+		"key":   day + "#" + service + "#" + source,
+	})
+	if err != nil {
+		return err
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{
+		":day":     day,
+		":service": service,
+		":source":  source,
+		":one":     1,
+		":units":   units,
+		":cost":    int64(math.Round(UsagePrice(service) * 1e6)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = getDynamoClient().UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 awsString(usageTable()),
+		Key:                       key,
+		UpdateExpression:          awsString("SET #day = :day, #service = :service, #source = :source ADD #count :one, #units :units, cost_micros :cost"),
+		ExpressionAttributeNames:  map[string]string{"#day": "day", "#service": "service", "#source": "source", "#count": "count", "#units": "units"},
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
+
+// UsageTotal is usage summed over a month, per service or per source.
+type UsageTotal struct {
+	Name       string  `json:"name"`
+	Count      int64   `json:"count"`
+	Units      int64   `json:"units"`
+	CostUSD    float64 `json:"cost_usd"`
+	costMicros int64
+}
+
+// UsageDay is one day's usage of a service, over all sources.
+type UsageDay struct {
+	Day        string  `json:"day"`
+	Service    string  `json:"service"`
+	Count      int64   `json:"count"`
+	Units      int64   `json:"units"`
+	CostUSD    float64 `json:"cost_usd"`
+	costMicros int64
+}
+
+// UsageSummary is a month of usage.
+type UsageSummary struct {
+	Month        string             `json:"month"`
+	TotalCostUSD float64            `json:"total_cost_usd"`
+	Services     []UsageTotal       `json:"services"`
+	Sources      []UsageTotal       `json:"sources"`
+	Daily        []UsageDay         `json:"daily"`
+	PricesUSD    map[string]float64 `json:"prices_usd"`
+}
+
+// SummarizeUsage totals a month's usage (YYYY-MM) per service, per source
+// and per day and service.
+func SummarizeUsage(ctx context.Context, month string) (*UsageSummary, error) {
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return nil, fmt.Errorf("invalid month %q: want YYYY-MM", month)
+	}
+	values, err := attributevalue.MarshalMap(map[string]any{":month": month})
+	if err != nil {
+		return nil, err
+	}
+	repo := newRepository[UsageRecord](usageTable())
+	var records []UsageRecord
+	var cursor string
+	for {
+		page, next, err := repo.Query(ctx, &dynamodb.QueryInput{
+			KeyConditionExpression:    awsString("#month = :month"),
+			ExpressionAttributeNames:  map[string]string{"#month": "month"},
+			ExpressionAttributeValues: values,
+		}, cursor)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, page...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	s := &UsageSummary{Month: month, Services: []UsageTotal{}, Sources: []UsageTotal{}, Daily: []UsageDay{}, PricesUSD: map[string]float64{}}
+	for service := range usagePrices {
+		s.PricesUSD[service] = UsagePrice(service)
+	}
+	services := map[string]*UsageTotal{}
+	sources := map[string]*UsageTotal{}
+	daily := map[string]*UsageDay{}
+	var total int64
+	for _, r := range records {
+		total += r.CostMicros
+		addUsage(services, r.Service, r)
+		addUsage(sources, r.Source, r)
+		k := r.Day + "#" + r.Service
+		d, ok := daily[k]
+		if !ok {
+			d = &UsageDay{Day: r.Day, Service: r.Service}
+			daily[k] = d
+		}
+		d.Count += r.Count
+		d.Units += r.Units
+		d.costMicros += r.CostMicros
+	}
+	s.TotalCostUSD = microsToUSD(total)
+	for _, t := range services {
+		t.CostUSD = microsToUSD(t.costMicros)
+		s.Services = append(s.Services, *t)
+	}
+	for _, t := range sources {
+		t.CostUSD = microsToUSD(t.costMicros)
+		s.Sources = append(s.Sources, *t)
+	}
+	for _, d := range daily {
+		d.CostUSD = microsToUSD(d.costMicros)
+		s.Daily = append(s.Daily, *d)
+	}
+	byCost := func(a, b UsageTotal) int {
+		return cmp.Or(cmp.Compare(b.costMicros, a.costMicros), strings.Compare(a.Name, b.Name))
+	}
+	slices.SortFunc(s.Services, byCost)
+	slices.SortFunc(s.Sources, byCost)
+	slices.SortFunc(s.Daily, func(a, b UsageDay) int {
+		return cmp.Or(strings.Compare(a.Day, b.Day), strings.Compare(a.Service, b.Service))
+	})
+	return s, nil
+}
+
+func addUsage(totals map[string]*UsageTotal, name string, r UsageRecord) {
+	t, ok := totals[name]
+	if !ok {
+		t = &UsageTotal{Name: name}
+		totals[name] = t
+	}
+	t.Count += r.Count
+	t.Units += r.Units
+	t.costMicros += r.CostMicros
+}
+
+func microsToUSD(micros int64) float64 {
+	return float64(micros) / 1e6
+}
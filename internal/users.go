@@ -0,0 +1,251 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Users are keyed by an opaque user ID ("usr_<hex>") and found from a login
+// subject (E.164 phone, email address, or "oidc:<sub>") through the
+// user-subjects table. Session tokens carry the user ID as sub, so a user
+// keeps the same profile across sign-in methods linked to them.
+
+// userIDPrefix marks session subjects that are already user IDs; older
+// tokens carry a bare phone number instead.
+const userIDPrefix = "usr_"
+
+// Measurement units a user can choose.
+const (
+	UnitsMetric   = "metric"
+	UnitsImperial = "imperial"
+)
+
+// maxWatchlistSites bounds the default watchlist.
+const maxWatchlistSites = 50
+
+// ErrUserNotFound is returned when a user ID has no profile.
+var ErrUserNotFound = notFoundError("user not found")
+
+// ErrInvalidProfile is returned for profile updates that fail validation.
+var ErrInvalidProfile = validationError("invalid profile")
+
+var siteIDPattern = regexp.MustCompile(`^[0-9]{8,15}$`)
+
+// NotificationPreferences controls which channels a user is alerted on and
+// the lowest alert severity they want to hear about.
+type NotificationPreferences struct {
+	SMS         bool   `dynamodbav:"sms" json:"sms"`
+	Email       bool   `dynamodbav:"email" json:"email"`
+	MinSeverity string `dynamodbav:"min_severity,omitempty" json:"min_severity,omitempty"`
+}
+
+// User is a profile in the users table.
+// Table name defaults to "users"; override with USERS_TABLE. Keys: PK user_id.
+type User struct {
+	UserID           string                  `dynamodbav:"user_id" json:"user_id"`
+	Subject          string                  `dynamodbav:"subject" json:"subject"`
+	DisplayName      string                  `dynamodbav:"display_name,omitempty" json:"display_name"`
+	Notifications    NotificationPreferences `dynamodbav:"notifications" json:"notifications"`
+	DefaultWatchlist []string                `dynamodbav:"default_watchlist" json:"default_watchlist"`
+	Units            string                  `dynamodbav:"units" json:"units"`
+	CreatedOn        int64                   `dynamodbav:"createdon" json:"createdon_ms"`
+	UpdatedOn        int64                   `dynamodbav:"updatedon" json:"updatedon_ms"`
+	Version          int64                   `dynamodbav:"version" json:"version"`
+}
+
+// userSubject maps a login subject to its user ID.
+// Table name defaults to "user-subjects"; override with USER_SUBJECTS_TABLE.
+// Keys: PK subject.
+type userSubject struct {
+	Subject   string `dynamodbav:"subject"`
+	UserID    string `dynamodbav:"user_id"`
+	CreatedOn int64  `dynamodbav:"createdon"`
+}
+
+func usersTable() string {
+	return tableName("USERS_TABLE", "users")
+}
+
+func userSubjectsTable() string {
+	return tableName("USER_SUBJECTS_TABLE", "user-subjects")
+}
+
+// IsUserID reports whether a session subject is a user ID.
+func IsUserID(subject string) bool {
+	return strings.HasPrefix(subject, userIDPrefix)
+}
+
+// GetUser loads a profile by user ID.
+func GetUser(ctx context.Context, userID string) (*User, error) {
+	u, err := newRepository[User](usersTable()).Get(ctx, map[string]any{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+	return u, nil
+}
+
+// EnsureUser returns the user linked to a login subject, creating the profile
+// (and the link) on first sign-in. Concurrent first sign-ins converge on the
+// same user: only one subject link can be created.
+func EnsureUser(ctx context.Context, subject string) (*User, error) {
+	if subject == "" {
+		return nil, errors.New("subject is required")
+	}
+	links := newRepository[userSubject](userSubjectsTable())
+	link, err := links.Get(ctx, map[string]any{"subject": subject})
+	if err != nil {
+		return nil, err
+	}
+	if link != nil {
+		return GetUser(ctx, link.UserID)
+	}
+
+	id, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().UnixMilli()
+	user := User{
+		UserID:           userIDPrefix + id,
+		Subject:          subject,
+		Notifications:    NotificationPreferences{SMS: strings.HasPrefix(subject, "+"), Email: strings.Contains(subject, "@")},
+		DefaultWatchlist: []string{},
+		Units:            UnitsMetric,
+		CreatedOn:        now,
+		UpdatedOn:        now,
+	}
+	// Create the profile first so a link never points at a missing user; a
+	// profile orphaned by a lost race is harmless.
+	if err := newRepository[User](usersTable()).Create(ctx, user, "user_id"); err != nil {
+		return nil, err
+	}
+	err = links.Create(ctx, userSubject{Subject: subject, UserID: user.UserID, CreatedOn: now}, "subject")
+	if errors.Is(err, ErrAlreadyExists) {
+		return EnsureUser(ctx, subject)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UserForSubject resolves a session subject to a user: user IDs are loaded
+// directly, and legacy subjects (a bare phone) go through EnsureUser.
+func UserForSubject(ctx context.Context, subject string) (*User, error) {
+	if IsUserID(subject) {
+		return GetUser(ctx, subject)
+	}
+	return EnsureUser(ctx, subject)
+}
+
+// UserProfileUpdate is a partial profile update; nil fields are left as is.
+type UserProfileUpdate struct {
+	DisplayName      *string                  `json:"display_name"`
+	Notifications    *NotificationPreferences `json:"notifications"`
+	DefaultWatchlist *[]string                `json:"default_watchlist"`
+	Units            *string                  `json:"units"`
+}
+
+func (u UserProfileUpdate) validate() error {
+	if u.DisplayName != nil && len(*u.DisplayName) > 100 {
+		return fmt.Errorf("%w: display_name longer than 100 characters", ErrInvalidProfile)
+	}
+	if u.Units != nil && *u.Units != UnitsMetric && *u.Units != UnitsImperial {
+		return fmt.Errorf("%w: units must be %q or %q", ErrInvalidProfile, UnitsMetric, UnitsImperial)
+	}
+	if u.Notifications != nil && u.Notifications.MinSeverity != "" {
+		switch u.Notifications.MinSeverity {
+		case "low", "medium", "high":
+		default:
+			return fmt.Errorf("%w: min_severity must be low, medium or high", ErrInvalidProfile)
+		}
+	}
+	if u.DefaultWatchlist != nil {
+		if len(*u.DefaultWatchlist) > maxWatchlistSites {
+			return fmt.Errorf("%w: at most %d watchlist sites", ErrInvalidProfile, maxWatchlistSites)
+		}
+		for _, site := range *u.DefaultWatchlist {
+			if !siteIDPattern.MatchString(site) {
+				return fmt.Errorf("%w: invalid site id %q", ErrInvalidProfile, site)
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateUserProfile applies update to userID with optimistic locking on
+// expectedVersion. Errors match ErrUserNotFound, ErrVersionConflict or
+// ErrInvalidProfile.
+func UpdateUserProfile(ctx context.Context, userID string, update UserProfileUpdate, expectedVersion int64) (*User, error) {
+	if err := update.validate(); err != nil {
+		return nil, err
+	}
+	set := []string{"updatedon = :now", "#version = if_not_exists(#version, :zero) + :one"}
+	vals := map[string]any{
+		":now":      time.Now().UTC().UnixMilli(),
+		":expected": expectedVersion,
+		":zero":     0,
+		":one":      1,
+	}
+	if update.DisplayName != nil {
+		set = append(set, "display_name = :name")
+		vals[":name"] = strings.TrimSpace(*update.DisplayName)
+	}
+	if update.Notifications != nil {
+		set = append(set, "notifications = :notif")
+		vals[":notif"] = *update.Notifications
+	}
+	if update.DefaultWatchlist != nil {
+		set = append(set, "default_watchlist = :watch")
+		vals[":watch"] = *update.DefaultWatchlist
+	}
+	if update.Units != nil {
+		set = append(set, "units = :units")
+		vals[":units"] = *update.Units
+	}
+
+	table := usersTable()
+	key, err := attributevalue.MarshalMap(map[string]any{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	values, err := attributevalue.MarshalMap(vals)
+	if err != nil {
+		return nil, err
+	}
+	out, err := getDynamoClient().UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 &table,
+		Key:                       key,
+		UpdateExpression:          awsString("SET " + strings.Join(set, ", ")),
+		ConditionExpression:       awsString("attribute_exists(user_id) AND " + versionCondition(expectedVersion)),
+		ExpressionAttributeNames:  map[string]string{"#version": "version"},
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		if _, getErr := GetUser(ctx, userID); errors.Is(getErr, ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, &VersionConflictError{Table: table, Expected: expectedVersion}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var updated User
+	if err := attributevalue.UnmarshalMap(out.Attributes, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
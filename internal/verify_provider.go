@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Verification provider names, used in VERIFY_PROVIDER /
+// VERIFY_FALLBACK_PROVIDER and as request ID prefixes.
+const (
+	VerifyProviderVonage = "vonage"
+	VerifyProviderTwilio = "twilio"
+)
+
+// ErrVerifyControlRejected is returned when a provider refuses to cancel or
+// resend a verification in its current state.
+var ErrVerifyControlRejected = errors.New("verification control rejected")
+
+// VerifyProvider sends one-time codes and checks them. Start returns a
+// provider request ID that Check later validates the code against; Cancel
+// and Resend act on a pending request.
+type VerifyProvider interface {
+	Name() string
+	Start(ctx context.Context, phoneE164, brand string) (string, error)
+	Check(ctx context.Context, requestID, code string) (bool, error)
+	Cancel(ctx context.Context, requestID string) error
+	Resend(ctx context.Context, requestID string) error
+}
+
+// verifyProviderByName returns the provider registered under name.
+func verifyProviderByName(name string) (VerifyProvider, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case VerifyProviderVonage:
+		return vonageProvider{}, nil
+	case VerifyProviderTwilio:
+		return twilioProvider{}, nil
+	}
+	return nil, fmt.Errorf("unknown verify provider %q", name)
+}
+
+// verifyProviders returns the configured primary provider (VERIFY_PROVIDER,
+// default vonage) followed by the optional VERIFY_FALLBACK_PROVIDER.
+func verifyProviders() ([]VerifyProvider, error) {
+	primaryName := os.Getenv("VERIFY_PROVIDER")
+	if primaryName == "" {
+		primaryName = VerifyProviderVonage
+	}
+	primary, err := verifyProviderByName(primaryName)
+	if err != nil {
+		return nil, err
+	}
+	providers := []VerifyProvider{primary}
+	if name := os.Getenv("VERIFY_FALLBACK_PROVIDER"); name != "" {
+		fallback, err := verifyProviderByName(name)
+		if err != nil {
+			return nil, err
+		}
+		if fallback.Name() != primary.Name() {
+			providers = append(providers, fallback)
+		}
+	}
+	return providers, nil
+}
+
+// VerifyStart sends a code to phoneE164 with the primary provider, failing
+// over to the fallback provider if the primary errors. The returned request
+// ID records which provider issued it ("<provider>:<id>"); Vonage IDs are
+// returned bare so IDs issued before providers were pluggable stay valid.
+func VerifyStart(ctx context.Context, phoneE164, brand string) (string, error) {
+	providers, err := verifyProviders()
+	if err != nil {
+		return "", err
+	}
+	var lastErr error
+	for _, p := range providers {
+		id, err := p.Start(ctx, phoneE164, brand)
+		if err == nil {
+			// Providers bill per verification started ("vonage.verification").
+			RecordUsage(ctx, p.Name()+".verification", 0)
+			return encodeVerifyRequestID(p.Name(), id), nil
+		}
+		log.Printf("verify start via %s failed: %v", p.Name(), err)
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// VerifyCheck validates code against the provider that issued requestID.
+func VerifyCheck(ctx context.Context, requestID, code string) (bool, error) {
+	name, id := decodeVerifyRequestID(requestID)
+	p, err := verifyProviderByName(name)
+	if err != nil {
+		return false, err
+	}
+	return p.Check(ctx, id, code)
+}
+
+// VerifyCancel cancels the pending verification requestID, e.g. after the
+// user mistyped their number.
+func VerifyCancel(ctx context.Context, requestID string) error {
+	name, id := decodeVerifyRequestID(requestID)
+	p, err := verifyProviderByName(name)
+	if err != nil {
+		return err
+	}
+	return p.Cancel(ctx, id)
+}
+
+// VerifyResend re-sends the code for requestID, moving on to the provider's
+// next channel (such as a voice call) where it has one.
+func VerifyResend(ctx context.Context, requestID string) error {
+	name, id := decodeVerifyRequestID(requestID)
+	p, err := verifyProviderByName(name)
+	if err != nil {
+		return err
+	}
+	return p.Resend(ctx, id)
+}
+
+func encodeVerifyRequestID(provider, id string) string {
+	if provider == VerifyProviderVonage {
+		return id
+	}
+	return provider + ":" + id
+}
+
+func decodeVerifyRequestID(requestID string) (provider, id string) {
+	if name, rest, ok := strings.Cut(requestID, ":"); ok {
+		return name, rest
+	}
+	return VerifyProviderVonage, requestID
+}
@@ -4,19 +4,31 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 	"time"
+
+	"aquawatch/internal/httpclient"
 )
 
-// VerifyCheck validates a Vonage Verify code for a given request ID.
-// It returns true when the code is valid (status == "0").
+// vonageProvider implements VerifyProvider with Vonage (Nexmo) Verify v1,
+// using VONAGE_API_KEY and VONAGE_API_SECRET.
 // Docs: https://dashboard.nexmo.com/getting-started/verify
-func VerifyCheck(ctx context.Context, requestID, code string) (bool, error) {
-	apiKey := os.Getenv("VONAGE_API_KEY")
-	apiSecret := os.Getenv("VONAGE_API_SECRET")
+type vonageProvider struct{}
+
+// vonageClient calls the Vonage Verify API. Its calls are POSTs that send
+// codes or check single-use ones, so they aren't retried.
+var vonageClient = httpclient.New(httpclient.Options{Name: "vonage", Timeout: 5 * time.Second})
+
+func (vonageProvider) Name() string { return VerifyProviderVonage }
+
+// Check validates a Vonage Verify code for a given request ID.
+// It returns true when the code is valid (status == "0").
+func (vonageProvider) Check(ctx context.Context, requestID, code string) (bool, error) {
+	apiKey := secretValue("VONAGE_API_KEY")
+	apiSecret := secretValue("VONAGE_API_SECRET")
 	if apiKey == "" || apiSecret == "" {
 		return false, errors.New("vonage api credentials not configured")
 	}
@@ -33,8 +45,7 @@ func VerifyCheck(ctx context.Context, requestID, code string) (bool, error) {
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := vonageClient.Do(req)
 	if err != nil {
 		return false, err
 	}
@@ -56,11 +67,11 @@ func VerifyCheck(ctx context.Context, requestID, code string) (bool, error) {
 	return false, errors.New("verification failed")
 }
 
-// VerifyStart initiates a Vonage Verify request to send a PIN via SMS/voice.
+// Start initiates a Vonage Verify request to send a PIN via SMS/voice.
 // Returns the request_id on success (status == "0").
-func VerifyStart(ctx context.Context, phoneE164, brand string) (string, error) {
-	apiKey := os.Getenv("VONAGE_API_KEY")
-	apiSecret := os.Getenv("VONAGE_API_SECRET")
+func (vonageProvider) Start(ctx context.Context, phoneE164, brand string) (string, error) {
+	apiKey := secretValue("VONAGE_API_KEY")
+	apiSecret := secretValue("VONAGE_API_SECRET")
 	if apiKey == "" || apiSecret == "" {
 		return "", errors.New("vonage api credentials not configured")
 	}
@@ -80,8 +91,7 @@ func VerifyStart(ctx context.Context, phoneE164, brand string) (string, error) {
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := vonageClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -103,3 +113,56 @@ func VerifyStart(ctx context.Context, phoneE164, brand string) (string, error) {
 	}
 	return "", errors.New("verify start failed")
 }
+
+// Cancel aborts a pending verification (cmd=cancel). Vonage only allows this
+// 30 seconds after the request started and before the second attempt.
+func (v vonageProvider) Cancel(ctx context.Context, requestID string) error {
+	return v.control(ctx, requestID, "cancel")
+}
+
+// Resend advances the verification to its next event (cmd=trigger_next_event),
+// e.g. a repeat SMS or a voice call, instead of waiting for the timer.
+func (v vonageProvider) Resend(ctx context.Context, requestID string) error {
+	return v.control(ctx, requestID, "trigger_next_event")
+}
+
+// control sends a Verify control command for requestID. A non-zero status
+// (e.g. too early to cancel, or no events left) is reported as
+// ErrVerifyControlRejected.
+func (vonageProvider) control(ctx context.Context, requestID, cmd string) error {
+	apiKey := secretValue("VONAGE_API_KEY")
+	apiSecret := secretValue("VONAGE_API_SECRET")
+	if apiKey == "" || apiSecret == "" {
+		return errors.New("vonage api credentials not configured")
+	}
+
+	form := url.Values{}
+	form.Set("api_key", apiKey)
+	form.Set("api_secret", apiSecret)
+	form.Set("request_id", requestID)
+	form.Set("cmd", cmd)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.nexmo.com/verify/control/json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := vonageClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Status    string `json:"status"`
+		ErrorText string `json:"error_text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return err
+	}
+	if out.Status == "0" {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrVerifyControlRejected, out.ErrorText)
+}
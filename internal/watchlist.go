@@ -0,0 +1,231 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"regexp"
+	"slices"
+	"time"
+
+	"aquawatch/internal/cache"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// A watchlist is a named set of monitored stations, one record per site.
+// Watchlist sites count as monitored (MonitoredStations) alongside the sites
+// of enabled schedules and basins.
+//
+// Removing a site archives it rather than deleting the record: a site
+// archived on every watchlist it's on is left out of monitoring and of
+// scheduled and basin sweeps, while its anomalies, predictions and reports
+// stay queryable. Re-adding the site restores it.
+
+// DefaultWatchlist is the watchlist sites are added to when none is named.
+const DefaultWatchlist = "default"
+
+// ErrInvalidWatchlist is returned for malformed watchlist names.
+var ErrInvalidWatchlist = validationError("invalid watchlist")
+
+// ErrWatchlistSiteNotFound is returned when a site isn't on a watchlist.
+var ErrWatchlistSiteNotFound = notFoundError("watchlist site not found")
+
+var watchlistNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// WatchlistEntry is one site of a watchlist.
+// Table name defaults to "watchlist"; override with WATCHLIST_TABLE.
+// Keys: PK watchlist, SK site.
+type WatchlistEntry struct {
+	Watchlist string `dynamodbav:"watchlist" json:"watchlist"`
+	Site      string `dynamodbav:"site" json:"site"`
+	Name      string `dynamodbav:"name,omitempty" json:"name,omitempty"`
+	AddedBy   string `dynamodbav:"added_by,omitempty" json:"added_by,omitempty"`
+	AddedOn   int64  `dynamodbav:"addedon" json:"addedon_ms"`
+	// ImportID is the station import that added the site, if any.
+	ImportID string `dynamodbav:"import_id,omitempty" json:"import_id,omitempty"`
+	// ArchivedOn is set while the site is archived (removed from
+	// monitoring).
+	ArchivedOn int64  `dynamodbav:"archivedon,omitempty" json:"archivedon_ms,omitempty"`
+	ArchivedBy string `dynamodbav:"archived_by,omitempty" json:"archived_by,omitempty"`
+}
+
+// Archived reports whether the site is archived.
+func (e WatchlistEntry) Archived() bool {
+	return e.ArchivedOn > 0
+}
+
+func watchlistTable() string {
+	return tableName("WATCHLIST_TABLE", "watchlist")
+}
+
+// ValidateWatchlistName checks a watchlist name: lowercase letters, digits,
+// "-" and "_", at most 64 characters.
+func ValidateWatchlistName(name string) error {
+	if !watchlistNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: name must be 1-64 lowercase letters, digits, '-' or '_'", ErrInvalidWatchlist)
+	}
+	return nil
+}
+
+// AddWatchlistSite stores e, stamping AddedOn. It reports false, without
+// error, when the site is already on the watchlist; an archived site is
+// restored instead and reported as added.
+func AddWatchlistSite(ctx context.Context, e WatchlistEntry) (bool, error) {
+	e.AddedOn = time.Now().UTC().UnixMilli()
+	err := newRepository[WatchlistEntry](watchlistTable()).Create(ctx, e, "watchlist")
+	if errors.Is(err, ErrAlreadyExists) {
+		_, err := RestoreWatchlistSite(ctx, e.Watchlist, e.Site)
+		if errors.Is(err, ErrWatchlistSiteNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return err == nil, err
+}
+
+// ArchiveWatchlistSite archives a site of a watchlist on behalf of actor and
+// returns the updated entry. Archiving an archived site keeps its original
+// archive time. Errors match ErrWatchlistSiteNotFound.
+func ArchiveWatchlistSite(ctx context.Context, watchlist, site, actor string) (*WatchlistEntry, error) {
+	return updateWatchlistSite(ctx, watchlist, site,
+		"SET archivedon = if_not_exists(archivedon, :now), archived_by = if_not_exists(archived_by, :actor)",
+		"attribute_exists(site)",
+		map[string]any{":now": time.Now().UTC().UnixMilli(), ":actor": actor})
+}
+
+// RestoreWatchlistSite returns an archived site of a watchlist to
+// monitoring. Errors match ErrWatchlistSiteNotFound, also when the site
+// isn't archived.
+func RestoreWatchlistSite(ctx context.Context, watchlist, site string) (*WatchlistEntry, error) {
+	return updateWatchlistSite(ctx, watchlist, site,
+		"REMOVE archivedon, archived_by",
+		"attribute_exists(archivedon)",
+		nil)
+}
+
+func updateWatchlistSite(ctx context.Context, watchlist, site, update, condition string, vals map[string]any) (*WatchlistEntry, error) {
+	table := watchlistTable()
+	key, err := attributevalue.MarshalMap(map[string]any{"watchlist": watchlist, "site": site})
+	if err != nil {
+		return nil, err
+	}
+	in := &dynamodb.UpdateItemInput{
+		TableName:           &table,
+		Key:                 key,
+		UpdateExpression:    &update,
+		ConditionExpression: &condition,
+		ReturnValues:        types.ReturnValueAllNew,
+	}
+	if vals != nil {
+		if in.ExpressionAttributeValues, err = attributevalue.MarshalMap(vals); err != nil {
+			return nil, err
+		}
+	}
+	out, err := getDynamoClient().UpdateItem(ctx, in)
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return nil, ErrWatchlistSiteNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	archivedSiteCache.Delete(archivedSitesKey)
+	var e WatchlistEntry
+	if err := attributevalue.UnmarshalMap(out.Attributes, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ListWatchlist returns the entries of one watchlist in site order,
+// archived ones included.
+func ListWatchlist(ctx context.Context, watchlist string) ([]WatchlistEntry, error) {
+	values, err := attributevalue.MarshalMap(map[string]any{":w": watchlist})
+	if err != nil {
+		return nil, err
+	}
+	in := &dynamodb.QueryInput{
+		KeyConditionExpression:    awsString("watchlist = :w"),
+		ExpressionAttributeValues: values,
+	}
+	repo := newRepository[WatchlistEntry](watchlistTable())
+	out := []WatchlistEntry{}
+	var cursor string
+	for {
+		items, next, err := repo.Query(ctx, in, cursor)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+		if next == "" {
+			return out, nil
+		}
+		cursor = next
+	}
+}
+
+// ListWatchlistEntries returns the entries of every watchlist.
+func ListWatchlistEntries(ctx context.Context) ([]WatchlistEntry, error) {
+	table := watchlistTable()
+	paginator := dynamodb.NewScanPaginator(getDynamoClient(), &dynamodb.ScanInput{TableName: &table})
+	var out []WatchlistEntry
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var items []WatchlistEntry
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+	}
+	return out, nil
+}
+
+// archivedSiteCache holds ArchivedSites briefly, as it scans the watchlist
+// table; archiving and restoring through this process invalidate it.
+var archivedSiteCache = cache.New[string, map[string]bool]("archived-sites", 1, time.Minute)
+
+const archivedSitesKey = "all"
+
+// ArchivedSites returns the sites archived on every watchlist they're on.
+func ArchivedSites(ctx context.Context) (map[string]bool, error) {
+	return archivedSiteCache.GetOrLoad(ctx, archivedSitesKey, func(ctx context.Context) (map[string]bool, error) {
+		entries, err := ListWatchlistEntries(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return archivedSites(entries), nil
+	})
+}
+
+func archivedSites(entries []WatchlistEntry) map[string]bool {
+	archived := map[string]bool{}
+	for _, e := range entries {
+		if v, seen := archived[e.Site]; !seen || v {
+			archived[e.Site] = e.Archived()
+		}
+	}
+	maps.DeleteFunc(archived, func(_ string, v bool) bool { return !v })
+	return archived
+}
+
+// ExcludeArchivedSites returns sites without the archived ones, for sweeps.
+// When the watchlists can't be read the sites are returned unchanged.
+func ExcludeArchivedSites(ctx context.Context, sites []string) []string {
+	archived, err := ArchivedSites(ctx)
+	if err != nil {
+		log.Printf("archived sites lookup failed; not excluding any: %v", err)
+		return sites
+	}
+	if len(archived) == 0 {
+		return sites
+	}
+	return slices.DeleteFunc(slices.Clone(sites), func(site string) bool { return archived[site] })
+}
@@ -0,0 +1,494 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"aquawatch/internal/cache"
+	"aquawatch/internal/httpclient"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// A watchlist can name an automation webhook (opening a ticket, starting a
+// pump-station workflow, ...) that is POSTed the structured anomalies of its
+// sites at or above a minimum severity. Each call is a delivery, recorded
+// with its status in webhook-deliveries: failed attempts (network errors,
+// 429 and 5xx responses) are retried with backoff up to
+// WEBHOOK_MAX_ATTEMPTS times, and failed deliveries can be redelivered.
+//
+// Deliveries are signed like external sensor readings: X-Signature is
+// "sha256=" and the hex HMAC-SHA256 of "<X-Signature-Timestamp>.<body>"
+// with the webhook's secret.
+
+// Webhook delivery statuses.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+const (
+	// defaultWebhookMaxAttempts is the default of WEBHOOK_MAX_ATTEMPTS.
+	defaultWebhookMaxAttempts = 3
+	webhookBaseBackoff        = time.Second
+	// maxWebhookErrorLen bounds the error (or response excerpt) kept on a
+	// delivery.
+	maxWebhookErrorLen = 300
+	// defaultWebhookDeliveryTTLDays is the default of
+	// WEBHOOK_DELIVERY_TTL_DAYS.
+	defaultWebhookDeliveryTTLDays = 30
+)
+
+// ErrInvalidWebhook is returned for webhook settings that fail validation.
+var ErrInvalidWebhook = validationError("invalid webhook")
+
+// ErrWebhookNotFound is returned when a watchlist has no webhook.
+var ErrWebhookNotFound = notFoundError("webhook not found")
+
+// ErrWebhookDeliveryNotFound is returned when a delivery has no record.
+var ErrWebhookDeliveryNotFound = notFoundError("webhook delivery not found")
+
+// WatchlistWebhook is the automation webhook of a watchlist.
+// Table name defaults to "watchlist-webhooks"; override with
+// WATCHLIST_WEBHOOKS_TABLE. Keys: PK watchlist.
+type WatchlistWebhook struct {
+	Watchlist string `dynamodbav:"watchlist" json:"watchlist"`
+	URL       string `dynamodbav:"url" json:"url"`
+	// MinSeverity is the lowest anomaly severity sent: low, medium or high.
+	MinSeverity string `dynamodbav:"min_severity" json:"min_severity"`
+	// Secret signs deliveries. It is only shown when set.
+	Secret    string `dynamodbav:"secret" json:"-"`
+	UpdatedBy string `dynamodbav:"updated_by,omitempty" json:"updated_by,omitempty"`
+	UpdatedOn int64  `dynamodbav:"updatedon" json:"updatedon_ms"`
+}
+
+// WatchlistWebhookSpec sets a watchlist's webhook. MinSeverity defaults to
+// high. The secret is generated on first set and kept after that unless
+// RotateSecret is true.
+type WatchlistWebhookSpec struct {
+	URL          string `json:"url"`
+	MinSeverity  string `json:"min_severity"`
+	RotateSecret bool   `json:"rotate_secret"`
+}
+
+// WebhookDelivery is one call of a watchlist's webhook.
+// Table name defaults to "webhook-deliveries"; override with
+// WEBHOOK_DELIVERIES_TABLE. Keys: PK watchlist, SK seq. Items expire after
+// WEBHOOK_DELIVERY_TTL_DAYS (default 30).
+type WebhookDelivery struct {
+	Watchlist string `dynamodbav:"watchlist" json:"watchlist"`
+	// Seq identifies the delivery within the watchlist: its creation time in
+	// epoch microseconds.
+	Seq      int64    `dynamodbav:"seq" json:"seq"`
+	Severity string   `dynamodbav:"severity" json:"severity"`
+	Sites    []string `dynamodbav:"sites" json:"sites"`
+	// Payload is the JSON body sent.
+	Payload    string `dynamodbav:"payload" json:"-"`
+	Status     string `dynamodbav:"status" json:"status"`
+	Attempts   int    `dynamodbav:"attempts" json:"attempts"`
+	StatusCode int    `dynamodbav:"status_code,omitempty" json:"status_code,omitempty"`
+	Error      string `dynamodbav:"error,omitempty" json:"error,omitempty"`
+	CreatedOn  int64  `dynamodbav:"createdon" json:"createdon_ms"`
+	UpdatedOn  int64  `dynamodbav:"updatedon" json:"updatedon_ms"`
+	ExpiresAt  int64  `dynamodbav:"expires_at,omitempty" json:"-"`
+}
+
+// WebhookPayload is the body POSTed to a webhook.
+type WebhookPayload struct {
+	Event      string `json:"event"`
+	DeliveryID string `json:"delivery_id"`
+	Watchlist  string `json:"watchlist"`
+	// Severity is the highest severity among Anomalies.
+	Severity  string           `json:"severity"`
+	CreatedOn int64            `json:"createdon_ms"`
+	Anomalies []WebhookAnomaly `json:"anomalies"`
+}
+
+// WebhookAnomaly is one anomalous evaluation in a WebhookPayload.
+type WebhookAnomaly struct {
+	Site           string             `json:"site"`
+	Parameter      string             `json:"parameter"`
+	Severity       string             `json:"severity"`
+	ObservedValue  float64            `json:"observed_value"`
+	PredictedValue float64            `json:"predicted_value"`
+	PercentChange  float64            `json:"percent_change"`
+	Unit           string             `json:"unit,omitempty"`
+	Reason         string             `json:"reason,omitempty"`
+	EvaluatedOn    int64              `json:"evaluatedon_ms"`
+	Source         string             `json:"source,omitempty"`
+	Model          string             `json:"model,omitempty"`
+	Percentile     *PercentileContext `json:"percentile,omitempty"`
+	LeadTime       *FloodLeadTime     `json:"lead_time,omitempty"`
+}
+
+var webhookClient = httpclient.New(httpclient.Options{Name: "webhook", Timeout: 10 * time.Second, MaxAttempts: 1})
+
+func watchlistWebhooksTable() string {
+	return tableName("WATCHLIST_WEBHOOKS_TABLE", "watchlist-webhooks")
+}
+
+// WebhookDeliveryRetention returns the retention policy for the
+// webhook-deliveries table.
+func WebhookDeliveryRetention() RetentionConfig {
+	return RetentionConfig{
+		Table: tableName("WEBHOOK_DELIVERIES_TABLE", "webhook-deliveries"),
+		TTL:   retentionFromEnv("WEBHOOK_DELIVERY_TTL_DAYS", defaultWebhookDeliveryTTLDays),
+	}
+}
+
+// webhookMaxAttempts returns WEBHOOK_MAX_ATTEMPTS (default 3).
+func webhookMaxAttempts() int {
+	return envInt("WEBHOOK_MAX_ATTEMPTS", defaultWebhookMaxAttempts)
+}
+
+// webhookCache holds every webhook briefly, as loading them scans the
+// table; changes through this process invalidate it.
+var webhookCache = cache.New[string, []WatchlistWebhook]("watchlist-webhooks", 1, time.Minute)
+
+const allWebhooksKey = "all"
+
+// SetWatchlistWebhook creates or replaces the webhook of watchlist on
+// behalf of actor. The returned secret is non-empty only when it was
+// generated by this call. Errors match ErrInvalidWebhook.
+func SetWatchlistWebhook(ctx context.Context, watchlist string, spec WatchlistWebhookSpec, actor string) (*WatchlistWebhook, string, error) {
+	return setWatchlistWebhook(ctx, watchlist, spec, "", actor)
+}
+
+// setWatchlistWebhook is SetWatchlistWebhook with the secret given, as when
+// webhooks are imported; an empty secret is kept or generated as usual.
+func setWatchlistWebhook(ctx context.Context, watchlist string, spec WatchlistWebhookSpec, withSecret, actor string) (*WatchlistWebhook, string, error) {
+	u, err := url.Parse(strings.TrimSpace(spec.URL))
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, "", fmt.Errorf("%w: url must be an https URL", ErrInvalidWebhook)
+	}
+	if spec.MinSeverity == "" {
+		spec.MinSeverity = "high"
+	}
+	if alertSeverityRank[spec.MinSeverity] == 0 {
+		return nil, "", fmt.Errorf("%w: min_severity must be low, medium or high", ErrInvalidWebhook)
+	}
+	hook := &WatchlistWebhook{
+		Watchlist:   watchlist,
+		URL:         u.String(),
+		MinSeverity: spec.MinSeverity,
+		UpdatedBy:   actor,
+		UpdatedOn:   time.Now().UTC().UnixMilli(),
+	}
+	existing, err := GetWatchlistWebhook(ctx, watchlist)
+	if err != nil && !errors.Is(err, ErrWebhookNotFound) {
+		return nil, "", err
+	}
+	var secret string
+	if withSecret != "" {
+		if !strings.HasPrefix(withSecret, "whsec_") {
+			return nil, "", fmt.Errorf("%w: secret must start with whsec_", ErrInvalidWebhook)
+		}
+		hook.Secret = withSecret
+	} else if existing != nil && !spec.RotateSecret {
+		hook.Secret = existing.Secret
+	} else {
+		if secret, err = newTokenID(); err != nil {
+			return nil, "", err
+		}
+		hook.Secret = "whsec_" + secret
+		secret = hook.Secret
+	}
+	if err := newRepository[WatchlistWebhook](watchlistWebhooksTable()).Put(ctx, hook); err != nil {
+		return nil, "", err
+	}
+	webhookCache.Delete(allWebhooksKey)
+	return hook, secret, nil
+}
+
+// GetWatchlistWebhook returns the webhook of watchlist. Errors match
+// ErrWebhookNotFound.
+func GetWatchlistWebhook(ctx context.Context, watchlist string) (*WatchlistWebhook, error) {
+	hook, err := newRepository[WatchlistWebhook](watchlistWebhooksTable()).Get(ctx, map[string]any{"watchlist": watchlist})
+	if err != nil {
+		return nil, err
+	}
+	if hook == nil {
+		return nil, ErrWebhookNotFound
+	}
+	return hook, nil
+}
+
+// DeleteWatchlistWebhook removes the webhook of watchlist. Its deliveries
+// are kept until they expire. Errors match ErrWebhookNotFound.
+func DeleteWatchlistWebhook(ctx context.Context, watchlist string) error {
+	key, err := attributevalue.MarshalMap(map[string]any{"watchlist": watchlist})
+	if err != nil {
+		return err
+	}
+	out, err := getDynamoClient().DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:    awsString(watchlistWebhooksTable()),
+		Key:          key,
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return err
+	}
+	webhookCache.Delete(allWebhooksKey)
+	if len(out.Attributes) == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// watchlistWebhooks returns every configured webhook.
+func watchlistWebhooks(ctx context.Context) ([]WatchlistWebhook, error) {
+	return webhookCache.GetOrLoad(ctx, allWebhooksKey, func(ctx context.Context) ([]WatchlistWebhook, error) {
+		table := watchlistWebhooksTable()
+		paginator := dynamodb.NewScanPaginator(getDynamoClient(), &dynamodb.ScanInput{TableName: &table})
+		var out []WatchlistWebhook
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			var items []WatchlistWebhook
+			if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+				return nil, err
+			}
+			out = append(out, items...)
+		}
+		return out, nil
+	})
+}
+
+// NotifyWatchlistWebhooks delivers the anomalous evaluations among evals to
+// the webhook of every watchlist their sites are on (archived sites aside),
+// keeping those at or above the webhook's minimum severity. Webhooks are
+// called concurrently and failures are only logged: deliveries record
+// their own status.
+func NotifyWatchlistWebhooks(ctx context.Context, evals []AnomalyEvaluation) {
+	ctx = context.WithoutCancel(ctx)
+	hooks, err := watchlistWebhooks(ctx)
+	if err != nil {
+		log.Printf("loading watchlist webhooks failed; not notifying: %v", err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	entries, err := ListWatchlistEntries(ctx)
+	if err != nil {
+		log.Printf("loading watchlists failed; not notifying webhooks: %v", err)
+		return
+	}
+	onWatchlist := map[string]map[string]bool{}
+	for _, e := range entries {
+		if e.Archived() {
+			continue
+		}
+		if onWatchlist[e.Watchlist] == nil {
+			onWatchlist[e.Watchlist] = map[string]bool{}
+		}
+		onWatchlist[e.Watchlist][e.Site] = true
+	}
+	var wg sync.WaitGroup
+	for _, hook := range hooks {
+		payload := webhookPayload(hook, onWatchlist[hook.Watchlist], evals)
+		if payload == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := sendWebhook(ctx, hook, payload); err != nil {
+				log.Printf("webhook of watchlist %s: %v", hook.Watchlist, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// webhookPayload returns the payload of hook for the anomalies among evals
+// on sites, or nil when none reaches its minimum severity.
+func webhookPayload(hook WatchlistWebhook, sites map[string]bool, evals []AnomalyEvaluation) *WebhookPayload {
+	p := &WebhookPayload{Event: "anomaly", Watchlist: hook.Watchlist}
+	now := time.Now().UTC().UnixMilli()
+	for _, e := range evals {
+		severity := e.Rating()
+		if !e.Anomalous || !sites[e.Site] || alertSeverityRank[severity] < alertSeverityRank[hook.MinSeverity] {
+			continue
+		}
+		if alertSeverityRank[severity] > alertSeverityRank[p.Severity] {
+			p.Severity = severity
+		}
+		p.Anomalies = append(p.Anomalies, WebhookAnomaly{
+			Site:           e.Site,
+			Parameter:      e.Parameter,
+			Severity:       severity,
+			ObservedValue:  e.ObservedValue,
+			PredictedValue: e.PredictedValue,
+			PercentChange:  e.PercentChange,
+			Unit:           e.Unit,
+			Reason:         e.Reason,
+			EvaluatedOn:    cmp.Or(e.EvaluatedOn, now),
+			Source:         e.Source,
+			Model:          e.Model,
+			Percentile:     e.Percentile,
+			LeadTime:       e.LeadTime,
+		})
+	}
+	if len(p.Anomalies) == 0 {
+		return nil
+	}
+	return p
+}
+
+// sendWebhook records a new delivery of payload and delivers it.
+func sendWebhook(ctx context.Context, hook WatchlistWebhook, payload *WebhookPayload) (*WebhookDelivery, error) {
+	now := time.Now().UTC()
+	d := &WebhookDelivery{
+		Watchlist: hook.Watchlist,
+		Seq:       now.UnixMicro(),
+		Severity:  payload.Severity,
+		Status:    WebhookDeliveryPending,
+		CreatedOn: now.UnixMilli(),
+		UpdatedOn: now.UnixMilli(),
+		ExpiresAt: WebhookDeliveryRetention().ExpiresAt(now),
+	}
+	for _, a := range payload.Anomalies {
+		if !slices.Contains(d.Sites, a.Site) {
+			d.Sites = append(d.Sites, a.Site)
+		}
+	}
+	payload.DeliveryID = strconv.FormatInt(d.Seq, 10)
+	payload.CreatedOn = d.CreatedOn
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	d.Payload = string(body)
+	repo := newRepository[WebhookDelivery](WebhookDeliveryRetention().Table)
+	if err := repo.Put(ctx, d); err != nil {
+		return nil, fmt.Errorf("record delivery: %w", err)
+	}
+	return d, deliverWebhook(ctx, hook, d)
+}
+
+// deliverWebhook POSTs d's payload to hook, retrying failed attempts with
+// backoff, and stores the outcome on d.
+func deliverWebhook(ctx context.Context, hook WatchlistWebhook, d *WebhookDelivery) error {
+	backoff := webhookBaseBackoff
+	for attempt := 1; ; attempt++ {
+		d.Attempts++
+		retry, err := postWebhook(ctx, hook, d)
+		if err == nil {
+			d.Status, d.Error = WebhookDeliveryDelivered, ""
+			break
+		}
+		d.Status, d.Error = WebhookDeliveryFailed, err.Error()
+		if len(d.Error) > maxWebhookErrorLen {
+			d.Error = d.Error[:maxWebhookErrorLen]
+		}
+		if !retry || attempt >= webhookMaxAttempts() {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	d.UpdatedOn = time.Now().UTC().UnixMilli()
+	if err := newRepository[WebhookDelivery](WebhookDeliveryRetention().Table).Put(ctx, d); err != nil {
+		return fmt.Errorf("record delivery %d: %w", d.Seq, err)
+	}
+	if d.Status == WebhookDeliveryFailed {
+		return fmt.Errorf("delivery %d failed after %d attempts: %s", d.Seq, d.Attempts, d.Error)
+	}
+	return nil
+}
+
+// postWebhook makes one delivery attempt and reports whether a failure is
+// worth retrying.
+func postWebhook(ctx context.Context, hook WatchlistWebhook, d *WebhookDelivery) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, strings.NewReader(d.Payload))
+	if err != nil {
+		return false, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "AquaWatch-Webhook/1")
+	req.Header.Set("X-AquaWatch-Event", "anomaly")
+	req.Header.Set("X-AquaWatch-Delivery", strconv.FormatInt(d.Seq, 10))
+	req.Header.Set("X-Signature-Timestamp", ts)
+	req.Header.Set("X-Signature", ExternalSignature(hook.Secret, ts, []byte(d.Payload)))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		d.StatusCode = 0
+		return true, err
+	}
+	defer resp.Body.Close()
+	d.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookErrorLen))
+	err = fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(excerpt)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// RedeliverWebhook sends a recorded delivery of watchlist again to the
+// watchlist's current webhook, with the same payload and delivery ID, and
+// returns it with the outcome. Errors match ErrWebhookDeliveryNotFound or
+// ErrWebhookNotFound; a failed delivery is returned without error.
+func RedeliverWebhook(ctx context.Context, watchlist string, seq int64) (*WebhookDelivery, error) {
+	d, err := newRepository[WebhookDelivery](WebhookDeliveryRetention().Table).Get(ctx, map[string]any{"watchlist": watchlist, "seq": seq})
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	hook, err := GetWatchlistWebhook(ctx, watchlist)
+	if err != nil {
+		return nil, err
+	}
+	if err := deliverWebhook(ctx, *hook, d); err != nil && d.Status != WebhookDeliveryFailed {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ListWebhookDeliveries returns a page of watchlist's deliveries, newest
+// first. See ListRecentAlertsPage for cursor semantics.
+func ListWebhookDeliveries(ctx context.Context, watchlist string, limit int, cursor string) ([]WebhookDelivery, string, error) {
+	values, err := attributevalue.MarshalMap(map[string]any{":w": watchlist})
+	if err != nil {
+		return nil, "", err
+	}
+	in := &dynamodb.QueryInput{
+		KeyConditionExpression:    awsString("watchlist = :w"),
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          awsBool(false),
+		Limit:                     awsInt32(int32(limit)),
+	}
+	items, next, err := newRepository[WebhookDelivery](WebhookDeliveryRetention().Table).Query(ctx, in, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if items == nil {
+		items = []WebhookDelivery{}
+	}
+	return items, next, nil
+}
@@ -1,15 +1,31 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"sync"
 	"time"
+
+	"aquawatch/internal/httpclient"
 )
 
 // This file contains small helpers to query the National Weather Service
 // (api.weather.gov) for a location's short-term forecast.
 
+// nwsClient calls api.weather.gov, which rejects requests without a
+// User-Agent identifying the caller.
+var nwsClient = httpclient.New(httpclient.Options{
+	Name:          "nws",
+	Timeout:       10 * time.Second,
+	RatePerSecond: 5,
+	Burst:         5,
+	UserAgent:     "aquawatch/1.0 (contact: dev@aquawatch)",
+	Breaker:       true,
+})
+
 type nwsPointsResponse struct {
 	Properties struct {
 		Forecast string `json:"forecast"`
@@ -27,56 +43,181 @@ type nwsForecastResponse struct {
 	} `json:"properties"`
 }
 
+// nwsPeriod is the part of a forecast period we use.
+type nwsPeriod struct {
+	Temperature     int
+	TemperatureUnit string
+	WindSpeed       string
+	WindDirection   string
+}
+
 // FetchWeatherForecast requests the forecast URL for the given coordinates and
 // returns the first forecast period's temperature (and unit) along with wind
 // speed and direction. If the API is unavailable, the caller should treat the
 // returned error and decide on a fallback policy.
-func FetchWeatherForecast(lat, lon float64) (int, string, string, string, error) {
-	client := &http.Client{Timeout: 10 * time.Second}
-	pointsURL := fmt.Sprintf("https://api.weather.gov/points/%0.4f,%0.4f", lat, lon)
-
-	req, err := http.NewRequest(http.MethodGet, pointsURL, nil)
+func FetchWeatherForecast(ctx context.Context, lat, lon float64) (int, string, string, string, error) {
+	ctx, cancel := withStageTimeout(ctx, StageWeather)
+	defer cancel()
+	forecastURL, err := nwsForecastURL(ctx, lat, lon)
 	if err != nil {
 		return 0, "", "", "", err
 	}
-	req.Header.Set("User-Agent", "aquawatch/1.0 (contact: dev@aquawatch)")
-
-	resp, err := client.Do(req)
+	p, err := nwsFirstPeriod(ctx, forecastURL)
 	if err != nil {
 		return 0, "", "", "", err
 	}
+	return p.Temperature, p.TemperatureUnit, p.WindSpeed, p.WindDirection, nil
+}
+
+// nwsForecastURL resolves the forecast URL of the gridpoint containing
+// lat, lon. Nearby points share a gridpoint, and so a forecast URL.
+func nwsForecastURL(ctx context.Context, lat, lon float64) (string, error) {
+	pointsURL := fmt.Sprintf("https://api.weather.gov/points/%0.4f,%0.4f", lat, lon)
+	resp, err := nwsClient.Get(ctx, pointsURL)
+	if err != nil {
+		return "", err
+	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return 0, "", "", "", fmt.Errorf("points request failed: %d", resp.StatusCode)
+		return "", fmt.Errorf("points request failed: %d", resp.StatusCode)
 	}
 	var pr nwsPointsResponse
 	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
-		return 0, "", "", "", err
+		return "", err
 	}
 	if pr.Properties.Forecast == "" {
-		return 0, "", "", "", fmt.Errorf("forecast URL missing in response")
+		return "", fmt.Errorf("forecast URL missing in response")
 	}
+	return pr.Properties.Forecast, nil
+}
 
-	freq, err := http.NewRequest(http.MethodGet, pr.Properties.Forecast, nil)
-	if err != nil {
-		return 0, "", "", "", err
-	}
-	freq.Header.Set("User-Agent", "aquawatch/1.0 (contact: dev@aquawatch)")
-	fresp, err := client.Do(freq)
+// nwsFirstPeriod fetches a gridpoint forecast and returns its first period.
+func nwsFirstPeriod(ctx context.Context, forecastURL string) (nwsPeriod, error) {
+	resp, err := nwsClient.Get(ctx, forecastURL)
 	if err != nil {
-		return 0, "", "", "", err
+		return nwsPeriod{}, err
 	}
-	defer fresp.Body.Close()
-	if fresp.StatusCode != http.StatusOK {
-		return 0, "", "", "", fmt.Errorf("forecast request failed: %d", fresp.StatusCode)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nwsPeriod{}, fmt.Errorf("forecast request failed: %d", resp.StatusCode)
 	}
 	var fr nwsForecastResponse
-	if err := json.NewDecoder(fresp.Body).Decode(&fr); err != nil {
-		return 0, "", "", "", err
+	if err := json.NewDecoder(resp.Body).Decode(&fr); err != nil {
+		return nwsPeriod{}, err
 	}
 	if len(fr.Properties.Periods) == 0 {
-		return 0, "", "", "", fmt.Errorf("no forecast periods available")
+		return nwsPeriod{}, fmt.Errorf("no forecast periods available")
 	}
 	p := fr.Properties.Periods[0]
-	return p.Temperature, p.TemperatureUnit, p.WindSpeed, p.WindDirection, nil
+	return nwsPeriod{
+		Temperature:     p.Temperature,
+		TemperatureUnit: p.TemperatureUnit,
+		WindSpeed:       p.WindSpeed,
+		WindDirection:   p.WindDirection,
+	}, nil
+}
+
+// Coord is a latitude/longitude pair.
+type Coord struct {
+	Lat float64
+	Lon float64
+}
+
+// gridForecasts shares forecast fetches between coordinates that resolve to
+// the same gridpoint: the first lookup of a forecast URL fetches it and the
+// others wait for its result.
+type gridForecasts struct {
+	mu    sync.Mutex
+	calls map[string]*gridForecast
+}
+
+type gridForecast struct {
+	done   chan struct{}
+	period nwsPeriod
+	err    error
+}
+
+func (g *gridForecasts) get(ctx context.Context, forecastURL string) (nwsPeriod, error) {
+	g.mu.Lock()
+	c, ok := g.calls[forecastURL]
+	if !ok {
+		c = &gridForecast{done: make(chan struct{})}
+		g.calls[forecastURL] = c
+	}
+	g.mu.Unlock()
+	if !ok {
+		c.period, c.err = nwsFirstPeriod(ctx, forecastURL)
+		close(c.done)
+	}
+	select {
+	case <-c.done:
+		return c.period, c.err
+	case <-ctx.Done():
+		return nwsPeriod{}, ctx.Err()
+	}
+}
+
+// FetchTemperatures looks up the current forecast temperature at each
+// coordinate, with up to WEATHER_CONCURRENCY (default 8) lookups in flight.
+// Coordinates in the same NWS gridpoint share one forecast request. Each
+// lookup has the weather stage deadline; coordinates whose lookup fails are
+// left out of the result.
+func FetchTemperatures(ctx context.Context, coords []Coord) map[Coord]int {
+	var unique []Coord
+	seen := map[Coord]bool{}
+	for _, c := range coords {
+		if !seen[c] {
+			seen[c] = true
+			unique = append(unique, c)
+		}
+	}
+	temps := make(map[Coord]int, len(unique))
+	if len(unique) == 0 {
+		return temps
+	}
+
+	grids := &gridForecasts{calls: map[string]*gridForecast{}}
+	jobs := make(chan Coord)
+	var (
+		mu     sync.Mutex
+		failed int
+		wg     sync.WaitGroup
+	)
+	for range min(envInt("WEATHER_CONCURRENCY", 8), len(unique)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range jobs {
+				temp, err := lookupTemperature(ctx, grids, c)
+				mu.Lock()
+				if err != nil {
+					failed++
+				} else {
+					temps[c] = temp
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, c := range unique {
+		jobs <- c
+	}
+	close(jobs)
+	wg.Wait()
+	log.Printf("weather: %d coordinates, %d gridpoints, %d failed", len(unique), len(grids.calls), failed)
+	return temps
+}
+
+func lookupTemperature(ctx context.Context, grids *gridForecasts, c Coord) (int, error) {
+	ctx, cancel := withStageTimeout(ctx, StageWeather)
+	defer cancel()
+	forecastURL, err := nwsForecastURL(ctx, c.Lat, c.Lon)
+	if err != nil {
+		return 0, err
+	}
+	p, err := grids.get(ctx, forecastURL)
+	if err != nil {
+		return 0, err
+	}
+	return p.Temperature, nil
 }
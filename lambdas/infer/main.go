@@ -2,12 +2,16 @@ package main
 
 import (
 	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+	"aquawatch/internal/tracing"
 	"context"
 	"encoding/csv"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
 )
@@ -21,53 +25,89 @@ type Prediction struct {
 	Unit      string  `json:"unit"`
 }
 
-// inferInput matches the Step Functions payload. If training ran in the same
-// execution, s3_model_artifacts carries the model artifact S3 URI. For MME,
-// the handler derives the model file name (or uses env).
-type inferInput struct {
-	Bucket           string   `json:"bucket"`
-	ProcessedKey     string   `json:"processed_key"`
-	S3ModelArtifacts string   `json:"s3_model_artifacts,omitempty"`
-	Sites            []string `json:"sites"`
+// handler runs inference, records the outcome for each site in the
+// prediction tracker (completed, or failed with the error message) and emits
+// the step's metrics.
+func handler(ctx context.Context, input pipeline.InferInput) (pipeline.InferOutput, error) {
+	ctx, seg := tracing.Start(ctx, "infer")
+	seg.AnnotateRun(input.RunID, input.Sites, input.ProcessedKey)
+	m := internal.NewStepMetrics(ctx, "infer", input.RunID).ForSites(input.Sites...)
+	out, err := infer(ctx, input, m)
+	seg.Annotate("model", out.Model)
+	m.Set("RowsProcessed", internal.UnitCount, float64(out.Rows))
+	m.Set("Predictions", internal.UnitCount, float64(out.Predictions))
+	m.Property("Model", out.Model)
+	m.Finish(err)
+	if err == nil {
+		internal.RecordProgress(ctx, input.ExecutionArn, "Infer", internal.ProgressPredictionsWritten, out.Predictions,
+			fmt.Sprintf("scored %d rows with %s", out.Rows, out.Model))
+	}
+	status, msg := internal.PredictionStatusCompleted, ""
+	if err != nil {
+		status, msg = internal.PredictionStatusFailed, err.Error()
+	}
+	for _, site := range input.Sites {
+		if terr := internal.UpdatePredictionTrackerStatus(ctx, site, status, msg); terr != nil {
+			log.Printf("prediction tracker update failed for %s: %v", site, terr)
+		}
+	}
+	seg.End(err)
+	return out, err
 }
 
-func handler(ctx context.Context, input inferInput) error {
+// infer runs the dataset through the model endpoint. When training ran in
+// the same execution, S3ModelArtifacts carries the model artifact S3 URI;
+// for MME the target model is derived from it (or DEFAULT_MODEL).
+func infer(ctx context.Context, input pipeline.InferInput, m *internal.StepMetrics) (pipeline.InferOutput, error) {
 	log.Println("AquaWatch Infer Lambda triggered")
 
-	if input.Bucket == "" || input.ProcessedKey == "" {
-		return fmt.Errorf("missing required fields: bucket, processedKey")
+	var out pipeline.InferOutput
+	if err := input.Validate(); err != nil {
+		return out, err
 	}
 
 	endpoint := os.Getenv("SAGEMAKER_ENDPOINT")
 	if endpoint == "" {
-		return fmt.Errorf("SAGEMAKER_ENDPOINT not configured")
+		return out, fmt.Errorf("SAGEMAKER_ENDPOINT not configured")
 	}
 
 	if input.S3ModelArtifacts == "" {
 		defaultModel := os.Getenv("DEFAULT_MODEL")
 		if defaultModel == "" {
-			return fmt.Errorf("DEFAULT_MODEL not configured")
+			return out, fmt.Errorf("DEFAULT_MODEL not configured")
 		}
 		input.S3ModelArtifacts = defaultModel
 	}
 
 	log.Println("using target model:", input.S3ModelArtifacts)
 
-	prefix := fmt.Sprintf("s3://%s/model", input.Bucket)
+	prefix := internal.Layout().ModelOutputURI(input.Bucket)
 	targetModel := strings.TrimPrefix(input.S3ModelArtifacts, prefix)
+	out.Model = targetModel
 
-	csvData, err := internal.LoadFromS3(ctx, input.Bucket, input.ProcessedKey)
+	maxRows := input.MaxRows
+	if maxRows <= 0 {
+		maxRows, _ = strconv.Atoi(os.Getenv("INFER_MAX_ROWS"))
+	}
+	var csvData []byte
+	var err error
+	if maxRows > 0 {
+		csvData, err = internal.LoadDatasetTail(ctx, input.Bucket, input.ProcessedKey, maxRows)
+	} else {
+		csvData, err = internal.LoadDataset(ctx, input.Bucket, input.ProcessedKey)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to load processed data: %w", err)
+		return out, fmt.Errorf("failed to load processed data: %w", err)
 	}
 
 	// Convert training CSV (label + numeric features) into features-only rows for inference.
 	reader := csv.NewReader(strings.NewReader(string(csvData)))
 	records, err := reader.ReadAll()
 	if err != nil {
-		return fmt.Errorf("failed to parse csv: %w", err)
+		return out, fmt.Errorf("failed to parse csv: %w", err)
 	}
 	var builder strings.Builder
+	var rows [][]string
 	for _, r := range records {
 		if len(r) == 0 {
 			continue
@@ -77,18 +117,54 @@ func handler(ctx context.Context, input inferInput) error {
 		if len(r) > 1 {
 			features = r[1:]
 		}
+		rows = append(rows, features)
 		builder.WriteString(strings.Join(features, ","))
 		builder.WriteByte('\n')
 	}
 
+	invokeStart := time.Now()
 	predBytes, err := internal.InvokeEndpoint(ctx, endpoint, []byte(builder.String()), targetModel)
+	m.Set("InferenceLatency", internal.UnitMilliseconds, float64(time.Since(invokeStart).Milliseconds()))
 	if err != nil {
-		return fmt.Errorf("failed to invoke endpoint: %w", err)
+		return out, fmt.Errorf("failed to invoke endpoint: %w", err)
 	}
+	out.Rows = len(rows)
 
-	log.Println("raw prediction bytes:", string(predBytes))
+	internal.LogPayload("predictions", predBytes)
 
-	return nil
+	// Best-effort: persist one record per input row (batched)
+	values, err := internal.ParsePredictionValues(predBytes)
+	if err != nil {
+		log.Printf("could not parse predictions: %v", err)
+		return out, nil
+	}
+	out.Predictions = len(values)
+	if err := internal.SavePredictionRecords(ctx, buildPredictionRecords(input.ProcessedKey, targetModel, rows, values)); err != nil {
+		log.Printf("failed to persist predictions: %v", err)
+	}
+	return out, nil
+}
+
+// buildPredictionRecords pairs each feature row (timestamp,lat,lng,wx_temp)
+// with its prediction. Extra rows or values without a counterpart are dropped.
+func buildPredictionRecords(dataset, model string, rows [][]string, values []float64) []internal.PredictionRecord {
+	n := min(len(rows), len(values))
+	out := make([]internal.PredictionRecord, 0, n)
+	for i := 0; i < n; i++ {
+		rec := internal.PredictionRecord{
+			Dataset:   dataset,
+			Row:       i,
+			Predicted: values[i],
+			Model:     model,
+		}
+		if len(rows[i]) >= 3 {
+			rec.Timestamp, _ = strconv.ParseInt(rows[i][0], 10, 64)
+			rec.Latitude, _ = strconv.ParseFloat(rows[i][1], 64)
+			rec.Longitude, _ = strconv.ParseFloat(rows[i][2], 64)
+		}
+		out = append(out, rec)
+	}
+	return out
 }
 
 func main() {
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"aquawatch/internal"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// maxRollupDays bounds the days one invocation rolls up.
+const maxRollupDays = 31
+
+// rollupInput is the (optional) scheduled event payload.
+// day: the last UTC day to roll up, YYYY-MM-DD (default yesterday)
+// days: how many days ending at day to roll up (default 1, at most 31), to
+// backfill or redo a range
+type rollupInput struct {
+	Day  string `json:"day,omitempty"`
+	Days int    `json:"days,omitempty"`
+}
+
+// handler sums each day's anomalies and alerts per site into the
+// metrics-rollups table. Intended to run nightly from an EventBridge
+// schedule, after the UTC day is over.
+func handler(ctx context.Context, in rollupInput) ([]*internal.RollupResult, error) {
+	log.Println("AquaWatch Metrics Rollup Lambda triggered")
+	last := time.Now().UTC().AddDate(0, 0, -1)
+	if in.Day != "" {
+		d, err := time.Parse(time.DateOnly, in.Day)
+		if err != nil {
+			return nil, fmt.Errorf("day must be YYYY-MM-DD: %w", err)
+		}
+		last = d
+	}
+	days := min(max(in.Days, 1), maxRollupDays)
+
+	m := internal.NewStepMetrics(ctx, "metrics_rollup", "")
+	var (
+		results   []*internal.RollupResult
+		anomalies int
+		alerts    int
+		err       error
+	)
+	for i := days - 1; i >= 0; i-- {
+		day := last.AddDate(0, 0, -i).Format(time.DateOnly)
+		var res *internal.RollupResult
+		if res, err = internal.RollupMetrics(ctx, day); err != nil {
+			err = fmt.Errorf("roll up %s: %w", day, err)
+			break
+		}
+		log.Printf("rolled up %s: %d sites, %d evaluations, %d anomalies, %d alerts",
+			res.Day, res.Sites, res.Evaluations, res.Anomalies, res.Alerts)
+		results = append(results, res)
+		anomalies += res.Anomalies
+		alerts += res.Alerts
+	}
+	m.Set("DaysRolledUp", internal.UnitCount, float64(len(results)))
+	m.Set("Anomalies", internal.UnitCount, float64(anomalies))
+	m.Set("Alerts", internal.UnitCount, float64(alerts))
+	m.Finish(err)
+	return results, err
+}
+
+func main() {
+	lambda.Start(handler)
+}
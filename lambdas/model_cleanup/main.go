@@ -0,0 +1,50 @@
+package main
+
+import (
+	"aquawatch/internal"
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// cleanupInput is the (optional) scheduled event payload.
+// keep_per_site: models kept per site (default MODEL_KEEP_PER_SITE, or 3)
+// dry_run: report what would be archived without deleting anything
+type cleanupInput struct {
+	KeepPerSite int  `json:"keep_per_site,omitempty"`
+	DryRun      bool `json:"dry_run,omitempty"`
+}
+
+// handler removes superseded model artifacts and archives their registry
+// entries. Intended to run daily from an EventBridge schedule.
+func handler(ctx context.Context, in cleanupInput) (*internal.ModelCleanupResult, error) {
+	log.Println("AquaWatch Model Cleanup Lambda triggered")
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("S3_BUCKET must be configured")
+	}
+	keep := in.KeepPerSite
+	if keep <= 0 {
+		keep = internal.ModelsKeptPerSite()
+	}
+	m := internal.NewStepMetrics(ctx, "model_cleanup", "")
+	res, err := internal.CleanupModels(ctx, bucket, keep, in.DryRun)
+	if res != nil {
+		log.Printf("scanned %d models, archived %d (%d objects deleted, dry_run=%t): %v",
+			res.Scanned, len(res.Archived), res.ObjectsDeleted, res.DryRun, res.Archived)
+		m.Set("ModelsScanned", internal.UnitCount, float64(res.Scanned))
+		if !res.DryRun {
+			m.Set("ModelsArchived", internal.UnitCount, float64(len(res.Archived)))
+			m.Set("ObjectsDeleted", internal.UnitCount, float64(res.ObjectsDeleted))
+		}
+	}
+	m.Finish(err)
+	return res, err
+}
+
+func main() {
+	lambda.Start(handler)
+}
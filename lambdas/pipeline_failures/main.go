@@ -0,0 +1,106 @@
+package main
+
+import (
+	"aquawatch/internal"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// executionStatusChange is the detail of a Step Functions "Execution Status
+// Change" EventBridge event.
+type executionStatusChange struct {
+	ExecutionArn string `json:"executionArn"`
+	Status       string `json:"status"`
+	Error        string `json:"error"`
+	Cause        string `json:"cause"`
+}
+
+// envelope holds the fields used to tell the two event shapes apart.
+type envelope struct {
+	DetailType string            `json:"detail-type"`
+	Records    []json.RawMessage `json:"Records"`
+}
+
+// handler consumes either a Step Functions failure event from the
+// aquawatch-pipeline-failures EventBridge rule or a batch of messages from
+// the lambda dead-letter queue, and records each failure (which also alerts
+// operators).
+func handler(ctx context.Context, raw json.RawMessage) error {
+	log.Println("AquaWatch Pipeline Failures Lambda triggered")
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return fmt.Errorf("decode event: %w", err)
+	}
+	switch {
+	case env.DetailType != "":
+		var ev events.EventBridgeEvent
+		if err := json.Unmarshal(raw, &ev); err != nil {
+			return fmt.Errorf("decode eventbridge event: %w", err)
+		}
+		return handleExecutionEvent(ctx, ev)
+	case len(env.Records) > 0:
+		var ev events.SQSEvent
+		if err := json.Unmarshal(raw, &ev); err != nil {
+			return fmt.Errorf("decode sqs event: %w", err)
+		}
+		return handleDeadLetters(ctx, ev)
+	}
+	return fmt.Errorf("unrecognized event")
+}
+
+func handleExecutionEvent(ctx context.Context, ev events.EventBridgeEvent) error {
+	var detail executionStatusChange
+	if err := json.Unmarshal(ev.Detail, &detail); err != nil {
+		return fmt.Errorf("decode execution status change: %w", err)
+	}
+	return internal.RecordPipelineFailure(ctx, internal.PipelineError{
+		ErrorID:      ev.ID,
+		Source:       internal.PipelineErrorSourceStepFunctions,
+		ExecutionArn: detail.ExecutionArn,
+		Status:       detail.Status,
+		Error:        detail.Error,
+		Cause:        detail.Cause,
+	})
+}
+
+// handleDeadLetters records each message; Lambda sets ErrorCode and
+// ErrorMessage attributes on async invocations it dead-letters, and the body
+// is the original event.
+func handleDeadLetters(ctx context.Context, ev events.SQSEvent) error {
+	var failed int
+	for _, msg := range ev.Records {
+		err := internal.RecordPipelineFailure(ctx, internal.PipelineError{
+			ErrorID: msg.MessageId,
+			Source:  internal.PipelineErrorSourceDLQ,
+			Queue:   msg.EventSourceARN[strings.LastIndex(msg.EventSourceARN, ":")+1:],
+			Error:   attr(msg, "ErrorCode"),
+			Cause:   attr(msg, "ErrorMessage"),
+			Payload: msg.Body,
+		})
+		if err != nil {
+			log.Printf("record dead letter %s failed: %v", msg.MessageId, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dead letters not recorded", failed, len(ev.Records))
+	}
+	return nil
+}
+
+func attr(msg events.SQSMessage, name string) string {
+	if a, ok := msg.MessageAttributes[name]; ok && a.StringValue != nil {
+		return *a.StringValue
+	}
+	return ""
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -2,65 +2,203 @@ package main
 
 import (
 	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+	"aquawatch/internal/tracing"
+	"bytes"
+	"cmp"
 	"context"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 )
 
-// preprocessInput captures inputs passed by Step Functions. The handler fetches
-// raw USGS data for the station/parameter, converts to CSV features, and
-// appends to S3 at the provided processed key.
-type preprocessInput struct {
-	StationID    []string `json:"station"`
-	Parameter    string   `json:"parameter"`
-	Bucket       string   `json:"bucket"`
-	ProcessedKey string   `json:"processedKey"`
+// handler preprocesses the run's data and emits the step's metrics: rows
+// and bytes written, source payloads, and whether a fallback source was used.
+func handler(ctx context.Context, input pipeline.PreprocessInput) (pipeline.PreprocessOutput, error) {
+	ctx, seg := tracing.Start(ctx, "preprocess")
+	seg.AnnotateRun(cmp.Or(input.ExecutionArn, input.RunID), input.Station, input.ProcessedKey)
+	m := internal.NewStepMetrics(ctx, "preprocess", input.RunID).ForSites(input.Station...)
+	out, err := preprocess(ctx, input, m)
+	seg.Annotate("data_source", out.DataSource)
+	m.Finish(err)
+	seg.End(err)
+	return out, err
 }
 
-// handler downloads fresh data, transforms it, and appends to the dataset in S3.
-func handler(ctx context.Context, input preprocessInput) error {
+// preprocess downloads fresh USGS data for the stations/parameter, converts
+// it to CSV features, and appends them to the dataset identified by the
+// processed key as a new part named after the run.
+func preprocess(ctx context.Context, input pipeline.PreprocessInput, m *internal.StepMetrics) (pipeline.PreprocessOutput, error) {
 	log.Println("AquaWatch Preprocess Lambda triggered")
 
-	if input.Bucket == "" || len(input.StationID) == 0 || input.Parameter == "" || input.ProcessedKey == "" {
-		return fmt.Errorf("missing required fields: bucket, data")
+	if err := input.Validate(); err != nil {
+		return pipeline.PreprocessOutput{}, err
+	}
+	if input.DryRun {
+		return dryRun(ctx, input, m)
 	}
 
-	rawPayloads, err := internal.GetWaterDailyDataLast30DaysBatch(input.StationID, input.Parameter)
-	if err != nil {
-		// daily API can fail; fallback to instantaneous current data as a last resort
-		log.Printf("daily 30d fetch failed, fallback to iv: %v", err)
-		rawPayloads, err = internal.GetWaterDataBatch(input.StationID, input.Parameter)
+	source := internal.DataSourceDaily
+	var policy string
+	// A window of past days is a backfill: current instantaneous values,
+	// last good copies and synthetic series can't stand in for it, and its
+	// observations are too old to evaluate against the latest predictions.
+	from, to, windowed := pipeline.ParseWindow(input.Start, input.End)
+	fetchCtx, fetchSeg := tracing.Start(ctx, "fetch")
+	var rawPayloads [][]byte
+	var err error
+	if windowed {
+		m.Property("Window", input.Start+"/"+input.End)
+		rawPayloads, err = internal.GetWaterDailyDataRangeBatch(fetchCtx, input.Station, input.Parameter, from, to)
+	} else {
+		rawPayloads, err = internal.GetWaterDailyDataLast30DaysBatch(fetchCtx, input.Station, input.Parameter)
+		if err != nil {
+			// daily API can fail; fallback to instantaneous current data
+			log.Printf("daily 30d fetch failed, fallback to iv: %v", err)
+			m.Add("SourceFallbacks", internal.UnitCount, 1)
+			source = internal.DataSourceInstant
+			rawPayloads, err = internal.GetWaterDataBatch(fetchCtx, input.Station, input.Parameter)
+		}
+	}
+	fetchSeg.End(err)
+	if err != nil && (ctx.Err() != nil || windowed) {
+		// Out of time (or cancelled): not an upstream outage, so no fallback.
+		return pipeline.PreprocessOutput{}, err
+	}
+	if err == nil {
+		// Last good copies are of the default window.
+		if !windowed {
+			if serr := internal.SaveLastGoodPayloads(ctx, input.Bucket, input.Station, input.Parameter, rawPayloads); serr != nil {
+				log.Printf("saving last good payloads failed: %v", serr)
+			}
+		}
+	} else {
+		// Both feeds failed: the configured policy decides whether the run
+		// fails or continues on substitute data.
+		policy = internal.FallbackPolicy()
+		log.Printf("upstream fetch failed, applying fallback policy %s: %v", policy, err)
+		m.Add("SourceFallbacks", internal.UnitCount, 1)
+		m.Property("FallbackPolicy", policy)
+		rawPayloads, source, err = internal.ApplyFallbackPolicy(ctx, policy, input.Bucket, input.Station, input.Parameter, err)
 		if err != nil {
-			// get water data api is very flaky, so we'll use mock data as fallback
-			log.Printf("using mock data since get water data failed: %v", err)
-			rawPayloads = [][]byte{[]byte(`{"name":"ns1:timeSeriesResponseType","declaredType":"org.cuahsi.waterml.TimeSeriesResponseType","scope":"javax.xml.bind.JAXBElement$GlobalScope","value":{"queryInfo":{"queryURL":"http://waterservices.usgs.gov/nwis/iv/format=json&sites=03339000&parameterCd=00060","criteria":{"locationParam":"[ALL:03339000]","variableParam":"[00060]","parameter":[]},"note":[{"value":"[ALL:03339000]","title":"filter:sites"},{"value":"[mode=LATEST, modifiedSince=null]","title":"filter:timeRange"},{"value":"methodIds=[ALL]","title":"filter:methodId"},{"value":"2025-08-24T16:44:54.347Z","title":"requestDT"},{"value":"a94b52a0-8109-11f0-841b-2cea7f5e5ede","title":"requestId"},{"value":"Provisional data are subject to revision. Go to http://waterdata.usgs.gov/nwis/help/?provisional for more information.","title":"disclaimer"},{"value":"sdas01","title":"server"}]},"timeSeries":[{"sourceInfo":{"siteName":"VERMILION RIVER NEAR DANVILLE, IL","siteCode":[{"value":"03339000","network":"NWIS","agencyCode":"USGS"}],"timeZoneInfo":{"defaultTimeZone":{"zoneOffset":"-06:00","zoneAbbreviation":"CST"},"daylightSavingsTimeZone":{"zoneOffset":"-05:00","zoneAbbreviation":"CDT"},"siteUsesDaylightSavingsTime":false},"geoLocation":{"geogLocation":{"srs":"EPSG:4326","latitude":40.1010833,"longitude":-87.5976111},"localSiteXY":[]},"note":[],"siteType":[],"siteProperty":[{"value":"ST","name":"siteTypeCd"},{"value":"05120109","name":"hucCd"},{"value":"17","name":"stateCd"},{"value":"17183","name":"countyCd"}]},"variable":{"variableCode":[{"value":"00060","network":"NWIS","vocabulary":"NWIS:UnitValues","variableID":45807197,"default":true}],"variableName":"Streamflow, ft&#179;/s","variableDescription":"Discharge, cubic feet per second","valueType":"Derived Value","unit":{"unitCode":"ft3/s"},"options":{"option":[{"name":"Statistic","optionCode":"00000"}]},"note":[],"noDataValue":-999999.0,"variableProperty":[],"oid":"45807197"},"values":[{"value":[{"value":"72.3","qualifiers":["P"],"dateTime":"2025-08-24T10:15:00.000-06:00"}],"qualifier":[{"qualifierCode":"P","qualifierDescription":"Provisional data subject to revision.","qualifierID":0,"network":"NWIS","vocabulary":"uv_rmk_cd"}],"qualityControlLevel":[],"method":[{"methodDescription":"","methodID":49959}],"source":[],"offset":[],"sample":[],"censorCode":[]}],"name":"USGS:03339000:00060:00000"}]} ,"nil":false,"globalScope":true,"typeSubstituted":false}`)}
+			recordDataSource(ctx, input.ExecutionArn, "", policy)
+			return pipeline.PreprocessOutput{}, err
 		}
 	}
+	m.Property("DataSource", source)
+	recordDataSource(ctx, input.ExecutionArn, source, policy)
+	internal.RecordProgress(ctx, input.ExecutionArn, "Preprocess", internal.ProgressFetchDone, len(rawPayloads),
+		fmt.Sprintf("fetched %d payloads from %s", len(rawPayloads), source))
+
+	m.Set("SourcePayloads", internal.UnitCount, float64(len(rawPayloads)))
 
-	csvBytes, err := internal.PreprocessDataCSVBatch(ctx, rawPayloads)
+	// Best-effort: keep per-station statistics current. Synthetic series
+	// would skew them, so they're left out.
+	if source != internal.DataSourceSynthetic {
+		if err := internal.RecordStationStats(ctx, input.Parameter, rawPayloads); err != nil {
+			log.Printf("updating station stats failed: %v", err)
+		}
+	}
+
+	// Best-effort: compare the new observations with each station's latest
+	// prediction and alert within this run. Substitute data isn't observed,
+	// so it's never evaluated.
+	var evaluated, anomalies int
+	if internal.StreamEvaluationEnabled() && !windowed && (source == internal.DataSourceDaily || source == internal.DataSourceInstant) {
+		evals, err := internal.EvaluateOnIngest(ctx, input.Parameter, rawPayloads)
+		if err != nil {
+			log.Printf("stream evaluation failed: %v", err)
+		}
+		evaluated = len(evals)
+		for _, e := range evals {
+			if e.Anomalous {
+				anomalies++
+			}
+		}
+		m.Set("StreamEvaluations", internal.UnitCount, float64(evaluated))
+		m.Set("StreamAnomalies", internal.UnitCount, float64(anomalies))
+	}
+
+	featuresCtx, featuresSeg := tracing.Start(ctx, "features")
+	csvBytes, err := internal.PreprocessDataCSVBatch(featuresCtx, rawPayloads)
+	featuresSeg.End(err)
 	if err != nil {
-		return fmt.Errorf("preprocessing failed: %w", err)
+		return pipeline.PreprocessOutput{}, fmt.Errorf("preprocessing failed: %w", err)
 	}
+	rows := bytes.Count(csvBytes, []byte{'\n'})
+	m.Set("RowsProcessed", internal.UnitCount, float64(rows))
 
-	// Append to existing CSV if present; otherwise create it.
-	existing, readErr := internal.LoadFromS3(ctx, input.Bucket, input.ProcessedKey)
-	if readErr == nil && len(existing) > 0 {
-		if existing[len(existing)-1] != '\n' {
-			existing = append(existing, '\n')
+	// Each run writes its own immutable part and registers it in the dataset
+	// manifest, so concurrent executions cannot drop each other's rows.
+	runID := input.RunID
+	if runID == "" {
+		if lc, ok := lambdacontext.FromContext(ctx); ok {
+			runID = lc.AwsRequestID
 		}
-		existing = append(existing, csvBytes...)
-		csvBytes = existing
-	} else if readErr != nil {
-		log.Printf("no existing processed file or failed to read: %v; creating new", readErr)
 	}
+	partKey, err := internal.AppendDatasetPart(ctx, input.Bucket, input.ProcessedKey, runID, csvBytes, map[string]string{
+		internal.MetaSites:      strings.Join(input.Station, ","),
+		internal.MetaDataSource: source,
+	})
+	if err != nil {
+		return pipeline.PreprocessOutput{}, fmt.Errorf("failed to save processed data: %w", err)
+	}
+	log.Printf("appended %d bytes to dataset %s as %s", len(csvBytes), input.ProcessedKey, partKey)
+	m.Set("BytesWritten", internal.UnitBytes, float64(len(csvBytes)))
+	internal.RecordProgress(ctx, input.ExecutionArn, "Preprocess", internal.ProgressRowsWritten, rows,
+		fmt.Sprintf("wrote %d rows to %s", rows, partKey))
+
+	// Best-effort: make the dataset queryable from Athena right away
+	if internal.GlueRegistrationEnabled() {
+		if err := internal.RegisterProcessedDataset(ctx, input.Bucket, input.ProcessedKey); err != nil {
+			log.Printf("glue registration failed for %s: %v", input.ProcessedKey, err)
+		}
+	}
+
+	return pipeline.PreprocessOutput{
+		PartKey:        partKey,
+		Bytes:          len(csvBytes),
+		DataSource:     source,
+		FallbackPolicy: policy,
+		Evaluated:      evaluated,
+		Anomalies:      anomalies,
+	}, nil
+}
 
-	if err := internal.SaveToS3WithKey(ctx, csvBytes, input.Bucket, input.ProcessedKey); err != nil {
-		return fmt.Errorf("failed to save processed data: %w", err)
+// dryRun fetches and preprocesses the stations' data without storing
+// anything, and reports the rows on the run's pipeline-runs record and as
+// the output.
+func dryRun(ctx context.Context, input pipeline.PreprocessInput, m *internal.StepMetrics) (pipeline.PreprocessOutput, error) {
+	m.Property("DryRun", true)
+	report, err := internal.DryRunIngest(ctx, input.Station, input.Parameter, input.Start, input.End)
+	if err != nil {
+		return pipeline.PreprocessOutput{}, err
+	}
+	m.Set("SourcePayloads", internal.UnitCount, float64(len(report.Stations)))
+	m.Set("RowsProcessed", internal.UnitCount, float64(report.Rows))
+	log.Printf("dry run: %d rows (%d bytes) from %s", report.Rows, report.Bytes, report.DataSource)
+	if input.ExecutionArn != "" {
+		if err := internal.RecordPipelineRunDryRun(ctx, input.ExecutionArn, report); err != nil {
+			log.Printf("recording dry run report on %s failed: %v", input.ExecutionArn, err)
+		}
 	}
+	internal.RecordProgress(ctx, input.ExecutionArn, "Preprocess", internal.ProgressFetchDone, report.Rows,
+		fmt.Sprintf("dry run: %d rows from %s, nothing written", report.Rows, report.DataSource))
+	return pipeline.PreprocessOutput{DataSource: report.DataSource, Report: report}, nil
+}
 
-	return nil
+// recordDataSource notes the run's data source and applied fallback policy
+// on its pipeline-runs record (best-effort).
+func recordDataSource(ctx context.Context, executionArn, source, policy string) {
+	if executionArn == "" {
+		return
+	}
+	if err := internal.RecordPipelineRunDataSource(ctx, executionArn, source, policy); err != nil {
+		log.Printf("recording data source on %s failed: %v", executionArn, err)
+	}
 }
 
 func main() {
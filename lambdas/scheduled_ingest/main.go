@@ -0,0 +1,42 @@
+package main
+
+import (
+	"aquawatch/internal"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// scheduleEvent is the constant input set on each schedule's EventBridge
+// rule target.
+type scheduleEvent struct {
+	ScheduleID string `json:"schedule_id"`
+}
+
+// handler starts the ingest pipeline for the schedule that fired. A schedule
+// deleted after its rule fired is logged and ignored.
+func handler(ctx context.Context, in scheduleEvent) error {
+	log.Println("AquaWatch Scheduled Ingest Lambda triggered")
+	if in.ScheduleID == "" {
+		return fmt.Errorf("missing schedule_id")
+	}
+	execArn, err := internal.RunSchedule(ctx, in.ScheduleID)
+	if errors.Is(err, internal.ErrScheduleNotFound) {
+		log.Printf("schedule %s not found; ignoring", in.ScheduleID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("run schedule %s: %w", in.ScheduleID, err)
+	}
+	if execArn != "" {
+		log.Printf("schedule %s started execution %s", in.ScheduleID, execArn)
+	}
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// batchItemFailure and batchResponse are the partial batch response Lambda
+// expects from SQS consumers with ReportBatchItemFailures enabled: only the
+// listed messages are retried.
+type batchItemFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
+}
+
+type batchResponse struct {
+	BatchItemFailures []batchItemFailure `json:"batchItemFailures"`
+}
+
+// concurrency reads SITE_WORKER_CONCURRENCY (default 4): how many tasks of a
+// batch run at once.
+func concurrency() int {
+	if n, err := strconv.Atoi(os.Getenv("SITE_WORKER_CONCURRENCY")); err == nil && n > 0 {
+		return n
+	}
+	return 4
+}
+
+// handler runs the batch's site tasks with bounded concurrency and reports
+// the messages that failed so SQS retries only those. Malformed or invalid
+// tasks are logged and dropped rather than retried. Anomaly evaluations of
+// the batch are saved together (shards save their own) and anomalous sites
+// alerted in one message.
+func handler(ctx context.Context, ev events.SQSEvent) (batchResponse, error) {
+	log.Printf("AquaWatch Site Worker Lambda triggered with %d tasks", len(ev.Records))
+
+	var (
+		mu       sync.Mutex
+		resp     batchResponse
+		evals    []internal.AnomalyEvaluation
+		saved    []internal.AnomalyEvaluation
+		wg       sync.WaitGroup
+		slots    = make(chan struct{}, concurrency())
+		failItem = func(id string) {
+			mu.Lock()
+			resp.BatchItemFailures = append(resp.BatchItemFailures, batchItemFailure{ItemIdentifier: id})
+			mu.Unlock()
+		}
+	)
+	for _, msg := range ev.Records {
+		var task internal.SiteTask
+		if err := json.Unmarshal([]byte(msg.Body), &task); err != nil {
+			log.Printf("dropping malformed task %s: %v", msg.MessageId, err)
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(id string, task internal.SiteTask) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			m := internal.NewStepMetrics(ctx, "site_worker", "").ForSites(task.Sites...)
+			m.Property("Kind", task.Kind)
+			m.Property("BatchID", task.BatchID)
+			res, err := internal.RunSiteTask(ctx, task)
+			switch {
+			case errors.Is(err, pipeline.ErrInvalidInput):
+				log.Printf("dropping invalid task %s (batch %s): %v", id, task.BatchID, err)
+			case err != nil:
+				log.Printf("task %s (batch %s) failed: %v", id, task.BatchID, err)
+				failItem(id)
+			case task.Kind == internal.SiteTaskBackfill:
+				m.Set("BackfillRows", internal.UnitCount, float64(res.Rows))
+			case res.Evaluation != nil:
+				m.Set("SitesEvaluated", internal.UnitCount, 1)
+				m.Set("AnomaliesFound", internal.UnitCount, b2f(res.Evaluation.Anomalous))
+				m.Set("PercentChange", internal.UnitPercent, res.Evaluation.PercentChange)
+				mu.Lock()
+				evals = append(evals, *res.Evaluation)
+				mu.Unlock()
+			case task.Kind == internal.SiteTaskAnomalyShard:
+				anomalies := 0
+				for _, ev := range res.Evaluations {
+					anomalies += int(b2f(ev.Anomalous))
+				}
+				m.Set("SitesEvaluated", internal.UnitCount, float64(len(res.Evaluations)))
+				m.Set("AnomaliesFound", internal.UnitCount, float64(anomalies))
+				mu.Lock()
+				saved = append(saved, res.Evaluations...)
+				mu.Unlock()
+			case res.ExecutionArn != "":
+				log.Printf("task %s (batch %s) started %s", id, task.BatchID, res.ExecutionArn)
+				m.Set("ExecutionsStarted", internal.UnitCount, 1)
+				m.Property("ExecutionArn", res.ExecutionArn)
+			}
+			m.Finish(err)
+		}(msg.MessageId, task)
+	}
+	wg.Wait()
+
+	if err := internal.SaveAnomalyEvaluations(ctx, evals); err != nil {
+		log.Printf("failed to persist anomaly evaluations: %v", err)
+	}
+	if err := internal.PublishAnomalies(ctx, append(evals, saved...)); err != nil {
+		log.Printf("publish anomaly alert failed: %v", err)
+	}
+	return resp, nil
+}
+
+func b2f(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	lambda.Start(handler)
+}
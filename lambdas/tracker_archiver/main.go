@@ -0,0 +1,56 @@
+package main
+
+import (
+	"aquawatch/internal"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// archiverInput is the (optional) scheduled event payload.
+// within_hours: archive items whose TTL expires within this window (default 48)
+type archiverInput struct {
+	WithinHours int `json:"within_hours,omitempty"`
+}
+
+// handler copies soon-to-expire tracker items to S3 before DynamoDB TTL removes
+// them. Intended to run daily from an EventBridge schedule.
+func handler(ctx context.Context, in archiverInput) error {
+	log.Println("AquaWatch Tracker Archiver Lambda triggered")
+	bucket := os.Getenv("ARCHIVE_BUCKET")
+	if bucket == "" {
+		bucket = os.Getenv("S3_BUCKET")
+	}
+	if bucket == "" {
+		return fmt.Errorf("ARCHIVE_BUCKET or S3_BUCKET must be configured")
+	}
+	within := 48 * time.Hour
+	if in.WithinHours > 0 {
+		within = time.Duration(in.WithinHours) * time.Hour
+	}
+
+	tables := []internal.RetentionConfig{
+		internal.AlertTrackerRetention(),
+		internal.PredictionTrackerRetention(),
+		internal.TrainModelTrackerRetention(),
+	}
+	for _, t := range tables {
+		if t.TTL <= 0 {
+			continue
+		}
+		key, n, err := internal.ArchiveExpiringItems(ctx, t, bucket, within)
+		if err != nil {
+			return fmt.Errorf("archive %s: %w", t.Table, err)
+		}
+		log.Printf("archived %d items from %s to s3://%s/%s", n, t.Table, bucket, key)
+	}
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"aquawatch/internal"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// exportInput is the (optional) scheduled event payload.
+// days: number of whole UTC days before today to export (default 1, i.e. yesterday)
+type exportInput struct {
+	Days int `json:"days,omitempty"`
+}
+
+// handler exports alert and prediction history to partitioned Parquet in S3
+// for Athena/QuickSight. Intended to run daily from an EventBridge schedule so
+// each run covers the previous UTC day exactly once.
+func handler(ctx context.Context, in exportInput) error {
+	log.Println("AquaWatch Tracker Export Lambda triggered")
+	bucket := os.Getenv("ANALYTICS_BUCKET")
+	if bucket == "" {
+		bucket = os.Getenv("S3_BUCKET")
+	}
+	if bucket == "" {
+		return fmt.Errorf("ANALYTICS_BUCKET or S3_BUCKET must be configured")
+	}
+	days := 1
+	if in.Days > 0 {
+		days = in.Days
+	}
+	until := time.Now().UTC().Truncate(24 * time.Hour)
+	since := until.AddDate(0, 0, -days)
+
+	results, err := internal.ExportTrackerHistory(ctx, bucket, since, until)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		log.Printf("exported %d %s rows to %d objects in s3://%s", r.Rows, r.Dataset, len(r.Keys), bucket)
+	}
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
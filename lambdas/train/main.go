@@ -0,0 +1,94 @@
+package main
+
+import (
+	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+	"cmp"
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// handler runs the action and emits the step's metrics: jobs started, and
+// for finished jobs their outcome and billable time.
+func handler(ctx context.Context, in pipeline.TrainInput) (pipeline.TrainOutput, error) {
+	m := internal.NewStepMetrics(ctx, "train", in.RunID).ForSites(in.Sites...)
+	out, err := train(ctx, in, m)
+	if out.TrainingJobName != "" {
+		m.Property("TrainingJob", out.TrainingJobName)
+	}
+	switch out.TrainingJobStatus {
+	case internal.TrainingJobCompleted:
+		m.Set("TrainingJobsCompleted", internal.UnitCount, 1)
+	case internal.TrainingJobFailed, internal.TrainingJobStopped:
+		m.Set("TrainingJobsFailed", internal.UnitCount, 1)
+	}
+	m.Finish(err)
+	return out, err
+}
+
+// train starts a training job ("start") or reports its progress ("status").
+// The state machine polls status until the job is Completed, Failed or
+// Stopped, then records the model with RecordTrainModel.
+func train(ctx context.Context, in pipeline.TrainInput, m *internal.StepMetrics) (pipeline.TrainOutput, error) {
+	log.Printf("AquaWatch Train Lambda triggered (%s)", in.Action)
+	var out pipeline.TrainOutput
+	if err := in.Validate(); err != nil {
+		return out, err
+	}
+	name := in.TrainingJobName
+	if in.Action == pipeline.TrainActionStart {
+		cfg, err := internal.TrainingConfigFromEnv(cmp.Or(in.Parameter, internal.ParameterDischarge))
+		if err != nil {
+			return out, err
+		}
+		name = internal.TrainingJobName(in.RunID)
+		manifestKey := in.ManifestKey
+		if in.ProcessedKey != "" {
+			// Train from a frozen copy so the registry can name the exact parts
+			if manifestKey, err = internal.SnapshotDatasetManifest(ctx, in.Bucket, in.ProcessedKey, name); err != nil {
+				return out, err
+			}
+		}
+		tags := map[string]string{"aquawatch:run": in.RunID, "aquawatch:parameter": in.Parameter}
+		err = internal.CreateTrainingJob(ctx, cfg, name, in.Bucket, manifestKey, in.ModelOutputPath, tags)
+		if errors.Is(err, internal.ErrTrainingJobExists) {
+			// Retried invocation: the job was created by the first attempt
+			log.Printf("training job %s already exists", name)
+		} else if err != nil {
+			return out, err
+		} else {
+			m.Set("TrainingJobsStarted", internal.UnitCount, 1)
+			internal.RecordProgress(ctx, in.ExecutionArn, "Train", internal.ProgressTrainingStarted, 0, "started training job "+name)
+		}
+	}
+
+	job, err := internal.DescribeTrainingJob(ctx, name)
+	if err != nil {
+		return out, err
+	}
+	log.Printf("training job %s: %s", job.Name, job.Status)
+	out.TrainingJobName = job.Name
+	out.TrainingJobStatus = job.Status
+	out.ModelArtifacts.S3ModelArtifacts = job.ModelArtifact
+	out.FailureReason = job.FailureReason
+	switch job.Status {
+	case internal.TrainingJobCompleted, internal.TrainingJobFailed, internal.TrainingJobStopped:
+		msg := "training job " + job.Name + " " + strings.ToLower(job.Status)
+		if job.FailureReason != "" {
+			msg += ": " + job.FailureReason
+		}
+		internal.RecordProgress(ctx, in.ExecutionArn, "CheckTraining", internal.ProgressTrainingFinished, 0, msg)
+	}
+	if job.BillableSecs > 0 {
+		m.Set("BillableTrainingTime", internal.UnitSeconds, float64(job.BillableSecs))
+	}
+	return out, nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"aquawatch/internal"
+	"aquawatch/internal/pipeline"
+	"aquawatch/internal/tracing"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -10,28 +13,56 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 )
 
-// input expected from Step Functions or direct invocation
-// uuid: unique training job identifier (e.g., training job name)
-// createdon: optional epoch millis override; defaults to now
-// sites: optional list of sites used for training
-type trackerInput struct {
-	CreatedOn int64    `json:"createdon,omitempty"`
-	Sites     []string `json:"sites,omitempty"`
+// handler records the training run and emits the step's metrics.
+func handler(ctx context.Context, in pipeline.TrainTrackerInput) error {
+	ctx, seg := tracing.Start(ctx, "record_train_model")
+	seg.AnnotateRun("", in.Sites, "")
+	seg.Annotate("training_job", in.TrainingJobName)
+	m := internal.NewStepMetrics(ctx, "record_train_model", "").ForSites(in.Sites...)
+	m.Property("TrainingJob", in.TrainingJobName)
+	err := record(ctx, in, m)
+	m.Finish(err)
+	seg.End(err)
+	return err
 }
 
-func handler(ctx context.Context, in trackerInput) error {
+// record records a completed training run in the train-model tracker.
+func record(ctx context.Context, in pipeline.TrainTrackerInput, m *internal.StepMetrics) error {
 	log.Println("AquaWatch Train Model Tracker Lambda triggered")
+	if err := in.Validate(); err != nil {
+		return err
+	}
 	if in.CreatedOn == 0 {
 		in.CreatedOn = time.Now().UTC().UnixMilli()
 	}
 	item := internal.TrainModelTrackerItem{
-		UUID:      fmt.Sprintf("train-%d", time.Now().UTC().UnixMilli()),
-		CreatedOn: in.CreatedOn,
-		Sites:     in.Sites,
+		UUID:          in.TrainingJobName,
+		CreatedOn:     in.CreatedOn,
+		Sites:         in.Sites,
+		Parameter:     in.Parameter,
+		ModelArtifact: in.ModelArtifact,
+	}
+	if item.UUID == "" {
+		item.UUID = fmt.Sprintf("train-%d", time.Now().UTC().UnixMilli())
+	}
+	if in.Bucket != "" && in.ProcessedKey != "" {
+		// Best-effort: the model is recorded even if its dataset can't be described
+		ds, err := internal.DescribeTrainingDataset(ctx, in.Bucket, in.ProcessedKey, item.UUID)
+		if err != nil {
+			log.Printf("describe training dataset of %s failed: %v", item.UUID, err)
+		} else {
+			item.Dataset = ds
+		}
 	}
 	if err := internal.SaveTrainModelTrackerItem(ctx, item); err != nil {
+		if errors.Is(err, internal.ErrAlreadyExists) {
+			// Retried invocation already recorded this job
+			log.Printf("train model tracker item %s already recorded", item.UUID)
+			return nil
+		}
 		return fmt.Errorf("failed to save train model tracker item: %w", err)
 	}
+	m.Set("ModelsRecorded", internal.UnitCount, 1)
 	return nil
 }
 
@@ -1,7 +1,8 @@
 #!/usr/bin/env bash
 # AquaWatch deploy script
-# - Builds and deploys Lambda functions (preprocess, infer)
-# - Creates/updates the Step Functions state machine from infra/state_machine/aquawatch.json
+# - Builds and deploys Lambda functions (preprocess, infer, train, ...)
+# - Creates/updates the Step Functions state machines from infra/state_machine/aquawatch.json
+#   (standard) and infra/state_machine/aquawatch_express.json (express)
 # - Substitutes REAL_ACCOUNT_ID and REAL_AWS_REGION in the definition
 # Requirements: awscli v2, permissions for IAM/Lambda/StepFunctions
 set -euo pipefail
@@ -18,8 +19,17 @@ fi
 # AWS region (defaults to us-west-2)
 AWS_REGION="${AWS_REGION:-us-west-2}"
 
+# Resource naming: every default name below (tables, topics, queues, roles,
+# functions, state machines, rules, Glue database) becomes
+# ${RESOURCE_PREFIX}<name>${RESOURCE_SUFFIX}, so several stacks can share one
+# AWS account (e.g. RESOURCE_SUFFIX=-stage). The lambdas get both settings and
+# resolve table and topic names the same way; set them on the API server too.
+RESOURCE_PREFIX="${RESOURCE_PREFIX:-}"
+RESOURCE_SUFFIX="${RESOURCE_SUFFIX:-}"
+res() { printf '%s%s%s' "$RESOURCE_PREFIX" "$1" "$RESOURCE_SUFFIX"; }
+
 # Lambda execution role (created if missing)
-LAMBDA_ROLE_NAME="${LAMBDA_ROLE_NAME:-aquawatch-lambda-role}"
+LAMBDA_ROLE_NAME="${LAMBDA_ROLE_NAME:-$(res aquawatch-lambda-role)}"
 
 # S3 bucket used by preprocess/infer (required)
 S3_BUCKET="${S3_BUCKET}"
@@ -35,8 +45,14 @@ if [ -z "$SAGEMAKER_ENDPOINT" ]; then
   exit 1
 fi
 
+# SageMaker training (train lambda): execution role, instance and spot capacity
+TRAINING_ROLE_ARN="${TRAINING_ROLE_ARN:-arn:aws:iam::${ACCOUNT_ID}:role/aquawatch-sagemaker-exec-role}"
+TRAINING_INSTANCE_TYPE="${TRAINING_INSTANCE_TYPE:-ml.c4.xlarge}"
+TRAINING_SPOT="${TRAINING_SPOT:-true}"
+
 # Step Functions names/roles
-STATE_MACHINE_NAME="${STATE_MACHINE_NAME:-aquawatch-pipeline}"
+STATE_MACHINE_NAME="${STATE_MACHINE_NAME:-$(res aquawatch-pipeline)}"
+EXPRESS_STATE_MACHINE_NAME="${EXPRESS_STATE_MACHINE_NAME:-$(res aquawatch-pipeline-express)}"
 SFN_ROLE_ARN="${SFN_ROLE_ARN:-arn:aws:iam::${ACCOUNT_ID}:role/service-role/StepFunctions-aquawatch-role-2sur8cc9m}"
 
 # Architecture: arm64 or x86_64
@@ -44,12 +60,60 @@ ARCH="${ARCH:-amd64}"
 GOARCH="amd64"; [[ "$ARCH" == "arm64" ]] && GOARCH="arm64"
 
 # Function names
-PREPROCESS_FN="${PREPROCESS_FN:-aquawatch-preprocess}"
-INFER_FN="${INFER_FN:-aquawatch-infer}"
-TRAIN_TRACKER_FN="${TRAIN_TRACKER_FN:-aquawatch-train-tracker}"
+PREPROCESS_FN="${PREPROCESS_FN:-$(res aquawatch-preprocess)}"
+INFER_FN="${INFER_FN:-$(res aquawatch-infer)}"
+TRAIN_TRACKER_FN="${TRAIN_TRACKER_FN:-$(res aquawatch-train-tracker)}"
+TRAIN_FN="${TRAIN_FN:-$(res aquawatch-train)}"
+MODEL_CLEANUP_FN="${MODEL_CLEANUP_FN:-$(res aquawatch-model-cleanup)}"
+ARCHIVER_FN="${ARCHIVER_FN:-$(res aquawatch-tracker-archiver)}"
+EXPORT_FN="${EXPORT_FN:-$(res aquawatch-tracker-export)}"
+SCHEDULED_INGEST_FN="${SCHEDULED_INGEST_FN:-$(res aquawatch-scheduled-ingest)}"
+PIPELINE_FAILURES_FN="${PIPELINE_FAILURES_FN:-$(res aquawatch-pipeline-failures)}"
+SITE_WORKER_FN="${SITE_WORKER_FN:-$(res aquawatch-site-worker)}"
+METRICS_ROLLUP_FN="${METRICS_ROLLUP_FN:-$(res aquawatch-metrics-rollup)}"
+
+# X-Ray tracing of the preprocess, infer and train tracker lambdas:
+# Active | PassThrough
+LAMBDA_TRACING_MODE="${LAMBDA_TRACING_MODE:-Active}"
+
+# Queue of per-site anomaly/ingest tasks and the worker's concurrency caps
+SITE_TASK_QUEUE_NAME="${SITE_TASK_QUEUE_NAME:-$(res aquawatch-site-tasks)}"
+SITE_WORKER_MAX_CONCURRENCY="${SITE_WORKER_MAX_CONCURRENCY:-5}"
+SITE_WORKER_CONCURRENCY="${SITE_WORKER_CONCURRENCY:-4}"
+# Sites checked at once within one shard of a sharded sweep (SWEEP_SHARDS on
+# the API server turns sharding on)
+SWEEP_SHARD_CONCURRENCY="${SWEEP_SHARD_CONCURRENCY:-8}"
+
+# Completion callbacks to the API (optional): EventBridge posts finished
+# executions to ${PIPELINE_CALLBACK_URL} with X-Callback-Secret
+PIPELINE_CALLBACK_URL="${PIPELINE_CALLBACK_URL:-}"
+PIPELINE_CALLBACK_SECRET="${PIPELINE_CALLBACK_SECRET:-}"
+
+# Dead-letter queue for async lambda invocations, drained by the failures lambda
+LAMBDA_DLQ_NAME="${LAMBDA_DLQ_NAME:-$(res aquawatch-lambda-dlq)}"
+
+# What preprocess does when both USGS feeds fail: fail | last_good | synthetic
+INGEST_FALLBACK_POLICY="${INGEST_FALLBACK_POLICY:-fail}"
+
+# Where stations' water data comes from: usgs | synthetic (generated series for demos and load tests)
+WATER_DATA_PROVIDER="${WATER_DATA_PROVIDER:-usgs}"
+
+# Compare new observations with the latest predictions during preprocess
+STREAM_EVALUATION_ENABLED="${STREAM_EVALUATION_ENABLED:-false}"
+
+# Minutes an anomaly keeps a basin's correlated event open (0 alerts every station on its own)
+CORRELATION_WINDOW_MINUTES="${CORRELATION_WINDOW_MINUTES:-60}"
+
+# Glue Data Catalog registration of processed datasets/exports (optional)
+GLUE_REGISTRATION_ENABLED="${GLUE_REGISTRATION_ENABLED:-false}"
+# (hyphens become underscores, as Athena requires)
+GLUE_DATABASE="${GLUE_DATABASE:-$(res aquawatch | tr - _)}"
 
 # SNS topic name for alerts
-SNS_TOPIC_NAME="${SNS_TOPIC_NAME:-aquawatch-alerts}"
+SNS_TOPIC_NAME="${SNS_TOPIC_NAME:-$(res aquawatch-alerts)}"
+
+# SNS topic for operator alerts (pipeline failures)
+OPERATOR_SNS_TOPIC_NAME="${OPERATOR_SNS_TOPIC_NAME:-$(res aquawatch-operators)}"
 
 # -------------------- Bootstrap --------------------
 
@@ -97,12 +161,21 @@ create_or_get_role() {
       \"Statement\": [
         {
           \"Effect\": \"Allow\",
-          \"Action\": [\"s3:GetObject\",\"s3:PutObject\",\"s3:ListBucket\"],
+          \"Action\": [\"s3:GetObject\",\"s3:PutObject\",\"s3:PutObjectTagging\",\"s3:ListBucket\",\"s3:DeleteObject\"],
           \"Resource\": [
             \"arn:aws:s3:::${S3_BUCKET}\",
             \"arn:aws:s3:::${S3_BUCKET}/*\"
           ]
         },
+        {
+          \"Effect\": \"Allow\",
+          \"Action\": [\"glue:CreateTable\",\"glue:UpdateTable\",\"glue:GetTable\",\"glue:CreatePartition\"],
+          \"Resource\": [
+            \"arn:aws:glue:${AWS_REGION}:${ACCOUNT_ID}:catalog\",
+            \"arn:aws:glue:${AWS_REGION}:${ACCOUNT_ID}:database/${GLUE_DATABASE}\",
+            \"arn:aws:glue:${AWS_REGION}:${ACCOUNT_ID}:table/${GLUE_DATABASE}/*\"
+          ]
+        },
         {
           \"Effect\": \"Allow\",
           \"Action\": [\"sagemaker:InvokeEndpoint\"],
@@ -110,14 +183,74 @@ create_or_get_role() {
         },
         {
           \"Effect\": \"Allow\",
-          \"Action\": [\"dynamodb:PutItem\",\"dynamodb:GetItem\",\"dynamodb:Query\"],
+          \"Action\": [\"sagemaker:CreateTrainingJob\",\"sagemaker:DescribeTrainingJob\",\"sagemaker:AddTags\"],
+          \"Resource\": \"arn:aws:sagemaker:${AWS_REGION}:${ACCOUNT_ID}:training-job/aquawatch-*\"
+        },
+        {
+          \"Effect\": \"Allow\",
+          \"Action\": [\"iam:PassRole\"],
+          \"Resource\": \"${TRAINING_ROLE_ARN}\",
+          \"Condition\": {\"StringEquals\": {\"iam:PassedToService\": \"sagemaker.amazonaws.com\"}}
+        },
+        {
+          \"Effect\": \"Allow\",
+          \"Action\": [\"states:StartExecution\"],
+          \"Resource\": \"arn:aws:states:${AWS_REGION}:${ACCOUNT_ID}:stateMachine:${STATE_MACHINE_NAME}\"
+        },
+        {
+          \"Effect\": \"Allow\",
+          \"Action\": [\"states:DescribeExecution\",\"states:GetExecutionHistory\"],
+          \"Resource\": \"arn:aws:states:${AWS_REGION}:${ACCOUNT_ID}:execution:${STATE_MACHINE_NAME}:*\"
+        },
+        {
+          \"Effect\": \"Allow\",
+          \"Action\": [\"sqs:SendMessage\",\"sqs:ReceiveMessage\",\"sqs:DeleteMessage\",\"sqs:GetQueueAttributes\"],
+          \"Resource\": [
+            \"arn:aws:sqs:${AWS_REGION}:${ACCOUNT_ID}:${LAMBDA_DLQ_NAME}\",
+            \"arn:aws:sqs:${AWS_REGION}:${ACCOUNT_ID}:${SITE_TASK_QUEUE_NAME}\"
+          ]
+        },
+        {
+          \"Effect\": \"Allow\",
+          \"Action\": [\"xray:PutTraceSegments\",\"xray:PutTelemetryRecords\"],
+          \"Resource\": \"*\"
+        },
+        {
+          \"Effect\": \"Allow\",
+          \"Action\": [\"sns:CreateTopic\",\"sns:Publish\"],
           \"Resource\": [
-            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/alert-tracker\",
-            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/alert-tracker/index/*\",
-            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/prediction-tracker\",
-            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/prediction-tracker/index/*\",
-            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/train-model-tracker\",
-            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/train-model-tracker/index/*\"
+            \"arn:aws:sns:${AWS_REGION}:${ACCOUNT_ID}:${OPERATOR_SNS_TOPIC_NAME}\",
+            \"arn:aws:sns:${AWS_REGION}:${ACCOUNT_ID}:${SNS_TOPIC_NAME}\"
+          ]
+        },
+        {
+          \"Effect\": \"Allow\",
+          \"Action\": [\"dynamodb:PutItem\",\"dynamodb:GetItem\",\"dynamodb:Query\",\"dynamodb:Scan\",\"dynamodb:BatchWriteItem\",\"dynamodb:UpdateItem\",\"dynamodb:ConditionCheckItem\"],
+          \"Resource\": [
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}alert-tracker${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}alert-tracker${RESOURCE_SUFFIX}/index/*\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}prediction-tracker${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}prediction-tracker${RESOURCE_SUFFIX}/index/*\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}train-model-tracker${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}train-model-tracker${RESOURCE_SUFFIX}/index/*\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}predictions${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}anomaly-evaluations${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}pipeline-runs${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}pipeline-events${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}schedules${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}backfills${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}sweeps${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}station-stats${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}metrics-rollups${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}api-usage${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}site-impacts${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}basins${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}watchlist${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}watchlist-webhooks${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}webhook-deliveries${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}correlated-events${RESOURCE_SUFFIX}\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}correlated-events${RESOURCE_SUFFIX}/index/*\",
+            \"arn:aws:dynamodb:${AWS_REGION}:${ACCOUNT_ID}:table/${RESOURCE_PREFIX}pipeline-errors${RESOURCE_SUFFIX}\"
           ]
         }
       ]
@@ -167,32 +300,219 @@ upsert_lambda() {
   fi
 }
 
+# set_env <function> <vars>: replaces the function's environment with vars
+# plus the resource naming.
 set_env() {
   local fn_name="$1"; shift
+  local vars="$*"
+  [[ -n "$RESOURCE_PREFIX" ]] && vars="${vars:+$vars,}RESOURCE_PREFIX=$RESOURCE_PREFIX"
+  [[ -n "$RESOURCE_SUFFIX" ]] && vars="${vars:+$vars,}RESOURCE_SUFFIX=$RESOURCE_SUFFIX"
   aws lambda update-function-configuration \
     --function-name "$fn_name" \
-    --environment "Variables={$*}" >/dev/null
+    --environment "Variables={$vars}" >/dev/null
+}
+
+# Turn on X-Ray tracing for the lambdas that record pipeline subsegments.
+ensure_lambda_tracing() {
+  local fn
+  for fn in "$PREPROCESS_FN" "$INFER_FN" "$TRAIN_TRACKER_FN"; do
+    aws lambda update-function-configuration \
+      --function-name "$fn" \
+      --tracing-config "Mode=$LAMBDA_TRACING_MODE" >/dev/null
+    aws lambda wait function-updated --function-name "$fn"
+  done
+}
+
+# Allow EventBridge schedule rules (aquawatch-schedule-*) to invoke the
+# scheduled-ingest lambda.
+ensure_schedule_invoke_permission() {
+  aws lambda add-permission \
+    --function-name "$SCHEDULED_INGEST_FN" \
+    --statement-id aquawatch-schedules \
+    --action lambda:InvokeFunction \
+    --principal events.amazonaws.com \
+    --source-arn "arn:aws:events:${AWS_REGION}:${ACCOUNT_ID}:rule/aquawatch-schedule-*" >/dev/null 2>&1 || true
+}
+
+# -------------------- Failure handling --------------------
+
+ensure_lambda_dlq() {
+  local url
+  url=$(aws sqs create-queue --queue-name "$LAMBDA_DLQ_NAME" \
+    --attributes MessageRetentionPeriod=1209600 --query 'QueueUrl' --output text)
+  aws sqs get-queue-attributes --queue-url "$url" --attribute-names QueueArn --query 'Attributes.QueueArn' --output text
+}
+
+# Send failed async invocations of the given functions to the DLQ.
+set_dead_letter_queue() {
+  local dlq_arn="$1"; shift
+  local fn
+  for fn in "$@"; do
+    aws lambda update-function-configuration \
+      --function-name "$fn" \
+      --dead-letter-config "TargetArn=$dlq_arn" >/dev/null
+    sleep 5
+  done
+}
+
+# Run the metrics rollup lambda nightly, once the UTC day is over.
+ensure_metrics_rollup_schedule() {
+  local fn_arn rule_arn
+  fn_arn=$(aws lambda get-function --function-name "$METRICS_ROLLUP_FN" --query 'Configuration.FunctionArn' --output text)
+  rule_arn=$(aws events put-rule \
+    --name "$(res aquawatch-metrics-rollup)" \
+    --schedule-expression "cron(30 0 * * ? *)" \
+    --query 'RuleArn' --output text)
+  aws events put-targets --rule "$(res aquawatch-metrics-rollup)" --targets "Id=rollup,Arn=$fn_arn" >/dev/null
+  aws lambda add-permission \
+    --function-name "$METRICS_ROLLUP_FN" \
+    --statement-id aquawatch-metrics-rollup \
+    --action lambda:InvokeFunction \
+    --principal events.amazonaws.com \
+    --source-arn "$rule_arn" >/dev/null 2>&1 || true
+}
+
+# Route failed/timed-out/aborted executions and dead-lettered invocations to
+# the failures lambda.
+ensure_failure_routing() {
+  local dlq_arn="$1" fn_arn rule_arn
+  fn_arn=$(aws lambda get-function --function-name "$PIPELINE_FAILURES_FN" --query 'Configuration.FunctionArn' --output text)
+
+  rule_arn=$(aws events put-rule \
+    --name "$(res aquawatch-pipeline-failures)" \
+    --event-pattern "{
+      \"source\": [\"aws.states\"],
+      \"detail-type\": [\"Step Functions Execution Status Change\"],
+      \"detail\": {
+        \"status\": [\"FAILED\", \"TIMED_OUT\", \"ABORTED\"],
+        \"stateMachineArn\": [\"arn:aws:states:${AWS_REGION}:${ACCOUNT_ID}:stateMachine:${STATE_MACHINE_NAME}\"]
+      }
+    }" --query 'RuleArn' --output text)
+  aws events put-targets --rule "$(res aquawatch-pipeline-failures)" --targets "Id=failures,Arn=$fn_arn" >/dev/null
+  aws lambda add-permission \
+    --function-name "$PIPELINE_FAILURES_FN" \
+    --statement-id aquawatch-pipeline-failures \
+    --action lambda:InvokeFunction \
+    --principal events.amazonaws.com \
+    --source-arn "$rule_arn" >/dev/null 2>&1 || true
+
+  if [[ -z "$(aws lambda list-event-source-mappings --function-name "$PIPELINE_FAILURES_FN" --event-source-arn "$dlq_arn" --query 'EventSourceMappings[0].UUID' --output text | grep -v None)" ]]; then
+    aws lambda create-event-source-mapping \
+      --function-name "$PIPELINE_FAILURES_FN" \
+      --event-source-arn "$dlq_arn" \
+      --batch-size 10 >/dev/null
+  fi
+}
+
+# Post finished executions of the standard state machine to the API's
+# /pipeline/callback through an EventBridge API destination.
+ensure_pipeline_callback() {
+  if [[ -z "$PIPELINE_CALLBACK_URL" || -z "$PIPELINE_CALLBACK_SECRET" ]]; then
+    echo "PIPELINE_CALLBACK_URL/PIPELINE_CALLBACK_SECRET not set; skipping completion callbacks."
+    return
+  fi
+  local role conn dest role_arn conn_arn dest_arn
+  role="$(res aquawatch-events-api-destination)"
+  conn="$(res aquawatch-api)"
+  dest="$(res aquawatch-pipeline-callback)"
+  local auth="{\"ApiKeyAuthParameters\":{\"ApiKeyName\":\"X-Callback-Secret\",\"ApiKeyValue\":\"${PIPELINE_CALLBACK_SECRET}\"}}"
+
+  conn_arn=$(aws events describe-connection --name "$conn" --query 'ConnectionArn' --output text 2>/dev/null || true)
+  if [[ -z "$conn_arn" ]]; then
+    conn_arn=$(aws events create-connection --name "$conn" --authorization-type API_KEY \
+      --auth-parameters "$auth" --query 'ConnectionArn' --output text)
+  else
+    aws events update-connection --name "$conn" --authorization-type API_KEY --auth-parameters "$auth" >/dev/null
+  fi
+
+  dest_arn=$(aws events describe-api-destination --name "$dest" --query 'ApiDestinationArn' --output text 2>/dev/null || true)
+  if [[ -z "$dest_arn" ]]; then
+    dest_arn=$(aws events create-api-destination --name "$dest" \
+      --connection-arn "$conn_arn" --invocation-endpoint "$PIPELINE_CALLBACK_URL" --http-method POST \
+      --query 'ApiDestinationArn' --output text)
+  else
+    aws events update-api-destination --name "$dest" \
+      --connection-arn "$conn_arn" --invocation-endpoint "$PIPELINE_CALLBACK_URL" --http-method POST >/dev/null
+  fi
+
+  role_arn=$(aws iam get-role --role-name "$role" --query 'Role.Arn' --output text 2>/dev/null || true)
+  if [[ -z "$role_arn" ]]; then
+    role_arn=$(aws iam create-role --role-name "$role" --assume-role-policy-document '{
+      "Version":"2012-10-17",
+      "Statement":[{"Effect":"Allow","Principal":{"Service":"events.amazonaws.com"},"Action":"sts:AssumeRole"}]
+    }' --query 'Role.Arn' --output text)
+    sleep 8
+  fi
+  aws iam put-role-policy --role-name "$role" --policy-name aquawatch-api-destination --policy-document "{
+    \"Version\": \"2012-10-17\",
+    \"Statement\": [{\"Effect\": \"Allow\", \"Action\": \"events:InvokeApiDestination\", \"Resource\": \"${dest_arn}\"}]
+  }"
+
+  aws events put-rule \
+    --name "$dest" \
+    --event-pattern "{
+      \"source\": [\"aws.states\"],
+      \"detail-type\": [\"Step Functions Execution Status Change\"],
+      \"detail\": {
+        \"status\": [\"SUCCEEDED\", \"FAILED\", \"TIMED_OUT\", \"ABORTED\"],
+        \"stateMachineArn\": [\"arn:aws:states:${AWS_REGION}:${ACCOUNT_ID}:stateMachine:${STATE_MACHINE_NAME}\"]
+      }
+    }" >/dev/null
+  aws events put-targets --rule "$dest" \
+    --targets "Id=api,Arn=${dest_arn},RoleArn=${role_arn}" >/dev/null
+}
+
+# -------------------- Site task queue --------------------
+
+# Create the site task queue with redrive to the DLQ after 3 receives; the
+# visibility timeout covers the worker's 60s timeout with room for retries.
+ensure_site_task_queue() {
+  local dlq_arn="$1" url
+  url=$(aws sqs create-queue --queue-name "$SITE_TASK_QUEUE_NAME" --query 'QueueUrl' --output text)
+  aws sqs set-queue-attributes --queue-url "$url" --attributes "{
+    \"VisibilityTimeout\": \"360\",
+    \"RedrivePolicy\": \"{\\\"deadLetterTargetArn\\\":\\\"${dlq_arn}\\\",\\\"maxReceiveCount\\\":\\\"3\\\"}\"
+  }" >/dev/null
+  echo "$url"
+}
+
+# Feed the site task queue to the worker with partial batch responses and a
+# cap on concurrent invocations.
+ensure_site_worker_mapping() {
+  local queue_url="$1" queue_arn
+  queue_arn=$(aws sqs get-queue-attributes --queue-url "$queue_url" --attribute-names QueueArn --query 'Attributes.QueueArn' --output text)
+  if [[ -z "$(aws lambda list-event-source-mappings --function-name "$SITE_WORKER_FN" --event-source-arn "$queue_arn" --query 'EventSourceMappings[0].UUID' --output text | grep -v None)" ]]; then
+    aws lambda create-event-source-mapping \
+      --function-name "$SITE_WORKER_FN" \
+      --event-source-arn "$queue_arn" \
+      --batch-size 10 \
+      --function-response-types ReportBatchItemFailures \
+      --scaling-config "MaximumConcurrency=$SITE_WORKER_MAX_CONCURRENCY" >/dev/null
+  fi
 }
 
 # -------------------- Step Functions --------------------
 
+# upsert_state_machine <name> <definition file> [STANDARD|EXPRESS]
 upsert_state_machine() {
-  local rendered="/tmp/aquawatch_state_machine.json"
+  local name="$1" definition="$2" type="${3:-STANDARD}"
+  local rendered="/tmp/${name}.json"
   # Replace placeholders for account id and region within the definition
   sed -e "s#REAL_ACCOUNT_ID#${ACCOUNT_ID}#g" \
       -e "s#REAL_AWS_REGION#${AWS_REGION}#g" \
-      "$REPO_ROOT/infra/state_machine/aquawatch.json" > "$rendered"
+      "$definition" > "$rendered"
 
   local existing
-  existing=$(aws stepfunctions list-state-machines --query "stateMachines[?name=='$STATE_MACHINE_NAME'].stateMachineArn | [0]" --output text)
+  existing=$(aws stepfunctions list-state-machines --query "stateMachines[?name=='$name'].stateMachineArn | [0]" --output text)
   if [[ -z "$existing" || "$existing" == "None" ]]; then
-    echo "Creating Step Functions state machine $STATE_MACHINE_NAME ..."
+    echo "Creating Step Functions state machine $name ($type) ..."
     aws stepfunctions create-state-machine \
-      --name "$STATE_MACHINE_NAME" \
+      --name "$name" \
+      --type "$type" \
       --definition "file://$rendered" \
       --role-arn "$SFN_ROLE_ARN" >/dev/null
   else
-    echo "Updating Step Functions state machine $STATE_MACHINE_NAME ..."
+    echo "Updating Step Functions state machine $name ..."
     aws stepfunctions update-state-machine \
       --state-machine-arn "$existing" \
       --definition "file://$rendered" >/dev/null
@@ -202,9 +522,31 @@ upsert_state_machine() {
 # -------------------- DynamoDB --------------------
 
 ensure_prediction_tracker_table() {
-  local table="prediction-tracker"
+  local table
+  table="$(res prediction-tracker)"
   if aws dynamodb describe-table --table-name "$table" >/dev/null 2>&1; then
     echo "DynamoDB table $table already exists."
+    # Ensure GSI for latest status per site exists (gsi_site_updated on site(updatedon))
+    local gsi
+    gsi=$(aws dynamodb describe-table --table-name "$table" --query 'Table.GlobalSecondaryIndexes[?IndexName==`gsi_site_updated`].IndexName' --output text 2>/dev/null || true)
+    if [[ -z "$gsi" || "$gsi" == "None" ]]; then
+      echo "Adding GSI gsi_site_updated to $table ..."
+      aws dynamodb update-table \
+        --table-name "$table" \
+        --attribute-definitions AttributeName=site,AttributeType=S AttributeName=updatedon,AttributeType=N \
+        --global-secondary-index-updates ' [{
+          "Create": {
+            "IndexName": "gsi_site_updated",
+            "KeySchema": [
+              {"AttributeName": "site", "KeyType": "HASH"},
+              {"AttributeName": "updatedon", "KeyType": "RANGE"}
+            ],
+            "Projection": {"ProjectionType": "ALL"}
+          }
+        } ]' >/dev/null
+      echo "Waiting for GSI to be active ..."
+      aws dynamodb wait table-exists --table-name "$table"
+    fi
   else
     echo "Creating DynamoDB table $table ..."
     aws dynamodb create-table \
@@ -212,10 +554,19 @@ ensure_prediction_tracker_table() {
       --attribute-definitions \
         AttributeName=site,AttributeType=S \
         AttributeName=status,AttributeType=S \
+        AttributeName=updatedon,AttributeType=N \
       --key-schema \
         AttributeName=site,KeyType=HASH \
         AttributeName=status,KeyType=RANGE \
-      --billing-mode PAY_PER_REQUEST >/dev/null
+      --billing-mode PAY_PER_REQUEST \
+      --global-secondary-indexes '[{
+        "IndexName": "gsi_site_updated",
+        "KeySchema": [
+          {"AttributeName": "site", "KeyType": "HASH"},
+          {"AttributeName": "updatedon", "KeyType": "RANGE"}
+        ],
+        "Projection": {"ProjectionType": "ALL"}
+      }]' >/dev/null
     echo "Waiting for DynamoDB table to be active ..."
     aws dynamodb wait table-exists --table-name "$table"
   fi
@@ -224,7 +575,8 @@ ensure_prediction_tracker_table() {
 # -------------------- DynamoDB: Alert Tracker --------------------
 
 ensure_alert_tracker_table() {
-  local table="alert-tracker"
+  local table
+  table="$(res alert-tracker)"
   if aws dynamodb describe-table --table-name "$table" >/dev/null 2>&1; then
     echo "DynamoDB table $table already exists."
     # Ensure GSI for recent alerts exists (gsi_recent on gsi_pk(createdon))
@@ -273,7 +625,8 @@ ensure_alert_tracker_table() {
 # -------------------- DynamoDB: Train Model Tracker --------------------
 
 ensure_train_model_tracker_table() {
-  local table="train-model-tracker"
+  local table
+  table="$(res train-model-tracker)"
   if aws dynamodb describe-table --table-name "$table" >/dev/null 2>&1; then
     echo "DynamoDB table $table already exists."
     # Ensure GSI for recent training jobs exists (gsi_recent on gsi_pk(createdon))
@@ -320,8 +673,130 @@ ensure_train_model_tracker_table() {
   fi
 }
 
+# -------------------- DynamoDB: Keyed tables --------------------
+
+# ensure_keyed_table <table> <hash-attr> <hash-type> [<range-attr> <range-type>]
+# Creates a PAY_PER_REQUEST table without secondary indexes if missing.
+# Table arguments here and below are default names, decorated by res.
+ensure_keyed_table() {
+  local table hash="$2" hash_type="$3" range="${4:-}" range_type="${5:-}"
+  table="$(res "$1")"
+  if aws dynamodb describe-table --table-name "$table" >/dev/null 2>&1; then
+    echo "DynamoDB table $table already exists."
+    return
+  fi
+  echo "Creating DynamoDB table $table ..."
+  if [[ -n "$range" ]]; then
+    aws dynamodb create-table \
+      --table-name "$table" \
+      --attribute-definitions \
+        AttributeName="$hash",AttributeType="$hash_type" AttributeName="$range",AttributeType="$range_type" \
+      --key-schema \
+        AttributeName="$hash",KeyType=HASH \
+        AttributeName="$range",KeyType=RANGE \
+      --billing-mode PAY_PER_REQUEST >/dev/null
+  else
+    aws dynamodb create-table \
+      --table-name "$table" \
+      --attribute-definitions AttributeName="$hash",AttributeType="$hash_type" \
+      --key-schema AttributeName="$hash",KeyType=HASH \
+      --billing-mode PAY_PER_REQUEST >/dev/null
+  fi
+  echo "Waiting for DynamoDB table to be active ..."
+  aws dynamodb wait table-exists --table-name "$table"
+}
+
+# ensure_gsi <table> <index> <hash-attr> <hash-type> <range-attr> <range-type>
+# Adds an ALL-projection global secondary index to an existing table if missing.
+ensure_gsi() {
+  local table index="$2" hash="$3" hash_type="$4" range="$5" range_type="$6"
+  table="$(res "$1")"
+  local gsi
+  gsi=$(aws dynamodb describe-table --table-name "$table" --query "Table.GlobalSecondaryIndexes[?IndexName=='$index'].IndexName" --output text 2>/dev/null || true)
+  if [[ -n "$gsi" && "$gsi" != "None" ]]; then
+    echo "GSI $index already exists on $table."
+    return
+  fi
+  echo "Adding GSI $index to $table ..."
+  aws dynamodb update-table \
+    --table-name "$table" \
+    --attribute-definitions AttributeName="$hash",AttributeType="$hash_type" AttributeName="$range",AttributeType="$range_type" \
+    --global-secondary-index-updates "[{
+      \"Create\": {
+        \"IndexName\": \"$index\",
+        \"KeySchema\": [
+          {\"AttributeName\": \"$hash\", \"KeyType\": \"HASH\"},
+          {\"AttributeName\": \"$range\", \"KeyType\": \"RANGE\"}
+        ],
+        \"Projection\": {\"ProjectionType\": \"ALL\"}
+      }
+    }]" >/dev/null
+  echo "Waiting for GSI to be active ..."
+  aws dynamodb wait table-exists --table-name "$table"
+}
+
+# -------------------- DynamoDB: Audit Log --------------------
+
+ensure_audit_log_table() {
+  local table
+  table="$(res audit-log)"
+  if aws dynamodb describe-table --table-name "$table" >/dev/null 2>&1; then
+    echo "DynamoDB table $table already exists."
+  else
+    echo "Creating DynamoDB table $table ..."
+    aws dynamodb create-table \
+      --table-name "$table" \
+      --attribute-definitions \
+        AttributeName=id,AttributeType=S AttributeName=createdon,AttributeType=N AttributeName=gsi_pk,AttributeType=S \
+      --key-schema \
+        AttributeName=id,KeyType=HASH \
+      --billing-mode PAY_PER_REQUEST \
+      --global-secondary-indexes '[{
+        "IndexName": "gsi_recent",
+        "KeySchema": [
+          {"AttributeName": "gsi_pk", "KeyType": "HASH"},
+          {"AttributeName": "createdon", "KeyType": "RANGE"}
+        ],
+        "Projection": {"ProjectionType": "ALL"}
+      }]' >/dev/null
+    echo "Waiting for DynamoDB table to be active ..."
+    aws dynamodb wait table-exists --table-name "$table"
+  fi
+}
+
+# -------------------- DynamoDB: TTL --------------------
+
+# Enables TTL on the expires_at attribute (epoch seconds) written by the app.
+ensure_ttl() {
+  local table status
+  table="$(res "$1")"
+  status=$(aws dynamodb describe-time-to-live --table-name "$table" --query 'TimeToLiveDescription.TimeToLiveStatus' --output text 2>/dev/null || true)
+  if [[ "$status" == "ENABLED" || "$status" == "ENABLING" ]]; then
+    echo "TTL already enabled on $table."
+  else
+    echo "Enabling TTL (expires_at) on $table ..."
+    aws dynamodb update-time-to-live \
+      --table-name "$table" \
+      --time-to-live-specification "Enabled=true,AttributeName=expires_at" >/dev/null
+  fi
+}
+
 # -------------------- SNS --------------------
 
+# -------------------- Glue --------------------
+
+ensure_glue_database() {
+  if [[ "$GLUE_REGISTRATION_ENABLED" != "true" ]]; then
+    return
+  fi
+  if aws glue get-database --name "$GLUE_DATABASE" >/dev/null 2>&1; then
+    echo "Glue database $GLUE_DATABASE already exists."
+  else
+    echo "Creating Glue database $GLUE_DATABASE ..."
+    aws glue create-database --database-input "{\"Name\":\"$GLUE_DATABASE\"}" >/dev/null
+  fi
+}
+
 ensure_sns_topic() {
   local name="$SNS_TOPIC_NAME"
   echo "Ensuring SNS topic '$name' exists ..."
@@ -345,30 +820,121 @@ main() {
   build_zip "lambdas/preprocess" "$BUILD_ROOT/preprocess"
   build_zip "lambdas/infer" "$BUILD_ROOT/infer"
   build_zip "lambdas/train_model_tracker" "$BUILD_ROOT/train_model_tracker"
+  build_zip "lambdas/train" "$BUILD_ROOT/train"
+  build_zip "lambdas/model_cleanup" "$BUILD_ROOT/model_cleanup"
+  build_zip "lambdas/tracker_archiver" "$BUILD_ROOT/tracker_archiver"
+  build_zip "lambdas/tracker_export" "$BUILD_ROOT/tracker_export"
+  build_zip "lambdas/scheduled_ingest" "$BUILD_ROOT/scheduled_ingest"
+  build_zip "lambdas/pipeline_failures" "$BUILD_ROOT/pipeline_failures"
+  build_zip "lambdas/site_worker" "$BUILD_ROOT/site_worker"
+  build_zip "lambdas/metrics_rollup" "$BUILD_ROOT/metrics_rollup"
 
   # Upsert functions
   upsert_lambda "$PREPROCESS_FN" "$BUILD_ROOT/preprocess/package.zip" "$ROLE_ARN"
   upsert_lambda "$INFER_FN"      "$BUILD_ROOT/infer/package.zip"      "$ROLE_ARN"
   upsert_lambda "$TRAIN_TRACKER_FN" "$BUILD_ROOT/train_model_tracker/package.zip" "$ROLE_ARN"
+  upsert_lambda "$TRAIN_FN" "$BUILD_ROOT/train/package.zip" "$ROLE_ARN"
+  upsert_lambda "$MODEL_CLEANUP_FN" "$BUILD_ROOT/model_cleanup/package.zip" "$ROLE_ARN"
+  upsert_lambda "$ARCHIVER_FN" "$BUILD_ROOT/tracker_archiver/package.zip" "$ROLE_ARN"
+  upsert_lambda "$EXPORT_FN" "$BUILD_ROOT/tracker_export/package.zip" "$ROLE_ARN"
+  upsert_lambda "$SCHEDULED_INGEST_FN" "$BUILD_ROOT/scheduled_ingest/package.zip" "$ROLE_ARN"
+  upsert_lambda "$PIPELINE_FAILURES_FN" "$BUILD_ROOT/pipeline_failures/package.zip" "$ROLE_ARN"
+  upsert_lambda "$SITE_WORKER_FN" "$BUILD_ROOT/site_worker/package.zip" "$ROLE_ARN"
+  upsert_lambda "$METRICS_ROLLUP_FN" "$BUILD_ROOT/metrics_rollup/package.zip" "$ROLE_ARN"
+  ensure_schedule_invoke_permission
 
   # Environment variables
   sleep 10
   set_env "$INFER_FN" "SAGEMAKER_ENDPOINT=$SAGEMAKER_ENDPOINT,S3_BUCKET=$S3_BUCKET"
-
-  # Create or update Step Functions state machine
-  upsert_state_machine
+  set_env "$ARCHIVER_FN" "S3_BUCKET=$S3_BUCKET"
+  set_env "$MODEL_CLEANUP_FN" "S3_BUCKET=$S3_BUCKET,MODEL_KEEP_PER_SITE=${MODEL_KEEP_PER_SITE:-3}"
+  set_env "$TRAIN_FN" "TRAINING_ROLE_ARN=$TRAINING_ROLE_ARN,TRAINING_INSTANCE_TYPE=$TRAINING_INSTANCE_TYPE,TRAINING_SPOT=$TRAINING_SPOT"
+  set_env "$PREPROCESS_FN" "GLUE_REGISTRATION_ENABLED=$GLUE_REGISTRATION_ENABLED,GLUE_DATABASE=$GLUE_DATABASE,INGEST_FALLBACK_POLICY=$INGEST_FALLBACK_POLICY,WATER_DATA_PROVIDER=$WATER_DATA_PROVIDER,STREAM_EVALUATION_ENABLED=$STREAM_EVALUATION_ENABLED,SNS_TOPIC_NAME=$SNS_TOPIC_NAME,CORRELATION_WINDOW_MINUTES=$CORRELATION_WINDOW_MINUTES"
+  set_env "$EXPORT_FN" "S3_BUCKET=$S3_BUCKET,GLUE_REGISTRATION_ENABLED=$GLUE_REGISTRATION_ENABLED,GLUE_DATABASE=$GLUE_DATABASE"
+  set_env "$SCHEDULED_INGEST_FN" "S3_BUCKET=$S3_BUCKET,STATE_MACHINE_ARN=arn:aws:states:${AWS_REGION}:${ACCOUNT_ID}:stateMachine:${STATE_MACHINE_NAME}"
+  set_env "$PIPELINE_FAILURES_FN" "OPERATOR_SNS_TOPIC_NAME=$OPERATOR_SNS_TOPIC_NAME"
+  set_env "$TRAIN_TRACKER_FN" ""
+  ensure_lambda_tracing
+
+  # Dead-letter queue for async invocations (EventBridge-triggered lambdas)
+  local DLQ_ARN
+  DLQ_ARN="$(ensure_lambda_dlq)"
+  set_dead_letter_queue "$DLQ_ARN" "$SCHEDULED_INGEST_FN" "$ARCHIVER_FN" "$EXPORT_FN" "$MODEL_CLEANUP_FN" "$METRICS_ROLLUP_FN"
+  ensure_failure_routing "$DLQ_ARN"
+  ensure_metrics_rollup_schedule
+  ensure_pipeline_callback
+
+  # Site task queue and worker
+  local SITE_TASK_QUEUE_URL
+  SITE_TASK_QUEUE_URL="$(ensure_site_task_queue "$DLQ_ARN")"
+  set_env "$SITE_WORKER_FN" "S3_BUCKET=$S3_BUCKET,SAGEMAKER_ENDPOINT=$SAGEMAKER_ENDPOINT,SNS_TOPIC_NAME=$SNS_TOPIC_NAME,SITE_WORKER_CONCURRENCY=$SITE_WORKER_CONCURRENCY,SWEEP_SHARD_CONCURRENCY=$SWEEP_SHARD_CONCURRENCY,WATER_DATA_PROVIDER=$WATER_DATA_PROVIDER,CORRELATION_WINDOW_MINUTES=$CORRELATION_WINDOW_MINUTES,STATE_MACHINE_ARN=arn:aws:states:${AWS_REGION}:${ACCOUNT_ID}:stateMachine:${STATE_MACHINE_NAME}"
+  ensure_site_worker_mapping "$SITE_TASK_QUEUE_URL"
+  echo "Site task queue: $SITE_TASK_QUEUE_URL (set SITE_TASK_QUEUE_URL on the API server)"
+
+  # Create or update the Step Functions state machines
+  upsert_state_machine "$STATE_MACHINE_NAME" "$REPO_ROOT/infra/state_machine/aquawatch.json"
+  upsert_state_machine "$EXPRESS_STATE_MACHINE_NAME" "$REPO_ROOT/infra/state_machine/aquawatch_express.json" EXPRESS
 
   # Ensure DynamoDB table exists
   ensure_prediction_tracker_table
   ensure_alert_tracker_table
+  ensure_gsi "alert-tracker" gsi_day alert_day S createdon N
   ensure_train_model_tracker_table
+  ensure_keyed_table "alert-site-index" site S createdon N
+  ensure_keyed_table "alert-subscribers" email S
+  ensure_keyed_table "alert-timeline" alert_createdon N seq N
+  ensure_keyed_table "predictions" dataset S row N
+  ensure_keyed_table "anomaly-evaluations" site S evaluatedon N
+  ensure_keyed_table "refresh-tokens" jti S
+  ensure_keyed_table "scoped-tokens" jti S
+  ensure_keyed_table "sms-rate-limits" limit_key S
+  ensure_keyed_table "users" user_id S
+  ensure_keyed_table "user-subjects" subject S
+  ensure_keyed_table "pipeline-runs" execution_arn S
+  ensure_gsi "pipeline-runs" gsi_started gsi_pk S startedon N
+  ensure_keyed_table "pipeline-events" execution_arn S seq N
+  ensure_keyed_table "schedules" schedule_id S
+  ensure_keyed_table "backfills" backfill_id S
+  ensure_keyed_table "sweeps" sweep_id S
+  ensure_keyed_table "station-stats" site S parameter S
+  ensure_keyed_table "metrics-rollups" site S day S
+  ensure_keyed_table "api-usage" month S key S
+  ensure_keyed_table "site-impacts" site S impact_id S
+  ensure_keyed_table "basins" basin_id S
+  ensure_keyed_table "watchlist" watchlist S site S
+  ensure_keyed_table "watchlist-webhooks" watchlist S
+  ensure_keyed_table "webhook-deliveries" watchlist S seq N
+  ensure_keyed_table "station-imports" import_id S
+  ensure_keyed_table "datasets" dataset_id S
+  ensure_keyed_table "correlated-events" group S createdon N
+  ensure_gsi "correlated-events" gsi_recent gsi_pk S createdon N
+  ensure_keyed_table "pipeline-errors" error_id S
+  ensure_audit_log_table
+  ensure_ttl "prediction-tracker"
+  ensure_ttl "alert-tracker"
+  ensure_ttl "alert-site-index"
+  ensure_ttl "alert-timeline"
+  ensure_ttl "webhook-deliveries"
+  ensure_ttl "sweeps"
+  ensure_ttl "predictions"
+  ensure_ttl "anomaly-evaluations"
+  ensure_ttl "metrics-rollups"
+  ensure_ttl "train-model-tracker"
+  ensure_ttl "refresh-tokens"
+  ensure_ttl "scoped-tokens"
+  ensure_ttl "sms-rate-limits"
+  ensure_ttl "pipeline-runs"
+  ensure_ttl "pipeline-events"
+  ensure_ttl "pipeline-errors"
+
+  ensure_glue_database
 
   # Ensure SNS topic exists and report ARN
   local SNS_TOPIC_ARN
   SNS_TOPIC_ARN="$(ensure_sns_topic)"
   echo "SNS topic: $SNS_TOPIC_NAME ($SNS_TOPIC_ARN)"
 
-  echo "Deployment complete. Functions: $PREPROCESS_FN, $INFER_FN, $TRAIN_TRACKER_FN. State Machine: $STATE_MACHINE_NAME"
+  echo "Deployment complete. Functions: $PREPROCESS_FN, $INFER_FN, $TRAIN_FN, $TRAIN_TRACKER_FN, $MODEL_CLEANUP_FN, $ARCHIVER_FN, $EXPORT_FN, $SCHEDULED_INGEST_FN, $PIPELINE_FAILURES_FN, $SITE_WORKER_FN, $METRICS_ROLLUP_FN. State Machines: $STATE_MACHINE_NAME, $EXPRESS_STATE_MACHINE_NAME"
 }
 
 main "$@"